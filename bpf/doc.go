@@ -49,6 +49,14 @@ to extensions, which are essentially calls to kernel utility
 functions. Currently, the only extensions supported by this package
 are the Linux packet filter extensions.
 
+# Extended BPF
+
+Modern Linux kernels run socket filters in the extended BPF (eBPF)
+virtual machine, translating classic programs on attachment.
+TranslateExtended performs that translation explicitly, and
+LoadExtended and ExtendedProgram.Attach load and attach the resulting
+eBPF program. The VM remains the way to test classic programs.
+
 # Examples
 
 This packet filter selects all ARP packets.
@@ -0,0 +1,458 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"syscall"
+)
+
+// An ExtendedInstruction is an instruction of the extended BPF (eBPF)
+// virtual machine used by the Linux kernel, encoded as on
+// little-endian systems.
+//
+// Most programs are written for the classic BPF virtual machine
+// implemented by VM, and translated with TranslateExtended.
+type ExtendedInstruction struct {
+	Op   uint8 // operation code
+	Regs uint8 // destination register in the low 4 bits, source register in the high 4 bits
+	Off  int16 // signed offset
+	Imm  int32 // signed immediate constant
+}
+
+// Dst returns the destination register of the instruction.
+func (ei ExtendedInstruction) Dst() uint8 { return ei.Regs & 0x0f }
+
+// Src returns the source register of the instruction.
+func (ei ExtendedInstruction) Src() uint8 { return ei.Regs >> 4 }
+
+// String returns the instruction's encoding in hexadecimal notation.
+func (ei ExtendedInstruction) String() string {
+	return fmt.Sprintf("op=%#02x dst=r%d src=r%d off=%d imm=%d", ei.Op, ei.Dst(), ei.Src(), ei.Off, ei.Imm)
+}
+
+// Extended instruction classes, sizes, modes and operations.
+// The arithmetic and jump operations share their encoding with the
+// classic instruction set.
+const (
+	extClassLD    = 0x00
+	extClassLDX   = 0x01
+	extClassST    = 0x02
+	extClassSTX   = 0x03
+	extClassALU   = 0x04
+	extClassJMP   = 0x05
+	extClassALU64 = 0x07
+
+	extSizeW = 0x00
+	extSizeH = 0x08
+	extSizeB = 0x10
+
+	extModeABS = 0x20
+	extModeIND = 0x40
+	extModeMEM = 0x60
+
+	extSrcK = 0x00
+	extSrcX = 0x08
+
+	extOpNeg = 0x80
+	extOpMov = 0xb0
+
+	extJmpJA   = 0x00
+	extJmpJEQ  = 0x10
+	extJmpJGT  = 0x20
+	extJmpJGE  = 0x30
+	extJmpJSET = 0x40
+	extJmpJNE  = 0x50
+	extJmpEXIT = 0x90
+	extJmpJLT  = 0xa0
+	extJmpJLE  = 0xb0
+)
+
+// Registers used by translated programs, following the conventions
+// of the Linux kernel's own classic BPF translation.
+const (
+	extRegA   = 0  // classic register A, and the return value
+	extRegCtx = 1  // the packet context, on entry
+	extRegTmp = 2  // a temporary register, clobbered by packet loads
+	extRegPkt = 6  // the packet context, preserved across packet loads
+	extRegX   = 7  // classic register X
+	extRegSav = 8  // a temporary register, preserved across packet loads
+	extRegFP  = 10 // the read-only frame pointer
+)
+
+// TranslateExtended translates a classic BPF program into an
+// equivalent extended BPF program, suitable for loading as a Linux
+// socket filter.
+//
+// Scratch memory is held on the eBPF stack, and of the extensions,
+// only ExtLen is supported. Unreachable instructions, which the
+// kernel's verifier rejects, are omitted from the translation.
+func TranslateExtended(filter []Instruction) ([]ExtendedInstruction, error) {
+	if len(filter) == 0 {
+		return nil, errors.New("one or more Instructions must be specified")
+	}
+	t := &extTranslator{starts: make([]int, len(filter))}
+
+	t.emit(extClassALU64|extOpMov|extSrcX, extRegPkt, extRegCtx, 0, 0)
+	t.emit(extClassALU|extOpMov|extSrcK, extRegA, 0, 0, 0)
+	t.emit(extClassALU|extOpMov|extSrcK, extRegX, 0, 0, 0)
+	// The verifier rejects reads of uninitialized stack slots, so
+	// zero the scratch slots which are loaded, as the classic VM does.
+	var loaded [16]bool
+	for _, ins := range filter {
+		if ins, ok := disassembleExtended(ins).(LoadScratch); ok && ins.N >= 0 && ins.N < len(loaded) {
+			loaded[ins.N] = true
+		}
+	}
+	for n, ok := range loaded {
+		if ok {
+			t.emit(extClassST|extModeMEM|extSizeW, extRegFP, 0, extScratchOff(n), 0)
+		}
+	}
+
+	// The verifier also rejects unreachable code, which classic
+	// programs may contain, so only reachable instructions are
+	// translated.
+	reachable := make([]bool, len(filter)+1)
+	reachable[0] = true
+	for i, ins := range filter {
+		t.starts[i] = len(t.insns)
+		if !reachable[i] {
+			continue
+		}
+		ins = disassembleExtended(ins)
+		for _, next := range classicSuccessors(i, ins) {
+			if next < len(reachable) {
+				reachable[next] = true
+			}
+		}
+		if err := t.translate(i, ins); err != nil {
+			return nil, fmt.Errorf("instruction %d (%v): %w", i, ins, err)
+		}
+	}
+	for _, f := range t.fixups {
+		if f.target >= len(filter) {
+			return nil, errors.New("jump past program bounds")
+		}
+		off := t.starts[f.target] - (f.at + 1)
+		if off > math.MaxInt16 {
+			return nil, errors.New("translated jump too long")
+		}
+		t.insns[f.at].Off = int16(off)
+	}
+	return t.insns, nil
+}
+
+// classicSuccessors returns the indexes of the instructions which may
+// run after instruction i.
+func classicSuccessors(i int, ins Instruction) []int {
+	switch ins := ins.(type) {
+	case RetA, RetConstant:
+		return nil
+	case Jump:
+		return []int{i + 1 + int(ins.Skip)}
+	case JumpIf:
+		return []int{i + 1 + int(ins.SkipTrue), i + 1 + int(ins.SkipFalse)}
+	case JumpIfX:
+		return []int{i + 1 + int(ins.SkipTrue), i + 1 + int(ins.SkipFalse)}
+	}
+	return []int{i + 1}
+}
+
+// disassembleExtended returns the typed form of a raw instruction.
+func disassembleExtended(ins Instruction) Instruction {
+	if ri, ok := ins.(RawInstruction); ok {
+		return ri.Disassemble()
+	}
+	return ins
+}
+
+// extScratchOff returns the stack offset of scratch slot n.
+func extScratchOff(n int) int16 {
+	return int16(-4 * (16 - n))
+}
+
+type extTranslator struct {
+	insns  []ExtendedInstruction
+	starts []int      // index of the first translation of each classic instruction
+	fixups []extFixup // jumps to classic instructions
+}
+
+type extFixup struct {
+	at     int // index of the jump instruction
+	target int // index of the classic instruction jumped to
+}
+
+func (t *extTranslator) emit(op, dst, src uint8, off int16, imm int32) {
+	t.insns = append(t.insns, ExtendedInstruction{
+		Op:   op,
+		Regs: src<<4 | dst,
+		Off:  off,
+		Imm:  imm,
+	})
+}
+
+// emitJump emits a jump to classic instruction target.
+func (t *extTranslator) emitJump(op, dst, src uint8, imm int32, target int) {
+	t.fixups = append(t.fixups, extFixup{at: len(t.insns), target: target})
+	t.emit(op, dst, src, 0, imm)
+}
+
+func extReg(r Register) (uint8, error) {
+	switch r {
+	case RegA:
+		return extRegA, nil
+	case RegX:
+		return extRegX, nil
+	}
+	return 0, fmt.Errorf("invalid register %v", r)
+}
+
+func extSize(size int) (uint8, error) {
+	switch size {
+	case 1:
+		return extSizeB, nil
+	case 2:
+		return extSizeH, nil
+	case 4:
+		return extSizeW, nil
+	}
+	return 0, fmt.Errorf("invalid load byte length %d", size)
+}
+
+func (t *extTranslator) translate(i int, ins Instruction) error {
+	switch ins := ins.(type) {
+	case LoadConstant:
+		dst, err := extReg(ins.Dst)
+		if err != nil {
+			return err
+		}
+		t.emit(extClassALU|extOpMov|extSrcK, dst, 0, 0, int32(ins.Val))
+	case LoadScratch:
+		dst, err := extReg(ins.Dst)
+		if err != nil {
+			return err
+		}
+		if ins.N < 0 || ins.N > 15 {
+			return fmt.Errorf("invalid scratch slot %d", ins.N)
+		}
+		t.emit(extClassLDX|extModeMEM|extSizeW, dst, extRegFP, extScratchOff(ins.N), 0)
+	case StoreScratch:
+		src, err := extReg(ins.Src)
+		if err != nil {
+			return err
+		}
+		if ins.N < 0 || ins.N > 15 {
+			return fmt.Errorf("invalid scratch slot %d", ins.N)
+		}
+		t.emit(extClassSTX|extModeMEM|extSizeW, extRegFP, src, extScratchOff(ins.N), 0)
+	case LoadAbsolute:
+		size, err := extSize(ins.Size)
+		if err != nil {
+			return err
+		}
+		if ins.Off > math.MaxInt32 {
+			return errors.New("packet offset out of range")
+		}
+		t.emit(extClassLD|extModeABS|size, 0, 0, 0, int32(ins.Off))
+	case LoadIndirect:
+		size, err := extSize(ins.Size)
+		if err != nil {
+			return err
+		}
+		if ins.Off > math.MaxInt32 {
+			return errors.New("packet offset out of range")
+		}
+		t.emit(extClassLD|extModeIND|size, 0, extRegX, 0, int32(ins.Off))
+	case LoadMemShift:
+		if ins.Off > math.MaxInt32 {
+			return errors.New("packet offset out of range")
+		}
+		// Packet loads write A, so preserve it.
+		t.emit(extClassALU64|extOpMov|extSrcX, extRegSav, extRegA, 0, 0)
+		t.emit(extClassLD|extModeABS|extSizeB, 0, 0, 0, int32(ins.Off))
+		t.emit(extClassALU|uint8(ALUOpAnd)|extSrcK, extRegA, 0, 0, 0xf)
+		t.emit(extClassALU|uint8(ALUOpShiftLeft)|extSrcK, extRegA, 0, 0, 2)
+		t.emit(extClassALU|extOpMov|extSrcX, extRegX, extRegA, 0, 0)
+		t.emit(extClassALU64|extOpMov|extSrcX, extRegA, extRegSav, 0, 0)
+	case LoadExtension:
+		if ins.Num != ExtLen {
+			return fmt.Errorf("extension %d not supported", ins.Num)
+		}
+		// The len field is at the start of struct __sk_buff.
+		t.emit(extClassLDX|extModeMEM|extSizeW, extRegA, extRegPkt, 0, 0)
+	case ALUOpConstant:
+		if (ins.Op == ALUOpDiv || ins.Op == ALUOpMod) && ins.Val == 0 {
+			return errors.New("cannot divide by zero using ALUOpConstant")
+		}
+		if ins.Op > ALUOpXor || ins.Op == aluOpNeg {
+			return fmt.Errorf("invalid ALU operation %v", ins.Op)
+		}
+		t.emit(extClassALU|uint8(ins.Op)|extSrcK, extRegA, 0, 0, int32(ins.Val))
+	case ALUOpX:
+		if ins.Op > ALUOpXor || ins.Op == aluOpNeg {
+			return fmt.Errorf("invalid ALU operation %v", ins.Op)
+		}
+		if ins.Op == ALUOpDiv || ins.Op == ALUOpMod {
+			// Division by zero terminates the program with a
+			// verdict of 0, as in the classic VM.
+			t.emit(extClassJMP|extJmpJNE|extSrcK, extRegX, 0, 2, 0)
+			t.emit(extClassALU|extOpMov|extSrcK, extRegA, 0, 0, 0)
+			t.emit(extClassJMP|extJmpEXIT, 0, 0, 0, 0)
+		}
+		t.emit(extClassALU|uint8(ins.Op)|extSrcX, extRegA, extRegX, 0, 0)
+	case NegateA:
+		t.emit(extClassALU|extOpNeg, extRegA, 0, 0, 0)
+	case Jump:
+		t.emitJump(extClassJMP|extJmpJA, 0, 0, 0, i+1+int(ins.Skip))
+	case JumpIf:
+		op, swap, err := extJumpOp(ins.Cond)
+		if err != nil {
+			return err
+		}
+		if int32(ins.Val) < 0 && op != extJmpJSET {
+			// Immediates are sign extended to 64 bits, so compare
+			// with a register holding the zero extended value.
+			t.emit(extClassALU|extOpMov|extSrcK, extRegTmp, 0, 0, int32(ins.Val))
+			t.translateCondJump(i, op|extSrcX, extRegTmp, 0, ins.SkipTrue, ins.SkipFalse, swap)
+			break
+		}
+		t.translateCondJump(i, op|extSrcK, 0, int32(ins.Val), ins.SkipTrue, ins.SkipFalse, swap)
+	case JumpIfX:
+		op, swap, err := extJumpOp(ins.Cond)
+		if err != nil {
+			return err
+		}
+		t.translateCondJump(i, op|extSrcX, extRegX, 0, ins.SkipTrue, ins.SkipFalse, swap)
+	case RetA:
+		t.emit(extClassJMP|extJmpEXIT, 0, 0, 0, 0)
+	case RetConstant:
+		t.emit(extClassALU|extOpMov|extSrcK, extRegA, 0, 0, int32(ins.Val))
+		t.emit(extClassJMP|extJmpEXIT, 0, 0, 0, 0)
+	case TAX:
+		t.emit(extClassALU|extOpMov|extSrcX, extRegX, extRegA, 0, 0)
+	case TXA:
+		t.emit(extClassALU|extOpMov|extSrcX, extRegA, extRegX, 0, 0)
+	default:
+		return errors.New("unsupported instruction")
+	}
+	return nil
+}
+
+// translateCondJump emits a conditional jump, op comparing A with imm
+// or with register src, followed if needed by a jump for the false
+// case. If swap is set, the jump targets are swapped.
+func (t *extTranslator) translateCondJump(i int, op, src uint8, imm int32, skipTrue, skipFalse uint8, swap bool) {
+	if swap {
+		skipTrue, skipFalse = skipFalse, skipTrue
+	}
+	trueTarget, falseTarget := i+1+int(skipTrue), i+1+int(skipFalse)
+	if trueTarget == falseTarget {
+		t.emitJump(extClassJMP|extJmpJA, 0, 0, 0, trueTarget)
+		return
+	}
+	t.emitJump(extClassJMP|op, extRegA, src, imm, trueTarget)
+	if falseTarget != i+1 {
+		t.emitJump(extClassJMP|extJmpJA, 0, 0, 0, falseTarget)
+	}
+}
+
+// extJumpOp returns the eBPF jump operation for a classic jump test,
+// and whether its targets must be swapped.
+func extJumpOp(cond JumpTest) (op uint8, swap bool, err error) {
+	switch cond {
+	case JumpEqual:
+		return extJmpJEQ, false, nil
+	case JumpNotEqual:
+		return extJmpJNE, false, nil
+	case JumpGreaterThan:
+		return extJmpJGT, false, nil
+	case JumpLessThan:
+		return extJmpJLT, false, nil
+	case JumpGreaterOrEqual:
+		return extJmpJGE, false, nil
+	case JumpLessOrEqual:
+		return extJmpJLE, false, nil
+	case JumpBitsSet:
+		return extJmpJSET, false, nil
+	case JumpBitsNotSet:
+		return extJmpJSET, true, nil
+	}
+	return 0, false, fmt.Errorf("unknown JumpTest %v", cond)
+}
+
+// An ExtendedProgram is an extended BPF socket filter program loaded
+// into the kernel.
+type ExtendedProgram struct {
+	fd int
+}
+
+// LoadExtended loads prog into the kernel as a socket filter program.
+// The license string declares the program's license to the kernel;
+// programs using only the packet access of translated classic
+// programs do not need a GPL-compatible license.
+//
+// LoadExtended is only implemented on Linux, and usually requires
+// the CAP_BPF or CAP_SYS_ADMIN capability. If the kernel's verifier
+// rejects the program, the error is a *VerifierError.
+func LoadExtended(prog []ExtendedInstruction, license string) (*ExtendedProgram, error) {
+	if len(prog) == 0 {
+		return nil, errors.New("one or more ExtendedInstructions must be specified")
+	}
+	fd, err := loadExtended(prog, license)
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedProgram{fd: fd}, nil
+}
+
+// FD returns the program's file descriptor.
+func (p *ExtendedProgram) FD() int { return p.fd }
+
+// Close releases the program. Sockets it is attached to keep using it.
+func (p *ExtendedProgram) Close() error {
+	if p.fd < 0 {
+		return nil
+	}
+	err := closeExtended(p.fd)
+	p.fd = -1
+	return err
+}
+
+// Attach attaches the program to the socket of c as its packet
+// filter, replacing any filter already attached, classic or extended.
+// It returns net.ErrClosed if p has been closed.
+func (p *ExtendedProgram) Attach(c syscall.Conn) error {
+	if p.fd < 0 {
+		return net.ErrClosed
+	}
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var aerr error
+	if err := rc.Control(func(fd uintptr) {
+		aerr = attachExtended(fd, p.fd)
+	}); err != nil {
+		return err
+	}
+	return aerr
+}
+
+// A VerifierError reports that the kernel's verifier rejected a
+// program.
+type VerifierError struct {
+	Err error  // error returned by the kernel
+	Log string // verifier log
+}
+
+func (e *VerifierError) Error() string {
+	return "bpf: program rejected by verifier: " + e.Err.Error() + "\n" + e.Log
+}
+
+func (e *VerifierError) Unwrap() error { return e.Err }
@@ -0,0 +1,108 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bpf
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// progLoadAttr is the leading part of union bpf_attr used by the
+// BPF_PROG_LOAD command.
+type progLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+var nativeBigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+// verifierLogSize is the size of the buffer receiving the verifier's
+// log when a program is rejected.
+const verifierLogSize = 64 << 10
+
+func loadExtended(prog []ExtendedInstruction, license string) (int, error) {
+	if nativeBigEndian {
+		// The kernel's register bitfields hold the destination
+		// register in the high 4 bits on big-endian systems.
+		p := make([]ExtendedInstruction, len(prog))
+		for i, ins := range prog {
+			ins.Regs = ins.Regs<<4 | ins.Regs>>4
+			p[i] = ins
+		}
+		prog = p
+	}
+	lic := append([]byte(license), 0)
+	attr := progLoadAttr{
+		progType: unix.BPF_PROG_TYPE_SOCKET_FILTER,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&lic[0]))),
+	}
+	fd, err := bpfProgLoad(&attr)
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(lic)
+	if err == nil {
+		return fd, nil
+	}
+	if err != unix.EACCES && err != unix.EINVAL {
+		return -1, err
+	}
+	// Load the program again to report why the verifier rejected it.
+	log := make([]byte, verifierLogSize)
+	attr.logLevel = 1
+	attr.logSize = uint32(len(log))
+	attr.logBuf = uint64(uintptr(unsafe.Pointer(&log[0])))
+	fd, lerr := bpfProgLoad(&attr)
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(lic)
+	runtime.KeepAlive(log)
+	if lerr == nil {
+		return fd, nil
+	}
+	if n := clen(log); n > 0 {
+		return -1, &VerifierError{Err: err, Log: string(log[:n])}
+	}
+	return -1, err
+}
+
+func bpfProgLoad(attr *progLoadAttr) (int, error) {
+	r, _, e := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(attr)), unsafe.Sizeof(*attr))
+	runtime.KeepAlive(attr)
+	if e != 0 {
+		return -1, e
+	}
+	unix.CloseOnExec(int(r))
+	return int(r), nil
+}
+
+// clen returns the index of the first NUL byte in b, or len(b).
+func clen(b []byte) int {
+	for i := range b {
+		if b[i] == 0 {
+			return i
+		}
+	}
+	return len(b)
+}
+
+func closeExtended(fd int) error {
+	return unix.Close(fd)
+}
+
+func attachExtended(fd uintptr, progFD int) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_BPF, progFD)
+}
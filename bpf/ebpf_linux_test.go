@@ -0,0 +1,100 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bpf_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/bpf"
+)
+
+func loadExtendedOrSkip(t *testing.T, filter []bpf.Instruction) *bpf.ExtendedProgram {
+	t.Helper()
+	prog, err := bpf.TranslateExtended(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := bpf.LoadExtended(prog, "BSD")
+	var verr *bpf.VerifierError
+	if errors.As(err, &verr) {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Skipf("cannot load eBPF programs: %v", err)
+	}
+	return p
+}
+
+func TestLoadExtendedVerifier(t *testing.T) {
+	for _, tt := range extendedTestPrograms {
+		t.Run(tt.name, func(t *testing.T) {
+			p := loadExtendedOrSkip(t, tt.filter)
+			if err := p.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestExtendedProgramAttach(t *testing.T) {
+	drop := loadExtendedOrSkip(t, []bpf.Instruction{bpf.RetConstant{Val: 0}})
+	defer drop.Close()
+	accept := loadExtendedOrSkip(t, []bpf.Instruction{bpf.RetConstant{Val: 0xffff}})
+	defer accept.Close()
+
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	send := func(s string) {
+		t.Helper()
+		if _, err := c.WriteTo([]byte(s), c.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := make([]byte, 16)
+
+	if err := drop.Attach(c); err != nil {
+		t.Fatal(err)
+	}
+	send("dropped")
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := c.ReadFrom(b); err == nil {
+		t.Fatalf("read %q with dropping filter attached", b[:n])
+	}
+
+	if err := accept.Attach(c); err != nil {
+		t.Fatal(err)
+	}
+	send("accepted")
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := c.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b[:n]); got != "accepted" {
+		t.Errorf("read %q with accepting filter attached, want %q", got, "accepted")
+	}
+}
+
+func TestExtendedProgramAttachAfterClose(t *testing.T) {
+	p := loadExtendedOrSkip(t, []bpf.Instruction{bpf.RetConstant{Val: 0}})
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := p.Attach(c); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Attach after Close = %v, want net.ErrClosed", err)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package bpf
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func loadExtended(prog []ExtendedInstruction, license string) (int, error) {
+	return -1, fmt.Errorf("extended BPF not supported on %s", runtime.GOOS)
+}
+
+func closeExtended(fd int) error {
+	return nil
+}
+
+func attachExtended(fd uintptr, progFD int) error {
+	return fmt.Errorf("extended BPF not supported on %s", runtime.GOOS)
+}
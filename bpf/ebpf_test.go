@@ -0,0 +1,343 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bpf_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+// runExtended interprets the subset of eBPF emitted by
+// bpf.TranslateExtended, with the packet as its socket buffer.
+func runExtended(prog []bpf.ExtendedInstruction, pkt []byte) (uint32, error) {
+	const (
+		regCtx = 1
+		regFP  = 10
+	)
+	var (
+		regs  [11]uint64
+		stack [512]byte
+	)
+	regs[regCtx] = 1 // an opaque context pointer
+	// stackOff returns the index in stack of the word at off from FP.
+	stackOff := func(ins bpf.ExtendedInstruction, base uint8) (int, error) {
+		if base != regFP || ins.Off >= 0 || int(ins.Off) < -len(stack) {
+			return 0, fmt.Errorf("invalid memory access %v", ins)
+		}
+		return len(stack) + int(ins.Off), nil
+	}
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		dst, src := ins.Dst(), ins.Src()
+		class := ins.Op & 0x07
+		switch class {
+		case 0x04, 0x07: // ALU, ALU64
+			op := ins.Op & 0xf0
+			val := uint64(int64(ins.Imm))
+			if ins.Op&0x08 != 0 {
+				val = regs[src]
+			}
+			if class == 0x07 {
+				if op != 0xb0 {
+					return 0, fmt.Errorf("unsupported ALU64 operation %v", ins)
+				}
+				regs[dst] = val
+				continue
+			}
+			a, v := uint32(regs[dst]), uint32(val)
+			switch op {
+			case 0x00:
+				a += v
+			case 0x10:
+				a -= v
+			case 0x20:
+				a *= v
+			case 0x30:
+				if v == 0 {
+					return 0, errors.New("division by zero")
+				}
+				a /= v
+			case 0x40:
+				a |= v
+			case 0x50:
+				a &= v
+			case 0x60:
+				a <<= v
+			case 0x70:
+				a >>= v
+			case 0x80:
+				a = -a
+			case 0x90:
+				if v == 0 {
+					return 0, errors.New("division by zero")
+				}
+				a %= v
+			case 0xa0:
+				a ^= v
+			case 0xb0:
+				a = v
+			default:
+				return 0, fmt.Errorf("unsupported ALU operation %v", ins)
+			}
+			regs[dst] = uint64(a)
+		case 0x00: // LD
+			off := uint32(ins.Imm)
+			if ins.Op&0xe0 == 0x40 {
+				off += uint32(regs[src])
+			}
+			var size uint32
+			switch ins.Op & 0x18 {
+			case 0x00:
+				size = 4
+			case 0x08:
+				size = 2
+			case 0x10:
+				size = 1
+			}
+			if uint64(off)+uint64(size) > uint64(len(pkt)) {
+				return 0, nil
+			}
+			b := pkt[off : off+size]
+			switch size {
+			case 4:
+				regs[0] = uint64(binary.BigEndian.Uint32(b))
+			case 2:
+				regs[0] = uint64(binary.BigEndian.Uint16(b))
+			case 1:
+				regs[0] = uint64(b[0])
+			}
+			// Packet loads clobber the argument registers.
+			for r := 1; r <= 5; r++ {
+				regs[r] = 0xdeadbeefdeadbeef
+			}
+		case 0x01: // LDX
+			if regs[src] == 1 && ins.Off == 0 {
+				regs[dst] = uint64(len(pkt))
+				continue
+			}
+			i, err := stackOff(ins, src)
+			if err != nil {
+				return 0, err
+			}
+			regs[dst] = uint64(binary.LittleEndian.Uint32(stack[i:]))
+		case 0x02, 0x03: // ST, STX
+			i, err := stackOff(ins, dst)
+			if err != nil {
+				return 0, err
+			}
+			val := uint32(ins.Imm)
+			if class == 0x03 {
+				val = uint32(regs[src])
+			}
+			binary.LittleEndian.PutUint32(stack[i:], val)
+		case 0x05: // JMP
+			op := ins.Op & 0xf0
+			if op == 0x90 {
+				return uint32(regs[0]), nil
+			}
+			a, v := regs[dst], uint64(int64(ins.Imm))
+			if ins.Op&0x08 != 0 {
+				v = regs[src]
+			}
+			var jump bool
+			switch op {
+			case 0x00:
+				jump = true
+			case 0x10:
+				jump = a == v
+			case 0x20:
+				jump = a > v
+			case 0x30:
+				jump = a >= v
+			case 0x40:
+				jump = a&v != 0
+			case 0x50:
+				jump = a != v
+			case 0xa0:
+				jump = a < v
+			case 0xb0:
+				jump = a <= v
+			default:
+				return 0, fmt.Errorf("unsupported jump operation %v", ins)
+			}
+			if jump {
+				pc += int(ins.Off)
+			}
+		default:
+			return 0, fmt.Errorf("unsupported instruction %v", ins)
+		}
+	}
+	return 0, errors.New("program did not exit")
+}
+
+var extendedTestPrograms = []struct {
+	name   string
+	filter []bpf.Instruction
+}{
+	{
+		name: "arp",
+		filter: []bpf.Instruction{
+			bpf.LoadAbsolute{Off: 12, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x0806, SkipTrue: 1},
+			bpf.RetConstant{Val: 4096},
+			bpf.RetConstant{Val: 0},
+		},
+	},
+	{
+		name: "ipv4 tcp port",
+		filter: []bpf.Instruction{
+			bpf.LoadAbsolute{Off: 9, Size: 1},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: 6, SkipFalse: 5},
+			bpf.LoadMemShift{Off: 0},
+			bpf.LoadIndirect{Off: 2, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: 443, SkipTrue: 1},
+			bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: 0x8000, SkipFalse: 1},
+			bpf.RetA{},
+			bpf.RetConstant{Val: 0},
+		},
+	},
+	{
+		name: "scratch and alu",
+		filter: []bpf.Instruction{
+			bpf.LoadAbsolute{Off: 0, Size: 4},
+			bpf.StoreScratch{Src: bpf.RegA, N: 3},
+			bpf.LoadAbsolute{Off: 4, Size: 1},
+			bpf.TAX{},
+			bpf.LoadScratch{Dst: bpf.RegA, N: 3},
+			bpf.ALUOpX{Op: bpf.ALUOpXor},
+			bpf.ALUOpConstant{Op: bpf.ALUOpMul, Val: 7},
+			bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 3},
+			bpf.ALUOpX{Op: bpf.ALUOpMod},
+			bpf.StoreScratch{Src: bpf.RegX, N: 15},
+			bpf.LoadScratch{Dst: bpf.RegX, N: 0},
+			bpf.ALUOpX{Op: bpf.ALUOpAdd},
+			bpf.LoadScratch{Dst: bpf.RegX, N: 15},
+			bpf.ALUOpX{Op: bpf.ALUOpDiv},
+			bpf.RetA{},
+		},
+	},
+	{
+		name: "length and large constants",
+		filter: []bpf.Instruction{
+			bpf.LoadExtension{Num: bpf.ExtLen},
+			bpf.JumpIf{Cond: bpf.JumpLessThan, Val: 8, SkipTrue: 7},
+			bpf.LoadAbsolute{Off: 0, Size: 4},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xffffffff, SkipTrue: 3},
+			bpf.JumpIf{Cond: bpf.JumpBitsNotSet, Val: 0x80000000, SkipTrue: 3, SkipFalse: 1},
+			bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: 0x90000000, SkipTrue: 1, SkipFalse: 2},
+			bpf.RetConstant{Val: 1},
+			bpf.RetConstant{Val: 2},
+			bpf.RetConstant{Val: 3},
+			bpf.LoadConstant{Dst: bpf.RegX, Val: 0xfffffff0},
+			bpf.TXA{},
+			bpf.JumpIfX{Cond: bpf.JumpLessOrEqual, SkipTrue: 1},
+			bpf.RetConstant{Val: 4},
+			bpf.Jump{Skip: 1},
+			bpf.RetConstant{Val: 5},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xff},
+			bpf.RetA{},
+		},
+	},
+	{
+		name: "unreachable code",
+		filter: []bpf.Instruction{
+			bpf.LoadAbsolute{Off: 0, Size: 1},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: 1, SkipTrue: 1, SkipFalse: 1},
+			bpf.RetConstant{Val: 1},
+			bpf.RetA{},
+			bpf.RetConstant{Val: 2},
+		},
+	},
+}
+
+var extendedTestPackets = [][]byte{
+	nil,
+	{0xff},
+	{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	{0x91, 0x02, 0x03, 0x04, 0x00, 0x06, 0x07, 0x08},
+	{0x81, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+	// An Ethernet ARP frame.
+	{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x01,
+		0x08, 0x06, 0x00, 0x01,
+	},
+	// IPv4 TCP segments to ports 443 and 51000, and a UDP datagram.
+	{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		192, 0, 2, 1, 192, 0, 2, 2,
+		0xc0, 0x00, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x00,
+	},
+	{
+		0x46, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		192, 0, 2, 1, 192, 0, 2, 2, 0x01, 0x04, 0x00, 0x00,
+		0x01, 0xbb, 0xc7, 0x38, 0x00, 0x00, 0x00, 0x00,
+	},
+	{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		192, 0, 2, 1, 192, 0, 2, 2,
+		0xc0, 0x00, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	},
+}
+
+func TestTranslateExtended(t *testing.T) {
+	for _, tt := range extendedTestPrograms {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, err := bpf.NewVM(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog, err := bpf.TranslateExtended(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := bpf.Assemble(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rawFilter := make([]bpf.Instruction, len(raw))
+			for i, ri := range raw {
+				rawFilter[i] = ri
+			}
+			rawProg, err := bpf.TranslateExtended(rawFilter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pkt := range extendedTestPackets {
+				want, err := vm.Run(pkt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, p := range [][]bpf.ExtendedInstruction{prog, rawProg} {
+					got, err := runExtended(p, pkt)
+					if err != nil {
+						t.Fatalf("packet %x: %v", pkt, err)
+					}
+					if int(got) != want {
+						t.Errorf("packet %x: translated program returned %v, VM returned %v", pkt, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateExtendedErrors(t *testing.T) {
+	for _, filter := range [][]bpf.Instruction{
+		nil,
+		{bpf.LoadExtension{Num: bpf.ExtRand}, bpf.RetA{}},
+		{bpf.ALUOpConstant{Op: bpf.ALUOpDiv, Val: 0}, bpf.RetA{}},
+		{bpf.LoadScratch{Dst: bpf.RegA, N: 16}, bpf.RetA{}},
+		{bpf.Jump{Skip: 1}, bpf.RetA{}},
+	} {
+		if _, err := bpf.TranslateExtended(filter); err == nil {
+			t.Errorf("TranslateExtended(%v) succeeded, want error", filter)
+		}
+	}
+}
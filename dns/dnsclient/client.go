@@ -0,0 +1,300 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnsclient sends DNS queries, built with package
+// golang.org/x/net/dns/dnsmessage, and waits for their responses.
+package dnsclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	// ErrNoResponse is returned by Client.Exchange when no valid
+	// response arrives before the last attempt times out.
+	ErrNoResponse = errors.New("no response to query")
+
+	errInvalidResponse = errors.New("invalid response to query")
+)
+
+// A Client sends DNS queries and waits for their responses, in the
+// manner of a stub resolver.
+//
+// Queries are sent over UDP, and retried if no response arrives in
+// time. A query whose UDP response is truncated is sent again over
+// TCP. Each query has a random ID, and responses which do not match
+// the query are ignored.
+//
+// A Client does no network I/O of its own; its Dial function connects
+// to the server. It is therefore usable over any transport which
+// carries DNS messages as datagrams or as a stream.
+type Client struct {
+	// Dial connects to the DNS server, with network "udp" or "tcp".
+	// It is called for every attempt, so each UDP query is sent from
+	// a fresh socket with a source port chosen by the operating
+	// system. For "tcp", Dial may return any stream connection, such
+	// as a TLS connection for DNS over TLS (RFC 7858).
+	Dial func(ctx context.Context, network string) (net.Conn, error)
+
+	// TCPOnly sends queries over TCP only, without trying UDP first.
+	TCPOnly bool
+
+	// Attempts is the number of times a query is sent over UDP
+	// before giving up. If zero, 3 attempts are made.
+	Attempts int
+
+	// Timeout is how long to wait for the response to each attempt.
+	// If zero, 5 seconds is used.
+	Timeout time.Duration
+
+	// Randomize0x20 randomizes the case of the letters in the names
+	// of the questions sent, and ignores responses which do not
+	// repeat the names exactly, making forged responses harder to
+	// deliver. See "Use of Bit 0x20 in DNS Labels to Improve
+	// Transaction Identity" (draft-vixie-dnsext-dns0x20).
+	//
+	// Servers which do not preserve the case of questions never give
+	// a valid response, so this should only be used with servers
+	// known to preserve it.
+	Randomize0x20 bool
+}
+
+func (c *Client) attempts() int {
+	if c.Attempts > 0 {
+		return c.Attempts
+	}
+	return 3
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+// Exchange sends the query q and returns the server's response.
+//
+// The query is sent with a random ID, and a response is valid only if
+// it has the same ID, is marked as a response, and repeats the query's
+// questions. q is not modified.
+func (c *Client) Exchange(ctx context.Context, q *dnsmessage.Message) (*dnsmessage.Message, error) {
+	query := *q
+	query.Header.Response = false
+	query.Questions = append([]dnsmessage.Question(nil), q.Questions...)
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	query.Header.ID = uint16(id[0])<<8 | uint16(id[1])
+	if c.Randomize0x20 {
+		for i := range query.Questions {
+			if err := randomizeCase(&query.Questions[i].Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	msg, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.TCPOnly {
+		res, err := c.exchangeUDP(ctx, &query, msg)
+		if err != nil {
+			return nil, err
+		}
+		if !res.Header.Truncated {
+			return res, nil
+		}
+	}
+	return c.attempt(ctx, "tcp", &query, msg)
+}
+
+// exchangeUDP sends msg over UDP until a valid response arrives or
+// the attempts are used up.
+func (c *Client) exchangeUDP(ctx context.Context, query *dnsmessage.Message, msg []byte) (*dnsmessage.Message, error) {
+	var lastErr error
+	for i := 0; i < c.attempts(); i++ {
+		res, err := c.attempt(ctx, "udp", query, msg)
+		if err == nil {
+			return res, nil
+		}
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil, err
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrNoResponse
+}
+
+// attempt sends msg on a new connection and waits for the response.
+func (c *Client) attempt(ctx context.Context, network string, query *dnsmessage.Message, msg []byte) (*dnsmessage.Message, error) {
+	conn, err := c.Dial(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(c.timeout())
+	ctxDeadline := false
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline, ctxDeadline = d, true
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Interrupt the exchange if ctx is done first.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var res *dnsmessage.Message
+	if network == "tcp" {
+		res, err = c.exchangeStream(conn, query, msg)
+	} else {
+		res, err = c.exchangePacket(conn, query, msg)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The connection's deadline may pass just before ctx's.
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && ctxDeadline {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return res, err
+}
+
+// exchangePacket sends msg as a datagram, and reads datagrams until
+// one is a valid response. Invalid ones may be forgeries or late
+// responses to earlier queries.
+func (c *Client) exchangePacket(conn net.Conn, query *dnsmessage.Message, msg []byte) (*dnsmessage.Message, error) {
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		res, err := parseResponse(buf[:n])
+		if err == nil && c.validResponse(query, res) {
+			return res, nil
+		}
+	}
+}
+
+// exchangeStream sends msg with a two-byte length prefix, as over
+// TCP (RFC 1035, section 4.2.2), and reads the response.
+func (c *Client) exchangeStream(conn net.Conn, query *dnsmessage.Message, msg []byte) (*dnsmessage.Message, error) {
+	b := make([]byte, 2+len(msg))
+	b[0] = byte(len(msg) >> 8)
+	b[1] = byte(len(msg))
+	copy(b[2:], msg)
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	b = make([]byte, int(l[0])<<8|int(l[1]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return nil, err
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(b); err != nil {
+		return nil, err
+	}
+	if !c.validResponse(query, &res) {
+		return nil, errInvalidResponse
+	}
+	return &res, nil
+}
+
+// parseResponse unpacks the response b. A truncated response may
+// end in the middle of a record, so when it cannot be unpacked in
+// full only its header and questions are kept.
+func parseResponse(b []byte) (*dnsmessage.Message, error) {
+	var m dnsmessage.Message
+	err := m.Unpack(b)
+	if err == nil {
+		return &m, nil
+	}
+	var p dnsmessage.Parser
+	h, herr := p.Start(b)
+	if herr != nil || !h.Truncated {
+		return nil, err
+	}
+	qs, qerr := p.AllQuestions()
+	if qerr != nil {
+		return nil, err
+	}
+	return &dnsmessage.Message{Header: h, Questions: qs}, nil
+}
+
+// validResponse reports whether res is a response to query.
+func (c *Client) validResponse(query, res *dnsmessage.Message) bool {
+	if !res.Header.Response || res.Header.ID != query.Header.ID ||
+		len(res.Questions) != len(query.Questions) {
+		return false
+	}
+	for i := range query.Questions {
+		q, r := &query.Questions[i], &res.Questions[i]
+		if q.Type != r.Type || q.Class != r.Class {
+			return false
+		}
+		if c.Randomize0x20 {
+			if q.Name.Length != r.Name.Length ||
+				!bytes.Equal(q.Name.Data[:q.Name.Length], r.Name.Data[:r.Name.Length]) {
+				return false
+			}
+		} else if !nameEqual(&q.Name, &r.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// nameEqual reports whether a and b are the same name, ignoring ASCII case.
+func nameEqual(a, b *dnsmessage.Name) bool {
+	return a.Length == b.Length && bytes.EqualFold(a.Data[:a.Length], b.Data[:b.Length])
+}
+
+// randomizeCase sets the case of each ASCII letter in n at random.
+func randomizeCase(n *dnsmessage.Name) error {
+	var bits [255]byte
+	if _, err := rand.Read(bits[:n.Length]); err != nil {
+		return err
+	}
+	for i := 0; i < int(n.Length); i++ {
+		c := n.Data[i] | 0x20
+		if c >= 'a' && c <= 'z' {
+			n.Data[i] = c ^ (bits[i] & 0x20)
+		}
+	}
+	return nil
+}
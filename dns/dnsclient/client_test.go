@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testServer answers queries on in-memory connections. reply is called
+// with the network, the attempt number on that network, and the query,
+// and returns the messages to send back.
+type testServer struct {
+	t     *testing.T
+	reply func(network string, attempt int, q *dnsmessage.Message) []*dnsmessage.Message
+	dials map[string]int
+}
+
+func (s *testServer) dial(ctx context.Context, network string) (net.Conn, error) {
+	if s.dials == nil {
+		s.dials = make(map[string]int)
+	}
+	attempt := s.dials[network]
+	s.dials[network]++
+	client, server := net.Pipe()
+	go s.serve(server, network, attempt)
+	return client, nil
+}
+
+func (s *testServer) serve(conn net.Conn, network string, attempt int) {
+	defer conn.Close()
+	var b []byte
+	if network == "tcp" {
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return
+		}
+		b = make([]byte, int(l[0])<<8|int(l[1]))
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return
+		}
+	} else {
+		b = make([]byte, 512)
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		b = b[:n]
+	}
+	var q dnsmessage.Message
+	if err := q.Unpack(b); err != nil {
+		s.t.Errorf("server: unpacking query: %v", err)
+		return
+	}
+	for _, m := range s.reply(network, attempt, &q) {
+		buf, err := m.Pack()
+		if err != nil {
+			s.t.Errorf("server: packing response: %v", err)
+			return
+		}
+		if network == "tcp" {
+			buf = append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...)
+		}
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+	}
+	// Hold the connection open until the client closes it.
+	io.Copy(io.Discard, conn)
+}
+
+func testQuery(name string) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+}
+
+func testAnswer(q *dnsmessage.Message, a [4]byte) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.Header.ID, Response: true},
+		Questions: q.Questions,
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: a},
+		}},
+	}
+}
+
+func answerA(t *testing.T, m *dnsmessage.Message) [4]byte {
+	t.Helper()
+	if len(m.Answers) != 1 {
+		t.Fatalf("got %v answers, want 1", len(m.Answers))
+	}
+	a, ok := m.Answers[0].Body.(*dnsmessage.AResource)
+	if !ok {
+		t.Fatalf("answer is %T, want *AResource", m.Answers[0].Body)
+	}
+	return a.A
+}
+
+func TestClientRetry(t *testing.T) {
+	s := &testServer{t: t}
+	s.reply = func(network string, attempt int, q *dnsmessage.Message) []*dnsmessage.Message {
+		if attempt == 0 {
+			// Lost.
+			return nil
+		}
+		wrongID := testAnswer(q, [4]byte{192, 0, 2, 66})
+		wrongID.Header.ID++
+		wrongName := testAnswer(q, [4]byte{192, 0, 2, 67})
+		wrongName.Questions = []dnsmessage.Question{{Name: dnsmessage.MustNewName("other.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}
+		notResponse := testAnswer(q, [4]byte{192, 0, 2, 68})
+		notResponse.Header.Response = false
+		return []*dnsmessage.Message{wrongID, wrongName, notResponse, testAnswer(q, [4]byte{192, 0, 2, 1})}
+	}
+	c := &Client{Dial: s.dial, Timeout: 50 * time.Millisecond}
+	q := testQuery("example.com.")
+	res, err := c.Exchange(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := answerA(t, res), [4]byte{192, 0, 2, 1}; got != want {
+		t.Errorf("answer = %v, want %v", got, want)
+	}
+	if got, want := s.dials["udp"], 2; got != want {
+		t.Errorf("UDP dials = %v, want %v", got, want)
+	}
+	if q.Header.ID != 0 {
+		t.Errorf("Exchange modified the query's ID")
+	}
+}
+
+func TestClientNoResponse(t *testing.T) {
+	s := &testServer{t: t}
+	s.reply = func(string, int, *dnsmessage.Message) []*dnsmessage.Message { return nil }
+	c := &Client{Dial: s.dial, Attempts: 2, Timeout: 10 * time.Millisecond}
+	if _, err := c.Exchange(context.Background(), testQuery("example.com.")); err != ErrNoResponse {
+		t.Errorf("Exchange: %v, want ErrNoResponse", err)
+	}
+	if got, want := s.dials["udp"], 2; got != want {
+		t.Errorf("UDP dials = %v, want %v", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c = &Client{Dial: s.dial}
+	if _, err := c.Exchange(ctx, testQuery("example.com.")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Exchange with expired context: %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestClientTruncated(t *testing.T) {
+	s := &testServer{t: t}
+	s.reply = func(network string, attempt int, q *dnsmessage.Message) []*dnsmessage.Message {
+		if network == "udp" {
+			tc := testAnswer(q, [4]byte{192, 0, 2, 66})
+			tc.Header.Truncated = true
+			return []*dnsmessage.Message{tc}
+		}
+		return []*dnsmessage.Message{testAnswer(q, [4]byte{192, 0, 2, 1})}
+	}
+	c := &Client{Dial: s.dial}
+	res, err := c.Exchange(context.Background(), testQuery("example.com."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := answerA(t, res), [4]byte{192, 0, 2, 1}; got != want {
+		t.Errorf("answer = %v, want %v", got, want)
+	}
+	if s.dials["udp"] != 1 || s.dials["tcp"] != 1 {
+		t.Errorf("dials = %v, want one UDP and one TCP", s.dials)
+	}
+
+	// TCPOnly skips UDP.
+	s.dials = nil
+	c.TCPOnly = true
+	if _, err := c.Exchange(context.Background(), testQuery("example.com.")); err != nil {
+		t.Fatal(err)
+	}
+	if s.dials["udp"] != 0 || s.dials["tcp"] != 1 {
+		t.Errorf("TCPOnly dials = %v, want one TCP", s.dials)
+	}
+}
+
+func TestClientRandomize0x20(t *testing.T) {
+	const name = "abcdefghijklmnopqrstuvwxyz.example."
+	var sent dnsmessage.Name
+	s := &testServer{t: t}
+	s.reply = func(network string, attempt int, q *dnsmessage.Message) []*dnsmessage.Message {
+		sent = q.Questions[0].Name
+		lower := testAnswer(q, [4]byte{192, 0, 2, 66})
+		n := dnsmessage.MustNewName(name)
+		lower.Questions = []dnsmessage.Question{{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}}
+		if attempt == 0 {
+			// A server which does not preserve case.
+			return []*dnsmessage.Message{lower}
+		}
+		return []*dnsmessage.Message{lower, testAnswer(q, [4]byte{192, 0, 2, 1})}
+	}
+	c := &Client{Dial: s.dial, Timeout: 50 * time.Millisecond, Randomize0x20: true}
+	res, err := c.Exchange(context.Background(), testQuery(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := answerA(t, res), [4]byte{192, 0, 2, 1}; got != want {
+		t.Errorf("answer = %v, want %v", got, want)
+	}
+	if got, want := s.dials["udp"], 2; got != want {
+		t.Errorf("UDP dials = %v, want %v", got, want)
+	}
+	if got := sent.Data[:sent.Length]; !bytes.EqualFold(got, []byte(name)) || bytes.Equal(got, []byte(name)) {
+		t.Errorf("sent question name %q, want %q with randomized case", got, name)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsmessage
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Canonicalize puts m in a canonical form, so that messages with the
+// same meaning compare equal, for use in tests.
+//
+// Names in questions and resource headers, and the domain names in the
+// data of CNAME, MX, NS, PTR, SOA, and SRV records, are lower cased.
+// The questions and the resources of each section are sorted by name,
+// in DNSSEC canonical order (RFC 4034 section 6.1), then by type,
+// class, data, and TTL. The Type of each resource header is set from
+// its body and its Length is cleared, since both are computed when
+// packing. The Header, including its ID, is not changed.
+//
+// Canonicalize replaces resource bodies rather than modifying them,
+// so bodies shared with other messages are not affected.
+func (m *Message) Canonicalize() {
+	for i := range m.Questions {
+		lowerName(&m.Questions[i].Name)
+	}
+	sort.SliceStable(m.Questions, func(i, j int) bool {
+		x, y := &m.Questions[i], &m.Questions[j]
+		if c := compareNames(&x.Name, &y.Name); c != 0 {
+			return c < 0
+		}
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		return x.Class < y.Class
+	})
+	for _, rs := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		canonicalizeResources(rs)
+	}
+}
+
+// PackCanonical returns the wire format of a canonicalized copy of m,
+// without name compression. Two messages with the same meaning pack to
+// the same bytes.
+func (m *Message) PackCanonical() ([]byte, error) {
+	c := m.copySections()
+	c.Canonicalize()
+	return c.appendPack(make([]byte, 0, packStartingCap), false)
+}
+
+// copySections returns a copy of m which does not share its section slices.
+func (m *Message) copySections() Message {
+	return Message{
+		Header:      m.Header,
+		Questions:   append([]Question(nil), m.Questions...),
+		Answers:     append([]Resource(nil), m.Answers...),
+		Authorities: append([]Resource(nil), m.Authorities...),
+		Additionals: append([]Resource(nil), m.Additionals...),
+	}
+}
+
+func canonicalizeResources(rs []Resource) {
+	data := make([][]byte, len(rs))
+	for i := range rs {
+		r := &rs[i]
+		lowerName(&r.Header.Name)
+		r.Header.Length = 0
+		if r.Body == nil {
+			continue
+		}
+		r.Body = lowerBody(r.Body)
+		r.Header.Type = r.Body.realType()
+		data[i], _ = r.Body.pack(nil, nil, 0)
+	}
+	idx := make([]int, len(rs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		x, y := &rs[idx[i]], &rs[idx[j]]
+		if c := compareNames(&x.Header.Name, &y.Header.Name); c != 0 {
+			return c < 0
+		}
+		if x.Header.Type != y.Header.Type {
+			return x.Header.Type < y.Header.Type
+		}
+		if x.Header.Class != y.Header.Class {
+			return x.Header.Class < y.Header.Class
+		}
+		if c := bytes.Compare(data[idx[i]], data[idx[j]]); c != 0 {
+			return c < 0
+		}
+		return x.Header.TTL < y.Header.TTL
+	})
+	sorted := make([]Resource, len(rs))
+	for i, j := range idx {
+		sorted[i] = rs[j]
+	}
+	copy(rs, sorted)
+}
+
+// lowerBody returns body with the domain names in its data lower cased.
+func lowerBody(body ResourceBody) ResourceBody {
+	switch b := body.(type) {
+	case *CNAMEResource:
+		c := *b
+		lowerName(&c.CNAME)
+		return &c
+	case *MXResource:
+		c := *b
+		lowerName(&c.MX)
+		return &c
+	case *NSResource:
+		c := *b
+		lowerName(&c.NS)
+		return &c
+	case *PTRResource:
+		c := *b
+		lowerName(&c.PTR)
+		return &c
+	case *SOAResource:
+		c := *b
+		lowerName(&c.NS)
+		lowerName(&c.MBox)
+		return &c
+	case *SRVResource:
+		c := *b
+		lowerName(&c.Target)
+		return &c
+	}
+	return body
+}
+
+func lowerName(n *Name) {
+	for i := 0; i < int(n.Length); i++ {
+		if c := n.Data[i]; 'A' <= c && c <= 'Z' {
+			n.Data[i] = c + 'a' - 'A'
+		}
+	}
+}
+
+// compareNames compares x and y in DNSSEC canonical order: label by
+// label, starting from the rightmost label.
+func compareNames(x, y *Name) int {
+	xl := strings.Split(strings.TrimSuffix(x.String(), "."), ".")
+	yl := strings.Split(strings.TrimSuffix(y.String(), "."), ".")
+	for i, j := len(xl)-1, len(yl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(xl[i], yl[j]); c != 0 {
+			return c
+		}
+	}
+	return len(xl) - len(yl)
+}
+
+// Diff returns a human-readable description of the differences between
+// the canonical forms of x and y, or "" if they are the same.
+//
+// Each line of the description shows a header, question, or resource
+// record in a format similar to a zone file, prefixed by "-" if it is
+// only in x or "+" if it is only in y. For example:
+//
+//	-answer: www.example.com. 300 IN A 192.0.2.1
+//	+answer: www.example.com. 300 IN A 192.0.2.2
+func Diff(x, y *Message) string {
+	xc, yc := x.copySections(), y.copySections()
+	xc.Canonicalize()
+	yc.Canonicalize()
+	a, b := messageLines(&xc), messageLines(&yc)
+
+	// lcs[i][j] is the length of the longest common subsequence
+	// of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			sb.WriteString("-" + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// messageLines returns the lines describing m for Diff.
+func messageLines(m *Message) []string {
+	lines := []string{"header: " + headerString(&m.Header)}
+	for i := range m.Questions {
+		q := &m.Questions[i]
+		lines = append(lines, "question: "+q.Name.String()+" "+classString(q.Class)+" "+typeString(q.Type))
+	}
+	for _, sec := range []struct {
+		name string
+		rs   []Resource
+	}{
+		{"answer", m.Answers},
+		{"authority", m.Authorities},
+		{"additional", m.Additionals},
+	} {
+		for i := range sec.rs {
+			lines = append(lines, sec.name+": "+resourceString(&sec.rs[i]))
+		}
+	}
+	return lines
+}
+
+func headerString(h *Header) string {
+	s := "id=" + strconv.Itoa(int(h.ID)) + " opcode=" + strconv.Itoa(int(h.OpCode)) + " rcode=" + h.RCode.String()
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{h.Response, "qr"},
+		{h.Authoritative, "aa"},
+		{h.Truncated, "tc"},
+		{h.RecursionDesired, "rd"},
+		{h.RecursionAvailable, "ra"},
+		{h.AuthenticData, "ad"},
+		{h.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			s += " " + f.name
+		}
+	}
+	return s
+}
+
+func typeString(t Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+func classString(c Class) string {
+	if c == ClassINET {
+		return "IN"
+	}
+	return strings.TrimPrefix(c.String(), "Class")
+}
+
+func resourceString(r *Resource) string {
+	h := &r.Header
+	s := h.Name.String() + " " + strconv.FormatUint(uint64(h.TTL), 10) + " " +
+		classString(h.Class) + " " + typeString(h.Type)
+	if r.Body == nil {
+		return s
+	}
+	return s + " " + bodyString(r.Body)
+}
+
+func bodyString(body ResourceBody) string {
+	u16 := func(v uint16) string { return strconv.Itoa(int(v)) }
+	u32 := func(v uint32) string { return strconv.FormatUint(uint64(v), 10) }
+	switch b := body.(type) {
+	case *AResource:
+		return u16(uint16(b.A[0])) + "." + u16(uint16(b.A[1])) + "." + u16(uint16(b.A[2])) + "." + u16(uint16(b.A[3]))
+	case *AAAAResource:
+		var parts []string
+		for i := 0; i < 16; i += 2 {
+			parts = append(parts, strconv.FormatUint(uint64(b.AAAA[i])<<8|uint64(b.AAAA[i+1]), 16))
+		}
+		return strings.Join(parts, ":")
+	case *CNAMEResource:
+		return b.CNAME.String()
+	case *MXResource:
+		return u16(b.Pref) + " " + b.MX.String()
+	case *NSResource:
+		return b.NS.String()
+	case *PTRResource:
+		return b.PTR.String()
+	case *SOAResource:
+		return b.NS.String() + " " + b.MBox.String() + " " + u32(b.Serial) + " " +
+			u32(b.Refresh) + " " + u32(b.Retry) + " " + u32(b.Expire) + " " + u32(b.MinTTL)
+	case *SRVResource:
+		return u16(b.Priority) + " " + u16(b.Weight) + " " + u16(b.Port) + " " + b.Target.String()
+	case *TXTResource:
+		var parts []string
+		for _, t := range b.TXT {
+			parts = append(parts, strconv.Quote(t))
+		}
+		return strings.Join(parts, " ")
+	case *OPTResource:
+		var parts []string
+		for _, o := range b.Options {
+			parts = append(parts, u16(o.Code)+"="+hexString(o.Data))
+		}
+		return strings.Join(parts, " ")
+	case *UnknownResource:
+		return `\# ` + strconv.Itoa(len(b.Data)) + " " + hexString(b.Data)
+	}
+	return body.GoString()
+}
+
+func hexString(b []byte) string {
+	s := make([]byte, 0, 2*len(b))
+	for _, c := range b {
+		s = append(s, hexDigits[c>>4], hexDigits[c&0xf])
+	}
+	return string(s)
+}
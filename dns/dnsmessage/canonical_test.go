@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsmessage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func canonicalTestMessage(upper bool, reversed bool) Message {
+	name := func(s string) Name {
+		if upper {
+			s = string(bytes.ToUpper([]byte(s)))
+		}
+		return MustNewName(s)
+	}
+	answers := []Resource{
+		{ResourceHeader{Name: name("example.com."), Class: ClassINET, TTL: 300}, &AResource{A: [4]byte{192, 0, 2, 1}}},
+		{ResourceHeader{Name: name("example.com."), Class: ClassINET, TTL: 300}, &AResource{A: [4]byte{192, 0, 2, 2}}},
+		{ResourceHeader{Name: name("a.example.com."), Class: ClassINET, TTL: 60}, &CNAMEResource{CNAME: name("www.example.com.")}},
+		{ResourceHeader{Name: name("example.com."), Class: ClassINET, TTL: 300}, &MXResource{Pref: 10, MX: name("mail.example.com.")}},
+	}
+	if reversed {
+		for i, j := 0, len(answers)-1; i < j; i, j = i+1, j-1 {
+			answers[i], answers[j] = answers[j], answers[i]
+		}
+	}
+	return Message{
+		Header:    Header{ID: 7, Response: true, Authoritative: true},
+		Questions: []Question{{Name: name("example.com."), Type: TypeA, Class: ClassINET}},
+		Answers:   answers,
+		Authorities: []Resource{
+			{ResourceHeader{Name: name("example.com."), Class: ClassINET, TTL: 3600}, &NSResource{NS: name("ns.example.com.")}},
+		},
+	}
+}
+
+func TestPackCanonical(t *testing.T) {
+	x := canonicalTestMessage(false, false)
+	y := canonicalTestMessage(true, true)
+	xb, err := x.PackCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	yb, err := y.PackCanonical()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(xb, yb) {
+		t.Errorf("PackCanonical differs for equivalent messages:\n%x\n%x", xb, yb)
+	}
+	if y.Questions[0].Name.String() != "EXAMPLE.COM." {
+		t.Errorf("PackCanonical modified its receiver: %v", y.GoString())
+	}
+	if cname := y.Answers[0].Body.(*MXResource).MX.String(); cname != "MAIL.EXAMPLE.COM." {
+		t.Errorf("PackCanonical modified a resource body: MX = %q", cname)
+	}
+
+	// The canonical form is not compressed, so it is longer than the
+	// packed message, but unpacks to the same records.
+	packed, err := x.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xb) <= len(packed) {
+		t.Errorf("PackCanonical: %v bytes, want more than Pack's %v", len(xb), len(packed))
+	}
+	var m Message
+	if err := m.Unpack(xb); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(&m, &x); d != "" {
+		t.Errorf("unpacked canonical message differs:\n%v", d)
+	}
+}
+
+func TestCanonicalizeOrder(t *testing.T) {
+	m := canonicalTestMessage(true, true)
+	m.Canonicalize()
+	var got []string
+	for i := range m.Answers {
+		got = append(got, resourceString(&m.Answers[i]))
+	}
+	want := []string{
+		"example.com. 300 IN A 192.0.2.1",
+		"example.com. 300 IN A 192.0.2.2",
+		"example.com. 300 IN MX 10 mail.example.com.",
+		"a.example.com. 60 IN CNAME www.example.com.",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Answers[%v] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	x := canonicalTestMessage(false, false)
+	y := canonicalTestMessage(true, true)
+	if d := Diff(&x, &y); d != "" {
+		t.Errorf("Diff of equivalent messages:\n%v", d)
+	}
+
+	y.Header.RecursionAvailable = true
+	y.Answers[3].Body = &AResource{A: [4]byte{192, 0, 2, 3}} // was 192.0.2.1
+	y.Additionals = []Resource{{
+		ResourceHeader{Name: MustNewName("ns.example.com."), Class: ClassINET, TTL: 3600},
+		&AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+	}}
+	want := "-header: id=7 opcode=0 rcode=RCodeSuccess qr aa\n" +
+		"+header: id=7 opcode=0 rcode=RCodeSuccess qr aa ra\n" +
+		"-answer: example.com. 300 IN A 192.0.2.1\n" +
+		"+answer: example.com. 300 IN A 192.0.2.3\n" +
+		"+additional: ns.example.com. 3600 IN AAAA 2001:db8:0:0:0:0:0:1\n"
+	if d := Diff(&x, &y); d != want {
+		t.Errorf("Diff:\n%v\nwant:\n%v", d, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
 )
 
 func ExampleParser() {
@@ -122,3 +123,47 @@ func ExampleParser() {
 	// Found question for name bar.example.com.
 	// Found A/AAAA records for name bar.example.com.: [127.0.0.2]
 }
+
+func ExampleServiceInstance() {
+	// Announce a service instance over multicast DNS.
+	c, err := net.ListenPacket("udp4", "0.0.0.0:5353")
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+	group := &net.UDPAddr{IP: dnsmessage.MDNSIPv4Group[:], Port: dnsmessage.MDNSPort}
+	p := ipv4.NewPacketConn(c)
+	if err := p.JoinGroup(nil, group); err != nil {
+		panic(err)
+	}
+	if err := p.SetMulticastTTL(255); err != nil {
+		panic(err)
+	}
+
+	inst := dnsmessage.ServiceInstance{
+		Instance: "Office Printer",
+		Service:  "_ipp._tcp",
+		Host:     dnsmessage.MustNewName("printer.local."),
+		Port:     631,
+		Text:     []string{"txtvers=1"},
+		TTL:      120,
+	}
+	rs, err := inst.Resources()
+	if err != nil {
+		panic(err)
+	}
+	for i := range rs[1:] {
+		rs[1+i].Header.SetCacheFlush(true)
+	}
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: rs,
+	}
+	buf, err := msg.Pack()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := p.WriteTo(buf, nil, group); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsmessage
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// Multicast DNS (RFC 6762) and DNS-Based Service Discovery (RFC 6763).
+//
+// Multicast DNS messages are sent to MDNSPort on the MDNSIPv4Group and
+// MDNSIPv6Group multicast groups, which can be joined with the
+// golang.org/x/net/ipv4 and golang.org/x/net/ipv6 packages.
+
+// MDNSPort is the UDP port used for multicast DNS.
+const MDNSPort = 5353
+
+var (
+	// MDNSIPv4Group is the IPv4 multicast group for multicast DNS, 224.0.0.251.
+	MDNSIPv4Group = [4]byte{224, 0, 0, 251}
+
+	// MDNSIPv6Group is the IPv6 multicast group for multicast DNS, ff02::fb.
+	MDNSIPv6Group = [16]byte{0xff, 0x02, 14: 0x00, 15: 0xfb}
+)
+
+// mdnsClassBit is the top bit of the class field, which multicast DNS
+// uses as the unicast-response bit in questions and the cache-flush
+// bit in resource records.
+const mdnsClassBit Class = 1 << 15
+
+var errInvalidInstance = errors.New("invalid service instance name")
+
+// SetUnicastResponse sets or clears the multicast DNS unicast-response
+// bit of q, which asks for a unicast (QU) rather than a multicast (QM)
+// response.
+func (q *Question) SetUnicastResponse(unicast bool) {
+	q.Class = setClassBit(q.Class, unicast)
+}
+
+// UnicastResponse reports whether q asks for a unicast (QU) response.
+func (q *Question) UnicastResponse() bool {
+	return q.Class&mdnsClassBit != 0
+}
+
+// SetCacheFlush sets or clears the multicast DNS cache-flush bit of h,
+// which tells receivers that the record replaces all cached records
+// with the same name, type, and class.
+func (h *ResourceHeader) SetCacheFlush(flush bool) {
+	h.Class = setClassBit(h.Class, flush)
+}
+
+// CacheFlush reports whether the multicast DNS cache-flush bit of h is set.
+func (h *ResourceHeader) CacheFlush() bool {
+	return h.Class&mdnsClassBit != 0
+}
+
+func setClassBit(c Class, set bool) Class {
+	if set {
+		return c | mdnsClassBit
+	}
+	return c &^ mdnsClassBit
+}
+
+// SuppressKnownAnswers returns the records of answers which are not
+// suppressed by the known answers listed in a multicast DNS query
+// (RFC 6762 section 7.1).
+//
+// An answer is suppressed when known holds the same record, with a TTL
+// of at least half of the answer's TTL. The cache-flush bit is ignored
+// when comparing records. answers is not modified.
+func SuppressKnownAnswers(answers, known []Resource) []Resource {
+	var kept []Resource
+	for _, a := range answers {
+		suppressed := false
+		for _, k := range known {
+			if k.Header.TTL >= a.Header.TTL/2 && sameRecord(&a, &k) {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// sameRecord reports whether a and b have the same name, type, class,
+// and data.
+func sameRecord(a, b *Resource) bool {
+	if a.Body == nil || b.Body == nil ||
+		a.Body.realType() != b.Body.realType() ||
+		a.Header.Class&^mdnsClassBit != b.Header.Class&^mdnsClassBit ||
+		!nameEqual(&a.Header.Name, &b.Header.Name) {
+		return false
+	}
+	ad, err := a.Body.pack(nil, nil, 0)
+	if err != nil {
+		return false
+	}
+	bd, err := b.Body.pack(nil, nil, 0)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ad, bd)
+}
+
+// nameEqual reports whether a and b are the same name, ignoring ASCII case.
+func nameEqual(a, b *Name) bool {
+	return a.Length == b.Length && bytes.EqualFold(a.Data[:a.Length], b.Data[:b.Length])
+}
+
+// A ServiceInstance is a DNS-SD service instance (RFC 6763), described
+// by a PTR, SRV, and TXT record.
+type ServiceInstance struct {
+	// Instance is the user-visible instance name, such as "Office Printer".
+	// It may contain spaces but not dots.
+	Instance string
+
+	// Service is the service type, such as "_ipp._tcp".
+	Service string
+
+	// Domain is the domain in which the service is registered.
+	// If empty, "local." is used.
+	Domain string
+
+	// Host is the target host of the SRV record.
+	Host Name
+
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+
+	// Text holds the key/value strings of the TXT record.
+	Text []string
+
+	// TTL is used for all of the instance's records.
+	TTL uint32
+}
+
+// serviceName returns the name of the service type in the instance's domain.
+func (s *ServiceInstance) serviceName() (Name, error) {
+	domain := s.Domain
+	if domain == "" {
+		domain = "local."
+	}
+	return NewName(s.Service + "." + domain)
+}
+
+// Name returns the instance's full name, such as
+// "Office Printer._ipp._tcp.local.".
+func (s *ServiceInstance) Name() (Name, error) {
+	if s.Instance == "" || len(s.Instance) > 63 || strings.Contains(s.Instance, ".") {
+		return Name{}, errInvalidInstance
+	}
+	svc, err := s.serviceName()
+	if err != nil {
+		return Name{}, err
+	}
+	return NewName(s.Instance + "." + svc.String())
+}
+
+// Resources returns the instance's PTR, SRV, and TXT records, in that
+// order. The PTR record maps the service type to the instance name, and
+// the SRV and TXT records describe the instance. A TXT record with no
+// strings holds a single empty string, as required by RFC 6763.
+func (s *ServiceInstance) Resources() ([]Resource, error) {
+	svc, err := s.serviceName()
+	if err != nil {
+		return nil, err
+	}
+	name, err := s.Name()
+	if err != nil {
+		return nil, err
+	}
+	txt := s.Text
+	if len(txt) == 0 {
+		txt = []string{""}
+	}
+	header := func(n Name, typ Type) ResourceHeader {
+		return ResourceHeader{Name: n, Type: typ, Class: ClassINET, TTL: s.TTL}
+	}
+	return []Resource{
+		{header(svc, TypePTR), &PTRResource{PTR: name}},
+		{header(name, TypeSRV), &SRVResource{Priority: s.Priority, Weight: s.Weight, Port: s.Port, Target: s.Host}},
+		{header(name, TypeTXT), &TXTResource{TXT: txt}},
+	}, nil
+}
+
+// ServiceInstances returns the service instances described by rs, such
+// as the answers and additional records of a DNS-SD response.
+//
+// Each PTR record pointing to a name in the PTR record's own domain,
+// such as "_ipp._tcp.local." pointing to "Office Printer._ipp._tcp.local.",
+// describes an instance if rs also holds an SRV record for the instance.
+// The instance's Text is taken from a TXT record for the instance, if
+// any. Other records, including subtype PTR records, are ignored.
+func ServiceInstances(rs []Resource) []ServiceInstance {
+	var instances []ServiceInstance
+	for i := range rs {
+		ptr, ok := rs[i].Body.(*PTRResource)
+		if !ok {
+			continue
+		}
+		svc := rs[i].Header.Name.String()
+		target := ptr.PTR.String()
+		if len(target) <= len(svc)+1 || target[len(target)-len(svc)-1] != '.' ||
+			!strings.EqualFold(target[len(target)-len(svc):], svc) {
+			continue
+		}
+		inst := ServiceInstance{
+			Instance: target[:len(target)-len(svc)-1],
+			TTL:      rs[i].Header.TTL,
+		}
+		if strings.Contains(inst.Instance, ".") {
+			continue
+		}
+		// The service type is the first two labels of the PTR
+		// record's name: the service and the protocol.
+		labels := strings.SplitN(svc, ".", 3)
+		if len(labels) < 3 {
+			continue
+		}
+		inst.Service, inst.Domain = labels[0]+"."+labels[1], labels[2]
+
+		found := false
+		for j := range rs {
+			if !nameEqual(&rs[j].Header.Name, &ptr.PTR) {
+				continue
+			}
+			switch body := rs[j].Body.(type) {
+			case *SRVResource:
+				found = true
+				inst.Host = body.Target
+				inst.Port = body.Port
+				inst.Priority = body.Priority
+				inst.Weight = body.Weight
+			case *TXTResource:
+				if len(body.TXT) != 1 || body.TXT[0] != "" {
+					inst.Text = body.TXT
+				}
+			}
+		}
+		if found {
+			instances = append(instances, inst)
+		}
+	}
+	return instances
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnsmessage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMDNSClassBits(t *testing.T) {
+	q := Question{Name: MustNewName("host.local."), Type: TypeA, Class: ClassINET}
+	q.SetUnicastResponse(true)
+	if !q.UnicastResponse() || q.Class != ClassINET|0x8000 {
+		t.Errorf("after SetUnicastResponse(true): Class = %#x, UnicastResponse = %v", uint16(q.Class), q.UnicastResponse())
+	}
+	q.SetUnicastResponse(false)
+	if q.UnicastResponse() || q.Class != ClassINET {
+		t.Errorf("after SetUnicastResponse(false): Class = %#x", uint16(q.Class))
+	}
+
+	h := ResourceHeader{Name: MustNewName("host.local."), Class: ClassINET}
+	h.SetCacheFlush(true)
+	if !h.CacheFlush() || h.Class != ClassINET|0x8000 {
+		t.Errorf("after SetCacheFlush(true): Class = %#x", uint16(h.Class))
+	}
+
+	// The bits survive packing and parsing.
+	msg := Message{
+		Questions: []Question{{Name: q.Name, Type: TypeA, Class: ClassINET | 0x8000}},
+		Answers:   []Resource{{h, &AResource{A: [4]byte{192, 0, 2, 1}}}},
+	}
+	buf, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := got.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Questions[0].UnicastResponse() || !got.Answers[0].Header.CacheFlush() {
+		t.Errorf("unpacked message lost mDNS class bits: %v", got.GoString())
+	}
+}
+
+func TestSuppressKnownAnswers(t *testing.T) {
+	a := func(name string, ttl uint32, ip byte) Resource {
+		return Resource{
+			Header: ResourceHeader{Name: MustNewName(name), Type: TypeA, Class: ClassINET, TTL: ttl},
+			Body:   &AResource{A: [4]byte{192, 0, 2, ip}},
+		}
+	}
+	flushed := a("b.local.", 120, 2)
+	flushed.Header.SetCacheFlush(true)
+	answers := []Resource{a("a.local.", 120, 1), flushed, a("c.local.", 120, 3), a("d.local.", 120, 4)}
+	known := []Resource{
+		a("A.LOCAL.", 60, 1),  // same record, half the TTL: suppressed
+		a("b.local.", 100, 2), // cache-flush bit is ignored: suppressed
+		a("c.local.", 59, 3),  // less than half the TTL
+		a("d.local.", 120, 5), // different data
+	}
+	got := SuppressKnownAnswers(answers, known)
+	var names []string
+	for _, r := range got {
+		names = append(names, r.Header.Name.String())
+	}
+	if want := []string{"c.local.", "d.local."}; !reflect.DeepEqual(names, want) {
+		t.Errorf("SuppressKnownAnswers kept %q, want %q", names, want)
+	}
+}
+
+func TestServiceInstances(t *testing.T) {
+	insts := []ServiceInstance{{
+		Instance: "Office Printer",
+		Service:  "_ipp._tcp",
+		Host:     MustNewName("printer.local."),
+		Port:     631,
+		Text:     []string{"txtvers=1", "rp=ipp/print"},
+		TTL:      4500,
+	}, {
+		Instance: "Lobby",
+		Service:  "_ipp._tcp",
+		Domain:   "example.com.",
+		Host:     MustNewName("lobby.example.com."),
+		Port:     631,
+		Priority: 1,
+		Weight:   2,
+		TTL:      120,
+	}}
+	msg := Message{Header: Header{Response: true, Authoritative: true}}
+	for i := range insts {
+		rs, err := insts[i].Resources()
+		if err != nil {
+			t.Fatalf("%q.Resources: %v", insts[i].Instance, err)
+		}
+		if len(rs) != 3 {
+			t.Fatalf("%q.Resources returned %v records, want 3", insts[i].Instance, len(rs))
+		}
+		msg.Answers = append(msg.Answers, rs[0])
+		msg.Additionals = append(msg.Additionals, rs[1:]...)
+	}
+	// A subtype PTR record is ignored.
+	msg.Answers = append(msg.Answers, Resource{
+		Header: ResourceHeader{Name: MustNewName("_printer._sub._ipp._tcp.local."), Type: TypePTR, Class: ClassINET},
+		Body:   &PTRResource{PTR: MustNewName("Office Printer._ipp._tcp.local.")},
+	})
+	buf, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Message
+	if err := parsed.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	got := ServiceInstances(append(parsed.Answers, parsed.Additionals...))
+	insts[0].Domain = "local."
+	if !reflect.DeepEqual(got, insts) {
+		t.Errorf("ServiceInstances:\ngot  %+v\nwant %+v", got, insts)
+	}
+}
+
+func TestServiceInstanceInvalid(t *testing.T) {
+	for _, inst := range []string{"", "a.b", string(make([]byte, 64))} {
+		s := ServiceInstance{Instance: inst, Service: "_http._tcp"}
+		if _, err := s.Resources(); err == nil {
+			t.Errorf("Resources for instance %q: got nil error", inst)
+		}
+	}
+}
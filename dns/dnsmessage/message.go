@@ -1102,6 +1102,11 @@ func (m *Message) Pack() ([]byte, error) {
 // AppendPack is like Pack but appends the full Message to b and returns the
 // extended buffer.
 func (m *Message) AppendPack(b []byte) ([]byte, error) {
+	return m.appendPack(b, true)
+}
+
+// appendPack is AppendPack, with name compression enabled if compress is set.
+func (m *Message) appendPack(b []byte, compress bool) ([]byte, error) {
 	// Validate the lengths. It is very unlikely that anyone will try to
 	// pack more than 65535 of any particular type, but it is possible and
 	// we should fail gracefully.
@@ -1137,7 +1142,10 @@ func (m *Message) AppendPack(b []byte) ([]byte, error) {
 	// DNS messages can be a maximum of 512 bytes long. Without compression,
 	// many DNS response messages are over this limit, so enabling
 	// compression will help ensure compliance.
-	compression := map[string]uint16{}
+	var compression map[string]uint16
+	if compress {
+		compression = map[string]uint16{}
+	}
 
 	for i := range m.Questions {
 		var err error
@@ -54,19 +54,14 @@ func DetermineEncoding(content []byte, contentType string) (e encoding.Encoding,
 		content = content[:1024]
 	}
 
-	for _, b := range boms {
-		if bytes.HasPrefix(content, b.bom) {
-			e, name = Lookup(b.enc)
-			return e, name, true
-		}
+	if name, _ := SniffBOM(content); name != "" {
+		e, name = Lookup(name)
+		return e, name, true
 	}
 
-	if _, params, err := mime.ParseMediaType(contentType); err == nil {
-		if cs, ok := params["charset"]; ok {
-			if e, name = Lookup(cs); e != nil {
-				return e, name, true
-			}
-		}
+	if name := ContentTypeCharset(contentType); name != "" {
+		e, name = Lookup(name)
+		return e, name, true
 	}
 
 	if len(content) > 0 {
@@ -76,7 +71,91 @@ func DetermineEncoding(content []byte, contentType string) (e encoding.Encoding,
 		}
 	}
 
-	// Try to detect UTF-8.
+	if looksLikeUTF8(content) {
+		return encoding.Nop, "utf-8", false
+	}
+
+	// TODO: change default depending on user's locale?
+	return charmap.Windows1252, "windows-1252", false
+}
+
+// Confidence is how certain a detected encoding is, as defined by the
+// HTML standard's encoding sniffing algorithm.
+type Confidence int
+
+const (
+	// Tentative means the encoding was inferred from the content,
+	// and a parser may change it if the document declares another.
+	Tentative Confidence = iota
+	// Certain means the encoding was given by a byte order mark or
+	// by the transport layer, and takes precedence over the document.
+	Certain
+)
+
+func (c Confidence) String() string {
+	if c == Certain {
+		return "certain"
+	}
+	return "tentative"
+}
+
+// Sniff determines the encoding of an HTML document in the same way as
+// DetermineEncoding, but returns only the encoding's canonical name and
+// the confidence of the result, for callers which decode the content
+// themselves.
+func Sniff(content []byte, contentType string) (name string, confidence Confidence) {
+	_, name, certain := DetermineEncoding(content, contentType)
+	if certain {
+		return name, Certain
+	}
+	return name, Tentative
+}
+
+// SniffBOM reports the encoding indicated by a byte order mark at the
+// start of content, and the length of the mark. It returns the empty
+// string and zero if content does not start with a byte order mark.
+func SniffBOM(content []byte) (name string, n int) {
+	for _, b := range boms {
+		if bytes.HasPrefix(content, b.bom) {
+			return b.enc, len(b.bom)
+		}
+	}
+	return "", 0
+}
+
+// ContentTypeCharset returns the canonical name of the encoding given by
+// the charset parameter of a Content-Type header value, such as
+// "text/html; charset=latin1". It returns the empty string if there is
+// no charset parameter, or its value is not a known encoding label.
+func ContentTypeCharset(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	cs, ok := params["charset"]
+	if !ok {
+		return ""
+	}
+	_, name := Lookup(cs)
+	return name
+}
+
+// PrescanMeta runs the HTML standard's prescan algorithm over the first
+// 1024 bytes of content, and returns the canonical name of the encoding
+// declared by a <meta charset> or <meta http-equiv="Content-Type">
+// element, or the empty string if there is none. A declared UTF-16
+// encoding is reported as "utf-8", as the standard requires.
+func PrescanMeta(content []byte) string {
+	if len(content) > 1024 {
+		content = content[:1024]
+	}
+	_, name := prescan(content)
+	return name
+}
+
+// looksLikeUTF8 reports whether content contains non-ASCII bytes
+// and is valid UTF-8, ignoring any partial rune at the end.
+func looksLikeUTF8(content []byte) bool {
 	// First eliminate any partial rune at the end.
 	for i := len(content) - 1; i >= 0 && i > len(content)-4; i-- {
 		b := content[i]
@@ -95,12 +174,7 @@ func DetermineEncoding(content []byte, contentType string) (e encoding.Encoding,
 			break
 		}
 	}
-	if hasHighBit && utf8.Valid(content) {
-		return encoding.Nop, "utf-8", false
-	}
-
-	// TODO: change default depending on user's locale?
-	return charmap.Windows1252, "windows-1252", false
+	return hasHighBit && utf8.Valid(content)
 }
 
 // NewReader returns an io.Reader that converts the content of r to UTF-8.
@@ -157,6 +157,40 @@ func TestSniff(t *testing.T) {
 	}
 }
 
+func TestSniffParts(t *testing.T) {
+	for _, tc := range []struct {
+		content     string
+		contentType string
+		bom         string
+		bomLen      int
+		header      string
+		meta        string
+		name        string
+		confidence  Confidence
+	}{
+		{"\xef\xbb\xbf<p>x", "text/html; charset=latin1", "utf-8", 3, "windows-1252", "", "utf-8", Certain},
+		{"\xff\xfe<\x00", "", "utf-16le", 2, "", "", "utf-16le", Certain},
+		{`<meta charset="shift_jis">`, "text/html; charset=latin1", "", 0, "windows-1252", "shift_jis", "windows-1252", Certain},
+		{`<meta http-equiv="Content-Type" content="text/html; charset=koi8-r">`, "text/html", "", 0, "", "koi8-r", "koi8-r", Tentative},
+		{`<meta charset="utf-16">`, "", "", 0, "", "utf-8", "utf-8", Tentative},
+		{"r\xc3\xa9sum\xc3\xa9", "", "", 0, "", "", "utf-8", Tentative},
+		{"plain", "text/html; charset=bogus", "", 0, "", "", "windows-1252", Tentative},
+	} {
+		if bom, n := SniffBOM([]byte(tc.content)); bom != tc.bom || n != tc.bomLen {
+			t.Errorf("SniffBOM(%q) = %q, %v; want %q, %v", tc.content, bom, n, tc.bom, tc.bomLen)
+		}
+		if got := ContentTypeCharset(tc.contentType); got != tc.header {
+			t.Errorf("ContentTypeCharset(%q) = %q, want %q", tc.contentType, got, tc.header)
+		}
+		if got := PrescanMeta([]byte(tc.content)); got != tc.meta {
+			t.Errorf("PrescanMeta(%q) = %q, want %q", tc.content, got, tc.meta)
+		}
+		if name, c := Sniff([]byte(tc.content), tc.contentType); name != tc.name || c != tc.confidence {
+			t.Errorf("Sniff(%q, %q) = %q, %v; want %q, %v", tc.content, tc.contentType, name, c, tc.name, tc.confidence)
+		}
+	}
+}
+
 func TestReader(t *testing.T) {
 	switch runtime.GOOS {
 	case "nacl": // platforms that don't permit direct file system access
@@ -0,0 +1,402 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	a "golang.org/x/net/html/atom"
+)
+
+// ErrReparseUnsupported is returned by ReparseChildren when parsing the
+// new content in isolation might not give the same tree as parsing the
+// whole edited document. The caller should parse the whole document.
+var ErrReparseUnsupported = errors.New("html: edit requires a full reparse")
+
+// reparseContexts are the elements whose contents ReparseChildren
+// may reparse. The contents of these elements are parsed the same
+// way regardless of their surroundings, provided that the contents
+// do not close or leave open any elements outside themselves.
+var reparseContexts = map[a.Atom]bool{
+	a.Address:    true,
+	a.Article:    true,
+	a.Aside:      true,
+	a.Blockquote: true,
+	a.Body:       true,
+	a.Center:     true,
+	a.Details:    true,
+	a.Dialog:     true,
+	a.Div:        true,
+	a.Fieldset:   true,
+	a.Figcaption: true,
+	a.Figure:     true,
+	a.Footer:     true,
+	a.Header:     true,
+	a.Hgroup:     true,
+	a.Main:       true,
+	a.Menu:       true,
+	a.Nav:        true,
+	a.Ol:         true,
+	a.Section:    true,
+	a.Summary:    true,
+	a.Ul:         true,
+}
+
+// ReparseChildren replaces the children of n with the result of parsing
+// content as n's new contents, as when a tool edits the part of a
+// document between n's start and end tags. n must be an element of a
+// tree returned by Parse.
+//
+// Only n's subtree is parsed. Children of n which parse identically
+// before and after the edit, at the start and end of n's contents, are
+// kept rather than replaced, so that references to them held by the
+// caller remain valid.
+//
+// If the HTML parsing algorithm could give a different result for the
+// whole edited document than for content in isolation, such as when n
+// is inside a table, or content contains an end tag for an element it
+// did not open, ReparseChildren returns ErrReparseUnsupported and
+// leaves n unchanged. Since the tree does not record which formatting
+// elements were still active where n started, ReparseChildren also
+// returns ErrReparseUnsupported if any formatting element, such as <b>,
+// precedes n in the document. ReparseEdit, which is given the
+// document's source, has no such restriction.
+func ReparseChildren(n *Node, content []byte, opts ...ParseOption) error {
+	if mayHaveActiveFormatting(n) {
+		return ErrReparseUnsupported
+	}
+	return reparseChildren(n, content, opts...)
+}
+
+// ReparseEdit applies an edit to the document doc, which was parsed
+// from src with the same options: it replaces src[start:end] with repl.
+// It returns the edited source.
+//
+// Only the contents of the innermost element which encloses the edit,
+// and whose contents may be reparsed in isolation, are parsed again,
+// with ReparseChildren. The part of src before that element's end tag
+// is tokenized to find the element, but the rest of the document is
+// not. If no enclosing element may be reparsed, ReparseEdit returns
+// ErrReparseUnsupported and leaves doc unchanged; the caller should
+// parse the whole edited document.
+func ReparseEdit(doc *Node, src []byte, start, end int, repl []byte, opts ...ParseOption) ([]byte, error) {
+	if start < 0 || start > end || end > len(src) {
+		return nil, errors.New("html: ReparseEdit range out of bounds")
+	}
+	n, contentStart, contentEnd, err := findEditContext(doc, src, start, end, opts)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, 0, contentEnd-contentStart-(end-start)+len(repl))
+	content = append(content, src[contentStart:start]...)
+	content = append(content, repl...)
+	content = append(content, src[end:contentEnd]...)
+	if err := reparseChildren(n, content, opts...); err != nil {
+		return nil, err
+	}
+	edited := make([]byte, 0, len(src)-(end-start)+len(repl))
+	edited = append(edited, src[:start]...)
+	edited = append(edited, repl...)
+	edited = append(edited, src[end:]...)
+	return edited, nil
+}
+
+// editContext is an element whose contents ReparseEdit might reparse.
+type editContext struct {
+	n            *Node
+	contentStart int  // offset in the source of the end of n's start tag
+	clean        bool // no formatting elements were active when n was opened
+}
+
+// findEditContext parses src as far as needed to find the element of
+// doc whose contents ReparseEdit should reparse for an edit of
+// src[start:end], and returns the element and the offsets in src of
+// its contents.
+func findEditContext(doc *Node, src []byte, start, end int, opts []ParseOption) (n *Node, contentStart, contentEnd int, err error) {
+	p := &parser{
+		tokenizer: NewTokenizer(bytes.NewReader(src)),
+		doc: &Node{
+			Type: DocumentNode,
+		},
+		scripting:  true,
+		framesetOK: true,
+		im:         initialIM,
+	}
+	for _, f := range opts {
+		f(p)
+	}
+
+	opened := make(map[*Node]*editContext)
+	var (
+		cands    []*editContext // contexts open at start, innermost first
+		atStart  bool
+		pos      int
+		tokStart int
+	)
+	for {
+		top := p.oe.top()
+		p.tokenizer.AllowCDATA(top != nil && top.Namespace != "")
+		p.tokenizer.Next()
+		tokStart, pos = pos, pos+len(p.tokenizer.Raw())
+		p.tok = p.tokenizer.Token()
+		if p.tok.Type == ErrorToken {
+			// The enclosing elements are not all closed by end tags.
+			return nil, 0, 0, ErrReparseUnsupported
+		}
+		if !atStart && pos > start {
+			// This token is the first one which is not
+			// entirely before the edit.
+			atStart = true
+			for i := len(p.oe) - 1; i >= 0; i-- {
+				if c := opened[p.oe[i]]; c != nil {
+					cands = append(cands, c)
+				}
+			}
+		}
+		p.parseCurrentToken()
+
+		if !atStart {
+			n := p.oe.top()
+			if n != nil && opened[n] == nil && n.Type == ElementNode &&
+				n.Namespace == "" && reparseContexts[n.DataAtom] {
+				opened[n] = &editContext{
+					n:            n,
+					contentStart: pos,
+					clean:        len(p.afe) == 0,
+				}
+			}
+			continue
+		}
+		// Drop the candidates closed by this token. Only the
+		// outermost of them can have been closed by its end tag.
+		closed := 0
+		for closed < len(cands) && p.oe.index(cands[closed].n) < 0 {
+			closed++
+		}
+		if closed == 0 {
+			continue
+		}
+		c := cands[closed-1]
+		if c.clean && tokStart >= end &&
+			p.tok.Type == EndTagToken && p.tok.Data == c.n.Data {
+			n := matchingNode(doc, c.n)
+			if n == nil {
+				return nil, 0, 0, ErrReparseUnsupported
+			}
+			return n, c.contentStart, tokStart, nil
+		}
+		cands = cands[closed:]
+		if len(cands) == 0 {
+			return nil, 0, 0, ErrReparseUnsupported
+		}
+	}
+}
+
+// matchingNode returns the node of doc at the same position as n in
+// the tree containing it, or nil if the node there is not like n.
+func matchingNode(doc, n *Node) *Node {
+	var path []int
+	for c := n; c.Parent != nil; c = c.Parent {
+		i := 0
+		for s := c.PrevSibling; s != nil; s = s.PrevSibling {
+			i++
+		}
+		path = append(path, i)
+	}
+	m := doc
+	for j := len(path) - 1; j >= 0 && m != nil; j-- {
+		m = m.FirstChild
+		for i := path[j]; i > 0 && m != nil; i-- {
+			m = m.NextSibling
+		}
+	}
+	if m == nil || m.Type != n.Type || m.DataAtom != n.DataAtom || m.Data != n.Data ||
+		m.Namespace != n.Namespace || len(m.Attr) != len(n.Attr) {
+		return nil
+	}
+	for i := range m.Attr {
+		if m.Attr[i] != n.Attr[i] {
+			return nil
+		}
+	}
+	return m
+}
+
+func reparseChildren(n *Node, content []byte, opts ...ParseOption) error {
+	if !canReparse(n) || !selfContained(content) {
+		return ErrReparseUnsupported
+	}
+	nodes, err := ParseFragmentWithOptions(bytes.NewReader(content), n, opts...)
+	if err != nil {
+		return err
+	}
+
+	var old []*Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		old = append(old, c)
+	}
+	// Keep the longest unchanged prefix and suffix of the old children.
+	prefix := 0
+	for prefix < len(old) && prefix < len(nodes) && nodesEqual(old[prefix], nodes[prefix]) {
+		nodes[prefix] = old[prefix]
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(nodes)-prefix &&
+		nodesEqual(old[len(old)-1-suffix], nodes[len(nodes)-1-suffix]) {
+		nodes[len(nodes)-1-suffix] = old[len(old)-1-suffix]
+		suffix++
+	}
+	for _, c := range old {
+		n.RemoveChild(c)
+	}
+	for _, c := range nodes {
+		n.AppendChild(c)
+	}
+	return nil
+}
+
+// canReparse reports whether n and its ancestors permit its contents
+// to be parsed in isolation.
+func canReparse(n *Node) bool {
+	if n.Type != ElementNode || n.Namespace != "" || !reparseContexts[n.DataAtom] {
+		return false
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		switch {
+		case p.Type == DocumentNode:
+			return true
+		case p.Type != ElementNode || p.Namespace != "":
+			return false
+		case p.DataAtom == a.Html:
+		case !reparseContexts[p.DataAtom]:
+			return false
+		}
+	}
+	// n is not part of a document.
+	return false
+}
+
+// mayHaveActiveFormatting reports whether the list of active formatting
+// elements might not have been empty when n was opened: whether any
+// formatting element precedes n in its document.
+func mayHaveActiveFormatting(n *Node) bool {
+	for c := n; c != nil; c = c.Parent {
+		for s := c.PrevSibling; s != nil; s = s.PrevSibling {
+			if containsFormatting(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsFormatting reports whether the tree rooted at n contains a
+// formatting element.
+func containsFormatting(n *Node) bool {
+	if n.Type == ElementNode && n.Namespace == "" {
+		switch n.DataAtom {
+		case a.A, a.B, a.Big, a.Code, a.Em, a.Font, a.I, a.Nobr, a.S, a.Small, a.Strike, a.Strong, a.Tt, a.U:
+			return true
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if containsFormatting(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// impliedEndTags are the elements which may be left open at the end of
+// a reparsed element's contents, since the element's end tag closes
+// them without other effects.
+var impliedEndTags = map[a.Atom]bool{
+	a.Dd:       true,
+	a.Dt:       true,
+	a.Li:       true,
+	a.Optgroup: true,
+	a.Option:   true,
+	a.P:        true,
+	a.Rb:       true,
+	a.Rp:       true,
+	a.Rt:       true,
+	a.Rtc:      true,
+}
+
+// selfContained reports whether content closes only elements it opens,
+// leaves open only elements closed implicitly by its parent's end tag,
+// and does not contain elements handled specially at the document level.
+func selfContained(content []byte) bool {
+	var open []string
+	z := NewTokenizer(bytes.NewReader(content))
+	for {
+		switch z.Next() {
+		case ErrorToken:
+			if z.Err() != io.EOF {
+				return false
+			}
+			for _, name := range open {
+				if !impliedEndTags[a.Lookup([]byte(name))] {
+					return false
+				}
+			}
+			return true
+		case StartTagToken:
+			name, _ := z.TagName()
+			switch a.Lookup(name) {
+			case a.Html, a.Head, a.Body, a.Frameset, a.Form, a.Template,
+				a.Table, a.Caption, a.Colgroup, a.Col, a.Tbody, a.Thead,
+				a.Tfoot, a.Tr, a.Td, a.Th, a.Select, a.Svg, a.Math:
+				// Elements with effects beyond their own contents,
+				// or which are parsed differently within them.
+				return false
+			}
+			if !voidElements[string(name)] {
+				open = append(open, string(name))
+			}
+		case EndTagToken:
+			name, _ := z.TagName()
+			i := len(open) - 1
+			for i >= 0 && open[i] != string(name) {
+				i--
+			}
+			if i < 0 {
+				return false
+			}
+			// Elements closed implicitly by this end tag must be
+			// closed without other effects.
+			for _, name := range open[i+1:] {
+				if !impliedEndTags[a.Lookup([]byte(name))] {
+					return false
+				}
+			}
+			open = open[:i]
+		}
+	}
+}
+
+// nodesEqual reports whether the trees rooted at x and y are the same,
+// ignoring their positions in their parents.
+func nodesEqual(x, y *Node) bool {
+	if x.Type != y.Type || x.DataAtom != y.DataAtom || x.Data != y.Data ||
+		x.Namespace != y.Namespace || len(x.Attr) != len(y.Attr) {
+		return false
+	}
+	for i := range x.Attr {
+		if x.Attr[i] != y.Attr[i] {
+			return false
+		}
+	}
+	xc, yc := x.FirstChild, y.FirstChild
+	for ; xc != nil && yc != nil; xc, yc = xc.NextSibling, yc.NextSibling {
+		if !nodesEqual(xc, yc) {
+			return false
+		}
+	}
+	return xc == nil && yc == nil
+}
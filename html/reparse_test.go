@@ -0,0 +1,145 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReparseChildren(t *testing.T) {
+	const before, after = `<!DOCTYPE html><body><p>intro<div id=x>`, `</div><p>outro`
+	for _, test := range []struct {
+		old, new string
+		wantErr  bool
+	}{
+		{"<p>one<p>two", "<p>one<p>2<p>three", false},
+		{"text", "<b>bold</b> text", false},
+		{"<ul><li>a<li>b</ul>", "<ul><li>a<li>b<li>c</ul>", false},
+		{"<span>a</span>", "<my-widget x=1>a</my-widget>", false},
+		{"", "<script>if (a</b) {}</script>", false},
+		{"<p>a", "a</div>b", true},        // closes the context
+		{"<p>a", "<b>unclosed", true},     // formatting element left open
+		{"<p>a", "<b><i>a</b></i>", true}, // misnested formatting
+		{"<p>a", "<table><tr><td>x</table>", true},
+	} {
+		doc, err := Parse(strings.NewReader(before + test.old + after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		div := findByID(doc, "x")
+		var oldFirst *Node = div.FirstChild
+		err = ReparseChildren(div, []byte(test.new))
+		if test.wantErr {
+			if err != ErrReparseUnsupported {
+				t.Errorf("ReparseChildren(%q) = %v, want ErrReparseUnsupported", test.new, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReparseChildren(%q) = %v", test.new, err)
+			continue
+		}
+		want, err := Parse(strings.NewReader(before + test.new + after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := renderString(doc), renderString(want); got != want {
+			t.Errorf("ReparseChildren(%q):\ngot  %q\nwant %q", test.new, got, want)
+		}
+		if oldFirst != nil && div.FirstChild != nil && nodesEqual(oldFirst, div.FirstChild) && oldFirst != div.FirstChild {
+			t.Errorf("ReparseChildren(%q) replaced unchanged first child", test.new)
+		}
+	}
+}
+
+func TestReparseChildrenContext(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<table><tr><td><div id=x>a</div></table><p><span id=y>b</span>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"x", "y"} {
+		if err := ReparseChildren(findByID(doc, id), []byte("c")); err != ErrReparseUnsupported {
+			t.Errorf("ReparseChildren(#%v) = %v, want ErrReparseUnsupported", id, err)
+		}
+	}
+}
+
+func TestReparseChildrenActiveFormatting(t *testing.T) {
+	// The <b> is still active when the div is opened, so a full parse
+	// puts the div's text inside a copy of it.
+	doc, err := Parse(strings.NewReader(`<p><b>x</p><div id=x>old</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReparseChildren(findByID(doc, "x"), []byte("new")); err != ErrReparseUnsupported {
+		t.Errorf("ReparseChildren = %v, want ErrReparseUnsupported", err)
+	}
+}
+
+func TestReparseEdit(t *testing.T) {
+	for _, test := range []struct {
+		src, old, new string
+		wantErr       bool
+	}{
+		{`<div id=x><p>one<p>two</div><p>outro`, "two", "2<p>three", false},
+		{`<div id=x>a<div id=y>b</div>c</div>`, "b", "<em>b</em>", false},
+		{`<div id=x>a<div id=y>b</div>c</div>`, "b</div>c", "B</div>C", false},
+		{`<b>x</b><div id=x>old</div>`, "old", "new", false},
+		{`<p><b>x</p><div id=x>old</div>`, "old", "new", true},
+		{`<div id=x>a</div>`, "a", "</div>", true},
+		{`<table><tr><td><div id=x>a</div></table>`, "a", "b", true},
+		{`<div id=x>a`, "a", "b", true}, // no end tag
+	} {
+		doc, err := Parse(strings.NewReader(test.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := strings.Index(test.src, test.old)
+		end := start + len(test.old)
+		got, err := ReparseEdit(doc, []byte(test.src), start, end, []byte(test.new))
+		if test.wantErr {
+			if err != ErrReparseUnsupported {
+				t.Errorf("ReparseEdit(%q, %q -> %q) = %v, want ErrReparseUnsupported", test.src, test.old, test.new, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ReparseEdit(%q, %q -> %q) = %v", test.src, test.old, test.new, err)
+			continue
+		}
+		wantSrc := test.src[:start] + test.new + test.src[end:]
+		if string(got) != wantSrc {
+			t.Errorf("ReparseEdit(%q, %q -> %q) source = %q, want %q", test.src, test.old, test.new, got, wantSrc)
+		}
+		want, err := Parse(strings.NewReader(wantSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := renderString(doc), renderString(want); got != want {
+			t.Errorf("ReparseEdit(%q, %q -> %q):\ngot  %q\nwant %q", test.src, test.old, test.new, got, want)
+		}
+	}
+}
+
+func findByID(n *Node, id string) *Node {
+	for _, a := range n.Attr {
+		if a.Key == "id" && a.Val == id {
+			return n
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findByID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func renderString(n *Node) string {
+	var b strings.Builder
+	Render(&b, n)
+	return b.String()
+}
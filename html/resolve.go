@@ -0,0 +1,273 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"net/url"
+	"strings"
+
+	a "golang.org/x/net/html/atom"
+)
+
+// urlAttrKind is how an attribute's value holds URLs.
+type urlAttrKind int
+
+const (
+	urlAttrURL    urlAttrKind = iota + 1 // the value is a URL
+	urlAttrSrcset                        // a srcset list of image candidates
+	urlAttrStyle                         // CSS declarations, with url() references
+)
+
+// urlAttrs are the attributes of HTML elements which RewriteURLs
+// rewrites.
+var urlAttrs = map[string]urlAttrKind{
+	"action":      urlAttrURL,
+	"background":  urlAttrURL,
+	"cite":        urlAttrURL,
+	"formaction":  urlAttrURL,
+	"href":        urlAttrURL,
+	"imagesrcset": urlAttrSrcset,
+	"poster":      urlAttrURL,
+	"src":         urlAttrURL,
+	"srcset":      urlAttrSrcset,
+	"style":       urlAttrStyle,
+}
+
+// RewriteURLs replaces the URLs in the attributes of the HTML elements
+// in the tree rooted at n with the result of calling rewrite, which is
+// passed the element, the attribute's name, and the URL as it appears
+// in the document.
+//
+// The rewritten attributes are action, background, cite, formaction,
+// href, poster and src, whose values are URLs; srcset and imagesrcset,
+// whose values are lists of image candidates, each with a URL; and
+// style, whose value may contain CSS url() references. In srcset
+// lists and style values, only the URLs are replaced, and the rest of
+// the value is kept as it was.
+//
+// Elements in foreign content, such as SVG, are not rewritten.
+func RewriteURLs(n *Node, rewrite func(el *Node, attr, ref string) string) {
+	if n.Type == ElementNode && n.Namespace == "" {
+		for i := range n.Attr {
+			attr := &n.Attr[i]
+			if attr.Namespace != "" {
+				continue
+			}
+			fn := func(ref string) string { return rewrite(n, attr.Key, ref) }
+			switch urlAttrs[attr.Key] {
+			case urlAttrURL:
+				attr.Val = fn(attr.Val)
+			case urlAttrSrcset:
+				attr.Val = rewriteSrcset(attr.Val, fn)
+			case urlAttrStyle:
+				attr.Val = rewriteCSSURLs(attr.Val, fn)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		RewriteURLs(c, rewrite)
+	}
+}
+
+// ResolveURLs makes the URLs in the attributes of the HTML elements in
+// the tree rooted at n absolute, as described for RewriteURLs.
+//
+// URLs are resolved against the document's base URL: the href of the
+// first base element in the tree which has one, itself resolved
+// against base, or base if there is no such element. The URL of the
+// document is usually the right choice for base. If base is nil, and
+// the tree has no base element with an absolute href, ResolveURLs does
+// nothing.
+//
+// The hrefs of base elements are themselves resolved against base,
+// and left as they are if base is nil. URLs which cannot be parsed are
+// left as they are.
+func ResolveURLs(n *Node, base *url.URL) {
+	docURL := base
+	base = documentBase(n, docURL)
+	if base == nil {
+		return
+	}
+	RewriteURLs(n, func(el *Node, attr, ref string) string {
+		against := base
+		if el.DataAtom == a.Base && attr == "href" {
+			// The base URL comes from this href, so resolving it
+			// against the base URL would apply it twice.
+			if docURL == nil {
+				return ref
+			}
+			against = docURL
+		}
+		u, err := url.Parse(strings.Trim(ref, whitespace))
+		if err != nil {
+			return ref
+		}
+		return against.ResolveReference(u).String()
+	})
+}
+
+// documentBase returns the base URL of the tree rooted at n,
+// for a document whose URL is docURL.
+func documentBase(n *Node, docURL *url.URL) *url.URL {
+	el := findBase(n)
+	if el == nil {
+		return docURL
+	}
+	var href string
+	for _, attr := range el.Attr {
+		if attr.Namespace == "" && attr.Key == "href" {
+			href = attr.Val
+		}
+	}
+	u, err := url.Parse(strings.Trim(href, whitespace))
+	if err != nil {
+		return docURL
+	}
+	if docURL != nil {
+		return docURL.ResolveReference(u)
+	}
+	if u.IsAbs() {
+		return u
+	}
+	return nil
+}
+
+// findBase returns the first base element with an href attribute in
+// the tree rooted at n, in document order, or nil.
+func findBase(n *Node) *Node {
+	if n.Type == ElementNode && n.DataAtom == a.Base && n.Namespace == "" {
+		for _, attr := range n.Attr {
+			if attr.Namespace == "" && attr.Key == "href" {
+				return n
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if el := findBase(c); el != nil {
+			return el
+		}
+	}
+	return nil
+}
+
+// rewriteSrcset replaces the URL of each image candidate in the srcset
+// attribute value s with rewrite(URL).
+//
+// The candidates are split as by the HTML parsing algorithm for srcset
+// attributes: a URL runs up to the next whitespace, so it may contain
+// commas, except for commas which end it; and the descriptors which
+// follow it run to the next comma outside parentheses.
+func rewriteSrcset(s string, rewrite func(string) string) string {
+	var b strings.Builder
+	last := 0 // end of the text copied to b
+	i := 0
+	for {
+		// Skip whitespace and commas before the candidate.
+		for i < len(s) && (isHTMLSpace(s[i]) || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		start := i
+		for i < len(s) && !isHTMLSpace(s[i]) {
+			i++
+		}
+		end := i
+		endsWithComma := s[end-1] == ','
+		for end > start && s[end-1] == ',' {
+			end--
+		}
+		if end > start {
+			b.WriteString(s[last:start])
+			b.WriteString(rewrite(s[start:end]))
+			last = end
+		}
+		if endsWithComma {
+			continue
+		}
+		// Skip the descriptors.
+		parens := false
+		for ; i < len(s); i++ {
+			c := s[i]
+			if parens {
+				if c == ')' {
+					parens = false
+				}
+				continue
+			}
+			if c == '(' {
+				parens = true
+			} else if c == ',' {
+				break
+			}
+		}
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isHTMLSpace(c byte) bool {
+	return strings.IndexByte(whitespace, c) >= 0
+}
+
+// rewriteCSSURLs replaces the URL of each url() reference in the CSS
+// text s with rewrite(URL). References containing CSS escapes are
+// left as they are.
+func rewriteCSSURLs(s string, rewrite func(string) string) string {
+	var b strings.Builder
+	last := 0 // end of the text copied to b
+	for i := 0; i+len("url(") <= len(s); {
+		if !strings.EqualFold(s[i:i+len("url(")], "url(") || i > 0 && isCSSNameByte(s[i-1]) {
+			i++
+			continue
+		}
+		i += len("url(")
+		for i < len(s) && isHTMLSpace(s[i]) {
+			i++
+		}
+		var quote byte
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote = s[i]
+			i++
+		}
+		start := i
+		for i < len(s) {
+			c := s[i]
+			if quote != 0 && c == quote || quote == 0 && (c == ')' || isHTMLSpace(c)) {
+				break
+			}
+			i++
+		}
+		end := i
+		if i >= len(s) || end == start || strings.IndexByte(s[start:end], '\\') >= 0 {
+			continue
+		}
+		ref := rewrite(s[start:end])
+		if quote == 0 && strings.ContainsAny(ref, "()'\" \t\r\n\f\\") {
+			ref = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(ref) + `"`
+		} else if quote != 0 && strings.ContainsAny(ref, string(quote)+"\\\n") {
+			ref = strings.NewReplacer(`\`, `\\`, string(quote), `\`+string(quote), "\n", `\a `).Replace(ref)
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(ref)
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// isCSSNameByte reports whether c may be part of a CSS identifier,
+// so that "url(" preceded by c is not a url() reference.
+func isCSSNameByte(c byte) bool {
+	return c == '-' || c == '_' || c >= 0x80 ||
+		'0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRewriteSrcset(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"a.png", "[a.png]"},
+		{"a.png 1x, b.png 2x", "[a.png] 1x, [b.png] 2x"},
+		{"  a.png  1x ,b.png 2x  ", "  [a.png]  1x ,[b.png] 2x  "},
+		{"a.png,b.png 2x", "[a.png,b.png] 2x"},
+		{"a.png,,, b.png", "[a.png],,, [b.png]"},
+		{"a,b.png 100w", "[a,b.png] 100w"},
+		{"data:image/png;base64,AAAA 1x, b.png 2x", "[data:image/png;base64,AAAA] 1x, [b.png] 2x"},
+		{"a.png foo(1,2), b.png", "[a.png] foo(1,2), [b.png]"},
+		{"", ""},
+		{" , ", " , "},
+	} {
+		got := rewriteSrcset(test.in, func(ref string) string { return "[" + ref + "]" })
+		if got != test.want {
+			t.Errorf("rewriteSrcset(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRewriteCSSURLs(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"background: url(a.png)", "background: url([a.png])"},
+		{"background: URL( 'a.png' ) no-repeat", "background: URL( '[a.png]' ) no-repeat"},
+		{`background: url("a b.png"), url(c.png)`, `background: url("[a b.png]"), url([c.png])`},
+		{"color: red", "color: red"},
+		{"--my-url(a.png)", "--my-url(a.png)"},
+		{`url(a\).png)`, `url(a\).png)`},
+		{"url(a.png", "url(a.png"},
+	} {
+		got := rewriteCSSURLs(test.in, func(ref string) string { return "[" + ref + "]" })
+		if got != test.want {
+			t.Errorf("rewriteCSSURLs(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	// Rewritten URLs are quoted or escaped as needed.
+	for _, test := range []struct {
+		in, ref, want string
+	}{
+		{"url(a)", "x y", `url("x y")`},
+		{"url(a)", `x"y`, `url("x\"y")`},
+		{"url('a')", "x'y", `url('x\'y')`},
+		{`url("a")`, "x'y", `url("x'y")`},
+	} {
+		got := rewriteCSSURLs(test.in, func(string) string { return test.ref })
+		if got != test.want {
+			t.Errorf("rewriteCSSURLs(%q) to %q = %q, want %q", test.in, test.ref, got, test.want)
+		}
+	}
+}
+
+func TestResolveURLs(t *testing.T) {
+	const doc = `<html><head><base href="/static/"><base href="/ignored/"></head><body>` +
+		`<a href="page.html#top">x</a>` +
+		`<a href="https://other.example/">y</a>` +
+		`<img src=" img.png " srcset="small.png 1x, ../large.png 2x">` +
+		`<div style="background: url('bg.png')"></div>` +
+		`<form action="?q=1"></form>` +
+		`<svg><a href="svg.html"></a></svg>` +
+		`<a href="http://[::1]:namedport">bad</a>` +
+		`</body></html>`
+	n, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://example.com/dir/index.html")
+	ResolveURLs(n, base)
+
+	var b strings.Builder
+	if err := Render(&b, n); err != nil {
+		t.Fatal(err)
+	}
+	want := `<html><head><base href="https://example.com/static/"/><base href="https://example.com/ignored/"/></head><body>` +
+		`<a href="https://example.com/static/page.html#top">x</a>` +
+		`<a href="https://other.example/">y</a>` +
+		`<img src="https://example.com/static/img.png" srcset="https://example.com/static/small.png 1x, https://example.com/large.png 2x"/>` +
+		`<div style="background: url(&#39;https://example.com/static/bg.png&#39;)"></div>` +
+		`<form action="https://example.com/static/?q=1"></form>` +
+		`<svg><a href="svg.html"></a></svg>` +
+		`<a href="http://[::1]:namedport">bad</a>` +
+		`</body></html>`
+	if got := b.String(); got != want {
+		t.Errorf("ResolveURLs:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestResolveURLsBaseHref(t *testing.T) {
+	// The base element's href is resolved against the document URL,
+	// not against itself.
+	for _, test := range []struct {
+		doc    string
+		docURL string
+		want   string
+	}{{
+		doc:    `<base href="sub/"><a href="page.html">x</a>`,
+		docURL: "https://example.com/dir/index.html",
+		want: `<html><head><base href="https://example.com/dir/sub/"/></head>` +
+			`<body><a href="https://example.com/dir/sub/page.html">x</a></body></html>`,
+	}, {
+		// Without a document URL, a relative base href gives no
+		// base URL, and nothing is resolved.
+		doc:  `<base href="sub/"><a href="page.html">x</a>`,
+		want: `<html><head><base href="sub/"/></head><body><a href="page.html">x</a></body></html>`,
+	}, {
+		doc: `<base href="https://example.com/static/"><a href="page.html">x</a>`,
+		want: `<html><head><base href="https://example.com/static/"/></head>` +
+			`<body><a href="https://example.com/static/page.html">x</a></body></html>`,
+	}} {
+		n, err := Parse(strings.NewReader(test.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var docURL *url.URL
+		if test.docURL != "" {
+			docURL, _ = url.Parse(test.docURL)
+		}
+		ResolveURLs(n, docURL)
+		var b strings.Builder
+		if err := Render(&b, n); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("ResolveURLs(%q, %q):\ngot  %v\nwant %v", test.doc, test.docURL, got, test.want)
+		}
+	}
+}
+
+func TestResolveURLsNoBase(t *testing.T) {
+	const doc = `<a href="page.html">x</a>`
+	n, err := Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResolveURLs(n, nil)
+	var b strings.Builder
+	if err := Render(&b, n); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), `<html><head></head><body><a href="page.html">x</a></body></html>`; got != want {
+		t.Errorf("ResolveURLs with no base:\ngot  %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"strconv"
+	"strings"
+
+	a "golang.org/x/net/html/atom"
+)
+
+// TextOption configures ExtractText.
+type TextOption func(w *textWriter)
+
+// TextOptionLinkFootnotes configures whether ExtractText follows the
+// text of each link with a bracketed number, such as "[1]", and lists
+// the links' URLs under those numbers at the end of the text. Links to
+// fragments of the same document are not numbered.
+//
+// By default, links are not numbered.
+func TextOptionLinkFootnotes(enable bool) TextOption {
+	return func(w *textWriter) {
+		w.footnotes = enable
+	}
+}
+
+// ExtractText returns the text of the tree rooted at n, as it would be
+// read in a browser with styles disabled.
+//
+// Runs of whitespace in text are collapsed to a single space, except
+// inside pre and textarea elements. Block elements, such as div and
+// li, start on a new line, and paragraphs, headings, lists and tables
+// are separated by a blank line. A br element starts a new line, and
+// the cells of a table row are separated by tabs. An img element is
+// replaced by its alt text.
+//
+// The contents of the head, script, style, template and noscript
+// elements, of elements with the hidden attribute, and of comments,
+// are omitted.
+func ExtractText(n *Node, opts ...TextOption) string {
+	w := &textWriter{}
+	for _, f := range opts {
+		f(w)
+	}
+	w.walk(n)
+	s := strings.Trim(w.b.String(), "\n")
+	if len(w.links) == 0 {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(s)
+	b.WriteString("\n")
+	for i, link := range w.links {
+		b.WriteString("\n[")
+		b.WriteString(strconv.Itoa(i + 1))
+		b.WriteString("] ")
+		b.WriteString(link)
+	}
+	return b.String()
+}
+
+// textSkipped are the elements whose contents ExtractText omits.
+var textSkipped = map[a.Atom]bool{
+	a.Head:     true,
+	a.Noscript: true,
+	a.Script:   true,
+	a.Style:    true,
+	a.Template: true,
+}
+
+// textBreaks are the elements which ExtractText puts on lines of their
+// own, with the number of line breaks before and after them: 1 for
+// block elements, and 2 for paragraphs set off by blank lines.
+var textBreaks = map[a.Atom]int{
+	a.Address:    1,
+	a.Article:    1,
+	a.Aside:      1,
+	a.Caption:    1,
+	a.Center:     1,
+	a.Dd:         1,
+	a.Details:    1,
+	a.Dialog:     1,
+	a.Div:        1,
+	a.Dt:         1,
+	a.Fieldset:   1,
+	a.Figcaption: 1,
+	a.Footer:     1,
+	a.Form:       1,
+	a.Header:     1,
+	a.Hgroup:     1,
+	a.Legend:     1,
+	a.Li:         1,
+	a.Main:       1,
+	a.Nav:        1,
+	a.Option:     1,
+	a.Section:    1,
+	a.Summary:    1,
+	a.Tr:         1,
+
+	a.Blockquote: 2,
+	a.Dl:         2,
+	a.Figure:     2,
+	a.H1:         2,
+	a.H2:         2,
+	a.H3:         2,
+	a.H4:         2,
+	a.H5:         2,
+	a.H6:         2,
+	a.Ol:         2,
+	a.P:          2,
+	a.Pre:        2,
+	a.Table:      2,
+	a.Ul:         2,
+}
+
+// A textWriter accumulates the text of a tree for ExtractText.
+type textWriter struct {
+	footnotes bool // number links; see TextOptionLinkFootnotes
+
+	b       strings.Builder
+	newline int      // number of line breaks at the end of b
+	pending int      // line breaks owed before the next text
+	space   bool     // a space is owed before the next text
+	pre     int      // depth of elements whose whitespace is kept
+	links   []string // URLs of the numbered links
+}
+
+func (w *textWriter) walk(n *Node) {
+	switch n.Type {
+	case DocumentNode:
+		w.walkChildren(n)
+		return
+	case TextNode:
+		w.text(n.Data)
+		return
+	case ElementNode:
+	default:
+		return
+	}
+	if n.Namespace != "" {
+		w.walkChildren(n)
+		return
+	}
+	if textSkipped[n.DataAtom] || hasAttr(n, "hidden") {
+		return
+	}
+	switch n.DataAtom {
+	case a.Br:
+		w.lineBreak()
+		return
+	case a.Hr:
+		w.blockBreak(2)
+		return
+	case a.Img:
+		if alt, ok := getAttr(n, "alt"); ok {
+			w.text(alt)
+		}
+		return
+	case a.Td, a.Th:
+		if w.b.Len() > 0 && w.newline == 0 && prevCell(n) {
+			w.b.WriteByte('\t')
+			w.space = false
+		}
+	}
+
+	breaks := textBreaks[n.DataAtom]
+	if breaks == 2 && (n.DataAtom == a.Ul || n.DataAtom == a.Ol) &&
+		n.Parent != nil && n.Parent.DataAtom == a.Li {
+		// A nested list is part of its item.
+		breaks = 1
+	}
+	keepSpace := n.DataAtom == a.Pre || n.DataAtom == a.Textarea || n.DataAtom == a.Listing
+	w.blockBreak(breaks)
+	if keepSpace {
+		w.pre++
+	}
+	start := w.b.Len()
+	w.walkChildren(n)
+	if keepSpace {
+		w.pre--
+	}
+	w.blockBreak(breaks)
+
+	if n.DataAtom == a.A && w.footnotes && w.b.Len() > start {
+		if href, ok := getAttr(n, "href"); ok {
+			href = strings.Trim(href, whitespace)
+			if href != "" && href[0] != '#' {
+				w.links = append(w.links, href)
+				w.flush()
+				w.b.WriteString("[" + strconv.Itoa(len(w.links)) + "]")
+			}
+		}
+	}
+}
+
+func (w *textWriter) walkChildren(n *Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// blockBreak ends the current line, with n line breaks in all
+// before the next text.
+func (w *textWriter) blockBreak(n int) {
+	if n == 0 {
+		return
+	}
+	if n > w.pending {
+		w.pending = n
+	}
+	w.space = false
+}
+
+// lineBreak adds a line break, as for a br element.
+func (w *textWriter) lineBreak() {
+	w.flush()
+	w.b.WriteByte('\n')
+	w.newline++
+	w.space = false
+}
+
+// flush writes the line breaks owed before the next text.
+func (w *textWriter) flush() {
+	if w.b.Len() > 0 {
+		for w.newline < w.pending {
+			w.b.WriteByte('\n')
+			w.newline++
+		}
+	}
+	w.pending = 0
+}
+
+// text adds the text s.
+func (w *textWriter) text(s string) {
+	if w.pre > 0 {
+		if s == "" {
+			return
+		}
+		w.flush()
+		if w.space {
+			w.b.WriteByte(' ')
+			w.space = false
+		}
+		w.b.WriteString(s)
+		if trimmed := strings.TrimRight(s, "\n"); trimmed == "" {
+			w.newline += len(s)
+		} else {
+			w.newline = len(s) - len(trimmed)
+		}
+		return
+	}
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(whitespace, r)
+	})
+	if len(words) == 0 {
+		if s != "" && w.b.Len() > 0 && w.newline == 0 {
+			w.space = true
+		}
+		return
+	}
+	if strings.IndexByte(whitespace, s[0]) >= 0 && w.newline == 0 {
+		w.space = true
+	}
+	w.flush()
+	for _, word := range words {
+		if w.space && w.b.Len() > 0 && w.newline == 0 {
+			w.b.WriteByte(' ')
+		}
+		w.b.WriteString(word)
+		w.newline = 0
+		w.space = true
+	}
+	w.space = strings.IndexByte(whitespace, s[len(s)-1]) >= 0
+}
+
+// prevCell reports whether the table cell n follows another cell in
+// its row.
+func prevCell(n *Node) bool {
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == ElementNode && (c.DataAtom == a.Td || c.DataAtom == a.Th) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(n *Node, key string) bool {
+	_, ok := getAttr(n, key)
+	return ok
+}
+
+func getAttr(n *Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+	for _, test := range []struct {
+		html, want string
+	}{
+		{"Hello,   <b>wide</b>\n world", "Hello, wide world"},
+		{"a<b> b </b>c", "a b c"},
+		{"<p>one</p><p>two</p>", "one\n\ntwo"},
+		{"<div>one</div><div>two</div>three", "one\ntwo\nthree"},
+		{"<h1>Title</h1>text", "Title\n\ntext"},
+		{"a<br>b<br><br>c", "a\nb\n\nc"},
+		{"<p>a<br></p><p>b</p>", "a\n\nb"},
+		{"<ul><li>one</li><li>two<ul><li>nested</li></ul></li></ul>after", "one\ntwo\nnested\n\nafter"},
+		{"<pre>  keep\n  this</pre>x", "  keep\n  this\n\nx"},
+		{"<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2</td></tr></table>", "a\tb\n1\t2"},
+		{"<head><title>T</title><style>p{}</style></head><script>x()</script>body<noscript>n</noscript>", "body"},
+		{"<div hidden>secret</div>shown<template>tmpl</template>", "shown"},
+		{"<!-- comment -->a<img alt='picture'>b", "apictureb"},
+		{"a <img alt=picture> b", "a picture b"},
+		{"<span>a</span>\n<span>b</span>", "a b"},
+		{"a&nbsp;&nbsp;b  c", "a\u00a0\u00a0b c"},
+		{"a<hr>b", "a\n\nb"},
+		{"<svg><text>vector</text></svg>", "vector"},
+	} {
+		doc, err := Parse(strings.NewReader(test.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ExtractText(doc); got != test.want {
+			t.Errorf("ExtractText(%q) = %q, want %q", test.html, got, test.want)
+		}
+	}
+}
+
+func TestExtractTextLinkFootnotes(t *testing.T) {
+	const html = `<p>See <a href="https://example.com/a">the docs</a>, ` +
+		`<a href="#top">the top</a> and <a href="/b">this</a>.</p>` +
+		`<p><a href="/empty"></a></p>`
+	doc, err := Parse(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "See the docs[1], the top and this[2].\n\n" +
+		"[1] https://example.com/a\n" +
+		"[2] /b"
+	if got := ExtractText(doc, TextOptionLinkFootnotes(true)); got != want {
+		t.Errorf("ExtractText with footnotes:\ngot  %q\nwant %q", got, want)
+	}
+	if got, want := ExtractText(doc), "See the docs, the top and this."; got != want {
+		t.Errorf("ExtractText without footnotes = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An XPath is a compiled expression in a small subset of XPath 1.0,
+// for selecting nodes from a parsed tree.
+//
+// A path is a sequence of steps separated by "/" (the child axis) or
+// "//" (the descendant axis). A path starting with "/" or "//" is
+// evaluated from the root of the tree, and otherwise from the node
+// passed to Select. Each step is one of:
+//
+//	name      elements with the given tag name
+//	*         any element
+//	text()    text nodes
+//	node()    any element or text node
+//	.         the context node
+//	..        the context node's parent
+//
+// followed by any number of predicates in square brackets, which
+// filter the nodes selected by the step:
+//
+//	[2]                        the second matching node (counting from 1)
+//	[last()]                   the last matching node
+//	[@attr]                    elements with the attribute
+//	[@attr='value']            elements with the attribute value ("!=" for inequality)
+//	[text()='value']           nodes with a text child with the value
+//	[.='value']                nodes with the text content
+//	[contains(@attr,'value')]  the attribute value contains the string
+//	[starts-with(@attr,'v')]   the attribute value starts with the string
+//
+// The first argument of contains and starts-with may also be text() or ".".
+// Step positions are counted separately for each node the step starts from,
+// so "//li[1]" selects the first item of each list.
+type XPath struct {
+	expr     string
+	absolute bool
+	steps    []xpathStep
+}
+
+type xpathStep struct {
+	descendant bool   // "//" axis rather than "/"
+	test       string // tag name, "*", "text()", "node()", ".", or ".."
+	preds      []xpathPred
+}
+
+type xpathPred struct {
+	pos    int    // position, if non-zero; -1 for last()
+	kind   string // "@", "text()", or "."
+	attr   string // attribute name, if kind is "@"
+	op     string // "", "=", "!=", "contains", or "starts-with"
+	value  string
+	exists bool // only test that the attribute exists
+}
+
+// CompileXPath parses an XPath expression.
+func CompileXPath(expr string) (*XPath, error) {
+	p := &xpathParser{s: expr}
+	x, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("html: bad XPath %q: %v", expr, err)
+	}
+	return x, nil
+}
+
+// MustCompileXPath is like CompileXPath but panics if the expression
+// cannot be parsed.
+func MustCompileXPath(expr string) *XPath {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// String returns the source expression.
+func (x *XPath) String() string { return x.expr }
+
+// Select returns the nodes selected by the expression, starting from n,
+// in document order.
+func (x *XPath) Select(n *Node) []*Node {
+	ctx := []*Node{n}
+	if x.absolute {
+		for n.Parent != nil {
+			n = n.Parent
+		}
+		ctx = []*Node{n}
+	}
+	for _, step := range x.steps {
+		seen := make(map[*Node]bool)
+		for _, c := range ctx {
+			var bases []*Node
+			if step.descendant {
+				bases = descendantsOrSelf(c, nil)
+			} else {
+				bases = []*Node{c}
+			}
+			for _, b := range bases {
+				for _, m := range step.apply(b) {
+					seen[m] = true
+				}
+			}
+		}
+		ctx = documentOrder(seen)
+	}
+	return ctx
+}
+
+// SelectFirst returns the first node selected by the expression,
+// or nil if there is none.
+func (x *XPath) SelectFirst(n *Node) *Node {
+	if nodes := x.Select(n); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+func descendantsOrSelf(n *Node, dst []*Node) []*Node {
+	dst = append(dst, n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		dst = descendantsOrSelf(c, dst)
+	}
+	return dst
+}
+
+// documentOrder returns the nodes in set in document order.
+func documentOrder(set map[*Node]bool) []*Node {
+	if len(set) == 0 {
+		return nil
+	}
+	roots := make(map[*Node]bool)
+	for n := range set {
+		for n.Parent != nil {
+			n = n.Parent
+		}
+		roots[n] = true
+	}
+	var nodes []*Node
+	for root := range roots {
+		for _, n := range descendantsOrSelf(root, nil) {
+			if set[n] {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// apply returns the nodes selected by the step from the node n.
+func (s *xpathStep) apply(n *Node) []*Node {
+	var nodes []*Node
+	switch s.test {
+	case ".":
+		nodes = []*Node{n}
+	case "..":
+		if n.Parent != nil {
+			nodes = []*Node{n.Parent}
+		}
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if s.matches(c) {
+				nodes = append(nodes, c)
+			}
+		}
+	}
+	for _, pred := range s.preds {
+		var kept []*Node
+		for i, m := range nodes {
+			if pred.matches(m, i+1, len(nodes)) {
+				kept = append(kept, m)
+			}
+		}
+		nodes = kept
+	}
+	return nodes
+}
+
+func (s *xpathStep) matches(n *Node) bool {
+	switch s.test {
+	case "text()":
+		return n.Type == TextNode
+	case "node()":
+		return n.Type == ElementNode || n.Type == TextNode
+	case "*":
+		return n.Type == ElementNode
+	}
+	return n.Type == ElementNode && n.Data == s.test
+}
+
+func (p *xpathPred) matches(n *Node, pos, size int) bool {
+	switch {
+	case p.pos > 0:
+		return pos == p.pos
+	case p.pos < 0:
+		return pos == size
+	}
+	var values []string
+	switch p.kind {
+	case "@":
+		for _, a := range n.Attr {
+			if a.Namespace == "" && a.Key == p.attr {
+				values = append(values, a.Val)
+			}
+		}
+	case "text()":
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == TextNode {
+				values = append(values, c.Data)
+			}
+		}
+	case ".":
+		values = append(values, textContent(n))
+	}
+	if p.exists {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		var ok bool
+		switch p.op {
+		case "=":
+			ok = v == p.value
+		case "!=":
+			ok = v != p.value
+		case "contains":
+			ok = strings.Contains(v, p.value)
+		case "starts-with":
+			ok = strings.HasPrefix(v, p.value)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// textContent returns the concatenation of the text nodes in n's subtree.
+func textContent(n *Node) string {
+	if n.Type == TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for _, d := range descendantsOrSelf(n, nil) {
+		if d.Type == TextNode {
+			b.WriteString(d.Data)
+		}
+	}
+	return b.String()
+}
+
+type xpathParser struct {
+	s   string
+	pos int
+}
+
+func (p *xpathParser) parse() (*XPath, error) {
+	x := &XPath{expr: p.s}
+	p.skipSpace()
+	descendant := false
+	switch {
+	case p.consume("//"):
+		x.absolute, descendant = true, true
+	case p.consume("/"):
+		x.absolute = true
+	}
+	if x.absolute && p.done() && !descendant {
+		return x, nil // "/" selects the root
+	}
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		step.descendant = descendant
+		x.steps = append(x.steps, step)
+		p.skipSpace()
+		switch {
+		case p.done():
+			return x, nil
+		case p.consume("//"):
+			descendant = true
+		case p.consume("/"):
+			descendant = false
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %v", p.s[p.pos:], p.pos)
+		}
+	}
+}
+
+func (p *xpathParser) parseStep() (xpathStep, error) {
+	p.skipSpace()
+	var step xpathStep
+	switch {
+	case p.consume(".."):
+		step.test = ".."
+	case p.consume("."):
+		step.test = "."
+	case p.consume("*"):
+		step.test = "*"
+	case p.consume("text()"):
+		step.test = "text()"
+	case p.consume("node()"):
+		step.test = "node()"
+	default:
+		name := p.name()
+		if name == "" {
+			return step, fmt.Errorf("expected step at offset %v", p.pos)
+		}
+		step.test = strings.ToLower(name)
+	}
+	for {
+		p.skipSpace()
+		if !p.consume("[") {
+			return step, nil
+		}
+		pred, err := p.parsePred()
+		if err != nil {
+			return step, err
+		}
+		p.skipSpace()
+		if !p.consume("]") {
+			return step, fmt.Errorf("expected ] at offset %v", p.pos)
+		}
+		step.preds = append(step.preds, pred)
+	}
+}
+
+func (p *xpathParser) parsePred() (xpathPred, error) {
+	p.skipSpace()
+	var pred xpathPred
+	if p.consume("last()") {
+		pred.pos = -1
+		return pred, nil
+	}
+	if start := p.pos; p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+		for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+		n, err := strconv.Atoi(p.s[start:p.pos])
+		if err != nil || n < 1 {
+			return pred, fmt.Errorf("bad position %q", p.s[start:p.pos])
+		}
+		pred.pos = n
+		return pred, nil
+	}
+	for _, fn := range []string{"contains", "starts-with"} {
+		if p.consume(fn + "(") {
+			pred.op = fn
+			if err := p.parseOperand(&pred); err != nil {
+				return pred, err
+			}
+			p.skipSpace()
+			if !p.consume(",") {
+				return pred, fmt.Errorf("expected , at offset %v", p.pos)
+			}
+			v, err := p.literal()
+			if err != nil {
+				return pred, err
+			}
+			pred.value = v
+			p.skipSpace()
+			if !p.consume(")") {
+				return pred, fmt.Errorf("expected ) at offset %v", p.pos)
+			}
+			return pred, nil
+		}
+	}
+	if err := p.parseOperand(&pred); err != nil {
+		return pred, err
+	}
+	p.skipSpace()
+	switch {
+	case p.consume("!="):
+		pred.op = "!="
+	case p.consume("="):
+		pred.op = "="
+	default:
+		if pred.kind != "@" {
+			return pred, fmt.Errorf("expected = at offset %v", p.pos)
+		}
+		pred.exists = true
+		return pred, nil
+	}
+	v, err := p.literal()
+	if err != nil {
+		return pred, err
+	}
+	pred.value = v
+	return pred, nil
+}
+
+// parseOperand parses @attr, text(), or ".".
+func (p *xpathParser) parseOperand(pred *xpathPred) error {
+	p.skipSpace()
+	switch {
+	case p.consume("@"):
+		pred.kind = "@"
+		pred.attr = strings.ToLower(p.name())
+		if pred.attr == "" {
+			return fmt.Errorf("expected attribute name at offset %v", p.pos)
+		}
+	case p.consume("text()"):
+		pred.kind = "text()"
+	case p.consume("."):
+		pred.kind = "."
+	default:
+		return fmt.Errorf("expected @attr, text(), or . at offset %v", p.pos)
+	}
+	return nil
+}
+
+func (p *xpathParser) literal() (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.s) && (p.s[p.pos] == '\'' || p.s[p.pos] == '"') {
+		quote := p.s[p.pos]
+		if end := strings.IndexByte(p.s[p.pos+1:], quote); end >= 0 {
+			v := p.s[p.pos+1 : p.pos+1+end]
+			p.pos += end + 2
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("expected quoted string at offset %v", p.pos)
+}
+
+func (p *xpathParser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == ':' ||
+			p.pos > start && ('0' <= c && c <= '9' || c == '-' || c == '.') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *xpathParser) consume(s string) bool {
+	if strings.HasPrefix(p.s[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *xpathParser) done() bool { return p.pos == len(p.s) }
@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package html
+
+import (
+	"strings"
+	"testing"
+)
+
+const xpathTestDoc = `<!DOCTYPE html>
+<html><head><title>Title</title></head>
+<body>
+<div id="main" class="content wide">
+<ul><li>a</li><li class="x">b</li><li>c</li></ul>
+<ul><li>d</li><li>e</li></ul>
+<p>Hello <a href="/one">one</a> and <a href="https://example.com/two">two</a></p>
+</div>
+<div id="footer">foot</div>
+</body></html>`
+
+func TestXPath(t *testing.T) {
+	doc, err := Parse(strings.NewReader(xpathTestDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		expr string
+		want []string // rendered nodes
+	}{
+		{"/html/head/title/text()", []string{"Title"}},
+		{"//li", []string{"<li>a</li>", `<li class="x">b</li>`, "<li>c</li>", "<li>d</li>", "<li>e</li>"}},
+		{"//li[1]", []string{"<li>a</li>", "<li>d</li>"}},
+		{"//ul[2]/li[last()]", []string{"<li>e</li>"}},
+		{"//li[@class]/text()", []string{"b"}},
+		{"//li[@class='x']", []string{`<li class="x">b</li>`}},
+		{"//ul/li[text()!='a'][1]", []string{`<li class="x">b</li>`, "<li>d</li>"}},
+		{"//a[starts-with(@href, 'https:')]", []string{`<a href="https://example.com/two">two</a>`}},
+		{"//div[contains(@class,\"wide\")]/p/a[.='one']", []string{`<a href="/one">one</a>`}},
+		{"//*[@id='footer']/text()", []string{"foot"}},
+		{"//p[contains(., 'and two')]/a[2]/..", []string{`<p>Hello <a href="/one">one</a> and <a href="https://example.com/two">two</a></p>`}},
+		{"//title/../../body/div[2]", []string{`<div id="footer">foot</div>`}},
+		{"//table", nil},
+	} {
+		x, err := CompileXPath(test.expr)
+		if err != nil {
+			t.Errorf("CompileXPath(%q): %v", test.expr, err)
+			continue
+		}
+		var got []string
+		for _, n := range x.Select(doc) {
+			got = append(got, renderString(n))
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%v:\ngot  %q\nwant %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestXPathRelative(t *testing.T) {
+	doc, err := Parse(strings.NewReader(xpathTestDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	div := MustCompileXPath("//div[@id='main']").SelectFirst(doc)
+	if got := len(MustCompileXPath("ul/li").Select(div)); got != 5 {
+		t.Errorf("ul/li from #main selected %v nodes, want 5", got)
+	}
+	if got := len(MustCompileXPath("//div").Select(div)); got != 2 {
+		t.Errorf("//div from #main selected %v nodes, want 2", got)
+	}
+}
+
+func TestCompileXPathErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"//",
+		"div[",
+		"div[@]",
+		"div[0]",
+		"div[text()]",
+		"div[@a='unterminated]",
+		"div[contains(@a)]",
+		"div/@href",
+		"div|p",
+	} {
+		if _, err := CompileXPath(expr); err == nil {
+			t.Errorf("CompileXPath(%q) succeeded, want error", expr)
+		}
+	}
+}
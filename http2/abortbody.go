@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"io"
+	"io/fs"
+)
+
+// ResponseBodyAborter is implemented by the Body of responses returned
+// by the Transport, including bodies it decompresses.
+type ResponseBodyAborter interface {
+	io.ReadCloser
+
+	// Abort closes the body like Close, but resets the stream with
+	// code rather than CANCEL if the response has not been fully
+	// received, so that the server can tell why the download was
+	// stopped, such as INTERNAL_ERROR for a failure in the client.
+	//
+	// If the whole response had already been received, the stream is
+	// not reset, and Abort copies any trailers it carried to the
+	// Response's Trailer, although the rest of the body was not read.
+	Abort(code ErrCode) error
+}
+
+var (
+	_ ResponseBodyAborter = transportResponseBody{}
+	_ ResponseBodyAborter = (*gzipReader)(nil)
+	_ ResponseBodyAborter = (*decompressReader)(nil)
+)
+
+func (b transportResponseBody) Abort(code ErrCode) error {
+	cs := b.cs
+	// The read loop sets the trailers before ending the body with
+	// io.EOF, which Err synchronizes with.
+	if cs.bufPipe.Err() == io.EOF {
+		cs.copyTrailers()
+	}
+	return b.closeWithError(StreamError{
+		StreamID: cs.ID,
+		Code:     code,
+		Cause:    errClosedResponseBody,
+	})
+}
+
+func (gz *gzipReader) Abort(code ErrCode) error {
+	if err := abortBody(gz.body, code); err != nil {
+		return err
+	}
+	gz.zerr = fs.ErrClosed
+	return nil
+}
+
+func (dr *decompressReader) Abort(code ErrCode) error {
+	if dr.zr != nil {
+		dr.zr.Close()
+	}
+	if err := abortBody(dr.body, code); err != nil {
+		return err
+	}
+	dr.zerr = fs.ErrClosed
+	return nil
+}
+
+// abortBody aborts body with code if it is a ResponseBodyAborter,
+// and closes it otherwise.
+func abortBody(body io.ReadCloser, code ErrCode) error {
+	if a, ok := body.(ResponseBodyAborter); ok {
+		return a.Abort(code)
+	}
+	return body.Close()
+}
@@ -0,0 +1,324 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// An AltSvc is an alternative service advertised for an origin,
+// as described in RFC 7838.
+type AltSvc struct {
+	// Protocol is the ALPN protocol ID of the alternative,
+	// such as "h2" or "h3".
+	Protocol string
+
+	// Host is the host of the alternative. If empty, it is the
+	// origin's host.
+	Host string
+
+	// Port is the port of the alternative.
+	Port int
+
+	// Expires is when the alternative is no longer fresh.
+	Expires time.Time
+
+	// Persist is whether the alternative remains valid after the
+	// client's network configuration changes.
+	Persist bool
+}
+
+// addr returns the address to dial for the alternative service of the
+// origin whose host is originHost.
+func (a AltSvc) addr(originHost string) string {
+	host := a.Host
+	if host == "" {
+		host = originHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+// defaultAltSvcMaxAge is the freshness lifetime of an alternative
+// service without an "ma" parameter (RFC 7838, Section 3.1).
+const defaultAltSvcMaxAge = 24 * time.Hour
+
+var errAltSvcSyntax = errors.New("http2: malformed Alt-Svc field value")
+
+// ParseAltSvc parses the value of an Alt-Svc header field or ALTSVC
+// frame, received at now. It returns no alternatives and a nil error
+// for the value "clear", which invalidates the origin's alternatives.
+// Parameters other than "ma" and "persist" are ignored.
+func ParseAltSvc(value string, now time.Time) ([]AltSvc, error) {
+	value = textproto.TrimString(value)
+	if value == "clear" {
+		return nil, nil
+	}
+	var alts []AltSvc
+	p := altSvcParser{s: value}
+	for {
+		p.skipSpace()
+		proto, ok := p.token()
+		if !ok || !p.consume('=') {
+			return nil, errAltSvcSyntax
+		}
+		if proto, ok = unescapeALPN(proto); !ok {
+			return nil, errAltSvcSyntax
+		}
+		authority, ok := p.quotedString()
+		if !ok {
+			return nil, errAltSvcSyntax
+		}
+		i := strings.LastIndexByte(authority, ':')
+		if i < 0 {
+			return nil, errAltSvcSyntax
+		}
+		port, err := strconv.ParseUint(authority[i+1:], 10, 16)
+		if err != nil {
+			return nil, errAltSvcSyntax
+		}
+		alt := AltSvc{
+			Protocol: proto,
+			Host:     strings.TrimSuffix(strings.TrimPrefix(authority[:i], "["), "]"),
+			Port:     int(port),
+			Expires:  now.Add(defaultAltSvcMaxAge),
+		}
+		for {
+			p.skipSpace()
+			if !p.consume(';') {
+				break
+			}
+			p.skipSpace()
+			name, ok := p.token()
+			if !ok || !p.consume('=') {
+				return nil, errAltSvcSyntax
+			}
+			val, ok := p.token()
+			if !ok {
+				if val, ok = p.quotedString(); !ok {
+					return nil, errAltSvcSyntax
+				}
+			}
+			switch name {
+			case "ma":
+				if ma, err := strconv.ParseUint(val, 10, 32); err == nil {
+					alt.Expires = now.Add(time.Duration(ma) * time.Second)
+				}
+			case "persist":
+				alt.Persist = val == "1"
+			}
+		}
+		alts = append(alts, alt)
+		p.skipSpace()
+		if p.done() {
+			return alts, nil
+		}
+		if !p.consume(',') {
+			return nil, errAltSvcSyntax
+		}
+	}
+}
+
+// altSvcParser scans the elements of an Alt-Svc field value.
+type altSvcParser struct {
+	s string
+}
+
+func (p *altSvcParser) done() bool { return p.s == "" }
+
+func (p *altSvcParser) skipSpace() {
+	p.s = strings.TrimLeft(p.s, " \t")
+}
+
+func (p *altSvcParser) consume(c byte) bool {
+	if p.s == "" || p.s[0] != c {
+		return false
+	}
+	p.s = p.s[1:]
+	return true
+}
+
+func (p *altSvcParser) token() (string, bool) {
+	i := 0
+	for i < len(p.s) && httpguts.IsTokenRune(rune(p.s[i])) {
+		i++
+	}
+	tok := p.s[:i]
+	p.s = p.s[i:]
+	return tok, tok != ""
+}
+
+func (p *altSvcParser) quotedString() (string, bool) {
+	if !p.consume('"') {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(p.s); i++ {
+		switch c := p.s[i]; c {
+		case '"':
+			p.s = p.s[i+1:]
+			return b.String(), true
+		case '\\':
+			if i+1 == len(p.s) {
+				return "", false
+			}
+			i++
+			b.WriteByte(p.s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+// unescapeALPN decodes a percent-encoded ALPN protocol ID
+// (RFC 7838, Section 3).
+func unescapeALPN(s string) (string, bool) {
+	if !strings.Contains(s, "%") {
+		return s, true
+	}
+	u, err := url.PathUnescape(s)
+	if err != nil {
+		return "", false
+	}
+	return u, true
+}
+
+// An AltSvcCache stores the alternative services advertised for
+// origins. See Transport.AltSvc.
+//
+// Origins are identified by their host and port, such as
+// "example.com:443". Implementations must be safe for concurrent use.
+// They may persist the alternatives, so that a new process can use
+// them, although the Transport ignores alternatives which have expired.
+type AltSvcCache interface {
+	// Get returns the alternatives stored for the origin addr, in
+	// order of preference.
+	Get(addr string) []AltSvc
+
+	// Put replaces the alternatives stored for the origin addr.
+	// If alts is empty, the origin's alternatives are removed.
+	Put(addr string, alts []AltSvc)
+}
+
+// NewAltSvcCache returns an AltSvcCache which stores alternatives
+// in memory.
+func NewAltSvcCache() AltSvcCache {
+	return &memAltSvcCache{m: make(map[string][]AltSvc)}
+}
+
+type memAltSvcCache struct {
+	mu sync.Mutex
+	m  map[string][]AltSvc
+}
+
+func (c *memAltSvcCache) Get(addr string) []AltSvc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]AltSvc(nil), c.m[addr]...)
+}
+
+func (c *memAltSvcCache) Put(addr string, alts []AltSvc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(alts) == 0 {
+		delete(c.m, addr)
+		return
+	}
+	c.m[addr] = append([]AltSvc(nil), alts...)
+}
+
+// recordAltSvc stores the alternatives advertised by value for the
+// origin addr, if the Transport has an AltSvcCache.
+func (t *Transport) recordAltSvc(addr, value string) {
+	if t.AltSvc == nil {
+		return
+	}
+	alts, err := ParseAltSvc(value, t.now())
+	if err != nil {
+		t.vlogf("http2: ignoring Alt-Svc for %v: %v", addr, err)
+		return
+	}
+	t.AltSvc.Put(addr, alts)
+}
+
+// altSvcAddr returns the address of a fresh "h2" alternative service
+// for the origin addr, or "" if there is none.
+func (t *Transport) altSvcAddr(addr string) string {
+	if t.AltSvc == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	now := t.now()
+	for _, alt := range t.AltSvc.Get(addr) {
+		if alt.Protocol == NextProtoTLS && now.Before(alt.Expires) {
+			return alt.addr(host)
+		}
+	}
+	return ""
+}
+
+// forgetAltSvc removes the alternative services of the origin addr
+// at altAddr, after a failure to connect to it, along with any which
+// have expired.
+func (t *Transport) forgetAltSvc(addr, altAddr string) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	now := t.now()
+	var keep []AltSvc
+	for _, alt := range t.AltSvc.Get(addr) {
+		if now.Before(alt.Expires) && !(alt.Protocol == NextProtoTLS && alt.addr(host) == altAddr) {
+			keep = append(keep, alt)
+		}
+	}
+	t.AltSvc.Put(addr, keep)
+}
+
+// processAltSvc records the alternative services in an ALTSVC frame
+// (RFC 7838, Section 4).
+func (rl *clientConnReadLoop) processAltSvc(f *AltSvcFrame) error {
+	cc := rl.cc
+	if cc.t.AltSvc == nil {
+		return nil
+	}
+	var addr string
+	switch {
+	case f.StreamID == 0 && f.Origin != "":
+		// The connection's server must be authoritative for the origin.
+		u, err := url.Parse(f.Origin)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil
+		}
+		addr = authorityAddr("https", u.Host)
+		host, _, _ := net.SplitHostPort(addr)
+		if !certValidForHost(cc, host) {
+			return nil
+		}
+	case f.StreamID != 0 && f.Origin == "":
+		cs := rl.streamByID(f.StreamID)
+		if cs == nil || cs.req.URL.Scheme != "https" {
+			return nil
+		}
+		addr = authorityAddr("https", cs.req.URL.Host)
+	default:
+		// Frames which do not identify an origin are ignored.
+		return nil
+	}
+	cc.t.recordAltSvc(addr, f.Value)
+	return nil
+}
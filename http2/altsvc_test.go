@@ -0,0 +1,198 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	now := time.Unix(1000, 0)
+	day := now.Add(24 * time.Hour)
+	for _, test := range []struct {
+		value   string
+		want    []AltSvc
+		wantErr bool
+	}{{
+		value: "clear",
+		want:  nil,
+	}, {
+		value: `h2=":8443"`,
+		want:  []AltSvc{{Protocol: "h2", Port: 8443, Expires: day}},
+	}, {
+		value: `h2="alt.example.com:443"; ma=60; persist=1, h3=":443"`,
+		want: []AltSvc{
+			{Protocol: "h2", Host: "alt.example.com", Port: 443, Expires: now.Add(60 * time.Second), Persist: true},
+			{Protocol: "h3", Port: 443, Expires: day},
+		},
+	}, {
+		value: `w%3D%3D="[::1]:80";foo="a,b"`,
+		want:  []AltSvc{{Protocol: "w==", Host: "::1", Port: 80, Expires: day}},
+	}, {
+		value: `h2="\a\l\t:1"`,
+		want:  []AltSvc{{Protocol: "h2", Host: "alt", Port: 1, Expires: day}},
+	}, {
+		value:   `h2=":8443`,
+		wantErr: true,
+	}, {
+		value:   `h2=alt:443`,
+		wantErr: true,
+	}, {
+		value:   `h2="alt"`,
+		wantErr: true,
+	}, {
+		value:   `h2=":99999"`,
+		wantErr: true,
+	}, {
+		value:   `h2=":443" h3=":443"`,
+		wantErr: true,
+	}} {
+		got, err := ParseAltSvc(test.value, now)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("ParseAltSvc(%q): err = %v, want error: %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseAltSvc(%q) =\n%+v\nwant:\n%+v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestTransportAltSvc(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		altSvc string
+	)
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Alt-Svc", altSvc)
+	})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	origin := "example.com:" + port
+	setAltSvc := func(v string) {
+		mu.Lock()
+		defer mu.Unlock()
+		altSvc = v
+	}
+
+	var dials []string
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		AltSvc:          NewAltSvcCache(),
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			dials = append(dials, addr+" "+cfg.ServerName)
+			if strings.HasPrefix(addr, "bad.") {
+				return nil, errors.New("unreachable")
+			}
+			cfg = cfg.Clone()
+			cfg.InsecureSkipVerify = true
+			return tls.Dial(network, u.Host, cfg)
+		},
+	}
+	defer tr.CloseIdleConnections()
+	get := func() {
+		t.Helper()
+		tr.CloseIdleConnections()
+		req, _ := http.NewRequest("GET", "https://"+origin+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// The first request dials the origin and learns of an alternative.
+	setAltSvc(`h2="alt.example.com:` + port + `"`)
+	get()
+	if got := tr.AltSvc.Get(origin); len(got) != 1 || got[0].Host != "alt.example.com" {
+		t.Fatalf("AltSvc.Get(%q) = %+v, want alt.example.com", origin, got)
+	}
+
+	// New connections use the alternative, with the origin's TLS name.
+	setAltSvc("clear")
+	get()
+	if len(tr.AltSvc.Get(origin)) != 0 {
+		t.Errorf("alternatives remain after Alt-Svc: clear")
+	}
+
+	// An unreachable alternative falls back to the origin and is forgotten.
+	tr.AltSvc.Put(origin, []AltSvc{{
+		Protocol: "h2",
+		Host:     "bad.example.com",
+		Port:     443,
+		Expires:  time.Now().Add(time.Hour),
+	}})
+	setAltSvc("")
+	get()
+	if got := tr.AltSvc.Get(origin); len(got) != 0 {
+		t.Errorf("AltSvc.Get(%q) = %+v after failed dial, want none", origin, got)
+	}
+
+	// Expired alternatives are not used.
+	tr.AltSvc.Put(origin, []AltSvc{{
+		Protocol: "h2",
+		Host:     "alt.example.com",
+		Port:     443,
+		Expires:  time.Now().Add(-time.Second),
+	}})
+	get()
+
+	want := []string{
+		origin + " example.com",
+		"alt.example.com:" + port + " example.com",
+		"bad.example.com:443 example.com",
+		origin + " example.com",
+		origin + " example.com",
+	}
+	if !reflect.DeepEqual(dials, want) {
+		t.Errorf("dialed:\n%q\nwant:\n%q", dials, want)
+	}
+}
+
+func TestTransportAltSvcFrame(t *testing.T) {
+	cache := NewAltSvcCache()
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.AltSvc = cache
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	// The connection has no certificate, so it is not authoritative
+	// for the origin named in a stream 0 frame.
+	tc.writeAltSvc(0, "https://other.tld", `h2=":8443"`)
+	// A frame on a request stream applies to the request's origin.
+	tc.writeAltSvc(rt.streamID(), "", `h2="alt.tld:8443"`)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(200)
+
+	if got := cache.Get("other.tld:443"); len(got) != 0 {
+		t.Errorf("alternatives for other.tld:443 = %+v, want none", got)
+	}
+	if got := cache.Get("dummy.tld:443"); len(got) != 1 || got[0].addr("dummy.tld") != "alt.tld:8443" {
+		t.Errorf("alternatives for dummy.tld:443 = %+v, want alt.tld:8443", got)
+	}
+}
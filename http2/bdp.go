@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import "time"
+
+// bdpPingData is the payload of the PINGs sent to estimate a
+// connection's bandwidth-delay product.
+var bdpPingData = [8]byte{'h', '2', 'b', 'd', 'p', 'e', 's', 't'}
+
+const (
+	// bdpRTTAlpha is the weight of a new round-trip time sample in
+	// the smoothed round-trip time, once bdpRTTWarmup samples were taken.
+	bdpRTTAlpha  = 0.9
+	bdpRTTWarmup = 10
+
+	// A sample of at least bdpGrowThreshold of the current window,
+	// at the highest bandwidth seen, grows the window to
+	// bdpGrowFactor times the sample.
+	bdpGrowThreshold = 2.0 / 3
+	bdpGrowFactor    = 2
+)
+
+// A bdpEstimator estimates the bandwidth-delay product (BDP) of a
+// connection, to grow its receive window so that flow control does not
+// limit throughput on links with a high latency.
+//
+// The estimator follows gRPC's: a PING is sent with the first DATA frame
+// received while no estimate is in progress, and the amount of data
+// received until the PING is acknowledged is a sample of the BDP.
+// When a sample, received at the highest bandwidth seen, nearly fills
+// the window, the window is too small and grows.
+type bdpEstimator struct {
+	limit  int32 // largest window
+	window int32 // current window
+
+	pending bool      // a PING was sent and not acknowledged
+	sentAt  time.Time // when the PING was sent
+	sample  int64     // bytes received since the PING was sent
+	samples int       // number of samples taken
+	rtt     float64   // smoothed round-trip time, in seconds
+	bwMax   float64   // highest bandwidth seen, in bytes per second
+}
+
+// received records the receipt of a DATA frame of n bytes, and reports
+// whether to send a PING with bdpPingData to start a new sample.
+func (b *bdpEstimator) received(n uint32, now time.Time) bool {
+	if b.window >= b.limit {
+		return false
+	}
+	if b.pending {
+		b.sample += int64(n)
+		return false
+	}
+	b.pending = true
+	b.sentAt = now
+	b.sample = int64(n)
+	b.samples++
+	return true
+}
+
+// acked records the acknowledgement of the PING, and returns the
+// window's new size, or zero if it does not change.
+func (b *bdpEstimator) acked(now time.Time) int32 {
+	if !b.pending {
+		return 0
+	}
+	b.pending = false
+	rtt := now.Sub(b.sentAt).Seconds()
+	if b.samples <= bdpRTTWarmup {
+		b.rtt += (rtt - b.rtt) / float64(b.samples)
+	} else {
+		b.rtt += (rtt - b.rtt) * bdpRTTAlpha
+	}
+	if b.rtt <= 0 {
+		return 0
+	}
+	// The sample was received over about one and a half round trips:
+	// the PING's, and the time the peer took to fill the window.
+	bw := float64(b.sample) / (b.rtt * 1.5)
+	if bw < b.bwMax {
+		return 0
+	}
+	b.bwMax = bw
+	if float64(b.sample) < bdpGrowThreshold*float64(b.window) {
+		return 0
+	}
+	window := int64(b.sample) * bdpGrowFactor
+	if window > int64(b.limit) {
+		window = int64(b.limit)
+	}
+	if window <= int64(b.window) {
+		return 0
+	}
+	b.window = int32(window)
+	return b.window
+}
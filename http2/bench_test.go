@@ -0,0 +1,215 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// BenchmarkWorkloads runs a set of representative client/server
+// workloads, to serve as a baseline for performance-affecting changes.
+//
+// Each workload runs over two harnesses:
+//
+//   - TLS: a Transport and Server connected by a loopback TLS connection.
+//   - Synthetic: a ClientConn and Server connected by an in-memory
+//     connection, which excludes the cost of TLS and the network stack.
+//
+// To run a single workload on a single harness:
+//
+//	go test -run=NONE -bench='Workloads/LargeDownload/Synthetic'
+func BenchmarkWorkloads(b *testing.B) {
+	for _, w := range []struct {
+		name string
+		f    func(*testing.B, benchHarness)
+	}{
+		{"SmallRequests", benchWorkloadSmallRequests},
+		{"LargeDownload", benchWorkloadLargeDownload},
+		{"LargeUpload", benchWorkloadLargeUpload},
+		{"StreamChurn", benchWorkloadStreamChurn},
+		{"HeaderHeavy", benchWorkloadHeaderHeavy},
+	} {
+		b.Run(w.name, func(b *testing.B) {
+			for _, h := range benchHarnesses {
+				b.Run(h.name, func(b *testing.B) {
+					disableGoroutineTracking(b)
+					b.ReportAllocs()
+					w.f(b, h)
+				})
+			}
+		})
+	}
+}
+
+// A benchHarness connects a RoundTripper to a handler.
+type benchHarness struct {
+	name string
+
+	// start serves handler, and returns a RoundTripper
+	// and URL for making requests to it.
+	start func(b *testing.B, handler http.HandlerFunc) (rt http.RoundTripper, url string)
+}
+
+var benchHarnesses = []benchHarness{{
+	name: "TLS",
+	start: func(b *testing.B, handler http.HandlerFunc) (http.RoundTripper, string) {
+		ts := newTestServer(b, handler, optQuiet)
+		tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+		b.Cleanup(tr.CloseIdleConnections)
+		return tr, ts.URL
+	},
+}, {
+	name: "Synthetic",
+	start: func(b *testing.B, handler http.HandlerFunc) (http.RoundTripper, string) {
+		cli, srv := synctestNetPipe(nil)
+		s := &Server{}
+		donec := make(chan struct{})
+		go func() {
+			defer close(donec)
+			s.ServeConn(srv, &ServeConnOpts{Handler: handler})
+		}()
+		b.Cleanup(func() {
+			srv.Close()
+			<-donec
+		})
+		tr := &Transport{}
+		cc, err := tr.NewClientConn(cli)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { cc.Close() })
+		return cc, "https://dummy.tld/"
+	},
+}}
+
+// benchRoundTrip sends req, and consumes and closes the response body.
+func benchRoundTrip(rt http.RoundTripper, req *http.Request) error {
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %v, want %v", res.StatusCode, http.StatusOK)
+	}
+	return nil
+}
+
+// benchWorkloadSmallRequests makes many small requests, in parallel,
+// on a single connection.
+func benchWorkloadSmallRequests(b *testing.B, h benchHarness) {
+	const msg = "Hello, world."
+	rt, url := h.start(b, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, msg)
+	})
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req, _ := http.NewRequest("GET", url, nil)
+			if err := benchRoundTrip(rt, req); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// benchWorkloadLargeDownload makes requests with large response bodies.
+func benchWorkloadLargeDownload(b *testing.B, h benchHarness) {
+	const size = 16 << 20
+	rt, url := h.start(b, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		buf := make([]byte, 64<<10)
+		for n := 0; n < size; n += len(buf) {
+			w.Write(buf)
+		}
+	})
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("GET", url, nil)
+		if err := benchRoundTrip(rt, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchWorkloadLargeUpload makes requests with large request bodies.
+func benchWorkloadLargeUpload(b *testing.B, h benchHarness) {
+	const size = 16 << 20
+	rt, url := h.start(b, func(w http.ResponseWriter, r *http.Request) {
+		if n, err := io.Copy(io.Discard, r.Body); err != nil || n != size {
+			http.Error(w, fmt.Sprintf("read %v bytes, %v; want %v bytes", n, err, size), http.StatusBadRequest)
+		}
+	})
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("PUT", url, io.LimitReader(neverEnding('A'), size))
+		req.ContentLength = size
+		if err := benchRoundTrip(rt, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchWorkloadStreamChurn opens and closes many short-lived streams
+// concurrently, each with a small request and response body.
+func benchWorkloadStreamChurn(b *testing.B, h benchHarness) {
+	const streams = 100
+	rt, url := h.start(b, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		errc := make(chan error, streams)
+		for j := 0; j < streams; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("POST", url, io.LimitReader(neverEnding('A'), 100))
+				if err := benchRoundTrip(rt, req); err != nil {
+					errc <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		if err := <-errc; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchWorkloadHeaderHeavy makes requests with many request and
+// response headers, and no bodies.
+func benchWorkloadHeaderHeavy(b *testing.B, h benchHarness) {
+	const headers = 100
+	rt, url := h.start(b, func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < headers; i++ {
+			w.Header().Set(fmt.Sprintf("X-Response-%v", i), fmt.Sprintf("value-%v", i))
+		}
+	})
+	req, _ := http.NewRequest("GET", url, nil)
+	for i := 0; i < headers; i++ {
+		req.Header.Set(fmt.Sprintf("X-Request-%v", i), fmt.Sprintf("value-%v", i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := benchRoundTrip(rt, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A CircuitBreaker stops the Transport from sending requests to a host
+// which keeps failing. See Transport.CircuitBreaker.
+//
+// Each host, identified by its address with a port, has a breaker
+// which starts out closed, letting requests through. After Threshold
+// consecutive failed attempts the breaker opens, and requests to the
+// host fail with a *CircuitOpenError without being sent. Once the
+// breaker has been open for Cooldown it becomes half-open: the next
+// attempt is sent as a probe, while others still fail. A successful
+// probe closes the breaker; a failed one opens it again for twice as
+// long as before, up to MaxCooldown.
+//
+// Each attempt to send a request counts, including those retried
+// under the Transport's RetryPolicy and failures to dial or to
+// complete the TLS handshake. Attempts abandoned because the request's
+// context is done count neither as failures nor as successes.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures which open
+	// a host's breaker. If zero, 5 is used.
+	Threshold int
+
+	// Cooldown is how long a breaker stays open before a probe is
+	// sent, after the first failure which opens it.
+	// If zero, 10 seconds is used.
+	Cooldown time.Duration
+
+	// MaxCooldown limits how long a breaker stays open after failed
+	// probes. If zero, 5 minutes is used.
+	MaxCooldown time.Duration
+
+	// IsFailure, if non-nil, reports whether an attempt which returned
+	// res or err failed. Exactly one of res and err is non-nil.
+	//
+	// If nil, failures to dial a connection, errors of the connection
+	// the request was sent on, such as a ConnectionError, GoAwayError
+	// or network error, and responses with a 5xx status code are
+	// failures. Other responses are successes, and other errors, such
+	// as those reading the request body, count as neither.
+	IsFailure func(req *http.Request, res *http.Response, err error) bool
+
+	// OnStateChange, if non-nil, is called when a host's breaker
+	// changes state. It must not block.
+	OnStateChange func(BreakerEvent)
+
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+// A BreakerState is the state of a host's CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails requests without sending them.
+	BreakerOpen
+
+	// BreakerHalfOpen lets one probe request through, and fails
+	// the others.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// A BreakerEvent is a change in the state of a host's CircuitBreaker.
+// See CircuitBreaker.OnStateChange.
+type BreakerEvent struct {
+	// Addr is the host's address, with a port.
+	Addr string
+
+	From, To BreakerState
+
+	// Err is the failure which opened the breaker, or nil.
+	Err error
+
+	// Until is when an open breaker becomes half-open.
+	Until time.Time
+
+	// Label is the label, set with WithRequestLabel, of the request
+	// whose attempt caused the change, or nil.
+	Label interface{}
+}
+
+// A CircuitOpenError is returned by RoundTrip for a request which was
+// not sent because the host's CircuitBreaker is open, or is half-open
+// and already sending a probe.
+type CircuitOpenError struct {
+	// Addr is the host's address, with a port.
+	Addr string
+
+	// Until is when the breaker becomes half-open, or the zero time
+	// if it is half-open.
+	Until time.Time
+
+	// LastErr is the most recent failure counted by the breaker,
+	// such as a dial error or a *GoAwayError. It is nil if that
+	// failure was a response.
+	LastErr error
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.LastErr != nil {
+		return fmt.Sprintf("http2: circuit breaker open for %v after error: %v", e.Addr, e.LastErr)
+	}
+	return fmt.Sprintf("http2: circuit breaker open for %v", e.Addr)
+}
+
+// hostBreaker is the state of one host's breaker.
+type hostBreaker struct {
+	state    BreakerState
+	failures int           // consecutive failures
+	cooldown time.Duration // how long the breaker was last opened for
+	until    time.Time     // when an open breaker becomes half-open
+	probing  bool          // a half-open breaker has sent its probe
+	lastErr  error
+}
+
+// attemptResult is how an attempt to send a request ended,
+// for a CircuitBreaker.
+type attemptResult int
+
+const (
+	attemptSucceeded attemptResult = iota
+	attemptFailed
+	attemptAbandoned
+)
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold <= 0 {
+		return 5
+	}
+	return b.Threshold
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 10 * time.Second
+	}
+	return b.Cooldown
+}
+
+func (b *CircuitBreaker) maxCooldown() time.Duration {
+	if b.MaxCooldown <= 0 {
+		return 5 * time.Minute
+	}
+	return b.MaxCooldown
+}
+
+// allow reports whether an attempt to send req to addr may be made at
+// now, returning a *CircuitOpenError if not. An allowed attempt must
+// be reported with done. The breaker b may be nil.
+func (b *CircuitBreaker) allow(addr string, req *http.Request, now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.hosts[addr]
+	if h == nil {
+		return nil
+	}
+	switch h.state {
+	case BreakerOpen:
+		if now.Before(h.until) {
+			return &CircuitOpenError{Addr: addr, Until: h.until, LastErr: h.lastErr}
+		}
+		b.setStateLocked(addr, h, BreakerHalfOpen, nil, RequestLabel(req.Context()))
+		fallthrough
+	case BreakerHalfOpen:
+		if h.probing {
+			return &CircuitOpenError{Addr: addr, LastErr: h.lastErr}
+		}
+		h.probing = true
+	}
+	return nil
+}
+
+// check returns a *CircuitOpenError if the breaker for addr is open
+// or half-open, without sending a probe. The breaker b may be nil.
+func (b *CircuitBreaker) check(addr string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.hosts[addr]
+	if h == nil || h.state == BreakerClosed {
+		return nil
+	}
+	err := &CircuitOpenError{Addr: addr, LastErr: h.lastErr}
+	if h.state == BreakerOpen {
+		err.Until = h.until
+	}
+	return err
+}
+
+// result classifies the outcome of an attempt to send req.
+// The attempt failed to dial a connection if dialErr is set.
+func (b *CircuitBreaker) result(req *http.Request, res *http.Response, err error, dialErr bool) attemptResult {
+	if err != nil && req.Context().Err() != nil {
+		return attemptAbandoned
+	}
+	if b.IsFailure != nil {
+		if b.IsFailure(req, res, err) {
+			return attemptFailed
+		}
+		return attemptSucceeded
+	}
+	switch {
+	case dialErr:
+		return attemptFailed
+	case err != nil:
+		if isConnFailure(err) {
+			return attemptFailed
+		}
+		return attemptAbandoned
+	case res.StatusCode >= 500:
+		return attemptFailed
+	}
+	return attemptSucceeded
+}
+
+// isConnFailure reports whether err, returned for a request, reports
+// a failure of the connection it was sent on.
+func isConnFailure(err error) bool {
+	var (
+		connErr ConnectionError
+		goAway  GoAwayError
+		netErr  net.Error
+	)
+	return errors.As(err, &connErr) ||
+		errors.As(err, &goAway) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, errClientConnLost)
+}
+
+// done reports the outcome of an attempt to send req to addr, which
+// allow permitted. The breaker b may be nil.
+func (b *CircuitBreaker) done(addr string, req *http.Request, res *http.Response, err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.record(addr, req, b.result(req, res, err, false), err, now)
+}
+
+// dialDone reports that an attempt to send req to addr, which allow
+// permitted, failed to get a connection with err. The breaker b may
+// be nil.
+func (b *CircuitBreaker) dialDone(addr string, req *http.Request, err error, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.record(addr, req, b.result(req, nil, err, true), err, now)
+}
+
+// record records the result r of an attempt to send req to addr,
+// which ended with err at now.
+func (b *CircuitBreaker) record(addr string, req *http.Request, r attemptResult, err error, now time.Time) {
+	if r == attemptAbandoned {
+		b.abandon(addr)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.hosts[addr]
+	switch r {
+	case attemptSucceeded:
+		if h == nil {
+			return
+		}
+		if h.state != BreakerClosed {
+			b.setStateLocked(addr, h, BreakerClosed, nil, RequestLabel(req.Context()))
+		}
+		delete(b.hosts, addr)
+	case attemptFailed:
+		if h == nil {
+			h = &hostBreaker{}
+			if b.hosts == nil {
+				b.hosts = make(map[string]*hostBreaker)
+			}
+			b.hosts[addr] = h
+		}
+		h.lastErr = err
+		switch h.state {
+		case BreakerClosed:
+			h.failures++
+			if h.failures < b.threshold() {
+				return
+			}
+			h.cooldown = b.cooldown()
+		case BreakerHalfOpen:
+			h.cooldown *= 2
+			if limit := b.maxCooldown(); h.cooldown > limit {
+				h.cooldown = limit
+			}
+		case BreakerOpen:
+			// An attempt allowed before the breaker opened.
+			return
+		}
+		h.until = now.Add(h.cooldown)
+		h.probing = false
+		b.setStateLocked(addr, h, BreakerOpen, err, RequestLabel(req.Context()))
+	}
+}
+
+// abandon reports that an attempt to send a request to addr, which
+// allow permitted, was not made or was abandoned, so that a half-open
+// breaker may send another probe. The breaker b may be nil.
+func (b *CircuitBreaker) abandon(addr string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h := b.hosts[addr]; h != nil && h.state == BreakerHalfOpen {
+		h.probing = false
+	}
+}
+
+// setStateLocked changes the state of h, the breaker for addr, on an
+// attempt to send a request with the given label. b.mu must be held.
+func (b *CircuitBreaker) setStateLocked(addr string, h *hostBreaker, state BreakerState, err error, label interface{}) {
+	from := h.state
+	h.state = state
+	if fn := b.OnStateChange; fn != nil {
+		ev := BreakerEvent{Addr: addr, From: from, To: state, Err: err, Label: label}
+		if state == BreakerOpen {
+			ev.Until = h.until
+		}
+		fn(ev)
+	}
+}
+
+// State returns the state of the breaker for addr, a host's address
+// with a port, such as "example.com:443".
+func (b *CircuitBreaker) State(addr string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h := b.hosts[addr]; h != nil {
+		return h.state
+	}
+	return BreakerClosed
+}
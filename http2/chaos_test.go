@@ -0,0 +1,187 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A chaosPolicy is a newTestServer option which injects faults into
+// the server's responses, for chaos testing the Transport.
+//
+// Faults are chosen by a random source seeded with Seed, one request
+// at a time, so a run which makes its requests in a fixed order can
+// be reproduced exactly from its seed.
+type chaosPolicy struct {
+	Seed int64
+
+	// DelayProb is the probability that a request's handler is
+	// delayed, by up to MaxDelay. Delays reorder the responses to
+	// concurrent requests.
+	DelayProb float64
+	MaxDelay  time.Duration
+
+	// ResetProb is the probability that a request's stream is reset
+	// before its response headers are sent.
+	ResetProb float64
+
+	// ResetBodyProb is the probability that a request's stream is
+	// reset after its response headers and first body write are sent.
+	ResetBodyProb float64
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	faults []chaosFault // faults injected, in request order
+}
+
+// A chaosFault is a fault injected into a single response.
+type chaosFault struct {
+	Delay     time.Duration
+	Reset     bool
+	ResetBody bool
+}
+
+// choose picks the faults for the next request.
+func (p *chaosPolicy) choose() chaosFault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(p.Seed))
+	}
+	var f chaosFault
+	if p.rand.Float64() < p.DelayProb && p.MaxDelay > 0 {
+		f.Delay = time.Duration(p.rand.Int63n(int64(p.MaxDelay)))
+	}
+	switch r := p.rand.Float64(); {
+	case r < p.ResetProb:
+		f.Reset = true
+	case r < p.ResetProb+p.ResetBodyProb:
+		f.ResetBody = true
+	}
+	p.faults = append(p.faults, f)
+	return f
+}
+
+// injected returns the faults injected so far, in request order.
+func (p *chaosPolicy) injected() []chaosFault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]chaosFault(nil), p.faults...)
+}
+
+// wrap returns a handler which injects faults into h's responses.
+// Streams are reset by aborting the handler, which the Server
+// reports to the client as RST_STREAM(INTERNAL_ERROR).
+func (p *chaosPolicy) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := p.choose()
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.Reset {
+			panic(http.ErrAbortHandler)
+		}
+		if f.ResetBody {
+			w = chaosResetWriter{w}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// chaosResetWriter aborts its handler after the first Write.
+type chaosResetWriter struct {
+	http.ResponseWriter
+}
+
+func (w chaosResetWriter) Write(p []byte) (int, error) {
+	w.ResponseWriter.Write(p)
+	w.ResponseWriter.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func TestChaosPolicyReproducible(t *testing.T) {
+	const requests = 20
+	run := func(seed int64) (faults []chaosFault, results []string) {
+		policy := &chaosPolicy{
+			Seed:          seed,
+			DelayProb:     0.3,
+			MaxDelay:      5 * time.Millisecond,
+			ResetProb:     0.2,
+			ResetBodyProb: 0.2,
+		}
+		ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "first;")
+			io.WriteString(w, "second")
+		}, policy, optQuiet)
+		tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+		defer tr.CloseIdleConnections()
+		for i := 0; i < requests; i++ {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			results = append(results, chaosResult(tr.RoundTrip(req)))
+		}
+		return policy.injected(), results
+	}
+
+	faults1, results1 := run(1)
+	faults2, results2 := run(1)
+	if fmt.Sprint(faults1) != fmt.Sprint(faults2) || fmt.Sprint(results1) != fmt.Sprint(results2) {
+		t.Fatalf("runs with the same seed differ:\n%v\n%v\n%v\n%v", faults1, results1, faults2, results2)
+	}
+
+	var resets, bodyResets, ok int
+	for i, f := range faults1 {
+		want := "ok"
+		switch {
+		case f.Reset:
+			resets++
+			want = "reset"
+		case f.ResetBody:
+			bodyResets++
+			want = "body reset"
+		default:
+			ok++
+		}
+		if results1[i] != want {
+			t.Errorf("request %v with faults %+v: got %v, want %v", i, f, results1[i], want)
+		}
+	}
+	if resets == 0 || bodyResets == 0 || ok == 0 {
+		t.Errorf("%v resets, %v body resets, %v successes; want some of each", resets, bodyResets, ok)
+	}
+}
+
+// chaosResult summarizes the outcome of a request to a chaosPolicy server.
+func chaosResult(res *http.Response, err error) string {
+	var se StreamError
+	if err != nil {
+		if errors.As(err, &se) && se.Code == ErrCodeInternal {
+			return "reset"
+		}
+		return err.Error()
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		if errors.As(err, &se) && se.Code == ErrCodeInternal && string(body) == "first;" {
+			return "body reset"
+		}
+		return err.Error()
+	}
+	if string(body) != "first;second" {
+		return fmt.Sprintf("body %q", body)
+	}
+	return "ok"
+}
@@ -10,8 +10,10 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // ClientConnPool manages a pool of HTTP/2 client connections.
@@ -47,8 +49,16 @@ type clientConnPool struct {
 	// (e.g. share conn for googleapis.com and appspot.com)
 	conns        map[string][]*ClientConn // key is host:port
 	dialing      map[string]*dialCall     // currently in-flight dials
+	dialFailed   map[string]bool          // addresses whose last dial failed
 	keys         map[*ClientConn][]string
 	addConnCalls map[string]*addConnCall // in-flight addConnIfNeeded calls
+	lookups      map[string]*lookupCall  // in-flight coalescing lookups, by host
+	ownDials     map[string]int          // in-flight dials not shared through dialing; see startOwnDialLocked
+
+	// connsChanged is closed, and reset to nil, when a pooled
+	// connection frees a stream or leaves the pool, waking requests
+	// waiting under Transport.MaxConnsPerHost.
+	connsChanged chan struct{}
 }
 
 func (p *clientConnPool) GetClientConn(req *http.Request, addr string) (*ClientConn, error) {
@@ -72,10 +82,22 @@ func (p *clientConnPool) getClientConn(req *http.Request, addr string, dialOnMis
 		}
 		return cc, nil
 	}
+	triedCoalesce := false
 	for {
 		p.mu.Lock()
-		for _, cc := range p.conns[addr] {
-			if cc.ReserveNewRequest() {
+		conns := p.conns[addr]
+		vetoed := false
+		if dialOnMiss && p.t.ReuseConn != nil {
+			// ClientConn.State may block on the connection's write lock,
+			// so consult the hook without holding p.mu.
+			conns = append([]*ClientConn(nil), conns...)
+			p.mu.Unlock()
+			conns, vetoed = p.t.reusableConns(req, conns)
+			p.mu.Lock()
+		}
+		spill := dialOnMiss && p.spillLocked(addr)
+		for _, cc := range conns {
+			if p.reserve(cc, spill) {
 				// When a connection is presented to us by the net/http package,
 				// the GetConn hook has already been called.
 				// Don't call it a second time here.
@@ -91,10 +113,76 @@ func (p *clientConnPool) getClientConn(req *http.Request, addr string, dialOnMis
 			p.mu.Unlock()
 			return nil, ErrNoCachedConn
 		}
+		if !triedCoalesce && !p.t.DisableConnectionCoalescing {
+			triedCoalesce = true
+			if cc := p.originConnLocked(addr); cc != nil {
+				p.addConnLocked(addr, cc)
+				p.mu.Unlock()
+				continue
+			}
+			if cands := p.coalesceCandidatesLocked(addr); len(cands) > 0 {
+				// Resolve the host without holding p.mu, sharing the
+				// lookup with other requests for the same host.
+				call := p.getStartLookupLocked(req.Context(), addr)
+				p.mu.Unlock()
+				select {
+				case <-call.done:
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+				p.mu.Lock()
+				if cc := p.coalesceConnLocked(call.ips, cands); cc != nil {
+					p.addConnLocked(addr, cc)
+				}
+				p.mu.Unlock()
+				continue
+			}
+		}
+		// A request which won't share an in-flight dial needs a slot
+		// under the limit of its own.
+		if p.atConnLimitLocked(addr) || (vetoed && p.connLimitReachedLocked(addr)) {
+			if p.t.MaxConnsPerHostFailFast {
+				p.mu.Unlock()
+				return nil, ErrMaxConnsPerHost
+			}
+			changed := p.connsChangedLocked()
+			p.mu.Unlock()
+			select {
+			case <-changed:
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			continue
+		}
 		traceGetConn(req, addr)
+		if vetoed {
+			// Transport.ReuseConn rejected the cached connections.
+			// Dial a connection of our own rather than sharing
+			// an in-flight dial started by some other request.
+			p.startOwnDialLocked(addr)
+			p.mu.Unlock()
+			cc, err := p.dialOwnConn(req.Context(), addr, nil)
+			if err != nil {
+				return nil, err
+			}
+			if cc.ReserveNewRequest() {
+				return cc, nil
+			}
+			continue
+		}
+		if p.failFastLocked(addr) {
+			p.mu.Unlock()
+			return nil, ErrDialInProgress
+		}
 		call := p.getStartDialLocked(req.Context(), addr)
 		p.mu.Unlock()
-		<-call.done
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			// The dial continues for any other requests waiting on it,
+			// unless this request started it.
+			return nil, req.Context().Err()
+		}
 		if shouldRetryDial(call, req) {
 			continue
 		}
@@ -102,12 +190,289 @@ func (p *clientConnPool) getClientConn(req *http.Request, addr string, dialOnMis
 		if err != nil {
 			return nil, err
 		}
-		if cc.ReserveNewRequest() {
+		if call.ctx != req.Context() && p.t.ReuseConn != nil && !p.t.ReuseConn(req, cc.State()) {
+			// The dial was started by another request, and the
+			// connection it produced is now in the pool.
+			continue
+		}
+		if p.reserve(cc, spill) {
 			return cc, nil
 		}
 	}
 }
 
+// spillLocked reports whether a request for addr should dial a new
+// connection rather than wait for a stream on a pooled connection
+// whose streams are all in use, under StrictMaxConcurrentStreams.
+// See Transport.StrictMaxConcurrentStreams.
+// p.mu must be held.
+func (p *clientConnPool) spillLocked(addr string) bool {
+	limit := p.t.MaxConnsPerHost
+	return p.t.StrictMaxConcurrentStreams && limit > 1 && len(p.conns[addr]) < limit
+}
+
+// reserve reserves a stream on cc for a new request. If spill is set,
+// it only reserves a stream which the server's
+// SETTINGS_MAX_CONCURRENT_STREAMS leaves free.
+func (p *clientConnPool) reserve(cc *ClientConn, spill bool) bool {
+	if spill {
+		return cc.reserveFreeStream()
+	}
+	return cc.ReserveNewRequest()
+}
+
+// lookupIPAddr resolves host names when coalescing connections.
+// It is a variable for testing.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// coalesceLookupTimeout bounds the lookup of a host made to find a
+// connection to coalesce requests for it onto.
+const coalesceLookupTimeout = 2 * time.Second
+
+// coalesceByDNS reports whether requests may be coalesced onto
+// connections found by resolving their host.
+// See Transport.CoalesceByDNS.
+func (t *Transport) coalesceByDNS() bool {
+	if !t.CoalesceByDNS {
+		return false
+	}
+	// A proxy or custom dialer decides the address it connects to,
+	// so the Transport's own lookup says nothing about it.
+	return t.Proxy == nil && t.DialTLSContext == nil && t.DialTLS == nil && t.DialClientConn == nil
+}
+
+// coalesceCandidatesLocked returns the pooled connections to other
+// hosts which could serve requests for addr: connections to the same
+// port, able to take a new request, whose certificate is valid for
+// addr's host, and which have not limited the origins they serve
+// with an ORIGIN frame. It returns nil unless Transport.CoalesceByDNS
+// permits coalescing.
+// requires p.mu is held.
+func (p *clientConnPool) coalesceCandidatesLocked(addr string) []*ClientConn {
+	if !p.t.coalesceByDNS() {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	var cands []*ClientConn
+	for cc := range p.keys {
+		if !certValidForHost(cc, host) || cc.hasOriginSet() {
+			continue
+		}
+		if _, ccPort, err := net.SplitHostPort(cc.tconn.RemoteAddr().String()); err != nil || ccPort != port {
+			continue
+		}
+		if cc.CanTakeNewRequest() {
+			cands = append(cands, cc)
+		}
+	}
+	return cands
+}
+
+// originConnLocked returns a pooled connection to another host which
+// listed addr in an ORIGIN frame, is able to take a new request, and
+// whose certificate is valid for addr's host, or nil if there is none.
+// Such a connection may be used for addr without consulting DNS
+// (RFC 8336, Section 2.4).
+// requires p.mu is held.
+func (p *clientConnPool) originConnLocked(addr string) *ClientConn {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	for cc := range p.keys {
+		if certValidForHost(cc, host) && cc.inOriginSet(addr) && cc.CanTakeNewRequest() {
+			return cc
+		}
+	}
+	return nil
+}
+
+// certValidForHost reports whether cc is a TLS connection whose
+// server certificate is valid for host.
+func certValidForHost(cc *ClientConn, host string) bool {
+	if cc.tlsState == nil || len(cc.tlsState.PeerCertificates) == 0 || cc.tconn == nil {
+		return false
+	}
+	return cc.tlsState.PeerCertificates[0].VerifyHostname(host) == nil
+}
+
+// lookupCall is an in-flight lookup of the addresses of a host,
+// made to decide whether a pooled connection may be coalesced for it.
+type lookupCall struct {
+	done chan struct{} // closed when done
+	ips  []net.IP      // valid after done is closed; nil if the lookup failed
+}
+
+// getStartLookupLocked returns the in-flight lookup of addr's host,
+// starting one bound to ctx if there is none.
+// requires p.mu is held.
+func (p *clientConnPool) getStartLookupLocked(ctx context.Context, addr string) *lookupCall {
+	host, _, _ := net.SplitHostPort(addr)
+	if call, ok := p.lookups[host]; ok {
+		return call
+	}
+	call := &lookupCall{done: make(chan struct{})}
+	if ip := net.ParseIP(host); ip != nil {
+		call.ips = []net.IP{ip}
+		close(call.done)
+		return call
+	}
+	if p.lookups == nil {
+		p.lookups = make(map[string]*lookupCall)
+	}
+	p.lookups[host] = call
+	go call.lookup(ctx, p, host)
+	return call
+}
+
+// run in its own goroutine.
+func (c *lookupCall) lookup(ctx context.Context, p *clientConnPool, host string) {
+	// Requests sharing the lookup which outlive the one that started
+	// it see a failed lookup, and dial a connection of their own.
+	ctx, cancel := p.t.contextWithTimeout(ctx, coalesceLookupTimeout)
+	defer cancel()
+	ipAddrs, err := lookupIPAddr(ctx, host)
+	if err == nil {
+		for _, ia := range ipAddrs {
+			c.ips = append(c.ips, ia.IP)
+		}
+	}
+	p.mu.Lock()
+	delete(p.lookups, host)
+	p.mu.Unlock()
+	close(c.done)
+}
+
+// coalesceConnLocked returns a connection from cands whose peer's IP
+// address is one of ips, or nil if there is none. The connection must
+// still be pooled and able to take a new request.
+// This follows RFC 9113, Section 9.1.1: a connection may be reused for
+// an origin whose host resolves to the connection's address and which
+// is covered by the server's certificate.
+// requires p.mu is held.
+func (p *clientConnPool) coalesceConnLocked(ips []net.IP, cands []*ClientConn) *ClientConn {
+	for _, cc := range cands {
+		if _, ok := p.keys[cc]; !ok || !cc.CanTakeNewRequest() {
+			continue
+		}
+		ccHost, _, err := net.SplitHostPort(cc.tconn.RemoteAddr().String())
+		if err != nil {
+			continue
+		}
+		ccIP := net.ParseIP(ccHost)
+		for _, ip := range ips {
+			if ip.Equal(ccIP) {
+				return cc
+			}
+		}
+	}
+	return nil
+}
+
+// reusableConns returns the connections in conns which t.ReuseConn
+// permits req to use, and whether any were rejected.
+func (t *Transport) reusableConns(req *http.Request, conns []*ClientConn) (ok []*ClientConn, vetoed bool) {
+	ok = conns[:0]
+	for _, cc := range conns {
+		if t.ReuseConn(req, cc.State()) {
+			ok = append(ok, cc)
+		} else {
+			vetoed = true
+		}
+	}
+	return ok, vetoed
+}
+
+// preconnect dials a new connection to addr, waits for it to be
+// ready to use, and adds it to the pool. It fails with
+// ErrMaxConnsPerHost if the pool already has, or is dialing,
+// Transport.MaxConnsPerHost connections to addr.
+func (p *clientConnPool) preconnect(ctx context.Context, addr string) error {
+	p.mu.Lock()
+	if p.connLimitReachedLocked(addr) {
+		p.mu.Unlock()
+		return ErrMaxConnsPerHost
+	}
+	p.startOwnDialLocked(addr)
+	p.mu.Unlock()
+	_, err := p.dialOwnConn(ctx, addr, func(cc *ClientConn) error {
+		// The server's SETTINGS frame precedes its PING ack, so once
+		// the ack arrives the connection is fully established.
+		return cc.Ping(ctx)
+	})
+	return err
+}
+
+// startOwnDialLocked counts a dial to addr which is not shared through
+// p.dialing against Transport.MaxConnsPerHost, until dialOwnConn
+// completes it.
+// p.mu must be held.
+func (p *clientConnPool) startOwnDialLocked(addr string) {
+	if p.ownDials == nil {
+		p.ownDials = make(map[string]int)
+	}
+	p.ownDials[addr]++
+}
+
+// dialOwnConn dials a connection to addr, counted by
+// startOwnDialLocked, and adds it to the pool. If ready is non-nil,
+// the connection is only added once ready returns nil for it.
+func (p *clientConnPool) dialOwnConn(ctx context.Context, addr string, ready func(*ClientConn) error) (*ClientConn, error) {
+	const singleUse = false // shared conn
+	cc, err := p.t.dialClientConn(ctx, addr, singleUse)
+	if err == nil && ready != nil {
+		if err = ready(cc); err != nil {
+			cc.Close()
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ownDials[addr]--; p.ownDials[addr] <= 0 {
+		delete(p.ownDials, addr)
+	}
+	if err != nil {
+		// The dial no longer counts against the limit.
+		p.wakeConnWaitersLocked()
+		return nil, err
+	}
+	p.addConnLocked(addr, cc)
+	return cc, nil
+}
+
+// hasUsableConnLocked reports whether the pool has a connection or
+// an in-flight dial for addr which can take new requests.
+// requires p.mu is held.
+func (p *clientConnPool) hasUsableConnLocked(addr string) bool {
+	if _, ok := p.dialing[addr]; ok {
+		return true
+	}
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			return true
+		}
+	}
+	return false
+}
+
+// startReplacementDialsLocked starts dialing a connection for each of
+// addrs which has no usable connection, so that requests need not wait
+// for a dial once a draining connection stops taking them. It reports
+// whether any dial was started.
+// requires p.mu is held.
+func (p *clientConnPool) startReplacementDialsLocked(addrs []string) bool {
+	started := false
+	for _, addr := range addrs {
+		if !p.hasUsableConnLocked(addr) {
+			p.getStartDialLocked(context.Background(), addr)
+			started = true
+		}
+	}
+	return started
+}
+
 // dialCall is an in-flight Transport dial call to a host.
 type dialCall struct {
 	_ incomparable
@@ -144,12 +509,107 @@ func (c *dialCall) dial(ctx context.Context, addr string) {
 	delete(c.p.dialing, addr)
 	if c.err == nil {
 		c.p.addConnLocked(addr, c.res)
+		delete(c.p.dialFailed, addr)
+	} else if c.ctx.Err() == nil {
+		// Dials abandoned by their request say nothing about the host.
+		if c.p.dialFailed == nil {
+			c.p.dialFailed = make(map[string]bool)
+		}
+		c.p.dialFailed[addr] = true
 	}
 	c.p.mu.Unlock()
 
 	close(c.done)
 }
 
+// A DialPolicy controls what happens to a request which needs a
+// connection to an address while a connection to it is being dialed.
+// See Transport.DialPolicy.
+type DialPolicy int
+
+const (
+	// DialPolicyWait waits for the in-flight dial to complete and
+	// uses the connection it creates.
+	DialPolicyWait DialPolicy = iota
+
+	// DialPolicyFailFast fails the request with ErrDialInProgress.
+	// Only the request which started the dial waits for it.
+	DialPolicyFailFast
+
+	// DialPolicyFailFastAfterError fails the request with
+	// ErrDialInProgress if the previous dial to the address failed,
+	// and otherwise waits like DialPolicyWait. Requests to a host
+	// which is down then fail without waiting for each redial.
+	DialPolicyFailFastAfterError
+)
+
+// failFastLocked reports whether a request for addr should fail with
+// ErrDialInProgress rather than wait for an in-flight dial.
+// requires p.mu is held.
+func (p *clientConnPool) failFastLocked(addr string) bool {
+	if _, ok := p.dialing[addr]; !ok {
+		return false
+	}
+	switch p.t.DialPolicy {
+	case DialPolicyFailFast:
+		return true
+	case DialPolicyFailFastAfterError:
+		return p.dialFailed[addr]
+	}
+	return false
+}
+
+// atConnLimitLocked reports whether a request for addr must not dial
+// a new connection, because the pool holds Transport.MaxConnsPerHost
+// connections for addr. A request may always join an in-flight dial.
+// p.mu must be held.
+func (p *clientConnPool) atConnLimitLocked(addr string) bool {
+	if _, ok := p.dialing[addr]; ok {
+		return false
+	}
+	return p.connLimitReachedLocked(addr)
+}
+
+// connLimitReachedLocked reports whether the pool holds, or is
+// dialing, Transport.MaxConnsPerHost connections for addr.
+// p.mu must be held.
+func (p *clientConnPool) connLimitReachedLocked(addr string) bool {
+	limit := p.t.MaxConnsPerHost
+	if limit <= 0 {
+		return false
+	}
+	n := len(p.conns[addr]) + p.ownDials[addr]
+	if _, ok := p.dialing[addr]; ok {
+		n++
+	}
+	return n >= limit
+}
+
+// connsChangedLocked returns a channel which is closed when a pooled
+// connection frees a stream or leaves the pool.
+// p.mu must be held.
+func (p *clientConnPool) connsChangedLocked() chan struct{} {
+	if p.connsChanged == nil {
+		p.connsChanged = make(chan struct{})
+	}
+	return p.connsChanged
+}
+
+// wakeConnWaiters wakes the requests waiting for a pooled connection
+// under Transport.MaxConnsPerHost.
+func (p *clientConnPool) wakeConnWaiters() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wakeConnWaitersLocked()
+}
+
+func (p *clientConnPool) wakeConnWaitersLocked() {
+	if p.connsChanged != nil {
+		close(p.connsChanged)
+		p.connsChanged = nil
+	}
+}
+
 // addConnIfNeeded makes a NewClientConn out of c if a connection for key doesn't
 // already exist. It coalesces concurrent calls with the same key.
 // This is used by the http1 Transport code when it creates a new connection. Because
@@ -195,7 +655,7 @@ type addConnCall struct {
 }
 
 func (c *addConnCall) run(t *Transport, key string, tc *tls.Conn) {
-	cc, err := t.NewClientConn(tc)
+	cc, err := t.newClientConn(tc, key, t.disableKeepAlives())
 
 	p := c.p
 	p.mu.Lock()
@@ -225,11 +685,21 @@ func (p *clientConnPool) addConnLocked(key string, cc *ClientConn) {
 	}
 	p.conns[key] = append(p.conns[key], cc)
 	p.keys[cc] = append(p.keys[cc], key)
+	p.notifyLocked(PoolConnAdded, key, cc)
 }
 
 func (p *clientConnPool) MarkDead(cc *ClientConn) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if _, ok := p.keys[cc]; ok {
+		p.notifyLocked(PoolConnMarkedDead, "", cc)
+	}
+	p.removeConnLocked(cc)
+}
+
+// removeConnLocked removes cc from the pool under all of its keys.
+// p.mu must be held
+func (p *clientConnPool) removeConnLocked(cc *ClientConn) {
 	for _, key := range p.keys[cc] {
 		vv, ok := p.conns[key]
 		if !ok {
@@ -241,8 +711,76 @@ func (p *clientConnPool) MarkDead(cc *ClientConn) {
 		} else {
 			delete(p.conns, key)
 		}
+		p.notifyLocked(PoolConnRemoved, key, cc)
 	}
 	delete(p.keys, cc)
+	p.wakeConnWaitersLocked()
+}
+
+// notifyLocked reports a change to the pool to Transport.ConnPoolEvent.
+// p.mu must be held
+func (p *clientConnPool) notifyLocked(kind PoolEventKind, addr string, cc *ClientConn) {
+	if fn := p.t.ConnPoolEvent; fn != nil {
+		fn(PoolEvent{Kind: kind, Addr: addr, Conn: cc})
+	}
+}
+
+// A PoolEventKind is a kind of PoolEvent.
+type PoolEventKind int
+
+const (
+	// PoolConnAdded is a connection added to the pool for an address.
+	// A connection coalesced for another host's requests is added for
+	// that host's address too.
+	PoolConnAdded PoolEventKind = iota + 1
+
+	// PoolConnRemoved is a connection removed from the pool for an
+	// address, because it was marked dead or by
+	// Transport.RemoveClientConn.
+	PoolConnRemoved
+
+	// PoolConnMarkedDead is a connection which stopped taking new
+	// requests, such as after a GOAWAY, at the end of its
+	// Transport.MaxConnLifetime, or when it closed. It is followed by
+	// a PoolConnRemoved event for each of the connection's addresses.
+	PoolConnMarkedDead
+)
+
+func (k PoolEventKind) String() string {
+	switch k {
+	case PoolConnAdded:
+		return "added"
+	case PoolConnRemoved:
+		return "removed"
+	case PoolConnMarkedDead:
+		return "marked dead"
+	}
+	return "unknown"
+}
+
+// A PoolEvent is a change to the Transport's connection pool.
+// See Transport.ConnPoolEvent.
+type PoolEvent struct {
+	Kind PoolEventKind
+
+	// Addr is the address, with a port, that the connection was added
+	// to or removed from the pool for. It is empty for
+	// PoolConnMarkedDead events.
+	Addr string
+
+	Conn *ClientConn
+}
+
+// A PooledConn is a connection in the Transport's connection pool.
+// See Transport.PooledConns.
+type PooledConn struct {
+	// Addr is the address, with a port, that the connection is pooled
+	// for. A connection pooled for several addresses appears once
+	// for each of them.
+	Addr string
+
+	Conn  *ClientConn
+	State ClientConnState
 }
 
 func (p *clientConnPool) closeIdleConnections() {
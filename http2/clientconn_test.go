@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"reflect"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -104,6 +105,7 @@ type testClientConn struct {
 	roundtrips []*testRoundTrip
 
 	netconn *synctestNetConn
+	addr    string // address dialed, if created by a testTransport dial
 }
 
 func newTestClientConnFromClientConn(t *testing.T, cc *ClientConn) *testClientConn {
@@ -154,7 +156,7 @@ func newTestClientConn(t *testing.T, opts ...func(*Transport)) *testClientConn {
 
 	tt := newTestTransport(t, opts...)
 	const singleUse = false
-	_, err := tt.tr.newClientConn(nil, singleUse)
+	_, err := tt.tr.newClientConn(nil, "", singleUse)
 	if err != nil {
 		t.Fatalf("newClientConn: %v", err)
 	}
@@ -179,6 +181,27 @@ func (tc *testClientConn) hasFrame() bool {
 	return len(tc.netconn.Peek()) > 0
 }
 
+// wantFrameWithin advances synthetic time until a frame is available,
+// by at most d, and then reads the frame as wantFrameMatching does.
+// It produces an error if no frame is sent within d.
+func (tc *testClientConn) wantFrameWithin(d time.Duration, match ...func(Frame) error) Frame {
+	tc.t.Helper()
+	step := d / 100
+	if step < time.Millisecond {
+		step = time.Millisecond
+	}
+	for elapsed := time.Duration(0); ; elapsed += step {
+		if fr := tc.readFrame(); fr != nil {
+			tc.matchFrame(fr, match)
+			return fr
+		}
+		if elapsed >= d {
+			tc.t.Fatalf("no frame sent within %v", d)
+		}
+		tc.advance(step)
+	}
+}
+
 // isClosed reports whether the peer has closed the connection.
 func (tc *testClientConn) isClosed() bool {
 	return tc.netconn.IsClosedByPeer()
@@ -190,6 +213,70 @@ func (tc *testClientConn) closeWrite() {
 	tc.netconn.Close()
 }
 
+// setReadChunkSize limits each read by the ClientConn from its
+// net.Conn to at most size bytes, splitting frames written by the
+// test across many reads.
+func (tc *testClientConn) setReadChunkSize(size int) {
+	tc.cc.tconn.(*synctestNetConn).SetReadChunkSize(size)
+}
+
+// writeTornFrame writes the first n bytes of the frames written by f,
+// and then closes the connection, producing an EOF in the middle of a frame.
+func (tc *testClientConn) writeTornFrame(n int, f func(fr *Framer) error) {
+	tc.t.Helper()
+	var buf bytes.Buffer
+	fr := NewFramer(&buf, nil)
+	fr.AllowIllegalWrites = true
+	if err := f(fr); err != nil {
+		tc.t.Fatalf("writing torn frame: %v", err)
+	}
+	if n > buf.Len() {
+		tc.t.Fatalf("writeTornFrame(%v): frame is only %v bytes", n, buf.Len())
+	}
+	tc.netconn.Write(buf.Bytes()[:n])
+	tc.closeWrite()
+}
+
+// delayFrames delays delivery to the ClientConn of frames of type typ
+// written by the test by d of synthetic time.
+// Frames written after a delayed frame may arrive before it, and the
+// order of delayed frames released at the same time is unspecified.
+func (tc *testClientConn) delayFrames(typ FrameType, d time.Duration) {
+	w, ok := tc.fr.w.(*faultWriter)
+	if !ok {
+		w = &faultWriter{
+			group: tc.group,
+			conn:  tc.netconn,
+			delay: make(map[FrameType]time.Duration),
+		}
+		tc.fr.w = w
+	}
+	w.delay[typ] = d
+}
+
+// A faultWriter writes frames to a synctestNetConn,
+// delaying frames of selected types.
+// It relies on each Write containing exactly one frame, as Framer does.
+type faultWriter struct {
+	group *synctestGroup
+	conn  *synctestNetConn
+	delay map[FrameType]time.Duration
+}
+
+func (w *faultWriter) Write(b []byte) (int, error) {
+	if len(b) >= frameHeaderLen {
+		if d, ok := w.delay[FrameType(b[3])]; ok {
+			b := bytes.Clone(b)
+			w.group.AfterFunc(d, func() {
+				// Runs in a group goroutine, so it must not call group.Wait.
+				w.conn.rem.write(b)
+			})
+			return len(b), nil
+		}
+	}
+	return w.conn.Write(b)
+}
+
 // testRequestBody is a Request.Body for use in tests.
 type testRequestBody struct {
 	tc   *testClientConn
@@ -484,7 +571,10 @@ type testTransport struct {
 	tr    *Transport
 	group *synctestGroup
 
-	ccs []*testClientConn
+	mu    sync.Mutex // guards following fields, which concurrent dials may modify
+	ccs   []*testClientConn
+	dials []string                // addresses dialed, in order
+	dial  func(addr string) error // if non-nil, called for each dial; an error fails the dial
 }
 
 func newTestTransport(t *testing.T, opts ...func(*Transport)) *testTransport {
@@ -504,8 +594,28 @@ func newTestTransport(t *testing.T, opts ...func(*Transport)) *testTransport {
 		group: tt.group,
 		newclientconn: func(cc *ClientConn) {
 			tc := newTestClientConnFromClientConn(t, cc)
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
 			tt.ccs = append(tt.ccs, tc)
 		},
+		dial: func(addr string) error {
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
+			tt.dials = append(tt.dials, addr)
+			if tt.dial != nil {
+				return tt.dial(addr)
+			}
+			return nil
+		},
+		dialed: func(addr string, cc *ClientConn) {
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
+			for _, tc := range tt.ccs {
+				if tc.cc == cc {
+					tc.addr = addr
+				}
+			}
+		},
 	}
 
 	t.Cleanup(func() {
@@ -534,22 +644,65 @@ func (tt *testTransport) advance(d time.Duration) {
 }
 
 func (tt *testTransport) hasConn() bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
 	return len(tt.ccs) > 0
 }
 
 func (tt *testTransport) getConn() *testClientConn {
 	tt.t.Helper()
+	tt.mu.Lock()
 	if len(tt.ccs) == 0 {
+		tt.mu.Unlock()
 		tt.t.Fatalf("no new ClientConns created; wanted one")
 	}
 	tc := tt.ccs[0]
 	tt.ccs = tt.ccs[1:]
+	tt.mu.Unlock()
+	tc.sync()
+	tc.readClientPreface()
+	tc.sync()
+	return tc
+}
+
+// getConnTo returns the oldest new ClientConn dialed to addr,
+// leaving any conns to other addresses for later calls.
+func (tt *testTransport) getConnTo(addr string) *testClientConn {
+	tt.t.Helper()
+	tt.sync()
+	tt.mu.Lock()
+	var tc *testClientConn
+	for i := range tt.ccs {
+		if tt.ccs[i].addr == addr {
+			tc = tt.ccs[i]
+			tt.ccs = append(tt.ccs[:i:i], tt.ccs[i+1:]...)
+			break
+		}
+	}
+	tt.mu.Unlock()
+	if tc == nil {
+		tt.t.Fatalf("no new ClientConns to %v created; wanted one", addr)
+	}
 	tc.sync()
 	tc.readClientPreface()
 	tc.sync()
 	return tc
 }
 
+// wantDials asserts that the Transport dialed exactly the addresses in want,
+// in order, since the last call to wantDials.
+func (tt *testTransport) wantDials(want ...string) {
+	tt.t.Helper()
+	tt.sync()
+	tt.mu.Lock()
+	got := tt.dials
+	tt.dials = nil
+	tt.mu.Unlock()
+	if !reflect.DeepEqual(got, want) && (len(got) > 0 || len(want) > 0) {
+		tt.t.Fatalf("dialed %q, want %q", got, want)
+	}
+}
+
 func (tt *testTransport) roundTrip(req *http.Request) *testRoundTrip {
 	rt := &testRoundTrip{
 		t:     tt.t,
@@ -0,0 +1,222 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errPriorityGroupStreamID = errors.New("http2: stream ID is not a priority group or stream of this connection")
+	errPrioritySelfDep       = errors.New("http2: stream cannot depend on itself")
+)
+
+// NewPriorityGroup allocates a stream ID which is never opened, and
+// sends a PRIORITY frame giving it the priority p. Requests can depend
+// on the returned ID, to build a dependency tree for servers whose
+// schedulers use one (RFC 7540, Section 5.3), as some browsers do.
+//
+// Servers may discard the priority of idle streams at any time, and
+// RFC 9113 deprecates the RFC 7540 priority scheme; many servers ignore
+// PRIORITY frames entirely.
+func (cc *ClientConn) NewPriorityGroup(ctx context.Context, p PriorityParam) (uint32, error) {
+	// Hold the new-request lock, so that the PRIORITY frame is written
+	// before the HEADERS of any stream with a higher ID. Otherwise,
+	// the group's stream would be implicitly closed before it is used.
+	select {
+	case cc.reqHeaderMu <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-cc.reqHeaderMu }()
+
+	cc.mu.Lock()
+	if cc.closed || cc.closing || cc.goAway != nil || cc.singleUse ||
+		int64(cc.nextStreamID)+2 >= math.MaxInt32 {
+		cc.mu.Unlock()
+		return 0, errClientConnUnusable
+	}
+	id := cc.nextStreamID
+	cc.nextStreamID += 2
+	cc.mu.Unlock()
+
+	if err := cc.writePriority(id, p); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SetPriority sends a PRIORITY frame changing the priority of streamID,
+// which must be a priority group returned by NewPriorityGroup or the
+// ID of a stream opened by this ClientConn.
+func (cc *ClientConn) SetPriority(streamID uint32, p PriorityParam) error {
+	cc.mu.Lock()
+	next := cc.nextStreamID
+	cc.mu.Unlock()
+	if streamID%2 != 1 || streamID >= next {
+		return errPriorityGroupStreamID
+	}
+	return cc.writePriority(streamID, p)
+}
+
+func (cc *ClientConn) writePriority(streamID uint32, p PriorityParam) error {
+	if p.StreamDep == streamID {
+		return errPrioritySelfDep
+	}
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	if cc.werr != nil {
+		return cc.werr
+	}
+	if err := cc.fr.WritePriority(streamID, p); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a new context based on ctx which sends the given
+// RFC 7540 priority with the HEADERS of requests made with a Transport,
+// overriding Transport.PriorityFunc. The dependency may be a stream
+// group from ClientConn.NewPriorityGroup.
+//
+// The zero PriorityParam is not sent, leaving the stream at the
+// server's default priority. Use ClientConn.SetPriority to change
+// the priority of a stream once its request has been sent.
+func WithPriority(ctx context.Context, p PriorityParam) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// requestPriority returns the priority to send with req's HEADERS.
+func (t *Transport) requestPriority(req *http.Request) PriorityParam {
+	if p, ok := req.Context().Value(priorityKey{}).(PriorityParam); ok {
+		return p
+	}
+	if t.PriorityFunc != nil {
+		return t.PriorityFunc(req)
+	}
+	return PriorityParam{}
+}
+
+// An ExtensiblePriority is an RFC 9218 priority, the replacement for
+// the deprecated RFC 7540 priority scheme.
+// It is sent as the priority header field of a request, and in
+// PRIORITY_UPDATE frames when changed.
+type ExtensiblePriority struct {
+	// Urgency is the request's urgency, from 0 (most urgent) to 7.
+	// The default is 3.
+	Urgency int
+
+	// Incremental reports whether the response can be processed
+	// incrementally, as its data arrives.
+	Incremental bool
+}
+
+// DefaultExtensiblePriority is the priority servers assume for requests
+// which do not specify one.
+var DefaultExtensiblePriority = ExtensiblePriority{Urgency: 3}
+
+var errInvalidUrgency = errors.New("http2: priority urgency not in range 0-7")
+
+// String returns p as a Priority Field Value, such as "u=1, i".
+// Default parameters are omitted, so the default priority is "".
+func (p ExtensiblePriority) String() string {
+	var s string
+	if p.Urgency != DefaultExtensiblePriority.Urgency {
+		s = "u=" + strconv.Itoa(p.Urgency)
+	}
+	if p.Incremental {
+		if s != "" {
+			s += ", "
+		}
+		s += "i"
+	}
+	return s
+}
+
+func (p ExtensiblePriority) valid() bool {
+	return p.Urgency >= 0 && p.Urgency <= 7
+}
+
+type extensiblePriorityKey struct{}
+
+// WithExtensiblePriority returns a new context based on ctx which sends
+// the priority p with requests made with a Transport.
+// The priority header field of a request, if set, takes precedence.
+// Use UpdateExtensiblePriority to change the priority of a request
+// once it has been sent.
+func WithExtensiblePriority(ctx context.Context, p ExtensiblePriority) context.Context {
+	return context.WithValue(ctx, extensiblePriorityKey{}, p)
+}
+
+// requestExtensiblePriority returns the priority field value to send
+// as req's priority header, if any.
+func requestExtensiblePriority(req *http.Request) (string, error) {
+	p, ok := req.Context().Value(extensiblePriorityKey{}).(ExtensiblePriority)
+	if !ok {
+		return "", nil
+	}
+	if !p.valid() {
+		return "", errInvalidUrgency
+	}
+	if _, ok := req.Header["Priority"]; ok {
+		return "", nil
+	}
+	return p.String(), nil
+}
+
+var errNoPriorityStream = errors.New("http2: response is not from an HTTP/2 stream")
+
+// bodyStream returns the stream carrying a response body returned by
+// the Transport, or nil if body is not one.
+func bodyStream(body io.ReadCloser) *clientStream {
+	switch body := body.(type) {
+	case transportResponseBody:
+		return body.cs
+	case *gzipReader:
+		return bodyStream(body.body)
+	case *decompressReader:
+		return bodyStream(body.body)
+	case hedgedBody:
+		return bodyStream(body.ReadCloser)
+	}
+	return nil
+}
+
+// UpdateExtensiblePriority changes the priority of the request whose
+// response is res, by sending a PRIORITY_UPDATE frame. res must have
+// been returned by a Transport or ClientConn.
+//
+// Servers which do not support RFC 9218 ignore the update.
+func UpdateExtensiblePriority(res *http.Response, p ExtensiblePriority) error {
+	if !p.valid() {
+		return errInvalidUrgency
+	}
+	cs := bodyStream(res.Body)
+	if cs == nil {
+		return errNoPriorityStream
+	}
+	cc := cs.cc
+	select {
+	case <-cs.donec:
+		return errStreamClosed
+	default:
+	}
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	if cc.werr != nil {
+		return cc.werr
+	}
+	if err := cc.fr.WritePriorityUpdate(cs.ID, p.String()); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
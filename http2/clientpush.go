@@ -0,0 +1,220 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// A PushPromise is a server's promise to push the response to a request.
+// See Transport.PushHandler.
+type PushPromise struct {
+	// Request is the promised request. It has no body.
+	Request *http.Request
+
+	cache PushCache
+}
+
+// Accept accepts the push. The pushed response will be delivered to c.
+func (p *PushPromise) Accept(c PushCache) {
+	p.cache = c
+}
+
+// A PushCache receives responses pushed by a server.
+type PushCache interface {
+	// Fill is called with the response to an accepted PushPromise
+	// when its headers are received. It is called from a new
+	// goroutine, and must read and close res.Body.
+	//
+	// If the push fails before its response headers are received,
+	// Fill is not called.
+	Fill(req *http.Request, res *http.Response)
+}
+
+var errPushRefused = errors.New("http2: push refused")
+
+// processPushPromise handles a PUSH_PROMISE frame. It is only called
+// when Transport.PushHandler is set.
+func (rl *clientConnReadLoop) processPushPromise(f *metaPushPromiseFrame) error {
+	cc := rl.cc
+	if f.MetaHeadersFrame != nil {
+		cc.hpackStats.decoded(cc.fr)
+	}
+
+	// "Promised stream identifiers [...] MUST be a valid choice for
+	// the next stream sent by the sender." RFC 9113, Section 6.6.
+	if f.PromiseID%2 != 0 || f.PromiseID <= rl.lastPushID {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	rl.lastPushID = f.PromiseID
+
+	cc.mu.Lock()
+	neverSent := cc.nextStreamID
+	cc.mu.Unlock()
+	if f.StreamID%2 == 0 || f.StreamID >= neverSent {
+		// Pushes must be associated with a request we sent.
+		return ConnectionError(ErrCodeProtocol)
+	}
+	assoc := rl.streamByID(f.StreamID)
+	if assoc == nil {
+		// We canceled the associated request.
+		cc.writeStreamReset(f.PromiseID, ErrCodeCancel, errPushRefused)
+		return nil
+	}
+	if assoc.readClosed {
+		return ConnectionError(ErrCodeProtocol)
+	}
+
+	if f.invalid != nil || f.Truncated {
+		cc.writeStreamReset(f.PromiseID, ErrCodeProtocol, f.invalid)
+		return nil
+	}
+	req, err := cc.pushedRequest(f)
+	if err != nil {
+		cc.vlogf("http2: Transport refusing push of stream %v: %v", f.PromiseID, err)
+		cc.writeStreamReset(f.PromiseID, ErrCodeProtocol, err)
+		return nil
+	}
+
+	p := &PushPromise{Request: req}
+	cc.t.PushHandler(p)
+	if p.cache == nil {
+		cc.writeStreamReset(f.PromiseID, ErrCodeCancel, errPushRefused)
+		return nil
+	}
+
+	cs := &clientStream{
+		cc:             cc,
+		ctx:            req.Context(),
+		req:            req,
+		isHead:         req.Method == "HEAD",
+		peerClosed:     make(chan struct{}),
+		abort:          make(chan struct{}),
+		respHeaderRecv: make(chan struct{}),
+		donec:          make(chan struct{}),
+		declBodyBytes:  -1,
+		// The promised request is complete; only the response is sent.
+		sentHeaders:   true,
+		sentEndStream: true,
+	}
+	cc.mu.Lock()
+	if cc.closed {
+		cc.mu.Unlock()
+		return nil
+	}
+	cs.flow.add(int32(cc.initialWindowSize))
+	cs.flow.setConnFlow(&cc.flow)
+	cs.inflow.init(cc.streamInflowWindow)
+	cs.inflow.policy = cc.t.FlowControl
+	cs.ID = f.PromiseID
+	cc.streams[cs.ID] = cs
+	cc.pushStreams++
+	cc.mu.Unlock()
+
+	go cs.doPush(req, p.cache)
+	return nil
+}
+
+// pushedRequest returns the request promised by f.
+func (cc *ClientConn) pushedRequest(f *metaPushPromiseFrame) (*http.Request, error) {
+	method := f.PseudoValue("method")
+	scheme := f.PseudoValue("scheme")
+	authority := f.PseudoValue("authority")
+	path := f.PseudoValue("path")
+	if f.PseudoValue("status") != "" {
+		return nil, errors.New("promised request has :status")
+	}
+	// Promised requests must be safe and cacheable. RFC 9113, Section 8.4.
+	if method != "GET" && method != "HEAD" {
+		return nil, errors.New("promised request has method " + method)
+	}
+	if authority == "" || path == "" {
+		return nil, errors.New("promised request missing :authority or :path")
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, errors.New("promised request has malformed :path")
+	}
+	u.Scheme = scheme
+	u.Host = authority
+
+	// The server must be authoritative for the pushed response.
+	// RFC 9113, Section 8.4.
+	if cc.tlsState != nil {
+		host := authority
+		if h, _, err := net.SplitHostPort(authority); err == nil {
+			host = h
+		}
+		if scheme != "https" {
+			return nil, errors.New("promised request has scheme " + scheme)
+		}
+		if len(cc.tlsState.PeerCertificates) == 0 {
+			return nil, errors.New("server has no certificate")
+		}
+		if err := cc.tlsState.PeerCertificates[0].VerifyHostname(host); err != nil {
+			return nil, err
+		}
+	} else if scheme != "http" {
+		return nil, errors.New("promised request has scheme " + scheme)
+	}
+
+	regularFields := f.RegularFields()
+	header := make(http.Header, len(regularFields))
+	for _, hf := range regularFields {
+		key := canonicalHeader(hf.Name)
+		header[key] = append(header[key], hf.Value)
+	}
+	req := &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     header,
+		Host:       authority,
+		Body:       http.NoBody,
+	}
+	return req.WithContext(context.Background()), nil
+}
+
+// doPush runs for the lifetime of a pushed stream, delivering its
+// response to c and performing post-stream cleanup.
+func (cs *clientStream) doPush(req *http.Request, c PushCache) {
+	cs.cc.t.markNewGoroutine()
+	cs.cleanupWriteRequest(cs.awaitPush(req, c))
+}
+
+// awaitPush waits for the pushed response, delivers it to c,
+// and waits for the stream to end.
+func (cs *clientStream) awaitPush(req *http.Request, c PushCache) error {
+	select {
+	case <-cs.respHeaderRecv:
+	case <-cs.abort:
+		select {
+		case <-cs.respHeaderRecv:
+			// The server wrote the response and reset the stream.
+		default:
+			return cs.abortErr
+		}
+	}
+	res := cs.res
+	res.Request = req
+	res.TLS = cs.cc.tlsState
+	// Closing the body waits for the stream to be cleaned up,
+	// so Fill must not be called from this goroutine.
+	go func() {
+		cs.cc.t.markNewGoroutine()
+		c.Fill(req, res)
+	}()
+	select {
+	case <-cs.peerClosed:
+		return nil
+	case <-cs.abort:
+		return cs.abortErr
+	}
+}
@@ -7,6 +7,8 @@ package http2
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -18,15 +20,17 @@ import (
 )
 
 type testConnFramer struct {
-	t   testing.TB
-	fr  *Framer
-	dec *hpack.Decoder
+	t    testing.TB
+	fr   *Framer
+	dec  *hpack.Decoder
+	skip []func(Frame) bool // frames to discard; see skipFrames
 }
 
-// readFrame reads the next frame.
+// readFrame reads the next frame, discarding any frames matched by skipFrames.
 // It returns nil if the conn is closed or no frames are available.
 func (tf *testConnFramer) readFrame() Frame {
 	tf.t.Helper()
+next:
 	fr, err := tf.fr.ReadFrame()
 	if err == io.EOF || err == os.ErrDeadlineExceeded {
 		return nil
@@ -34,9 +38,101 @@ func (tf *testConnFramer) readFrame() Frame {
 	if err != nil {
 		tf.t.Fatalf("ReadFrame: %v", err)
 	}
+	for _, skip := range tf.skip {
+		if skip(fr) {
+			goto next
+		}
+	}
+	return fr
+}
+
+// skipFrames causes all frames for which skip returns true to be discarded
+// by readFrame and the want* methods built on it, until the returned func
+// is called. Filters must be removed in the reverse order of their addition.
+//
+// Example:
+//
+//	// Ignore WINDOW_UPDATE frames, which may be sent at any time.
+//	defer tf.skipFrames(frameType(FrameWindowUpdate))()
+func (tf *testConnFramer) skipFrames(skip func(Frame) bool) (restore func()) {
+	tf.skip = append(tf.skip, skip)
+	n := len(tf.skip)
+	return func() {
+		tf.skip = tf.skip[:n-1]
+	}
+}
+
+// frameType returns a skipFrames filter matching frames of type typ.
+func frameType(typ FrameType) func(Frame) bool {
+	return func(fr Frame) bool {
+		return fr.Header().Type == typ
+	}
+}
+
+// wantFrameMatching reads the next frame, and calls each of match with it.
+// It produces an error if any match func returns an error.
+//
+// Example:
+//
+//	tf.wantFrameMatching(
+//		matchFrameType(FrameData),
+//		matchStreamID(1),
+//		func(fr Frame) error {
+//			if got := len(fr.(*DataFrame).Data()); got != 100 {
+//				return fmt.Errorf("got %v bytes of data, want 100", got)
+//			}
+//			return nil
+//		},
+//	)
+func (tf *testConnFramer) wantFrameMatching(match ...func(Frame) error) Frame {
+	tf.t.Helper()
+	fr := tf.readFrame()
+	if fr == nil {
+		tf.t.Fatalf("got no frame, want matching frame")
+	}
+	tf.matchFrame(fr, match)
 	return fr
 }
 
+func (tf *testConnFramer) matchFrame(fr Frame, match []func(Frame) error) {
+	tf.t.Helper()
+	for _, m := range match {
+		if err := m(fr); err != nil {
+			tf.t.Fatalf("frame %v: %v", summarizeFrame(fr), err)
+		}
+	}
+}
+
+// matchFrameType returns a wantFrameMatching func requiring a frame type.
+func matchFrameType(want FrameType) func(Frame) error {
+	return func(fr Frame) error {
+		if got := fr.Header().Type; got != want {
+			return fmt.Errorf("got frame type %v, want %v", got, want)
+		}
+		return nil
+	}
+}
+
+// matchStreamID returns a wantFrameMatching func requiring a stream ID.
+func matchStreamID(want uint32) func(Frame) error {
+	return func(fr Frame) error {
+		if got := fr.Header().StreamID; got != want {
+			return fmt.Errorf("got stream ID %v, want %v", got, want)
+		}
+		return nil
+	}
+}
+
+// matchFlags returns a wantFrameMatching func requiring flags to be set.
+func matchFlags(want Flags) func(Frame) error {
+	return func(fr Frame) error {
+		if got := fr.Header().Flags; !got.Has(want) {
+			return fmt.Errorf("got flags %v, want %v set", got, want)
+		}
+		return nil
+	}
+}
+
 type readFramer interface {
 	readFrame() Frame
 }
@@ -306,7 +402,7 @@ func (tf *testConnFramer) wantIdle() {
 	if err == nil {
 		tf.t.Fatalf("got unexpected frame (want idle connection): %v", fr)
 	}
-	if err != context.DeadlineExceeded {
+	if err != context.DeadlineExceeded && !errors.Is(err, os.ErrDeadlineExceeded) {
 		tf.t.Fatalf("got unexpected frame error (want idle connection): %v", err)
 	}
 }
@@ -412,3 +508,10 @@ func (tf *testConnFramer) writeWindowUpdate(streamID, incr uint32) {
 		tf.t.Fatal(err)
 	}
 }
+
+func (tf *testConnFramer) writeAltSvc(streamID uint32, origin, value string) {
+	tf.t.Helper()
+	if err := tf.fr.WriteAltSvc(streamID, origin, value); err != nil {
+		tf.t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// A ContentLengthPolicy determines how a Transport or Server handles a
+// received message body which is longer than its declared Content-Length.
+type ContentLengthPolicy int
+
+const (
+	// ContentLengthReset treats the message as malformed (RFC 9113,
+	// Section 8.1.1). The stream is reset with PROTOCOL_ERROR, and
+	// reads of the body fail with a *ContentLengthError after
+	// returning the declared number of bytes.
+	ContentLengthReset ContentLengthPolicy = iota
+
+	// ContentLengthTruncate logs the excess data and discards it.
+	// Reads of the body return the declared number of bytes,
+	// followed by io.EOF.
+	//
+	// A Transport resets the stream with CANCEL, since it has no
+	// use for the rest of the response. A Server keeps the stream
+	// open, so that the handler can still send its response.
+	ContentLengthTruncate
+)
+
+// ContentLengthError is returned when reading a message body which is
+// longer than its declared Content-Length, under the ContentLengthReset
+// policy.
+type ContentLengthError struct {
+	// StreamID is the stream the message was received on.
+	StreamID uint32
+
+	// Declared is the declared Content-Length.
+	Declared int64
+}
+
+func (e *ContentLengthError) Error() string {
+	return fmt.Sprintf("http2: stream %v: sender tried to send more than declared Content-Length of %v bytes", e.StreamID, e.Declared)
+}
+
+// truncateBody handles a DATA frame which takes a request body past its
+// declared Content-Length, under the ContentLengthTruncate policy.
+// The body ends at its declared length, and the rest is discarded.
+func (sc *serverConn) truncateBody(st *stream, f *DataFrame) error {
+	sc.serveG.check()
+	if !takeInflows(&sc.inflow, &st.inflow, f.Length) {
+		return sc.countError("flow_on_data_length", streamError(st.id, ErrCodeFlowControl))
+	}
+	n := int(st.declBodyBytes - st.bodyBytes)
+	if n > 0 {
+		st.bodyBytes += int64(n)
+		st.body.Write(f.Data()[:n])
+	}
+	// The handler returns flow control for the bytes it reads.
+	// Return the rest now.
+	discard := int32(f.Length) - int32(n)
+	sc.sendWindowUpdate32(nil, discard)
+	sc.sendWindowUpdate32(st, discard)
+	if !st.truncatedBody {
+		st.truncatedBody = true
+		sc.logf("http2: stream %v from %v: request body longer than declared Content-Length of %v bytes; truncating", st.id, sc.conn.RemoteAddr(), st.declBodyBytes)
+		st.body.CloseWithError(io.EOF)
+	}
+	if f.StreamEnded() {
+		st.endStream()
+	}
+	return nil
+}
+
+// bufferRequestBody reads the body of req, if its length is unknown and
+// it is no longer than Transport.MaxBufferedRequestBodySize, and returns
+// a copy of req whose body is the buffered data, with its ContentLength
+// and GetBody set. Otherwise, it returns a copy of req whose body
+// returns what was read, followed by the rest of the original body,
+// or req itself if nothing was read.
+func (t *Transport) bufferRequestBody(req *http.Request) (*http.Request, error) {
+	max := t.MaxBufferedRequestBodySize
+	if max <= 0 || actualContentLength(req) != -1 || isExtendedConnect(req) {
+		return req, nil
+	}
+	// A request which waits for the server's 100 Continue response
+	// should not read its body before it.
+	if httpguts.HeaderValuesContainsToken(req.Header["Expect"], "100-continue") {
+		return req, nil
+	}
+	// The body may block indefinitely, so read it in its own goroutine
+	// to give up when the request is canceled. Closing the body
+	// unblocks the read.
+	type result struct {
+		b   []byte
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		t.markNewGoroutine()
+		b, err := io.ReadAll(io.LimitReader(req.Body, max+1))
+		resc <- result{b, err}
+	}()
+	var b []byte
+	select {
+	case res := <-resc:
+		if res.err != nil {
+			req.Body.Close()
+			return nil, res.err
+		}
+		b = res.b
+	case <-req.Context().Done():
+		req.Body.Close()
+		return nil, req.Context().Err()
+	}
+	newReq := *req
+	if int64(len(b)) > max {
+		newReq.Body = &prefixedBody{
+			Reader: io.MultiReader(bytes.NewReader(b), req.Body),
+			body:   req.Body,
+		}
+		return &newReq, nil
+	}
+	req.Body.Close()
+	newReq.ContentLength = int64(len(b))
+	if len(b) == 0 {
+		newReq.Body = http.NoBody
+	} else {
+		newReq.Body = io.NopCloser(bytes.NewReader(b))
+	}
+	newReq.GetBody = func() (io.ReadCloser, error) {
+		if len(b) == 0 {
+			return http.NoBody, nil
+		}
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	return &newReq, nil
+}
+
+// prefixedBody is a request body whose start was read by
+// bufferRequestBody.
+type prefixedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *prefixedBody) Close() error { return b.body.Close() }
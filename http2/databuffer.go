@@ -102,6 +102,26 @@ func (b *dataBuffer) Read(p []byte) (int, error) {
 	return ntotal, nil
 }
 
+// next removes the unread bytes of the first chunk from the buffer and
+// returns them along with the chunk holding them. The chunk is no longer
+// referenced by the buffer; the caller must release it with
+// putDataBufferChunk once it is done with p.
+// It is an error to call next when no data is available.
+func (b *dataBuffer) next() (p, chunk []byte, err error) {
+	if b.size == 0 {
+		return nil, nil, errReadEmpty
+	}
+	chunk = b.chunks[0]
+	p = b.bytesFromFirstChunk()
+	b.size -= len(p)
+	end := len(b.chunks) - 1
+	copy(b.chunks[:end], b.chunks[1:])
+	b.chunks[end] = nil
+	b.chunks = b.chunks[:end]
+	b.r = 0
+	return p, chunk, nil
+}
+
 func (b *dataBuffer) bytesFromFirstChunk() []byte {
 	if len(b.chunks) == 1 {
 		return b.chunks[0][b.r:b.w]
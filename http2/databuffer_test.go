@@ -153,3 +153,30 @@ func TestDataBufferWriteAfterPartialRead(t *testing.T) {
 		return b
 	})
 }
+
+func TestDataBufferNext(t *testing.T) {
+	testDataBuffer(t, []byte("defxyz"), func(t *testing.T) *dataBuffer {
+		b := &dataBuffer{}
+		if n, err := b.Write([]byte("abcdef")); n != 6 || err != nil {
+			t.Fatalf("Write(\"abcdef\")=%v,%v want 6,nil", n, err)
+		}
+		p := make([]byte, 1)
+		if n, err := b.Read(p); n != 1 || err != nil {
+			t.Fatalf("Read()=%v,%v want 1,nil", n, err)
+		}
+		p, chunk, err := b.next()
+		if err != nil || string(p) != "bcdef" {
+			t.Fatalf("next()=%q,%v want \"bcdef\",nil", p, err)
+		}
+		// The chunk is no longer owned by the buffer,
+		// so later writes must not overwrite it.
+		if n, err := b.Write([]byte("defxyz")); n != 6 || err != nil {
+			t.Fatalf("Write(\"defxyz\")=%v,%v want 6,nil", n, err)
+		}
+		if string(p) != "bcdef" {
+			t.Fatalf("after Write, next() result = %q, want \"bcdef\"", p)
+		}
+		putDataBufferChunk(chunk)
+		return b
+	})
+}
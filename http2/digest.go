@@ -0,0 +1,73 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"net/textproto"
+	"strings"
+)
+
+// A ContentDigest computes and verifies Content-Digest fields
+// (RFC 9530) sent as trailers.
+//
+// When a Transport or Server has a ContentDigest, it computes a digest
+// of each message body it sends as the body is written, and sends the
+// digest in a Content-Digest trailer. It also computes a digest of each
+// message body it receives, and when the body is followed by a
+// Content-Digest trailer for the same algorithm, checks that the two
+// match. A body which does not match fails with ErrDigestMismatch.
+// Bodies without a Content-Digest trailer are not checked.
+type ContentDigest struct {
+	// Algorithm is the digest algorithm key, such as "sha-256".
+	Algorithm string
+
+	// New returns a new hash computing the digest.
+	New func() hash.Hash
+}
+
+var (
+	// DigestSHA256 is the "sha-256" Content-Digest algorithm.
+	DigestSHA256 = &ContentDigest{Algorithm: "sha-256", New: sha256.New}
+
+	// DigestSHA512 is the "sha-512" Content-Digest algorithm.
+	DigestSHA512 = &ContentDigest{Algorithm: "sha-512", New: sha512.New}
+)
+
+// ErrDigestMismatch is returned when reading a message body whose
+// Content-Digest trailer does not match the body's contents.
+var ErrDigestMismatch = errors.New("http2: Content-Digest does not match message body")
+
+// field returns the Content-Digest field value for the digest h.
+func (d *ContentDigest) field(h hash.Hash) string {
+	return d.Algorithm + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":"
+}
+
+// verify checks the Content-Digest field values vv against the digest h.
+// Members of the field for other algorithms are ignored.
+func (d *ContentDigest) verify(vv []string, h hash.Hash) error {
+	for _, v := range vv {
+		for _, member := range strings.Split(v, ",") {
+			key, val, ok := strings.Cut(member, "=")
+			if !ok || !asciiEqualFold(textproto.TrimString(key), d.Algorithm) {
+				continue
+			}
+			val = textproto.TrimString(val)
+			if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+				return ErrDigestMismatch
+			}
+			want, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+			if err != nil || !bytes.Equal(want, h.Sum(nil)) {
+				return ErrDigestMismatch
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import "testing"
+
+func TestContentDigestVerify(t *testing.T) {
+	d := DigestSHA256
+	h := d.New()
+	h.Write([]byte("hello"))
+	good := d.field(h)
+	if want := "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"; good != want {
+		t.Fatalf("field = %q, want %q", good, want)
+	}
+	for _, test := range []struct {
+		vv      []string
+		wantErr bool
+	}{
+		{[]string{good}, false},
+		{[]string{"sha-512=:AAAA:, " + good}, false},
+		{[]string{"sha-512=:AAAA:", good}, false},
+		{[]string{"SHA-256" + good[len("sha-256"):]}, false},
+		{[]string{"sha-512=:AAAA:"}, false}, // no sha-256 member
+		{nil, false},
+		{[]string{"sha-256=:AAAA:"}, true},
+		{[]string{"sha-256=AAAA"}, true},
+		{[]string{"sha-256=:!!!!:"}, true},
+	} {
+		err := d.verify(test.vv, h)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("verify(%q) = %v, want error: %v", test.vv, err, test.wantErr)
+		}
+	}
+}
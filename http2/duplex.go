@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import "context"
+
+type fullDuplexKey struct{}
+
+// WithFullDuplex returns a new context based on ctx which makes the
+// requests a Transport sends with it full duplex: the request body is
+// sent for as long as it has data, independently of the response.
+//
+// RoundTrip returns as soon as the response headers arrive, while the
+// request body may still be sent, as for any request. For a full-duplex
+// request the Transport also keeps sending the request body when the
+// response has a status code of 300 or more, which otherwise stops it,
+// and after the response is complete, even once its Body is closed.
+// A request body which produces data in reply to the response, as in
+// bidirectional streaming protocols, can therefore read the response
+// without deadlock.
+//
+// The request body stops when it returns an error or io.EOF, when the
+// request's context is done, when the server resets the stream, or
+// when the response Body is closed before the response is complete.
+func WithFullDuplex(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fullDuplexKey{}, true)
+}
+
+func contextFullDuplex(ctx context.Context) bool {
+	v, _ := ctx.Value(fullDuplexKey{}).(bool)
+	return v
+}
+
+// responseDone reports whether the server has ended the response
+// to cs.
+func (cs *clientStream) responseDone() bool {
+	select {
+	case <-cs.peerClosed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Extended CONNECT (RFC 8441) requests are CONNECT requests with a
+// :protocol pseudo-header, such as "websocket", which tunnel that
+// protocol over the request and response bodies. To make one, set the
+// request's ":protocol" header:
+//
+//	req, _ := http.NewRequest("CONNECT", "https://example.com/chat", body)
+//	req.Header.Set(":protocol", "websocket")
+//
+// The Transport sends the request once the server has advertised
+// SETTINGS_ENABLE_CONNECT_PROTOCOL, and fails it with
+// ErrExtendedConnectNotSupported if the server does not.
+
+// ErrExtendedConnectNotSupported is returned by the Transport for an
+// Extended CONNECT request to a server which does not support it.
+var ErrExtendedConnectNotSupported = errors.New("http2: server does not support Extended CONNECT")
+
+var errInvalidProtocol = errors.New("http2: invalid :protocol pseudo-header")
+
+// isExtendedConnect reports whether req is an Extended CONNECT request.
+func isExtendedConnect(req *http.Request) bool {
+	_, ok := req.Header[":protocol"]
+	return ok && req.Method == "CONNECT"
+}
+
+// awaitExtendedConnect waits for the server's SETTINGS, and reports
+// whether the server permits Extended CONNECT requests.
+func (cs *clientStream) awaitExtendedConnect() error {
+	cc := cs.cc
+	select {
+	case <-cc.seenSettingsCh:
+	case <-cc.readerDone:
+		return errClientConnClosed
+	case <-cs.abort:
+		return cs.abortErr
+	case <-cs.ctx.Done():
+		return cs.ctx.Err()
+	case <-cs.reqCancel:
+		return errRequestCanceled
+	}
+	cc.mu.Lock()
+	ok := cc.extendedConnect
+	cc.mu.Unlock()
+	if !ok {
+		return ErrExtendedConnectNotSupported
+	}
+	return nil
+}
+
+// validProtocol reports whether v is a valid :protocol value,
+// which is a token (RFC 8441, Section 4; RFC 9110, Section 7.8).
+func validProtocol(v []string) bool {
+	if len(v) != 1 || v[0] == "" {
+		return false
+	}
+	for _, r := range v[0] {
+		if !httpguts.IsTokenRune(r) {
+			return false
+		}
+	}
+	return true
+}
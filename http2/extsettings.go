@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExtensionSettings is implemented by the http.ResponseWriter passed to
+// Server handlers. It gives handlers access to the SETTINGS parameters
+// of protocol extensions, which use identifiers other than those
+// defined by RFC 9113 (SETTINGS_HEADER_TABLE_SIZE through
+// SETTINGS_MAX_HEADER_LIST_SIZE) and SETTINGS_ENABLE_CONNECT_PROTOCOL.
+// See also Server.ExtraSettings.
+//
+// The settings apply to the whole connection, not just to the request
+// being handled.
+type ExtensionSettings interface {
+	// PeerSetting returns the most recent value the client sent for
+	// the extension setting id, and whether the client has sent it.
+	PeerSetting(id SettingID) (val uint32, ok bool)
+
+	// SendSettings sends a SETTINGS frame with the given extension
+	// settings to the client. It returns an error if any setting is
+	// not an extension setting, or if the connection is closed.
+	// It does not wait for the client to acknowledge the settings.
+	SendSettings(settings ...Setting) error
+}
+
+var _ ExtensionSettings = (*responseWriter)(nil)
+
+var errNotExtensionSetting = errors.New("http2: setting is not an extension setting")
+
+// isExtensionSetting reports whether id is not one of the settings
+// known to this package, which the Server manages itself.
+func isExtensionSetting(id SettingID) bool {
+	if id == SettingEnableConnectProtocol {
+		return false
+	}
+	return id < SettingHeaderTableSize || id > SettingMaxHeaderListSize
+}
+
+// extraSettings returns the valid extension settings of
+// s.ExtraSettings.
+func (s *Server) extraSettings() []Setting {
+	var settings []Setting
+	for _, st := range s.ExtraSettings {
+		if isExtensionSetting(st.ID) {
+			settings = append(settings, st)
+		}
+	}
+	return settings
+}
+
+// notePeerExtensionSetting records an extension setting sent by the client.
+func (sc *serverConn) notePeerExtensionSetting(s Setting) {
+	sc.peerExtMu.Lock()
+	defer sc.peerExtMu.Unlock()
+	if sc.peerExtSettings == nil {
+		sc.peerExtSettings = make(map[SettingID]uint32)
+	}
+	sc.peerExtSettings[s.ID] = s.Val
+}
+
+func (w *responseWriter) PeerSetting(id SettingID) (val uint32, ok bool) {
+	sc := w.rws.conn
+	sc.peerExtMu.Lock()
+	defer sc.peerExtMu.Unlock()
+	val, ok = sc.peerExtSettings[id]
+	return val, ok && isExtensionSetting(id)
+}
+
+func (w *responseWriter) SendSettings(settings ...Setting) error {
+	for _, s := range settings {
+		if !isExtensionSetting(s.ID) {
+			return fmt.Errorf("%w: %v", errNotExtensionSetting, s.ID)
+		}
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	sc := w.rws.conn
+	ws := append(writeSettings(nil), settings...)
+	errc := make(chan error, 1)
+	sc.sendServeMsg(func(sc *serverConn) {
+		if sc.inGoAway {
+			errc <- errClientDisconnected
+			return
+		}
+		sc.writeFrame(FrameWriteRequest{write: ws})
+		sc.unackedSettings++
+		errc <- nil
+	})
+	select {
+	case err := <-errc:
+		return err
+	case <-sc.doneServing:
+		return errClientDisconnected
+	}
+}
@@ -10,12 +10,61 @@ package http2
 // flow control window update.
 const inflowMinRefresh = 4 << 10
 
+// A FlowControlPolicy decides when an endpoint returns inbound flow
+// control credit to its peer in WINDOW_UPDATE frames, and how much.
+// It applies to both connection-level and stream-level windows.
+//
+// Methods are called with connection state locked, and must not block.
+type FlowControlPolicy interface {
+	// WindowUpdate is called when data received from the peer has been
+	// consumed, with the total credit not yet returned to the peer
+	// (unsent) and the window currently available to the peer (avail).
+	// It returns how many of the unsent bytes to return now, between
+	// zero and unsent. Bytes not returned accumulate for a later call.
+	WindowUpdate(unsent, avail int32) int32
+}
+
+// NewThresholdFlowControlPolicy returns a FlowControlPolicy which
+// returns flow control credit once at least minRefresh bytes are
+// unsent, or once returning it will at least double the peer's
+// available window.
+//
+// This is the default policy, with a minRefresh of 4KB. It trades a
+// small amount of latency for fewer WINDOW_UPDATE frames.
+func NewThresholdFlowControlPolicy(minRefresh int32) FlowControlPolicy {
+	return thresholdFlowControlPolicy{minRefresh}
+}
+
+type thresholdFlowControlPolicy struct {
+	minRefresh int32
+}
+
+func (p thresholdFlowControlPolicy) WindowUpdate(unsent, avail int32) int32 {
+	if unsent < p.minRefresh && unsent < avail {
+		// If there aren't at least minRefresh bytes of window to send,
+		// and this update won't at least double the window, buffer the update for later.
+		return 0
+	}
+	return unsent
+}
+
+// NewLowLatencyFlowControlPolicy returns a FlowControlPolicy which
+// returns all flow control credit as soon as data is consumed. It keeps
+// the peer's windows as open as possible, at the cost of sending a
+// WINDOW_UPDATE frame for every read.
+func NewLowLatencyFlowControlPolicy() FlowControlPolicy {
+	return thresholdFlowControlPolicy{0}
+}
+
+var defaultFlowControlPolicy = thresholdFlowControlPolicy{inflowMinRefresh}
+
 // inflow accounts for an inbound flow control window.
 // It tracks both the latest window sent to the peer (used for enforcement)
 // and the accumulated unsent window.
 type inflow struct {
 	avail  int32
 	unsent int32
+	policy FlowControlPolicy // if nil, defaultFlowControlPolicy
 }
 
 // init sets the initial window.
@@ -28,8 +77,9 @@ func (f *inflow) init(n int32) {
 // For example, the user read from a {Request,Response} body and consumed
 // some of the buffered data, so the peer can now send more.
 // It returns the number of bytes to send in a WINDOW_UPDATE frame to the peer.
-// Window updates are accumulated and sent when the unsent capacity
-// is at least inflowMinRefresh or will at least double the peer's available window.
+// Window updates are accumulated and sent when the inflow's FlowControlPolicy
+// allows. By default, that is when the unsent capacity is at least
+// inflowMinRefresh or will at least double the peer's available window.
 func (f *inflow) add(n int) (connAdd int32) {
 	if n < 0 {
 		panic("negative update")
@@ -42,14 +92,21 @@ func (f *inflow) add(n int) (connAdd int32) {
 		panic("flow control update exceeds maximum window size")
 	}
 	f.unsent = int32(unsent)
-	if f.unsent < inflowMinRefresh && f.unsent < f.avail {
-		// If there aren't at least inflowMinRefresh bytes of window to send,
-		// and this update won't at least double the window, buffer the update for later.
+	var send int32
+	if f.policy == nil {
+		send = defaultFlowControlPolicy.WindowUpdate(f.unsent, f.avail)
+	} else {
+		send = f.policy.WindowUpdate(f.unsent, f.avail)
+	}
+	if send <= 0 {
 		return 0
 	}
-	f.avail += f.unsent
-	f.unsent = 0
-	return int32(unsent)
+	if send > f.unsent {
+		send = f.unsent
+	}
+	f.avail += send
+	f.unsent -= send
+	return send
 }
 
 // take attempts to take n bytes from the peer's flow control window.
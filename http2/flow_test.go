@@ -43,6 +43,35 @@ func TestInflowAdd(t *testing.T) {
 	}
 }
 
+func TestInflowAddLowLatencyPolicy(t *testing.T) {
+	var f inflow
+	f.init(10 * inflowMinRefresh)
+	f.policy = NewLowLatencyFlowControlPolicy()
+	if got, want := f.add(1), int32(1); got != want {
+		t.Fatalf("f.add(1) = %v, want %v", got, want)
+	}
+}
+
+type halfFlowControlPolicy struct{}
+
+func (halfFlowControlPolicy) WindowUpdate(unsent, avail int32) int32 { return unsent / 2 }
+
+func TestInflowAddPartialPolicy(t *testing.T) {
+	var f inflow
+	f.init(100)
+	f.policy = halfFlowControlPolicy{}
+	if got, want := f.add(11), int32(5); got != want {
+		t.Fatalf("f.add(11) = %v, want %v", got, want)
+	}
+	// The 6 unsent bytes carry over to the next update.
+	if got, want := f.add(2), int32(4); got != want {
+		t.Fatalf("f.add(2) = %v, want %v", got, want)
+	}
+	if got, want := f.avail, int32(109); got != want {
+		t.Fatalf("f.avail = %v, want %v", got, want)
+	}
+}
+
 func TestTakeInflows(t *testing.T) {
 	var a, b inflow
 	a.init(10)
@@ -0,0 +1,110 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"fmt"
+	"time"
+)
+
+// A FlowControlStall is an interval during which DATA could not be sent
+// on a Transport's connection because a flow control window was exhausted.
+// See Transport.FlowControlStallFunc.
+type FlowControlStall struct {
+	// StreamID is the stream which was blocked.
+	// It is 0 for a local connection-level stall, which blocks every
+	// stream on the connection.
+	StreamID uint32
+
+	// Label is the label of the stream's request, set with
+	// WithRequestLabel, or nil.
+	Label interface{}
+
+	// Conn reports whether the connection-level window, rather than
+	// the stream's own window, was exhausted.
+	Conn bool
+
+	// Cause is the side whose receive window was exhausted.
+	Cause FlowControlStallCause
+
+	Start    time.Time
+	Duration time.Duration
+}
+
+// A FlowControlStallCause identifies which side of a connection caused a
+// FlowControlStall.
+type FlowControlStallCause int
+
+const (
+	// FlowControlStallPeer is a stall sending a request body because
+	// the peer's receive window was exhausted. The peer is consuming
+	// data slowly, or advertises small windows.
+	FlowControlStallPeer FlowControlStallCause = iota
+
+	// FlowControlStallLocal is a stall of the peer sending a response
+	// body because the window the Transport advertised was exhausted.
+	// The response body is being read slowly, or the Transport's
+	// windows or FlowControl policy are too small for the connection.
+	FlowControlStallLocal
+)
+
+func (c FlowControlStallCause) String() string {
+	switch c {
+	case FlowControlStallPeer:
+		return "peer"
+	case FlowControlStallLocal:
+		return "local"
+	}
+	return fmt.Sprintf("FlowControlStallCause(%d)", int(c))
+}
+
+// startLocalStalls notes the start of a local stall for each of the
+// connection and stream windows which data received on cs exhausted.
+// cc.mu must be held.
+func (cs *clientStream) startLocalStalls(endStream bool) {
+	cc := cs.cc
+	if cc.t.FlowControlStallFunc == nil {
+		return
+	}
+	now := cc.t.now()
+	if cc.inflow.avail == 0 && cc.inflowStalled.IsZero() {
+		cc.inflowStalled = now
+	}
+	// The peer has nothing more to send on a stream it has ended.
+	if cs.inflow.avail == 0 && cs.inflowStalled.IsZero() && !endStream {
+		cs.inflowStalled = now
+	}
+}
+
+// endLocalStall ends the local stall which began at *since, if any,
+// when add bytes are returned to its window, and returns it.
+// cc.mu must be held.
+func (cc *ClientConn) endLocalStall(since *time.Time, cs *clientStream, add int32) *FlowControlStall {
+	if add <= 0 || since.IsZero() {
+		return nil
+	}
+	st := &FlowControlStall{
+		Conn:     cs == nil,
+		Cause:    FlowControlStallLocal,
+		Start:    *since,
+		Duration: cc.t.now().Sub(*since),
+	}
+	if cs != nil {
+		st.StreamID = cs.ID
+		st.Label = cs.label
+	}
+	*since = time.Time{}
+	return st
+}
+
+// reportStalls calls Transport.FlowControlStallFunc for each non-nil stall.
+// cc.mu must not be held.
+func (cc *ClientConn) reportStalls(stalls ...*FlowControlStall) {
+	for _, st := range stalls {
+		if st != nil {
+			cc.t.FlowControlStallFunc(*st)
+		}
+	}
+}
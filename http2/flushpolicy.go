@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+)
+
+// A FlushMode determines when data written by a Server handler is
+// sent to the client.
+type FlushMode int
+
+const (
+	// FlushWhenFull buffers written data, sending it when the buffer
+	// fills or the handler calls Flush. This is the default, with a
+	// buffer size of 4KB.
+	FlushWhenFull FlushMode = iota
+
+	// FlushEachWrite sends the data from each Write as soon as it is
+	// made, for latency-sensitive responses such as Server-Sent Events.
+	FlushEachWrite
+
+	// FlushOnClose holds all written data until the handler returns
+	// or calls Flush. If the handler returns without flushing, the
+	// response is sent with a Content-Length. The data is held in
+	// memory, however large it grows.
+	FlushOnClose
+)
+
+var flushModeName = map[FlushMode]string{
+	FlushWhenFull:  "FlushWhenFull",
+	FlushEachWrite: "FlushEachWrite",
+	FlushOnClose:   "FlushOnClose",
+}
+
+func (m FlushMode) String() string {
+	if s, ok := flushModeName[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("FlushMode(%d)", int(m))
+}
+
+// FlushPolicySetter is implemented by the http.ResponseWriter passed
+// to Server handlers.
+//
+// SetFlushPolicy sets when the response's data is sent. Size is the
+// buffer size for FlushWhenFull, where zero means the default; it is
+// ignored for other modes. Any data buffered under the previous policy
+// is flushed first.
+type FlushPolicySetter interface {
+	SetFlushPolicy(mode FlushMode, size int) error
+}
+
+var _ FlushPolicySetter = (*responseWriter)(nil)
+
+var errInvalidFlushPolicy = errors.New("http2: invalid flush policy")
+
+func (w *responseWriter) SetFlushPolicy(mode FlushMode, size int) error {
+	rws := w.rws
+	if rws == nil {
+		panic("SetFlushPolicy called after Handler finished")
+	}
+	if _, ok := flushModeName[mode]; !ok || size < 0 {
+		return errInvalidFlushPolicy
+	}
+	if rws.held.Len() > 0 || rws.bw.Buffered() > 0 {
+		if err := w.FlushError(); err != nil {
+			return err
+		}
+	}
+	rws.flushMode = mode
+	if mode == FlushWhenFull {
+		if size == 0 {
+			size = handlerChunkWriteSize
+		}
+		if size != rws.bw.Size() {
+			rws.bw = bufio.NewWriterSize(chunkWriter{rws}, size)
+		}
+	}
+	return nil
+}
@@ -37,6 +37,15 @@ const (
 	FrameGoAway       FrameType = 0x7
 	FrameWindowUpdate FrameType = 0x8
 	FrameContinuation FrameType = 0x9
+
+	// FrameAltSvc is defined by RFC 7838, Section 4.
+	FrameAltSvc FrameType = 0xa
+
+	// FrameOrigin is defined by RFC 8336, Section 2.
+	FrameOrigin FrameType = 0xc
+
+	// FramePriorityUpdate is defined by RFC 9218, Section 7.1.
+	FramePriorityUpdate FrameType = 0x10
 )
 
 var frameName = map[FrameType]string{
@@ -50,6 +59,10 @@ var frameName = map[FrameType]string{
 	FrameGoAway:       "GOAWAY",
 	FrameWindowUpdate: "WINDOW_UPDATE",
 	FrameContinuation: "CONTINUATION",
+
+	FrameAltSvc:         "ALTSVC",
+	FrameOrigin:         "ORIGIN",
+	FramePriorityUpdate: "PRIORITY_UPDATE",
 }
 
 func (t FrameType) String() string {
@@ -135,6 +148,10 @@ var frameParsers = map[FrameType]frameParser{
 	FrameGoAway:       parseGoAwayFrame,
 	FrameWindowUpdate: parseWindowUpdateFrame,
 	FrameContinuation: parseContinuationFrame,
+
+	FrameAltSvc:         parseAltSvcFrame,
+	FrameOrigin:         parseOriginFrame,
+	FramePriorityUpdate: parsePriorityUpdateFrame,
 }
 
 func typeFrameParser(t FrameType) frameParser {
@@ -310,12 +327,26 @@ type Framer struct {
 	// MetaHeadersFrame instead.
 	ReadMetaHeaders *hpack.Decoder
 
+	// readMetaPushPromises causes ReadFrame to decode the header
+	// blocks of PUSH_PROMISE frames as well, when ReadMetaHeaders
+	// is set, returning metaPushPromiseFrame instead. It is set by
+	// Transports accepting server push.
+	readMetaPushPromises bool
+
 	// MaxHeaderListSize is the http2 MAX_HEADER_LIST_SIZE.
 	// It's used only if ReadMetaHeaders is set; 0 means a sane default
 	// (currently 16MB)
 	// If the limit is hit, MetaHeadersFrame.Truncated is set true.
 	MaxHeaderListSize uint32
 
+	// MaxContinuationFrames is the maximum number of CONTINUATION
+	// frames accepted in a single header block.
+	// It's used only if ReadMetaHeaders is set; 0 means a sane default
+	// (currently 1000).
+	// If the limit is exceeded, ReadFrame returns a ConnectionError
+	// with ErrCodeEnhanceYourCalm.
+	MaxContinuationFrames int
+
 	// TODO: track which type of frame & with which flags was sent
 	// last. Then return an error (unless AllowIllegalWrites) if
 	// we're in the middle of a header block and a
@@ -324,12 +355,26 @@ type Framer struct {
 
 	logReads, logWrites bool
 
+	// wroteFrame, if non-nil, is called with the header of each
+	// frame written.
+	wroteFrame func(FrameHeader)
+
 	debugFramer       *Framer // only use for logging written writes
 	debugFramerBuf    *bytes.Buffer
 	debugReadLoggerf  func(string, ...interface{})
 	debugWriteLoggerf func(string, ...interface{})
 
 	frameCache *frameCache // nil if frames aren't reused (default)
+
+	// metaFrameCache holds the CONTINUATION frame reused for each
+	// fragment of a header block read by readMetaFrame, which are
+	// never returned by ReadFrame, while readingMetaFrame is set.
+	metaFrameCache   frameCache
+	readingMetaFrame bool
+
+	// Totals for header blocks read with ReadMetaHeaders.
+	headerBlockBytesRead int64 // encoded size
+	headerFieldBytesRead int64 // decoded size
 }
 
 func (fr *Framer) maxHeaderListSize() uint32 {
@@ -339,6 +384,13 @@ func (fr *Framer) maxHeaderListSize() uint32 {
 	return fr.MaxHeaderListSize
 }
 
+func (fr *Framer) maxContinuationFrames() int {
+	if fr.MaxContinuationFrames <= 0 {
+		return 1000 // sane default, per docs
+	}
+	return fr.MaxContinuationFrames
+}
+
 func (f *Framer) startWrite(ftype FrameType, flags Flags, streamID uint32) {
 	// Write the FrameHeader.
 	f.wbuf = append(f.wbuf[:0],
@@ -372,6 +424,14 @@ func (f *Framer) endWrite() error {
 	if err == nil && n != len(f.wbuf) {
 		err = io.ErrShortWrite
 	}
+	if err == nil && f.wroteFrame != nil {
+		f.wroteFrame(FrameHeader{
+			Type:     FrameType(f.wbuf[3]),
+			Flags:    Flags(f.wbuf[4]),
+			Length:   uint32(length),
+			StreamID: binary.BigEndian.Uint32(f.wbuf[5:]) & (1<<31 - 1),
+		})
+	}
 	return err
 }
 
@@ -416,7 +476,8 @@ func (fr *Framer) SetReuseFrames() {
 }
 
 type frameCache struct {
-	dataFrame DataFrame
+	dataFrame         DataFrame
+	continuationFrame ContinuationFrame
 }
 
 func (fc *frameCache) getDataFrame() *DataFrame {
@@ -426,6 +487,13 @@ func (fc *frameCache) getDataFrame() *DataFrame {
 	return &fc.dataFrame
 }
 
+func (fc *frameCache) getContinuationFrame() *ContinuationFrame {
+	if fc == nil {
+		return &ContinuationFrame{}
+	}
+	return &fc.continuationFrame
+}
+
 // NewFramer returns a Framer that writes frames to w and reads them from r.
 func NewFramer(w io.Writer, r io.Reader) *Framer {
 	fr := &Framer{
@@ -509,7 +577,11 @@ func (fr *Framer) ReadFrame() (Frame, error) {
 	if _, err := io.ReadFull(fr.r, payload); err != nil {
 		return nil, err
 	}
-	f, err := typeFrameParser(fh.Type)(fr.frameCache, fh, fr.countError, payload)
+	fc := fr.frameCache
+	if fr.readingMetaFrame {
+		fc = &fr.metaFrameCache
+	}
+	f, err := typeFrameParser(fh.Type)(fc, fh, fr.countError, payload)
 	if err != nil {
 		if ce, ok := err.(connError); ok {
 			return nil, fr.connError(ce.Code, ce.Reason)
@@ -522,8 +594,15 @@ func (fr *Framer) ReadFrame() (Frame, error) {
 	if fr.logReads {
 		fr.debugReadLoggerf("http2: Framer %p: read %v", fr, summarizeFrame(f))
 	}
-	if fh.Type == FrameHeaders && fr.ReadMetaHeaders != nil {
-		return fr.readMetaFrame(f.(*HeadersFrame))
+	if fr.ReadMetaHeaders != nil {
+		switch fh.Type {
+		case FrameHeaders:
+			return fr.readMetaFrame(f.(*HeadersFrame))
+		case FramePushPromise:
+			if fr.readMetaPushPromises {
+				return fr.readMetaPushPromise(f.(*PushPromiseFrame))
+			}
+		}
 	}
 	return f, nil
 }
@@ -565,6 +644,11 @@ func (fr *Framer) checkFrameOrder(f Frame) error {
 	}
 
 	switch fh.Type {
+	case FramePushPromise:
+		if !fr.readMetaPushPromises {
+			break
+		}
+		fallthrough
 	case FrameHeaders, FrameContinuation:
 		if fh.Flags.Has(FlagHeadersEndHeaders) {
 			fr.lastHeaderStream = 0
@@ -1204,6 +1288,147 @@ func (f *Framer) WritePriority(streamID uint32, p PriorityParam) error {
 	return f.endWrite()
 }
 
+// A PriorityUpdateFrame is sent by a client to change the priority of
+// a request. It carries an RFC 9218 Priority Field Value, such as "u=1, i".
+// See https://www.rfc-editor.org/rfc/rfc9218.html#section-7.1
+type PriorityUpdateFrame struct {
+	FrameHeader
+	PrioritizedStreamID uint32
+	Priority            string
+}
+
+func parsePriorityUpdateFrame(_ *frameCache, fh FrameHeader, countError func(string), payload []byte) (Frame, error) {
+	if fh.StreamID != 0 {
+		countError("frame_priority_update_non_zero_stream")
+		return nil, connError{ErrCodeProtocol, "PRIORITY_UPDATE frame with non-zero stream ID"}
+	}
+	if len(payload) < 4 {
+		countError("frame_priority_update_bad_length")
+		return nil, connError{ErrCodeFrameSize, fmt.Sprintf("PRIORITY_UPDATE frame payload size was %d; want at least 4", len(payload))}
+	}
+	id := binary.BigEndian.Uint32(payload[:4]) & 0x7fffffff
+	if id == 0 {
+		countError("frame_priority_update_zero_prioritized_stream")
+		return nil, connError{ErrCodeProtocol, "PRIORITY_UPDATE frame for stream 0"}
+	}
+	return &PriorityUpdateFrame{
+		FrameHeader:         fh,
+		PrioritizedStreamID: id,
+		Priority:            string(payload[4:]),
+	}, nil
+}
+
+// WritePriorityUpdate writes a PRIORITY_UPDATE frame changing the
+// priority of the stream prioritizedStreamID.
+//
+// It will perform exactly one Write to the underlying Writer.
+// It is the caller's responsibility to not call other Write methods concurrently.
+func (f *Framer) WritePriorityUpdate(prioritizedStreamID uint32, priority string) error {
+	if !validStreamID(prioritizedStreamID) && !f.AllowIllegalWrites {
+		return errStreamID
+	}
+	f.startWrite(FramePriorityUpdate, 0, 0)
+	f.writeUint32(prioritizedStreamID)
+	f.writeBytes([]byte(priority))
+	return f.endWrite()
+}
+
+// An OriginFrame is sent by a server to list the origins it is
+// authoritative for, which a client may send requests for on the
+// connection. Each origin is an ASCII serialization, such as
+// "https://example.com".
+// See https://www.rfc-editor.org/rfc/rfc8336.html#section-2
+type OriginFrame struct {
+	FrameHeader
+	Origins []string
+}
+
+func parseOriginFrame(_ *frameCache, fh FrameHeader, countError func(string), p []byte) (Frame, error) {
+	// RFC 8336, Section 2.1: ORIGIN frames on streams other than
+	// stream 0 are ignored, as are frame types an endpoint does not
+	// understand.
+	if fh.StreamID != 0 {
+		countError("frame_origin_non_zero_stream")
+		return &UnknownFrame{fh, p}, nil
+	}
+	var origins []string
+	for len(p) > 0 {
+		if len(p) < 2 {
+			countError("frame_origin_bad_len")
+			return nil, connError{ErrCodeFrameSize, "ORIGIN frame with truncated Origin-Len"}
+		}
+		n := int(binary.BigEndian.Uint16(p))
+		p = p[2:]
+		if len(p) < n {
+			countError("frame_origin_bad_len")
+			return nil, connError{ErrCodeFrameSize, "ORIGIN frame entry longer than frame"}
+		}
+		origins = append(origins, string(p[:n]))
+		p = p[n:]
+	}
+	return &OriginFrame{FrameHeader: fh, Origins: origins}, nil
+}
+
+// WriteOrigin writes an ORIGIN frame listing origins.
+//
+// It will perform exactly one Write to the underlying Writer.
+// It is the caller's responsibility to not call other Write methods concurrently.
+func (f *Framer) WriteOrigin(origins ...string) error {
+	for _, o := range origins {
+		if len(o) > 0xffff && !f.AllowIllegalWrites {
+			return errors.New("http2: ORIGIN frame entry too long")
+		}
+	}
+	f.startWrite(FrameOrigin, 0, 0)
+	for _, o := range origins {
+		f.writeUint16(uint16(len(o)))
+		f.writeBytes([]byte(o))
+	}
+	return f.endWrite()
+}
+
+// An AltSvcFrame advertises alternative services for an origin.
+// On stream 0 the frame's Origin names the origin; on other streams
+// Origin is empty and the origin is that of the stream's request.
+// Value has the syntax of the Alt-Svc header field; see ParseAltSvc.
+// See https://www.rfc-editor.org/rfc/rfc7838.html#section-4
+type AltSvcFrame struct {
+	FrameHeader
+	Origin string
+	Value  string
+}
+
+func parseAltSvcFrame(_ *frameCache, fh FrameHeader, countError func(string), p []byte) (Frame, error) {
+	if len(p) < 2 {
+		countError("frame_altsvc_bad_len")
+		return nil, connError{ErrCodeFrameSize, "ALTSVC frame too short"}
+	}
+	n := int(binary.BigEndian.Uint16(p))
+	p = p[2:]
+	if len(p) < n {
+		countError("frame_altsvc_bad_len")
+		return nil, connError{ErrCodeFrameSize, "ALTSVC frame Origin longer than frame"}
+	}
+	return &AltSvcFrame{FrameHeader: fh, Origin: string(p[:n]), Value: string(p[n:])}, nil
+}
+
+// WriteAltSvc writes an ALTSVC frame advertising the alternative
+// services in value for origin. The origin must be empty if streamID
+// is nonzero.
+//
+// It will perform exactly one Write to the underlying Writer.
+// It is the caller's responsibility to not call other Write methods concurrently.
+func (f *Framer) WriteAltSvc(streamID uint32, origin, value string) error {
+	if !f.AllowIllegalWrites && (len(origin) > 0xffff || (streamID != 0 && origin != "")) {
+		return errors.New("http2: invalid ALTSVC frame origin")
+	}
+	f.startWrite(FrameAltSvc, 0, streamID)
+	f.writeUint16(uint16(len(origin)))
+	f.writeBytes([]byte(origin))
+	f.writeBytes([]byte(value))
+	return f.endWrite()
+}
+
 // A RSTStreamFrame allows for abnormal termination of a stream.
 // See https://httpwg.org/specs/rfc7540.html#rfc.section.6.4
 type RSTStreamFrame struct {
@@ -1243,12 +1468,14 @@ type ContinuationFrame struct {
 	headerFragBuf []byte
 }
 
-func parseContinuationFrame(_ *frameCache, fh FrameHeader, countError func(string), p []byte) (Frame, error) {
+func parseContinuationFrame(fc *frameCache, fh FrameHeader, countError func(string), p []byte) (Frame, error) {
 	if fh.StreamID == 0 {
 		countError("frame_continuation_zero_stream")
 		return nil, connError{ErrCodeProtocol, "CONTINUATION frame with stream ID 0"}
 	}
-	return &ContinuationFrame{fh, p}, nil
+	f := fc.getContinuationFrame()
+	*f = ContinuationFrame{fh, p}
+	return f, nil
 }
 
 func (f *ContinuationFrame) HeaderBlockFragment() []byte {
@@ -1447,6 +1674,17 @@ type MetaHeadersFrame struct {
 	// and Fields is incomplete. The hpack decoder state is still
 	// valid, however.
 	Truncated bool
+
+	// DecodedSize is the total size of the header fields decoded
+	// from the header block, as defined in RFC 7541, Section 4.1.
+	// If Truncated is set, it includes the size of the field which
+	// exceeded the limit. Fields after that one are not decoded,
+	// and are not counted.
+	DecodedSize uint64
+
+	// Frames is the number of HEADERS and CONTINUATION frames
+	// the header block was received in.
+	Frames int
 }
 
 // PseudoValue returns the given pseudo header field's value.
@@ -1564,6 +1802,7 @@ func (fr *Framer) readMetaFrame(hf *HeadersFrame) (Frame, error) {
 		}
 
 		size := hf.Size()
+		mh.DecodedSize += uint64(size)
 		if size > remainSize {
 			hdec.SetEmitEnabled(false)
 			mh.Truncated = true
@@ -1577,9 +1816,17 @@ func (fr *Framer) readMetaFrame(hf *HeadersFrame) (Frame, error) {
 	// Lose reference to MetaHeadersFrame:
 	defer hdec.SetEmitFunc(func(hf hpack.HeaderField) {})
 
+	// The header block is decoded one fragment at a time as frames
+	// arrive, so memory use is bounded by the header list size rather
+	// than the encoded size of the block. Bound the number of frames
+	// as well, since a peer can send an unlimited number of
+	// CONTINUATION frames with little or no header data in each.
+	remainFrames := fr.maxContinuationFrames()
 	var hc headersOrContinuation = hf
 	for {
+		mh.Frames++
 		frag := hc.HeaderBlockFragment()
+		fr.headerBlockBytesRead += int64(len(frag))
 
 		// Avoid parsing large amounts of headers that we will then discard.
 		// If the sender exceeds the max header list size by too much,
@@ -1617,15 +1864,27 @@ func (fr *Framer) readMetaFrame(hf *HeadersFrame) (Frame, error) {
 		if hc.HeadersEnded() {
 			break
 		}
-		if f, err := fr.ReadFrame(); err != nil {
+		if remainFrames == 0 {
+			if VerboseLogs {
+				log.Printf("http2: too many CONTINUATION frames")
+			}
+			fr.countError("frame_continuation_too_many")
+			return mh, ConnectionError(ErrCodeEnhanceYourCalm)
+		}
+		remainFrames--
+		// The fragment has been decoded, so its frame can be reused.
+		fr.readingMetaFrame = true
+		f, err := fr.ReadFrame()
+		fr.readingMetaFrame = false
+		if err != nil {
 			return nil, err
-		} else {
-			hc = f.(*ContinuationFrame) // guaranteed by checkFrameOrder
 		}
+		hc = f.(*ContinuationFrame) // guaranteed by checkFrameOrder
 	}
 
 	mh.HeadersFrame.headerFragBuf = nil
 	mh.HeadersFrame.invalidate()
+	fr.headerFieldBytesRead += int64(mh.DecodedSize)
 
 	if err := hdec.Close(); err != nil {
 		return mh, ConnectionError(ErrCodeCompression)
@@ -1647,6 +1906,39 @@ func (fr *Framer) readMetaFrame(hf *HeadersFrame) (Frame, error) {
 	return mh, nil
 }
 
+// A metaPushPromiseFrame is a PUSH_PROMISE frame and zero or more
+// contiguous CONTINUATION frames, with the promised request's header
+// fields decoded. See Framer.readMetaPushPromises.
+type metaPushPromiseFrame struct {
+	*PushPromiseFrame
+	*MetaHeadersFrame // the header block; its HeadersFrame is not valid
+
+	// invalid is set if the promised request's header fields are
+	// malformed, in which case MetaHeadersFrame is nil. The promised
+	// stream must be reset with a PROTOCOL_ERROR.
+	invalid error
+}
+
+// readMetaPushPromise is readMetaFrame for PUSH_PROMISE frames.
+func (fr *Framer) readMetaPushPromise(pp *PushPromiseFrame) (Frame, error) {
+	// PUSH_PROMISE shares END_HEADERS with HEADERS, so its header
+	// block is read as if it were one.
+	hf := &HeadersFrame{
+		FrameHeader:   pp.FrameHeader,
+		headerFragBuf: pp.headerFragBuf,
+	}
+	f, err := fr.readMetaFrame(hf)
+	pp.headerFragBuf = nil
+	pp.invalidate()
+	if se, ok := err.(StreamError); ok {
+		return &metaPushPromiseFrame{PushPromiseFrame: pp, invalid: se.Cause}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &metaPushPromiseFrame{PushPromiseFrame: pp, MetaHeadersFrame: f.(*MetaHeadersFrame)}, nil
+}
+
 func summarizeFrame(f Frame) string {
 	var buf bytes.Buffer
 	f.Header().writeDebug(&buf)
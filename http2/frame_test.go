@@ -493,6 +493,153 @@ func TestWritePriority(t *testing.T) {
 	}
 }
 
+func TestWritePriorityUpdate(t *testing.T) {
+	fr, buf := testFramer()
+	if err := fr.WritePriorityUpdate(5, "u=1, i"); err != nil {
+		t.Fatal(err)
+	}
+	const wantEnc = "\x00\x00\x0a\x10\x00\x00\x00\x00\x00\x00\x00\x00\x05u=1, i"
+	if buf.String() != wantEnc {
+		t.Errorf("encoded as %q; want %q", buf.Bytes(), wantEnc)
+	}
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PriorityUpdateFrame{
+		FrameHeader: FrameHeader{
+			valid:  true,
+			Type:   FramePriorityUpdate,
+			Length: 10,
+		},
+		PrioritizedStreamID: 5,
+		Priority:            "u=1, i",
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("mismatch.\n got: %#v\nwant: %#v", f, want)
+	}
+}
+
+func TestReadPriorityUpdateErrors(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		streamID uint32
+		payload  []byte
+	}{
+		{"non-zero stream", 1, []byte{0, 0, 0, 1}},
+		{"short payload", 0, []byte{0, 0, 1}},
+		{"zero prioritized stream", 0, []byte{0, 0, 0, 0}},
+	} {
+		fr, _ := testFramer()
+		fr.WriteRawFrame(FramePriorityUpdate, 0, test.streamID, test.payload)
+		if _, err := fr.ReadFrame(); err == nil {
+			t.Errorf("%v: ReadFrame succeeded, want error", test.name)
+		}
+	}
+}
+
+func TestWriteOrigin(t *testing.T) {
+	fr, buf := testFramer()
+	if err := fr.WriteOrigin("https://a.example", "https://b.example:8443"); err != nil {
+		t.Fatal(err)
+	}
+	const wantEnc = "\x00\x00\x2b\x0c\x00\x00\x00\x00\x00" +
+		"\x00\x11https://a.example" +
+		"\x00\x16https://b.example:8443"
+	if buf.String() != wantEnc {
+		t.Errorf("encoded as %q; want %q", buf.Bytes(), wantEnc)
+	}
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &OriginFrame{
+		FrameHeader: FrameHeader{
+			valid:  true,
+			Type:   FrameOrigin,
+			Length: 43,
+		},
+		Origins: []string{"https://a.example", "https://b.example:8443"},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("mismatch.\n got: %#v\nwant: %#v", f, want)
+	}
+}
+
+func TestReadOriginFrame(t *testing.T) {
+	// RFC 8336, Section 2.1: ORIGIN frames on other streams are ignored.
+	fr, _ := testFramer()
+	fr.WriteRawFrame(FrameOrigin, 0, 1, []byte("\x00\x01a"))
+	if f, err := fr.ReadFrame(); err != nil {
+		t.Errorf("ORIGIN frame on stream 1: %v, want no error", err)
+	} else if _, ok := f.(*UnknownFrame); !ok {
+		t.Errorf("ORIGIN frame on stream 1 read as %T, want *UnknownFrame", f)
+	}
+
+	for _, test := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"truncated length", []byte{0}},
+		{"truncated origin", []byte("\x00\x05abc")},
+	} {
+		fr, _ := testFramer()
+		fr.WriteRawFrame(FrameOrigin, 0, 0, test.payload)
+		if _, err := fr.ReadFrame(); err == nil {
+			t.Errorf("%v: ReadFrame succeeded, want error", test.name)
+		}
+	}
+}
+
+func TestWriteAltSvc(t *testing.T) {
+	fr, buf := testFramer()
+	if err := fr.WriteAltSvc(0, "https://a.example", `h2=":8443"`); err != nil {
+		t.Fatal(err)
+	}
+	const wantEnc = "\x00\x00\x1d\x0a\x00\x00\x00\x00\x00" +
+		"\x00\x11https://a.example" +
+		`h2=":8443"`
+	if buf.String() != wantEnc {
+		t.Errorf("encoded as %q; want %q", buf.Bytes(), wantEnc)
+	}
+	f, err := fr.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &AltSvcFrame{
+		FrameHeader: FrameHeader{
+			valid:  true,
+			Type:   FrameAltSvc,
+			Length: 29,
+		},
+		Origin: "https://a.example",
+		Value:  `h2=":8443"`,
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("mismatch.\n got: %#v\nwant: %#v", f, want)
+	}
+
+	if err := fr.WriteAltSvc(1, "https://a.example", "clear"); err == nil {
+		t.Errorf("WriteAltSvc with origin on stream 1 succeeded, want error")
+	}
+}
+
+func TestReadAltSvcFrame(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"truncated length", []byte{0}},
+		{"truncated origin", []byte("\x00\x05abc")},
+	} {
+		fr, _ := testFramer()
+		fr.WriteRawFrame(FrameAltSvc, 0, 0, test.payload)
+		if _, err := fr.ReadFrame(); err == nil {
+			t.Errorf("%v: ReadFrame succeeded, want error", test.name)
+		}
+	}
+}
+
 func TestWriteSettings(t *testing.T) {
 	fr, buf := testFramer()
 	settings := []Setting{{1, 2}, {3, 4}}
@@ -908,18 +1055,28 @@ func TestMetaFrameHeader(t *testing.T) {
 				},
 			},
 			Fields: []hpack.HeaderField(nil),
+			Frames: 1,
 		}
 		for len(pairs) > 0 {
-			mh.Fields = append(mh.Fields, hpack.HeaderField{
+			hf := hpack.HeaderField{
 				Name:  pairs[0],
 				Value: pairs[1],
-			})
+			}
+			mh.Fields = append(mh.Fields, hf)
+			mh.DecodedSize += uint64(hf.Size())
 			pairs = pairs[2:]
 		}
 		return mh
 	}
-	truncated := func(mh *MetaHeadersFrame) *MetaHeadersFrame {
+	// truncated marks mh as truncated at a field with the given name and value.
+	truncated := func(mh *MetaHeadersFrame, name, value string) *MetaHeadersFrame {
 		mh.Truncated = true
+		mh.DecodedSize += uint64(hpack.HeaderField{Name: name, Value: value}.Size())
+		return mh
+	}
+	// frames sets the number of frames mh was received in.
+	frames := func(n int, mh *MetaHeadersFrame) *MetaHeadersFrame {
+		mh.Frames = n
 		return mh
 	}
 
@@ -950,7 +1107,7 @@ func TestMetaFrameHeader(t *testing.T) {
 				all := he.encodeHeaderRaw(t, ":method", "GET", ":path", "/", "foo", "bar")
 				write(f, all[:1], all[1:])
 			},
-			want: want(noFlags, 1, ":method", "GET", ":path", "/", "foo", "bar"),
+			want: frames(2, want(noFlags, 1, ":method", "GET", ":path", "/", "foo", "bar")),
 		},
 		2: {
 			name: "with_two_continuation",
@@ -959,7 +1116,7 @@ func TestMetaFrameHeader(t *testing.T) {
 				all := he.encodeHeaderRaw(t, ":method", "GET", ":path", "/", "foo", "bar")
 				write(f, all[:2], all[2:4], all[4:])
 			},
-			want: want(noFlags, 2, ":method", "GET", ":path", "/", "foo", "bar"),
+			want: frames(3, want(noFlags, 2, ":method", "GET", ":path", "/", "foo", "bar")),
 		},
 		3: {
 			name: "big_string_okay",
@@ -968,7 +1125,7 @@ func TestMetaFrameHeader(t *testing.T) {
 				all := he.encodeHeaderRaw(t, ":method", "GET", ":path", "/", "foo", oneKBString)
 				write(f, all[:2], all[2:])
 			},
-			want: want(noFlags, 2, ":method", "GET", ":path", "/", "foo", oneKBString),
+			want: frames(2, want(noFlags, 2, ":method", "GET", ":path", "/", "foo", oneKBString)),
 		},
 		4: {
 			name: "big_string_error",
@@ -992,7 +1149,7 @@ func TestMetaFrameHeader(t *testing.T) {
 				write(f, all[:2], all[2:])
 			},
 			maxHeaderListSize: (1 << 10) / 2,
-			want: truncated(want(noFlags, 2,
+			want: frames(2, truncated(want(noFlags, 2,
 				":method", "GET",
 				":path", "/",
 				"foo", "bar",
@@ -1006,7 +1163,7 @@ func TestMetaFrameHeader(t *testing.T) {
 				"foo", "bar",
 				"foo", "bar",
 				"foo", "bar", // 11
-			)),
+			), "foo", "bar")),
 		},
 		6: {
 			name: "pseudo_order",
@@ -1121,6 +1278,41 @@ func TestMetaFrameHeader(t *testing.T) {
 	}
 }
 
+func TestReadMetaPushPromise(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := NewFramer(buf, buf)
+	f.ReadMetaHeaders = hpack.NewDecoder(initialHeaderTableSize, nil)
+	f.readMetaPushPromises = true
+
+	block := encodeHeaderRaw(t, ":method", "GET", ":path", "/pushed", "accept", "text/css")
+	f.WritePushPromise(PushPromiseParam{
+		StreamID:      1,
+		PromiseID:     2,
+		BlockFragment: block[:5],
+	})
+	f.WriteContinuation(1, true, block[5:])
+
+	fr, err := f.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp, ok := fr.(*metaPushPromiseFrame)
+	if !ok {
+		t.Fatalf("got %T; want *metaPushPromiseFrame", fr)
+	}
+	if pp.StreamID != 1 || pp.PromiseID != 2 || pp.Frames != 2 {
+		t.Errorf("got stream %v, promise %v, frames %v; want 1, 2, 2", pp.StreamID, pp.PromiseID, pp.Frames)
+	}
+	want := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/pushed"},
+		{Name: "accept", Value: "text/css"},
+	}
+	if !reflect.DeepEqual(pp.Fields, want) {
+		t.Errorf("fields = %v; want %v", pp.Fields, want)
+	}
+}
+
 func TestSetReuseFrames(t *testing.T) {
 	fr, buf := testFramer()
 	fr.SetReuseFrames()
@@ -1258,3 +1450,44 @@ func TestSettingsDuplicates(t *testing.T) {
 	}
 
 }
+
+// BenchmarkReadMetaFrameLargeHeaderBlock measures reading a header
+// block with a large field split across many CONTINUATION frames.
+func BenchmarkReadMetaFrameLargeHeaderBlock(b *testing.B) {
+	var hbuf bytes.Buffer
+	enc := hpack.NewEncoder(&hbuf)
+	enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+	enc.WriteField(hpack.HeaderField{Name: "x-large", Value: strings.Repeat("v", 64<<10)})
+	block := hbuf.Bytes()
+
+	var wbuf bytes.Buffer
+	fr := NewFramer(&wbuf, nil)
+	const fragSize = 256
+	fr.WriteHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: block[:fragSize],
+	})
+	for rest := block[fragSize:]; len(rest) > 0; {
+		n := fragSize
+		if n > len(rest) {
+			n = len(rest)
+		}
+		fr.WriteContinuation(1, n == len(rest), rest[:n])
+		rest = rest[n:]
+	}
+	frames := wbuf.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frames)))
+	r := bytes.NewReader(frames)
+	fr = NewFramer(io.Discard, r)
+	fr.ReadMetaHeaders = hpack.NewDecoder(initialHeaderTableSize, nil)
+	fr.MaxHeaderListSize = 1 << 20
+	fr.MaxContinuationFrames = len(frames)
+	for i := 0; i < b.N; i++ {
+		r.Reset(frames)
+		if _, err := fr.ReadFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
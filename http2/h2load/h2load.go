@@ -0,0 +1,324 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package h2load generates HTTP/2 load against a server, in the manner
+// of the nghttp2 h2load tool.
+//
+// It opens a fixed number of connections and runs a fixed number of
+// concurrent streams on each, reporting request latencies and the
+// HTTP/2 errors encountered. It is intended for capacity testing
+// deployments of golang.org/x/net/http2, not for benchmarking
+// arbitrary servers with precise timing.
+//
+// Run is the client side of a test. Handler is its server side: a
+// synthetic handler to install on the Server under test, so that the
+// test measures the server's HTTP/2 implementation rather than the
+// deployment's application handlers.
+package h2load
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Config configures a load test.
+type Config struct {
+	// URL is the target of the requests.
+	// It is used when NewRequest is nil, and to choose
+	// the address to dial when Dial is nil.
+	URL string
+
+	// NewRequest, if non-nil, returns a new request to send.
+	// It is called once per request, possibly concurrently.
+	// If nil, each request is a GET of URL.
+	NewRequest func(ctx context.Context) (*http.Request, error)
+
+	// Transport is used to create client connections.
+	// If nil, a zero http2.Transport is used.
+	Transport *http2.Transport
+
+	// Dial, if non-nil, dials a new connection to the server.
+	// If nil, a TLS connection is dialed to URL's host using the
+	// Transport's TLSClientConfig, negotiating "h2" with ALPN.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Connections is the number of connections to open.
+	// If zero, one connection is used.
+	Connections int
+
+	// Streams is the number of concurrent streams to run on
+	// each connection. If zero, one stream is used.
+	Streams int
+
+	// Requests is the total number of requests to send, across
+	// all connections. If zero, requests are sent until Duration
+	// elapses or the context passed to Run is done.
+	Requests int
+
+	// Duration limits how long requests are sent for.
+	// If zero, there is no limit.
+	Duration time.Duration
+}
+
+// Result reports the outcome of a load test.
+type Result struct {
+	// Duration is how long the test ran for.
+	Duration time.Duration
+
+	// Requests is the number of requests which received a complete response.
+	Requests int
+
+	// Failed is the number of requests which failed.
+	Failed int
+
+	// StatusCodes counts complete responses by status code.
+	StatusCodes map[int]int
+
+	// Errors counts failed requests by kind of error, such as
+	// "RST_STREAM REFUSED_STREAM", "GOAWAY ENHANCE_YOUR_CALM", or
+	// "connection PROTOCOL_ERROR". Errors not caused by an HTTP/2
+	// error code are counted under "other".
+	Errors map[string]int
+
+	// latencies of complete responses, sorted.
+	latencies []time.Duration
+}
+
+// Latency returns the p-th percentile of the latencies of complete
+// responses, measured from sending the request until the end of the
+// response body, for p between 0 and 100.
+// It uses the nearest-rank method: the result is the smallest latency
+// which is at least p percent of all latencies.
+// It returns zero if no requests completed.
+func (r *Result) Latency(p float64) time.Duration {
+	n := len(r.latencies)
+	if n == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(n))) - 1
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return r.latencies[i]
+}
+
+// RequestsPerSecond returns the rate of complete responses.
+func (r *Result) RequestsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// Run runs a load test, and returns its result once every request
+// has finished. It returns an error if the configuration is invalid
+// or no connection could be established.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.NewRequest == nil && cfg.URL == "" {
+		return nil, errors.New("h2load: Config has neither URL nor NewRequest")
+	}
+	tr := cfg.Transport
+	if tr == nil {
+		tr = &http2.Transport{}
+	}
+	dial := cfg.Dial
+	if dial == nil {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		dial = tlsDialer(tr, u)
+	}
+	newRequest := cfg.NewRequest
+	if newRequest == nil {
+		newRequest = func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", cfg.URL, nil)
+		}
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	r := &runner{
+		ctx:        ctx,
+		newRequest: newRequest,
+		remaining:  cfg.Requests,
+		res: &Result{
+			StatusCodes: make(map[int]int),
+			Errors:      make(map[string]int),
+		},
+	}
+	start := time.Now()
+	var ccs []*http2.ClientConn
+	var dialErr error
+	for i := 0; i < atLeastOne(cfg.Connections); i++ {
+		c, err := dial(ctx)
+		if err == nil {
+			var cc *http2.ClientConn
+			if cc, err = tr.NewClientConn(c); err == nil {
+				ccs = append(ccs, cc)
+				continue
+			}
+			c.Close()
+		}
+		if dialErr == nil {
+			dialErr = err
+		}
+	}
+	if len(ccs) == 0 {
+		return nil, dialErr
+	}
+	var wg sync.WaitGroup
+	for _, cc := range ccs {
+		for i := 0; i < atLeastOne(cfg.Streams); i++ {
+			wg.Add(1)
+			go func(cc *http2.ClientConn) {
+				defer wg.Done()
+				r.stream(cc)
+			}(cc)
+		}
+	}
+	wg.Wait()
+	r.res.Duration = time.Since(start)
+	for _, cc := range ccs {
+		cc.Close()
+	}
+	sort.Slice(r.res.latencies, func(i, j int) bool {
+		return r.res.latencies[i] < r.res.latencies[j]
+	})
+	return r.res, nil
+}
+
+// atLeastOne returns n, or 1 if n is zero or negative.
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func tlsDialer(tr *http2.Transport, u *url.URL) func(ctx context.Context) (net.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	cfg := new(tls.Config)
+	if tr.TLSClientConfig != nil {
+		cfg = tr.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	cfg.NextProtos = []string{http2.NextProtoTLS}
+	return func(ctx context.Context) (net.Conn, error) {
+		d := &tls.Dialer{Config: cfg}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+type runner struct {
+	ctx        context.Context
+	newRequest func(ctx context.Context) (*http.Request, error)
+
+	mu        sync.Mutex
+	remaining int // requests left to start, if limited
+	res       *Result
+}
+
+// next reports whether another request should be started.
+func (r *runner) next() bool {
+	if r.ctx.Err() != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.remaining == 0:
+		return true // unlimited
+	case r.remaining > 1:
+		r.remaining--
+		return true
+	case r.remaining == 1:
+		r.remaining = -1 // exhausted
+		return true
+	}
+	return false
+}
+
+// stream sends requests on cc one at a time until the test is done
+// or cc can no longer take requests.
+func (r *runner) stream(cc *http2.ClientConn) {
+	for r.next() {
+		start := time.Now()
+		status, err := r.roundTrip(cc)
+		latency := time.Since(start)
+		if err != nil && r.ctx.Err() != nil {
+			// The test ended while this request was in flight.
+			return
+		}
+		r.mu.Lock()
+		if err != nil {
+			r.res.Failed++
+			r.res.Errors[errorKind(err)]++
+		} else {
+			r.res.Requests++
+			r.res.StatusCodes[status]++
+			r.res.latencies = append(r.res.latencies, latency)
+		}
+		r.mu.Unlock()
+		if err != nil && !cc.CanTakeNewRequest() {
+			return
+		}
+	}
+}
+
+func (r *runner) roundTrip(cc *http2.ClientConn) (status int, err error) {
+	req, err := r.newRequest(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	res, err := cc.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(io.Discard, res.Body); err != nil {
+		return 0, err
+	}
+	return res.StatusCode, nil
+}
+
+// errorKind classifies err by the HTTP/2 error code which caused it.
+func errorKind(err error) string {
+	var se http2.StreamError
+	if errors.As(err, &se) {
+		return fmt.Sprintf("RST_STREAM %v", se.Code)
+	}
+	var ge http2.GoAwayError
+	if errors.As(err, &ge) {
+		return fmt.Sprintf("GOAWAY %v", ge.ErrCode)
+	}
+	var ce http2.ConnectionError
+	if errors.As(err, &ce) {
+		return fmt.Sprintf("connection %v", http2.ErrCode(ce))
+	}
+	return "other"
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package h2load
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestRun(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = map[string]bool{}
+	)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("request protocol = %v; want HTTP/2", r.Proto)
+		}
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+		w.Write([]byte("hello"))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	res, err := Run(context.Background(), Config{
+		URL: ts.URL,
+		Transport: &http2.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Connections: 2,
+		Streams:     3,
+		Requests:    20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests != 20 || res.Failed != 0 {
+		t.Errorf("Requests = %v, Failed = %v; want 20, 0 (errors: %v)", res.Requests, res.Failed, res.Errors)
+	}
+	if got := res.StatusCodes[200]; got != 20 {
+		t.Errorf("StatusCodes[200] = %v; want 20", got)
+	}
+	if len(conns) != 2 {
+		t.Errorf("server saw %v connections; want 2", len(conns))
+	}
+	if p50, p100 := res.Latency(50), res.Latency(100); p50 <= 0 || p50 > p100 {
+		t.Errorf("Latency(50) = %v, Latency(100) = %v; want 0 < p50 <= p100", p50, p100)
+	}
+}
+
+func TestRunDuration(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	res, err := Run(context.Background(), Config{
+		URL: ts.URL,
+		Transport: &http2.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Duration: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests == 0 || res.StatusCodes[http.StatusTeapot] != res.Requests {
+		t.Errorf("Requests = %v, StatusCodes = %v; want some 418 responses", res.Requests, res.StatusCodes)
+	}
+}
+
+func TestResultLatency(t *testing.T) {
+	r := &Result{}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Millisecond)
+	}
+	for _, test := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{1, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{99.5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		if got := r.Latency(test.p); got != test.want {
+			t.Errorf("Latency(%v) = %v; want %v", test.p, got, test.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ts := httptest.NewUnstartedServer(&Handler{Size: 1000})
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	res, err := Run(context.Background(), Config{
+		URL: ts.URL + "?size=70000&delay=1ms",
+		Transport: &http2.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Streams:  2,
+		Requests: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCodes[200] != 4 {
+		t.Errorf("StatusCodes = %v, Errors = %v; want 4 200 responses", res.StatusCodes, res.Errors)
+	}
+	if p0 := res.Latency(0); p0 < time.Millisecond {
+		t.Errorf("Latency(0) = %v; want at least the 1ms delay", p0)
+	}
+
+	c := ts.Client()
+	for _, test := range []struct {
+		query      string
+		wantStatus int
+		wantSize   int64
+	}{
+		{"", 200, 1000},
+		{"?size=0", 200, 0},
+		{"?size=70000", 200, 70000},
+		{"?size=-1", 400, -1},
+		{"?delay=x", 400, -1},
+	} {
+		res, err := c.Get(ts.URL + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != test.wantStatus {
+			t.Errorf("GET %q: status %v; want %v", test.query, res.StatusCode, test.wantStatus)
+		}
+		if test.wantSize >= 0 && n != test.wantSize {
+			t.Errorf("GET %q: read %v bytes; want %v", test.query, n, test.wantSize)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package h2load
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A Handler serves synthetic responses for a load test.
+//
+// Each response has a body of Size bytes, sent after waiting Delay.
+// A request may override them with the "size" query parameter, a
+// number of bytes, and the "delay" query parameter, in the format
+// accepted by time.ParseDuration.
+type Handler struct {
+	Size  int
+	Delay time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	size, delay := h.Size, h.Delay
+	q := r.URL.Query()
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "h2load: invalid size", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	if v := q.Get("delay"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "h2load: invalid delay", http.StatusBadRequest)
+			return
+		}
+		delay = d
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	buf := make([]byte, 32<<10)
+	for size > 0 {
+		n := len(buf)
+		if size < n {
+			n = size
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+		size -= n
+	}
+}
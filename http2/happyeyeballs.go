@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// defaultDialAttemptDelay is the Connection Attempt Delay recommended
+// by RFC 8305, Section 8.
+const defaultDialAttemptDelay = 250 * time.Millisecond
+
+func (t *Transport) dialAttemptDelay() time.Duration {
+	if t.DialAttemptDelay == 0 {
+		return defaultDialAttemptDelay
+	}
+	return t.DialAttemptDelay
+}
+
+// dialParallel connects to addr, racing staggered connection attempts
+// to the addresses of its host as described in RFC 8305, Section 5.
+// It returns the first connection established, or the first error if
+// every attempt fails.
+func (t *Transport) dialParallel(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Control: t.DialControl}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	ipAddrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ipAddrs = interleaveAddrs(network, ipAddrs)
+	if len(ipAddrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(ipAddrs))
+	next, pending := 0, 0
+	startNext := func() {
+		target := net.JoinHostPort(ipAddrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			c, err := dialer.DialContext(ctx, network, target)
+			results <- result{c, err}
+		}()
+	}
+
+	delay := t.dialAttemptDelay()
+	var firstErr error
+	startNext()
+	for pending > 0 {
+		var tm timer
+		var wait <-chan time.Time
+		if next < len(ipAddrs) && delay > 0 {
+			tm = t.newTimer(delay)
+			wait = tm.C()
+		}
+		select {
+		case r := <-results:
+			if tm != nil {
+				tm.Stop()
+			}
+			pending--
+			if r.err == nil {
+				cancel()
+				// Close the connections of attempts which
+				// complete despite being canceled.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.c != nil {
+							r.c.Close()
+						}
+					}
+				}(pending)
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(ipAddrs) {
+				startNext()
+			}
+		case <-wait:
+			startNext()
+		}
+	}
+	return nil, firstErr
+}
+
+// interleaveAddrs returns the addresses in addrs which network can
+// dial, alternating between IPv6 and IPv4 addresses and starting with
+// IPv6, as recommended by RFC 8305, Section 4.
+func interleaveAddrs(network string, addrs []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			if network != "tcp6" {
+				v4 = append(v4, a)
+			}
+		} else if network != "tcp4" {
+			v6 = append(v6, a)
+		}
+	}
+	out := make([]net.IPAddr, 0, len(v6)+len(v4))
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			out = append(out, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			out = append(out, v4[0])
+			v4 = v4[1:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	addrs := []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("10.0.0.3")},
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("2001:db8::2")},
+	}
+	for _, test := range []struct {
+		network string
+		want    []string
+	}{
+		{"tcp", []string{"2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2", "10.0.0.3"}},
+		{"tcp4", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"tcp6", []string{"2001:db8::1", "2001:db8::2"}},
+	} {
+		var got []string
+		for _, a := range interleaveAddrs(test.network, addrs) {
+			got = append(got, a.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("interleaveAddrs(%q) = %q, want %q", test.network, got, test.want)
+		}
+	}
+}
+
+func TestTransportDialParallel(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	// The first address never connects.
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{
+			{IP: net.ParseIP("127.0.0.2")},
+			{IP: net.ParseIP(u.Hostname())},
+		}, nil
+	}
+	hang := make(chan struct{})
+	defer close(hang)
+	var (
+		mu       sync.Mutex
+		attempts []string
+	)
+	tr := &Transport{
+		TLSClientConfig:  tlsConfigInsecure,
+		DialAttemptDelay: 10 * time.Millisecond,
+		DialControl: func(network, address string, c syscall.RawConn) error {
+			mu.Lock()
+			attempts = append(attempts, address)
+			mu.Unlock()
+			if address == net.JoinHostPort("127.0.0.2", port) {
+				<-hang
+			}
+			return nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", "https://example.com:"+port+"/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{net.JoinHostPort("127.0.0.2", port), u.Host}
+	if !reflect.DeepEqual(attempts, want) {
+		t.Errorf("connection attempts = %q, want %q", attempts, want)
+	}
+}
@@ -103,3 +103,21 @@ func canonicalHeader(v string) string {
 	}
 	return http.CanonicalHeaderKey(v)
 }
+
+// headerCasing returns a map from the lowercase form of each of h's
+// keys to the key, for keys which are not lowercase.
+// See Transport.PreserveHeaderCase.
+func headerCasing(h http.Header) map[string]string {
+	var casing map[string]string
+	for k := range h {
+		lower, ascii := asciiToLower(k)
+		if !ascii || lower == k {
+			continue
+		}
+		if casing == nil {
+			casing = make(map[string]string)
+		}
+		casing[lower] = k
+	}
+	return casing
+}
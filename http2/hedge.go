@@ -0,0 +1,262 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A HedgingPolicy configures request hedging: when a request has not
+// received its response headers after Delay, the Transport sends a
+// copy of it on a second connection, uses whichever response arrives
+// first, and cancels the other request with RST_STREAM. Hedging trades
+// extra load on the server for lower tail latency, and is meant for
+// read-only requests which are safe to send twice.
+//
+// Only requests without a body are hedged. See Transport.Hedging.
+type HedgingPolicy struct {
+	// Delay is how long to wait for the response headers of a request
+	// before sending a hedged copy. It should be around the latency
+	// of the slowest requests worth hedging, such as the 95th
+	// percentile latency.
+	Delay time.Duration
+
+	// Methods lists the request methods which may be hedged.
+	// If nil, GET and HEAD requests are hedged.
+	Methods []string
+
+	// Budget limits the number of hedged copies sent to this fraction
+	// of the requests eligible for hedging, such as 0.05 for 5%, so
+	// that a slow server is not overloaded by hedges. Each eligible
+	// request earns Budget hedges, up to a reserve of 10; a hedge is
+	// sent only when a whole one is available.
+	// If zero, 0.1 is used.
+	Budget float64
+
+	mu     sync.Mutex
+	tokens float64
+	inited bool
+}
+
+// maxHedgeTokens is the most hedges a HedgingPolicy can save up.
+const maxHedgeTokens = 10
+
+func (p *HedgingPolicy) eligible(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if p.Methods == nil {
+		return req.Method == "GET" || req.Method == "HEAD" || req.Method == ""
+	}
+	for _, m := range p.Methods {
+		if m == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// earn adds the budget for an eligible request.
+func (p *HedgingPolicy) earn() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inited {
+		p.inited = true
+		p.tokens = maxHedgeTokens
+	}
+	b := p.Budget
+	if b == 0 {
+		b = 0.1
+	}
+	p.tokens += b
+	if p.tokens > maxHedgeTokens {
+		p.tokens = maxHedgeTokens
+	}
+}
+
+// spend reports whether a hedge may be sent, and if so spends it.
+func (p *HedgingPolicy) spend() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+type hedgeResult struct {
+	attempt int // 0 for the original request, 1 for the hedge
+	res     *http.Response
+	err     error
+	cancel  context.CancelFunc // cancels the request's context
+}
+
+// roundTripHedged sends req on cc, and if it is eligible for hedging
+// and has no response headers after the policy's delay, sends a copy
+// of it on another connection. The first successful response is
+// returned; the other request is canceled.
+//
+// Hedging requires the Transport's own connection pool, which can dial
+// the second connection.
+func (t *Transport) roundTripHedged(cc *ClientConn, req *http.Request, addr string) (*http.Response, error) {
+	policy := t.Hedging
+	p, ok := t.connPool().(*clientConnPool)
+	if !ok || policy == nil || !policy.eligible(req) {
+		return cc.RoundTrip(req)
+	}
+	policy.earn()
+
+	results := make(chan hedgeResult, 2)
+	ctx, cancel := context.WithCancel(req.Context())
+	cancels := []context.CancelFunc{cancel}
+	go func() {
+		t.markNewGoroutine()
+		res, err := cc.RoundTrip(req.WithContext(ctx))
+		results <- hedgeResult{0, res, err, cancel}
+	}()
+
+	tm := t.newTimer(policy.Delay)
+	select {
+	case r := <-results:
+		tm.Stop()
+		return r.response()
+	case <-req.Context().Done():
+		tm.Stop()
+	case <-tm.C():
+		if policy.spend() {
+			ctx, cancel := context.WithCancel(req.Context())
+			cancels = append(cancels, cancel)
+			go func() {
+				t.markNewGoroutine()
+				hc, err := p.getHedgeConn(req.WithContext(ctx), addr, cc)
+				if err == nil {
+					t.vlogf("http2: Transport hedging request to %v", addr)
+					var res *http.Response
+					res, err = hc.RoundTrip(req.WithContext(ctx))
+					if err == nil {
+						results <- hedgeResult{1, res, nil, cancel}
+						return
+					}
+				}
+				results <- hedgeResult{1, nil, err, cancel}
+			}()
+		}
+	}
+
+	// Use the first successful response, or the last error.
+	var r hedgeResult
+	for pending := len(cancels); pending > 0; pending-- {
+		r = <-results
+		if r.err == nil || pending == 1 {
+			if pending > 1 {
+				// Cancel the other request, and discard its
+				// response if it arrives anyway.
+				cancels[1-r.attempt]()
+				go func() {
+					t.markNewGoroutine()
+					if loser := <-results; loser.res != nil {
+						loser.res.Body.Close()
+					}
+				}()
+			}
+			break
+		}
+	}
+	return r.response()
+}
+
+// response returns the result of a hedged request. The request's
+// context is canceled when its response body is closed.
+func (r hedgeResult) response() (*http.Response, error) {
+	if r.err != nil {
+		r.cancel()
+		return nil, r.err
+	}
+	r.res.Body = hedgedBody{r.res.Body, r.cancel}
+	return r.res, nil
+}
+
+// hedgedBody is the body of a response to a request which may have
+// been hedged. It cancels the request's context when closed, and
+// passes through the optional interfaces of the body it wraps.
+type hedgedBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+var _ ResponseBodyAborter = hedgedBody{}
+
+func (b hedgedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (b hedgedBody) Abort(code ErrCode) error {
+	err := abortBody(b.ReadCloser, code)
+	b.cancel()
+	return err
+}
+
+func (b hedgedBody) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := b.ReadCloser.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, b.ReadCloser)
+}
+
+// getHedgeConn returns a connection to addr other than cc for a hedged
+// copy of req. Like a request, it dials a new connection or joins an
+// in-flight dial under the Transport's MaxConnsPerHost limit and
+// DialPolicy, but it never waits for a connection to free up. It does
+// not hedge to a host whose CircuitBreaker is not closed.
+func (p *clientConnPool) getHedgeConn(req *http.Request, addr string, cc *ClientConn) (*ClientConn, error) {
+	t := p.t
+	if err := t.CircuitBreaker.check(addr); err != nil {
+		return nil, err
+	}
+	for {
+		p.mu.Lock()
+		for _, c := range p.conns[addr] {
+			if c != cc && c.ReserveNewRequest() {
+				p.mu.Unlock()
+				return c, nil
+			}
+		}
+		if p.atConnLimitLocked(addr) {
+			p.mu.Unlock()
+			return nil, ErrMaxConnsPerHost
+		}
+		if p.failFastLocked(addr) {
+			p.mu.Unlock()
+			return nil, ErrDialInProgress
+		}
+		call := p.getStartDialLocked(req.Context(), addr)
+		p.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if shouldRetryDial(call, req) {
+			continue
+		}
+		if call.err != nil {
+			if call.ctx == req.Context() {
+				t.CircuitBreaker.dialDone(addr, req, call.err, t.now())
+			}
+			return nil, call.err
+		}
+		if call.res == cc || !call.res.ReserveNewRequest() {
+			return nil, errClientConnUnusable
+		}
+		return call.res, nil
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransportHedging(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Hedging = &HedgingPolicy{Delay: 1 * time.Second}
+	})
+	newConn := func() *testClientConn {
+		t.Helper()
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		return tc
+	}
+	respond := func(tc *testClientConn, streamID uint32, body string) {
+		t.Helper()
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      streamID,
+			EndHeaders:    true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+		})
+		tc.writeData(streamID, true, []byte(body))
+	}
+
+	// A request which gets no response within the delay is sent
+	// again on a second connection. The first response wins, and
+	// the other request is canceled.
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc1 := newConn()
+	tt.advance(1 * time.Second)
+	tc2 := newConn()
+	respond(tc2, 1, "hedge")
+	rt.wantStatus(200)
+	rt.wantBody([]byte("hedge"))
+	tc1.wantRSTStream(1, ErrCodeCancel)
+
+	// A late response to the canceled request is discarded.
+	respond(tc1, 1, "late")
+	if tt.hasConn() {
+		t.Fatalf("unexpected connection dialed")
+	}
+
+	// A request which gets a response within the delay is not hedged.
+	rt = tt.roundTrip(req)
+	tc1.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	respond(tc1, 3, "fast")
+	rt.wantStatus(200)
+	rt.wantBody([]byte("fast"))
+	tt.advance(1 * time.Second)
+	tc2.wantIdle()
+
+	// Requests with a body are not hedged.
+	postReq, _ := http.NewRequest("POST", "https://dummy.tld/", strings.NewReader("body"))
+	rt = tt.roundTrip(postReq)
+	tc1.wantHeaders(wantHeader{
+		streamID:  5,
+		endStream: false,
+	})
+	tc1.wantData(wantData{
+		streamID:  5,
+		endStream: true,
+		size:      len("body"),
+	})
+	tt.advance(1 * time.Second)
+	tc2.wantIdle()
+	respond(tc1, 5, "post")
+	rt.wantStatus(200)
+	rt.wantBody([]byte("post"))
+}
+
+func TestTransportHedgingOriginalWins(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Hedging = &HedgingPolicy{Delay: 1 * time.Second}
+	})
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	var tcs []*testClientConn
+	for i := 0; i < 2; i++ {
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		tcs = append(tcs, tc)
+		tt.advance(1 * time.Second)
+	}
+	tcs[0].writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tcs[0].makeHeaderBlockFragment(":status", "204"),
+	})
+	rt.wantStatus(204)
+	tcs[1].wantRSTStream(1, ErrCodeCancel)
+}
+
+func TestHedgingPolicyBudget(t *testing.T) {
+	p := &HedgingPolicy{Budget: 0.5}
+	var hedges int
+	for i := 0; i < 100; i++ {
+		p.earn()
+		if p.spend() {
+			hedges++
+		}
+	}
+	// The reserve of 10 starts full, so the first request's budget is
+	// lost; the other 99 requests earn 49 more hedges.
+	if want := maxHedgeTokens + 49; hedges != want {
+		t.Errorf("sent %v hedges for 100 requests, want %v", hedges, want)
+	}
+
+	for _, tc := range []struct {
+		p      *HedgingPolicy
+		method string
+		body   bool
+		want   bool
+	}{
+		{&HedgingPolicy{}, "GET", false, true},
+		{&HedgingPolicy{}, "HEAD", false, true},
+		{&HedgingPolicy{}, "GET", true, false},
+		{&HedgingPolicy{}, "POST", false, false},
+		{&HedgingPolicy{Methods: []string{"PROPFIND"}}, "PROPFIND", false, true},
+		{&HedgingPolicy{Methods: []string{"PROPFIND"}}, "GET", false, false},
+	} {
+		req, _ := http.NewRequest(tc.method, "https://dummy.tld/", nil)
+		if tc.body {
+			req, _ = http.NewRequest(tc.method, "https://dummy.tld/", strings.NewReader("x"))
+		}
+		if got := tc.p.eligible(req); got != tc.want {
+			t.Errorf("%v %v (body=%v): eligible = %v, want %v", tc.p.Methods, tc.method, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestTransportHedgingMaxConnsPerHost(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Hedging = &HedgingPolicy{Delay: 1 * time.Second}
+		tr.MaxConnsPerHost = 1
+	})
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings()
+	tc.wantFrameType(FrameSettings) // settings ACK
+
+	// The host has MaxConnsPerHost connections, so the hedge is not
+	// sent on a new one.
+	tt.advance(1 * time.Second)
+	if tt.hasConn() {
+		t.Fatalf("Transport dialed a connection for the hedge, want at most one connection")
+	}
+	tc.wantIdle()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
+
+func TestTransportHedgingBodyInterfaces(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Hedging = &HedgingPolicy{Delay: 1 * time.Second}
+	})
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings()
+	tc.wantFrameType(FrameSettings) // settings ACK
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	res := rt.response()
+
+	// The body of a hedgeable response keeps the interfaces
+	// of the Transport's response bodies.
+	if _, ok := res.Body.(io.WriterTo); !ok {
+		t.Errorf("response body %T does not implement io.WriterTo", res.Body)
+	}
+	if err := UpdateExtensiblePriority(res, ExtensiblePriority{Urgency: 6}); err != nil {
+		t.Fatalf("UpdateExtensiblePriority: %v", err)
+	}
+	readFrame[*PriorityUpdateFrame](t, tc)
+	a, ok := res.Body.(ResponseBodyAborter)
+	if !ok {
+		t.Fatalf("response body %T does not implement ResponseBodyAborter", res.Body)
+	}
+	a.Abort(ErrCodeInternal)
+	tc.wantRSTStream(1, ErrCodeInternal)
+}
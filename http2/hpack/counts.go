@@ -0,0 +1,68 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hpack
+
+// FieldCounts counts the header fields encoded by an Encoder or
+// decoded by a Decoder, by their representation (RFC 7541, Section 6).
+// They may be used to judge how well a dynamic table size suits the
+// headers sent on a connection.
+type FieldCounts struct {
+	// StaticIndexed and DynamicIndexed count the fields represented
+	// entirely by an index into the static or dynamic table.
+	StaticIndexed  int64
+	DynamicIndexed int64
+
+	// StaticName and DynamicName count the literal fields whose name
+	// is an index into the static or dynamic table, and NewName the
+	// literal fields whose name is a literal too.
+	StaticName  int64
+	DynamicName int64
+	NewName     int64
+
+	// Added counts the literal fields added to the dynamic table.
+	Added int64
+}
+
+// Fields returns the number of fields counted.
+func (c FieldCounts) Fields() int64 {
+	return c.StaticIndexed + c.DynamicIndexed + c.StaticName + c.DynamicName + c.NewName
+}
+
+// DynamicHitRate returns the fraction of the fields counted which were
+// represented entirely by an index into the dynamic table, or zero if
+// there were none.
+func (c FieldCounts) DynamicHitRate() float64 {
+	n := c.Fields()
+	if n == 0 {
+		return 0
+	}
+	return float64(c.DynamicIndexed) / float64(n)
+}
+
+// indexed counts a field represented by the table index idx.
+func (c *FieldCounts) indexed(idx uint64) {
+	if idx <= uint64(staticTable.len()) {
+		c.StaticIndexed++
+	} else {
+		c.DynamicIndexed++
+	}
+}
+
+// literal counts a literal field whose name is the table index
+// nameIdx, or a literal if nameIdx is zero, and which is added to
+// the dynamic table if added is set.
+func (c *FieldCounts) literal(nameIdx uint64, added bool) {
+	switch {
+	case nameIdx == 0:
+		c.NewName++
+	case nameIdx <= uint64(staticTable.len()):
+		c.StaticName++
+	default:
+		c.DynamicName++
+	}
+	if added {
+		c.Added++
+	}
+}
@@ -28,6 +28,7 @@ type Encoder struct {
 	tableSizeUpdate bool
 	w               io.Writer
 	buf             []byte
+	counts          FieldCounts
 }
 
 // NewEncoder returns a new Encoder which performs HPACK encoding. An
@@ -62,8 +63,10 @@ func (e *Encoder) WriteField(f HeaderField) error {
 	idx, nameValueMatch := e.searchTable(f)
 	if nameValueMatch {
 		e.buf = appendIndexed(e.buf, idx)
+		e.counts.indexed(idx)
 	} else {
 		indexing := e.shouldIndex(f)
+		e.counts.literal(idx, indexing)
 		if indexing {
 			e.dynTab.add(f)
 		}
@@ -121,6 +124,24 @@ func (e *Encoder) MaxDynamicTableSize() (v uint32) {
 	return e.dynTab.maxSize
 }
 
+// DynamicTable returns a copy of the fields in the encoder's dynamic
+// table, in index order: the first field is the most recently added,
+// at index 62.
+func (e *Encoder) DynamicTable() []HeaderField {
+	return e.dynTab.fields()
+}
+
+// FieldCounts returns the counts of the fields the encoder has encoded.
+func (e *Encoder) FieldCounts() FieldCounts {
+	return e.counts
+}
+
+// DynamicTableSize returns the size of the encoder's dynamic table,
+// as defined in RFC 7541, Section 4.1.
+func (e *Encoder) DynamicTableSize() uint32 {
+	return e.dynTab.size
+}
+
 // SetMaxDynamicTableSizeLimit changes the maximum value that can be
 // specified in SetMaxDynamicTableSize to v. By default, it is set to
 // 4096, which is the same size of the default dynamic header table
@@ -384,3 +384,86 @@ func BenchmarkEncoderSearchTable(b *testing.B) {
 		}
 	}
 }
+
+func TestDynamicTableSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	fields := []HeaderField{
+		{Name: "custom-key", Value: "custom-value"},
+		{Name: ":authority", Value: "example.com"},
+		{Name: "x-secret", Value: "no", Sensitive: true}, // never indexed
+		{Name: ":method", Value: "GET"},                  // in the static table
+	}
+	for _, hf := range fields {
+		e.WriteField(hf)
+	}
+	d := NewDecoder(initialHeaderTableSize, nil)
+	if _, err := d.DecodeFull(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HeaderField{
+		{Name: ":authority", Value: "example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	}
+	wantSize := want[0].Size() + want[1].Size()
+	if got := e.DynamicTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Encoder.DynamicTable() = %v, want %v", got, want)
+	}
+	if got := e.DynamicTableSize(); got != wantSize {
+		t.Errorf("Encoder.DynamicTableSize() = %v, want %v", got, wantSize)
+	}
+	if got := d.DynamicTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decoder.DynamicTable() = %v, want %v", got, want)
+	}
+	if got := d.DynamicTableSize(); got != wantSize {
+		t.Errorf("Decoder.DynamicTableSize() = %v, want %v", got, wantSize)
+	}
+
+	// The snapshot is a copy.
+	e.DynamicTable()[0].Value = "changed"
+	if got := e.DynamicTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after modifying snapshot, Encoder.DynamicTable() = %v, want %v", got, want)
+	}
+}
+
+func TestFieldCounts(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	fields := []HeaderField{
+		{Name: ":method", Value: "GET"},                  // static table
+		{Name: "custom-key", Value: "custom-value"},      // new name, added
+		{Name: "custom-key", Value: "custom-value"},      // dynamic table
+		{Name: "custom-key", Value: "other"},             // dynamic name, added
+		{Name: ":authority", Value: "example.com"},       // static name, added
+		{Name: "x-secret", Value: "no", Sensitive: true}, // new name, never indexed
+	}
+	for _, hf := range fields {
+		e.WriteField(hf)
+	}
+	d := NewDecoder(initialHeaderTableSize, nil)
+	if _, err := d.DecodeFull(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := FieldCounts{
+		StaticIndexed:  1,
+		DynamicIndexed: 1,
+		StaticName:     1,
+		DynamicName:    1,
+		NewName:        2,
+		Added:          3,
+	}
+	if got := e.FieldCounts(); got != want {
+		t.Errorf("Encoder.FieldCounts() = %+v, want %+v", got, want)
+	}
+	if got := d.FieldCounts(); got != want {
+		t.Errorf("Decoder.FieldCounts() = %+v, want %+v", got, want)
+	}
+	if got, want := want.Fields(), int64(len(fields)); got != want {
+		t.Errorf("Fields() = %v, want %v", got, want)
+	}
+	if got, want := want.DynamicHitRate(), 1.0/6; got != want {
+		t.Errorf("DynamicHitRate() = %v, want %v", got, want)
+	}
+}
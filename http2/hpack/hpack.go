@@ -94,6 +94,8 @@ type Decoder struct {
 	saveBuf bytes.Buffer
 
 	firstField bool // processing the first field of the header block
+
+	counts FieldCounts
 }
 
 // NewDecoder returns a new decoder with the provided maximum dynamic
@@ -157,6 +159,24 @@ func (d *Decoder) SetAllowedMaxDynamicTableSize(v uint32) {
 	d.dynTab.allowedMaxSize = v
 }
 
+// DynamicTable returns a copy of the fields in the decoder's dynamic
+// table, in index order: the first field is the most recently added,
+// at index 62.
+func (d *Decoder) DynamicTable() []HeaderField {
+	return d.dynTab.fields()
+}
+
+// FieldCounts returns the counts of the fields the decoder has decoded.
+func (d *Decoder) FieldCounts() FieldCounts {
+	return d.counts
+}
+
+// DynamicTableSize returns the size of the decoder's dynamic table,
+// as defined in RFC 7541, Section 4.1.
+func (d *Decoder) DynamicTableSize() uint32 {
+	return d.dynTab.size
+}
+
 type dynamicTable struct {
 	// https://httpwg.org/specs/rfc7541.html#rfc.section.2.3.2
 	table          headerFieldTable
@@ -176,6 +196,17 @@ func (dt *dynamicTable) add(f HeaderField) {
 	dt.evict()
 }
 
+// fields returns a copy of the table's entries in index order,
+// most recently added first.
+func (dt *dynamicTable) fields() []HeaderField {
+	ents := dt.table.ents
+	fields := make([]HeaderField, len(ents))
+	for i, hf := range ents {
+		fields[len(ents)-1-i] = hf
+	}
+	return fields
+}
+
 // If we're too big, evict old stuff.
 func (dt *dynamicTable) evict() {
 	var n int
@@ -250,12 +281,12 @@ func (d *Decoder) Write(p []byte) (n int, err error) {
 	}
 	// Only copy the data if we have to. Optimistically assume
 	// that p will contain a complete header block.
-	if d.saveBuf.Len() == 0 {
+	saved := d.saveBuf.Len() > 0
+	if !saved {
 		d.buf = p
 	} else {
 		d.saveBuf.Write(p)
 		d.buf = d.saveBuf.Bytes()
-		d.saveBuf.Reset()
 	}
 
 	for len(d.buf) > 0 {
@@ -268,9 +299,18 @@ func (d *Decoder) Write(p []byte) (n int, err error) {
 			// but keep this as a last resort.
 			const varIntOverhead = 8 // conservative
 			if d.maxStrLen != 0 && int64(len(d.buf)) > 2*(int64(d.maxStrLen)+varIntOverhead) {
+				d.saveBuf.Reset()
 				return 0, ErrStringLength
 			}
-			d.saveBuf.Write(d.buf)
+			if saved {
+				// d.buf is the unparsed tail of saveBuf. Drop the
+				// fields parsed from its head rather than copying
+				// the tail, so that a field split across many
+				// writes is copied into saveBuf only once.
+				d.saveBuf.Next(d.saveBuf.Len() - len(d.buf))
+			} else {
+				d.saveBuf.Write(d.buf)
+			}
 			return len(p), nil
 		}
 		d.firstField = false
@@ -278,6 +318,9 @@ func (d *Decoder) Write(p []byte) (n int, err error) {
 			break
 		}
 	}
+	if saved {
+		d.saveBuf.Reset()
+	}
 	return len(p), err
 }
 
@@ -346,6 +389,7 @@ func (d *Decoder) parseFieldIndexed() error {
 		return DecodingError{InvalidIndexError(idx)}
 	}
 	d.buf = buf
+	d.counts.indexed(idx)
 	return d.callEmit(HeaderField{Name: hf.Name, Value: hf.Value})
 }
 
@@ -389,6 +433,7 @@ func (d *Decoder) parseFieldLiteral(n uint8, it indexType) error {
 		}
 	}
 	d.buf = buf
+	d.counts.literal(nameIdx, it.indexed())
 	if it.indexed() {
 		d.dynTab.add(hf)
 	}
@@ -729,9 +729,6 @@ func TestEmitEnabled(t *testing.T) {
 }
 
 func TestSlowIncrementalDecode(t *testing.T) {
-	// TODO(dneil): Fix for -race mode.
-	t.Skip("too slow in -race mode")
-
 	var buf bytes.Buffer
 	enc := NewEncoder(&buf)
 	hf := HeaderField{
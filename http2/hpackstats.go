@@ -0,0 +1,163 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HeaderCompressionStats describes HPACK header compression on a
+// connection. Field sizes are as defined in RFC 7541, Section 4.1.
+type HeaderCompressionStats struct {
+	// SentFieldBytes is the total size of the header fields sent,
+	// before compression.
+	SentFieldBytes int64
+
+	// SentBlockBytes is the total size of the compressed header
+	// blocks sent.
+	SentBlockBytes int64
+
+	// ReceivedFieldBytes is the total size of the header fields
+	// decoded from received header blocks.
+	ReceivedFieldBytes int64
+
+	// ReceivedBlockBytes is the total size of the compressed header
+	// blocks received.
+	ReceivedBlockBytes int64
+
+	// SentFields and ReceivedFields count the header fields sent and
+	// received by their representation. Their DynamicHitRate is the
+	// fraction of fields found in the dynamic table: a low rate for
+	// headers repeated across requests suggests the table, whose size
+	// is set by SETTINGS_HEADER_TABLE_SIZE, is too small for them.
+	SentFields     hpack.FieldCounts
+	ReceivedFields hpack.FieldCounts
+
+	// EncoderTable and DecoderTable hold the fields in the dynamic
+	// tables of the connection's HPACK encoder and decoder, most
+	// recently added first, as of the last header block sent or
+	// received.
+	//
+	// The tables can contain sensitive header values, such as
+	// cookies, so they are only recorded when the GODEBUG
+	// environment variable contains http2hpackdebug=1.
+	// Otherwise they are nil.
+	EncoderTable []hpack.HeaderField
+	DecoderTable []hpack.HeaderField
+}
+
+// SentRatio returns the size of the header blocks sent as a fraction
+// of the size of their fields, or zero if none were sent.
+func (s HeaderCompressionStats) SentRatio() float64 {
+	return compressionRatio(s.SentBlockBytes, s.SentFieldBytes)
+}
+
+// ReceivedRatio returns the size of the header blocks received as a
+// fraction of the size of their fields, or zero if none were received.
+func (s HeaderCompressionStats) ReceivedRatio() float64 {
+	return compressionRatio(s.ReceivedBlockBytes, s.ReceivedFieldBytes)
+}
+
+func compressionRatio(block, fields int64) float64 {
+	if fields == 0 {
+		return 0
+	}
+	return float64(block) / float64(fields)
+}
+
+// HeaderCompressionReporter is implemented by the http.ResponseWriter
+// passed to Server handlers. It reports header compression on the
+// connection the request was received on.
+type HeaderCompressionReporter interface {
+	HeaderCompression() HeaderCompressionStats
+}
+
+var _ HeaderCompressionReporter = (*responseWriter)(nil)
+
+// HeaderCompression reports header compression on the connection the
+// request was received on.
+func (w *responseWriter) HeaderCompression() HeaderCompressionStats {
+	return w.rws.conn.hpackStats.get()
+}
+
+// HeaderCompression reports header compression on the connection.
+func (cc *ClientConn) HeaderCompression() HeaderCompressionStats {
+	return cc.hpackStats.get()
+}
+
+// headerEncoder is an HPACK encoder which counts the header fields
+// it encodes and the bytes it encodes them to.
+type headerEncoder struct {
+	*hpack.Encoder
+	fieldBytes int64
+	blockBytes int64
+}
+
+func newHeaderEncoder(w io.Writer) *headerEncoder {
+	e := &headerEncoder{}
+	e.Encoder = hpack.NewEncoder(countingWriter{w, &e.blockBytes})
+	return e
+}
+
+func (e *headerEncoder) writeField(hf hpack.HeaderField) {
+	e.fieldBytes += int64(hf.Size())
+	e.Encoder.WriteField(hf)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
+
+// hpackStats holds a connection's HeaderCompressionStats.
+// The encoder and decoder are owned by other goroutines, so their
+// counts are copied in by their owners after each header block.
+type hpackStats struct {
+	mu sync.Mutex
+	s  HeaderCompressionStats
+}
+
+func (hs *hpackStats) get() HeaderCompressionStats {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return hs.s
+}
+
+// encoded records the state of enc after it encodes a header block.
+func (hs *hpackStats) encoded(enc *headerEncoder) {
+	var table []hpack.HeaderField
+	if debugHPACKTables {
+		table = enc.DynamicTable()
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.s.SentFieldBytes = enc.fieldBytes
+	hs.s.SentBlockBytes = enc.blockBytes
+	hs.s.SentFields = enc.FieldCounts()
+	hs.s.EncoderTable = table
+}
+
+// decoded records the state of fr's decoder after it decodes a header block.
+func (hs *hpackStats) decoded(fr *Framer) {
+	var table []hpack.HeaderField
+	if debugHPACKTables {
+		table = fr.ReadMetaHeaders.DynamicTable()
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.s.ReceivedFieldBytes = fr.headerFieldBytesRead
+	hs.s.ReceivedBlockBytes = fr.headerBlockBytesRead
+	hs.s.ReceivedFields = fr.ReadMetaHeaders.FieldCounts()
+	hs.s.DecoderTable = table
+}
@@ -10,6 +10,17 @@
 // For use in earlier Go versions see ConfigureServer. (Transport support
 // requires Go 1.6 or later)
 //
+// RPC frameworks such as gRPC rely on a few HTTP/2 behaviors beyond
+// plain HTTP semantics, which this package supports as follows.
+// A Server handler may send response headers immediately, before any
+// body, by calling WriteHeader and then Flush. A handler which declares
+// trailers and returns without writing a body produces a single
+// HEADERS frame ending the stream when Server.TrailersOnly is set.
+// Server.RequestContext can apply deadlines carried in request headers
+// to the handler's context. On the client side, Transport.ReadIdleTimeout
+// and ClientConn.Ping detect dead connections, and canceling a request's
+// context resets its stream.
+//
 // See https://http2.github.io/ for more information on HTTP/2.
 //
 // See https://http2.golang.org/ for a test server running this code.
@@ -21,12 +32,14 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http/httpguts"
@@ -37,6 +50,8 @@ var (
 	logFrameWrites bool
 	logFrameReads  bool
 	inTests        bool
+
+	debugHPACKTables bool // record HPACK dynamic tables in HeaderCompressionStats
 )
 
 func init() {
@@ -49,6 +64,9 @@ func init() {
 		logFrameWrites = true
 		logFrameReads = true
 	}
+	if strings.Contains(e, "http2hpackdebug=1") {
+		debugHPACKTables = true
+	}
 }
 
 const (
@@ -128,7 +146,7 @@ func (s Setting) String() string {
 func (s Setting) Valid() error {
 	// Limits and error codes from 6.5.2 Defined SETTINGS Parameters
 	switch s.ID {
-	case SettingEnablePush:
+	case SettingEnablePush, SettingEnableConnectProtocol:
 		if s.Val != 1 && s.Val != 0 {
 			return ConnectionError(ErrCodeProtocol)
 		}
@@ -155,6 +173,9 @@ const (
 	SettingInitialWindowSize    SettingID = 0x4
 	SettingMaxFrameSize         SettingID = 0x5
 	SettingMaxHeaderListSize    SettingID = 0x6
+
+	// SettingEnableConnectProtocol is defined by RFC 8441, Section 3.
+	SettingEnableConnectProtocol SettingID = 0x8
 )
 
 var settingName = map[SettingID]string{
@@ -164,6 +185,8 @@ var settingName = map[SettingID]string{
 	SettingInitialWindowSize:    "INITIAL_WINDOW_SIZE",
 	SettingMaxFrameSize:         "MAX_FRAME_SIZE",
 	SettingMaxHeaderListSize:    "MAX_HEADER_LIST_SIZE",
+
+	SettingEnableConnectProtocol: "ENABLE_CONNECT_PROTOCOL",
 }
 
 func (s SettingID) String() string {
@@ -325,6 +348,28 @@ type connectionStater interface {
 	ConnectionState() tls.ConnectionState
 }
 
+// controlConn calls f with the raw network connection underlying c,
+// unwrapping a *tls.Conn or similar wrapper.
+// It does nothing if c has no underlying raw connection.
+func controlConn(c net.Conn, f func(syscall.RawConn) error) error {
+	for {
+		nc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = nc.NetConn()
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return f(rc)
+}
+
 var sorterPool = sync.Pool{New: func() interface{} { return new(sorter) }}
 
 type sorter struct {
@@ -375,6 +420,56 @@ func validPseudoPath(v string) bool {
 	return (len(v) > 0 && v[0] == '/') || v == "*"
 }
 
+// An AuthorityError is returned when a request fails the strict
+// :authority, Host, and request target checks enabled by
+// Transport.StrictAuthority or Server.StrictAuthority.
+type AuthorityError struct {
+	Authority string // :authority pseudo-header field
+	Host      string // Host header field, if any
+	Path      string // :path pseudo-header field
+	Reason    string
+}
+
+func (e *AuthorityError) Error() string {
+	return fmt.Sprintf("http2: invalid request authority %q: %s", e.Authority, e.Reason)
+}
+
+// checkAuthority applies the checks of RFC 9113, Section 8.3.1 to a
+// request's :authority, Host header (host, or "" if absent), and :path.
+// The path is ignored for CONNECT requests.
+func checkAuthority(method, authority, host, path string) error {
+	var reason string
+	switch {
+	case authority == "" && method == "CONNECT":
+		reason = "CONNECT request without :authority"
+	case strings.Contains(authority, "@"):
+		// "[:authority] MUST NOT include the deprecated userinfo
+		// subcomponent for "http" or "https" schemed URIs."
+		reason = "authority includes userinfo"
+	case !httpguts.ValidHostHeader(authority):
+		reason = "malformed authority"
+	case host != "" && !asciiEqualFold(host, authority):
+		// "A server SHOULD treat a request as malformed if it contains
+		// a Host header field that identifies an entity that differs
+		// from the entity in the ":authority" pseudo-header field."
+		reason = "Host header does not match :authority"
+	case method == "CONNECT":
+	case path == "*" && method != "OPTIONS":
+		reason = "asterisk-form request target with method " + method
+	case !strings.HasPrefix(path, "/") && path != "*":
+		reason = "request target is not in origin form"
+	}
+	if reason == "" {
+		return nil
+	}
+	return &AuthorityError{
+		Authority: authority,
+		Host:      host,
+		Path:      path,
+		Reason:    reason,
+	}
+}
+
 // incomparable is a zero-width, non-comparable type. Adding it to a struct
 // makes that struct also non-comparable, and generally doesn't add
 // any size (as long as it's first).
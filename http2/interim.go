@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// An InterimResponse is an informational (1xx) response received by a
+// Transport before the final response to a request, such as a
+// 103 Early Hints response.
+type InterimResponse struct {
+	// StatusCode is the value of the :status pseudo-header.
+	StatusCode int
+
+	// Header holds the regular header fields of the response,
+	// keyed by canonical name.
+	Header http.Header
+
+	// PseudoFields holds the pseudo-header fields of the response,
+	// including :status, in the order received.
+	PseudoFields []hpack.HeaderField
+}
+
+type interimResponsesKey struct{}
+
+// interimRecorder holds the interim responses of requests made with
+// a context returned by WithInterimResponses.
+type interimRecorder struct {
+	mu  sync.Mutex
+	res map[*http.Request][]InterimResponse
+}
+
+// WithInterimResponses returns a new context based on ctx which records
+// the interim responses received by a Transport for requests made with
+// it. Use InterimResponses to retrieve them from the final response.
+// To handle each interim response as it arrives, use the
+// Got1xxResponse hook of an httptrace.ClientTrace instead.
+func WithInterimResponses(ctx context.Context) context.Context {
+	return context.WithValue(ctx, interimResponsesKey{}, &interimRecorder{})
+}
+
+// InterimResponses returns the interim responses received, in order,
+// before the final response res. It returns nil if there were none,
+// or if the request was not made with a context returned by
+// WithInterimResponses.
+//
+// The interim responses are kept only until they are returned by
+// InterimResponses or res.Body is closed, so later calls return nil.
+func InterimResponses(res *http.Response) []InterimResponse {
+	if res == nil || res.Request == nil {
+		return nil
+	}
+	r := contextInterimRecorder(res.Request.Context())
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interim := r.res[res.Request]
+	delete(r.res, res.Request)
+	return interim
+}
+
+func contextInterimRecorder(ctx context.Context) *interimRecorder {
+	r, _ := ctx.Value(interimResponsesKey{}).(*interimRecorder)
+	return r
+}
+
+// record records the interim responses received before the final
+// response to req.
+func (r *interimRecorder) record(req *http.Request, interim []InterimResponse) {
+	if len(interim) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.res == nil {
+		r.res = make(map[*http.Request][]InterimResponse)
+	}
+	r.res[req] = interim
+}
+
+// forget discards the interim responses recorded for req.
+func (r *interimRecorder) forget(req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.res, req)
+}
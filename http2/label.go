@@ -0,0 +1,90 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import "context"
+
+type requestLabelKey struct{}
+
+// WithRequestLabel returns a copy of ctx carrying label, an opaque
+// value such as an application's request ID. The Transport includes
+// the label of a request's context in the FrameEvent and
+// FlowControlStall events for the request's stream, including the
+// streams of retried and hedged attempts, and in the BreakerEvent of
+// a CircuitBreaker state change caused by one of its attempts, so
+// that they can be correlated with the request.
+//
+// Hooks which are passed the request, such as RetryPolicy.ShouldRetry
+// and CircuitBreaker.IsFailure, can retrieve the label with
+// RequestLabel. Events about a connection rather than a request, such
+// as RTTSample, ConnDrainInfo and PoolEvent, carry no label.
+func WithRequestLabel(ctx context.Context, label interface{}) context.Context {
+	return context.WithValue(ctx, requestLabelKey{}, label)
+}
+
+// RequestLabel returns the label added to ctx by WithRequestLabel,
+// or nil.
+func RequestLabel(ctx context.Context) interface{} {
+	return ctx.Value(requestLabelKey{})
+}
+
+// A FrameEvent is a frame sent or received by a Transport on the
+// stream of a request. See Transport.FrameEvent.
+type FrameEvent struct {
+	// Header is the frame's header, including its type, flags,
+	// length and stream.
+	Header FrameHeader
+
+	// Sent reports whether the Transport sent the frame.
+	// Otherwise it received it.
+	Sent bool
+
+	// Label is the request's label, set with WithRequestLabel.
+	// It is nil for requests without a label, and for streams
+	// the Transport has finished with.
+	Label interface{}
+}
+
+// setStreamLabel records the label of the request on stream id,
+// for reporting events on the stream.
+func (cc *ClientConn) setStreamLabel(id uint32, label interface{}) {
+	if label == nil {
+		return
+	}
+	cc.labelMu.Lock()
+	defer cc.labelMu.Unlock()
+	if cc.labels == nil {
+		cc.labels = make(map[uint32]interface{})
+	}
+	cc.labels[id] = label
+}
+
+// streamLabel returns the label of the request on stream id, or nil.
+func (cc *ClientConn) streamLabel(id uint32) interface{} {
+	cc.labelMu.Lock()
+	defer cc.labelMu.Unlock()
+	return cc.labels[id]
+}
+
+func (cc *ClientConn) forgetStreamLabel(id uint32) {
+	cc.labelMu.Lock()
+	defer cc.labelMu.Unlock()
+	delete(cc.labels, id)
+}
+
+// frameEvent reports a frame sent or received on a stream to
+// Transport.FrameEvent. Frames on stream 0 are not reported.
+// It is called with cc.wmu held for frames sent, so it must not
+// acquire cc.mu.
+func (cc *ClientConn) frameEvent(fh FrameHeader, sent bool) {
+	if fh.StreamID == 0 {
+		return
+	}
+	cc.t.FrameEvent(FrameEvent{
+		Header: fh,
+		Sent:   sent,
+		Label:  cc.streamLabel(fh.StreamID),
+	})
+}
@@ -123,6 +123,13 @@ func (c *synctestNetConn) SetReadBufferSize(size int) {
 	c.loc.setReadBufferSize(size)
 }
 
+// SetReadChunkSize limits the number of bytes returned by each Read
+// from the connection, regardless of how the peer's writes were sized.
+// A size of zero or less removes the limit.
+func (c *synctestNetConn) SetReadChunkSize(size int) {
+	c.loc.setReadChunkSize(size)
+}
+
 // synctestNetConnHalf is one data flow in the connection created by synctestNetPipe.
 // Each half contains a buffer. Writes to the half push to the buffer, and reads pull from it.
 type synctestNetConnHalf struct {
@@ -143,6 +150,7 @@ type synctestNetConnHalf struct {
 	lockc  chan struct{} // neither readable nor writable
 
 	bufMax   int // maximum buffer size
+	readMax  int // maximum bytes returned by one read, if non-zero
 	buf      bytes.Buffer
 	readErr  error // error returned by reads
 	writeErr error // error returned by writes
@@ -243,9 +251,18 @@ func (h *synctestNetConnHalf) read(b []byte) (n int, err error) {
 	if h.buf.Len() == 0 && h.readErr != nil {
 		return 0, h.readErr
 	}
+	if h.readMax > 0 && len(b) > h.readMax {
+		b = b[:h.readMax]
+	}
 	return h.buf.Read(b)
 }
 
+func (h *synctestNetConnHalf) setReadChunkSize(size int) {
+	h.lock()
+	defer h.unlock()
+	h.readMax = size
+}
+
 func (h *synctestNetConnHalf) setReadBufferSize(size int) {
 	h.lock()
 	defer h.unlock()
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"net"
+	"net/url"
+	"sort"
+)
+
+// processOrigin adds the origins of an ORIGIN frame to the
+// connection's origin set (RFC 8336, Section 2.3).
+//
+// Until a connection receives an ORIGIN frame, it may be coalesced
+// for other hosts its certificate is valid for which resolve to its
+// address. Afterwards, it is only used for the origins in its origin
+// set, without consulting DNS.
+func (rl *clientConnReadLoop) processOrigin(f *OriginFrame) error {
+	cc := rl.cc
+	if cc.tlsState == nil {
+		// ORIGIN frames only apply to "https" origins.
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.originSet == nil {
+		cc.originSet = make(map[string]bool)
+		if addr := cc.initialOriginAddr(); addr != "" {
+			cc.originSet[addr] = true
+		}
+	}
+	for _, o := range f.Origins {
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			continue
+		}
+		host, ok := asciiToLower(u.Host)
+		if !ok {
+			// Origins are ASCII serializations.
+			continue
+		}
+		cc.originSet[authorityAddr("https", host)] = true
+	}
+	return nil
+}
+
+// initialOriginAddr returns the address of the origin a connection's
+// origin set starts with: the host sent in the TLS server name
+// indication, or the server's IP address if there was none, and the
+// port of the origin the connection was dialed for. The server's
+// port is used only when that origin is unknown, since an alternative
+// service or service endpoint may listen on another port than the
+// origin's.
+// cc.mu must be held.
+func (cc *ClientConn) initialOriginAddr() string {
+	if cc.tconn == nil {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(cc.tconn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	if cc.dialedAddr != "" {
+		if _, dport, err := net.SplitHostPort(cc.dialedAddr); err == nil {
+			port = dport
+		}
+	}
+	if sni, ok := asciiToLower(cc.tlsState.ServerName); ok && sni != "" {
+		host = sni
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// originsLocked returns the addresses of the origins in the
+// connection's origin set, sorted, or nil if it has none.
+// cc.mu must be held.
+func (cc *ClientConn) originsLocked() []string {
+	if cc.originSet == nil {
+		return nil
+	}
+	addrs := make([]string, 0, len(cc.originSet))
+	for addr := range cc.originSet {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// hasOriginSet reports whether the connection has received an ORIGIN
+// frame, and so may only be used for the origins it listed.
+func (cc *ClientConn) hasOriginSet() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.originSet != nil
+}
+
+// inOriginSet reports whether addr is in the connection's origin set.
+func (cc *ClientConn) inOriginSet(addr string) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	addr, _ = asciiToLower(addr)
+	return cc.originSet[addr]
+}
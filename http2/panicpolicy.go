@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// A PanicAction is how a Server responds to a panicking handler.
+// See Server.HandlerPanic.
+type PanicAction int
+
+const (
+	// PanicResetStream resets the handler's stream with
+	// INTERNAL_ERROR, leaving the connection's other streams
+	// unaffected. It is the default.
+	PanicResetStream PanicAction = iota
+
+	// PanicGoAway resets the handler's stream, and closes the
+	// connection with GOAWAY(INTERNAL_ERROR), ending its other
+	// streams.
+	PanicGoAway
+
+	// PanicRethrow resets the handler's stream, waits for the
+	// reset to be flushed to the connection, and then panics
+	// again with the same value in the handler's goroutine,
+	// crashing the program.
+	PanicRethrow
+)
+
+func (a PanicAction) String() string {
+	switch a {
+	case PanicResetStream:
+		return "PanicResetStream"
+	case PanicGoAway:
+		return "PanicGoAway"
+	case PanicRethrow:
+		return "PanicRethrow"
+	}
+	return fmt.Sprintf("PanicAction(%d)", int(a))
+}
+
+// HandlerPanicInfo describes a panic in a Server's handler.
+type HandlerPanicInfo struct {
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// StreamID is the stream of the handler's request.
+	StreamID uint32
+
+	// Request is the handler's request.
+	Request *http.Request
+
+	// WroteHeader reports whether the handler wrote the
+	// response header before panicking.
+	WroteHeader bool
+
+	// Value is the value the handler panicked with, and Stack is
+	// the stack trace of the handler's goroutine.
+	Value interface{}
+	Stack []byte
+}
+
+// handlerPanicAction reports a handler's panic with value e,
+// and returns the action to take.
+func (sc *serverConn) handlerPanicAction(rw *responseWriter, req *http.Request, e interface{}) PanicAction {
+	// Same as net/http: aborted handlers and runtime.Goexit
+	// just reset the stream.
+	if e == nil || e == http.ErrAbortHandler {
+		return PanicResetStream
+	}
+	const size = 64 << 10
+	buf := make([]byte, size)
+	buf = buf[:runtime.Stack(buf, false)]
+	f := sc.srv.HandlerPanic
+	if f == nil {
+		sc.logf("http2: panic serving %v: %v\n%s", sc.conn.RemoteAddr(), e, buf)
+		return PanicResetStream
+	}
+	return f(HandlerPanicInfo{
+		RemoteAddr:  sc.remoteAddrStr,
+		StreamID:    rw.rws.stream.id,
+		Request:     req,
+		WroteHeader: rw.rws.wroteHeader,
+		Value:       e,
+		Stack:       buf,
+	})
+}
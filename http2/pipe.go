@@ -77,6 +77,39 @@ func (p *pipe) Read(d []byte) (n int, err error) {
 	}
 }
 
+// readChunk waits until data is available and removes a run of it from
+// the buffer without copying. The returned chunk holds p and must be
+// released with putDataBufferChunk when the caller is done with p.
+func (p *pipe) readChunk() (d, chunk []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.c.L == nil {
+		p.c.L = &p.mu
+	}
+	for {
+		if p.breakErr != nil {
+			return nil, nil, p.breakErr
+		}
+		if p.b != nil && p.b.Len() > 0 {
+			if db, ok := p.b.(*dataBuffer); ok {
+				return db.next()
+			}
+			chunk = getDataBufferChunk(int64(p.b.Len()))
+			n, err := p.b.Read(chunk)
+			return chunk[:n], chunk, err
+		}
+		if p.err != nil {
+			if p.readFn != nil {
+				p.readFn()     // e.g. copy trailers
+				p.readFn = nil // not sticky like p.err
+			}
+			p.b = nil
+			return nil, nil, p.err
+		}
+		p.c.Wait()
+	}
+}
+
 var (
 	errClosedPipeWrite        = errors.New("write on closed buffer")
 	errUninitializedPipeWrite = errors.New("write on uninitialized buffer")
@@ -0,0 +1,144 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialConn dials a TCP connection to addr for the Transport, through
+// the proxy chosen by Transport.Proxy for the origin authority origin,
+// if any. The proxy is chosen for the origin rather than addr, which
+// may be one of the origin's alternative services or endpoints.
+func (t *Transport) dialConn(ctx context.Context, network, origin, addr string) (net.Conn, error) {
+	if t.Proxy == nil {
+		return t.dialParallel(ctx, network, addr)
+	}
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "https", Host: origin, Path: "/"},
+		Header: make(http.Header),
+		Host:   origin,
+	}
+	proxyURL, err := t.Proxy(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return t.dialParallel(ctx, network, addr)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https":
+		return t.dialProxyConnect(ctx, network, proxyURL, addr)
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(proxyURL, proxyForwardDialer{t})
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+	return nil, fmt.Errorf("http2: unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// proxyForwardDialer dials SOCKS5 proxy servers for a Transport.
+type proxyForwardDialer struct {
+	t *Transport
+}
+
+func (d proxyForwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d proxyForwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.t.dialParallel(ctx, network, addr)
+}
+
+// dialProxyConnect dials the HTTP or HTTPS proxy at proxyURL, and
+// asks it with a CONNECT request for a tunnel to addr.
+func (t *Transport) dialProxyConnect(ctx context.Context, network string, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := authorityAddr(proxyURL.Scheme, proxyURL.Host)
+	conn, err := t.dialParallel(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		cfg := new(tls.Config)
+		if t.TLSClientConfig != nil {
+			cfg = t.TLSClientConfig.Clone()
+		}
+		// The proxy speaks HTTP/1.1 to us, whatever the tunnel carries.
+		cfg.NextProtos = nil
+		cfg.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	hdr := t.ProxyConnectHeader.Clone()
+	if hdr == nil {
+		hdr = make(http.Header)
+	}
+	if u := proxyURL.User; u != nil && hdr.Get("Proxy-Authorization") == "" {
+		password, _ := u.Password()
+		auth := u.Username() + ":" + password
+		hdr.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: hdr,
+	}
+
+	// Close the connection to abandon the CONNECT request if ctx is
+	// done before the proxy responds.
+	done := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	var res *http.Response
+	err = connectReq.Write(conn)
+	if err == nil {
+		br := bufio.NewReader(conn)
+		res, err = http.ReadResponse(br, connectReq)
+		if err == nil && br.Buffered() > 0 {
+			// The server must wait for our TLS handshake.
+			err = fmt.Errorf("http2: proxy %v sent data after CONNECT response", proxyAddr)
+		}
+	}
+	close(done)
+	<-closed
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http2: proxy %v refused CONNECT to %v: %v", proxyAddr, addr, res.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// proxyTestListener starts a proxy server on a loopback address which
+// serves each connection with serve, and returns its address.
+func proxyTestListener(t *testing.T, serve func(net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// proxyTunnel copies data between the proxy's client and target.
+func proxyTunnel(client io.ReadWriter, target net.Conn) {
+	defer target.Close()
+	go io.Copy(target, client)
+	io.Copy(client, target)
+}
+
+func TestTransportProxyConnect(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	var (
+		mu       sync.Mutex
+		connects []string
+		refuse   bool
+	)
+	proxyAddr := proxyTestListener(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		connects = append(connects, strings.Join([]string{
+			req.Method,
+			req.Host,
+			req.Header.Get("Proxy-Authorization"),
+			req.Header.Get("X-Proxy-Test"),
+		}, " "))
+		refused := refuse
+		mu.Unlock()
+		if refused {
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		target, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		proxyTunnel(c, target)
+	})
+
+	tr := &Transport{
+		TLSClientConfig:    tlsConfigInsecure,
+		Proxy:              http.ProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("user", "pass"), Host: proxyAddr}),
+		ProxyConnectHeader: http.Header{"X-Proxy-Test": {"1"}},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", "https://example.com:"+port+"/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	mu.Lock()
+	want := "CONNECT example.com:" + port + " Basic dXNlcjpwYXNz 1"
+	if len(connects) != 1 || connects[0] != want {
+		t.Errorf("proxy received %q, want %q", connects, want)
+	}
+	refuse = true
+	mu.Unlock()
+
+	req, _ = http.NewRequest("GET", "https://other.example.com:"+port+"/", nil)
+	if _, err := tr.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("RoundTrip through refusing proxy: %v, want 407 error", err)
+	}
+}
+
+func TestTransportProxyChosenForOrigin(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	var (
+		mu       sync.Mutex
+		connects []string
+	)
+	proxyAddr := proxyTestListener(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		connects = append(connects, req.Host)
+		mu.Unlock()
+		target, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		proxyTunnel(c, target)
+	})
+
+	// The origin advertises a service endpoint on another host.
+	// The proxy is chosen for the origin, and tunnels to the endpoint.
+	var proxied []string
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		LookupService: func(ctx context.Context, host string) ([]ServiceEndpoint, error) {
+			return []ServiceEndpoint{{Priority: 1, Target: "svc.example.net.", ALPN: []string{"h2"}}}, nil
+		},
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			proxied = append(proxied, req.URL.Host)
+			return &url.URL{Scheme: "http", Host: proxyAddr}, nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", "https://example.com:"+port+"/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if want := []string{"example.com:" + port}; !reflect.DeepEqual(proxied, want) {
+		t.Errorf("Proxy called for %q, want %q", proxied, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"svc.example.net:" + port}; !reflect.DeepEqual(connects, want) {
+		t.Errorf("proxy received CONNECT for %q, want %q", connects, want)
+	}
+}
+
+func TestTransportProxySOCKS5(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	targets := make(chan string, 1)
+	proxyAddr := proxyTestListener(t, func(c net.Conn) {
+		// A SOCKS5 server accepting CONNECT requests for domain
+		// names without authentication (RFC 1928).
+		br := bufio.NewReader(c)
+		var hello [2]byte
+		if _, err := io.ReadFull(br, hello[:]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(br, make([]byte, hello[1])); err != nil {
+			return
+		}
+		c.Write([]byte{5, 0})
+		var req [5]byte // VER CMD RSV ATYP len(DST.ADDR)
+		if _, err := io.ReadFull(br, req[:]); err != nil || req[3] != 3 {
+			return
+		}
+		host := make([]byte, int(req[4])+2)
+		if _, err := io.ReadFull(br, host); err != nil {
+			return
+		}
+		targets <- net.JoinHostPort(string(host[:req[4]]), strconv.Itoa(int(binary.BigEndian.Uint16(host[req[4]:]))))
+		target, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			return
+		}
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		proxyTunnel(struct {
+			io.Reader
+			io.Writer
+		}{br, c}, target)
+	})
+
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "socks5", Host: proxyAddr}),
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", "https://example.com:"+port+"/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got, want := <-targets, "example.com:"+port; got != want {
+		t.Errorf("SOCKS5 proxy connected to %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"fmt"
+	"time"
+)
+
+// An OverloadedError is returned by RoundTrip for a request which was
+// not sent because its connection had too many requests waiting for a
+// stream, or because it waited too long for one.
+// See Transport.MaxQueuedRequests and Transport.MaxQueueWait.
+//
+// The server did not receive the request, which may be retried later.
+type OverloadedError struct {
+	// Queued is the number of requests which were waiting for a stream
+	// when the request was refused. It is zero if the request timed out.
+	Queued int
+
+	// Waited is how long the request waited for a stream before it
+	// timed out. It is zero if the request was refused without waiting.
+	Waited time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	if e.Waited > 0 {
+		return fmt.Sprintf("http2: request waited %v for a stream", e.Waited)
+	}
+	return fmt.Sprintf("http2: request refused with %v requests waiting for a stream", e.Queued)
+}
+
+// Timeout reports whether the request timed out waiting for a stream.
+func (e *OverloadedError) Timeout() bool {
+	return e.Waited > 0
+}
+
+// enterQueue counts cs as waiting for a stream on its connection,
+// until leaveQueueLocked, if the Transport limits the requests waiting.
+// It returns an *OverloadedError if the queue is full.
+func (cs *clientStream) enterQueue() error {
+	cc := cs.cc
+	t := cc.t
+	if t == nil || (t.MaxQueuedRequests <= 0 && t.MaxQueueWait <= 0) {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	// Requests waiting for the connection's free streams are not
+	// queued; they only wait their turn to send their headers.
+	free := int64(cc.maxConcurrentStreams) - int64(len(cc.streams)-cc.pushStreams)
+	if free < 0 {
+		free = 0
+	}
+	if t.MaxQueuedRequests > 0 && int64(cc.queuedRequests) >= free+int64(t.MaxQueuedRequests) {
+		return &OverloadedError{Queued: cc.queuedRequests - int(free)}
+	}
+	cc.queuedRequests++
+	cs.queued = true
+	cs.queueStart = t.now()
+	if d := t.MaxQueueWait; d > 0 {
+		timeout := make(chan struct{})
+		cs.queueTimeout = timeout
+		cs.queueTimer = t.afterFunc(d, func() {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			close(timeout)
+			cc.cond.Broadcast()
+		})
+	}
+	return nil
+}
+
+// leaveQueueLocked stops counting cs as waiting for a stream.
+// Must hold cc.mu.
+func (cs *clientStream) leaveQueueLocked() {
+	if !cs.queued {
+		return
+	}
+	cs.queued = false
+	cs.cc.queuedRequests--
+	if cs.queueTimer != nil {
+		cs.queueTimer.Stop()
+		cs.queueTimer = nil
+	}
+}
+
+func (cs *clientStream) leaveQueue() {
+	if !cs.queued {
+		return
+	}
+	cs.cc.mu.Lock()
+	defer cs.cc.mu.Unlock()
+	cs.leaveQueueLocked()
+}
+
+// queueTimeoutError returns the error for a request which waited too
+// long for a stream.
+func (cs *clientStream) queueTimeoutError() error {
+	return &OverloadedError{Waited: cs.cc.t.now().Sub(cs.queueStart)}
+}
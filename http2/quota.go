@@ -0,0 +1,132 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A StreamQuota identifies a per-stream limit enforced by the Server.
+type StreamQuota int
+
+const (
+	// QuotaRequestBody limits the number of request body bytes
+	// (Server.MaxRequestBodyBytes).
+	QuotaRequestBody StreamQuota = iota
+
+	// QuotaHeaderFrames limits the number of HEADERS and CONTINUATION
+	// frames (Server.MaxHeaderFrames).
+	QuotaHeaderFrames
+
+	// QuotaTrailerBytes limits the decoded size of the request
+	// trailers (Server.MaxTrailerBytes).
+	QuotaTrailerBytes
+)
+
+var streamQuotaName = map[StreamQuota]string{
+	QuotaRequestBody:  "REQUEST_BODY",
+	QuotaHeaderFrames: "HEADER_FRAMES",
+	QuotaTrailerBytes: "TRAILER_BYTES",
+}
+
+func (q StreamQuota) String() string {
+	if s, ok := streamQuotaName[q]; ok {
+		return s
+	}
+	return fmt.Sprintf("UNKNOWN_STREAM_QUOTA_%d", int(q))
+}
+
+// StreamQuotaInfo describes a stream reset by the Server for
+// exceeding one of its per-stream quotas.
+type StreamQuotaInfo struct {
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// StreamID is the stream which was reset.
+	StreamID uint32
+
+	// Quota is the quota which was exceeded.
+	Quota StreamQuota
+
+	// Limit is the configured value of the quota.
+	Limit int64
+
+	// Code is the error code the stream was reset with.
+	Code ErrCode
+}
+
+// ErrRequestBodyTooLarge is returned when reading a request body
+// which exceeded the Server's MaxRequestBodyBytes.
+var ErrRequestBodyTooLarge = errors.New("http2: request body too large")
+
+// errRequestTrailersTooLarge is returned when reading a request body
+// whose trailers exceeded the Server's MaxTrailerBytes.
+var errRequestTrailersTooLarge = errors.New("http2: request trailers too large")
+
+// quotaErrCode is the error code a stream exceeding q is reset with.
+func quotaErrCode(q StreamQuota) ErrCode {
+	if q == QuotaRequestBody {
+		// The peer did nothing wrong, we just don't want the rest.
+		return ErrCodeCancel
+	}
+	return ErrCodeEnhanceYourCalm
+}
+
+// exceededQuota reports a stream exceeding quota q, and returns the
+// stream error to reset it with. The request body, if any, is closed
+// with bodyErr.
+func (sc *serverConn) exceededQuota(st *stream, id uint32, q StreamQuota, limit int64, bodyErr error) error {
+	sc.serveG.check()
+	code := quotaErrCode(q)
+	if st != nil && st.body != nil {
+		st.body.CloseWithError(bodyErr)
+	}
+	if fn := sc.srv.StreamQuotaExceeded; fn != nil {
+		fn(StreamQuotaInfo{
+			RemoteAddr: sc.remoteAddrStr,
+			StreamID:   id,
+			Quota:      q,
+			Limit:      limit,
+			Code:       code,
+		})
+	}
+	if VerboseLogs {
+		sc.vlogf("http2: stream %v from %v exceeded %v quota of %v", id, sc.conn.RemoteAddr(), q, limit)
+	}
+	return sc.countError("quota_"+q.countName(), streamError(id, code))
+}
+
+func (q StreamQuota) countName() string {
+	switch q {
+	case QuotaRequestBody:
+		return "body"
+	case QuotaHeaderFrames:
+		return "header_frames"
+	case QuotaTrailerBytes:
+		return "trailer_bytes"
+	}
+	return "unknown"
+}
+
+// checkHeaderFrames enforces Server.MaxHeaderFrames for the header
+// block f received on stream id. st is nil for the request headers.
+func (sc *serverConn) checkHeaderFrames(st *stream, id uint32, f *MetaHeadersFrame) error {
+	limit := sc.srv.MaxHeaderFrames
+	if limit <= 0 {
+		return nil
+	}
+	n := f.Frames
+	if st != nil {
+		n += st.headerFrames
+	}
+	if n > limit {
+		return sc.exceededQuota(st, id, QuotaHeaderFrames, int64(limit), errRequestTrailersTooLarge)
+	}
+	if st != nil {
+		st.headerFrames = n
+	}
+	return nil
+}
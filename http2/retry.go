@@ -0,0 +1,151 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	mathrand "math/rand"
+	"net/http"
+	"time"
+)
+
+// A RetryReason is why a request failed in a way the Transport may
+// retry it. See RetryPolicy.
+type RetryReason int
+
+const (
+	// RetryRefusedStream is a stream reset by the server with
+	// REFUSED_STREAM, which it sends for requests it did not process,
+	// or with PROTOCOL_ERROR before any response was received.
+	RetryRefusedStream RetryReason = iota + 1
+
+	// RetryGoAway is a request which was not sent, or was not
+	// processed by the server, because its connection received a
+	// GOAWAY frame or otherwise became unusable.
+	RetryGoAway
+
+	// RetryDial is a failure to get a connection for the request,
+	// such as a dial or TLS handshake error. These are only retried
+	// if RetryPolicy.RetryDialErrors is set.
+	RetryDial
+)
+
+func (r RetryReason) String() string {
+	switch r {
+	case RetryRefusedStream:
+		return "refused stream"
+	case RetryGoAway:
+		return "GOAWAY"
+	case RetryDial:
+		return "dial"
+	}
+	return "unknown reason"
+}
+
+// A RetryPolicy configures when and how the Transport retries requests
+// which fail before they are processed by the server. See
+// Transport.Retry.
+//
+// A request is only retried if it has no body, or its body can be
+// reset with Request.GetBody, or none of it was sent. A retry on the
+// same or a new connection replaces the failed attempt; the caller
+// sees only the last attempt's response or error.
+//
+// Without a RetryPolicy, the Transport retries a request up to seven
+// times after a refused stream or GOAWAY, waiting after the second
+// and later failures for 1s, 2s, 4s and so on, plus up to 10% jitter.
+type RetryPolicy struct {
+	// MaxAttempts limits the number of times a request is sent,
+	// including the first attempt. One disables retries.
+	// If zero, 8 is used.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the nth retry of a
+	// request, counting from 1. If nil, there is no wait before the
+	// first retry, and the default wait before later ones.
+	Backoff func(n int) time.Duration
+
+	// RetryDialErrors, if true, retries requests after failures to
+	// get a connection for them, which are returned by RoundTrip
+	// by default.
+	RetryDialErrors bool
+
+	// IdempotentOnly, if true, only retries idempotent requests:
+	// those whose method is GET, HEAD, OPTIONS, TRACE, PUT or DELETE,
+	// and those with an Idempotency-Key or X-Idempotency-Key header.
+	// Although the server should not have processed a request which
+	// is retried, proxies between the Transport and the server may
+	// not guarantee this.
+	IdempotentOnly bool
+
+	// ShouldRetry, if non-nil, is called before each retry, after the
+	// other checks of the policy pass, with the error of the failed
+	// attempt, why it may be retried, and the number of the retry,
+	// counting from 1. The request is not retried if it returns false.
+	ShouldRetry func(req *http.Request, err error, reason RetryReason, n int) bool
+}
+
+// maxAttempts returns the most times a request may be sent.
+// The policy p may be nil.
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.MaxAttempts <= 0 {
+		return 8
+	}
+	return p.MaxAttempts
+}
+
+// allows reports whether the policy p, which may be nil, allows the
+// nth retry of req after err.
+func (p *RetryPolicy) allows(req *http.Request, err error, reason RetryReason, n int) bool {
+	if n >= p.maxAttempts() {
+		return false
+	}
+	if p == nil {
+		return reason != RetryDial
+	}
+	if reason == RetryDial && !p.RetryDialErrors {
+		return false
+	}
+	if p.IdempotentOnly && !isIdempotentRequest(req) {
+		return false
+	}
+	if p.ShouldRetry != nil && !p.ShouldRetry(req, err, reason, n) {
+		return false
+	}
+	return true
+}
+
+// backoff returns how long to wait before the nth retry.
+// The policy p may be nil.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	if p != nil && p.Backoff != nil {
+		return p.Backoff(n)
+	}
+	if n <= 1 {
+		return 0
+	}
+	// Exponential backoff with 10% jitter.
+	backoff := float64(uint(1) << (uint(n) - 2))
+	backoff += backoff * (0.1 * mathrand.Float64())
+	return time.Second * time.Duration(backoff)
+}
+
+// isIdempotentRequest reports whether req is idempotent, by its method
+// or by an idempotency key header, as in net/http.
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	// The header is checked for presence rather than a value, as
+	// net/http does: a nil value marks a request idempotent without
+	// sending the header.
+	if _, ok := req.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	if _, ok := req.Header["X-Idempotency-Key"]; ok {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import "time"
+
+// An RTTSample is a measurement of a connection's round-trip time,
+// taken from a PING frame the Transport sent and the peer's
+// acknowledgement of it. See Transport.RTTSampleFunc.
+//
+// The Transport sends PINGs for ClientConn.Ping, for health checks
+// (see Transport.ReadIdleTimeout), and to tune receive windows (see
+// Transport.MaxAutoTunedReceiveBuffer).
+type RTTSample struct {
+	// Conn is the connection the PING was sent on.
+	Conn *ClientConn
+
+	// RTT is the time from sending the PING to receiving its
+	// acknowledgement.
+	RTT time.Duration
+
+	// SmoothedRTT, RTTVariation and MinRTT are the connection's
+	// estimates after this sample. See ClientConnState.
+	SmoothedRTT  time.Duration
+	RTTVariation time.Duration
+	MinRTT       time.Duration
+}
+
+// rttStats estimates a connection's round-trip time from samples,
+// in the manner of TCP's retransmission timer (RFC 6298).
+type rttStats struct {
+	smoothed  time.Duration // smoothed round-trip time, or zero
+	variation time.Duration // mean deviation of the samples
+	min       time.Duration // smallest sample
+	samples   int
+}
+
+// add records a sample.
+func (s *rttStats) add(rtt time.Duration) {
+	if rtt < 0 {
+		rtt = 0
+	}
+	s.samples++
+	if s.samples == 1 {
+		s.smoothed = rtt
+		s.variation = rtt / 2
+		s.min = rtt
+		return
+	}
+	if rtt < s.min {
+		s.min = rtt
+	}
+	dev := s.smoothed - rtt
+	if dev < 0 {
+		dev = -dev
+	}
+	s.variation = (3*s.variation + dev) / 4
+	s.smoothed = (7*s.smoothed + rtt) / 8
+}
+
+// recordRTTLocked adds an RTT sample to cc's estimates, and returns
+// the event to report to Transport.RTTSampleFunc, or nil.
+// cc.mu must be held.
+func (cc *ClientConn) recordRTTLocked(rtt time.Duration) *RTTSample {
+	cc.rtt.add(rtt)
+	if cc.t.RTTSampleFunc == nil {
+		return nil
+	}
+	return &RTTSample{
+		Conn:         cc,
+		RTT:          rtt,
+		SmoothedRTT:  cc.rtt.smoothed,
+		RTTVariation: cc.rtt.variation,
+		MinRTT:       cc.rtt.min,
+	}
+}
+
+// reportRTT calls Transport.RTTSampleFunc with the sample ev, if
+// non-nil. cc.mu must not be held.
+func (cc *ClientConn) reportRTT(ev *RTTSample) {
+	if ev != nil {
+		cc.t.RTTSampleFunc(*ev)
+	}
+}
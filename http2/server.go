@@ -32,6 +32,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"math"
@@ -45,6 +46,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http/httpguts"
@@ -82,6 +84,28 @@ var (
 	testHookOnPanic       func(sc *serverConn, panicVal interface{}) (rePanic bool)
 )
 
+// HeaderListTooLargeInfo describes a request header list rejected
+// by the Server for exceeding its maximum header list size.
+type HeaderListTooLargeInfo struct {
+	// RemoteAddr is the network address of the client.
+	RemoteAddr string
+
+	// StreamID is the stream the headers were received on.
+	StreamID uint32
+
+	// Fields are the header fields decoded before the limit
+	// was reached. The caller owns the slice.
+	Fields []hpack.HeaderField
+
+	// Size is the size of the decoded header list, as defined in
+	// RFC 7541, Section 4.1. It includes the field which exceeded
+	// the limit, but not any fields following it.
+	Size uint64
+
+	// MaxSize is the limit which was exceeded.
+	MaxSize uint32
+}
+
 // Server is an HTTP/2 server.
 type Server struct {
 	// MaxHandlers limits the number of http.Handler ServeHTTP goroutines
@@ -144,12 +168,135 @@ type Server struct {
 	// If nil, a default scheduler is chosen.
 	NewWriteScheduler func() WriteScheduler
 
+	// WriteQuantum optionally limits how many bytes of a handler's
+	// response body are queued with the write scheduler at once.
+	// Larger writes are split into chunks of at most WriteQuantum
+	// bytes, and each chunk is written before the next is queued,
+	// so a single large response cannot monopolize the connection
+	// ahead of small, latency-sensitive responses on other streams.
+	// If zero or negative, handler writes are queued whole.
+	WriteQuantum int
+
+	// ContentDigest, if non-nil, adds a Content-Digest trailer to
+	// response bodies and checks the Content-Digest trailers of
+	// request bodies. See ContentDigest.
+	ContentDigest *ContentDigest
+
+	// ContentLengthPolicy determines how the server handles a request
+	// body longer than its declared Content-Length.
+	// The default is ContentLengthReset.
+	ContentLengthPolicy ContentLengthPolicy
+
+	// StrictAuthority enables the request target checks of RFC 9113,
+	// Section 8.3.1. If true, requests whose :authority includes
+	// userinfo, whose Host header differs from :authority, or whose
+	// :path is not in origin form (or "*" for OPTIONS) are treated as
+	// malformed and reset with a PROTOCOL_ERROR. By default, the
+	// :authority takes precedence over a differing Host header.
+	StrictAuthority bool
+
+	// FlowControl, if non-nil, decides when the server sends
+	// WINDOW_UPDATE frames for request body data consumed by handlers.
+	// If nil, NewThresholdFlowControlPolicy(4 << 10) is used.
+	FlowControl FlowControlPolicy
+
+	// TrailersOnly controls how a response whose handler declares
+	// trailers but returns without writing a body or flushing is sent.
+	// By default, such a response is sent as a HEADERS frame followed
+	// by a second HEADERS frame carrying the trailers. If TrailersOnly
+	// is true, the trailers are instead merged into the response
+	// headers and sent in a single HEADERS frame which ends the stream,
+	// as in the "Trailers-Only" responses used by gRPC.
+	TrailersOnly bool
+
+	// RequestContext, if non-nil, returns the context passed to the
+	// handler for a request. The provided ctx is derived from the
+	// connection's base context and is canceled when the stream ends;
+	// RequestContext must return a context derived from it.
+	// It may be used to apply a per-request deadline carried in a
+	// request header, such as gRPC's "grpc-timeout".
+	// It is called from the connection's serve goroutine, and
+	// should not block.
+	RequestContext func(ctx context.Context, r *http.Request) context.Context
+
+	// ConnControl, if non-nil, is called by ServeConn with the raw
+	// network connection underlying each connection before serving it,
+	// unwrapping a *tls.Conn if necessary. It may be used to set
+	// platform-specific socket options such as TCP_NODELAY, SO_SNDBUF,
+	// SO_RCVBUF, or TCP_USER_TIMEOUT on Linux. It is not called for
+	// connections which do not implement syscall.Conn.
+	// If ConnControl returns an error, the connection is closed.
+	ConnControl func(c syscall.RawConn) error
+
 	// CountError, if non-nil, is called on HTTP/2 server errors.
 	// It's intended to increment a metric for monitoring, such
 	// as an expvar or Prometheus metric.
 	// The errType consists of only ASCII word characters.
 	CountError func(errType string)
 
+	// HeaderListTooLarge, if non-nil, is called when a request's
+	// header list exceeds the limit advertised in the server's
+	// SETTINGS_MAX_HEADER_LIST_SIZE (derived from
+	// http.Server.MaxHeaderBytes). The request is still rejected
+	// with a 431 (Request Header Fields Too Large) response.
+	//
+	// It is intended to help operators identify clients sending
+	// pathological headers, such as oversized cookies.
+	// It is called from the connection's serve goroutine, and
+	// should not block.
+	HeaderListTooLarge func(HeaderListTooLargeInfo)
+
+	// MaxRequestBodyBytes, if positive, limits the size of each
+	// request body. A stream sending more is reset with CANCEL, and
+	// the handler's reads of the body fail with ErrRequestBodyTooLarge.
+	MaxRequestBodyBytes int64
+
+	// MaxHeaderFrames, if positive, limits the total number of HEADERS
+	// and CONTINUATION frames a client may send on each stream,
+	// counting both the request headers and any trailers. A stream
+	// sending more is reset with ENHANCE_YOUR_CALM.
+	MaxHeaderFrames int
+
+	// MaxTrailerBytes, if positive, limits the size of each request's
+	// trailers, as defined in RFC 7541, Section 4.1. A stream sending
+	// larger trailers is reset with ENHANCE_YOUR_CALM, and the
+	// handler's reads of the body fail.
+	MaxTrailerBytes int64
+
+	// StreamQuotaExceeded, if non-nil, is called when a stream is reset
+	// for exceeding MaxRequestBodyBytes, MaxHeaderFrames or
+	// MaxTrailerBytes.
+	// It is called from the connection's serve goroutine, and
+	// should not block.
+	StreamQuotaExceeded func(StreamQuotaInfo)
+
+	// ExtraSettings holds settings for protocol extensions to send in
+	// the server's initial SETTINGS frame on each connection, after
+	// the settings the server sends itself. Settings with identifiers
+	// defined by RFC 9113, and SettingEnableConnectProtocol, are
+	// ignored. Handlers can send further extension settings, and read
+	// those sent by the client, through the ExtensionSettings
+	// interface of their ResponseWriter.
+	ExtraSettings []Setting
+
+	// Origins, if non-empty, are the origins, such as
+	// "https://example.com", which the server sends in an ORIGIN frame
+	// (RFC 8336) after its initial SETTINGS frame on TLS connections.
+	// Clients supporting ORIGIN frames send requests for these origins
+	// on the connection, if the server's certificate is valid for them,
+	// and no longer send it requests for other origins.
+	Origins []string
+
+	// HandlerPanic, if non-nil, is called when a handler panics,
+	// and returns how the Server responds. It is responsible for
+	// logging the panic. It is not called for handlers which panic
+	// with http.ErrAbortHandler, which just reset their stream.
+	// It is called from the handler's goroutine.
+	//
+	// If HandlerPanic is nil, the Server logs the panic and
+	// responds with PanicResetStream.
+	HandlerPanic func(HandlerPanicInfo) PanicAction
+
 	// Internal state. This is a pointer (rather than embedded directly)
 	// so that we don't embed a Mutex in this struct, which will make the
 	// struct non-copyable, which might break some callers.
@@ -467,6 +614,14 @@ func (s *Server) serveConn(c net.Conn, opts *ServeConnOpts, newf func(*serverCon
 		newf(sc)
 	}
 
+	if s.ConnControl != nil {
+		if err := controlConn(c, s.ConnControl); err != nil {
+			sc.logf("http2: server: ConnControl for %v: %v", sc.remoteAddrStr, err)
+			c.Close()
+			return
+		}
+	}
+
 	s.state.registerConn(sc)
 	defer s.state.unregisterConn(sc)
 
@@ -490,7 +645,8 @@ func (s *Server) serveConn(c net.Conn, opts *ServeConnOpts, newf func(*serverCon
 	// WINDOW_UPDATE shortly after sending SETTINGS.
 	sc.flow.add(initialWindowSize)
 	sc.inflow.init(initialWindowSize)
-	sc.hpackEncoder = hpack.NewEncoder(&sc.headerWriteBuf)
+	sc.inflow.policy = s.FlowControl
+	sc.hpackEncoder = newHeaderEncoder(&sc.headerWriteBuf)
 	sc.hpackEncoder.SetMaxDynamicTableSizeLimit(s.maxEncoderHeaderTableSize())
 
 	fr := NewFramer(sc.bw, c)
@@ -644,10 +800,16 @@ type serverConn struct {
 
 	// Owned by the writeFrameAsync goroutine:
 	headerWriteBuf bytes.Buffer
-	hpackEncoder   *hpack.Encoder
+	hpackEncoder   *headerEncoder
 
 	// Used by startGracefulShutdown.
 	shutdownOnce sync.Once
+
+	// Extension settings received from the client, read by handlers.
+	peerExtMu       sync.Mutex
+	peerExtSettings map[SettingID]uint32
+
+	hpackStats hpackStats
 }
 
 func (sc *serverConn) maxHeaderListSize() uint32 {
@@ -691,6 +853,8 @@ type stream struct {
 	state            streamState
 	resetQueued      bool  // RST_STREAM queued for write; set by sc.resetStream
 	gotTrailerHeader bool  // HEADER frame for trailers was seen
+	truncatedBody    bool  // body was truncated to declBodyBytes
+	headerFrames     int   // HEADERS and CONTINUATION frames seen
 	wroteHeaders     bool  // whether we wrote headers (not status 100)
 	readDeadline     timer // nil if unused
 	writeDeadline    timer // nil if unused
@@ -698,12 +862,13 @@ type stream struct {
 
 	trailer    http.Header // accumulated trailers
 	reqTrailer http.Header // handler's Request.Trailer
+	digest     hash.Hash   // digest of the request body, if Server.ContentDigest is set
 }
 
 func (sc *serverConn) Framer() *Framer  { return sc.framer }
 func (sc *serverConn) CloseConn() error { return sc.conn.Close() }
 func (sc *serverConn) Flush() error     { return sc.bw.Flush() }
-func (sc *serverConn) HeaderEncoder() (*hpack.Encoder, *bytes.Buffer) {
+func (sc *serverConn) HeaderEncoder() (*headerEncoder, *bytes.Buffer) {
 	return sc.hpackEncoder, &sc.headerWriteBuf
 }
 
@@ -935,17 +1100,25 @@ func (sc *serverConn) serve() {
 		sc.vlogf("http2: server connection from %v on %p", sc.conn.RemoteAddr(), sc.hs)
 	}
 
+	settings := writeSettings{
+		{SettingMaxFrameSize, sc.srv.maxReadFrameSize()},
+		{SettingMaxConcurrentStreams, sc.advMaxStreams},
+		{SettingMaxHeaderListSize, sc.maxHeaderListSize()},
+		{SettingHeaderTableSize, sc.srv.maxDecoderHeaderTableSize()},
+		{SettingInitialWindowSize, uint32(sc.srv.initialStreamRecvWindowSize())},
+	}
+	settings = append(settings, sc.srv.extraSettings()...)
 	sc.writeFrame(FrameWriteRequest{
-		write: writeSettings{
-			{SettingMaxFrameSize, sc.srv.maxReadFrameSize()},
-			{SettingMaxConcurrentStreams, sc.advMaxStreams},
-			{SettingMaxHeaderListSize, sc.maxHeaderListSize()},
-			{SettingHeaderTableSize, sc.srv.maxDecoderHeaderTableSize()},
-			{SettingInitialWindowSize, uint32(sc.srv.initialStreamRecvWindowSize())},
-		},
+		write: settings,
 	})
 	sc.unackedSettings++
 
+	if len(sc.srv.Origins) > 0 && sc.tlsState != nil {
+		sc.writeFrame(FrameWriteRequest{
+			write: writeOrigin(sc.srv.Origins),
+		})
+	}
+
 	// Each connection starts with initialWindowSize inflow tokens.
 	// If a higher value is configured, we add more tokens.
 	if diff := sc.srv.initialConnRecvWindowSize() - initialWindowSize; diff > 0 {
@@ -1321,6 +1494,10 @@ func (sc *serverConn) wroteFrame(res frameWriteResult) {
 	sc.writingFrameAsync = false
 
 	wr := res.wr
+	switch wr.write.(type) {
+	case *writeResHeaders, *writePushPromise, write100ContinueHeadersFrame:
+		sc.hpackStats.encoded(sc.hpackEncoder)
+	}
 
 	if writeEndsStream(wr.write) {
 		st := wr.stream
@@ -1757,13 +1934,15 @@ func (sc *serverConn) processSetting(s Setting) error {
 		sc.maxFrameSize = int32(s.Val) // the maximum valid s.Val is < 2^31
 	case SettingMaxHeaderListSize:
 		sc.peerMaxHeaderListSize = s.Val
+	case SettingEnableConnectProtocol:
+		// Only meaningful when sent by a server (RFC 8441, Section 3).
 	default:
 		// Unknown setting: "An endpoint that receives a SETTINGS
 		// frame with any unknown or unsupported identifier MUST
 		// ignore that setting."
-		if VerboseLogs {
-			sc.vlogf("http2: server ignoring unknown setting %v", s)
-		}
+		// It is kept for handlers which implement extensions.
+		// See ExtensionSettings.
+		sc.notePeerExtensionSetting(s)
 	}
 	return nil
 }
@@ -1846,17 +2025,27 @@ func (sc *serverConn) processData(f *DataFrame) error {
 
 	// Sender sending more than they'd declared?
 	if st.declBodyBytes != -1 && st.bodyBytes+int64(len(data)) > st.declBodyBytes {
+		if sc.srv.ContentLengthPolicy == ContentLengthTruncate {
+			return sc.truncateBody(st, f)
+		}
 		if !sc.inflow.take(f.Length) {
 			return sc.countError("data_flow", streamError(id, ErrCodeFlowControl))
 		}
 		sc.sendWindowUpdate(nil, int(f.Length)) // conn-level
 
-		st.body.CloseWithError(fmt.Errorf("sender tried to send more than declared Content-Length of %d bytes", st.declBodyBytes))
+		st.body.CloseWithError(&ContentLengthError{StreamID: id, Declared: st.declBodyBytes})
 		// RFC 7540, sec 8.1.2.6: A request or response is also malformed if the
 		// value of a content-length header field does not equal the sum of the
 		// DATA frame payload lengths that form the body.
 		return sc.countError("send_too_much", streamError(id, ErrCodeProtocol))
 	}
+	if limit := sc.srv.MaxRequestBodyBytes; limit > 0 && st.bodyBytes+int64(len(data)) > limit {
+		if !sc.inflow.take(f.Length) {
+			return sc.countError("data_flow", streamError(id, ErrCodeFlowControl))
+		}
+		sc.sendWindowUpdate(nil, int(f.Length)) // conn-level
+		return sc.exceededQuota(st, id, QuotaRequestBody, limit, ErrRequestBodyTooLarge)
+	}
 	if f.Length > 0 {
 		// Check whether the client has flow control quota.
 		if !takeInflows(&sc.inflow, &st.inflow, f.Length) {
@@ -1876,6 +2065,12 @@ func (sc *serverConn) processData(f *DataFrame) error {
 			if wrote != len(data) {
 				panic("internal error: bad Writer")
 			}
+			if d := sc.srv.ContentDigest; d != nil {
+				if st.digest == nil {
+					st.digest = d.New()
+				}
+				st.digest.Write(data)
+			}
 		}
 
 		// Return any padded flow control now, since we won't
@@ -1918,6 +2113,12 @@ func (st *stream) endStream() {
 	sc := st.sc
 	sc.serveG.check()
 
+	if st.truncatedBody {
+		// The body was already closed.
+		st.state = stateHalfClosedRemote
+		return
+	}
+
 	if st.declBodyBytes != -1 && st.declBodyBytes != st.bodyBytes {
 		st.body.CloseWithError(fmt.Errorf("request declared a Content-Length of %d but only wrote %d bytes",
 			st.declBodyBytes, st.bodyBytes))
@@ -1961,6 +2162,7 @@ func (st *stream) onWriteTimeout() {
 
 func (sc *serverConn) processHeaders(f *MetaHeadersFrame) error {
 	sc.serveG.check()
+	sc.hpackStats.decoded(sc.framer)
 	id := f.StreamID
 	// http://tools.ietf.org/html/rfc7540#section-5.1.1
 	// Streams initiated by a client MUST use odd-numbered stream
@@ -1987,6 +2189,9 @@ func (sc *serverConn) processHeaders(f *MetaHeadersFrame) error {
 		if st.state == stateHalfClosedRemote {
 			return sc.countError("headers_half_closed", streamError(id, ErrCodeStreamClosed))
 		}
+		if err := sc.checkHeaderFrames(st, id, f); err != nil {
+			return err
+		}
 		return st.processTrailerHeaders(f)
 	}
 
@@ -2023,11 +2228,16 @@ func (sc *serverConn) processHeaders(f *MetaHeadersFrame) error {
 		return sc.countError("over_max_streams_race", streamError(id, ErrCodeRefusedStream))
 	}
 
+	if err := sc.checkHeaderFrames(nil, id, f); err != nil {
+		return err
+	}
+
 	initialState := stateOpen
 	if f.StreamEnded() {
 		initialState = stateHalfClosedRemote
 	}
 	st := sc.newStream(id, 0, initialState)
+	st.headerFrames = f.Frames
 
 	if f.HasPriority() {
 		if err := sc.checkPriority(f.StreamID, f.Priority); err != nil {
@@ -2051,6 +2261,15 @@ func (sc *serverConn) processHeaders(f *MetaHeadersFrame) error {
 	if f.Truncated {
 		// Their header list was too long. Send a 431 error.
 		handler = handleHeaderListTooLong
+		if fn := sc.srv.HeaderListTooLarge; fn != nil {
+			fn(HeaderListTooLargeInfo{
+				RemoteAddr: sc.remoteAddrStr,
+				StreamID:   id,
+				Fields:     append([]hpack.HeaderField(nil), f.Fields...),
+				Size:       f.DecodedSize,
+				MaxSize:    sc.framer.maxHeaderListSize(),
+			})
+		}
 	} else if err := checkValidHTTP2RequestHeaders(req.Header); err != nil {
 		handler = new400Handler(err)
 	}
@@ -2108,6 +2327,9 @@ func (st *stream) processTrailerHeaders(f *MetaHeadersFrame) error {
 	if len(f.PseudoFields()) > 0 {
 		return sc.countError("trailers_pseudo", streamError(st.id, ErrCodeProtocol))
 	}
+	if limit := sc.srv.MaxTrailerBytes; limit > 0 && (f.Truncated || f.DecodedSize > uint64(limit)) {
+		return sc.exceededQuota(st, st.id, QuotaTrailerBytes, limit, errRequestTrailersTooLarge)
+	}
 	if st.trailer != nil {
 		for _, hf := range f.RegularFields() {
 			key := sc.canonicalHeader(hf.Name)
@@ -2120,6 +2342,22 @@ func (st *stream) processTrailerHeaders(f *MetaHeadersFrame) error {
 			st.trailer[key] = append(st.trailer[key], hf.Value)
 		}
 	}
+	if d := sc.srv.ContentDigest; d != nil {
+		var vv []string
+		for _, hf := range f.RegularFields() {
+			if sc.canonicalHeader(hf.Name) == "Content-Digest" {
+				vv = append(vv, hf.Value)
+			}
+		}
+		h := st.digest
+		if h == nil {
+			h = d.New() // empty body
+		}
+		if err := d.verify(vv, h); err != nil {
+			st.body.CloseWithError(err)
+			return sc.countError("digest_mismatch", streamError(st.id, ErrCodeProtocol))
+		}
+	}
 	st.endStream()
 	return nil
 }
@@ -2161,6 +2399,7 @@ func (sc *serverConn) newStream(id, pusherID uint32, state streamState) *stream
 	st.flow.conn = &sc.flow // link to conn-level counter
 	st.flow.add(sc.initialStreamSendWindowSize)
 	st.inflow.init(sc.srv.initialStreamRecvWindowSize())
+	st.inflow.policy = sc.srv.FlowControl
 	if sc.hs.WriteTimeout > 0 {
 		st.writeDeadline = sc.srv.afterFunc(sc.hs.WriteTimeout, st.onWriteTimeout)
 	}
@@ -2212,6 +2451,16 @@ func (sc *serverConn) newWriterAndRequest(st *stream, f *MetaHeadersFrame) (*res
 	for _, hf := range f.RegularFields() {
 		rp.header.Add(sc.canonicalHeader(hf.Name), hf.Value)
 	}
+	if sc.srv.StrictAuthority {
+		authority := rp.authority
+		if authority == "" {
+			authority = rp.header.Get("Host")
+		}
+		if err := checkAuthority(rp.method, authority, rp.header.Get("Host"), rp.path); err != nil {
+			sc.vlogf("http2: server rejecting request from %v: %v", sc.remoteAddrStr, err)
+			return nil, nil, sc.countError("bad_authority", streamError(f.StreamID, ErrCodeProtocol))
+		}
+	}
 	if rp.authority == "" {
 		rp.authority = rp.header.Get("Host")
 	}
@@ -2314,6 +2563,13 @@ func (sc *serverConn) newWriterAndRequestNoBody(st *stream, rp requestParam) (*r
 		Trailer:    trailer,
 	}
 	req = req.WithContext(st.ctx)
+	if sc.srv.RequestContext != nil {
+		ctx := sc.srv.RequestContext(st.ctx, req)
+		if ctx == nil {
+			panic("http2: RequestContext returned nil")
+		}
+		req = req.WithContext(ctx)
+	}
 
 	rw := sc.newResponseWriter(st, req)
 	return rw, req, nil
@@ -2396,16 +2652,22 @@ func (sc *serverConn) runHandler(rw *responseWriter, req *http.Request, handler
 		}
 		if didPanic {
 			e := recover()
-			sc.writeFrameFromHandler(FrameWriteRequest{
+			action := sc.handlerPanicAction(rw, req, e)
+			rst := FrameWriteRequest{
 				write:  handlerPanicRST{rw.rws.stream.id},
 				stream: rw.rws.stream,
-			})
-			// Same as net/http:
-			if e != nil && e != http.ErrAbortHandler {
-				const size = 64 << 10
-				buf := make([]byte, size)
-				buf = buf[:runtime.Stack(buf, false)]
-				sc.logf("http2: panic serving %v: %v\n%s", sc.conn.RemoteAddr(), e, buf)
+			}
+			switch action {
+			case PanicGoAway:
+				sc.writeFrameFromHandler(rst)
+				sc.sendServeMsg(func(sc *serverConn) { sc.goAway(ErrCodeInternal) })
+			case PanicRethrow:
+				// The panic is about to crash the program, so make
+				// sure the peer sees the reset first.
+				sc.writeAndFlushFromHandler(rst)
+				handlerPanicRethrow(e)
+			default:
+				sc.writeFrameFromHandler(rst)
 			}
 			return
 		}
@@ -2415,6 +2677,43 @@ func (sc *serverConn) runHandler(rw *responseWriter, req *http.Request, handler
 	didPanic = false
 }
 
+// handlerPanicRethrow rethrows a handler panic for PanicRethrow.
+// It is a variable for testing.
+var handlerPanicRethrow = func(e interface{}) { panic(e) }
+
+// writeAndFlushFromHandler writes wr and waits until it, and any frames
+// queued ahead of it, have been flushed to the connection. It gives up
+// if the connection stops serving.
+//
+// called from handler goroutines.
+func (sc *serverConn) writeAndFlushFromHandler(wr FrameWriteRequest) {
+	sc.serveG.checkNotOn() // NOT on
+	errc := make(chan error, 1)
+	wr.done = errc
+	if sc.writeFrameFromHandler(wr) != nil {
+		return
+	}
+	// Writes for a stream which has already been closed are dropped
+	// without a reply, so also watch for the stream closing.
+	select {
+	case <-errc:
+	case <-wr.stream.cw:
+	case <-sc.doneServing:
+		return
+	}
+	errc = make(chan error, 1)
+	if sc.writeFrameFromHandler(FrameWriteRequest{
+		write: flushFrameWriter{},
+		done:  errc,
+	}) != nil {
+		return
+	}
+	select {
+	case <-errc:
+	case <-sc.doneServing:
+	}
+}
+
 func handleHeaderListTooLong(w http.ResponseWriter, r *http.Request) {
 	// 10.5.1 Limits on Header Block Size:
 	// .. "A server that receives a larger header block than it is
@@ -2595,10 +2894,15 @@ type responseWriterState struct {
 	wroteHeader   bool        // WriteHeader called (explicitly or implicitly). Not necessarily sent to user yet.
 	sentHeader    bool        // have we sent the header frame?
 	handlerDone   bool        // handler has finished
+	digest        hash.Hash   // digest of the response body, if Server.ContentDigest is set
+	digestDone    bool        // digest is complete
 
 	sentContentLen int64 // non-zero if handler set a Content-Length header
 	wroteBytes     int64
 
+	flushMode FlushMode    // set by SetFlushPolicy
+	held      bytes.Buffer // data written under FlushOnClose, not yet flushed
+
 	closeNotifierMu sync.Mutex // guards closeNotifierCh
 	closeNotifierCh chan bool  // nil until first used
 }
@@ -2652,11 +2956,27 @@ func (rws *responseWriterState) writeChunk(p []byte) (n int, err error) {
 		rws.writeHeader(200)
 	}
 
+	isHeadResp := rws.req.Method == "HEAD"
+	if d := rws.conn.srv.ContentDigest; d != nil && !isHeadResp && !rws.digestDone {
+		if rws.digest == nil {
+			rws.digest = d.New()
+		}
+		rws.digest.Write(p)
+		if rws.handlerDone {
+			rws.digestDone = true
+			if bodyAllowedForStatus(rws.status) && !rws.handlerSetDigest() {
+				if rws.handlerHeader == nil {
+					rws.handlerHeader = make(http.Header)
+				}
+				rws.handlerHeader[TrailerPrefix+"Content-Digest"] = []string{d.field(rws.digest)}
+			}
+		}
+	}
+
 	if rws.handlerDone {
 		rws.promoteUndeclaredTrailers()
 	}
 
-	isHeadResp := rws.req.Method == "HEAD"
 	if !rws.sentHeader {
 		rws.sentHeader = true
 		var ctype, clen string
@@ -2690,6 +3010,17 @@ func (rws *responseWriterState) writeChunk(p []byte) (n int, err error) {
 			foreachHeaderElement(v, rws.declareTrailer)
 		}
 
+		trailersOnly := rws.conn.srv.TrailersOnly && rws.handlerDone && len(p) == 0 && !isHeadResp && rws.hasNonemptyTrailers()
+		if trailersOnly {
+			for _, k := range rws.trailers {
+				if vv, ok := rws.handlerHeader[k]; ok {
+					rws.snapHeader[k] = vv
+				}
+			}
+			delete(rws.snapHeader, "Trailer")
+			rws.trailers = nil
+		}
+
 		// "Connection" headers aren't allowed in HTTP/2 (RFC 7540, 8.1.2.2),
 		// but respect "Connection" == "close" to mean sending a GOAWAY and tearing
 		// down the TCP connection when idle, like we do for HTTP/1.
@@ -2733,7 +3064,7 @@ func (rws *responseWriterState) writeChunk(p []byte) (n int, err error) {
 	endStream := rws.handlerDone && !hasNonemptyTrailers
 	if len(p) > 0 || endStream {
 		// only send a 0 byte DATA frame if we're ending the stream.
-		if err := rws.conn.writeDataFromHandler(rws.stream, p, endStream); err != nil {
+		if err := rws.writeData(p, endStream); err != nil {
 			return 0, err
 		}
 	}
@@ -2750,6 +3081,30 @@ func (rws *responseWriterState) writeChunk(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// handlerSetDigest reports whether the handler provided its own
+// Content-Digest, as a header or trailer.
+func (rws *responseWriterState) handlerSetDigest() bool {
+	if _, ok := rws.handlerHeader["Content-Digest"]; ok {
+		return true
+	}
+	_, ok := rws.handlerHeader[TrailerPrefix+"Content-Digest"]
+	return ok
+}
+
+// writeData writes p to the stream in chunks of at most the
+// server's WriteQuantum, waiting for each chunk to be written
+// before queuing the next.
+func (rws *responseWriterState) writeData(p []byte, endStream bool) error {
+	quantum := rws.conn.srv.WriteQuantum
+	for quantum > 0 && len(p) > quantum {
+		if err := rws.conn.writeDataFromHandler(rws.stream, p[:quantum], false); err != nil {
+			return err
+		}
+		p = p[quantum:]
+	}
+	return rws.conn.writeDataFromHandler(rws.stream, p, endStream)
+}
+
 // TrailerPrefix is a magic prefix for ResponseWriter.Header map keys
 // that, if present, signals that the map entry is actually for
 // the response trailers, and not the response headers. The prefix
@@ -2865,7 +3220,13 @@ func (w *responseWriter) FlushError() error {
 		panic("Header called after Handler finished")
 	}
 	var err error
-	if rws.bw.Buffered() > 0 {
+	if rws.held.Len() > 0 {
+		// Data is only held under FlushOnClose, when rws.bw is empty.
+		// Write it in one chunk, so that a response flushed by
+		// handlerDone has a Content-Length.
+		_, err = chunkWriter{rws}.Write(rws.held.Bytes())
+		rws.held.Reset()
+	} else if rws.bw.Buffered() > 0 {
 		err = rws.bw.Flush()
 	} else {
 		// The bufio.Writer won't call chunkWriter.Write
@@ -3021,6 +3382,23 @@ func (w *responseWriter) write(lenData int, dataB []byte, dataS string) (n int,
 		return 0, errors.New("http2: handler wrote more than declared Content-Length")
 	}
 
+	switch rws.flushMode {
+	case FlushOnClose:
+		if dataB != nil {
+			return rws.held.Write(dataB)
+		}
+		return rws.held.WriteString(dataS)
+	case FlushEachWrite:
+		if dataB != nil {
+			n, err = rws.bw.Write(dataB)
+		} else {
+			n, err = rws.bw.WriteString(dataS)
+		}
+		if err == nil {
+			err = rws.bw.Flush()
+		}
+		return n, err
+	}
 	if dataB != nil {
 		return rws.bw.Write(dataB)
 	} else {
@@ -3033,6 +3411,9 @@ func (w *responseWriter) handlerDone() {
 	rws.handlerDone = true
 	w.Flush()
 	w.rws = nil
+	if rws.bw.Size() != handlerChunkWriteSize {
+		rws.bw = bufio.NewWriterSize(chunkWriter{rws}, handlerChunkWriteSize)
+	}
 	responseWriterStatePool.Put(rws)
 }
 
@@ -114,6 +114,8 @@ func newTestServer(t testing.TB, handler http.HandlerFunc, opts ...interface{})
 			v(ts.Config)
 		case func(*Server):
 			v(h2server)
+		case *chaosPolicy:
+			ts.Config.Handler = v.wrap(ts.Config.Handler)
 		default:
 			t.Fatalf("unknown newTestServer option type %T", v)
 		}
@@ -1963,6 +1965,36 @@ func TestServer_Response_Header_Flush_MidWrite(t *testing.T) {
 	})
 }
 
+func TestServer_Response_WriteQuantum(t *testing.T) {
+	const size = 5000
+	const quantum = 1000
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), size))
+	}, func(s *Server) {
+		s.WriteQuantum = quantum
+	})
+	defer st.Close()
+	st.greet()
+
+	getSlash(st)
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: false,
+	})
+	for i := 0; i < size/quantum; i++ {
+		st.wantData(wantData{
+			streamID:  1,
+			endStream: false,
+			size:      quantum,
+		})
+	}
+	st.wantData(wantData{
+		streamID:  1,
+		endStream: true,
+		size:      0,
+	})
+}
+
 func TestServer_Response_LargeWrite(t *testing.T) {
 	const size = 1 << 20
 	const maxFrameSize = 16 << 10
@@ -2600,6 +2632,65 @@ func TestServerDoS_MaxHeaderListSize(t *testing.T) {
 	})
 }
 
+func TestServerHeaderListTooLargeCallback(t *testing.T) {
+	var got []HeaderListTooLargeInfo
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for request with oversized header list")
+	}, func(s *http.Server) {
+		s.MaxHeaderBytes = 1 << 10
+	}, func(s *Server) {
+		s.HeaderListTooLarge = func(info HeaderListTooLargeInfo) {
+			got = append(got, info)
+		}
+	})
+	defer st.Close()
+	st.greet()
+
+	cookie := strings.Repeat("c", 1300)
+	st.writeHeaders(HeadersFrameParam{
+		StreamID: 1,
+		BlockFragment: st.encodeHeader(
+			"x-before", "value",
+			"cookie", cookie,
+			"x-after", "value",
+		),
+		EndStream:  true,
+		EndHeaders: true,
+	})
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: false,
+		header: http.Header{
+			":status": []string{"431"},
+		},
+	})
+
+	if len(got) != 1 {
+		t.Fatalf("HeaderListTooLarge called %v times, want 1", len(got))
+	}
+	info := got[0]
+	if info.StreamID != 1 {
+		t.Errorf("StreamID = %v, want 1", info.StreamID)
+	}
+	if want := st.sc.maxHeaderListSize(); info.MaxSize != want {
+		t.Errorf("MaxSize = %v, want %v", info.MaxSize, want)
+	}
+	var fieldSize uint64
+	for _, hf := range info.Fields {
+		if hf.Name == "cookie" || hf.Name == "x-after" {
+			t.Errorf("Fields contains %q, which follows the limit", hf.Name)
+		}
+		fieldSize += uint64(hf.Size())
+	}
+	cookieSize := uint64(hpack.HeaderField{Name: "cookie", Value: cookie}.Size())
+	if want := fieldSize + cookieSize; info.Size != want {
+		t.Errorf("Size = %v, want %v", info.Size, want)
+	}
+	if info.Size <= uint64(info.MaxSize) {
+		t.Errorf("Size = %v, want more than MaxSize %v", info.Size, info.MaxSize)
+	}
+}
+
 func TestServer_Response_Stream_With_Missing_Trailer(t *testing.T) {
 	testServerResponse(t, func(w http.ResponseWriter, r *http.Request) error {
 		w.Header().Set("Trailer", "test-trailer")
@@ -4656,3 +4747,640 @@ func TestServerRequestCancelOnError(t *testing.T) {
 	})
 	<-donec
 }
+
+func TestServer_Response_TrailersOnly(t *testing.T) {
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "5")
+	}, func(s *Server) {
+		s.TrailersOnly = true
+	})
+	defer st.Close()
+	st.greet()
+	getSlash(st)
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+		header: http.Header{
+			":status":      []string{"200"},
+			"content-type": []string{"application/grpc"},
+			"grpc-status":  []string{"5"},
+			"trailer":      nil,
+		},
+	})
+}
+
+func TestServerRequestContext(t *testing.T) {
+	type ctxKey struct{}
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Context().Value(ctxKey{}); got != "/path" {
+			t.Errorf("request context value = %v; want %q", got, "/path")
+		}
+		w.Header().Set("X-Seen", "1")
+	}, func(s *Server) {
+		s.RequestContext = func(ctx context.Context, r *http.Request) context.Context {
+			return context.WithValue(ctx, ctxKey{}, r.URL.Path)
+		}
+	})
+	defer st.Close()
+	st.greet()
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeader(":path", "/path"),
+		EndStream:     true,
+		EndHeaders:    true,
+	})
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+		header: http.Header{
+			":status": []string{"200"},
+			"x-seen":  []string{"1"},
+		},
+	})
+}
+
+func TestServerStrictAuthority(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		strict  bool
+		headers []string
+		wantRST bool
+	}{{
+		name:    "host mismatch relaxed",
+		headers: []string{":authority", "foo.com", "host", "bar.com"},
+	}, {
+		name:    "host mismatch strict",
+		strict:  true,
+		headers: []string{":authority", "foo.com", "host", "bar.com"},
+		wantRST: true,
+	}, {
+		name:    "host match strict",
+		strict:  true,
+		headers: []string{":authority", "foo.com", "host", "FOO.com"},
+	}, {
+		name:    "userinfo strict",
+		strict:  true,
+		headers: []string{":authority", "user@foo.com"},
+		wantRST: true,
+	}, {
+		name:    "asterisk GET strict",
+		strict:  true,
+		headers: []string{":path", "*"},
+		wantRST: true,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+			}, func(s *Server) {
+				s.StrictAuthority = test.strict
+			})
+			defer st.Close()
+			st.greet()
+			st.writeHeaders(HeadersFrameParam{
+				StreamID:      1,
+				BlockFragment: st.encodeHeader(test.headers...),
+				EndStream:     true,
+				EndHeaders:    true,
+			})
+			if test.wantRST {
+				st.wantRSTStream(1, ErrCodeProtocol)
+			} else {
+				st.wantHeaders(wantHeader{
+					streamID:  1,
+					endStream: true,
+				})
+			}
+		})
+	}
+}
+
+func TestServerExtensionSettings(t *testing.T) {
+	const (
+		settingServerExt SettingID = 0xf000
+		settingClientExt SettingID = 0xf001
+		settingLaterExt  SettingID = 0xf002
+	)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		es, ok := w.(ExtensionSettings)
+		if !ok {
+			t.Errorf("ResponseWriter %T does not implement ExtensionSettings", w)
+			return
+		}
+		if v, ok := es.PeerSetting(settingClientExt); v != 3 || !ok {
+			t.Errorf("PeerSetting(%v) = %v, %v; want 3, true", settingClientExt, v, ok)
+		}
+		if v, ok := es.PeerSetting(SettingMaxFrameSize); ok {
+			t.Errorf("PeerSetting(%v) = %v, %v; want not ok", SettingMaxFrameSize, v, ok)
+		}
+		if err := es.SendSettings(Setting{SettingMaxFrameSize, 1 << 20}); err == nil {
+			t.Errorf("SendSettings(%v) succeeded, want error", SettingMaxFrameSize)
+		}
+		if v, ok := es.PeerSetting(SettingEnableConnectProtocol); ok {
+			t.Errorf("PeerSetting(%v) = %v, %v; want not ok", SettingEnableConnectProtocol, v, ok)
+		}
+		if err := es.SendSettings(Setting{SettingEnableConnectProtocol, 1}); err == nil {
+			t.Errorf("SendSettings(%v) succeeded, want error", SettingEnableConnectProtocol)
+		}
+		if err := es.SendSettings(Setting{settingLaterExt, 9}); err != nil {
+			t.Errorf("SendSettings: %v", err)
+		}
+	}, func(s *Server) {
+		s.ExtraSettings = []Setting{
+			{settingServerExt, 7},
+			{SettingMaxFrameSize, 1 << 20}, // ignored
+		}
+	})
+	defer st.Close()
+
+	got := map[SettingID]uint32{}
+	st.greetAndCheckSettings(func(s Setting) error {
+		got[s.ID] = s.Val
+		return nil
+	})
+	if v := got[settingServerExt]; v != 7 {
+		t.Errorf("initial SETTINGS: %v = %v, want 7", settingServerExt, v)
+	}
+	if v := got[SettingMaxFrameSize]; v != defaultMaxReadFrameSize {
+		t.Errorf("initial SETTINGS: %v = %v, want %v", SettingMaxFrameSize, v, defaultMaxReadFrameSize)
+	}
+
+	st.writeSettings(Setting{settingClientExt, 3}, Setting{SettingEnableConnectProtocol, 1})
+	st.wantSettingsAck()
+	st.bodylessReq1()
+	sf := readFrame[*SettingsFrame](t, st)
+	if v, ok := sf.Value(settingLaterExt); sf.IsAck() || v != 9 || !ok {
+		t.Errorf("got SETTINGS %v, want %v = 9", sf, settingLaterExt)
+	}
+	st.writeSettingsAck()
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+}
+
+func TestServerStreamQuotaRequestBody(t *testing.T) {
+	var got []StreamQuotaInfo
+	errc := make(chan error, 1)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		errc <- err
+	}, func(s *Server) {
+		s.MaxRequestBodyBytes = 8
+		s.StreamQuotaExceeded = func(info StreamQuotaInfo) {
+			got = append(got, info)
+		}
+	})
+	defer st.Close()
+	st.greet()
+
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeader(":method", "POST"),
+		EndStream:     false,
+		EndHeaders:    true,
+	})
+	st.writeData(1, false, []byte("12345"))
+	st.writeData(1, false, []byte("6789"))
+	st.wantRSTStream(1, ErrCodeCancel)
+	if err := <-errc; err != ErrRequestBodyTooLarge {
+		t.Errorf("reading request body: %v, want ErrRequestBodyTooLarge", err)
+	}
+	want := []StreamQuotaInfo{{
+		RemoteAddr: st.sc.remoteAddrStr,
+		StreamID:   1,
+		Quota:      QuotaRequestBody,
+		Limit:      8,
+		Code:       ErrCodeCancel,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamQuotaExceeded calls:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestServerStreamQuotaHeaderFrames(t *testing.T) {
+	var got []StreamQuotaInfo
+	calls := make(chan struct{}, 2)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+	}, func(s *Server) {
+		s.MaxHeaderFrames = 2
+		s.StreamQuotaExceeded = func(info StreamQuotaInfo) {
+			got = append(got, info)
+		}
+	})
+	defer st.Close()
+	st.greet()
+
+	hbf := st.encodeHeader()
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: hbf[:1],
+		EndStream:     true,
+		EndHeaders:    false,
+	})
+	st.writeContinuation(1, false, hbf[1:2])
+	st.writeContinuation(1, true, hbf[2:])
+	st.wantRSTStream(1, ErrCodeEnhanceYourCalm)
+	if len(got) != 1 || got[0].Quota != QuotaHeaderFrames || got[0].Limit != 2 {
+		t.Errorf("StreamQuotaExceeded calls: %+v, want one for %v", got, QuotaHeaderFrames)
+	}
+
+	// The connection is still usable.
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      3,
+		BlockFragment: st.encodeHeader(),
+		EndStream:     true,
+		EndHeaders:    true,
+	})
+	st.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	if n := len(calls); n != 1 {
+		t.Errorf("handler called %v times, want 1", n)
+	}
+}
+
+func TestServerStreamQuotaTrailerBytes(t *testing.T) {
+	var got []StreamQuotaInfo
+	errc := make(chan error, 1)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		errc <- err
+	}, func(s *Server) {
+		s.MaxTrailerBytes = 100
+		s.StreamQuotaExceeded = func(info StreamQuotaInfo) {
+			got = append(got, info)
+		}
+	})
+	defer st.Close()
+	st.greet()
+
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeader(":method", "POST", "trailer", "x-big"),
+		EndStream:     false,
+		EndHeaders:    true,
+	})
+	st.writeData(1, false, []byte("body"))
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeaderRaw("x-big", strings.Repeat("x", 100)),
+		EndStream:     true,
+		EndHeaders:    true,
+	})
+	st.wantRSTStream(1, ErrCodeEnhanceYourCalm)
+	if err := <-errc; err == nil {
+		t.Errorf("reading request body with oversized trailers: got nil error")
+	}
+	if len(got) != 1 || got[0].Quota != QuotaTrailerBytes || got[0].Limit != 100 {
+		t.Errorf("StreamQuotaExceeded calls: %+v, want one for %v", got, QuotaTrailerBytes)
+	}
+}
+
+func TestServerHeaderCompressionStats(t *testing.T) {
+	defer func(v bool) { debugHPACKTables = v }(debugHPACKTables)
+	debugHPACKTables = true
+
+	statsc := make(chan HeaderCompressionStats, 2)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		statsc <- w.(HeaderCompressionReporter).HeaderCompression()
+		w.Header().Set("X-Response", strings.Repeat("v", 100))
+	})
+	defer st.Close()
+	st.greet()
+
+	for id := uint32(1); id <= 3; id += 2 {
+		st.writeHeaders(HeadersFrameParam{
+			StreamID:      id,
+			BlockFragment: st.encodeHeader("x-request", "value"),
+			EndStream:     true,
+			EndHeaders:    true,
+		})
+		st.wantHeaders(wantHeader{
+			streamID:  id,
+			endStream: true,
+		})
+	}
+
+	// The first handler runs before any response headers are sent.
+	s := <-statsc
+	if s.SentFieldBytes != 0 || s.EncoderTable != nil {
+		t.Errorf("before first response: SentFieldBytes = %v, EncoderTable = %v; want none", s.SentFieldBytes, s.EncoderTable)
+	}
+	wantRecv := hpack.HeaderField{Name: "x-request", Value: "value"}
+	if s.ReceivedBlockBytes <= 0 || !containsHeaderField(s.DecoderTable, wantRecv) {
+		t.Errorf("ReceivedBlockBytes = %v, DecoderTable = %v; want blocks > 0 and table containing %v", s.ReceivedBlockBytes, s.DecoderTable, wantRecv)
+	}
+
+	s = <-statsc
+	if s.SentBlockBytes <= 0 || s.SentBlockBytes >= s.SentFieldBytes {
+		t.Errorf("sent %v bytes of header blocks for %v bytes of fields, want 0 < blocks < fields", s.SentBlockBytes, s.SentFieldBytes)
+	}
+	wantSent := hpack.HeaderField{Name: "x-response", Value: strings.Repeat("v", 100)}
+	if !containsHeaderField(s.EncoderTable, wantSent) {
+		t.Errorf("EncoderTable = %v, want it to contain %v", s.EncoderTable, wantSent)
+	}
+}
+
+func TestServerRequestBodyLongerThanContentLengthTruncate(t *testing.T) {
+	bodyc := make(chan string, 1)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		bodyc <- string(b)
+	}, func(s *Server) {
+		s.ContentLengthPolicy = ContentLengthTruncate
+	}, optQuiet)
+	defer st.Close()
+	st.greet()
+
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeader(":method", "POST", "content-length", "8"),
+		EndStream:     false,
+		EndHeaders:    true,
+	})
+	st.writeData(1, false, []byte("12345"))
+	st.writeData(1, false, []byte("67890"))
+	if got, want := <-bodyc, "12345678"; got != want {
+		t.Errorf("handler read body %q, want %q", got, want)
+	}
+
+	// The handler's response is sent. The handler finished before
+	// the client ended the stream, so the server then asks the
+	// client to stop sending.
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	st.wantRSTStream(1, ErrCodeNo)
+	st.wantFlowControlConsumed(0, 0)
+}
+
+func TestServerRequestBodyLongerThanContentLengthError(t *testing.T) {
+	errc := make(chan error, 1)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		errc <- err
+	})
+	defer st.Close()
+	st.greet()
+
+	st.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		BlockFragment: st.encodeHeader(":method", "POST", "content-length", "4"),
+		EndStream:     false,
+		EndHeaders:    true,
+	})
+	st.writeData(1, true, []byte("12345"))
+	st.wantRSTStream(1, ErrCodeProtocol)
+	var cle *ContentLengthError
+	if err := <-errc; !errors.As(err, &cle) || cle.Declared != 4 {
+		t.Errorf("reading body: %v, want ContentLengthError with Declared = 4", err)
+	}
+}
+
+func TestServerFlushPolicy(t *testing.T) {
+	for _, test := range []struct {
+		mode FlushMode
+		size int
+		// wantSent is the number of bytes sent after each of three
+		// 1000-byte writes, before the handler returns.
+		wantSent []int
+		wantClen string
+	}{{
+		mode:     FlushWhenFull,
+		wantSent: []int{0, 0, 0},
+		wantClen: "3000", // fits in the default buffer
+	}, {
+		mode:     FlushWhenFull,
+		size:     1500,
+		wantSent: []int{0, 1500, 1500},
+	}, {
+		mode:     FlushEachWrite,
+		wantSent: []int{1000, 2000, 3000},
+	}, {
+		mode:     FlushOnClose,
+		wantSent: []int{0, 0, 0},
+		wantClen: "3000",
+	}} {
+		t.Run(fmt.Sprintf("%v/%v", test.mode, test.size), func(t *testing.T) {
+			proceedc := make(chan struct{})
+			st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+				if err := w.(FlushPolicySetter).SetFlushPolicy(test.mode, test.size); err != nil {
+					t.Errorf("SetFlushPolicy: %v", err)
+				}
+				for range test.wantSent {
+					w.Write(make([]byte, 1000))
+					<-proceedc
+				}
+			})
+			defer st.Close()
+			st.greet()
+			st.bodylessReq1()
+
+			sent := 0
+			for i, want := range test.wantSent {
+				st.sync()
+				for fr := st.readFrame(); fr != nil; fr = st.readFrame() {
+					if df, ok := fr.(*DataFrame); ok {
+						sent += len(df.Data())
+					}
+				}
+				if sent != want {
+					t.Fatalf("after write %v: sent %v bytes, want %v", i+1, sent, want)
+				}
+				proceedc <- struct{}{}
+			}
+			st.sync()
+			for {
+				fr := st.readFrame()
+				if fr == nil {
+					t.Fatalf("stream not ended after handler returned")
+				}
+				if hf, ok := fr.(*HeadersFrame); ok {
+					var clen string
+					for _, kv := range st.decodeHeader(hf.HeaderBlockFragment()) {
+						if kv[0] == "content-length" {
+							clen = kv[1]
+						}
+					}
+					if clen != test.wantClen {
+						t.Errorf("content-length = %q, want %q", clen, test.wantClen)
+					}
+				}
+				if df, ok := fr.(*DataFrame); ok {
+					sent += len(df.Data())
+					if df.StreamEnded() {
+						break
+					}
+				}
+			}
+			if sent != 3000 {
+				t.Errorf("sent %v bytes, want 3000", sent)
+			}
+		})
+	}
+}
+
+func TestServerFlushPolicyInvalid(t *testing.T) {
+	errc := make(chan error, 2)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		errc <- w.(FlushPolicySetter).SetFlushPolicy(FlushMode(-1), 0)
+		errc <- w.(FlushPolicySetter).SetFlushPolicy(FlushWhenFull, -1)
+	})
+	defer st.Close()
+	st.greet()
+	st.bodylessReq1()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err == nil {
+			t.Errorf("SetFlushPolicy with invalid policy succeeded, want error")
+		}
+	}
+}
+
+func TestServerEventWriter(t *testing.T) {
+	const heartbeat = 15 * time.Second
+	sendc := make(chan Event)
+	errc := make(chan error)
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		ew, err := NewEventWriter(w, r, heartbeat)
+		if err != nil {
+			t.Errorf("NewEventWriter: %v", err)
+			return
+		}
+		defer ew.Close()
+		for {
+			select {
+			case ev := <-sendc:
+				errc <- ew.Send(ev)
+			case <-ew.Done():
+				errc <- ew.Send(Event{Data: "after reset"})
+				return
+			}
+		}
+	})
+	defer st.Close()
+	st.greet()
+	st.bodylessReq1()
+	st.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: false,
+		header: http.Header{
+			":status":        []string{"200"},
+			"content-type":   []string{"text/event-stream"},
+			"cache-control":  []string{"no-cache"},
+			"content-length": nil,
+		},
+	})
+
+	sendc <- Event{ID: "1", Type: "update", Data: "line one\nline two", Retry: 3 * time.Second}
+	if err := <-errc; err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	st.wantData(wantData{
+		streamID: 1,
+		data:     []byte("id: 1\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n"),
+	})
+
+	sendc <- Event{Type: "bad\ntype"}
+	if err := <-errc; err == nil {
+		t.Errorf("Send with newline in event type succeeded, want error")
+	}
+
+	st.advance(heartbeat - 1)
+	if fr := st.readFrame(); fr != nil {
+		t.Fatalf("got %v before heartbeat interval, want nothing", fr)
+	}
+	st.advance(1)
+	st.wantData(wantData{
+		streamID: 1,
+		data:     []byte(":\n"),
+	})
+
+	st.writeRSTStream(1, ErrCodeCancel)
+	if err := <-errc; err == nil {
+		t.Errorf("Send after stream reset succeeded, want error")
+	}
+}
+
+func TestServerHandlerPanic(t *testing.T) {
+	for _, action := range []PanicAction{PanicResetStream, PanicGoAway} {
+		t.Run(action.String(), func(t *testing.T) {
+			infoc := make(chan HandlerPanicInfo, 1)
+			st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+				panic("boom")
+			}, func(s *Server) {
+				s.HandlerPanic = func(info HandlerPanicInfo) PanicAction {
+					infoc <- info
+					return action
+				}
+			})
+			st.greet()
+			st.bodylessReq1()
+			st.sync()
+
+			info := <-infoc
+			if info.Value != "boom" || info.StreamID != 1 || !info.WroteHeader ||
+				info.Request.URL.Path != "/" || len(info.Stack) == 0 {
+				t.Errorf("HandlerPanic called with %+v", info)
+			}
+			st.wantRSTStream(1, ErrCodeInternal)
+			if action == PanicGoAway {
+				st.wantGoAway(1, ErrCodeInternal)
+			} else {
+				st.wantIdle()
+			}
+		})
+	}
+}
+
+func TestServerHandlerPanicRethrow(t *testing.T) {
+	// The RST_STREAM must reach the connection before the panic is rethrown.
+	rethrown := make(chan bool, 1)
+	var st *serverTester
+	defer func(f func(interface{})) { handlerPanicRethrow = f }(handlerPanicRethrow)
+	handlerPanicRethrow = func(e interface{}) {
+		if e != "boom" {
+			t.Errorf("rethrown panic value = %v, want boom", e)
+		}
+		rethrown <- len(st.cc.(*synctestNetConn).loc.peek()) > 0
+	}
+	st = newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}, func(s *Server) {
+		s.HandlerPanic = func(info HandlerPanicInfo) PanicAction {
+			return PanicRethrow
+		}
+	})
+	st.greet()
+	st.bodylessReq1()
+	st.sync()
+
+	if flushed := <-rethrown; !flushed {
+		t.Errorf("panic rethrown before RST_STREAM was flushed")
+	}
+	st.wantRSTStream(1, ErrCodeInternal)
+	st.wantIdle()
+}
+
+func TestServerHandlerPanicAbortHandler(t *testing.T) {
+	// Handlers aborted with http.ErrAbortHandler don't call HandlerPanic.
+	st := newServerTester(t, func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}, func(s *Server) {
+		s.HandlerPanic = func(info HandlerPanicInfo) PanicAction {
+			t.Errorf("HandlerPanic called for http.ErrAbortHandler")
+			return PanicGoAway
+		}
+	})
+	st.greet()
+	st.bodylessReq1()
+	st.wantRSTStream(1, ErrCodeInternal)
+	st.wantIdle()
+}
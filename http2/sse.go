@@ -0,0 +1,204 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Event is a Server-Sent Event, as defined by the HTML Living
+// Standard, Section 9.2.
+type Event struct {
+	// ID, if non-empty, sets the client's last event ID.
+	ID string
+
+	// Type is the event type. If empty, the client dispatches
+	// a "message" event.
+	Type string
+
+	// Data is the event data. It may contain newlines.
+	Data string
+
+	// Retry, if positive, sets the client's reconnection delay.
+	Retry time.Duration
+}
+
+var (
+	errEventWriterClosed = errors.New("http2: EventWriter closed")
+	errInvalidEventField = errors.New("http2: event ID or type contains a line break or NUL")
+)
+
+// An EventWriter writes a Server-Sent Events stream as the response
+// to a request. Each event is flushed to the client as it is sent,
+// and the stream ends when the client resets it or the handler returns.
+//
+// An EventWriter may be used from multiple goroutines. Close must be
+// called before the handler returns.
+type EventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	done    <-chan struct{}
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	err       error // sticky write error, or errEventWriterClosed
+	heartbeat time.Duration
+	hbTimer   timer // or nil
+}
+
+// NewEventWriter starts a Server-Sent Events response to r, with
+// Content-Type text/event-stream, and sends the response headers.
+// w must implement http.Flusher.
+//
+// If heartbeat is positive, the EventWriter sends a comment whenever
+// heartbeat passes without an event, so that idle streams are not
+// closed by intermediaries.
+func NewEventWriter(w http.ResponseWriter, r *http.Request, heartbeat time.Duration) (*EventWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("http2: ResponseWriter does not support flushing")
+	}
+	ew := &EventWriter{
+		w:         w,
+		flusher:   flusher,
+		done:      r.Context().Done(),
+		heartbeat: heartbeat,
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if heartbeat > 0 {
+		afterFunc := func(d time.Duration, f func()) timer {
+			return timeTimer{time.AfterFunc(d, f)}
+		}
+		if rw, ok := w.(*responseWriter); ok {
+			afterFunc = rw.rws.conn.srv.afterFunc
+		}
+		ew.hbTimer = afterFunc(heartbeat, ew.sendHeartbeat)
+	}
+	return ew, nil
+}
+
+// Done returns a channel which is closed when the client goes away,
+// either by resetting the stream or closing the connection.
+// Sends fail after this point.
+func (ew *EventWriter) Done() <-chan struct{} {
+	return ew.done
+}
+
+// Send sends an event.
+func (ew *EventWriter) Send(ev Event) error {
+	if !validEventField(ev.ID) || !validEventField(ev.Type) {
+		return errInvalidEventField
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	b := &ew.buf
+	b.Reset()
+	if ev.ID != "" {
+		b.WriteString("id: ")
+		b.WriteString(ev.ID)
+		b.WriteByte('\n')
+	}
+	if ev.Type != "" {
+		b.WriteString("event: ")
+		b.WriteString(ev.Type)
+		b.WriteByte('\n')
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.FormatInt(ev.Retry.Milliseconds(), 10))
+		b.WriteByte('\n')
+	}
+	data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return ew.writeLocked(b.Bytes())
+}
+
+// Comment sends a comment, which the client ignores.
+// Each line of text is sent as a separate comment line.
+func (ew *EventWriter) Comment(text string) error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	b := &ew.buf
+	b.Reset()
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(": ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return ew.writeLocked(b.Bytes())
+}
+
+// Close stops the EventWriter's heartbeat. Subsequent sends fail.
+// It does not end the response, which ends when the handler returns.
+func (ew *EventWriter) Close() {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.hbTimer != nil {
+		ew.hbTimer.Stop()
+	}
+	if ew.err == nil {
+		ew.err = errEventWriterClosed
+	}
+}
+
+func (ew *EventWriter) sendHeartbeat() {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.err != nil {
+		return
+	}
+	select {
+	case <-ew.done:
+		return
+	default:
+	}
+	ew.writeLocked([]byte(":\n"))
+}
+
+// writeLocked writes and flushes p, and postpones the next heartbeat.
+func (ew *EventWriter) writeLocked(p []byte) error {
+	if ew.err != nil {
+		return ew.err
+	}
+	if _, err := ew.w.Write(p); err != nil {
+		ew.err = err
+		return err
+	}
+	if rw, ok := ew.w.(*responseWriter); ok {
+		// Report a stream reset, which Flush does not.
+		if err := rw.FlushError(); err != nil {
+			ew.err = err
+			return err
+		}
+	} else {
+		ew.flusher.Flush()
+	}
+	if ew.hbTimer != nil {
+		ew.hbTimer.Reset(ew.heartbeat)
+	}
+	return nil
+}
+
+func validEventField(s string) bool {
+	return !strings.ContainsAny(s, "\r\n\x00")
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// streamLimiter is a FIFO semaphore limiting the number of streams
+// open across all of a Transport's connections. See Transport.MaxTotalStreams.
+type streamLimiter struct {
+	mu      sync.Mutex
+	active  int
+	waiters []chan struct{} // closed when granted a stream, in arrival order
+}
+
+// errStreamAborted is returned by acquire when abort is closed.
+var errStreamAborted = errors.New("http2: stream aborted")
+
+// acquire waits for one of max streams to be available, and takes it.
+// Streams are granted in the order they are requested.
+// It gives up when ctx is done, or reqCancel or abort is closed.
+func (l *streamLimiter) acquire(ctx context.Context, reqCancel, abort <-chan struct{}, max int) error {
+	l.mu.Lock()
+	if l.active < max && len(l.waiters) == 0 {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-reqCancel:
+		err = errRequestCanceled
+	case <-abort:
+		err = errStreamAborted
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, c := range l.waiters {
+		if c == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return err
+		}
+	}
+	// We were granted a stream while giving up. Pass it on.
+	l.releaseLocked()
+	return err
+}
+
+// release returns a stream taken by acquire, granting it to the
+// longest waiter, if any.
+func (l *streamLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *streamLimiter) releaseLocked() {
+	if len(l.waiters) > 0 {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		return
+	}
+	l.active--
+}
+
+// acquireTotalStream waits until the stream cs may be opened without
+// exceeding Transport.MaxTotalStreams.
+func (cs *clientStream) acquireTotalStream() error {
+	t := cs.cc.t
+	if t == nil || t.MaxTotalStreams <= 0 {
+		return nil
+	}
+	if err := t.totalStreams.acquire(cs.ctx, cs.reqCancel, cs.abort, t.MaxTotalStreams); err != nil {
+		if err == errStreamAborted {
+			return cs.abortErr
+		}
+		return err
+	}
+	cs.heldTotalStream = true
+	return nil
+}
+
+// releaseTotalStream returns the stream taken by acquireTotalStream, if any.
+func (cs *clientStream) releaseTotalStream() {
+	if cs.heldTotalStream {
+		cs.heldTotalStream = false
+		cs.cc.t.totalStreams.release()
+	}
+}
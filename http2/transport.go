@@ -15,22 +15,24 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"log"
 	"math"
 	"math/bits"
-	mathrand "math/rand"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/textproto"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http/httpguts"
@@ -70,6 +72,11 @@ type Transport struct {
 	//
 	// If DialTLSContext and DialTLS is nil, tls.Dial is used.
 	//
+	// The ctx is the context of the request which caused the dial, and
+	// carries its values. Other requests for the same address may wait
+	// for the dial; each stops waiting when its own context is done,
+	// and redials if the dial was canceled with the first request.
+	//
 	// If the returned net.Conn has a ConnectionState method like tls.Conn,
 	// it will be used to set http.Response.TLS.
 	DialTLSContext func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error)
@@ -84,10 +91,49 @@ type Transport struct {
 	// If both are set, DialTLSContext takes priority.
 	DialTLS func(network, addr string, cfg *tls.Config) (net.Conn, error)
 
+	// DialClientConn, if non-nil, is called to create new connections
+	// for the Transport's connection pool, instead of dialing TLS
+	// connections with DialTLSContext or DialTLS. The addr is of the
+	// form "host:port".
+	//
+	// The returned ClientConn must have been created by this
+	// Transport's NewClientConn. This lets callers such as service
+	// meshes establish connections over their own tunnels.
+	DialClientConn func(ctx context.Context, addr string) (*ClientConn, error)
+
 	// TLSClientConfig specifies the TLS configuration to use with
 	// tls.Client. If nil, the default configuration is used.
 	TLSClientConfig *tls.Config
 
+	// ResumeTLSSessions, if true, makes the Transport supply its own
+	// TLS session cache for the connections it dials when
+	// TLSClientConfig does not set ClientSessionCache, so new
+	// connections to a host can resume an earlier TLS session with
+	// an abbreviated handshake.
+	ResumeTLSSessions bool
+
+	// LookupService, if non-nil, is called before dialing a new
+	// connection to find the service endpoints a host advertises in
+	// DNS HTTPS records, as described in RFC 9460. The function is
+	// responsible for querying DNS and following any AliasMode records.
+	//
+	// The Transport dials the highest-priority endpoint which supports
+	// HTTP/2 ("h2" in its ALPN list), using the endpoint's target name
+	// and port. The TLS server name remains the original host.
+	// If no ServiceMode endpoints are returned, or LookupService returns
+	// an error, the host is dialed directly. If endpoints are returned but none
+	// supports HTTP/2, the dial fails with an error.
+	LookupService func(ctx context.Context, host string) ([]ServiceEndpoint, error)
+
+	// AltSvc optionally stores the alternative services (RFC 7838)
+	// advertised in Alt-Svc response headers and ALTSVC frames for
+	// https origins. When dialing a new connection to an origin with
+	// a fresh "h2" alternative, the Transport dials the alternative,
+	// falling back to the origin if that fails. The alternative must
+	// present a certificate valid for the origin.
+	// If nil, advertised alternatives are ignored.
+	AltSvc AltSvcCache
+
 	// ConnPool optionally specifies an alternate connection pool to use.
 	// If nil, the default is used.
 	ConnPool ClientConnPool
@@ -106,6 +152,17 @@ type Transport struct {
 	// plain-text "http" scheme. Note that this does not enable h2c support.
 	AllowHTTP bool
 
+	// PreserveHeaderCase, if true, sends request header and trailer
+	// field names with the casing of their keys in Request.Header
+	// and Request.Trailer, rather than in lowercase.
+	// Keys set with http.Header.Set are in canonical form; assign to
+	// the map directly to use other casing.
+	//
+	// HTTP/2 requires lowercase field names, and compliant servers
+	// reject requests with other names as malformed. This option is
+	// for legacy peers which match field names case-sensitively.
+	PreserveHeaderCase bool
+
 	// MaxHeaderListSize is the http2 SETTINGS_MAX_HEADER_LIST_SIZE to
 	// send in the initial settings frame. It is how many bytes
 	// of response headers are allowed. Unlike the http2 spec, zero here
@@ -129,14 +186,49 @@ type Transport struct {
 	// informs the remote endpoint of the maximum size of the header compression
 	// table used to decode header blocks, in octets. If zero, the default value
 	// of 4096 is used.
+	//
+	// Larger tables let the server compress repetitive response headers
+	// better, at the cost of memory held for each connection; smaller
+	// tables bound that memory for constrained clients.
 	MaxDecoderHeaderTableSize uint32
 
 	// MaxEncoderHeaderTableSize optionally specifies an upper limit for the
 	// header compression table used for encoding request headers. Received
 	// SETTINGS_HEADER_TABLE_SIZE settings are capped at this limit. If zero,
 	// the default value of 4096 is used.
+	//
+	// Clients sending many similar request headers on each connection,
+	// such as API gateways, may compress them better with a larger
+	// limit, if the server advertises a larger table. A limit below the
+	// default shrinks the table from the first request.
 	MaxEncoderHeaderTableSize uint32
 
+	// MaxReceiveBufferPerConnection is the size of the initial flow
+	// control window for each connection, bounding how many bytes of
+	// response bodies the server may send before the Transport's
+	// callers read them. The HTTP/2 spec does not allow this to be
+	// smaller than 65535. If the value is zero or smaller than 65535,
+	// a default of 1GB is used.
+	MaxReceiveBufferPerConnection int32
+
+	// MaxReceiveBufferPerStream is the http2 SETTINGS_INITIAL_WINDOW_SIZE
+	// to send in the initial settings frame. It is the size of the
+	// initial flow control window for each stream's response body.
+	// If the value is zero or negative, a default of 4MB is used.
+	MaxReceiveBufferPerStream int32
+
+	// MaxAutoTunedReceiveBuffer, if larger than the initial stream
+	// window, enables receive window auto-tuning: the Transport
+	// estimates each connection's bandwidth-delay product from the
+	// response data received during a PING round trip, and grows the
+	// stream flow control windows, and the connection's window if it
+	// is smaller, up to MaxAutoTunedReceiveBuffer bytes. This keeps
+	// flow control from throttling downloads on links with a high
+	// latency. Windows start at MaxReceiveBufferPerStream and
+	// MaxReceiveBufferPerConnection, and never shrink.
+	// If zero, windows have a fixed size.
+	MaxAutoTunedReceiveBuffer int32
+
 	// StrictMaxConcurrentStreams controls whether the server's
 	// SETTINGS_MAX_CONCURRENT_STREAMS should be respected
 	// globally. If false, new TCP connections are created to the
@@ -145,32 +237,286 @@ type Transport struct {
 	// server's SETTINGS_MAX_CONCURRENT_STREAMS is interpreted as
 	// a global limit and callers of RoundTrip block when needed,
 	// waiting for their turn.
+	//
+	// If MaxConnsPerHost is greater than 1, the server's limit
+	// is instead interpreted as a limit for each of up to
+	// MaxConnsPerHost connections: new connections are created
+	// as needed until the address has MaxConnsPerHost of them,
+	// and only then do callers of RoundTrip block.
 	StrictMaxConcurrentStreams bool
 
+	// MaxBufferedRequestBodySize, if positive, is the largest request
+	// body of unknown length which RoundTrip reads before sending the
+	// request, so that it can send the body's Content-Length. Some
+	// servers reject requests with bodies of unknown length. Longer
+	// bodies are sent as they are read, without a Content-Length.
+	// A buffered body is also sent again if the request is retried.
+	//
+	// Bodies are not buffered for requests with an
+	// "Expect: 100-continue" header or for extended CONNECT requests.
+	// Since a body is read before the request is sent, a body which
+	// does not end until the response is read, as in a full-duplex
+	// exchange, must declare its length, or the request never starts.
+	//
+	// Regardless of this setting, a request body which is longer or
+	// shorter than its declared Request.ContentLength is an error,
+	// and the request stream is reset.
+	MaxBufferedRequestBodySize int64
+
+	// MaxQueuedRequests, if positive, limits the number of requests
+	// which may wait for a stream on a connection whose streams are
+	// all in use, as with StrictMaxConcurrentStreams. RoundTrip
+	// returns an *OverloadedError for requests beyond the limit,
+	// rather than letting load spikes queue without bound.
+	MaxQueuedRequests int
+
+	// MaxQueueWait, if positive, is the longest a request may wait
+	// for a stream on a connection whose streams are all in use.
+	// RoundTrip returns an *OverloadedError for requests which wait
+	// longer.
+	MaxQueueWait time.Duration
+
 	// IdleConnTimeout is the maximum amount of time an idle
 	// (keep-alive) connection will remain idle before closing
 	// itself.
 	// Zero means no limit.
 	IdleConnTimeout time.Duration
 
+	// MaxConnLifetime, if positive, is the maximum amount of time a
+	// connection may be used for new requests after it is created.
+	// Once a connection reaches this age it takes no new requests, and
+	// it is closed when its active requests finish. Limiting the
+	// lifetime of connections lets long-lived clients spread their
+	// requests over the backends of a load balancer, rather than
+	// staying on the same backend for as long as the connection lasts.
+	// If ReplaceOnGoAway is set, a replacement connection is dialed as
+	// soon as a connection with active requests reaches its lifetime.
+	// Zero means no limit.
+	MaxConnLifetime time.Duration
+
 	// ReadIdleTimeout is the timeout after which a health check using ping
 	// frame will be carried out if no frame is received on the connection.
-	// Note that a ping response will is considered a received frame, so if
+	// Note that a ping response is considered a received frame, so if
 	// there is no other traffic on the connection, the health check will
-	// be performed every ReadIdleTimeout interval.
+	// be performed every ReadIdleTimeout interval. Idle connections in
+	// the pool are checked too, so dead connections are removed before
+	// a request is sent on them.
 	// If zero, no health check is performed.
 	ReadIdleTimeout time.Duration
 
 	// PingTimeout is the timeout after which the connection will be closed
-	// if a response to Ping is not received.
+	// if a response to a health check's ping is not received.
+	// Requests in progress on the connection fail.
 	// Defaults to 15s.
 	PingTimeout time.Duration
 
 	// WriteByteTimeout is the timeout after which the connection will be
-	// closed no data can be written to it. The timeout begins when data is
+	// closed if no data can be written to it. The timeout begins when data is
 	// available to write, and is extended whenever any bytes are written.
+	// A peer which stops reading causes the connection's requests to fail
+	// with an error wrapping os.ErrDeadlineExceeded, rather than blocking
+	// indefinitely, and the connection is not reused.
+	// If zero, there is no timeout.
 	WriteByteTimeout time.Duration
 
+	// StrictAuthority enables the request target checks of RFC 9113,
+	// Section 8.3.1. If true, RoundTrip returns an *AuthorityError
+	// for a request whose Host includes userinfo, whose Host header
+	// differs from Request.Host (or URL.Host), or whose URL does not
+	// produce an origin-form :path. By default, a mismatched Host
+	// header is ignored and an absolute-form Request.URL.Opaque
+	// is trimmed to origin form.
+	StrictAuthority bool
+
+	// DialControl, if non-nil, is used as the net.Dialer's Control
+	// function when the Transport dials a TCP connection itself (that
+	// is, when DialTLS and DialTLSContext are nil). It is called after
+	// the socket is created and before it connects, and may be used to
+	// set platform-specific socket options such as SO_SNDBUF, SO_RCVBUF,
+	// or TCP_USER_TIMEOUT on Linux. Flow-controlled HTTP/2 traffic on
+	// high bandwidth-delay links often benefits from larger socket
+	// buffers than the system defaults.
+	DialControl func(network, address string, c syscall.RawConn) error
+
+	// DialAttemptDelay is how long the Transport's own dialer waits
+	// for a connection attempt to one of a host's addresses before
+	// starting another to the next address, as in RFC 8305 ("Happy
+	// Eyeballs"). The host's IPv6 and IPv4 addresses are tried in
+	// turn, so that an unreachable IPv6 network delays a connection
+	// by DialAttemptDelay rather than the TCP connect timeout.
+	// If zero, 250ms is used. If negative, each attempt waits for
+	// the previous one to fail. It is not used with DialTLS or
+	// DialTLSContext.
+	DialAttemptDelay time.Duration
+
+	// Proxy, if non-nil, returns the proxy to dial new connections
+	// through, as http.Transport.Proxy does. It is called with a GET
+	// request for the root of the origin being dialed, even when the
+	// connection is to one of the origin's alternative services or
+	// service endpoints, and may return an "http", "https", "socks5"
+	// or "socks5h" URL, or nil for a direct connection. The connection
+	// then serves every request for the origin. User information in
+	// the URL is used to authenticate to the proxy. Proxy is not used
+	// with DialTLS or DialTLSContext.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// ProxyConnectHeader optionally specifies headers to send to
+	// an HTTP or HTTPS proxy in CONNECT requests.
+	ProxyConnectHeader http.Header
+
+	// FlowControl, if non-nil, decides when the Transport sends
+	// WINDOW_UPDATE frames for response body data it has consumed.
+	// If nil, NewThresholdFlowControlPolicy(4 << 10) is used.
+	FlowControl FlowControlPolicy
+
+	// DisableConnectionCoalescing prevents requests from using a
+	// connection to a different host. By default, when there is no
+	// connection for a request's host, the Transport reuses an
+	// existing connection whose server listed the host in an ORIGIN
+	// frame (RFC 8336), and, if CoalesceByDNS is set, a connection
+	// found by resolving the host.
+	// The request's :authority is unaffected.
+	DisableConnectionCoalescing bool
+
+	// CoalesceByDNS, if true, lets a request with no connection for
+	// its host reuse an existing connection to the same port whose
+	// server certificate is valid for the host and whose remote IP
+	// address is one the host resolves to, as browsers do
+	// (RFC 9113, Section 9.1.1).
+	//
+	// Resolving the host is bounded by the request's context and a
+	// short timeout. No lookup is made when Proxy, DialTLSContext,
+	// DialTLS or DialClientConn is set, since the addresses they
+	// connect to are not known to the Transport.
+	CoalesceByDNS bool
+
+	// ContentDigest, if non-nil, adds a Content-Digest trailer to
+	// request bodies and checks the Content-Digest trailers of
+	// response bodies. See ContentDigest.
+	ContentDigest *ContentDigest
+
+	// ContentLengthPolicy determines how the Transport handles a
+	// response body longer than its declared Content-Length.
+	// The default is ContentLengthReset.
+	ContentLengthPolicy ContentLengthPolicy
+
+	// MaxTotalStreams, if positive, limits the number of streams open
+	// at once across all of the Transport's connections. Requests
+	// beyond the limit wait, in the order they were made, for a stream
+	// to close, or for their context to be canceled. A stream is held
+	// from when its request is sent until the response ends or the
+	// request is canceled.
+	MaxTotalStreams int
+
+	// PriorityFunc, if non-nil, returns the RFC 7540 priority to send
+	// with a request's HEADERS frame. A priority set with WithPriority
+	// in the request's context takes precedence. See WithPriority.
+	PriorityFunc func(*http.Request) PriorityParam
+
+	// ReplaceOnGoAway, if true, causes the Transport to begin dialing
+	// a replacement connection as soon as a pooled connection with
+	// requests in flight receives a graceful GOAWAY (one with
+	// ErrCodeNo) from the server. New requests use the replacement
+	// while the in-flight requests finish on the old connection.
+	// By default, a new connection is dialed by the first request
+	// which needs one.
+	//
+	// ReplaceOnGoAway has no effect when ConnPool is set, or on a
+	// Transport returned by ConfigureTransports, whose connections
+	// are dialed by the net/http Transport.
+	ReplaceOnGoAway bool
+
+	// ConnDrain, if non-nil, is called when a connection receives
+	// its first GOAWAY frame from the server, and again with Done set
+	// when that connection closes.
+	// It is called from the connection's read loop, and should not block.
+	ConnDrain func(ConnDrainInfo)
+
+	// ConnPoolEvent, if non-nil, is called when a connection is added
+	// to or removed from the Transport's connection pool, or marked
+	// dead. See PoolEvent. It is not called if ConnPool is set.
+	//
+	// ConnPoolEvent is called with the pool locked, in the order of
+	// the changes. It must not block, or call the Transport's methods
+	// using the pool, such as PooledConns, or ClientConn.State.
+	ConnPoolEvent func(PoolEvent)
+
+	// DialPolicy controls whether a request which needs a new
+	// connection waits for one already being dialed to the same
+	// address, or fails immediately with ErrDialInProgress.
+	// The default, DialPolicyWait, waits.
+	// It is not used if ConnPool is set.
+	DialPolicy DialPolicy
+
+	// MaxConnsPerHost, if positive, limits the number of connections
+	// the Transport keeps to each address, counting a connection being
+	// dialed. A request which needs a new connection when the limit is
+	// reached waits for a stream to become free on one of the
+	// address's connections, or for a connection to close, until its
+	// context is done. Requests which set Connection: close get a
+	// connection of their own, which does not count toward the limit.
+	// It is not used if ConnPool is set.
+	MaxConnsPerHost int
+
+	// MaxConnsPerHostFailFast makes a request which would wait under
+	// MaxConnsPerHost fail immediately with ErrMaxConnsPerHost instead.
+	MaxConnsPerHostFailFast bool
+
+	// ReuseConn, if non-nil, is called before a request is assigned to
+	// an existing cached connection, with the connection's current state.
+	// If it returns false, the connection is not used for the request.
+	// When every cached connection is rejected, a new connection is dialed
+	// for the request and added to the pool; ReuseConn is not consulted
+	// for that connection.
+	//
+	// ReuseConn may be used to keep requests with strict isolation
+	// requirements, such as requests for different authentication realms,
+	// off connections already carrying other traffic. It is not called
+	// for connections provided by an http.Transport via ConfigureTransport.
+	ReuseConn func(req *http.Request, state ClientConnState) bool
+
+	// Hedging, if non-nil, enables request hedging for requests
+	// matching the policy. See HedgingPolicy.
+	Hedging *HedgingPolicy
+
+	// Retry, if non-nil, configures when and how requests which fail
+	// before the server processes them are retried. See RetryPolicy.
+	Retry *RetryPolicy
+
+	// CircuitBreaker, if non-nil, fails requests without sending them
+	// to hosts which have failed repeatedly. See CircuitBreaker.
+	CircuitBreaker *CircuitBreaker
+
+	// FlowControlStallFunc, if non-nil, is called at the end of each
+	// interval during which a connection or stream was unable to send
+	// DATA because of flow control, in either direction.
+	// See FlowControlStall.
+	FlowControlStallFunc func(FlowControlStall)
+
+	// RTTSampleFunc, if non-nil, is called each time a connection
+	// measures its round-trip time from the acknowledgement of a
+	// PING. See RTTSample. It is called by the connection's reader,
+	// and must not block.
+	RTTSampleFunc func(RTTSample)
+
+	// FrameEvent, if non-nil, is called for each frame the Transport
+	// sends or receives on the stream of a request, with the request's
+	// label. See WithRequestLabel. It is called synchronously by the
+	// connection's reader and writers, and must not block.
+	FrameEvent func(FrameEvent)
+
+	// PushHandler, if non-nil, enables HTTP/2 server push.
+	// It is called for each response a server promises to push,
+	// and may accept the push with PushPromise.Accept.
+	// Pushes not accepted when PushHandler returns are refused.
+	//
+	// PushHandler is called from the connection's read loop,
+	// and must not block.
+	//
+	// If PushHandler is nil, the Transport tells servers not to
+	// push responses.
+	PushHandler func(*PushPromise)
+
 	// CountError, if non-nil, is called on HTTP/2 transport errors.
 	// It's intended to increment a metric for monitoring, such
 	// as an expvar or Prometheus metric.
@@ -182,9 +528,21 @@ type Transport struct {
 	// RoundTrip method, etc).
 	t1 *http.Transport
 
+	totalStreams streamLimiter // see MaxTotalStreams
+
 	connPoolOnce  sync.Once
 	connPoolOrDef ClientConnPool // non-nil version of ConnPool
 
+	sessionCacheOnce sync.Once
+	sessionCache     tls.ClientSessionCache
+
+	handshakeMu    sync.Mutex
+	handshakeStats map[string]TLSHandshakeStats // keyed by host
+
+	decompMu      sync.Mutex
+	decompressors map[string]func(io.Reader) (io.ReadCloser, error) // keyed by lowercase Content-Encoding
+	decompOrder   []string                                          // registered encodings, in order
+
 	*transportTestHooks
 }
 
@@ -194,6 +552,8 @@ type Transport struct {
 
 type transportTestHooks struct {
 	newclientconn func(*ClientConn)
+	dial          func(addr string) error           // optional; called before dialing addr
+	dialed        func(addr string, cc *ClientConn) // optional; called after dialing addr
 	group         synctestGroupInterface
 }
 
@@ -203,6 +563,13 @@ func (t *Transport) markNewGoroutine() {
 	}
 }
 
+func (t *Transport) now() time.Time {
+	if t != nil && t.transportTestHooks != nil {
+		return t.transportTestHooks.group.Now()
+	}
+	return time.Now()
+}
+
 // newTimer creates a new time.Timer, or a synthetic timer in tests.
 func (t *Transport) newTimer(d time.Duration) timer {
 	if t.transportTestHooks != nil {
@@ -253,6 +620,61 @@ func (t *Transport) disableCompression() bool {
 	return t.DisableCompression || (t.t1 != nil && t.t1.DisableCompression)
 }
 
+// RegisterDecompressor registers a function which transparently decodes
+// response bodies with the given Content-Encoding, such as "br" or "zstd".
+//
+// When the Transport adds its own Accept-Encoding request header (see
+// DisableCompression), it advertises gzip followed by each registered
+// encoding in the order they were registered. Responses using one of
+// these encodings are decoded in the Response.Body, the Content-Encoding
+// and Content-Length headers are removed, and Response.Uncompressed is set.
+// If the user explicitly sets an Accept-Encoding request header, responses
+// are never decoded.
+//
+// Registering "gzip" replaces the Transport's built-in gzip decoder.
+// Registering an encoding a second time replaces the earlier function.
+// RegisterDecompressor panics if encoding is empty or newReader is nil.
+func (t *Transport) RegisterDecompressor(encoding string, newReader func(io.Reader) (io.ReadCloser, error)) {
+	if encoding == "" || newReader == nil {
+		panic("http2: invalid RegisterDecompressor call")
+	}
+	encoding, ascii := asciiToLower(encoding)
+	if !ascii || !httpguts.ValidHeaderFieldValue(encoding) || strings.ContainsAny(encoding, " ,;") {
+		panic("http2: invalid content encoding " + strconv.Quote(encoding))
+	}
+	t.decompMu.Lock()
+	defer t.decompMu.Unlock()
+	if t.decompressors == nil {
+		t.decompressors = make(map[string]func(io.Reader) (io.ReadCloser, error))
+	}
+	if _, ok := t.decompressors[encoding]; !ok && encoding != "gzip" {
+		t.decompOrder = append(t.decompOrder, encoding)
+	}
+	t.decompressors[encoding] = newReader
+}
+
+// decompressor returns the registered decoder for a Content-Encoding, or nil.
+func (t *Transport) decompressor(encoding string) func(io.Reader) (io.ReadCloser, error) {
+	encoding, ascii := asciiToLower(encoding)
+	if !ascii {
+		return nil
+	}
+	t.decompMu.Lock()
+	defer t.decompMu.Unlock()
+	return t.decompressors[encoding]
+}
+
+// acceptEncoding returns the Accept-Encoding request header value
+// the Transport sends when it requests compression on its own.
+func (t *Transport) acceptEncoding() string {
+	t.decompMu.Lock()
+	defer t.decompMu.Unlock()
+	if len(t.decompOrder) == 0 {
+		return "gzip"
+	}
+	return "gzip, " + strings.Join(t.decompOrder, ", ")
+}
+
 func (t *Transport) pingTimeout() time.Duration {
 	if t.PingTimeout == 0 {
 		return 15 * time.Second
@@ -342,6 +764,7 @@ type ClientConn struct {
 	reused        uint32               // whether conn is being reused; atomic
 	singleUse     bool                 // whether being used for a single http.Request
 	getConnCalled bool                 // used by clientConnPool
+	strictAuth    bool                 // Transport.StrictAuthority
 
 	// readLoop goroutine fields:
 	readerDone chan struct{} // closed on error
@@ -350,22 +773,38 @@ type ClientConn struct {
 	idleTimeout time.Duration // or 0 for never
 	idleTimer   timer
 
+	lifetimeTimer timer // or nil; fires after Transport.MaxConnLifetime
+
+	// Receive window auto-tuning; guarded by mu.
+	connInflowWindow   int32         // size of the conn-level inflow window
+	streamInflowWindow int32         // size of new streams' inflow windows
+	bdp                *bdpEstimator // or nil, if windows are not auto-tuned
+	bdpSettingsAcks    int           // SETTINGS frames sent to grow windows, not yet acknowledged
+
 	mu              sync.Mutex // guards following
 	cond            *sync.Cond // hold mu; broadcast on flow/closed changes
 	flow            outflow    // our conn-level flow control quota (cs.outflow is per stream)
 	inflow          inflow     // peer's conn-level flow control
+	inflowStalled   time.Time  // when inflow was exhausted, if it still is
 	doNotReuse      bool       // whether conn is marked to not be reused for any future requests
 	closing         bool
 	closed          bool
 	seenSettings    bool                     // true if we've seen a settings frame, false otherwise
 	wantSettingsAck bool                     // we sent a SETTINGS frame and haven't heard back
+	seenSettingsCh  chan struct{}            // closed when seenSettings is set
+	extendedConnect bool                     // peer sent SETTINGS_ENABLE_CONNECT_PROTOCOL=1
 	goAway          *GoAwayFrame             // if non-nil, the GoAwayFrame we received
 	goAwayDebug     string                   // goAway frame's debug data, retained as a string
-	streams         map[uint32]*clientStream // client-initiated
+	originSet       map[string]bool          // host:port of origins from ORIGIN frames, or nil; see processOrigin
+	dialedAddr      string                   // host:port the conn was dialed for, or "" if unknown
+	streams         map[uint32]*clientStream // client-initiated, and accepted pushes
+	pushStreams     int                      // number of pushed streams in streams
 	streamsReserved int                      // incr by ReserveNewRequest; decr on RoundTrip
 	nextStreamID    uint32
-	pendingRequests int                       // requests blocked and waiting to be sent because len(streams) == maxConcurrentStreams
-	pings           map[[8]byte]chan struct{} // in flight ping data to notification channel
+	pendingRequests int                     // requests blocked and waiting to be sent because len(streams) == maxConcurrentStreams
+	queuedRequests  int                     // requests waiting for a stream; see Transport.MaxQueuedRequests
+	pings           map[[8]byte]*clientPing // in flight ping data to its state
+	rtt             rttStats                // round-trip time estimates, from PING acknowledgements
 	br              *bufio.Reader
 	lastActive      time.Time
 	lastIdle        time.Time // time last idle
@@ -389,7 +828,14 @@ type ClientConn struct {
 	fr   *Framer
 	werr error        // first write error that has occurred
 	hbuf bytes.Buffer // HPACK encoder writes into this
-	henc *hpack.Encoder
+	henc *headerEncoder
+
+	hpackStats hpackStats
+
+	// labelMu guards labels. It may be acquired while holding
+	// any other lock, and no other lock is acquired while holding it.
+	labelMu sync.Mutex
+	labels  map[uint32]interface{} // request labels by stream ID; see WithRequestLabel
 }
 
 // clientStream is the state for a single HTTP/2 stream. One of these
@@ -400,12 +846,18 @@ type clientStream struct {
 	// Fields of Request that we may access even after the response body is closed.
 	ctx       context.Context
 	reqCancel <-chan struct{}
-
-	trace         *httptrace.ClientTrace // or nil
-	ID            uint32
-	bufPipe       pipe // buffered pipe with the flow-controlled response payload
-	requestedGzip bool
-	isHead        bool
+	req       *http.Request // its Body must not be accessed
+
+	trace       *httptrace.ClientTrace // or nil
+	timeouts    RequestTimeouts
+	interimRec  *interimRecorder // or nil; records 1xx responses
+	label       interface{}      // or nil; see WithRequestLabel
+	priority    PriorityParam    // sent with the request HEADERS, unless zero
+	idleTimer   timer            // or nil; fires after timeouts.StreamIdleTimeout without activity
+	ID          uint32
+	bufPipe     pipe   // buffered pipe with the flow-controlled response payload
+	reqEncoding string // Accept-Encoding added by the Transport, or ""
+	isHead      bool
 
 	abortOnce sync.Once
 	abort     chan struct{} // closed to signal stream should end immediately
@@ -418,29 +870,44 @@ type clientStream struct {
 	respHeaderRecv chan struct{}  // closed when headers are received
 	res            *http.Response // set if respHeaderRecv is closed
 
-	flow        outflow // guarded by cc.mu
-	inflow      inflow  // guarded by cc.mu
-	bytesRemain int64   // -1 means unknown; owned by transportResponseBody.Read
-	readErr     error   // sticky read error; owned by transportResponseBody.Read
+	flow          outflow   // guarded by cc.mu
+	inflow        inflow    // guarded by cc.mu
+	inflowStalled time.Time // when inflow was exhausted, if it still is; guarded by cc.mu
+	bytesRemain   int64     // -1 means unknown; owned by transportResponseBody.Read
+	readErr       error     // sticky read error; owned by transportResponseBody.Read
 
 	reqBody              io.ReadCloser
 	reqBodyContentLength int64         // -1 means unknown
 	reqBodyClosed        chan struct{} // guarded by cc.mu; non-nil on Close, closed when done
+	peerStoppedBody      bool          // guarded by cc.mu; peer sent RST_STREAM(NO_ERROR) after the response
+	fullDuplex           bool          // request body outlives the response; see WithFullDuplex
 
 	// owned by writeRequest:
-	sentEndStream bool // sent an END_STREAM flag to the peer
-	sentHeaders   bool
+	sentEndStream   bool // sent an END_STREAM flag to the peer
+	sentHeaders     bool
+	heldTotalStream bool // holds one of Transport.MaxTotalStreams
+
+	// owned by writeRequest, while waiting for a stream; see enterQueue:
+	queued       bool
+	queueStart   time.Time
+	queueTimeout <-chan struct{} // closed after Transport.MaxQueueWait
+	queueTimer   timer
 
 	// owned by clientConnReadLoop:
-	firstByte    bool  // got the first response byte
-	pastHeaders  bool  // got first MetaHeadersFrame (actual headers)
-	pastTrailers bool  // got optional second MetaHeadersFrame (trailers)
-	num1xx       uint8 // number of 1xx responses seen
-	readClosed   bool  // peer sent an END_STREAM flag
-	readAborted  bool  // read loop reset the stream
+	firstByte    bool              // got the first response byte
+	pastHeaders  bool              // got first MetaHeadersFrame (actual headers)
+	pastTrailers bool              // got optional second MetaHeadersFrame (trailers)
+	num1xx       uint8             // number of 1xx responses seen
+	interim      []InterimResponse // 1xx responses seen, if recording them
+	readClosed   bool              // peer sent an END_STREAM flag
+	readAborted  bool              // read loop reset the stream
+
+	declBodyBytes int64 // response Content-Length, or -1 if undeclared
+	bodyBytes     int64 // response body bytes received
 
 	trailer    http.Header  // accumulated trailers
 	resTrailer *http.Header // client's Response.Trailer
+	digest     hash.Hash    // digest of the response body, if Transport.ContentDigest is set
 }
 
 var got1xxFuncForTests func(int, textproto.MIMEHeader) error
@@ -499,6 +966,7 @@ func (cs *clientStream) closeReqBodyLocked() {
 }
 
 type stickyErrWriter struct {
+	t       *Transport // for the current time
 	conn    net.Conn
 	timeout time.Duration
 	err     *error
@@ -510,7 +978,7 @@ func (sew stickyErrWriter) Write(p []byte) (n int, err error) {
 	}
 	for {
 		if sew.timeout != 0 {
-			sew.conn.SetWriteDeadline(time.Now().Add(sew.timeout))
+			sew.conn.SetWriteDeadline(sew.t.now().Add(sew.timeout))
 		}
 		nn, err := sew.conn.Write(p[n:])
 		n += nn
@@ -547,6 +1015,18 @@ func isNoCachedConnError(err error) bool {
 
 var ErrNoCachedConn error = noCachedConnError{}
 
+// ErrDialInProgress is returned by RoundTrip for a request which needs
+// a new connection while one is being dialed, when the Transport's
+// DialPolicy chooses not to wait for it.
+var ErrDialInProgress = errors.New("http2: connection dial in progress")
+
+// ErrMaxConnsPerHost is returned by RoundTrip for a request which needs
+// a new connection to an address which has Transport.MaxConnsPerHost
+// connections, when Transport.MaxConnsPerHostFailFast is set, and by
+// Transport.Preconnect and Transport.AddClientConn for a connection
+// which would exceed the limit.
+var ErrMaxConnsPerHost = errors.New("http2: too many connections to host")
+
 // RoundTripOpt are options for the Transport.RoundTripOpt method.
 type RoundTripOpt struct {
 	// OnlyCachedConn controls whether RoundTripOpt may
@@ -590,36 +1070,41 @@ func (t *Transport) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.Res
 		return nil, errors.New("http2: unsupported scheme")
 	}
 
+	req, err := t.bufferRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
 	addr := authorityAddr(req.URL.Scheme, req.URL.Host)
-	for retry := 0; ; retry++ {
+	for retry := 1; ; retry++ {
+		if err := t.CircuitBreaker.allow(addr, req, t.now()); err != nil {
+			return nil, err
+		}
 		cc, err := t.connPool().GetClientConn(req, addr)
 		if err != nil {
 			t.vlogf("http2: Transport failed to get client conn for %s: %v", addr, err)
-			return nil, err
+			if err == ErrNoCachedConn || err == ErrDialInProgress || err == ErrMaxConnsPerHost {
+				t.CircuitBreaker.abandon(addr)
+			} else {
+				t.CircuitBreaker.dialDone(addr, req, err, t.now())
+			}
+			if err == ErrNoCachedConn || err == ErrDialInProgress || err == ErrMaxConnsPerHost || req.Context().Err() != nil || !t.Retry.allows(req, err, RetryDial, retry) {
+				return nil, err
+			}
+			if err := t.waitRetry(req, retry); err != nil {
+				return nil, err
+			}
+			continue
 		}
-		reused := !atomic.CompareAndSwapUint32(&cc.reused, 0, 1)
-		traceGotConn(req, cc, reused)
-		res, err := cc.RoundTrip(req)
-		if err != nil && retry <= 6 {
+		res, err := t.roundTripHedged(cc, req, addr)
+		t.CircuitBreaker.done(addr, req, res, err, t.now())
+		if reason := retryReasonOf(err); reason != 0 && t.Retry.allows(req, err, reason, retry) {
 			roundTripErr := err
 			if req, err = shouldRetryRequest(req, err); err == nil {
-				// After the first retry, do exponential backoff with 10% jitter.
-				if retry == 0 {
+				if err = t.waitRetry(req, retry); err == nil {
 					t.vlogf("RoundTrip retrying after failure: %v", roundTripErr)
 					continue
 				}
-				backoff := float64(uint(1) << (uint(retry) - 1))
-				backoff += backoff * (0.1 * mathrand.Float64())
-				d := time.Second * time.Duration(backoff)
-				tm := t.newTimer(d)
-				select {
-				case <-tm.C():
-					t.vlogf("RoundTrip retrying after failure: %v", roundTripErr)
-					continue
-				case <-req.Context().Done():
-					tm.Stop()
-					err = req.Context().Err()
-				}
 			}
 		}
 		if err != nil {
@@ -630,6 +1115,23 @@ func (t *Transport) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.Res
 	}
 }
 
+// waitRetry waits before the nth retry of req, as set by t.Retry.
+// It returns an error if req's context is done first.
+func (t *Transport) waitRetry(req *http.Request, n int) error {
+	d := t.Retry.backoff(n)
+	if d <= 0 {
+		return nil
+	}
+	tm := t.newTimer(d)
+	select {
+	case <-tm.C():
+		return nil
+	case <-req.Context().Done():
+		tm.Stop()
+		return req.Context().Err()
+	}
+}
+
 // CloseIdleConnections closes any connections which were previously
 // connected from previous requests but are now sitting idle.
 // It does not interrupt any connections currently in use.
@@ -639,10 +1141,204 @@ func (t *Transport) CloseIdleConnections() {
 	}
 }
 
+// ConnDrainInfo describes a connection draining after a GOAWAY.
+// See Transport.ConnDrain.
+type ConnDrainInfo struct {
+	Conn *ClientConn
+
+	// ErrCode and LastStreamID are from the server's GOAWAY frame.
+	ErrCode      ErrCode
+	LastStreamID uint32
+
+	// StreamsActive is the number of requests in flight on the
+	// connection. When Done is set, it is the number of requests
+	// which did not finish before the connection closed.
+	StreamsActive int
+
+	// Replaced is whether a replacement connection is being dialed.
+	// See Transport.ReplaceOnGoAway.
+	Replaced bool
+
+	// Done is set when the connection has closed.
+	Done bool
+}
+
+// errCustomConnPool is returned by Transport methods which require
+// the Transport's own connection pool.
+var errCustomConnPool = errors.New("http2: Transport uses a custom ConnPool")
+
+// defaultConnPool returns the Transport's own connection pool,
+// or nil if t.ConnPool is set.
+func (t *Transport) defaultConnPool() *clientConnPool {
+	switch p := t.connPool().(type) {
+	case *clientConnPool:
+		return p
+	case noDialClientConnPool:
+		return p.clientConnPool
+	}
+	return nil
+}
+
+// wakeConnWaiters wakes requests waiting under MaxConnsPerHost, after
+// a connection has freed a stream. It must not be called with a
+// ClientConn's mu held, since the pool's lock is taken before it.
+func (t *Transport) wakeConnWaiters() {
+	if t.MaxConnsPerHost <= 0 {
+		return
+	}
+	if p := t.defaultConnPool(); p != nil {
+		p.wakeConnWaiters()
+	}
+}
+
+// Preconnect dials n new connections to addr and adds them to the
+// Transport's connection pool, so that later requests to addr need
+// not wait for connection setup. The addr is a host with an optional
+// port, as in a URL's Host field; the port defaults to 443.
+//
+// When Preconnect returns, each connection it added has completed its
+// TLS handshake and received the server's SETTINGS. If any connection
+// fails, Preconnect returns the first error encountered; connections
+// which succeeded remain in the pool. Connections beyond
+// MaxConnsPerHost are not dialed, and fail with ErrMaxConnsPerHost.
+//
+// Preconnect returns an error if t.ConnPool is set.
+func (t *Transport) Preconnect(ctx context.Context, addr string, n int) error {
+	p := t.defaultConnPool()
+	if p == nil {
+		return errCustomConnPool
+	}
+	addr = authorityAddr("https", addr)
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			t.markNewGoroutine()
+			errc <- p.preconnect(ctx, addr)
+		}()
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+var errForeignClientConn = errors.New("http2: ClientConn was not created by this Transport")
+
+// AddClientConn adds cc, created by t.NewClientConn, to the Transport's
+// connection pool for addr, which has the same form as in Preconnect.
+// Requests to addr may then use cc like a connection dialed by the
+// Transport, and the pool removes cc when it closes.
+//
+// AddClientConn returns an error if t.ConnPool is set, or if cc
+// cannot take new requests. It returns ErrMaxConnsPerHost if the pool
+// already has, or is dialing, MaxConnsPerHost connections to addr.
+func (t *Transport) AddClientConn(addr string, cc *ClientConn) error {
+	p := t.defaultConnPool()
+	if p == nil {
+		return errCustomConnPool
+	}
+	if cc.t != t {
+		return errForeignClientConn
+	}
+	if !cc.CanTakeNewRequest() {
+		return errClientConnUnusable
+	}
+	addr = authorityAddr("https", addr)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns[addr] {
+		if c == cc {
+			return nil
+		}
+	}
+	if p.connLimitReachedLocked(addr) {
+		return ErrMaxConnsPerHost
+	}
+	p.addConnLocked(addr, cc)
+	return nil
+}
+
+// ConnStates returns the state of each connection in the Transport's
+// connection pool for addr, which has the same form as in Preconnect.
+// It returns nil if t.ConnPool is set.
+func (t *Transport) ConnStates(addr string) []ClientConnState {
+	p := t.defaultConnPool()
+	if p == nil {
+		return nil
+	}
+	addr = authorityAddr("https", addr)
+	p.mu.Lock()
+	conns := append([]*ClientConn(nil), p.conns[addr]...)
+	p.mu.Unlock()
+	var states []ClientConnState
+	for _, cc := range conns {
+		states = append(states, cc.State())
+	}
+	return states
+}
+
+// PooledConns returns the connections in the Transport's connection
+// pool, with their states, ordered by address and then by when they
+// were added. It returns nil if t.ConnPool is set.
+func (t *Transport) PooledConns() []PooledConn {
+	p := t.defaultConnPool()
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	var addrs []string
+	for addr := range p.conns {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	var pooled []PooledConn
+	for _, addr := range addrs {
+		for _, cc := range p.conns[addr] {
+			pooled = append(pooled, PooledConn{Addr: addr, Conn: cc})
+		}
+	}
+	p.mu.Unlock()
+	// ClientConn.State may block on the connection's write lock,
+	// so get the states without holding p.mu.
+	for i := range pooled {
+		pooled[i].State = pooled[i].Conn.State()
+	}
+	return pooled
+}
+
+// RemoveClientConn removes cc from the Transport's connection pool,
+// such as when its server is being drained. New requests do not use
+// cc; its requests in flight continue, and cc is closed when they are
+// done. It reports whether cc was in the pool.
+//
+// RemoveClientConn returns an error if t.ConnPool is set.
+func (t *Transport) RemoveClientConn(cc *ClientConn) (bool, error) {
+	p := t.defaultConnPool()
+	if p == nil {
+		return false, errCustomConnPool
+	}
+	p.mu.Lock()
+	_, ok := p.keys[cc]
+	p.removeConnLocked(cc)
+	p.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	cc.mu.Lock()
+	cc.doNotReuse = true
+	cc.mu.Unlock()
+	cc.closeIfIdle()
+	return true, nil
+}
+
 var (
 	errClientConnClosed    = errors.New("http2: client conn is closed")
 	errClientConnUnusable  = errors.New("http2: client conn not usable")
 	errClientConnGotGoAway = errors.New("http2: Transport received Server's graceful shutdown GOAWAY")
+	errClientConnLost      = errors.New("http2: client connection lost")
 )
 
 // shouldRetryRequest is called by RoundTrip when a request fails to get
@@ -682,32 +1378,190 @@ func shouldRetryRequest(req *http.Request, err error) (*http.Request, error) {
 }
 
 func canRetryError(err error) bool {
+	return retryReasonOf(err) != 0
+}
+
+// retryReasonOf returns why a request which failed with err may be
+// retried, or zero if it may not.
+func retryReasonOf(err error) RetryReason {
 	if err == errClientConnUnusable || err == errClientConnGotGoAway {
-		return true
+		return RetryGoAway
 	}
 	if se, ok := err.(StreamError); ok {
 		if se.Code == ErrCodeProtocol && se.Cause == errFromPeer {
 			// See golang/go#47635, golang/go#42777
-			return true
+			return RetryRefusedStream
+		}
+		if se.Code == ErrCodeRefusedStream {
+			return RetryRefusedStream
 		}
-		return se.Code == ErrCodeRefusedStream
 	}
-	return false
+	return 0
+}
+
+// ServiceEndpoint is an alternative endpoint for an origin, as advertised
+// by a ServiceMode DNS HTTPS record (RFC 9460).
+type ServiceEndpoint struct {
+	// Priority is the record's SvcPriority. Lower values are preferred.
+	// Zero indicates an AliasMode record, which is ignored.
+	Priority uint16
+
+	// Target is the record's TargetName.
+	// If empty or ".", the origin host is used.
+	Target string
+
+	// Port is the value of the "port" SvcParam.
+	// If zero, the origin's port is used.
+	Port uint16
+
+	// ALPN is the value of the "alpn" SvcParam.
+	ALPN []string
+}
+
+var errNoServiceEndpoint = errors.New("http2: no advertised service endpoint supports HTTP/2")
+
+// serviceAddr returns the address to dial for the origin addr,
+// given the endpoints returned by Transport.LookupService.
+func serviceAddr(addr string, eps []ServiceEndpoint) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	var best *ServiceEndpoint
+	haveService := false
+	for i := range eps {
+		ep := &eps[i]
+		if ep.Priority == 0 {
+			continue
+		}
+		haveService = true
+		if !strSliceContains(ep.ALPN, NextProtoTLS) {
+			continue
+		}
+		if best == nil || ep.Priority < best.Priority {
+			best = ep
+		}
+	}
+	if !haveService {
+		// No endpoints, or only AliasMode records: dial the origin.
+		return addr, nil
+	}
+	if best == nil {
+		return "", errNoServiceEndpoint
+	}
+	if t := strings.TrimSuffix(best.Target, "."); t != "" {
+		host = t
+	}
+	if best.Port != 0 {
+		port = strconv.Itoa(int(best.Port))
+	}
+	return net.JoinHostPort(host, port), nil
 }
 
 func (t *Transport) dialClientConn(ctx context.Context, addr string, singleUse bool) (*ClientConn, error) {
-	if t.transportTestHooks != nil {
-		return t.newClientConn(nil, singleUse)
+	if t.DialClientConn != nil {
+		cc, err := t.DialClientConn(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		if cc.t != t {
+			cc.Close()
+			return nil, errForeignClientConn
+		}
+		cc.mu.Lock()
+		cc.singleUse = cc.singleUse || singleUse
+		cc.mu.Unlock()
+		return cc, nil
+	}
+	if hooks := t.transportTestHooks; hooks != nil {
+		if hooks.dial != nil {
+			if err := hooks.dial(addr); err != nil {
+				return nil, err
+			}
+		}
+		cc, err := t.newClientConn(nil, addr, singleUse)
+		if err == nil && hooks.dialed != nil {
+			hooks.dialed(addr, cc)
+		}
+		return cc, err
 	}
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
 	}
-	tconn, err := t.dialTLS(ctx, "tcp", addr, t.newTLSConfig(host))
+	if altAddr := t.altSvcAddr(addr); altAddr != "" {
+		// The alternative must present a certificate for the origin.
+		tconn, err := t.dialTLS(ctx, "tcp", addr, altAddr, t.newTLSConfig(host))
+		if err == nil {
+			return t.newClientConn(tconn, addr, singleUse)
+		}
+		t.vlogf("http2: Transport alternative service %s for %s failed: %v", altAddr, addr, err)
+		t.forgetAltSvc(addr, altAddr)
+	}
+	dialAddr := addr
+	if t.LookupService != nil {
+		if eps, err := t.LookupService(ctx, host); err != nil {
+			t.vlogf("http2: Transport service lookup for %s failed: %v", host, err)
+		} else if dialAddr, err = serviceAddr(addr, eps); err != nil {
+			return nil, err
+		}
+	}
+	tconn, err := t.dialTLS(ctx, "tcp", addr, dialAddr, t.newTLSConfig(host))
 	if err != nil {
 		return nil, err
 	}
-	return t.newClientConn(tconn, singleUse)
+	return t.newClientConn(tconn, addr, singleUse)
+}
+
+// TLSHandshakeStats counts the TLS handshakes performed by a Transport's connections.
+type TLSHandshakeStats struct {
+	// Full is the number of handshakes which established a new session.
+	Full uint64
+
+	// Resumed is the number of handshakes which resumed an earlier session.
+	Resumed uint64
+}
+
+// TLSHandshakeStats returns counts of the full and resumed TLS handshakes
+// performed by connections created by t to host, including connections
+// handed to t by a net/http Transport configured with ConfigureTransports.
+// A connection's host is that of the address it was dialed for or,
+// for a connection passed to NewClientConn, its TLS server name.
+func (t *Transport) TLSHandshakeStats(host string) TLSHandshakeStats {
+	t.handshakeMu.Lock()
+	defer t.handshakeMu.Unlock()
+	return t.handshakeStats[host]
+}
+
+func (t *Transport) countHandshake(addr string, state *tls.ConnectionState) {
+	host := state.ServerName
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	t.handshakeMu.Lock()
+	defer t.handshakeMu.Unlock()
+	if t.handshakeStats == nil {
+		t.handshakeStats = make(map[string]TLSHandshakeStats)
+	}
+	stats := t.handshakeStats[host]
+	if state.DidResume {
+		stats.Resumed++
+	} else {
+		stats.Full++
+	}
+	t.handshakeStats[host] = stats
+}
+
+// clientSessionCache returns the TLS session cache shared by
+// connections dialed by t, or nil if t shouldn't supply one.
+func (t *Transport) clientSessionCache() tls.ClientSessionCache {
+	if !t.ResumeTLSSessions {
+		return nil
+	}
+	t.sessionCacheOnce.Do(func() {
+		t.sessionCache = tls.NewLRUClientSessionCache(0)
+	})
+	return t.sessionCache
 }
 
 func (t *Transport) newTLSConfig(host string) *tls.Config {
@@ -715,6 +1569,9 @@ func (t *Transport) newTLSConfig(host string) *tls.Config {
 	if t.TLSClientConfig != nil {
 		*cfg = *t.TLSClientConfig.Clone()
 	}
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = t.clientSessionCache()
+	}
 	if !strSliceContains(cfg.NextProtos, NextProtoTLS) {
 		cfg.NextProtos = append([]string{NextProtoTLS}, cfg.NextProtos...)
 	}
@@ -724,14 +1581,17 @@ func (t *Transport) newTLSConfig(host string) *tls.Config {
 	return cfg
 }
 
-func (t *Transport) dialTLS(ctx context.Context, network, addr string, tlsCfg *tls.Config) (net.Conn, error) {
+// dialTLS dials a TLS connection to addr, which serves the origin
+// authority origin. addr differs from origin when the connection is to
+// an alternative service or a service endpoint of the origin.
+func (t *Transport) dialTLS(ctx context.Context, network, origin, addr string, tlsCfg *tls.Config) (net.Conn, error) {
 	if t.DialTLSContext != nil {
 		return t.DialTLSContext(ctx, network, addr, tlsCfg)
 	} else if t.DialTLS != nil {
 		return t.DialTLS(network, addr, tlsCfg)
 	}
 
-	tlsCn, err := t.dialTLSWithContext(ctx, network, addr, tlsCfg)
+	tlsCn, err := t.dialTLSWithContext(ctx, network, origin, addr, tlsCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -745,6 +1605,10 @@ func (t *Transport) dialTLS(ctx context.Context, network, addr string, tlsCfg *t
 	return tlsCn, nil
 }
 
+func (t *Transport) preserveHeaderCase() bool {
+	return t != nil && t.PreserveHeaderCase
+}
+
 // disableKeepAlives reports whether connections should be closed as
 // soon as possible after handling the first request.
 func (t *Transport) disableKeepAlives() bool {
@@ -772,14 +1636,31 @@ func (t *Transport) maxEncoderHeaderTableSize() uint32 {
 	return initialHeaderTableSize
 }
 
+func (t *Transport) maxReceiveBufferPerConnection() int32 {
+	if v := t.MaxReceiveBufferPerConnection; v >= initialWindowSize {
+		return v
+	}
+	return transportDefaultConnFlow + initialWindowSize
+}
+
+func (t *Transport) maxReceiveBufferPerStream() int32 {
+	if v := t.MaxReceiveBufferPerStream; v > 0 {
+		return v
+	}
+	return transportDefaultStreamFlow
+}
+
 func (t *Transport) NewClientConn(c net.Conn) (*ClientConn, error) {
-	return t.newClientConn(c, t.disableKeepAlives())
+	return t.newClientConn(c, "", t.disableKeepAlives())
 }
 
-func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, error) {
+// newClientConn creates a ClientConn for c, which was dialed for the
+// origin at addr, or "" if unknown.
+func (t *Transport) newClientConn(c net.Conn, addr string, singleUse bool) (*ClientConn, error) {
 	cc := &ClientConn{
 		t:                     t,
 		tconn:                 c,
+		dialedAddr:            addr,
 		readerDone:            make(chan struct{}),
 		nextStreamID:          1,
 		maxFrameSize:          16 << 10,                    // spec default
@@ -788,8 +1669,10 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 		peerMaxHeaderListSize: 0xffffffffffffffff,          // "infinite", per spec. Use 2^64-1 instead.
 		streams:               make(map[uint32]*clientStream),
 		singleUse:             singleUse,
+		strictAuth:            t.StrictAuthority,
 		wantSettingsAck:       true,
-		pings:                 make(map[[8]byte]chan struct{}),
+		seenSettingsCh:        make(chan struct{}),
+		pings:                 make(map[[8]byte]*clientPing),
 		reqHeaderMu:           make(chan struct{}, 1),
 	}
 	if t.transportTestHooks != nil {
@@ -807,12 +1690,16 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 	// TODO: adjust this writer size to account for frame size +
 	// MTU + crypto/tls record padding.
 	cc.bw = bufio.NewWriter(stickyErrWriter{
+		t:       t,
 		conn:    c,
 		timeout: t.WriteByteTimeout,
 		err:     &cc.werr,
 	})
 	cc.br = bufio.NewReader(c)
 	cc.fr = NewFramer(cc.bw, cc.br)
+	if t.FrameEvent != nil {
+		cc.fr.wroteFrame = func(fh FrameHeader) { cc.frameEvent(fh, true) }
+	}
 	if t.maxFrameReadSize() != 0 {
 		cc.fr.SetMaxReadFrameSize(t.maxFrameReadSize())
 	}
@@ -823,7 +1710,7 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 	cc.fr.ReadMetaHeaders = hpack.NewDecoder(maxHeaderTableSize, nil)
 	cc.fr.MaxHeaderListSize = t.maxHeaderListSize()
 
-	cc.henc = hpack.NewEncoder(&cc.hbuf)
+	cc.henc = newHeaderEncoder(&cc.hbuf)
 	cc.henc.SetMaxDynamicTableSizeLimit(t.maxEncoderHeaderTableSize())
 	cc.peerMaxHeaderTableSize = initialHeaderTableSize
 
@@ -834,11 +1721,16 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 	if cs, ok := c.(connectionStater); ok {
 		state := cs.ConnectionState()
 		cc.tlsState = &state
+		t.countHandshake(addr, cc.tlsState)
 	}
 
 	initialSettings := []Setting{
 		{ID: SettingEnablePush, Val: 0},
-		{ID: SettingInitialWindowSize, Val: transportDefaultStreamFlow},
+		{ID: SettingInitialWindowSize, Val: uint32(t.maxReceiveBufferPerStream())},
+	}
+	if t.PushHandler != nil {
+		initialSettings[0].Val = 1
+		cc.fr.readMetaPushPromises = true
 	}
 	if max := t.maxFrameReadSize(); max != 0 {
 		initialSettings = append(initialSettings, Setting{ID: SettingMaxFrameSize, Val: max})
@@ -852,8 +1744,17 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 
 	cc.bw.Write(clientPreface)
 	cc.fr.WriteSettings(initialSettings...)
-	cc.fr.WriteWindowUpdate(0, transportDefaultConnFlow)
-	cc.inflow.init(transportDefaultConnFlow + initialWindowSize)
+	connFlow := t.maxReceiveBufferPerConnection()
+	if connFlow > initialWindowSize {
+		cc.fr.WriteWindowUpdate(0, uint32(connFlow-initialWindowSize))
+	}
+	cc.inflow.init(connFlow)
+	cc.inflow.policy = t.FlowControl
+	cc.connInflowWindow = connFlow
+	cc.streamInflowWindow = t.maxReceiveBufferPerStream()
+	if limit := t.MaxAutoTunedReceiveBuffer; limit > cc.streamInflowWindow {
+		cc.bdp = &bdpEstimator{limit: limit, window: cc.streamInflowWindow}
+	}
 	cc.bw.Flush()
 	if cc.werr != nil {
 		cc.Close()
@@ -865,6 +1766,9 @@ func (t *Transport) newClientConn(c net.Conn, singleUse bool) (*ClientConn, erro
 		cc.idleTimeout = d
 		cc.idleTimer = t.afterFunc(d, cc.onIdleTimeout)
 	}
+	if d := t.MaxConnLifetime; d > 0 {
+		cc.lifetimeTimer = t.afterFunc(d, cc.onMaxLifetime)
+	}
 
 	go cc.readLoop()
 	return cc, nil
@@ -909,8 +1813,9 @@ func (cc *ClientConn) setGoAway(f *GoAwayFrame) {
 	}
 	last := f.LastStreamID
 	for streamID, cs := range cc.streams {
-		if streamID <= last {
-			// The server's GOAWAY indicates that it received this stream.
+		if streamID <= last || streamID%2 == 0 {
+			// The server's GOAWAY indicates that it received this stream,
+			// or the stream is one it pushed.
 			// It will either finish processing it, or close the connection
 			// without doing so. Either way, leave the stream alone for now.
 			continue
@@ -983,6 +1888,59 @@ type ClientConnState struct {
 	// LastIdle, if non-zero, is when the connection last
 	// transitioned to idle state.
 	LastIdle time.Time
+
+	// TLSResumed is whether the connection's TLS handshake
+	// resumed an earlier session.
+	TLSResumed bool
+
+	// CanTakeNewRequest is whether the connection can take a new
+	// request. See ClientConn.CanTakeNewRequest.
+	CanTakeNewRequest bool
+
+	// IdleTime is how long the connection has been idle,
+	// or zero if it has active streams.
+	IdleTime time.Duration
+
+	// GoAway, if non-nil, describes the GOAWAY frame the peer sent.
+	GoAway *GoAwayError
+
+	// Origins holds the host:port addresses of the origins in the
+	// connection's origin set, if the peer sent an ORIGIN frame
+	// (RFC 8336). The connection is only coalesced for requests to
+	// these origins. Origins is nil if the peer sent no ORIGIN frame.
+	Origins []string
+
+	// PeerMaxFrameSize, PeerInitialWindowSize,
+	// PeerMaxHeaderListSize and PeerHeaderTableSize are the
+	// peer's SETTINGS_MAX_FRAME_SIZE, SETTINGS_INITIAL_WINDOW_SIZE,
+	// SETTINGS_MAX_HEADER_LIST_SIZE and SETTINGS_HEADER_TABLE_SIZE.
+	// Until the peer's first SETTINGS frame is received, they are
+	// the protocol's initial values.
+	PeerMaxFrameSize      uint32
+	PeerInitialWindowSize uint32
+	PeerMaxHeaderListSize uint64
+	PeerHeaderTableSize   uint32
+
+	// SendWindow is how many bytes of request bodies the
+	// connection-level flow control window permits sending.
+	SendWindow int32
+
+	// ReceiveWindow is how many bytes of response bodies the peer
+	// may send before the connection-level flow control window,
+	// including any increase not yet sent in a WINDOW_UPDATE, is
+	// exhausted.
+	ReceiveWindow int32
+
+	// SmoothedRTT is the connection's estimated round-trip time,
+	// averaged over the PINGs acknowledged by the peer, and
+	// RTTVariation is the mean deviation from it. MinRTT is the
+	// shortest round-trip time measured. They are zero until the
+	// first PING is acknowledged; RTTSamples is the number of PINGs
+	// measured. ClientConn.Ping takes a new sample.
+	SmoothedRTT  time.Duration
+	RTTVariation time.Duration
+	MinRTT       time.Duration
+	RTTSamples   int
 }
 
 // State returns a snapshot of cc's state.
@@ -996,15 +1954,39 @@ func (cc *ClientConn) State() ClientConnState {
 
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
-	return ClientConnState{
-		Closed:               cc.closed,
-		Closing:              cc.closing || cc.singleUse || cc.doNotReuse || cc.goAway != nil,
-		StreamsActive:        len(cc.streams),
-		StreamsReserved:      cc.streamsReserved,
-		StreamsPending:       cc.pendingRequests,
-		LastIdle:             cc.lastIdle,
-		MaxConcurrentStreams: maxConcurrent,
+	st := ClientConnState{
+		Closed:                cc.closed,
+		Closing:               cc.closing || cc.singleUse || cc.doNotReuse || cc.goAway != nil,
+		StreamsActive:         len(cc.streams),
+		StreamsReserved:       cc.streamsReserved,
+		StreamsPending:        cc.pendingRequests,
+		LastIdle:              cc.lastIdle,
+		MaxConcurrentStreams:  maxConcurrent,
+		TLSResumed:            cc.tlsState != nil && cc.tlsState.DidResume,
+		CanTakeNewRequest:     cc.idleStateLocked().canTakeNewRequest,
+		PeerMaxFrameSize:      cc.maxFrameSize,
+		PeerInitialWindowSize: cc.initialWindowSize,
+		PeerMaxHeaderListSize: cc.peerMaxHeaderListSize,
+		PeerHeaderTableSize:   cc.peerMaxHeaderTableSize,
+		SendWindow:            cc.flow.available(),
+		ReceiveWindow:         cc.inflow.avail + cc.inflow.unsent,
+		Origins:               cc.originsLocked(),
+		SmoothedRTT:           cc.rtt.smoothed,
+		RTTVariation:          cc.rtt.variation,
+		MinRTT:                cc.rtt.min,
+		RTTSamples:            cc.rtt.samples,
+	}
+	if !cc.lastIdle.IsZero() {
+		st.IdleTime = time.Since(cc.lastIdle)
+	}
+	if cc.goAway != nil {
+		st.GoAway = &GoAwayError{
+			LastStreamID: cc.goAway.LastStreamID,
+			ErrCode:      cc.goAway.ErrCode,
+			DebugData:    cc.goAwayDebug,
+		}
 	}
+	return st
 }
 
 // clientConnIdleState describes the suitability of a client
@@ -1031,7 +2013,7 @@ func (cc *ClientConn) idleStateLocked() (st clientConnIdleState) {
 		// writing it.
 		maxConcurrentOkay = true
 	} else {
-		maxConcurrentOkay = int64(len(cc.streams)+cc.streamsReserved+1) <= int64(cc.maxConcurrentStreams)
+		maxConcurrentOkay = cc.hasFreeStreamLocked()
 	}
 
 	st.canTakeNewRequest = cc.goAway == nil && !cc.closed && !cc.closing && maxConcurrentOkay &&
@@ -1041,6 +2023,25 @@ func (cc *ClientConn) idleStateLocked() (st clientConnIdleState) {
 	return
 }
 
+// hasFreeStreamLocked reports whether a new request would be within
+// the peer's SETTINGS_MAX_CONCURRENT_STREAMS.
+func (cc *ClientConn) hasFreeStreamLocked() bool {
+	return int64(len(cc.streams)-cc.pushStreams+cc.streamsReserved+1) <= int64(cc.maxConcurrentStreams)
+}
+
+// reserveFreeStream is like ReserveNewRequest, but only reserves
+// a stream within the peer's SETTINGS_MAX_CONCURRENT_STREAMS, even
+// when StrictMaxConcurrentStreams is set.
+func (cc *ClientConn) reserveFreeStream() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if !cc.canTakeNewRequestLocked() || !cc.hasFreeStreamLocked() {
+		return false
+	}
+	cc.streamsReserved++
+	return true
+}
+
 func (cc *ClientConn) canTakeNewRequestLocked() bool {
 	st := cc.idleStateLocked()
 	return st.canTakeNewRequest
@@ -1066,6 +2067,44 @@ func (cc *ClientConn) onIdleTimeout() {
 	cc.closeIfIdle()
 }
 
+// onMaxLifetime is called from a time.AfterFunc goroutine when the
+// connection reaches Transport.MaxConnLifetime. It retires the
+// connection: it is removed from the pool and closed once its active
+// requests are done. A connection which has already received a GOAWAY
+// is left to finish draining.
+func (cc *ClientConn) onMaxLifetime() {
+	var addrs []string // pool keys, if the connection is to be replaced
+	p, ok := cc.t.connPool().(*clientConnPool)
+	if ok && cc.t.ReplaceOnGoAway {
+		p.mu.Lock()
+		addrs = append(addrs, p.keys[cc]...)
+		p.mu.Unlock()
+	}
+
+	cc.mu.Lock()
+	if cc.closed || cc.closing || cc.goAway != nil {
+		cc.mu.Unlock()
+		return
+	}
+	cc.doNotReuse = true
+	active := len(cc.streams) + cc.streamsReserved
+	cc.mu.Unlock()
+
+	if VerboseLogs {
+		cc.vlogf("http2: Transport retiring conn %p after MaxConnLifetime (active=%v)", cc, active)
+	}
+	cc.t.connPool().MarkDead(cc)
+	if active == 0 {
+		cc.closeIfIdle()
+		return
+	}
+	if len(addrs) > 0 {
+		p.mu.Lock()
+		p.startReplacementDialsLocked(addrs)
+		p.mu.Unlock()
+	}
+}
+
 func (cc *ClientConn) closeConn() {
 	t := time.AfterFunc(250*time.Millisecond, cc.forceCloseConn)
 	defer t.Stop()
@@ -1110,6 +2149,15 @@ func (cc *ClientConn) isDoNotReuseAndIdle() bool {
 var shutdownEnterWaitStateHook = func() {}
 
 // Shutdown gracefully closes the client connection, waiting for running streams to complete.
+//
+// Shutdown stops the connection from taking new requests, and sends the
+// server a GOAWAY frame. Requests waiting for a stream to become
+// available fail, and are retried on another connection by the
+// Transport. When the running streams have completed, Shutdown closes
+// the connection and returns nil.
+//
+// If ctx is done first, Shutdown returns its error, leaving the
+// connection's running streams to complete. Call Close to end them.
 func (cc *ClientConn) Shutdown(ctx context.Context) error {
 	if err := cc.sendGoAway(); err != nil {
 		return err
@@ -1153,6 +2201,8 @@ func (cc *ClientConn) sendGoAway() error {
 	closing := cc.closing
 	cc.closing = true
 	maxStreamID := cc.nextStreamID
+	// Wake requests waiting for a stream, which can no longer be sent.
+	cc.cond.Broadcast()
 	cc.mu.Unlock()
 	if closing {
 		// GOAWAY sent already
@@ -1196,7 +2246,7 @@ func (cc *ClientConn) Close() error {
 
 // closes the client connection immediately. In-flight requests are interrupted.
 func (cc *ClientConn) closeForLostPing() {
-	err := errors.New("http2: client connection lost")
+	err := errClientConnLost
 	if f := cc.t.CountError; f != nil {
 		f("conn_close_lost_ping")
 	}
@@ -1207,6 +2257,16 @@ func (cc *ClientConn) closeForLostPing() {
 // exported. At least they'll be DeepEqual for h1-vs-h2 comparisons tests.
 var errRequestCanceled = errors.New("net/http: request canceled")
 
+// sendsDigest reports whether the request body is followed by a
+// Content-Digest trailer computed by the Transport.
+func (cc *ClientConn) sendsDigest(req *http.Request) bool {
+	if cc.t.ContentDigest == nil {
+		return false
+	}
+	_, ok := req.Trailer["Content-Digest"]
+	return !ok
+}
+
 func commaSeparatedTrailers(req *http.Request) (string, error) {
 	keys := make([]string, 0, len(req.Trailer))
 	for k := range req.Trailer {
@@ -1235,6 +2295,46 @@ func (cc *ClientConn) responseHeaderTimeout() time.Duration {
 	return 0
 }
 
+// RequestTimeouts are timeouts for a single request made with a Transport.
+// Use WithRequestTimeouts to apply them to a request's context.
+type RequestTimeouts struct {
+	// ResponseHeaderTimeout, if non-zero, is the amount of time to
+	// wait for the server's response headers after fully writing
+	// the request (including its body, if any). It overrides the
+	// ResponseHeaderTimeout of the net/http Transport, if any.
+	// A negative value disables the timeout.
+	ResponseHeaderTimeout time.Duration
+
+	// StreamIdleTimeout, if positive, is the maximum amount of time
+	// the request's stream may go without sending or receiving a
+	// frame before the request fails with a timeout error.
+	StreamIdleTimeout time.Duration
+}
+
+type requestTimeoutsKey struct{}
+
+// WithRequestTimeouts returns a new context based on ctx which
+// applies the given timeouts to requests made with a Transport,
+// overriding any Transport-wide settings.
+func WithRequestTimeouts(ctx context.Context, timeouts RequestTimeouts) context.Context {
+	return context.WithValue(ctx, requestTimeoutsKey{}, timeouts)
+}
+
+func contextRequestTimeouts(ctx context.Context) RequestTimeouts {
+	timeouts, _ := ctx.Value(requestTimeoutsKey{}).(RequestTimeouts)
+	return timeouts
+}
+
+var errStreamIdleTimeout error = &httpError{msg: "http2: timeout awaiting stream activity", timeout: true}
+
+// resetIdleTimer records activity on the stream,
+// postponing its stream idle timeout.
+func (cs *clientStream) resetIdleTimer() {
+	if cs.idleTimer != nil {
+		cs.idleTimer.Reset(cs.timeouts.StreamIdleTimeout)
+	}
+}
+
 // checkConnHeaders checks whether req has any invalid connection-level headers.
 // per RFC 7540 section 8.1.2.2: Connection-Specific Header Fields.
 // Certain headers are special-cased as okay but not transmitted later.
@@ -1266,8 +2366,9 @@ func actualContentLength(req *http.Request) int64 {
 
 func (cc *ClientConn) decrStreamReservations() {
 	cc.mu.Lock()
-	defer cc.mu.Unlock()
 	cc.decrStreamReservationsLocked()
+	cc.mu.Unlock()
+	cc.t.wakeConnWaiters()
 }
 
 func (cc *ClientConn) decrStreamReservationsLocked() {
@@ -1282,18 +2383,27 @@ func (cc *ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func (cc *ClientConn) roundTrip(req *http.Request, streamf func(*clientStream)) (*http.Response, error) {
 	ctx := req.Context()
+	reused := !atomic.CompareAndSwapUint32(&cc.reused, 0, 1)
+	traceGotConn(req, cc, reused)
 	cs := &clientStream{
 		cc:                   cc,
 		ctx:                  ctx,
 		reqCancel:            req.Cancel,
+		req:                  req,
 		isHead:               req.Method == "HEAD",
 		reqBody:              req.Body,
 		reqBodyContentLength: actualContentLength(req),
 		trace:                httptrace.ContextClientTrace(ctx),
+		timeouts:             contextRequestTimeouts(ctx),
+		interimRec:           contextInterimRecorder(ctx),
+		label:                RequestLabel(ctx),
+		fullDuplex:           contextFullDuplex(ctx),
+		priority:             cc.t.requestPriority(req),
 		peerClosed:           make(chan struct{}),
 		abort:                make(chan struct{}),
 		respHeaderRecv:       make(chan struct{}),
 		donec:                make(chan struct{}),
+		declBodyBytes:        -1,
 	}
 
 	// TODO(bradfitz): this is a copy of the logic in net/http. Unify somewhere?
@@ -1313,7 +2423,7 @@ func (cc *ClientConn) roundTrip(req *http.Request, streamf func(*clientStream))
 		// We don't request gzip if the request is for a range, since
 		// auto-decoding a portion of a gzipped document will just fail
 		// anyway. See https://golang.org/issue/8923
-		cs.requestedGzip = true
+		cs.reqEncoding = cc.t.acceptEncoding()
 	}
 
 	go cs.doRequest(req, streamf)
@@ -1331,7 +2441,7 @@ func (cc *ClientConn) roundTrip(req *http.Request, streamf func(*clientStream))
 
 	handleResponseHeaders := func() (*http.Response, error) {
 		res := cs.res
-		if res.StatusCode > 299 {
+		if res.StatusCode > 299 && !cs.fullDuplex {
 			// On error or status code 3xx, 4xx, 5xx, etc abort any
 			// ongoing write, assuming that the server doesn't care
 			// about our request body. If the server replied with 1xx or
@@ -1345,6 +2455,9 @@ func (cc *ClientConn) roundTrip(req *http.Request, streamf func(*clientStream))
 		}
 		res.Request = req
 		res.TLS = cc.tlsState
+		if cs.interimRec != nil {
+			cs.interimRec.record(req, cs.interim)
+		}
 		if res.Body == noBody && actualContentLength(req) == 0 {
 			// If there isn't a request or response body still being
 			// written, then wait for the stream to be closed before
@@ -1430,6 +2543,20 @@ func (cs *clientStream) writeRequest(req *http.Request, streamf func(*clientStre
 		return err
 	}
 
+	if isExtendedConnect(req) {
+		if err := cs.awaitExtendedConnect(); err != nil {
+			return err
+		}
+	}
+
+	if err := cs.acquireTotalStream(); err != nil {
+		return err
+	}
+
+	if err := cs.enterQueue(); err != nil {
+		return err
+	}
+
 	// Acquire the new-request lock by writing to reqHeaderMu.
 	// This lock guards the critical section covering allocating a new stream ID
 	// (requires mu) and creating the stream (requires wmu).
@@ -1439,9 +2566,14 @@ func (cs *clientStream) writeRequest(req *http.Request, streamf func(*clientStre
 	select {
 	case cc.reqHeaderMu <- struct{}{}:
 	case <-cs.reqCancel:
+		cs.leaveQueue()
 		return errRequestCanceled
 	case <-ctx.Done():
+		cs.leaveQueue()
 		return ctx.Err()
+	case <-cs.queueTimeout:
+		cs.leaveQueue()
+		return cs.queueTimeoutError()
 	}
 
 	cc.mu.Lock()
@@ -1449,11 +2581,18 @@ func (cs *clientStream) writeRequest(req *http.Request, streamf func(*clientStre
 		cc.idleTimer.Stop()
 	}
 	cc.decrStreamReservationsLocked()
-	if err := cc.awaitOpenSlotForStreamLocked(cs); err != nil {
+	err = cc.awaitOpenSlotForStreamLocked(cs)
+	cs.leaveQueueLocked()
+	if err != nil {
 		cc.mu.Unlock()
 		<-cc.reqHeaderMu
 		return err
 	}
+	if d := cs.timeouts.StreamIdleTimeout; d > 0 {
+		cs.idleTimer = cc.t.afterFunc(d, func() {
+			cs.abortStream(errStreamIdleTimeout)
+		})
+	}
 	cc.addStreamLocked(cs) // assigns stream ID
 	if isConnectionCloseRequest(req) {
 		cc.doNotReuse = true
@@ -1485,6 +2624,14 @@ func (cs *clientStream) writeRequest(req *http.Request, streamf func(*clientStre
 
 	hasBody := cs.reqBodyContentLength != 0
 	if !hasBody {
+		if len(req.Trailer) > 0 {
+			// The HEADERS frame was sent without END_STREAM,
+			// leaving the trailers to end the stream.
+			if err = cs.writeRequestTrailers(req, nil); err != nil {
+				traceWroteRequest(cs.trace, err)
+				return err
+			}
+		}
 		cs.sentEndStream = true
 	} else {
 		if continueTimeout != 0 {
@@ -1523,7 +2670,11 @@ func (cs *clientStream) writeRequest(req *http.Request, streamf func(*clientStre
 
 	var respHeaderTimer <-chan time.Time
 	var respHeaderRecv chan struct{}
-	if d := cc.responseHeaderTimeout(); d != 0 {
+	d := cc.responseHeaderTimeout()
+	if v := cs.timeouts.ResponseHeaderTimeout; v != 0 {
+		d = v
+	}
+	if d > 0 {
 		timer := cc.t.newTimer(d)
 		defer timer.Stop()
 		respHeaderTimer = timer.C()
@@ -1569,6 +2720,13 @@ func (cs *clientStream) encodeAndWriteHeaders(req *http.Request) error {
 	default:
 	}
 
+	// Reject a self-dependent priority before encoding: once the
+	// header block is encoded the HPACK state has changed, and it
+	// must then be sent to keep the peer's decoder in sync.
+	if cs.priority.StreamDep == cs.ID {
+		return errPrioritySelfDep
+	}
+
 	// Encode headers.
 	//
 	// we send: HEADERS{1}, CONTINUATION{0,} + DATA{0,} (DATA is
@@ -1578,10 +2736,16 @@ func (cs *clientStream) encodeAndWriteHeaders(req *http.Request) error {
 	if err != nil {
 		return err
 	}
-	hasTrailers := trailers != ""
 	contentLen := actualContentLength(req)
 	hasBody := contentLen != 0
-	hdrs, err := cc.encodeHeaders(req, cs.requestedGzip, trailers, contentLen)
+	if hasBody && cc.sendsDigest(req) {
+		if trailers != "" {
+			trailers += ","
+		}
+		trailers += "Content-Digest"
+	}
+	hasTrailers := trailers != ""
+	hdrs, err := cc.encodeHeaders(req, cs.reqEncoding, trailers, contentLen)
 	if err != nil {
 		return err
 	}
@@ -1589,7 +2753,7 @@ func (cs *clientStream) encodeAndWriteHeaders(req *http.Request) error {
 	// Write the request.
 	endStream := !hasBody && !hasTrailers
 	cs.sentHeaders = true
-	err = cc.writeHeaders(cs.ID, endStream, int(cc.maxFrameSize), hdrs)
+	err = cc.writeHeaders(cs.ID, endStream, int(cc.maxFrameSize), cs.priority, hdrs)
 	traceWroteHeaders(cs.trace)
 	return err
 }
@@ -1605,6 +2769,9 @@ func (cs *clientStream) cleanupWriteRequest(err error) {
 		// We were canceled before creating the stream, so return our reservation.
 		cc.decrStreamReservations()
 	}
+	if cs.idleTimer != nil {
+		cs.idleTimer.Stop()
+	}
 
 	// TODO: write h12Compare test showing whether
 	// Request.Body is closed by the Transport,
@@ -1649,7 +2816,11 @@ func (cs *clientStream) cleanupWriteRequest(err error) {
 		}
 		cs.bufPipe.CloseWithError(err) // no-op if already closed
 	} else {
-		if cs.sentHeaders && !cs.sentEndStream {
+		cc.mu.Lock()
+		peerStoppedBody := cs.peerStoppedBody
+		cc.mu.Unlock()
+		// Don't reset a stream the peer has already reset.
+		if cs.sentHeaders && !cs.sentEndStream && !peerStoppedBody {
 			cc.writeStreamReset(cs.ID, ErrCodeNo, nil)
 		}
 		cs.bufPipe.CloseWithError(errRequestCanceled)
@@ -1665,6 +2836,7 @@ func (cs *clientStream) cleanupWriteRequest(err error) {
 		cc.Close()
 	}
 
+	cs.releaseTotalStream()
 	close(cs.donec)
 }
 
@@ -1677,9 +2849,14 @@ func (cc *ClientConn) awaitOpenSlotForStreamLocked(cs *clientStream) error {
 			return errClientConnUnusable
 		}
 		cc.lastIdle = time.Time{}
-		if int64(len(cc.streams)) < int64(cc.maxConcurrentStreams) {
+		if int64(len(cc.streams)-cc.pushStreams) < int64(cc.maxConcurrentStreams) {
 			return nil
 		}
+		select {
+		case <-cs.queueTimeout:
+			return cs.queueTimeoutError()
+		default:
+		}
 		cc.pendingRequests++
 		cc.cond.Wait()
 		cc.pendingRequests--
@@ -1692,12 +2869,16 @@ func (cc *ClientConn) awaitOpenSlotForStreamLocked(cs *clientStream) error {
 }
 
 // requires cc.wmu be held
-func (cc *ClientConn) writeHeaders(streamID uint32, endStream bool, maxFrameSize int, hdrs []byte) error {
+func (cc *ClientConn) writeHeaders(streamID uint32, endStream bool, maxFrameSize int, priority PriorityParam, hdrs []byte) error {
 	first := true // first frame written (HEADERS is first, then CONTINUATION)
 	for len(hdrs) > 0 && cc.werr == nil {
 		chunk := hdrs
-		if len(chunk) > maxFrameSize {
-			chunk = chunk[:maxFrameSize]
+		size := maxFrameSize
+		if first && !priority.IsZero() {
+			size -= 5 // stream dependency and weight
+		}
+		if len(chunk) > size {
+			chunk = chunk[:size]
 		}
 		hdrs = hdrs[len(chunk):]
 		endHeaders := len(hdrs) == 0
@@ -1707,6 +2888,7 @@ func (cc *ClientConn) writeHeaders(streamID uint32, endStream bool, maxFrameSize
 				BlockFragment: chunk,
 				EndStream:     endStream,
 				EndHeaders:    endHeaders,
+				Priority:      priority,
 			})
 			first = false
 		} else {
@@ -1725,7 +2907,8 @@ var (
 	// abort request body write, but send stream reset of cancel.
 	errStopReqBodyWriteAndCancel = errors.New("http2: canceling request")
 
-	errReqBodyTooLong = errors.New("http2: request body larger than specified content length")
+	errReqBodyTooLong  = errors.New("http2: request body larger than specified content length")
+	errReqBodyTooShort = errors.New("http2: request body shorter than specified content length")
 )
 
 // frameScratchBufferLen returns the length of a buffer to use for
@@ -1779,7 +2962,11 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 	body := cs.reqBody
 	sentEnd := false // whether we sent the final DATA frame w/ END_STREAM
 
-	hasTrailers := req.Trailer != nil
+	var digest hash.Hash
+	if cc.sendsDigest(req) {
+		digest = cc.t.ContentDigest.New()
+	}
+	hasTrailers := req.Trailer != nil || digest != nil
 	remainLen := cs.reqBodyContentLength
 	hasContentLen := remainLen != -1
 
@@ -1830,12 +3017,21 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 			case bodyClosed:
 				return errStopReqBodyWrite
 			case err == io.EOF:
+				if remainLen > 0 {
+					// The body ended before its declared
+					// Content-Length. Don't end the stream, which
+					// would send a truncated request.
+					return errReqBodyTooShort
+				}
 				sawEOF = true
 				err = nil
 			default:
 				return err
 			}
 		}
+		if digest != nil {
+			digest.Write(buf[:n])
+		}
 
 		remain := buf[:n]
 		for len(remain) > 0 && err == nil {
@@ -1849,6 +3045,7 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 			remain = remain[allowed:]
 			sentEnd = sawEOF && len(remain) == 0 && !hasTrailers
 			err = cc.fr.WriteData(cs.ID, sentEnd, data)
+			cs.resetIdleTimer()
 			if err == nil {
 				// TODO(bradfitz): this flush is for latency, not bandwidth.
 				// Most requests won't need this. Make this opt-in or
@@ -1872,6 +3069,18 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 		return nil
 	}
 
+	return cs.writeRequestTrailers(req, digest)
+}
+
+// writeRequestTrailers ends the request stream, sending the request's
+// trailers, and the Content-Digest of the body if digest is non-nil,
+// in a HEADERS frame, or an empty DATA frame if there are none.
+func (cs *clientStream) writeRequestTrailers(req *http.Request, digest hash.Hash) (err error) {
+	cc := cs.cc
+	cc.mu.Lock()
+	maxFrameSize := int(cc.maxFrameSize)
+	cc.mu.Unlock()
+
 	// Since the RoundTrip contract permits the caller to "mutate or reuse"
 	// a request after the Response's Body is closed, verify that this hasn't
 	// happened before accessing the trailers.
@@ -1883,6 +3092,14 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 		return err
 	}
 
+	if digest != nil {
+		trailer = trailer.Clone()
+		if trailer == nil {
+			trailer = make(http.Header)
+		}
+		trailer.Set("Content-Digest", cc.t.ContentDigest.field(digest))
+	}
+
 	cc.wmu.Lock()
 	defer cc.wmu.Unlock()
 	var trls []byte
@@ -1896,7 +3113,7 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 	// Two ways to send END_STREAM: either with trailers, or
 	// with an empty DATA frame.
 	if len(trls) > 0 {
-		err = cc.writeHeaders(cs.ID, true, maxFrameSize, trls)
+		err = cc.writeHeaders(cs.ID, true, maxFrameSize, PriorityParam{}, trls)
 	} else {
 		err = cc.fr.WriteData(cs.ID, true, nil)
 	}
@@ -1913,6 +3130,14 @@ func (cs *clientStream) writeRequestBody(req *http.Request) (err error) {
 func (cs *clientStream) awaitFlowControl(maxBytes int) (taken int32, err error) {
 	cc := cs.cc
 	ctx := cs.ctx
+	var stall *FlowControlStall
+	defer func() {
+		// Report a stall after releasing cc.mu.
+		if stall != nil {
+			stall.Duration = cc.t.now().Sub(stall.Start)
+			cc.reportStalls(stall)
+		}
+	}()
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
 	for {
@@ -1943,6 +3168,15 @@ func (cs *clientStream) awaitFlowControl(maxBytes int) (taken int32, err error)
 			cs.flow.take(take)
 			return take, nil
 		}
+		if stall == nil && cc.t.FlowControlStallFunc != nil {
+			stall = &FlowControlStall{
+				StreamID: cs.ID,
+				Label:    cs.label,
+				Conn:     cc.flow.n <= 0,
+				Cause:    FlowControlStallPeer,
+				Start:    cc.t.now(),
+			}
+		}
 		cc.cond.Wait()
 	}
 }
@@ -1966,7 +3200,7 @@ func validateHeaders(hdrs http.Header) string {
 var errNilRequestURL = errors.New("http2: Request.URI is nil")
 
 // requires cc.wmu be held.
-func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trailers string, contentLength int64) ([]byte, error) {
+func (cc *ClientConn) encodeHeaders(req *http.Request, acceptEncoding string, trailers string, contentLength int64) ([]byte, error) {
 	cc.hbuf.Reset()
 	if req.URL == nil {
 		return nil, errNilRequestURL
@@ -1980,13 +3214,25 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 	if err != nil {
 		return nil, err
 	}
-	if !httpguts.ValidHostHeader(host) {
-		return nil, errors.New("http2: invalid Host header")
-	}
 
+	extendedConnect := isExtendedConnect(req)
 	var path string
-	if req.Method != "CONNECT" {
+	if req.Method != "CONNECT" || extendedConnect {
 		path = req.URL.RequestURI()
+	}
+	if cc.strictAuth {
+		m := req.Method
+		if m == "" {
+			m = http.MethodGet
+		}
+		if err := checkAuthority(m, host, req.Header.Get("Host"), path); err != nil {
+			return nil, err
+		}
+	}
+	if !httpguts.ValidHostHeader(host) {
+		return nil, errors.New("http2: invalid Host header")
+	}
+	if req.Method != "CONNECT" || extendedConnect {
 		if !validPseudoPath(path) {
 			orig := path
 			path = strings.TrimPrefix(path, req.URL.Scheme+"://"+host)
@@ -2003,13 +3249,27 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 	// Check for any invalid headers+trailers and return an error before we
 	// potentially pollute our hpack state. (We want to be able to
 	// continue to reuse the hpack encoder for future requests)
-	if err := validateHeaders(req.Header); err != "" {
+	hdrs := req.Header
+	if extendedConnect {
+		if !validProtocol(hdrs[":protocol"]) {
+			return nil, errInvalidProtocol
+		}
+		// Validate the :protocol pseudo-header separately.
+		hdrs = hdrs.Clone()
+		delete(hdrs, ":protocol")
+	}
+	if err := validateHeaders(hdrs); err != "" {
 		return nil, fmt.Errorf("invalid HTTP header %s", err)
 	}
 	if err := validateHeaders(req.Trailer); err != "" {
 		return nil, fmt.Errorf("invalid HTTP trailer %s", err)
 	}
 
+	priority, err := requestExtensiblePriority(req)
+	if err != nil {
+		return nil, err
+	}
+
 	enumerateHeaders := func(f func(name, value string)) {
 		// 8.1.2.3 Request Pseudo-Header Fields
 		// The :path pseudo-header field includes the path and query parts of the
@@ -2022,7 +3282,10 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 			m = http.MethodGet
 		}
 		f(":method", m)
-		if req.Method != "CONNECT" {
+		if extendedConnect {
+			f(":protocol", req.Header.Get(":protocol"))
+		}
+		if req.Method != "CONNECT" || extendedConnect {
 			f(":path", path)
 			f(":scheme", req.URL.Scheme)
 		}
@@ -2032,9 +3295,10 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 
 		var didUA bool
 		for k, vv := range req.Header {
-			if asciiEqualFold(k, "host") || asciiEqualFold(k, "content-length") {
+			if asciiEqualFold(k, "host") || asciiEqualFold(k, "content-length") || k == ":protocol" {
 				// Host is :authority, already sent.
 				// Content-Length is automatic, set below.
+				// :protocol is sent above, for Extended CONNECT.
 				continue
 			} else if asciiEqualFold(k, "connection") ||
 				asciiEqualFold(k, "proxy-connection") ||
@@ -2091,12 +3355,15 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 		if shouldSendReqContentLength(req.Method, contentLength) {
 			f("content-length", strconv.FormatInt(contentLength, 10))
 		}
-		if addGzipHeader {
-			f("accept-encoding", "gzip")
+		if acceptEncoding != "" {
+			f("accept-encoding", acceptEncoding)
 		}
 		if !didUA {
 			f("user-agent", defaultUserAgent)
 		}
+		if priority != "" {
+			f("priority", priority)
+		}
 	}
 
 	// Do a first pass over the headers counting bytes to ensure
@@ -2116,6 +3383,11 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 	trace := httptrace.ContextClientTrace(req.Context())
 	traceHeaders := traceHasWroteHeaderField(trace)
 
+	var casing map[string]string
+	if cc.t.preserveHeaderCase() {
+		casing = headerCasing(req.Header)
+	}
+
 	// Header list size is ok. Write the headers.
 	enumerateHeaders(func(name, value string) {
 		name, ascii := lowerHeader(name)
@@ -2124,12 +3396,16 @@ func (cc *ClientConn) encodeHeaders(req *http.Request, addGzipHeader bool, trail
 			// field names have to be ASCII characters (just as in HTTP/1.x).
 			return
 		}
+		if wire, ok := casing[name]; ok {
+			name = wire
+		}
 		cc.writeHeader(name, value)
 		if traceHeaders {
 			traceWroteHeaderField(trace, name, value)
 		}
 	})
 
+	cc.hpackStats.encoded(cc.henc)
 	return cc.hbuf.Bytes(), nil
 }
 
@@ -2177,12 +3453,16 @@ func (cc *ClientConn) encodeTrailers(trailer http.Header) ([]byte, error) {
 			// field names have to be ASCII characters (just as in HTTP/1.x).
 			continue
 		}
+		if cc.t.preserveHeaderCase() {
+			lowKey = k
+		}
 		// Transfer-Encoding, etc.. have already been filtered at the
 		// start of RoundTrip
 		for _, v := range vv {
 			cc.writeHeader(lowKey, v)
 		}
 	}
+	cc.hpackStats.encoded(cc.henc)
 	return cc.hbuf.Bytes(), nil
 }
 
@@ -2190,7 +3470,7 @@ func (cc *ClientConn) writeHeader(name, value string) {
 	if VerboseLogs {
 		log.Printf("http2: Transport encoding header %q = %q", name, value)
 	}
-	cc.henc.WriteField(hpack.HeaderField{Name: name, Value: value})
+	cc.henc.writeField(hpack.HeaderField{Name: name, Value: value})
 }
 
 type resAndError struct {
@@ -2203,10 +3483,12 @@ type resAndError struct {
 func (cc *ClientConn) addStreamLocked(cs *clientStream) {
 	cs.flow.add(int32(cc.initialWindowSize))
 	cs.flow.setConnFlow(&cc.flow)
-	cs.inflow.init(transportDefaultStreamFlow)
+	cs.inflow.init(cc.streamInflowWindow)
+	cs.inflow.policy = cc.t.FlowControl
 	cs.ID = cc.nextStreamID
 	cc.nextStreamID += 2
 	cc.streams[cs.ID] = cs
+	cc.setStreamLabel(cs.ID, cs.label)
 	if cs.ID == 0 {
 		panic("assigned stream ID 0")
 	}
@@ -2219,6 +3501,10 @@ func (cc *ClientConn) forgetStreamID(id uint32) {
 	if len(cc.streams) != slen-1 {
 		panic("forgetting unknown stream id")
 	}
+	cc.forgetStreamLabel(id)
+	if id%2 == 0 {
+		cc.pushStreams--
+	}
 	cc.lastActive = time.Now()
 	if len(cc.streams) == 0 && cc.idleTimer != nil {
 		cc.idleTimer.Reset(cc.idleTimeout)
@@ -2238,12 +3524,23 @@ func (cc *ClientConn) forgetStreamID(id uint32) {
 	}
 
 	cc.mu.Unlock()
+	cc.t.wakeConnWaiters()
 }
 
 // clientConnReadLoop is the state owned by the clientConn's frame-reading readLoop.
 type clientConnReadLoop struct {
 	_  incomparable
 	cc *ClientConn
+
+	// needFlush is set when frames written in response to received
+	// frames (WINDOW_UPDATE, PING acks) may be buffered in cc.bw.
+	// They are flushed once cc.br no longer holds a complete frame,
+	// so a burst of small frames results in one flush rather than one
+	// per frame, and the peer is never left waiting for a reply while
+	// we block reading the rest of a frame.
+	needFlush bool
+
+	lastPushID uint32 // highest stream ID promised by the server
 }
 
 // readLoop runs in its own goroutine and reads and dispatches frames.
@@ -2289,6 +3586,9 @@ func (rl *clientConnReadLoop) cleanup() {
 	if cc.idleTimer != nil {
 		cc.idleTimer.Stop()
 	}
+	if cc.lifetimeTimer != nil {
+		cc.lifetimeTimer.Stop()
+	}
 
 	// Close any response bodies if the server closes prematurely.
 	// TODO: also do this if we've written the headers but not
@@ -2316,7 +3616,18 @@ func (rl *clientConnReadLoop) cleanup() {
 		}
 	}
 	cc.cond.Broadcast()
+	goAway, active := cc.goAway, len(cc.streams)
 	cc.mu.Unlock()
+
+	if fn := cc.t.ConnDrain; fn != nil && goAway != nil {
+		fn(ConnDrainInfo{
+			Conn:          cc,
+			ErrCode:       goAway.ErrCode,
+			LastStreamID:  goAway.LastStreamID,
+			StreamsActive: active,
+			Done:          true,
+		})
+	}
 }
 
 // countReadFrameError calls Transport.CountError with a string
@@ -2355,6 +3666,9 @@ func (rl *clientConnReadLoop) run() error {
 		t = cc.t.afterFunc(readIdleTimeout, cc.healthCheck)
 	}
 	for {
+		if rl.needFlush && !rl.frameBuffered() {
+			rl.flush()
+		}
 		f, err := cc.fr.ReadFrame()
 		if t != nil {
 			t.Reset(readIdleTimeout)
@@ -2377,6 +3691,9 @@ func (rl *clientConnReadLoop) run() error {
 		if VerboseLogs {
 			cc.vlogf("http2: Transport received %s", summarizeFrame(f))
 		}
+		if cc.t.FrameEvent != nil {
+			cc.frameEvent(f.Header(), false)
+		}
 		if !gotSettings {
 			if _, ok := f.(*SettingsFrame); !ok {
 				cc.logf("protocol error: received %T before a SETTINGS frame", f)
@@ -2397,11 +3714,21 @@ func (rl *clientConnReadLoop) run() error {
 		case *SettingsFrame:
 			err = rl.processSettings(f)
 		case *PushPromiseFrame:
+			// Not decoded, so PushHandler is nil.
+			err = rl.refusePushPromise()
+		case *metaPushPromiseFrame:
 			err = rl.processPushPromise(f)
 		case *WindowUpdateFrame:
 			err = rl.processWindowUpdate(f)
 		case *PingFrame:
 			err = rl.processPing(f)
+		case *OriginFrame:
+			err = rl.processOrigin(f)
+		case *AltSvcFrame:
+			err = rl.processAltSvc(f)
+		case *PriorityUpdateFrame:
+			// RFC 9218, Section 7.1: Servers must not send PRIORITY_UPDATE.
+			err = ConnectionError(ErrCodeProtocol)
 		default:
 			cc.logf("Transport: unhandled response frame type %T", f)
 		}
@@ -2414,7 +3741,32 @@ func (rl *clientConnReadLoop) run() error {
 	}
 }
 
+// frameBuffered reports whether cc.br holds a complete frame,
+// which can be read without blocking.
+func (rl *clientConnReadLoop) frameBuffered() bool {
+	br := rl.cc.br
+	if br.Buffered() < frameHeaderLen {
+		return false
+	}
+	hdr, _ := br.Peek(frameHeaderLen)
+	n := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+	return br.Buffered() >= frameHeaderLen+n
+}
+
+// flush flushes frames buffered by the read loop,
+// if they have not already been written.
+func (rl *clientConnReadLoop) flush() {
+	cc := rl.cc
+	cc.wmu.Lock()
+	if cc.bw.Buffered() > 0 {
+		cc.bw.Flush()
+	}
+	cc.wmu.Unlock()
+	rl.needFlush = false
+}
+
 func (rl *clientConnReadLoop) processHeaders(f *MetaHeadersFrame) error {
+	rl.cc.hpackStats.decoded(rl.cc.fr)
 	cs := rl.streamByID(f.StreamID)
 	if cs == nil {
 		// We'd get here if we canceled a request while the
@@ -2430,6 +3782,7 @@ func (rl *clientConnReadLoop) processHeaders(f *MetaHeadersFrame) error {
 		})
 		return nil
 	}
+	cs.resetIdleTimer()
 	if !cs.firstByte {
 		if cs.trace != nil {
 			// TODO(bradfitz): move first response byte earlier,
@@ -2529,6 +3882,10 @@ func (rl *clientConnReadLoop) handleResponse(cs *clientStream, f *MetaHeadersFra
 		}
 	}
 
+	if vv := header["Alt-Svc"]; len(vv) > 0 && cs.req.URL.Scheme == "https" {
+		cs.cc.t.recordAltSvc(authorityAddr("https", cs.req.URL.Host), strings.Join(vv, ","))
+	}
+
 	if statusCode >= 100 && statusCode <= 199 {
 		if f.StreamEnded() {
 			return nil, errors.New("1xx informational response with END_STREAM flag")
@@ -2538,10 +3895,8 @@ func (rl *clientConnReadLoop) handleResponse(cs *clientStream, f *MetaHeadersFra
 		if cs.num1xx > max1xxResponses {
 			return nil, errors.New("http2: too many 1xx informational responses")
 		}
-		if fn := cs.get1xxTraceFunc(); fn != nil {
-			if err := fn(statusCode, textproto.MIMEHeader(header)); err != nil {
-				return nil, err
-			}
+		if err := cs.got1xx(statusCode, header, f.PseudoFields()); err != nil {
+			return nil, err
 		}
 		if statusCode == 100 {
 			traceGot100Continue(cs.trace)
@@ -2585,18 +3940,44 @@ func (rl *clientConnReadLoop) handleResponse(cs *clientStream, f *MetaHeadersFra
 
 	cs.bufPipe.setBuffer(&dataBuffer{expected: res.ContentLength})
 	cs.bytesRemain = res.ContentLength
+	cs.declBodyBytes = res.ContentLength
 	res.Body = transportResponseBody{cs}
 
-	if cs.requestedGzip && asciiEqualFold(res.Header.Get("Content-Encoding"), "gzip") {
-		res.Header.Del("Content-Encoding")
-		res.Header.Del("Content-Length")
-		res.ContentLength = -1
-		res.Body = &gzipReader{body: res.Body}
-		res.Uncompressed = true
+	if ce := res.Header.Get("Content-Encoding"); cs.reqEncoding != "" && ce != "" {
+		var body io.ReadCloser
+		if newReader := cs.cc.t.decompressor(ce); newReader != nil {
+			body = &decompressReader{body: res.Body, newReader: newReader}
+		} else if asciiEqualFold(ce, "gzip") {
+			body = &gzipReader{body: res.Body}
+		}
+		if body != nil {
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+			res.ContentLength = -1
+			res.Body = body
+			res.Uncompressed = true
+		}
 	}
 	return res, nil
 }
 
+// got1xx delivers an interim response to the request's
+// httptrace.ClientTrace.Got1xxResponse hook, and records it for
+// InterimResponses if the request asked for that.
+func (cs *clientStream) got1xx(statusCode int, header http.Header, pseudo []hpack.HeaderField) error {
+	if cs.interimRec != nil {
+		cs.interim = append(cs.interim, InterimResponse{
+			StatusCode:   statusCode,
+			Header:       header,
+			PseudoFields: append([]hpack.HeaderField(nil), pseudo...),
+		})
+	}
+	if fn := cs.get1xxTraceFunc(); fn != nil {
+		return fn(statusCode, textproto.MIMEHeader(header))
+	}
+	return nil
+}
+
 func (rl *clientConnReadLoop) processTrailers(cs *clientStream, f *MetaHeadersFrame) error {
 	if cs.pastTrailers {
 		// Too many HEADERS frames for this stream.
@@ -2621,6 +4002,19 @@ func (rl *clientConnReadLoop) processTrailers(cs *clientStream, f *MetaHeadersFr
 	}
 	cs.trailer = trailer
 
+	if d := rl.cc.t.ContentDigest; d != nil {
+		if vv := trailer["Content-Digest"]; len(vv) > 0 {
+			h := cs.digest
+			if h == nil {
+				h = d.New() // empty body
+			}
+			if err := d.verify(vv, h); err != nil {
+				rl.endStreamError(cs, err)
+				return nil
+			}
+		}
+	}
+
 	rl.endStream(cs)
 	return nil
 }
@@ -2632,30 +4026,79 @@ type transportResponseBody struct {
 }
 
 func (b transportResponseBody) Read(p []byte) (n int, err error) {
+	n, err = b.read(p)
+	b.returnFlow(n, err)
+	return n, err
+}
+
+// WriteTo implements io.WriterTo, so io.Copy writes data to w directly
+// from the stream's receive buffer rather than copying it into a buffer
+// of its own first. Flow control for the data is returned to the server
+// only after w has consumed it.
+func (b transportResponseBody) WriteTo(w io.Writer) (written int64, err error) {
+	cs := b.cs
+	for {
+		if cs.readErr != nil {
+			return written, cs.readErr
+		}
+		p, chunk, rerr := cs.bufPipe.readChunk()
+		n := len(p)
+		rerr = b.consumed(n, rerr)
+		if n > 0 {
+			nw, werr := w.Write(p)
+			putDataBufferChunk(chunk)
+			written += int64(nw)
+			b.returnFlow(n, rerr)
+			if werr == nil && nw != n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// read reads response body data into p,
+// without returning flow control to the server.
+func (b transportResponseBody) read(p []byte) (n int, err error) {
 	cs := b.cs
-	cc := cs.cc
 
 	if cs.readErr != nil {
 		return 0, cs.readErr
 	}
 	n, err = b.cs.bufPipe.Read(p)
+	return n, b.consumed(n, err)
+}
+
+// consumed accounts for n bytes of response body data removed from the
+// stream's buffer, and returns the error the read should report.
+func (b transportResponseBody) consumed(n int, err error) error {
+	cs := b.cs
 	if cs.bytesRemain != -1 {
-		if int64(n) > cs.bytesRemain {
-			n = int(cs.bytesRemain)
-			if err == nil {
-				err = errors.New("net/http: server replied with more than declared Content-Length; truncated")
-				cs.abortStream(err)
-			}
-			cs.readErr = err
-			return int(cs.bytesRemain), err
-		}
+		// The read loop doesn't buffer data past the declared
+		// Content-Length; see ContentLengthPolicy.
 		cs.bytesRemain -= int64(n)
 		if err == io.EOF && cs.bytesRemain > 0 {
 			err = io.ErrUnexpectedEOF
 			cs.readErr = err
-			return n, err
 		}
 	}
+	return err
+}
+
+// returnFlow returns flow control for n bytes of response body data
+// which have been consumed. The err is the error returned by the read.
+func (b transportResponseBody) returnFlow(n int, err error) {
+	cs := b.cs
+	cc := cs.cc
+
 	if n == 0 {
 		// No flow control tokens to send back.
 		return
@@ -2667,7 +4110,10 @@ func (b transportResponseBody) Read(p []byte) (n int, err error) {
 	if err == nil { // No need to refresh if the stream is over or failed.
 		streamAdd = cs.inflow.add(n)
 	}
+	connStall := cc.endLocalStall(&cc.inflowStalled, nil, connAdd)
+	streamStall := cc.endLocalStall(&cs.inflowStalled, cs, streamAdd)
 	cc.mu.Unlock()
+	cc.reportStalls(connStall, streamStall)
 
 	if connAdd != 0 || streamAdd != 0 {
 		cc.wmu.Lock()
@@ -2680,24 +4126,40 @@ func (b transportResponseBody) Read(p []byte) (n int, err error) {
 		}
 		cc.bw.Flush()
 	}
-	return
 }
 
 var errClosedResponseBody = errors.New("http2: response body closed")
 
 func (b transportResponseBody) Close() error {
+	return b.closeWithError(errClosedResponseBody)
+}
+
+// closeWithError closes the body, aborting the stream with err if the
+// response is not done.
+func (b transportResponseBody) closeWithError(err error) error {
 	cs := b.cs
 	cc := cs.cc
 
-	cs.bufPipe.BreakWithError(errClosedResponseBody)
-	cs.abortStream(errClosedResponseBody)
+	if cs.interimRec != nil {
+		cs.interimRec.forget(cs.req)
+	}
+
+	cs.bufPipe.BreakWithError(err)
+	// A full-duplex request keeps sending its body after
+	// the response is complete.
+	duplexWrite := cs.fullDuplex && cs.responseDone()
+	if !duplexWrite {
+		cs.abortStream(err)
+	}
 
 	unread := cs.bufPipe.Len()
 	if unread > 0 {
 		cc.mu.Lock()
 		// Return connection-level flow control.
 		connAdd := cc.inflow.add(unread)
+		stall := cc.endLocalStall(&cc.inflowStalled, nil, connAdd)
 		cc.mu.Unlock()
+		cc.reportStalls(stall)
 
 		// TODO(dneil): Acquiring this mutex can block indefinitely.
 		// Move flow control return to a goroutine?
@@ -2710,6 +4172,9 @@ func (b transportResponseBody) Close() error {
 		cc.wmu.Unlock()
 	}
 
+	if duplexWrite {
+		return nil
+	}
 	select {
 	case <-cs.donec:
 	case <-cs.ctx.Done():
@@ -2731,6 +4196,10 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 		cc.mu.Lock()
 		neverSent := cc.nextStreamID
 		cc.mu.Unlock()
+		if f.StreamID%2 == 0 {
+			// Server-initiated streams are opened by PUSH_PROMISE.
+			neverSent = rl.lastPushID + 2
+		}
 		if f.StreamID >= neverSent {
 			// We never asked for this.
 			cc.logf("http2: Transport received unsolicited DATA frame; closing connection")
@@ -2746,15 +4215,17 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 			cc.mu.Lock()
 			ok := cc.inflow.take(f.Length)
 			connAdd := cc.inflow.add(int(f.Length))
+			stall := cc.endLocalStall(&cc.inflowStalled, nil, connAdd)
 			cc.mu.Unlock()
+			cc.reportStalls(stall)
 			if !ok {
 				return ConnectionError(ErrCodeFlowControl)
 			}
 			if connAdd > 0 {
 				cc.wmu.Lock()
 				cc.fr.WriteWindowUpdate(0, uint32(connAdd))
-				cc.bw.Flush()
 				cc.wmu.Unlock()
+				rl.needFlush = true
 			}
 		}
 		return nil
@@ -2767,6 +4238,7 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 		})
 		return nil
 	}
+	cs.resetIdleTimer()
 	if !cs.pastHeaders {
 		cc.logf("protocol error: received DATA before a HEADERS frame")
 		rl.endStreamError(cs, StreamError{
@@ -2784,20 +4256,28 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 			})
 			return nil
 		}
+		// Cut off any data past the declared Content-Length.
+		var tooLong *ContentLengthError
+		if cs.declBodyBytes != -1 && cs.bodyBytes+int64(len(data)) > cs.declBodyBytes {
+			tooLong = &ContentLengthError{StreamID: cs.ID, Declared: cs.declBodyBytes}
+			data = data[:cs.declBodyBytes-cs.bodyBytes]
+		}
+		cs.bodyBytes += int64(len(data))
 		// Check connection-level flow control.
 		cc.mu.Lock()
 		if !takeInflows(&cc.inflow, &cs.inflow, f.Length) {
 			cc.mu.Unlock()
 			return ConnectionError(ErrCodeFlowControl)
 		}
-		// Return any padded flow control now, since we won't
-		// refund it later on body reads.
+		sendBDPPing := cc.bdp != nil && cc.bdp.received(f.Length, cc.t.now())
+		// Return any padded or discarded flow control now,
+		// since we won't refund it later on body reads.
 		var refund int
 		if pad := int(f.Length) - len(data); pad > 0 {
 			refund += pad
 		}
 
-		didReset := false
+		didReset := tooLong != nil
 		var err error
 		if len(data) > 0 {
 			if _, err = cs.bufPipe.Write(data); err != nil {
@@ -2805,6 +4285,11 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 				// since data will never be read.
 				didReset = true
 				refund += len(data)
+			} else if d := cc.t.ContentDigest; d != nil {
+				if cs.digest == nil {
+					cs.digest = d.New()
+				}
+				cs.digest.Write(data)
 			}
 		}
 
@@ -2813,9 +4298,13 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 		if !didReset {
 			sendStream = cs.inflow.add(refund)
 		}
+		connStall := cc.endLocalStall(&cc.inflowStalled, nil, sendConn)
+		streamStall := cc.endLocalStall(&cs.inflowStalled, cs, sendStream)
+		cs.startLocalStalls(f.StreamEnded() || didReset)
 		cc.mu.Unlock()
+		cc.reportStalls(connStall, streamStall)
 
-		if sendConn > 0 || sendStream > 0 {
+		if sendConn > 0 || sendStream > 0 || sendBDPPing {
 			cc.wmu.Lock()
 			if sendConn > 0 {
 				cc.fr.WriteWindowUpdate(0, uint32(sendConn))
@@ -2823,14 +4312,21 @@ func (rl *clientConnReadLoop) processData(f *DataFrame) error {
 			if sendStream > 0 {
 				cc.fr.WriteWindowUpdate(cs.ID, uint32(sendStream))
 			}
-			cc.bw.Flush()
+			if sendBDPPing {
+				cc.fr.WritePing(false, bdpPingData)
+			}
 			cc.wmu.Unlock()
+			rl.needFlush = true
 		}
 
 		if err != nil {
 			rl.endStreamError(cs, err)
 			return nil
 		}
+		if tooLong != nil {
+			rl.endStreamTooLong(cs, tooLong)
+			return nil
+		}
 	}
 
 	if f.StreamEnded() {
@@ -2860,6 +4356,20 @@ func (rl *clientConnReadLoop) endStreamError(cs *clientStream, err error) {
 	cs.abortStream(err)
 }
 
+// endStreamTooLong ends a stream whose response body is longer than
+// its declared Content-Length, according to the ContentLengthPolicy.
+// The body has already been cut off at the declared length.
+func (rl *clientConnReadLoop) endStreamTooLong(cs *clientStream, err *ContentLengthError) {
+	if rl.cc.t.ContentLengthPolicy == ContentLengthTruncate {
+		rl.cc.logf("http2: Transport received response body longer than declared Content-Length of %v bytes; truncating", err.Declared)
+		cs.bufPipe.closeWithErrorAndCode(io.EOF, cs.copyTrailers)
+		rl.endStreamError(cs, StreamError{StreamID: cs.ID, Code: ErrCodeCancel, Cause: err})
+		return
+	}
+	cs.bufPipe.CloseWithError(err)
+	rl.endStreamError(cs, StreamError{StreamID: cs.ID, Code: ErrCodeProtocol, Cause: err})
+}
+
 func (rl *clientConnReadLoop) streamByID(id uint32) *clientStream {
 	rl.cc.mu.Lock()
 	defer rl.cc.mu.Unlock()
@@ -2882,6 +4392,12 @@ func (cs *clientStream) copyTrailers() {
 
 func (rl *clientConnReadLoop) processGoAway(f *GoAwayFrame) error {
 	cc := rl.cc
+	var addrs []string // pool keys, if the connection is to be replaced
+	if p, ok := cc.t.connPool().(*clientConnPool); ok && cc.t.ReplaceOnGoAway && f.ErrCode == ErrCodeNo {
+		p.mu.Lock()
+		addrs = append(addrs, p.keys[cc]...)
+		p.mu.Unlock()
+	}
 	cc.t.connPool().MarkDead(cc)
 	if f.ErrCode != 0 {
 		// TODO: deal with GOAWAY more. particularly the error code
@@ -2890,7 +4406,34 @@ func (rl *clientConnReadLoop) processGoAway(f *GoAwayFrame) error {
 			fn("recv_goaway_" + f.ErrCode.stringToken())
 		}
 	}
+	cc.mu.Lock()
+	first := cc.goAway == nil
+	cc.mu.Unlock()
 	cc.setGoAway(f)
+	if !first {
+		return nil
+	}
+	cc.mu.Lock()
+	active := len(cc.streams)
+	cc.mu.Unlock()
+	replaced := false
+	if len(addrs) > 0 && active > 0 {
+		// Dial a replacement now, so new requests need not wait for
+		// a dial once the draining connection stops taking them.
+		p := cc.t.connPool().(*clientConnPool)
+		p.mu.Lock()
+		replaced = p.startReplacementDialsLocked(addrs)
+		p.mu.Unlock()
+	}
+	if fn := cc.t.ConnDrain; fn != nil {
+		fn(ConnDrainInfo{
+			Conn:          cc,
+			ErrCode:       f.ErrCode,
+			LastStreamID:  f.LastStreamID,
+			StreamsActive: active,
+			Replaced:      replaced,
+		})
+	}
 	return nil
 }
 
@@ -2921,6 +4464,10 @@ func (rl *clientConnReadLoop) processSettingsNoWrite(f *SettingsFrame) error {
 			cc.wantSettingsAck = false
 			return nil
 		}
+		if cc.bdpSettingsAcks > 0 {
+			cc.bdpSettingsAcks--
+			return nil
+		}
 		return ConnectionError(ErrCodeProtocol)
 	}
 
@@ -2956,6 +4503,13 @@ func (rl *clientConnReadLoop) processSettingsNoWrite(f *SettingsFrame) error {
 		case SettingHeaderTableSize:
 			cc.henc.SetMaxDynamicTableSize(s.Val)
 			cc.peerMaxHeaderTableSize = s.Val
+		case SettingEnableConnectProtocol:
+			// RFC 8441, Section 3: A sender must not turn the
+			// setting off after turning it on.
+			if cc.extendedConnect && s.Val == 0 {
+				return ConnectionError(ErrCodeProtocol)
+			}
+			cc.extendedConnect = s.Val == 1
 		default:
 			cc.vlogf("Unhandled Setting: %v", s)
 		}
@@ -2974,6 +4528,7 @@ func (rl *clientConnReadLoop) processSettingsNoWrite(f *SettingsFrame) error {
 			cc.maxConcurrentStreams = defaultMaxConcurrentStreams
 		}
 		cc.seenSettings = true
+		close(cc.seenSettingsCh)
 	}
 
 	return nil
@@ -3023,15 +4578,35 @@ func (rl *clientConnReadLoop) processResetStream(f *RSTStreamFrame) error {
 	if fn := cs.cc.t.CountError; fn != nil {
 		fn("recv_rststream_" + f.ErrCode.stringToken())
 	}
+	if f.ErrCode == ErrCodeNo && cs.readClosed {
+		// RFC 9113, Section 8.1: A server can ask the client to stop
+		// sending a request body, without error, by resetting the
+		// stream with NO_ERROR after sending a complete response.
+		// The response is unaffected; just stop writing the body.
+		rl.cc.mu.Lock()
+		cs.peerStoppedBody = true
+		rl.cc.mu.Unlock()
+		cs.abortRequestBodyWrite()
+		return nil
+	}
 	cs.abortStream(serr)
 
 	cs.bufPipe.CloseWithError(serr)
 	return nil
 }
 
+// A clientPing is a PING sent by ClientConn.Ping, awaiting its
+// acknowledgement.
+type clientPing struct {
+	done   chan struct{} // closed when the PING is acknowledged
+	sentAt time.Time     // when the PING was written, or zero
+}
+
 // Ping sends a PING frame to the server and waits for the ack.
+// The round trip is recorded in the connection's RTT estimates;
+// see ClientConnState.SmoothedRTT.
 func (cc *ClientConn) Ping(ctx context.Context) error {
-	c := make(chan struct{})
+	ping := &clientPing{done: make(chan struct{})}
 	// Generate a random payload
 	var p [8]byte
 	for {
@@ -3041,7 +4616,7 @@ func (cc *ClientConn) Ping(ctx context.Context) error {
 		cc.mu.Lock()
 		// check for dup before insert
 		if _, found := cc.pings[p]; !found {
-			cc.pings[p] = c
+			cc.pings[p] = ping
 			cc.mu.Unlock()
 			break
 		}
@@ -3053,6 +4628,9 @@ func (cc *ClientConn) Ping(ctx context.Context) error {
 		cc.t.markNewGoroutine()
 		cc.wmu.Lock()
 		defer cc.wmu.Unlock()
+		cc.mu.Lock()
+		ping.sentAt = cc.t.now()
+		cc.mu.Unlock()
 		if pingError = cc.fr.WritePing(false, p); pingError != nil {
 			close(errc)
 			return
@@ -3063,7 +4641,7 @@ func (cc *ClientConn) Ping(ctx context.Context) error {
 		}
 	}()
 	select {
-	case <-c:
+	case <-ping.done:
 		return nil
 	case <-errc:
 		return pingError
@@ -3079,12 +4657,38 @@ func (rl *clientConnReadLoop) processPing(f *PingFrame) error {
 	if f.IsAck() {
 		cc := rl.cc
 		cc.mu.Lock()
-		defer cc.mu.Unlock()
+		if cc.bdp != nil && cc.bdp.pending && f.Data == bdpPingData {
+			now := cc.t.now()
+			rtt := cc.recordRTTLocked(now.Sub(cc.bdp.sentAt))
+			window := cc.bdp.acked(now)
+			var connIncr int32
+			if window > 0 {
+				connIncr = cc.growInflowWindowsLocked(window)
+			}
+			cc.mu.Unlock()
+			cc.reportRTT(rtt)
+			if window > 0 {
+				cc.wmu.Lock()
+				cc.fr.WriteSettings(Setting{ID: SettingInitialWindowSize, Val: uint32(window)})
+				if connIncr > 0 {
+					cc.fr.WriteWindowUpdate(0, uint32(connIncr))
+				}
+				cc.wmu.Unlock()
+				rl.needFlush = true
+			}
+			return nil
+		}
 		// If ack, notify listener if any
-		if c, ok := cc.pings[f.Data]; ok {
-			close(c)
+		var rtt *RTTSample
+		if ping, ok := cc.pings[f.Data]; ok {
+			if !ping.sentAt.IsZero() {
+				rtt = cc.recordRTTLocked(cc.t.now().Sub(ping.sentAt))
+			}
+			close(ping.done)
 			delete(cc.pings, f.Data)
 		}
+		cc.mu.Unlock()
+		cc.reportRTT(rtt)
 		return nil
 	}
 	cc := rl.cc
@@ -3093,10 +4697,32 @@ func (rl *clientConnReadLoop) processPing(f *PingFrame) error {
 	if err := cc.fr.WritePing(true, f.Data); err != nil {
 		return err
 	}
-	return cc.bw.Flush()
+	rl.needFlush = true
+	return nil
+}
+
+// growInflowWindowsLocked grows the inflow windows of all streams to
+// window bytes, and the conn-level inflow window if it is smaller.
+// The caller sends the new SETTINGS_INITIAL_WINDOW_SIZE, which grows
+// the peer's windows for open streams by the same amount, and a
+// WINDOW_UPDATE of the returned size for the connection.
+// cc.mu must be held.
+func (cc *ClientConn) growInflowWindowsLocked(window int32) (connIncr int32) {
+	delta := window - cc.streamInflowWindow
+	cc.streamInflowWindow = window
+	for _, cs := range cc.streams {
+		cs.inflow.avail += delta
+	}
+	cc.bdpSettingsAcks++
+	if cc.connInflowWindow < window {
+		connIncr = window - cc.connInflowWindow
+		cc.connInflowWindow = window
+		cc.inflow.avail += connIncr
+	}
+	return connIncr
 }
 
-func (rl *clientConnReadLoop) processPushPromise(f *PushPromiseFrame) error {
+func (rl *clientConnReadLoop) refusePushPromise() error {
 	// We told the peer we don't want them.
 	// Spec says:
 	// "PUSH_PROMISE MUST NOT be sent if the SETTINGS_ENABLE_PUSH
@@ -3198,6 +4824,41 @@ func (gz *gzipReader) Close() error {
 	return nil
 }
 
+// decompressReader wraps a response body so it can lazily
+// call a decoder registered with RegisterDecompressor on the first call to Read.
+type decompressReader struct {
+	_         incomparable
+	body      io.ReadCloser // underlying Response.Body
+	newReader func(io.Reader) (io.ReadCloser, error)
+	zr        io.ReadCloser // lazily-initialized decoder
+	zerr      error         // sticky error
+}
+
+func (dr *decompressReader) Read(p []byte) (n int, err error) {
+	if dr.zerr != nil {
+		return 0, dr.zerr
+	}
+	if dr.zr == nil {
+		dr.zr, err = dr.newReader(dr.body)
+		if err != nil {
+			dr.zerr = err
+			return 0, err
+		}
+	}
+	return dr.zr.Read(p)
+}
+
+func (dr *decompressReader) Close() error {
+	if dr.zr != nil {
+		dr.zr.Close()
+	}
+	if err := dr.body.Close(); err != nil {
+		return err
+	}
+	dr.zerr = fs.ErrClosed
+	return nil
+}
+
 type errorReader struct{ err error }
 
 func (r errorReader) Read(p []byte) (int, error) { return 0, r.err }
@@ -3274,6 +4935,18 @@ func traceGotConn(req *http.Request, cc *ClientConn, reused bool) {
 	trace.GotConn(ci)
 }
 
+func traceTLSHandshakeStart(trace *httptrace.ClientTrace) {
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+}
+
+func traceTLSHandshakeDone(trace *httptrace.ClientTrace, state tls.ConnectionState, err error) {
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+}
+
 func traceWroteHeaders(trace *httptrace.ClientTrace) {
 	if trace != nil && trace.WroteHeaders != nil {
 		trace.WroteHeaders()
@@ -3323,14 +4996,22 @@ func traceGot1xxResponseFunc(trace *httptrace.ClientTrace) func(int, textproto.M
 
 // dialTLSWithContext uses tls.Dialer, added in Go 1.15, to open a TLS
 // connection.
-func (t *Transport) dialTLSWithContext(ctx context.Context, network, addr string, cfg *tls.Config) (*tls.Conn, error) {
-	dialer := &tls.Dialer{
-		Config: cfg,
+func (t *Transport) dialTLSWithContext(ctx context.Context, network, origin, addr string, cfg *tls.Config) (*tls.Conn, error) {
+	// The net.Dialer reports DNS and connect events to any
+	// httptrace.ClientTrace in ctx itself. Report the handshake
+	// separately, as net/http does.
+	cn, err := t.dialConn(ctx, network, origin, addr)
+	if err != nil {
+		return nil, err
 	}
-	cn, err := dialer.DialContext(ctx, network, addr)
+	trace := httptrace.ContextClientTrace(ctx)
+	tlsCn := tls.Client(cn, cfg)
+	traceTLSHandshakeStart(trace)
+	err = tlsCn.HandshakeContext(ctx)
+	traceTLSHandshakeDone(trace, tlsCn.ConnectionState(), err)
 	if err != nil {
+		cn.Close()
 		return nil, err
 	}
-	tlsCn := cn.(*tls.Conn) // DialContext comment promises this will always succeed
 	return tlsCn, nil
 }
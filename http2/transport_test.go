@@ -7,6 +7,7 @@ package http2
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
 	"context"
 	"crypto/tls"
@@ -32,6 +33,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -875,6 +877,68 @@ func testTransportReqBodyAfterResponse(t *testing.T, status int) {
 	rt.wantBody(nil)
 }
 
+func TestTransportWithFullDuplex(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	body := tc.newRequestBody()
+	ctx := WithFullDuplex(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://dummy.tld/", body)
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: false,
+	})
+
+	// The response headers arrive before any of the request body, with
+	// a status which would otherwise stop the request body.
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "409",
+		),
+	})
+	rt.wantStatus(409)
+
+	// The request and response bodies are interleaved.
+	body.Write([]byte("ping"))
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: false,
+		data:      []byte("ping"),
+	})
+	tc.writeData(rt.streamID(), false, []byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(rt.response().Body, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("reading response body: %q, %v; want %q", buf, err, "pong")
+	}
+
+	// The response ends and its body is closed,
+	// but the request body continues.
+	tc.writeData(rt.streamID(), true, nil)
+	if n, err := rt.response().Body.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("reading end of response body: %v, %v; want 0, io.EOF", n, err)
+	}
+	if err := rt.response().Body.Close(); err != nil {
+		t.Fatalf("closing response body: %v", err)
+	}
+	body.Write([]byte("more"))
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: false,
+		data:      []byte("more"),
+	})
+	body.closeWithError(io.EOF)
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: true,
+		size:      0,
+	})
+	tc.wantIdle()
+}
+
 // See golang.org/issue/13444
 func TestTransportFullDuplex(t *testing.T) {
 	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
@@ -1449,9 +1513,9 @@ func TestTransportChecksRequestHeaderListSize(t *testing.T) {
 			t.Fatalf("headerListSizeForRequest: %v", err)
 		}
 		cc := &ClientConn{peerMaxHeaderListSize: 0xffffffffffffffff}
-		cc.henc = hpack.NewEncoder(&cc.hbuf)
+		cc.henc = newHeaderEncoder(&cc.hbuf)
 		cc.mu.Lock()
-		hdrs, err := cc.encodeHeaders(req, true, trailers, contentLen)
+		hdrs, err := cc.encodeHeaders(req, "gzip", trailers, contentLen)
 		cc.mu.Unlock()
 		if err != nil {
 			t.Fatalf("headerListSizeForRequest: %v", err)
@@ -1886,6 +1950,136 @@ func testTransportResponseHeaderTimeout(t *testing.T, body bool) {
 	}
 }
 
+func TestTransportRequestResponseHeaderTimeout(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.t1 = &http.Transport{
+			ResponseHeaderTimeout: 1 * time.Millisecond,
+		}
+	})
+	tc.greet()
+
+	ctx := WithRequestTimeouts(context.Background(), RequestTimeouts{
+		ResponseHeaderTimeout: 5 * time.Millisecond,
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	tc.advance(4 * time.Millisecond)
+	if rt.done() {
+		t.Fatalf("RoundTrip is done after 4ms; want still waiting")
+	}
+	tc.advance(1 * time.Millisecond)
+	if err := rt.err(); !isTimeout(err) {
+		t.Fatalf("RoundTrip error: %v; want timeout error", err)
+	}
+	tc.wantRSTStream(rt.streamID(), ErrCodeCancel)
+}
+
+func TestTransportRequestStreamIdleTimeout(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	ctx := WithRequestTimeouts(context.Background(), RequestTimeouts{
+		StreamIdleTimeout: 10 * time.Millisecond,
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	tc.advance(9 * time.Millisecond)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(200)
+
+	// Each frame received postpones the timeout.
+	tc.advance(9 * time.Millisecond)
+	tc.writeData(rt.streamID(), false, []byte("data"))
+	tc.advance(9 * time.Millisecond)
+	if tc.hasFrame() {
+		t.Fatalf("stream reset after 9ms of inactivity; want no reset until 10ms")
+	}
+
+	tc.advance(1 * time.Millisecond)
+	tc.wantRSTStream(rt.streamID(), ErrCodeCancel)
+	body, err := rt.readBody()
+	if string(body) != "data" || !isTimeout(err) {
+		t.Fatalf("reading body = %q, %v; want %q, timeout error", body, err, "data")
+	}
+}
+
+func TestTransportResponseBodyAbort(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(rt.streamID(), false, []byte("partial"))
+	res := rt.response()
+	body, ok := res.Body.(ResponseBodyAborter)
+	if !ok {
+		t.Fatalf("response body type %T does not implement ResponseBodyAborter", res.Body)
+	}
+	if err := body.Abort(ErrCodeInternal); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	tc.wantRSTStream(rt.streamID(), ErrCodeInternal)
+	if _, err := res.Body.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read after Abort succeeded; want error")
+	}
+}
+
+func TestTransportResponseBodyAbortAfterTrailers(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+			"trailer", "X-Checksum",
+		),
+	})
+	tc.writeData(rt.streamID(), false, []byte("unread body"))
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			"x-checksum", "abc",
+		),
+	})
+
+	// The response is complete, so Abort does not reset the stream,
+	// and the trailers are available although the body was not read.
+	if err := rt.response().Body.(ResponseBodyAborter).Abort(ErrCodeInternal); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	if fr := tc.readFrame(); fr != nil {
+		t.Errorf("after Abort of complete response, got frame %v; want none", summarizeFrame(fr))
+	}
+	rt.wantTrailers(http.Header{
+		"X-Checksum": []string{"abc"},
+	})
+}
+
 func TestTransportDisableCompression(t *testing.T) {
 	const body = "sup"
 	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
@@ -2163,6 +2357,75 @@ func TestTransportFailsOnInvalidHeadersAndTrailers(t *testing.T) {
 	}
 }
 
+func TestTransportRequestTrailers(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	// As in gRPC, the trailer values are set as the body ends.
+	body := tc.newRequestBody()
+	req, _ := http.NewRequest("POST", "https://dummy.tld/", body)
+	req.Trailer = http.Header{"Grpc-Status": nil}
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: false,
+		header: http.Header{
+			"trailer": {"Grpc-Status"},
+		},
+	})
+
+	body.writeBytes(10)
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: false,
+		size:      10,
+	})
+	req.Trailer.Set("Grpc-Status", "0")
+	body.closeWithError(io.EOF)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: true,
+		header: http.Header{
+			"grpc-status": {"0"},
+		},
+	})
+	tc.wantIdle()
+}
+
+func TestTransportRequestTrailersNoBody(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	req.Trailer = http.Header{"X-Trailer": {"value"}}
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: false,
+		header: http.Header{
+			"trailer": {"X-Trailer"},
+		},
+	})
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: true,
+		header: http.Header{
+			"x-trailer": {"value"},
+		},
+	})
+	tc.wantIdle()
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(200)
+}
+
 // Tests that gzipReader doesn't crash on a second Read call following
 // the first Read call's gzip.NewReader returning an error.
 func TestGzipReader_DoubleReadCrash(t *testing.T) {
@@ -2202,61 +2465,234 @@ func TestGzipReader_ReadAfterClose(t *testing.T) {
 	}
 }
 
-func TestTransportNewTLSConfig(t *testing.T) {
-	tests := [...]struct {
-		conf *tls.Config
-		host string
-		want *tls.Config
-	}{
-		// Normal case.
-		0: {
-			conf: nil,
-			host: "foo.com",
-			want: &tls.Config{
-				ServerName: "foo.com",
-				NextProtos: []string{NextProtoTLS},
-			},
-		},
+func TestTransportRegisterDecompressor(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.RegisterDecompressor("X-Flate", func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		})
+	})
+	tc.greet()
 
-		// User-provided name (bar.com) takes precedence:
-		1: {
-			conf: &tls.Config{
-				ServerName: "bar.com",
-			},
-			host: "foo.com",
-			want: &tls.Config{
-				ServerName: "bar.com",
-				NextProtos: []string{NextProtoTLS},
-			},
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: true,
+		header: http.Header{
+			"accept-encoding": []string{"gzip, x-flate"},
 		},
+	})
 
-		// NextProto is prepended:
-		2: {
-			conf: &tls.Config{
-				NextProtos: []string{"foo", "bar"},
-			},
-			host: "example.com",
-			want: &tls.Config{
-				ServerName: "example.com",
-				NextProtos: []string{NextProtoTLS, "foo", "bar"},
-			},
-		},
+	const want = "hello, decompressor"
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	zw.Write([]byte(want))
+	zw.Close()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+			"content-encoding", "x-flate",
+			"content-length", strconv.Itoa(buf.Len()),
+		),
+	})
+	tc.writeData(rt.streamID(), true, buf.Bytes())
 
-		// NextProto is not duplicated:
-		3: {
-			conf: &tls.Config{
-				NextProtos: []string{"foo", "bar", NextProtoTLS},
-			},
-			host: "example.com",
-			want: &tls.Config{
-				ServerName: "example.com",
-				NextProtos: []string{"foo", "bar", NextProtoTLS},
-			},
-		},
+	res := rt.response()
+	if !res.Uncompressed {
+		t.Errorf("res.Uncompressed = false, want true")
 	}
-	for i, tt := range tests {
-		// Ignore the session ticket keys part, which ends up populating
-		// unexported fields in the Config:
+	if res.ContentLength != -1 {
+		t.Errorf("res.ContentLength = %v, want -1", res.ContentLength)
+	}
+	rt.wantHeaders(http.Header{})
+	rt.wantBody([]byte(want))
+}
+
+func TestTransportUnregisteredContentEncoding(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: true,
+		header: http.Header{
+			"accept-encoding": []string{"gzip"},
+		},
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+			"content-encoding", "br",
+		),
+	})
+	tc.writeData(rt.streamID(), true, []byte("raw"))
+
+	if res := rt.response(); res.Uncompressed {
+		t.Errorf("res.Uncompressed = true, want false")
+	}
+	rt.wantHeaders(http.Header{
+		"Content-Encoding": []string{"br"},
+	})
+	rt.wantBody([]byte("raw"))
+}
+
+func TestServiceAddr(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		eps     []ServiceEndpoint
+		want    string
+		wantErr error
+	}{{
+		name: "no endpoints",
+		want: "example.tld:443",
+	}, {
+		name: "same host alternate port",
+		eps: []ServiceEndpoint{
+			{Priority: 1, Target: ".", Port: 8443, ALPN: []string{"h2"}},
+		},
+		want: "example.tld:8443",
+	}, {
+		name: "prefer lowest priority",
+		eps: []ServiceEndpoint{
+			{Priority: 2, Target: "b.example.tld.", ALPN: []string{"h2"}},
+			{Priority: 1, Target: "a.example.tld.", ALPN: []string{"h2", "http/1.1"}},
+		},
+		want: "a.example.tld:443",
+	}, {
+		name: "skip endpoints without h2",
+		eps: []ServiceEndpoint{
+			{Priority: 1, Target: "a.example.tld.", ALPN: []string{"http/1.1"}},
+			{Priority: 2, Target: "b.example.tld.", Port: 444, ALPN: []string{"h2"}},
+		},
+		want: "b.example.tld:444",
+	}, {
+		name: "ignore alias mode",
+		eps: []ServiceEndpoint{
+			{Priority: 0, Target: "alias.example.tld.", ALPN: []string{"h2"}},
+			{Priority: 1, ALPN: []string{"h2"}},
+		},
+		want: "example.tld:443",
+	}, {
+		name: "only alias mode",
+		eps: []ServiceEndpoint{
+			{Priority: 0, Target: "alias.example.tld.", ALPN: []string{"h2"}},
+		},
+		want: "example.tld:443",
+	}, {
+		name: "no h2 endpoint",
+		eps: []ServiceEndpoint{
+			{Priority: 1, ALPN: []string{"http/1.1"}},
+		},
+		wantErr: errNoServiceEndpoint,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := serviceAddr("example.tld:443", test.eps)
+			if got != test.want || err != test.wantErr {
+				t.Errorf("serviceAddr = %q, %v; want %q, %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransportTLSSessionResumption(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	for _, resume := range []bool{false, true} {
+		t.Run(fmt.Sprintf("resume=%v", resume), func(t *testing.T) {
+			tr := &Transport{
+				TLSClientConfig:   tlsConfigInsecure,
+				ResumeTLSSessions: resume,
+			}
+			defer tr.CloseIdleConnections()
+
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				req.Close = true // don't reuse the connection
+				res, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := res.TLS.DidResume, resume && i > 0; got != want {
+					t.Errorf("request %v: DidResume = %v, want %v", i, got, want)
+				}
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+			want := TLSHandshakeStats{Full: 2}
+			if resume {
+				want = TLSHandshakeStats{Full: 1, Resumed: 1}
+			}
+			host := ts.Listener.Addr().(*net.TCPAddr).IP.String()
+			if got := tr.TLSHandshakeStats(host); got != want {
+				t.Errorf("TLSHandshakeStats(%q) = %+v, want %+v", host, got, want)
+			}
+			if got := tr.TLSHandshakeStats("other.example.com"); got != (TLSHandshakeStats{}) {
+				t.Errorf("TLSHandshakeStats for another host = %+v, want none", got)
+			}
+		})
+	}
+}
+
+func TestTransportNewTLSConfig(t *testing.T) {
+	tests := [...]struct {
+		conf *tls.Config
+		host string
+		want *tls.Config
+	}{
+		// Normal case.
+		0: {
+			conf: nil,
+			host: "foo.com",
+			want: &tls.Config{
+				ServerName: "foo.com",
+				NextProtos: []string{NextProtoTLS},
+			},
+		},
+
+		// User-provided name (bar.com) takes precedence:
+		1: {
+			conf: &tls.Config{
+				ServerName: "bar.com",
+			},
+			host: "foo.com",
+			want: &tls.Config{
+				ServerName: "bar.com",
+				NextProtos: []string{NextProtoTLS},
+			},
+		},
+
+		// NextProto is prepended:
+		2: {
+			conf: &tls.Config{
+				NextProtos: []string{"foo", "bar"},
+			},
+			host: "example.com",
+			want: &tls.Config{
+				ServerName: "example.com",
+				NextProtos: []string{NextProtoTLS, "foo", "bar"},
+			},
+		},
+
+		// NextProto is not duplicated:
+		3: {
+			conf: &tls.Config{
+				NextProtos: []string{"foo", "bar", NextProtoTLS},
+			},
+			host: "example.com",
+			want: &tls.Config{
+				ServerName: "example.com",
+				NextProtos: []string{"foo", "bar", NextProtoTLS},
+			},
+		},
+	}
+	for i, tt := range tests {
+		// Ignore the session ticket keys part, which ends up populating
+		// unexported fields in the Config:
 		if tt.conf != nil {
 			tt.conf.SessionTicketsDisabled = true
 		}
@@ -2755,8 +3191,9 @@ func TestTransportRequestPathPseudo(t *testing.T) {
 		err  string
 	}
 	tests := []struct {
-		req  *http.Request
-		want result
+		req    *http.Request
+		strict bool
+		want   result
 	}{
 		0: {
 			req: &http.Request{
@@ -2848,12 +3285,67 @@ func TestTransportRequestPathPseudo(t *testing.T) {
 			},
 			want: result{},
 		},
+
+		// Strict mode accepts an origin-form path:
+		7: {
+			req: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Host: "foo.com",
+					Path: "/foo",
+				},
+			},
+			strict: true,
+			want:   result{path: "/foo"},
+		},
+
+		// Strict mode rejects an absolute-form Opaque:
+		8: {
+			req: &http.Request{
+				Method: "GET",
+				URL: &url.URL{
+					Scheme: "https",
+					Opaque: "//foo.com/path",
+					Host:   "foo.com",
+				},
+			},
+			strict: true,
+			want:   result{err: `http2: invalid request authority "foo.com": request target is not in origin form`},
+		},
+
+		// Strict mode rejects a mismatched Host header:
+		9: {
+			req: &http.Request{
+				Method: "GET",
+				Header: http.Header{"Host": {"bar.com"}},
+				URL: &url.URL{
+					Host: "foo.com",
+					Path: "/foo",
+				},
+			},
+			strict: true,
+			want:   result{err: `http2: invalid request authority "foo.com": Host header does not match :authority`},
+		},
+
+		// Strict mode rejects userinfo in the authority:
+		10: {
+			req: &http.Request{
+				Method: "GET",
+				Host:   "user@foo.com",
+				URL: &url.URL{
+					Host: "foo.com",
+					Path: "/foo",
+				},
+			},
+			strict: true,
+			want:   result{err: `http2: invalid request authority "user@foo.com": authority includes userinfo`},
+		},
 	}
 	for i, tt := range tests {
-		cc := &ClientConn{peerMaxHeaderListSize: 0xffffffffffffffff}
-		cc.henc = hpack.NewEncoder(&cc.hbuf)
+		cc := &ClientConn{peerMaxHeaderListSize: 0xffffffffffffffff, strictAuth: tt.strict}
+		cc.henc = newHeaderEncoder(&cc.hbuf)
 		cc.mu.Lock()
-		hdrs, err := cc.encodeHeaders(tt.req, false, "", -1)
+		hdrs, err := cc.encodeHeaders(tt.req, "", "", -1)
 		cc.mu.Unlock()
 		var got result
 		hpackDec := hpack.NewDecoder(initialHeaderTableSize, func(f hpack.HeaderField) {
@@ -2914,107 +3406,379 @@ func TestClientConnPing(t *testing.T) {
 	}
 }
 
-// Issue 16974: if the server sent a DATA frame after the user
-// canceled the Transport's Request, the Transport previously wrote to a
-// closed pipe, got an error, and ended up closing the whole TCP
-// connection.
-func TestTransportCancelDataResponseRace(t *testing.T) {
-	cancel := make(chan struct{})
-	clientGotResponse := make(chan bool, 1)
-
-	const msg = "Hello."
-	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "/hello") {
-			time.Sleep(50 * time.Millisecond)
-			io.WriteString(w, msg)
-			return
-		}
-		for i := 0; i < 50; i++ {
-			io.WriteString(w, "Some data.")
-			w.(http.Flusher).Flush()
-			if i == 2 {
-				<-clientGotResponse
-				close(cancel)
-			}
-			time.Sleep(10 * time.Millisecond)
-		}
-	})
-
-	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
-	defer tr.CloseIdleConnections()
+func TestClientConnPriorityGroups(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
 
-	c := &http.Client{Transport: tr}
-	req, _ := http.NewRequest("GET", ts.URL, nil)
-	req.Cancel = cancel
-	res, err := c.Do(req)
-	clientGotResponse <- true
+	ctx := context.Background()
+	leaders, err := tc.cc.NewPriorityGroup(ctx, PriorityParam{Weight: 200})
 	if err != nil {
-		t.Fatal(err)
-	}
-	if _, err = io.Copy(io.Discard, res.Body); err == nil {
-		t.Fatal("unexpected success")
+		t.Fatalf("NewPriorityGroup: %v", err)
 	}
-
-	res, err = c.Get(ts.URL + "/hello")
+	followers, err := tc.cc.NewPriorityGroup(ctx, PriorityParam{StreamDep: leaders, Weight: 0})
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("NewPriorityGroup: %v", err)
 	}
-	slurp, err := io.ReadAll(res.Body)
-	if err != nil {
-		t.Fatal(err)
+	if leaders != 1 || followers != 3 {
+		t.Fatalf("priority groups = %v, %v; want 1, 3", leaders, followers)
 	}
-	if string(slurp) != msg {
-		t.Errorf("Got = %q; want %q", slurp, msg)
+	for _, want := range []PriorityFrame{{
+		FrameHeader:   FrameHeader{StreamID: leaders},
+		PriorityParam: PriorityParam{Weight: 200},
+	}, {
+		FrameHeader:   FrameHeader{StreamID: followers},
+		PriorityParam: PriorityParam{StreamDep: leaders, Weight: 0},
+	}} {
+		fr := readFrame[*PriorityFrame](t, tc)
+		if fr.StreamID != want.StreamID || fr.PriorityParam != want.PriorityParam {
+			t.Fatalf("got PRIORITY stream=%v %+v; want stream=%v %+v", fr.StreamID, fr.PriorityParam, want.StreamID, want.PriorityParam)
+		}
 	}
-}
-
-// Issue 21316: It should be safe to reuse an http.Request after the
-// request has completed.
-func TestTransportNoRaceOnRequestObjectAfterRequestComplete(t *testing.T) {
-	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		io.WriteString(w, "body")
-	})
 
-	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
-	defer tr.CloseIdleConnections()
-
-	req, _ := http.NewRequest("GET", ts.URL, nil)
-	resp, err := tr.RoundTrip(req)
-	if err != nil {
-		t.Fatal(err)
+	if err := tc.cc.SetPriority(followers, PriorityParam{StreamDep: leaders, Exclusive: true, Weight: 15}); err != nil {
+		t.Fatalf("SetPriority: %v", err)
 	}
-	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
-		t.Fatalf("error reading response body: %v", err)
+	fr := readFrame[*PriorityFrame](t, tc)
+	if want := (PriorityParam{StreamDep: leaders, Exclusive: true, Weight: 15}); fr.StreamID != followers || fr.PriorityParam != want {
+		t.Fatalf("got PRIORITY stream=%v %+v; want stream=%v %+v", fr.StreamID, fr.PriorityParam, followers, want)
 	}
-	if err := resp.Body.Close(); err != nil {
-		t.Fatalf("error closing response body: %v", err)
+	if err := tc.cc.SetPriority(5, PriorityParam{}); err == nil {
+		t.Errorf("SetPriority on unallocated stream 5 succeeded, want error")
+	}
+	if err := tc.cc.SetPriority(leaders, PriorityParam{StreamDep: leaders}); err == nil {
+		t.Errorf("SetPriority with self-dependency succeeded, want error")
 	}
 
-	// This access of req.Header should not race with code in the transport.
-	req.Header = http.Header{}
+	// Requests are assigned stream IDs after the priority groups.
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	if got, want := rt.streamID(), uint32(5); got != want {
+		t.Errorf("request stream ID = %v, want %v", got, want)
+	}
+	tc.wantIdle()
 }
 
-func TestTransportCloseAfterLostPing(t *testing.T) {
+func TestTransportRequestPriority(t *testing.T) {
 	tc := newTestClientConn(t, func(tr *Transport) {
-		tr.PingTimeout = 1 * time.Second
-		tr.ReadIdleTimeout = 1 * time.Second
+		tr.PriorityFunc = func(req *http.Request) PriorityParam {
+			if req.URL.Path == "/style.css" {
+				return PriorityParam{Weight: 255}
+			}
+			return PriorityParam{}
+		}
 	})
 	tc.greet()
 
-	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
-	rt := tc.roundTrip(req)
-	tc.wantFrameType(FrameHeaders)
-
-	tc.advance(1 * time.Second)
-	tc.wantFrameType(FramePing)
-
-	tc.advance(1 * time.Second)
-	err := rt.err()
-	if err == nil || !strings.Contains(err.Error(), "client connection lost") {
-		t.Fatalf("expected to get error about \"connection lost\", got %v", err)
+	group, err := tc.cc.NewPriorityGroup(context.Background(), PriorityParam{Weight: 100})
+	if err != nil {
+		t.Fatalf("NewPriorityGroup: %v", err)
 	}
-}
+	readFrame[*PriorityFrame](t, tc)
+
+	for _, test := range []struct {
+		path string
+		ctx  context.Context
+		want PriorityParam
+	}{{
+		path: "/",
+		ctx:  context.Background(),
+		want: PriorityParam{},
+	}, {
+		path: "/style.css",
+		ctx:  context.Background(),
+		want: PriorityParam{Weight: 255},
+	}, {
+		path: "/style.css",
+		ctx:  WithPriority(context.Background(), PriorityParam{StreamDep: group, Exclusive: true, Weight: 31}),
+		want: PriorityParam{StreamDep: group, Exclusive: true, Weight: 31},
+	}} {
+		req, _ := http.NewRequestWithContext(test.ctx, "GET", "https://dummy.tld"+test.path, nil)
+		tc.roundTrip(req)
+		hf := readFrame[*HeadersFrame](t, tc)
+		if hf.HasPriority() != !test.want.IsZero() || hf.Priority != test.want {
+			t.Errorf("%v: HEADERS priority = %v %+v, want %+v", test.path, hf.HasPriority(), hf.Priority, test.want)
+		}
+	}
+}
+
+func TestTransportRequestPrioritySelfDependency(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	// The first request is stream 1, and may not depend on itself.
+	ctx := WithPriority(context.Background(), PriorityParam{StreamDep: 1, Weight: 15})
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	req.Header.Set("X-Test", "value")
+	rt := tc.roundTrip(req)
+	if err := rt.err(); err != errPrioritySelfDep {
+		t.Fatalf("RoundTrip = %v, want %v", err, errPrioritySelfDep)
+	}
+	tc.skipFrames(frameType(FrameRSTStream))
+
+	// The rejected request must not have touched the HPACK state.
+	req, _ = http.NewRequest("GET", "https://dummy.tld/", nil)
+	req.Header.Set("X-Test", "value")
+	tc.roundTrip(req)
+	hf := readFrame[*HeadersFrame](t, tc)
+	var got string
+	for _, kv := range tc.decodeHeader(hf.HeaderBlockFragment()) {
+		if kv[0] == "x-test" {
+			got = kv[1]
+		}
+	}
+	if got != "value" {
+		t.Errorf("x-test header = %q, want %q", got, "value")
+	}
+}
+
+func TestTransportExtensiblePriority(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	for _, test := range []struct {
+		name   string
+		p      *ExtensiblePriority
+		header string
+		want   []string
+	}{{
+		name: "none",
+		want: nil,
+	}, {
+		name: "default",
+		p:    &DefaultExtensiblePriority,
+		want: nil,
+	}, {
+		name: "urgent incremental",
+		p:    &ExtensiblePriority{Urgency: 1, Incremental: true},
+		want: []string{"u=1, i"},
+	}, {
+		name:   "header takes precedence",
+		p:      &ExtensiblePriority{Urgency: 7},
+		header: "u=0",
+		want:   []string{"u=0"},
+	}} {
+		ctx := context.Background()
+		if test.p != nil {
+			ctx = WithExtensiblePriority(ctx, *test.p)
+		}
+		req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+		if test.header != "" {
+			req.Header.Set("Priority", test.header)
+		}
+		rt := tc.roundTrip(req)
+		hf := readFrame[*HeadersFrame](t, tc)
+		var got []string
+		for _, kv := range tc.decodeHeader(hf.HeaderBlockFragment()) {
+			if kv[0] == "priority" {
+				got = append(got, kv[1])
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: priority header = %q, want %q", test.name, got, test.want)
+		}
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      rt.streamID(),
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "204"),
+		})
+		rt.wantStatus(204)
+	}
+
+	req, _ := http.NewRequestWithContext(WithExtensiblePriority(context.Background(), ExtensiblePriority{Urgency: 8}), "GET", "https://dummy.tld/", nil)
+	if _, err := tc.cc.RoundTrip(req); err == nil {
+		t.Errorf("RoundTrip with urgency 8 succeeded, want error")
+	}
+}
+
+func TestTransportUpdateExtensiblePriority(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	res := rt.response()
+
+	if err := UpdateExtensiblePriority(res, ExtensiblePriority{Urgency: 6, Incremental: true}); err != nil {
+		t.Fatalf("UpdateExtensiblePriority: %v", err)
+	}
+	fr := readFrame[*PriorityUpdateFrame](t, tc)
+	if fr.PrioritizedStreamID != rt.streamID() || fr.Priority != "u=6, i" {
+		t.Errorf("got PRIORITY_UPDATE for stream %v with %q, want stream %v with %q", fr.PrioritizedStreamID, fr.Priority, rt.streamID(), "u=6, i")
+	}
+	if err := UpdateExtensiblePriority(res, ExtensiblePriority{Urgency: -1}); err == nil {
+		t.Errorf("UpdateExtensiblePriority with urgency -1 succeeded, want error")
+	}
+
+	tc.writeData(rt.streamID(), true, nil)
+	rt.wantBody(nil)
+	if err := UpdateExtensiblePriority(res, DefaultExtensiblePriority); err == nil {
+		t.Errorf("UpdateExtensiblePriority after stream closed succeeded, want error")
+	}
+	tc.wantIdle()
+}
+
+func TestTransportRejectsPriorityUpdate(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+	tc.fr.WritePriorityUpdate(1, "u=0")
+	tc.wantClosed()
+}
+
+// Issue 16974: if the server sent a DATA frame after the user
+// canceled the Transport's Request, the Transport previously wrote to a
+// closed pipe, got an error, and ended up closing the whole TCP
+// connection.
+func TestTransportCancelDataResponseRace(t *testing.T) {
+	cancel := make(chan struct{})
+	clientGotResponse := make(chan bool, 1)
+
+	const msg = "Hello."
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/hello") {
+			time.Sleep(50 * time.Millisecond)
+			io.WriteString(w, msg)
+			return
+		}
+		for i := 0; i < 50; i++ {
+			io.WriteString(w, "Some data.")
+			w.(http.Flusher).Flush()
+			if i == 2 {
+				<-clientGotResponse
+				close(cancel)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+	defer tr.CloseIdleConnections()
+
+	c := &http.Client{Transport: tr}
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Cancel = cancel
+	res, err := c.Do(req)
+	clientGotResponse <- true
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(io.Discard, res.Body); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	res, err = c.Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slurp, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(slurp) != msg {
+		t.Errorf("Got = %q; want %q", slurp, msg)
+	}
+}
+
+// Issue 21316: It should be safe to reuse an http.Request after the
+// request has completed.
+func TestTransportNoRaceOnRequestObjectAfterRequestComplete(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		io.WriteString(w, "body")
+	})
+
+	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("error reading response body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatalf("error closing response body: %v", err)
+	}
+
+	// This access of req.Header should not race with code in the transport.
+	req.Header = http.Header{}
+}
+
+func TestTransportCloseAfterLostPing(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.PingTimeout = 1 * time.Second
+		tr.ReadIdleTimeout = 1 * time.Second
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	tc.advance(1 * time.Second)
+	tc.wantFrameType(FramePing)
+
+	tc.advance(1 * time.Second)
+	err := rt.err()
+	if err == nil || !strings.Contains(err.Error(), "client connection lost") {
+		t.Fatalf("expected to get error about \"connection lost\", got %v", err)
+	}
+}
+
+func TestTransportHealthCheckIdleConn(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.PingTimeout = 1 * time.Second
+		tr.ReadIdleTimeout = 1 * time.Second
+	})
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+	tt.wantDials("dummy.tld:443")
+
+	// The idle connection's peer stops responding.
+	tt.advance(1 * time.Second)
+	tc.wantFrameType(FrameSettings) // ACK
+	tc.wantFrameType(FramePing)
+	tt.advance(1 * time.Second)
+	tc.wantClosed()
+
+	// The next request uses a new connection.
+	rt = tt.roundTrip(req)
+	tt.wantDials("dummy.tld:443")
+	tc = tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
 
 func TestTransportPingWriteBlocks(t *testing.T) {
 	ts := newTestServer(t,
@@ -3088,6 +3852,50 @@ func TestTransportPingWhenReadingMultiplePings(t *testing.T) {
 	}
 }
 
+func TestTransportRTTEstimate(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		samples []RTTSample
+	)
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.ReadIdleTimeout = 1 * time.Second
+		tr.RTTSampleFunc = func(s RTTSample) {
+			mu.Lock()
+			defer mu.Unlock()
+			samples = append(samples, s)
+		}
+	})
+	tc.greet()
+	if st := tc.cc.State(); st.RTTSamples != 0 || st.SmoothedRTT != 0 {
+		t.Fatalf("before any PING: RTTSamples = %v, SmoothedRTT = %v; want 0, 0", st.RTTSamples, st.SmoothedRTT)
+	}
+
+	// Health check PINGs are acknowledged after 80ms, then 40ms.
+	for _, rtt := range []time.Duration{80 * time.Millisecond, 40 * time.Millisecond} {
+		tc.advance(1 * time.Second)
+		f := readFrame[*PingFrame](t, tc)
+		tc.advance(rtt)
+		tc.writePing(true, f.Data)
+	}
+
+	st := tc.cc.State()
+	if got, want := [4]interface{}{st.SmoothedRTT, st.RTTVariation, st.MinRTT, st.RTTSamples},
+		[4]interface{}{75 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond, 2}; got != want {
+		t.Errorf("SmoothedRTT, RTTVariation, MinRTT, RTTSamples = %v, want %v", got, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(samples) != 2 {
+		t.Fatalf("got %v RTT samples, want 2", len(samples))
+	}
+	if s := samples[0]; s.Conn != tc.cc || s.RTT != 80*time.Millisecond || s.SmoothedRTT != 80*time.Millisecond {
+		t.Errorf("first sample = %+v, want RTT and SmoothedRTT 80ms", s)
+	}
+	if s := samples[1]; s.RTT != 40*time.Millisecond || s.SmoothedRTT != st.SmoothedRTT || s.MinRTT != st.MinRTT {
+		t.Errorf("second sample = %+v, want RTT 40ms and the connection's estimates", s)
+	}
+}
+
 func TestTransportPingWhenReadingPingDisabled(t *testing.T) {
 	tc := newTestClientConn(t, func(tr *Transport) {
 		tr.ReadIdleTimeout = 0 // PINGs disabled
@@ -3330,17 +4138,188 @@ func TestTransportRetryHasLimit(t *testing.T) {
 	}
 }
 
-func TestTransportResponseDataBeforeHeaders(t *testing.T) {
-	// Discard log output complaining about protocol error.
-	log.SetOutput(io.Discard)
-	t.Cleanup(func() { log.SetOutput(os.Stderr) }) // after other cleanup is done
+// refuseRetries answers each attempt of a request on tc with
+// REFUSED_STREAM until the request stops being retried, advancing the
+// clock through any backoff. It returns the number of attempts and the
+// waits between them.
+func refuseRetries(tt *testTransport, tc *testClientConn) (attempts int, waits []time.Duration) {
+	tt.t.Helper()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	for {
+		if !tc.hasFrame() {
+			d, scheduled := tt.group.TimeUntilEvent()
+			if !scheduled {
+				return attempts, waits
+			}
+			waits = append(waits, d)
+			tt.advance(d)
+			continue
+		}
+		streamID := uint32(2*attempts + 1)
+		tc.wantHeaders(wantHeader{
+			streamID:  streamID,
+			endStream: true,
+		})
+		if attempts == 0 {
+			tc.writeSettings()
+			tc.wantFrameType(FrameSettings) // settings ACK
+		}
+		attempts++
+		tc.writeRSTStream(streamID, ErrCodeRefusedStream)
+	}
+}
 
-	tc := newTestClientConn(t)
-	tc.greet()
+func TestTransportRetryPolicy(t *testing.T) {
+	var reasons []RetryReason
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Retry = &RetryPolicy{
+			MaxAttempts: 3,
+			Backoff: func(n int) time.Duration {
+				return time.Duration(n) * time.Second
+			},
+			ShouldRetry: func(req *http.Request, err error, reason RetryReason, n int) bool {
+				reasons = append(reasons, reason)
+				return true
+			},
+		}
+	})
 
-	// First request is normal to ensure the check is per stream and not per connection.
 	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
-	rt1 := tc.roundTrip(req)
+	rt := tt.roundTrip(req)
+	attempts, waits := refuseRetries(tt, tt.getConn())
+	if attempts != 3 {
+		t.Errorf("RoundTrip made %v attempts, want 3", attempts)
+	}
+	if want := []time.Duration{1 * time.Second, 2 * time.Second}; !reflect.DeepEqual(waits, want) {
+		t.Errorf("RoundTrip waited %v between attempts, want %v", waits, want)
+	}
+	if want := []RetryReason{RetryRefusedStream, RetryRefusedStream}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("ShouldRetry called with reasons %v, want %v", reasons, want)
+	}
+	if rt.err() == nil {
+		t.Errorf("RoundTrip succeeded, want error")
+	}
+}
+
+func TestTransportRetryPolicyShouldRetry(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.Retry = &RetryPolicy{
+			ShouldRetry: func(req *http.Request, err error, reason RetryReason, n int) bool {
+				return false
+			},
+		}
+	})
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	if attempts, _ := refuseRetries(tt, tt.getConn()); attempts != 1 {
+		t.Errorf("RoundTrip made %v attempts, want 1", attempts)
+	}
+	if err, ok := rt.err().(StreamError); !ok || err.Code != ErrCodeRefusedStream {
+		t.Errorf("RoundTrip error = %v, want REFUSED_STREAM StreamError", rt.err())
+	}
+}
+
+func TestTransportRetryPolicyIdempotentOnly(t *testing.T) {
+	for _, test := range []struct {
+		method       string
+		header       http.Header
+		wantAttempts int
+	}{
+		{"GET", nil, 2},
+		{"DELETE", nil, 2},
+		{"POST", nil, 1},
+		{"POST", http.Header{"Idempotency-Key": {"k"}}, 2},
+		{"PATCH", http.Header{"X-Idempotency-Key": nil}, 2},
+	} {
+		t.Run(test.method, func(t *testing.T) {
+			tt := newTestTransport(t, func(tr *Transport) {
+				tr.Retry = &RetryPolicy{
+					MaxAttempts:    2,
+					IdempotentOnly: true,
+				}
+			})
+			req, _ := http.NewRequest(test.method, "https://dummy.tld/", nil)
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			rt := tt.roundTrip(req)
+			if attempts, _ := refuseRetries(tt, tt.getConn()); attempts != test.wantAttempts {
+				t.Errorf("%v request made %v attempts, want %v", test.method, attempts, test.wantAttempts)
+			}
+			if rt.err() == nil {
+				t.Errorf("RoundTrip succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestTransportRetryPolicyDialErrors(t *testing.T) {
+	for _, retryDial := range []bool{false, true} {
+		t.Run(fmt.Sprint(retryDial), func(t *testing.T) {
+			dialErr := errors.New("dial error")
+			var reasons []RetryReason
+			tt := newTestTransport(t, func(tr *Transport) {
+				tr.Retry = &RetryPolicy{
+					RetryDialErrors: retryDial,
+					ShouldRetry: func(req *http.Request, err error, reason RetryReason, n int) bool {
+						reasons = append(reasons, reason)
+						return true
+					},
+				}
+			})
+			dials := 0
+			tt.dial = func(addr string) error {
+				dials++
+				if dials == 1 {
+					return dialErr
+				}
+				return nil
+			}
+
+			req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+			rt := tt.roundTrip(req)
+			if !retryDial {
+				if err := rt.err(); !errors.Is(err, dialErr) {
+					t.Fatalf("RoundTrip error = %v, want %v", err, dialErr)
+				}
+				return
+			}
+			tc := tt.getConn()
+			tc.wantFrameType(FrameSettings)
+			tc.wantFrameType(FrameWindowUpdate)
+			tc.wantHeaders(wantHeader{
+				streamID:  1,
+				endStream: true,
+			})
+			tc.writeSettings()
+			tc.writeHeaders(HeadersFrameParam{
+				StreamID:   1,
+				EndHeaders: true,
+				EndStream:  true,
+				BlockFragment: tc.makeHeaderBlockFragment(
+					":status", "200",
+				),
+			})
+			rt.wantStatus(200)
+			if want := []RetryReason{RetryDial}; !reflect.DeepEqual(reasons, want) {
+				t.Errorf("ShouldRetry called with reasons %v, want %v", reasons, want)
+			}
+		})
+	}
+}
+
+func TestTransportResponseDataBeforeHeaders(t *testing.T) {
+	// Discard log output complaining about protocol error.
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) }) // after other cleanup is done
+
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	// First request is normal to ensure the check is per stream and not per connection.
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt1 := tc.roundTrip(req)
 	tc.wantFrameType(FrameHeaders)
 	tc.writeHeaders(HeadersFrameParam{
 		StreamID:   rt1.streamID(),
@@ -3388,6 +4367,171 @@ func TestTransportMaxFrameReadSize(t *testing.T) {
 	}
 }
 
+func TestTransportMaxReceiveBuffer(t *testing.T) {
+	const (
+		connBuf   = 1 << 20
+		streamBuf = 1 << 16
+	)
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.MaxReceiveBufferPerConnection = connBuf
+		tr.MaxReceiveBufferPerStream = streamBuf
+	})
+
+	fr := readFrame[*SettingsFrame](t, tc)
+	if v, ok := fr.Value(SettingInitialWindowSize); !ok || v != streamBuf {
+		t.Fatalf("SETTINGS_INITIAL_WINDOW_SIZE = %v, %v; want %v", v, ok, streamBuf)
+	}
+	tc.wantWindowUpdate(0, connBuf-initialWindowSize)
+	tc.writeSettings()
+	tc.writeSettingsAck()
+	tc.wantFrameType(FrameSettings) // acknowledgement
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	if got := tc.inflowWindow(0); got != connBuf {
+		t.Errorf("connection inflow window = %v, want %v", got, connBuf)
+	}
+	if got := tc.inflowWindow(rt.streamID()); got != streamBuf {
+		t.Errorf("stream inflow window = %v, want %v", got, streamBuf)
+	}
+}
+
+func TestTransportMaxReceiveBufferPerConnectionMinimum(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.MaxReceiveBufferPerConnection = initialWindowSize
+	})
+	tc.wantFrameType(FrameSettings)
+	tc.writeSettings()
+	tc.writeSettingsAck()
+	// No WINDOW_UPDATE is needed to grow the connection's window.
+	tc.wantFrameType(FrameSettings) // acknowledgement
+	if got := tc.inflowWindow(0); got != initialWindowSize {
+		t.Errorf("connection inflow window = %v, want %v", got, initialWindowSize)
+	}
+}
+
+func newAutoTuningTestClientConn(t *testing.T, limit int32) *testClientConn {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.MaxReceiveBufferPerConnection = initialWindowSize
+		tr.MaxReceiveBufferPerStream = initialWindowSize
+		tr.MaxAutoTunedReceiveBuffer = limit
+	})
+	tc.wantFrameType(FrameSettings)
+	tc.writeSettings()
+	tc.writeSettingsAck()
+	tc.wantFrameType(FrameSettings) // acknowledgement
+	return tc
+}
+
+// startAutoTuningResponse sends a request and starts its response,
+// whose body is not read.
+func startAutoTuningResponse(tc *testClientConn) *testRoundTrip {
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(200)
+	return rt
+}
+
+func wantBDPPing(t *testing.T, tc *testClientConn) {
+	t.Helper()
+	fr := readFrame[*PingFrame](t, tc)
+	if fr.IsAck() || fr.Data != bdpPingData {
+		t.Fatalf("got PING (ack=%v, data=%q), want BDP estimation PING", fr.IsAck(), fr.Data)
+	}
+}
+
+func TestTransportReceiveWindowAutoTuning(t *testing.T) {
+	const limit = 100000
+	tc := newAutoTuningTestClientConn(t, limit)
+	rt := startAutoTuningResponse(tc)
+
+	// The first DATA frame starts a sample, which fills the window.
+	tc.writeData(rt.streamID(), false, make([]byte, 16384))
+	wantBDPPing(t, tc)
+	tc.writeData(rt.streamID(), false, make([]byte, 16384))
+	tc.writeData(rt.streamID(), false, make([]byte, 16384))
+	tc.writeData(rt.streamID(), false, make([]byte, 16383))
+	tc.wantIdle()
+	tc.advance(50 * time.Millisecond)
+	tc.writePing(true, bdpPingData)
+
+	// The window grows to twice the sample, capped at the limit.
+	fr := readFrame[*SettingsFrame](t, tc)
+	if v, ok := fr.Value(SettingInitialWindowSize); !ok || v != limit {
+		t.Fatalf("SETTINGS_INITIAL_WINDOW_SIZE = %v, %v; want %v", v, ok, limit)
+	}
+	tc.wantWindowUpdate(0, limit-initialWindowSize)
+	if got, want := tc.inflowWindow(rt.streamID()), int32(limit-initialWindowSize); got != want {
+		t.Errorf("stream inflow window = %v, want %v", got, want)
+	}
+	if got, want := tc.inflowWindow(0), int32(limit-initialWindowSize); got != want {
+		t.Errorf("connection inflow window = %v, want %v", got, want)
+	}
+	tc.writeSettingsAck()
+
+	// Once the window reaches the limit, estimation stops.
+	tc.writeData(rt.streamID(), false, make([]byte, 1000))
+	tc.wantIdle()
+
+	// New streams use the grown window.
+	rt2 := startAutoTuningResponse(tc)
+	if got, want := tc.inflowWindow(rt2.streamID()), int32(limit); got != want {
+		t.Errorf("new stream inflow window = %v, want %v", got, want)
+	}
+}
+
+func TestTransportReceiveWindowAutoTuningSmallSample(t *testing.T) {
+	tc := newAutoTuningTestClientConn(t, 1<<20)
+	rt := startAutoTuningResponse(tc)
+
+	for i := 0; i < 2; i++ {
+		tc.writeData(rt.streamID(), false, make([]byte, 1000))
+		wantBDPPing(t, tc)
+		tc.advance(50 * time.Millisecond)
+		tc.writePing(true, bdpPingData)
+		// A sample much smaller than the window does not grow it.
+		tc.wantIdle()
+	}
+	if got, want := tc.inflowWindow(rt.streamID()), int32(initialWindowSize-2000); got != want {
+		t.Errorf("stream inflow window = %v, want %v", got, want)
+	}
+}
+
+func TestTransportReceiveWindowAutoTuningEndToEnd(t *testing.T) {
+	const size = 4 << 20
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, size))
+	})
+	tr := &Transport{
+		TLSClientConfig:               tlsConfigInsecure,
+		MaxReceiveBufferPerConnection: initialWindowSize,
+		MaxReceiveBufferPerStream:     initialWindowSize,
+		MaxAutoTunedReceiveBuffer:     1 << 20,
+	}
+	defer tr.CloseIdleConnections()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if err != nil || n != size {
+			t.Fatalf("read %v bytes of response body, %v; want %v", n, err, size)
+		}
+	}
+}
+
 func TestTransportRequestsLowServerLimit(t *testing.T) {
 	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
 	}, func(s *Server) {
@@ -3514,6 +4658,103 @@ func TestTransportRequestsStallAtServerLimit(t *testing.T) {
 	rts[0].wantStatus(200)
 }
 
+func TestTransportMaxQueuedRequests(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.StrictMaxConcurrentStreams = true
+		tr.MaxQueuedRequests = 1
+	})
+	tc.greet(Setting{SettingMaxConcurrentStreams, 1})
+
+	// The first request is sent, the second waits for a stream,
+	// and the third is refused.
+	var rts []*testRoundTrip
+	for k := 0; k < 3; k++ {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("https://dummy.tld/%d", k), nil)
+		rts = append(rts, tc.roundTrip(req))
+	}
+	tc.wantHeaders(wantHeader{
+		streamID:  rts[0].streamID(),
+		endStream: true,
+	})
+	var oerr *OverloadedError
+	if err := rts[2].err(); !errors.As(err, &oerr) {
+		t.Fatalf("RoundTrip(2) = %v, want *OverloadedError", err)
+	}
+	if oerr.Queued != 1 || oerr.Timeout() {
+		t.Errorf("OverloadedError = %+v, want Queued 1 and no timeout", oerr)
+	}
+	if rts[1].done() {
+		t.Fatalf("RoundTrip(1) is done, but should be waiting for a stream")
+	}
+
+	// Finishing the first request sends the second.
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rts[0].streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rts[0].wantStatus(200)
+	tc.wantHeaders(wantHeader{
+		streamID:  rts[1].streamID(),
+		endStream: true,
+	})
+}
+
+func TestTransportMaxQueueWait(t *testing.T) {
+	const wait = 5 * time.Second
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.StrictMaxConcurrentStreams = true
+		tr.MaxQueueWait = wait
+	})
+	tc.greet(Setting{SettingMaxConcurrentStreams, 1})
+
+	req0, _ := http.NewRequest("GET", "https://dummy.tld/0", nil)
+	rt0 := tc.roundTrip(req0)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt0.streamID(),
+		endStream: true,
+	})
+
+	req1, _ := http.NewRequest("GET", "https://dummy.tld/1", nil)
+	rt1 := tc.roundTrip(req1)
+	tc.advance(wait - 1)
+	if rt1.done() {
+		t.Fatalf("RoundTrip(1) is done before MaxQueueWait")
+	}
+	tc.advance(1)
+	var oerr *OverloadedError
+	if err := rt1.err(); !errors.As(err, &oerr) || !oerr.Timeout() {
+		t.Fatalf("RoundTrip(1) = %v, want *OverloadedError with timeout", err)
+	}
+	if oerr.Waited != wait {
+		t.Errorf("OverloadedError.Waited = %v, want %v", oerr.Waited, wait)
+	}
+	if fr := tc.readFrame(); fr != nil {
+		t.Fatalf("got unexpected frame after queue timeout: %v", fr)
+	}
+
+	// The first request is unaffected, and requests may still wait
+	// for its stream.
+	req2, _ := http.NewRequest("GET", "https://dummy.tld/2", nil)
+	rt2 := tc.roundTrip(req2)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt0.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt0.wantStatus(200)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt2.streamID(),
+		endStream: true,
+	})
+}
+
 func TestTransportMaxDecoderHeaderTableSize(t *testing.T) {
 	var reqSize, resSize uint32 = 8192, 16384
 	tc := newTestClientConn(t, func(tr *Transport) {
@@ -3547,6 +4788,71 @@ func TestTransportMaxEncoderHeaderTableSize(t *testing.T) {
 	}
 }
 
+func TestTransportShrinkHeaderTables(t *testing.T) {
+	const decSize, encSize = 1024, 256
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.MaxDecoderHeaderTableSize = decSize
+		tr.MaxEncoderHeaderTableSize = encSize
+	})
+	fr := readFrame[*SettingsFrame](t, tc)
+	if v, _ := fr.Value(SettingHeaderTableSize); v != decSize {
+		t.Fatalf("received SETTINGS_HEADER_TABLE_SIZE = %d, want %d", v, decSize)
+	}
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.writeSettings()
+	tc.writeSettingsAck()
+	tc.wantFrameType(FrameSettings) // acknowledgement
+
+	// The peer's default table size is capped at the encoder limit,
+	// and the reduced size is signaled in the first header block.
+	if got := tc.cc.henc.MaxDynamicTableSize(); got != encSize {
+		t.Fatalf("henc.MaxDynamicTableSize() = %d, want %d", got, encSize)
+	}
+	big := strings.Repeat("a", 100)
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+		req.Header.Set("X-Big", big)
+		rt := tc.roundTrip(req)
+		tc.wantHeaders(wantHeader{
+			streamID:  rt.streamID(),
+			endStream: true,
+			header:    http.Header{"x-big": {big}},
+		})
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:   rt.streamID(),
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+			),
+		})
+		rt.wantStatus(200)
+	}
+
+	// A peer resizing its encoder's table beyond the advertised
+	// decoder table size is a connection error.
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.enc.SetMaxDynamicTableSizeLimit(2 * decSize)
+	tc.enc.SetMaxDynamicTableSize(2 * decSize)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	if err := rt.err(); err == nil {
+		t.Fatalf("RoundTrip succeeded with oversized header table update, want error")
+	}
+	ga := readFrame[*GoAwayFrame](t, tc)
+	if ga.ErrCode != ErrCodeCompression {
+		t.Errorf("GOAWAY error code = %v, want %v", ga.ErrCode, ErrCodeCompression)
+	}
+}
+
 func TestAuthorityAddr(t *testing.T) {
 	tests := []struct {
 		scheme, authority string
@@ -3806,23 +5112,99 @@ func benchLargeDownloadRoundTrip(b *testing.B, frameSize uint32) {
 	}
 }
 
-func activeStreams(cc *ClientConn) int {
-	count := 0
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	for _, cs := range cc.streams {
-		select {
-		case <-cs.abort:
-		default:
-			count++
-		}
-	}
-	return count
+func BenchmarkClientSmallDataFrames(b *testing.B) {
+	b.Run("  1 Stream", func(b *testing.B) { benchSmallDataFrames(b, 1) })
+	b.Run(" 10 Streams", func(b *testing.B) { benchSmallDataFrames(b, 10) })
+	b.Run("100 Streams", func(b *testing.B) { benchSmallDataFrames(b, 100) })
 }
 
-type closeMode int
-
-const (
+// benchSmallDataFrames measures reading responses sent as many small
+// DATA frames, on several concurrent streams.
+func benchSmallDataFrames(b *testing.B, streams int) {
+	disableGoroutineTracking(b)
+	const (
+		frames    = 1000
+		frameSize = 16
+	)
+	b.ReportAllocs()
+	ts := newTestServer(b,
+		func(w http.ResponseWriter, r *http.Request) {
+			var data [frameSize]byte
+			for i := 0; i < frames; i++ {
+				w.Write(data[:])
+				w.(http.Flusher).Flush()
+			}
+		}, optQuiet,
+	)
+
+	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+	defer tr.CloseIdleConnections()
+
+	b.SetBytes(int64(streams * frames * frameSize))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		errc := make(chan error, streams)
+		for j := 0; j < streams; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				res, err := tr.RoundTrip(req)
+				if err != nil {
+					errc <- err
+					return
+				}
+				defer res.Body.Close()
+				n, err := io.Copy(io.Discard, res.Body)
+				if err == nil && n != frames*frameSize {
+					err = fmt.Errorf("read %v bytes, want %v", n, frames*frameSize)
+				}
+				if err != nil {
+					errc <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTransportFlushesPingAckBeforePartialFrame(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	// A PING arrives along with the start of another frame.
+	// The ack must not wait for the rest of that frame.
+	var buf bytes.Buffer
+	fr := NewFramer(&buf, nil)
+	fr.WritePing(false, [8]byte{1})
+	fr.WritePing(false, [8]byte{2})
+	tc.netconn.Write(buf.Bytes()[:buf.Len()-4])
+	tc.wantFrameType(FramePing)
+}
+
+func activeStreams(cc *ClientConn) int {
+	count := 0
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, cs := range cc.streams {
+		select {
+		case <-cs.abort:
+		default:
+			count++
+		}
+	}
+	return count
+}
+
+type closeMode int
+
+const (
 	closeAtHeaders closeMode = iota
 	closeAtBody
 	shutdown
@@ -4002,6 +5384,51 @@ func TestClientConnShutdownCancel(t *testing.T) {
 	testClientConnClose(t, shutdownCancel)
 }
 
+func TestClientConnShutdownPendingRequests(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.StrictMaxConcurrentStreams = true
+	})
+	tc.greet(Setting{SettingMaxConcurrentStreams, 1})
+
+	req1, _ := http.NewRequest("GET", "https://dummy.tld/1", nil)
+	rt1 := tc.roundTrip(req1)
+	tc.wantFrameType(FrameHeaders)
+	req2, _ := http.NewRequest("GET", "https://dummy.tld/2", nil)
+	rt2 := tc.roundTrip(req2)
+	tc.wantIdle()
+	if got := tc.cc.State().StreamsPending; got != 1 {
+		t.Fatalf("StreamsPending = %v, want 1", got)
+	}
+
+	donec := make(chan error, 1)
+	go func() {
+		tc.group.Join()
+		donec <- tc.cc.Shutdown(context.Background())
+	}()
+	tc.sync()
+	tc.wantFrameType(FrameGoAway)
+	if err := rt2.err(); err != errClientConnUnusable {
+		t.Errorf("pending request error = %v, want %v", err, errClientConnUnusable)
+	}
+	select {
+	case err := <-donec:
+		t.Fatalf("Shutdown returned %v with a stream running", err)
+	default:
+	}
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt1.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt1.wantStatus(200)
+	if err := <-donec; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	tc.wantClosed()
+}
+
 // Issue 25009: use Request.GetBody if present, even if it seems like
 // we might not need it. Apparently something else can still read from
 // the original request body. Data race? In any case, rewinding
@@ -4195,6 +5622,147 @@ func testTransportBodyLargerThanSpecifiedContentLength(t *testing.T, body *chunk
 	}
 }
 
+func TestTransportBodyShorterThanSpecifiedContentLength(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("POST", "https://dummy.tld/", io.NopCloser(strings.NewReader("abc")))
+	req.ContentLength = 5
+	rt := tc.roundTrip(req)
+	tc.wantHeaders(wantHeader{
+		streamID:  rt.streamID(),
+		endStream: false,
+		header: http.Header{
+			"content-length": []string{"5"},
+		},
+	})
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: false,
+		data:      []byte("abc"),
+	})
+	tc.wantRSTStream(rt.streamID(), ErrCodeCancel)
+	if err := rt.err(); err != errReqBodyTooShort {
+		t.Fatalf("RoundTrip = %v, want %v", err, errReqBodyTooShort)
+	}
+}
+
+func TestTransportMaxBufferedRequestBodySize(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		body          string
+		contentLength []string
+	}{{
+		name:          "buffered",
+		body:          "hello",
+		contentLength: []string{"5"},
+	}, {
+		name:          "too long",
+		body:          "hello, world",
+		contentLength: nil,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			tt := newTestTransport(t, func(tr *Transport) {
+				tr.MaxBufferedRequestBodySize = 10
+			})
+			body := io.NopCloser(strings.NewReader(test.body))
+			req, _ := http.NewRequest("PUT", "https://dummy.tld/", body)
+			rt := tt.roundTrip(req)
+			tc := tt.getConn()
+			tc.wantFrameType(FrameSettings)
+			tc.wantFrameType(FrameWindowUpdate)
+			tc.wantHeaders(wantHeader{
+				streamID:  1,
+				endStream: false,
+				header: http.Header{
+					"content-length": test.contentLength,
+				},
+			})
+			tc.wantData(wantData{
+				streamID:  1,
+				endStream: true,
+				data:      []byte(test.body),
+				multiple:  true,
+			})
+			tc.writeSettings()
+			tc.writeHeaders(HeadersFrameParam{
+				StreamID:   1,
+				EndHeaders: true,
+				EndStream:  true,
+				BlockFragment: tc.makeHeaderBlockFragment(
+					":status", "200",
+				),
+			})
+			rt.wantStatus(200)
+		})
+	}
+}
+
+func TestTransportBufferedRequestBodyRetried(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxBufferedRequestBodySize = 10
+	})
+	req, _ := http.NewRequest("PUT", "https://dummy.tld/", io.NopCloser(strings.NewReader("hello")))
+	rt := tt.roundTrip(req)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+
+	// The body is buffered, so the request can be retried after the
+	// server refuses it, although it has no GetBody.
+	for _, streamID := range []uint32{1, 3} {
+		tc.wantHeaders(wantHeader{
+			streamID:  streamID,
+			endStream: false,
+			header: http.Header{
+				"content-length": []string{"5"},
+			},
+		})
+		tc.wantData(wantData{
+			streamID:  streamID,
+			endStream: true,
+			data:      []byte("hello"),
+		})
+		if streamID == 1 {
+			tc.writeSettings()
+			tc.wantFrameType(FrameSettings) // ACK
+			tc.writeRSTStream(streamID, ErrCodeRefusedStream)
+		}
+	}
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   3,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(200)
+}
+
+func TestTransportBufferRequestBodyCanceled(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxBufferedRequestBodySize = 10
+	})
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "PUT", "https://dummy.tld/", pr)
+	rt := tt.roundTrip(req)
+	if rt.done() {
+		t.Fatalf("RoundTrip finished before its body was read")
+	}
+
+	cancel()
+	tt.sync()
+	if err := rt.err(); err != context.Canceled {
+		t.Fatalf("RoundTrip = %v, want %v", err, context.Canceled)
+	}
+	if _, err := pw.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("writing to request body after cancel = %v, want %v", err, io.ErrClosedPipe)
+	}
+	tt.wantDials()
+}
+
 func TestClientConnTooIdle(t *testing.T) {
 	tests := []struct {
 		cc   func() *ClientConn
@@ -5063,6 +6631,58 @@ func TestTransportWriteByteTimeout(t *testing.T) {
 	}
 }
 
+func TestTransportWriteByteTimeoutConnNotReused(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.WriteByteTimeout = 1 * time.Millisecond
+	})
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tt.wantDials("dummy.tld:443")
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+
+	// The peer stops reading, so the next request's writes time out.
+	tc.netconn.SetReadBufferSize(0)
+	rt = tt.roundTrip(req)
+	tt.advance(1 * time.Millisecond)
+	if err := rt.err(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("RoundTrip on unresponsive connection: got %v; want ErrDeadlineExceeded", err)
+	}
+
+	// A timed out connection must not be reused.
+	rt = tt.roundTrip(req)
+	tt.wantDials("dummy.tld:443")
+	tc = tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
+
 type slowWriteConn struct {
 	net.Conn
 	hasWriteDeadline bool
@@ -5259,6 +6879,61 @@ func TestTransportDialTLSContext(t *testing.T) {
 	}
 }
 
+// TestTransportDialWaitCanceled tests that a request waiting for a
+// dial started by another request stops waiting when its context is
+// canceled, and the dial continues for the request which started it.
+func TestTransportDialWaitCanceled(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			close(dialing)
+			<-release
+			return tls.Dial(network, addr, cfg)
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	errc1 := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc1 <- err
+	}()
+	<-dialing
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waiting := make(chan struct{})
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) { close(waiting) },
+	})
+	errc2 := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc2 <- err
+	}()
+	<-waiting
+	cancel()
+	if err := <-errc2; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled request waiting for dial: %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := <-errc1; err != nil {
+		t.Errorf("request which started dial: %v", err)
+	}
+}
+
 // TestDialRaceResumesDial tests that, given two concurrent requests
 // to the same address, when the first Dial is interrupted because
 // the first request's context is cancelled, the second request
@@ -5348,40 +7023,209 @@ func TestDialRaceResumesDial(t *testing.T) {
 	}
 }
 
-func TestTransportDataAfter1xxHeader(t *testing.T) {
-	// Discard logger output to avoid spamming stderr.
-	log.SetOutput(io.Discard)
-	defer log.SetOutput(os.Stderr)
-
-	// https://go.dev/issue/65927 - server sends a 1xx response, followed by a DATA frame.
+func TestTransportInterimResponses(t *testing.T) {
 	tc := newTestClientConn(t)
 	tc.greet()
 
 	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	req = req.WithContext(WithInterimResponses(req.Context()))
 	rt := tc.roundTrip(req)
 
-	tc.wantFrameType(FrameHeaders)
 	tc.writeHeaders(HeadersFrameParam{
 		StreamID:   rt.streamID(),
 		EndHeaders: true,
-		EndStream:  false,
 		BlockFragment: tc.makeHeaderBlockFragment(
-			":status", "100",
+			":status", "103",
+			"link", "</style.css>; rel=preload; as=style",
+			"link", "</script.js>; rel=preload; as=script",
+		),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "199",
+			"x-foo", "bar",
+		),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "204",
 		),
 	})
-	tc.writeData(rt.streamID(), true, []byte{0})
-	err := rt.err()
-	if err, ok := err.(StreamError); !ok || err.Code != ErrCodeProtocol {
-		t.Errorf("RoundTrip error: %v; want ErrCodeProtocol", err)
-	}
-	tc.wantFrameType(FrameRSTStream)
-}
 
-func TestIssue66763Race(t *testing.T) {
-	tr := &Transport{
-		IdleConnTimeout: 1 * time.Nanosecond,
-		AllowHTTP:       true, // issue 66763 only occurs when AllowHTTP is true
-	}
+	res := rt.response()
+	if res.StatusCode != 204 {
+		t.Fatalf("status code = %v; want 204", res.StatusCode)
+	}
+	want := []InterimResponse{{
+		StatusCode: 103,
+		Header: http.Header{
+			"Link": {"</style.css>; rel=preload; as=style", "</script.js>; rel=preload; as=script"},
+		},
+		PseudoFields: []hpack.HeaderField{{Name: ":status", Value: "103"}},
+	}, {
+		StatusCode:   199,
+		Header:       http.Header{"X-Foo": {"bar"}},
+		PseudoFields: []hpack.HeaderField{{Name: ":status", Value: "199"}},
+	}}
+	if got := InterimResponses(res); !reflect.DeepEqual(got, want) {
+		t.Errorf("InterimResponses = %+v\nwant %+v", got, want)
+	}
+	if got := InterimResponses(res); got != nil {
+		t.Errorf("second InterimResponses = %+v, want nil", got)
+	}
+}
+
+func TestTransportInterimResponsesForgottenOnClose(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	ctx := WithInterimResponses(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "103"),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	res := rt.response()
+	res.Body.Close()
+	if r := contextInterimRecorder(ctx); len(r.res) != 0 {
+		t.Errorf("after closing the body, recorder holds %v requests, want 0", len(r.res))
+	}
+}
+
+func TestTransportInterimResponsesNotRecorded(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "103"),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "204"),
+	})
+	if got := InterimResponses(rt.response()); got != nil {
+		t.Errorf("InterimResponses = %+v, want nil for request without WithInterimResponses", got)
+	}
+}
+
+func TestTransportGot1xxResponseTrace(t *testing.T) {
+	type interim struct {
+		code   int
+		header textproto.MIMEHeader
+	}
+	var got []interim
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			got = append(got, interim{code, header})
+			return nil
+		},
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "103",
+			"link", "</style.css>; rel=preload; as=style",
+		),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "204"),
+	})
+	rt.wantStatus(204)
+
+	want := []interim{{
+		code:   103,
+		header: textproto.MIMEHeader{"Link": {"</style.css>; rel=preload; as=style"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got1xxResponse calls = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestTransportGot1xxResponseTraceError(t *testing.T) {
+	errStop := errors.New("stop")
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			return errStop
+		},
+	})
+	req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "103"),
+	})
+	if err, ok := rt.err().(StreamError); !ok || err.Cause != errStop {
+		t.Errorf("RoundTrip error: %v; want StreamError caused by %v", rt.err(), errStop)
+	}
+	tc.wantFrameType(FrameRSTStream)
+}
+
+func TestTransportDataAfter1xxHeader(t *testing.T) {
+	// Discard logger output to avoid spamming stderr.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// https://go.dev/issue/65927 - server sends a 1xx response, followed by a DATA frame.
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "100",
+		),
+	})
+	tc.writeData(rt.streamID(), true, []byte{0})
+	err := rt.err()
+	if err, ok := err.(StreamError); !ok || err.Code != ErrCodeProtocol {
+		t.Errorf("RoundTrip error: %v; want ErrCodeProtocol", err)
+	}
+	tc.wantFrameType(FrameRSTStream)
+}
+
+func TestIssue66763Race(t *testing.T) {
+	tr := &Transport{
+		IdleConnTimeout: 1 * time.Nanosecond,
+		AllowHTTP:       true, // issue 66763 only occurs when AllowHTTP is true
+	}
 	defer tr.CloseIdleConnections()
 
 	cli, srv := net.Pipe()
@@ -5401,3 +7245,2631 @@ func TestIssue66763Race(t *testing.T) {
 
 	<-donec
 }
+
+func TestTransportReuseConnVeto(t *testing.T) {
+	var gotStates []ClientConnState
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.ReuseConn = func(req *http.Request, state ClientConnState) bool {
+			gotStates = append(gotStates, state)
+			return req.Header.Get("X-Isolated") == "" || state.StreamsActive == 0
+		}
+	})
+
+	// Request #1 dials the first connection and remains in progress.
+	req1, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt1 := tt.roundTrip(req1)
+	tc1 := tt.getConn()
+	tc1.wantFrameType(FrameSettings)
+	tc1.wantFrameType(FrameWindowUpdate)
+	tc1.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc1.writeSettings()
+	tc1.wantFrameType(FrameSettings) // settings ACK
+	if len(gotStates) != 0 {
+		t.Fatalf("ReuseConn called %v times for first request; want 0", len(gotStates))
+	}
+
+	// Request #2 shares the first connection.
+	req2, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt2 := tt.roundTrip(req2)
+	tc1.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	if len(gotStates) != 1 || gotStates[0].StreamsActive != 1 {
+		t.Fatalf("ReuseConn states = %+v; want one call with StreamsActive=1", gotStates)
+	}
+
+	// Request #3 rejects the busy connection and gets a new one.
+	req3, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	req3.Header.Set("X-Isolated", "1")
+	rt3 := tt.roundTrip(req3)
+	tc2 := tt.getConn()
+	tc2.wantFrameType(FrameSettings)
+	tc2.wantFrameType(FrameWindowUpdate)
+	tc2.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc2.writeSettings()
+	tc2.wantFrameType(FrameSettings) // settings ACK
+	if tt.hasConn() {
+		t.Fatalf("unexpected extra connection dialed")
+	}
+
+	writeOK := func(tc *testClientConn, streamID uint32) {
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:   streamID,
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+			),
+		})
+	}
+	writeOK(tc1, 1)
+	writeOK(tc1, 3)
+	writeOK(tc2, 1)
+	rt1.wantStatus(200)
+	rt2.wantStatus(200)
+	rt3.wantStatus(200)
+}
+
+func TestTransportContinuationFlood(t *testing.T) {
+	var countErr []string
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.CountError = func(errType string) {
+			countErr = append(countErr, errType)
+		}
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: false,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	// Empty CONTINUATION frames don't grow the header list,
+	// but they are still limited in number.
+	for i := 0; i < 1000; i++ {
+		tc.writeContinuation(1, false, nil)
+	}
+	tc.wantGoAway(0, ErrCodeEnhanceYourCalm)
+	if err := rt.err(); err == nil {
+		t.Fatalf("RoundTrip succeeded; want error")
+	}
+	if got, want := strings.Join(countErr, ","), "frame_continuation_too_many,read_frame_conn_error_ENHANCE_YOUR_CALM"; got != want {
+		t.Errorf("CountError calls = %q; want %q", got, want)
+	}
+}
+
+func TestTransportDialControl(t *testing.T) {
+	var serverCalls atomic.Int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {}, func(s *Server) {
+		s.ConnControl = func(c syscall.RawConn) error {
+			serverCalls.Add(1)
+			return c.Control(func(fd uintptr) {})
+		}
+	})
+	var dialed []string
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		DialControl: func(network, address string, c syscall.RawConn) error {
+			dialed = append(dialed, network+" "+address)
+			return c.Control(func(fd uintptr) {})
+		},
+	}
+	defer tr.CloseIdleConnections()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	addr := strings.TrimPrefix(ts.URL, "https://")
+	if len(dialed) != 1 || !strings.HasSuffix(dialed[0], " "+addr) {
+		t.Errorf("DialControl calls = %q; want one for %v", dialed, addr)
+	}
+	if got := serverCalls.Load(); got != 1 {
+		t.Errorf("ConnControl calls = %v; want 1", got)
+	}
+}
+
+func TestTransportPreconnect(t *testing.T) {
+	tt := newTestTransport(t)
+
+	donec := make(chan error, 1)
+	go func() {
+		tt.group.Join()
+		donec <- tt.tr.Preconnect(context.Background(), "dummy.tld", 2)
+	}()
+	tt.sync()
+
+	for i := 0; i < 2; i++ {
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		ping := readFrame[*PingFrame](t, tc)
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		tc.writePing(true, ping.Data)
+	}
+	select {
+	case err := <-donec:
+		if err != nil {
+			t.Fatalf("Preconnect: %v", err)
+		}
+	default:
+		t.Fatalf("Preconnect still running after connections are ready")
+	}
+
+	states := tt.tr.ConnStates("dummy.tld:443")
+	if len(states) != 2 {
+		t.Fatalf("ConnStates returned %v connections; want 2", len(states))
+	}
+	for _, st := range states {
+		if st.Closed || st.MaxConcurrentStreams == 0 {
+			t.Errorf("preconnected connection state = %+v; want open with SETTINGS received", st)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	tt.roundTrip(req)
+	if tt.hasConn() {
+		t.Fatalf("RoundTrip dialed a new connection; want it to use a preconnected one")
+	}
+}
+
+func TestTransportPreconnectMaxConnsPerHost(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxConnsPerHost = 1
+	})
+
+	donec := make(chan error, 1)
+	go func() {
+		tt.group.Join()
+		donec <- tt.tr.Preconnect(context.Background(), "dummy.tld", 2)
+	}()
+	tt.sync()
+
+	// Only one connection is dialed.
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	ping := readFrame[*PingFrame](t, tc)
+	tc.writeSettings()
+	tc.wantFrameType(FrameSettings) // settings ACK
+	tc.writePing(true, ping.Data)
+	if tt.hasConn() {
+		t.Fatalf("Preconnect dialed a second connection, want at most one")
+	}
+	select {
+	case err := <-donec:
+		if err != ErrMaxConnsPerHost {
+			t.Fatalf("Preconnect = %v, want ErrMaxConnsPerHost", err)
+		}
+	default:
+		t.Fatalf("Preconnect still running after connections are ready")
+	}
+	if states := tt.tr.ConnStates("dummy.tld:443"); len(states) != 1 {
+		t.Fatalf("ConnStates returned %v connections; want 1", len(states))
+	}
+}
+
+func TestClientConnState(t *testing.T) {
+	tc := newTestClientConn(t)
+	st := tc.cc.State()
+	if st.MaxConcurrentStreams != 0 || st.PeerMaxFrameSize != 16<<10 || st.PeerInitialWindowSize != initialWindowSize {
+		t.Errorf("before SETTINGS: state = %+v; want MaxConcurrentStreams 0 and initial peer settings", st)
+	}
+
+	tc.greet(
+		Setting{SettingMaxConcurrentStreams, 10},
+		Setting{SettingMaxFrameSize, 32 << 10},
+		Setting{SettingInitialWindowSize, 100000},
+		Setting{SettingMaxHeaderListSize, 1 << 20},
+	)
+	req, _ := http.NewRequest("PUT", "https://dummy.tld/", bytes.NewReader(make([]byte, 100)))
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: true,
+		size:      100,
+	})
+
+	st = tc.cc.State()
+	want := ClientConnState{
+		StreamsActive:         1,
+		MaxConcurrentStreams:  10,
+		CanTakeNewRequest:     true,
+		PeerMaxFrameSize:      32 << 10,
+		PeerInitialWindowSize: 100000,
+		PeerMaxHeaderListSize: 1 << 20,
+		PeerHeaderTableSize:   initialHeaderTableSize,
+		SendWindow:            initialWindowSize - 100,
+		ReceiveWindow:         tc.inflowWindow(0),
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("with active stream: state =\n%+v\nwant\n%+v", st, want)
+	}
+
+	tc.writeGoAway(rt.streamID(), ErrCodeNo, []byte("bye"))
+	st = tc.cc.State()
+	wantGoAway := &GoAwayError{
+		LastStreamID: rt.streamID(),
+		ErrCode:      ErrCodeNo,
+		DebugData:    "bye",
+	}
+	if !st.Closing || st.CanTakeNewRequest || !reflect.DeepEqual(st.GoAway, wantGoAway) {
+		t.Errorf("after GOAWAY: state = %+v; want Closing, not CanTakeNewRequest, GoAway %+v", st, wantGoAway)
+	}
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
+
+func TestTransportAddClientConn(t *testing.T) {
+	tt := newTestTransport(t)
+
+	cc, err := tt.tr.NewClientConn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := tt.getConn()
+	if err := tt.tr.AddClientConn("dummy.tld", cc); err != nil {
+		t.Fatalf("AddClientConn: %v", err)
+	}
+	if err := (&Transport{}).AddClientConn("dummy.tld", cc); err == nil {
+		t.Errorf("AddClientConn of another Transport's ClientConn succeeded; want error")
+	}
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tt.wantDials()
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
+
+func TestTransportAddClientConnMaxConnsPerHost(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxConnsPerHost = 1
+	})
+	for i, want := range []error{nil, ErrMaxConnsPerHost} {
+		cc, err := tt.tr.NewClientConn(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.getConn()
+		if err := tt.tr.AddClientConn("dummy.tld", cc); err != want {
+			t.Errorf("AddClientConn #%v = %v, want %v", i, err, want)
+		}
+	}
+	if states := tt.tr.ConnStates("dummy.tld:443"); len(states) != 1 {
+		t.Fatalf("ConnStates returned %v connections; want 1", len(states))
+	}
+}
+
+func TestTransportAddClientConnUnusable(t *testing.T) {
+	tt := newTestTransport(t)
+
+	cc, err := tt.tr.NewClientConn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.getConn()
+	cc.Close()
+	if err := tt.tr.AddClientConn("dummy.tld", cc); err != errClientConnUnusable {
+		t.Errorf("AddClientConn of closed ClientConn = %v, want %v", err, errClientConnUnusable)
+	}
+}
+
+func TestTransportConnPoolEvents(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []PoolEvent
+	)
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.ConnPoolEvent = func(ev PoolEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		}
+	})
+	wantEvents := func(want ...PoolEvent) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("pool events = %v; want %v", events, want)
+		}
+		events = nil
+	}
+	roundTrip := func() *testClientConn {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+		rt := tt.roundTrip(req)
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings()
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      1,
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+		})
+		rt.wantStatus(200)
+		return tc
+	}
+
+	tc1 := roundTrip()
+	wantEvents(PoolEvent{Kind: PoolConnAdded, Addr: "dummy.tld:443", Conn: tc1.cc})
+	pooled := tt.tr.PooledConns()
+	if len(pooled) != 1 || pooled[0].Addr != "dummy.tld:443" || pooled[0].Conn != tc1.cc || !pooled[0].State.CanTakeNewRequest {
+		t.Fatalf("PooledConns = %+v; want the one usable connection", pooled)
+	}
+
+	// A removed connection is closed once idle, and new requests
+	// dial a new connection.
+	if ok, err := tt.tr.RemoveClientConn(tc1.cc); !ok || err != nil {
+		t.Fatalf("RemoveClientConn = %v, %v; want true, nil", ok, err)
+	}
+	wantEvents(PoolEvent{Kind: PoolConnRemoved, Addr: "dummy.tld:443", Conn: tc1.cc})
+	if pooled := tt.tr.PooledConns(); len(pooled) != 0 {
+		t.Fatalf("after RemoveClientConn, PooledConns = %+v; want none", pooled)
+	}
+	tc1.sync()
+	if !tc1.isClosed() {
+		t.Errorf("removed idle connection is not closed")
+	}
+	if ok, _ := tt.tr.RemoveClientConn(tc1.cc); ok {
+		t.Errorf("RemoveClientConn of removed connection = true; want false")
+	}
+
+	tc2 := roundTrip()
+	wantEvents(PoolEvent{Kind: PoolConnAdded, Addr: "dummy.tld:443", Conn: tc2.cc})
+	tc2.writeGoAway(1, ErrCodeNo, nil)
+	wantEvents(
+		PoolEvent{Kind: PoolConnMarkedDead, Conn: tc2.cc},
+		PoolEvent{Kind: PoolConnRemoved, Addr: "dummy.tld:443", Conn: tc2.cc},
+	)
+
+	if _, err := (&Transport{ConnPool: noDialClientConnPool{}}).RemoveClientConn(tc2.cc); err == nil {
+		t.Errorf("RemoveClientConn with a custom ConnPool succeeded; want error")
+	}
+}
+
+func TestTransportDialClientConn(t *testing.T) {
+	var dials []string
+	tt := newTestTransport(t)
+	tt.tr.DialClientConn = func(ctx context.Context, addr string) (*ClientConn, error) {
+		dials = append(dials, addr)
+		return tt.tr.NewClientConn(nil)
+	}
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	if want := []string{"dummy.tld:443"}; !reflect.DeepEqual(dials, want) {
+		t.Errorf("DialClientConn called with %q, want %q", dials, want)
+	}
+	tt.wantDials()
+	tc.writeSettings()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+}
+
+func TestTransportDialClientConnForeign(t *testing.T) {
+	tr := &Transport{
+		DialClientConn: func(ctx context.Context, addr string) (*ClientConn, error) {
+			c1, c2 := net.Pipe()
+			go io.Copy(io.Discard, c2)
+			return (&Transport{}).NewClientConn(c1)
+		},
+	}
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	if _, err := tr.RoundTrip(req); err != errForeignClientConn {
+		t.Errorf("RoundTrip error = %v, want %v", err, errForeignClientConn)
+	}
+}
+
+func TestTransportResponseBodyWriteTo(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	want := make([]byte, 40000)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	tc.writeData(1, false, want[:10000])
+	tc.writeData(1, false, want[10000:26000])
+	tc.writeData(1, true, want[26000:])
+
+	res := rt.response()
+	wt, ok := res.Body.(io.WriterTo)
+	if !ok {
+		t.Fatalf("response body type %T does not implement io.WriterTo", res.Body)
+	}
+	var got bytes.Buffer
+	n, err := wt.WriteTo(&got)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(len(want)) || !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("WriteTo copied %v bytes, %v match; want %v bytes", n, bytes.Equal(got.Bytes(), want), len(want))
+	}
+}
+
+func TestTransportConnectionCoalescing(t *testing.T) {
+	for _, enable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enable=%v", enable), func(t *testing.T) {
+			testTransportConnectionCoalescing(t, enable)
+		})
+	}
+}
+
+func testTransportConnectionCoalescing(t *testing.T, enable bool) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Remote", r.RemoteAddr)
+	})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host != "example.com" {
+			return nil, fmt.Errorf("unexpected lookup of %q", host)
+		}
+		if _, ok := ctx.Deadline(); enable && !ok {
+			t.Errorf("coalescing lookup of %q has no deadline", host)
+		}
+		return []net.IPAddr{{IP: net.ParseIP(u.Hostname())}}, nil
+	}
+
+	var dials int
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		CoalesceByDNS:   enable,
+		DialControl: func(network, address string, c syscall.RawConn) error {
+			dials++
+			return nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	var remotes []string
+	for _, host := range []string{u.Host, "example.com:" + port} {
+		req, _ := http.NewRequest("GET", "https://"+host+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("X-Host"); got != host {
+			t.Errorf("server saw Host %q; want %q", got, host)
+		}
+		remotes = append(remotes, res.Header.Get("X-Remote"))
+	}
+	wantDials := 2
+	if enable {
+		wantDials = 1
+	}
+	if dials != wantDials {
+		t.Errorf("dialed %v connections; want %v", dials, wantDials)
+	}
+	if coalesced := remotes[0] == remotes[1]; coalesced != enable {
+		t.Errorf("requests from %q; coalesced = %v, want %v", remotes, coalesced, enable)
+	}
+}
+
+func TestTransportConnectionCoalescingRequiresPeerIP(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	// example.com's certificate is valid, but the coalescing lookup
+	// resolves it to an address other than the existing connection's
+	// peer. The dial which follows reaches the server.
+	lookups := 0
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if lookups == 1 {
+			return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP(u.Hostname())}}, nil
+	}
+
+	var dials int
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		CoalesceByDNS:   true,
+		DialControl: func(network, address string, c syscall.RawConn) error {
+			dials++
+			return nil
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	for _, host := range []string{u.Host, "example.com:" + port} {
+		req, _ := http.NewRequest("GET", "https://"+host+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if dials != 2 {
+		t.Errorf("dialed %v connections; want 2", dials)
+	}
+}
+
+func TestTransportConnectionCoalescingCustomDialer(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		t.Errorf("unexpected lookup of %q", host)
+		return []net.IPAddr{{IP: net.ParseIP(u.Hostname())}}, nil
+	}
+
+	// The custom dialer, not the Transport, decides where
+	// connections go, so coalescing does not resolve hosts.
+	var dials int
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		CoalesceByDNS:   true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			dials++
+			cfg = cfg.Clone()
+			cfg.InsecureSkipVerify = true
+			return tls.Dial(network, u.Host, cfg)
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	for _, host := range []string{u.Host, "example.com:" + port} {
+		req, _ := http.NewRequest("GET", "https://"+host+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if dials != 2 {
+		t.Errorf("dialed %v connections; want 2", dials)
+	}
+}
+
+func TestTransportOriginFrameCoalescing(t *testing.T) {
+	var srv *Server
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Remote", r.RemoteAddr)
+	}, func(s *Server) {
+		srv = s
+	})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	srv.Origins = []string{"https://a.example.com:" + port}
+
+	// Hosts resolve to the server, so without ORIGIN frames requests
+	// for any of them could be coalesced.
+	var lookups []string
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups = append(lookups, host)
+		return []net.IPAddr{{IP: net.ParseIP(u.Hostname())}}, nil
+	}
+
+	var dials []string
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			dials = append(dials, addr)
+			cfg = cfg.Clone()
+			cfg.InsecureSkipVerify = true
+			return tls.Dial(network, u.Host, cfg)
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	var remotes []string
+	for _, host := range []string{u.Host, "a.example.com:" + port, "b.example.com:" + port} {
+		req, _ := http.NewRequest("GET", "https://"+host+"/", nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		remotes = append(remotes, res.Header.Get("X-Remote"))
+	}
+
+	// The origin listed in the ORIGIN frame uses the first connection
+	// without a DNS lookup; other origins get their own connection.
+	if want := []string{u.Host, "b.example.com:" + port}; !reflect.DeepEqual(dials, want) {
+		t.Errorf("dialed %q; want %q", dials, want)
+	}
+	if remotes[0] != remotes[1] || remotes[0] == remotes[2] {
+		t.Errorf("requests from %q; want the first two on one connection", remotes)
+	}
+	if len(lookups) != 0 {
+		t.Errorf("looked up %q; want no lookups", lookups)
+	}
+	states := tr.ConnStates(u.Host)
+	if len(states) != 1 {
+		t.Fatalf("ConnStates returned %v connections; want 1", len(states))
+	}
+	if !containsString(states[0].Origins, "a.example.com:"+port) {
+		t.Errorf("connection Origins = %q; want it to include a.example.com:%v", states[0].Origins, port)
+	}
+}
+
+func TestTransportOriginSetUsesDialedPort(t *testing.T) {
+	// The connection is dialed for example.com:443, but reaches a
+	// server listening on another port, as through an alternative
+	// service. Its origin set starts with the origin it was dialed for.
+	var srv *Server
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {}, func(s *Server) {
+		srv = s
+	})
+	u, _ := url.Parse(ts.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	srv.Origins = []string{"https://a.example.com"}
+
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			cfg = cfg.Clone()
+			cfg.InsecureSkipVerify = true
+			return tls.Dial(network, u.Host, cfg)
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	states := tr.ConnStates("example.com:443")
+	if len(states) != 1 {
+		t.Fatalf("ConnStates returned %v connections; want 1", len(states))
+	}
+	if want := []string{"a.example.com:443", "example.com:443"}; !reflect.DeepEqual(states[0].Origins, want) {
+		t.Errorf("connection Origins = %q; want %q (not the server's port %v)", states[0].Origins, want, port)
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTransportReplaceOnGoAway(t *testing.T) {
+	// ConnDrain is called on the connection's read loop goroutine.
+	var (
+		mu     sync.Mutex
+		events []ConnDrainInfo
+	)
+	drainEvents := func() []ConnDrainInfo {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ConnDrainInfo(nil), events...)
+	}
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.ReplaceOnGoAway = true
+		tr.ConnDrain = func(info ConnDrainInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, info)
+		}
+	})
+
+	req1, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt1 := tt.roundTrip(req1)
+	tc1 := tt.getConn()
+	tc1.wantFrameType(FrameSettings)
+	tc1.wantFrameType(FrameWindowUpdate)
+	tc1.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc1.writeSettings()
+	tc1.wantFrameType(FrameSettings) // settings ACK
+
+	// A graceful GOAWAY dials a replacement connection before
+	// any new request needs one.
+	tc1.writeGoAway(1, ErrCodeNo, nil)
+	tc2 := tt.getConn()
+	tc2.wantFrameType(FrameSettings)
+	tc2.wantFrameType(FrameWindowUpdate)
+	tc2.writeSettings()
+	tc2.wantFrameType(FrameSettings) // settings ACK
+	drained := drainEvents()
+	if len(drained) != 1 {
+		t.Fatalf("got %v ConnDrain events, want 1", len(drained))
+	}
+	if got := drained[0]; got.ErrCode != ErrCodeNo || got.LastStreamID != 1 ||
+		got.StreamsActive != 1 || !got.Replaced || got.Done {
+		t.Errorf("ConnDrain event = %+v, want replaced drain of one stream", got)
+	}
+
+	// New requests use the replacement.
+	req2, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt2 := tt.roundTrip(req2)
+	tc2.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	if tt.hasConn() {
+		t.Fatalf("unexpected extra connection dialed")
+	}
+
+	// The in-flight request finishes on the draining connection.
+	for _, c := range []struct {
+		tc *testClientConn
+		rt *testRoundTrip
+	}{{tc1, rt1}, {tc2, rt2}} {
+		c.tc.writeHeaders(HeadersFrameParam{
+			StreamID:   1,
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: c.tc.makeHeaderBlockFragment(
+				":status", "200",
+			),
+		})
+		c.rt.wantStatus(200)
+	}
+	tc1.wantClosed()
+	tc1.closeWrite()
+	drained = drainEvents()
+	if len(drained) != 2 {
+		t.Fatalf("got %v ConnDrain events, want 2", len(drained))
+	}
+	if got := drained[1]; !got.Done || got.StreamsActive != 0 || got.Conn != drained[0].Conn {
+		t.Errorf("ConnDrain event = %+v, want completed drain", got)
+	}
+}
+
+func TestTransportContentDigest(t *testing.T) {
+	const reqBody, resBody = "request body", "response body"
+	var gotDigest string
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil || string(b) != reqBody {
+			t.Errorf("server read body %q, %v; want %q", b, err, reqBody)
+		}
+		gotDigest = r.Trailer.Get("Content-Digest")
+		io.WriteString(w, resBody)
+	}, func(s *Server) {
+		s.ContentDigest = DigestSHA256
+	})
+
+	tr := &Transport{TLSClientConfig: tlsConfigInsecure, ContentDigest: DigestSHA256}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(reqBody))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil || string(b) != resBody {
+		t.Fatalf("client read body %q, %v; want %q", b, err, resBody)
+	}
+	digestOf := func(s string) string {
+		h := DigestSHA256.New()
+		io.WriteString(h, s)
+		return DigestSHA256.field(h)
+	}
+	if want := digestOf(reqBody); gotDigest != want {
+		t.Errorf("request Content-Digest = %q, want %q", gotDigest, want)
+	}
+	if got, want := res.Trailer.Get("Content-Digest"), digestOf(resBody); got != want {
+		t.Errorf("response Content-Digest = %q, want %q", got, want)
+	}
+}
+
+func TestTransportContentDigestMismatch(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.ContentDigest = DigestSHA256
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(1, false, []byte("hello"))
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			"content-digest", "sha-256=:AAAA:",
+		),
+	})
+	if _, err := io.ReadAll(rt.response().Body); err != ErrDigestMismatch {
+		t.Fatalf("reading body: %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestTransportHealthCheckFrameMatching(t *testing.T) {
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.ReadIdleTimeout = 10 * time.Second
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameMatching(
+		matchFrameType(FrameHeaders),
+		matchStreamID(1),
+		matchFlags(FlagHeadersEndStream|FlagHeadersEndHeaders),
+	)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(1, false, make([]byte, 1000))
+	rt.wantStatus(200)
+
+	// Reading the body may produce WINDOW_UPDATEs; the PING is
+	// sent once the connection has been idle for ReadIdleTimeout.
+	defer tc.skipFrames(frameType(FrameWindowUpdate))()
+	io.ReadFull(rt.response().Body, make([]byte, 1000))
+	tc.advance(5 * time.Second)
+	if tc.readFrame() != nil {
+		t.Fatalf("unexpected frame before ReadIdleTimeout")
+	}
+	tc.wantFrameWithin(10*time.Second, matchFrameType(FramePing), matchStreamID(0))
+}
+
+func TestTransportReadChunked(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.setReadChunkSize(1)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(1, true, []byte("hello"))
+	rt.wantStatus(200)
+	rt.wantBody([]byte("hello"))
+}
+
+func TestTransportTornFrame(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeTornFrame(frameHeaderLen+2, func(fr *Framer) error {
+		return fr.WriteHeaders(HeadersFrameParam{
+			StreamID:   1,
+			EndHeaders: true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+				"x-header", "value",
+			),
+		})
+	})
+	if err := rt.err(); err == nil {
+		t.Fatalf("RoundTrip succeeded after torn frame, want error")
+	}
+}
+
+func TestTransportDelayedFrames(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+	tc.delayFrames(FrameData, 1*time.Second)
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(1, true, []byte("hello"))
+	rt.wantStatus(200)
+
+	tc.advance(999 * time.Millisecond)
+	if n := rt.response().Body.(transportResponseBody).cs.bufPipe.Len(); n != 0 {
+		t.Fatalf("received %v bytes of delayed DATA early", n)
+	}
+	tc.advance(1 * time.Millisecond)
+	rt.wantBody([]byte("hello"))
+}
+
+func TestTransportMultipleHosts(t *testing.T) {
+	tt := newTestTransport(t)
+	tt.dial = func(addr string) error {
+		if addr == "c.tld:443" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	reqA, _ := http.NewRequest("GET", "https://a.tld/", nil)
+	rtA := tt.roundTrip(reqA)
+	reqB, _ := http.NewRequest("GET", "https://b.tld/", nil)
+	rtB := tt.roundTrip(reqB)
+	reqC, _ := http.NewRequest("GET", "https://c.tld/", nil)
+	rtC := tt.roundTrip(reqC)
+	tt.wantDials("a.tld:443", "b.tld:443", "c.tld:443")
+	if err := rtC.err(); err == nil {
+		t.Fatalf("request to c.tld succeeded, want dial error")
+	}
+
+	// Answer b.tld's connection first.
+	for _, c := range []struct {
+		addr string
+		rt   *testRoundTrip
+	}{{"b.tld:443", rtB}, {"a.tld:443", rtA}} {
+		tc := tt.getConnTo(c.addr)
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:   1,
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+			),
+		})
+		c.rt.wantStatus(200)
+	}
+	if tt.hasConn() {
+		t.Fatalf("unexpected extra connection dialed")
+	}
+}
+
+func TestTransportMaxTotalStreams(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxTotalStreams = 2
+	})
+	newRequest := func(ctx context.Context, host string) *testRoundTrip {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "https://"+host+"/", nil)
+		return tt.roundTrip(req)
+	}
+	respond := func(tc *testClientConn, streamID uint32, rt *testRoundTrip) {
+		t.Helper()
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      streamID,
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+		})
+		rt.wantStatus(200)
+	}
+
+	ctx := context.Background()
+	rtA1 := newRequest(ctx, "a.tld")
+	rtB1 := newRequest(ctx, "b.tld")
+	var tcA, tcB *testClientConn
+	for _, c := range []struct {
+		addr string
+		tc   **testClientConn
+	}{{"a.tld:443", &tcA}, {"b.tld:443", &tcB}} {
+		tc := tt.getConnTo(c.addr)
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{streamID: 1, endStream: true})
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		*c.tc = tc
+	}
+
+	// Both streams are in use, so later requests wait,
+	// even though a.tld's connection could take them.
+	rtA2 := newRequest(ctx, "a.tld")
+	cancelCtx, cancel := context.WithCancel(ctx)
+	rtA3 := newRequest(cancelCtx, "a.tld")
+	rtA4 := newRequest(ctx, "a.tld")
+	tcA.wantIdle()
+
+	cancel()
+	tt.sync()
+	if err := rtA3.err(); err != context.Canceled {
+		t.Fatalf("canceled waiting request: err = %v, want %v", err, context.Canceled)
+	}
+	tcA.wantIdle()
+
+	// Streams are granted in order as others close.
+	respond(tcB, 1, rtB1)
+	tcA.wantHeaders(wantHeader{streamID: 3, endStream: true})
+	tcA.wantIdle()
+	respond(tcA, 1, rtA1)
+	tcA.wantHeaders(wantHeader{streamID: 5, endStream: true})
+	respond(tcA, 3, rtA2)
+	respond(tcA, 5, rtA4)
+}
+
+func TestStreamLimiterAbort(t *testing.T) {
+	var l streamLimiter
+	ctx := context.Background()
+	if err := l.acquire(ctx, nil, nil, 1); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	abort := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- l.acquire(ctx, nil, abort, 1) }()
+	close(abort)
+	if err := <-errc; err != errStreamAborted {
+		t.Fatalf("acquire after abort: %v, want %v", err, errStreamAborted)
+	}
+	// The aborted waiter does not hold up later ones.
+	l.release()
+	if err := l.acquire(ctx, nil, nil, 1); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestTransportExtendedConnect(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+
+	// The request waits for the server's SETTINGS.
+	body := tc.newRequestBody()
+	req, _ := http.NewRequest("CONNECT", "https://dummy.tld/chat?room=1", body)
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-Websocket-Version", "13")
+	rt := tc.roundTrip(req)
+	tc.wantIdle()
+
+	tc.writeSettings(Setting{SettingEnableConnectProtocol, 1})
+	tc.wantFrameType(FrameSettings) // acknowledgement
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: false,
+		header: http.Header{
+			":method":               []string{"CONNECT"},
+			":protocol":             []string{"websocket"},
+			":scheme":               []string{"https"},
+			":path":                 []string{"/chat?room=1"},
+			":authority":            []string{"dummy.tld"},
+			"sec-websocket-version": []string{"13"},
+		},
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+
+	// The stream carries data in both directions.
+	body.writeBytes(5)
+	tc.wantData(wantData{streamID: 1, endStream: false, size: 5})
+	tc.writeData(1, false, []byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(rt.response().Body, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("reading tunnel: %q, %v; want %q", buf, err, "hello")
+	}
+	body.closeWithError(io.EOF)
+	tc.wantData(wantData{streamID: 1, endStream: true, size: 0})
+	tc.writeData(1, true, nil)
+	rt.wantBody(nil)
+}
+
+func TestTransportExtendedConnectNotSupported(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("CONNECT", "https://dummy.tld/chat", nil)
+	req.Header.Set(":protocol", "websocket")
+	rt := tc.roundTrip(req)
+	if err := rt.err(); err != ErrExtendedConnectNotSupported {
+		t.Fatalf("RoundTrip = %v, want %v", err, ErrExtendedConnectNotSupported)
+	}
+	tc.wantIdle()
+
+	// Non-CONNECT requests may not set :protocol.
+	req, _ = http.NewRequest("GET", "https://dummy.tld/", nil)
+	req.Header.Set(":protocol", "websocket")
+	rt = tc.roundTrip(req)
+	if err := rt.err(); err == nil {
+		t.Fatalf("GET with :protocol succeeded, want error")
+	}
+	tc.wantIdle()
+}
+
+func TestTransportExtendedConnectSettingDisabled(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet(Setting{SettingEnableConnectProtocol, 1})
+	tc.writeSettings(Setting{SettingEnableConnectProtocol, 0})
+	tc.wantClosed()
+}
+
+func TestTransportMaxConnLifetime(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxConnLifetime = 10 * time.Second
+	})
+	// newConn accepts a new connection, whose first request is stream 1.
+	newConn := func() *testClientConn {
+		t.Helper()
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings()
+		tc.wantFrameType(FrameSettings) // settings ACK
+		return tc
+	}
+	respond := func(tc *testClientConn, rt *testRoundTrip, streamID uint32) {
+		t.Helper()
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      streamID,
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+		})
+		rt.wantStatus(200)
+	}
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt1 := tt.roundTrip(req)
+	tc1 := newConn()
+	respond(tc1, rt1, 1)
+
+	// Before the lifetime is up, requests reuse the connection.
+	tt.advance(5 * time.Second)
+	rt2 := tt.roundTrip(req)
+	tc1.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	respond(tc1, rt2, 3)
+	if tt.hasConn() {
+		t.Fatalf("new connection dialed before MaxConnLifetime")
+	}
+
+	// A request in flight when the lifetime is up finishes on the
+	// retired connection, while new requests use a new one.
+	rt3 := tt.roundTrip(req)
+	tc1.wantHeaders(wantHeader{
+		streamID:  5,
+		endStream: true,
+	})
+	tt.advance(5 * time.Second)
+	if tc1.isClosed() {
+		t.Fatalf("connection with active request closed at MaxConnLifetime")
+	}
+	rt4 := tt.roundTrip(req)
+	tc2 := newConn()
+	respond(tc2, rt4, 1)
+
+	respond(tc1, rt3, 5)
+	tc1.wantClosed()
+	tc1.closeWrite()
+
+	// An idle connection is closed as soon as its lifetime is up.
+	tt.advance(10 * time.Second)
+	tc2.wantClosed()
+	tc2.closeWrite()
+}
+
+func TestTransportMaxConnLifetimeReplace(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxConnLifetime = 10 * time.Second
+		tr.ReplaceOnGoAway = true
+	})
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tt.roundTrip(req)
+	tc1 := tt.getConn()
+	tc1.wantFrameType(FrameSettings)
+	tc1.wantFrameType(FrameWindowUpdate)
+	tc1.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc1.writeSettings()
+	tc1.wantFrameType(FrameSettings) // settings ACK
+
+	// Retiring a connection with a request in flight dials a
+	// replacement before any new request needs one.
+	tt.advance(10 * time.Second)
+	tc2 := tt.getConn()
+	tc2.wantFrameType(FrameSettings)
+	tc2.wantFrameType(FrameWindowUpdate)
+	tc2.writeSettings()
+	tc2.wantFrameType(FrameSettings) // settings ACK
+
+	tc1.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc1.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+	tc1.wantClosed()
+	tc1.closeWrite()
+}
+
+// RFC 9113, Section 8.1: A server that has sent a complete response may
+// reset the stream with NO_ERROR to stop the client sending its request body.
+func TestTransportResetNoErrorAfterResponse(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	body := tc.newRequestBody()
+	body.writeBytes(10)
+	req, _ := http.NewRequest("POST", "https://dummy.tld/", body)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.wantData(wantData{
+		streamID:  rt.streamID(),
+		endStream: false,
+		size:      10,
+	})
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(rt.streamID(), true, []byte("hello"))
+	tc.writeRSTStream(rt.streamID(), ErrCodeNo)
+
+	rt.wantStatus(200)
+	rt.wantBody([]byte("hello"))
+
+	// The client stops sending the body, and doesn't reset the stream
+	// the server already reset.
+	body.writeBytes(10)
+	tc.wantIdle()
+	if got, want := tc.cc.State().StreamsActive, 0; got != want {
+		t.Errorf("StreamsActive = %v, want %v", got, want)
+	}
+	body.closeWithError(io.EOF)
+}
+
+func TestTransportResetNoErrorBeforeResponseEnd(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   rt.streamID(),
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(rt.streamID(), false, []byte("partial"))
+	tc.writeRSTStream(rt.streamID(), ErrCodeNo)
+
+	rt.wantStatus(200)
+	_, err := io.ReadAll(rt.response().Body)
+	if se, ok := err.(StreamError); !ok || se.Code != ErrCodeNo {
+		t.Fatalf("reading truncated response body: %v, want StreamError with NO_ERROR", err)
+	}
+}
+
+func TestTransportHeaderCompressionStats(t *testing.T) {
+	defer func(v bool) { debugHPACKTables = v }(debugHPACKTables)
+	debugHPACKTables = true
+
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+		req.Header.Set("X-Custom", strings.Repeat("v", 100))
+		rt := tc.roundTrip(req)
+		tc.wantFrameType(FrameHeaders)
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:   rt.streamID(),
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+				"x-response", "value",
+			),
+		})
+		rt.wantStatus(200)
+	}
+
+	s := tc.cc.HeaderCompression()
+	if s.SentBlockBytes <= 0 || s.SentBlockBytes >= s.SentFieldBytes {
+		t.Errorf("sent %v bytes of header blocks for %v bytes of fields, want 0 < blocks < fields", s.SentBlockBytes, s.SentFieldBytes)
+	}
+	if s.ReceivedBlockBytes <= 0 || s.ReceivedFieldBytes <= 0 {
+		t.Errorf("received %v bytes of header blocks for %v bytes of fields, want both > 0", s.ReceivedBlockBytes, s.ReceivedFieldBytes)
+	}
+	wantSent := hpack.HeaderField{Name: "x-custom", Value: strings.Repeat("v", 100)}
+	if !containsHeaderField(s.EncoderTable, wantSent) {
+		t.Errorf("EncoderTable = %v, want it to contain %v", s.EncoderTable, wantSent)
+	}
+	wantRecv := hpack.HeaderField{Name: "x-response", Value: "value"}
+	if !containsHeaderField(s.DecoderTable, wantRecv) {
+		t.Errorf("DecoderTable = %v, want it to contain %v", s.DecoderTable, wantRecv)
+	}
+
+	debugHPACKTables = false
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+	if s := tc.cc.HeaderCompression(); s.EncoderTable != nil || s.DecoderTable != nil {
+		t.Errorf("with table debugging disabled, got tables %v, %v; want nil", s.EncoderTable, s.DecoderTable)
+	}
+}
+
+func TestTransportHeaderCompressionFieldCounts(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+		req.Header.Set("X-Custom", "value")
+		rt := tc.roundTrip(req)
+		tc.wantFrameType(FrameHeaders)
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:   rt.streamID(),
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":status", "200",
+				"x-response", "value",
+			),
+		})
+		rt.wantStatus(200)
+	}
+
+	// The first request adds the fields which are not in the static
+	// table to the dynamic table, where the second request finds them.
+	s := tc.cc.HeaderCompression()
+	sent := s.SentFields
+	literals := sent.StaticName + sent.DynamicName + sent.NewName
+	if sent.Added == 0 || literals != sent.Added || sent.DynamicIndexed != sent.Added {
+		t.Errorf("SentFields = %+v, want fields added once and then found in the dynamic table", sent)
+	}
+	if got, want := s.ReceivedFields.Fields(), int64(4); got != want {
+		t.Errorf("ReceivedFields.Fields() = %v, want %v", got, want)
+	}
+	if r := s.SentRatio(); r <= 0 || r >= 1 {
+		t.Errorf("SentRatio() = %v, want between 0 and 1", r)
+	}
+	if r := s.ReceivedRatio(); r <= 0 {
+		t.Errorf("ReceivedRatio() = %v, want > 0", r)
+	}
+}
+
+func containsHeaderField(fields []hpack.HeaderField, hf hpack.HeaderField) bool {
+	for _, f := range fields {
+		if f == hf {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTransportResponseBodyLongerThanContentLength(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		policy   ContentLengthPolicy
+		wantCode ErrCode
+	}{{
+		name:     "reset",
+		policy:   ContentLengthReset,
+		wantCode: ErrCodeProtocol,
+	}, {
+		name:     "truncate",
+		policy:   ContentLengthTruncate,
+		wantCode: ErrCodeCancel,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			tc := newTestClientConn(t, func(tr *Transport) {
+				tr.ContentLengthPolicy = test.policy
+			})
+			tc.greet()
+
+			req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+			rt := tc.roundTrip(req)
+			tc.wantFrameType(FrameHeaders)
+			tc.writeHeaders(HeadersFrameParam{
+				StreamID:   rt.streamID(),
+				EndHeaders: true,
+				EndStream:  false,
+				BlockFragment: tc.makeHeaderBlockFragment(
+					":status", "200",
+					"content-length", "8",
+				),
+			})
+			tc.writeData(rt.streamID(), false, []byte("12345"))
+			tc.writeData(rt.streamID(), false, []byte("67890"))
+			tc.wantRSTStream(rt.streamID(), test.wantCode)
+
+			rt.wantStatus(200)
+			body, err := io.ReadAll(rt.response().Body)
+			if got, want := string(body), "12345678"; got != want {
+				t.Errorf("read body %q, want %q", got, want)
+			}
+			switch test.policy {
+			case ContentLengthReset:
+				var cle *ContentLengthError
+				if !errors.As(err, &cle) || cle.Declared != 8 {
+					t.Errorf("reading body: %v, want ContentLengthError with Declared = 8", err)
+				}
+			case ContentLengthTruncate:
+				if err != nil {
+					t.Errorf("reading body: %v, want nil", err)
+				}
+			}
+
+			// Data still in flight from the server is ignored.
+			tc.writeData(rt.streamID(), true, []byte("more"))
+			tc.wantIdle()
+		})
+	}
+}
+
+func TestClientConnConnect(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	// async runs f in a goroutine within the test's synthetic time group,
+	// and returns a channel which receives its result.
+	async := func(f func() error) <-chan error {
+		errc := make(chan error, 1)
+		go func() {
+			tc.group.Join()
+			errc <- f()
+		}()
+		tc.sync()
+		return errc
+	}
+
+	var conn net.Conn
+	connc := async(func() (err error) {
+		conn, err = tc.cc.Connect(context.Background(), "example.tld:443")
+		return err
+	})
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: false,
+		header: http.Header{
+			":method":    []string{"CONNECT"},
+			":authority": []string{"example.tld:443"},
+		},
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	if err := <-connc; err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	writec := async(func() error {
+		_, err := conn.Write([]byte("hello"))
+		return err
+	})
+	tc.wantData(wantData{streamID: 1, endStream: false, size: 5, data: []byte("hello")})
+	if err := <-writec; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	tc.writeData(1, false, []byte("world"))
+	buf := make([]byte, 10)
+	var n int
+	readc := async(func() (err error) {
+		n, err = conn.Read(buf)
+		return err
+	})
+	if err := <-readc; err != nil || string(buf[:n]) != "world" {
+		t.Fatalf("Read = %q, %v; want %q", buf[:n], err, "world")
+	}
+
+	// Reads time out at the read deadline.
+	conn.SetReadDeadline(tc.cc.t.now().Add(1 * time.Second))
+	readc = async(func() (err error) {
+		_, err = conn.Read(buf)
+		return err
+	})
+	tc.advance(1 * time.Second)
+	select {
+	case err := <-readc:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Read after deadline = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	default:
+		t.Fatalf("Read did not time out at deadline")
+	}
+
+	// The tunnel is still usable after a read timeout.
+	conn.SetReadDeadline(time.Time{})
+	tc.writeData(1, false, []byte("again"))
+	readc = async(func() (err error) {
+		n, err = conn.Read(buf)
+		return err
+	})
+	if err := <-readc; err != nil || string(buf[:n]) != "again" {
+		t.Fatalf("Read = %q, %v; want %q", buf[:n], err, "again")
+	}
+
+	conn.Close()
+	tc.sync()
+	tc.wantFrameType(FrameRSTStream)
+	if _, err := conn.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Read after Close = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestClientConnConnectReadAfterCloseWithBufferedData(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	connc := make(chan net.Conn, 1)
+	go func() {
+		tc.group.Join()
+		conn, err := tc.cc.Connect(context.Background(), "example.tld:443")
+		if err != nil {
+			t.Errorf("Connect: %v", err)
+		}
+		connc <- conn
+	}()
+	tc.sync()
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	conn := <-connc
+	if conn == nil {
+		return
+	}
+
+	// Leave part of the data unread.
+	tc.writeData(1, false, []byte("hello world"))
+	buf := make([]byte, 5)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("Read = %q, %v; want %q", buf[:n], err, "hello")
+	}
+
+	conn.Close()
+	tc.sync()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(buf)
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Read after Close = %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Read after Close with buffered data did not return")
+	}
+}
+
+func TestClientConnConnectRefused(t *testing.T) {
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	errc := make(chan error, 1)
+	go func() {
+		tc.group.Join()
+		_, err := tc.cc.Connect(context.Background(), "example.tld:443")
+		errc <- err
+	}()
+	tc.sync()
+	tc.wantFrameType(FrameHeaders)
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      1,
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "403"),
+	})
+	var ce *ConnectError
+	if err := <-errc; !errors.As(err, &ce) || ce.Response.StatusCode != 403 {
+		t.Fatalf("Connect = %v, want ConnectError with status 403", err)
+	}
+}
+
+func TestTransportFlowControlStalls(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		stalls []FlowControlStall
+	)
+	takeStalls := func() []FlowControlStall {
+		mu.Lock()
+		defer mu.Unlock()
+		got := stalls
+		stalls = nil
+		return got
+	}
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.FlowControlStallFunc = func(st FlowControlStall) {
+			mu.Lock()
+			defer mu.Unlock()
+			stalls = append(stalls, st)
+		}
+	})
+	tc.greet(Setting{SettingInitialWindowSize, 10})
+
+	// The request body is blocked by the server's window.
+	body := tc.newRequestBody()
+	body.writeBytes(20)
+	body.closeWithError(io.EOF)
+	req, _ := http.NewRequest("PUT", "https://dummy.tld/", body)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.wantData(wantData{streamID: rt.streamID(), endStream: false, size: 10})
+	start := tc.cc.t.now()
+	tc.advance(1 * time.Second)
+	if got := takeStalls(); len(got) != 0 {
+		t.Fatalf("stalls reported before window update: %+v", got)
+	}
+	tc.writeWindowUpdate(rt.streamID(), 10)
+	tc.wantData(wantData{streamID: rt.streamID(), endStream: false, size: 10})
+	want := []FlowControlStall{{
+		StreamID: rt.streamID(),
+		Cause:    FlowControlStallPeer,
+		Start:    start,
+		Duration: 1 * time.Second,
+	}}
+	if got := takeStalls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after window update, stalls = %+v\nwant %+v", got, want)
+	}
+	tc.wantData(wantData{streamID: rt.streamID(), endStream: true, size: 0})
+
+	// The response body exhausts the window the Transport advertised.
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+	const frameSize = 16 << 10
+	for n := 0; n < transportDefaultStreamFlow; n += frameSize {
+		tc.writeData(rt.streamID(), false, make([]byte, frameSize))
+	}
+	start = tc.cc.t.now()
+	tc.advance(1 * time.Second)
+	if got := takeStalls(); len(got) != 0 {
+		t.Fatalf("stalls reported before response body read: %+v", got)
+	}
+	if _, err := io.ReadFull(rt.response().Body, make([]byte, transportDefaultStreamFlow)); err != nil {
+		t.Fatal(err)
+	}
+	want = []FlowControlStall{{
+		StreamID: rt.streamID(),
+		Cause:    FlowControlStallLocal,
+		Start:    start,
+		Duration: 1 * time.Second,
+	}}
+	if got := takeStalls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after response body read, stalls = %+v\nwant %+v", got, want)
+	}
+}
+
+type pushCacheFunc func(req *http.Request, res *http.Response)
+
+func (f pushCacheFunc) Fill(req *http.Request, res *http.Response) { f(req, res) }
+
+func TestTransportPushHandler(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			if err := w.(http.Pusher).Push("/style.css", nil); err != nil {
+				t.Errorf("Push: %v", err)
+			}
+			io.WriteString(w, "main")
+		case "/style.css":
+			io.WriteString(w, "pushed")
+		}
+	})
+
+	type fill struct {
+		url  string
+		body string
+	}
+	fillc := make(chan fill, 1)
+	tr := &Transport{
+		TLSClientConfig: tlsConfigInsecure,
+		PushHandler: func(p *PushPromise) {
+			p.Accept(pushCacheFunc(func(req *http.Request, res *http.Response) {
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Errorf("reading pushed body: %v", err)
+				}
+				fillc <- fill{req.URL.String(), string(body)}
+			}))
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(body) != "main" {
+		t.Fatalf("response body = %q, %v; want %q", body, err, "main")
+	}
+	got := <-fillc
+	want := fill{ts.URL + "/style.css", "pushed"}
+	if got != want {
+		t.Fatalf("pushed response = %+v, want %+v", got, want)
+	}
+}
+
+func TestTransportPushHandlerRefuses(t *testing.T) {
+	promisedc := make(chan string, 10)
+	takePromised := func() (promised []string) {
+		for {
+			select {
+			case p := <-promisedc:
+				promised = append(promised, p)
+			default:
+				return promised
+			}
+		}
+	}
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.PushHandler = func(p *PushPromise) {
+			promisedc <- p.Request.Method + " " + p.Request.URL.String()
+		}
+	})
+	settings := readFrame[*SettingsFrame](t, tc)
+	if v, ok := settings.Value(SettingEnablePush); !ok || v != 1 {
+		t.Fatalf("SETTINGS_ENABLE_PUSH = %v, %v; want 1", v, ok)
+	}
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.writeSettings()
+	tc.writeSettingsAck()
+	tc.wantFrameType(FrameSettings) // acknowledgement
+
+	req, _ := http.NewRequest("GET", "http://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+
+	promise := func(promiseID uint32, method string) {
+		tc.fr.WritePushPromise(PushPromiseParam{
+			StreamID:   rt.streamID(),
+			PromiseID:  promiseID,
+			EndHeaders: true,
+			BlockFragment: tc.makeHeaderBlockFragment(
+				":method", method,
+				":scheme", "http",
+				":authority", "dummy.tld",
+				":path", "/pushed",
+			),
+		})
+		tc.sync()
+	}
+
+	// Pushes not accepted by the PushHandler are canceled.
+	promise(2, "GET")
+	if promised, want := takePromised(), []string{"GET http://dummy.tld/pushed"}; !reflect.DeepEqual(promised, want) {
+		t.Fatalf("promised = %v, want %v", promised, want)
+	}
+	fr := readFrame[*RSTStreamFrame](t, tc)
+	if fr.StreamID != 2 || fr.ErrCode != ErrCodeCancel {
+		t.Fatalf("got %v, want RST_STREAM(CANCEL) for stream 2", summarizeFrame(fr))
+	}
+
+	// Unsafe requests may not be pushed.
+	promise(4, "POST")
+	if promised := takePromised(); len(promised) != 0 {
+		t.Fatalf("PushHandler called for unsafe request: %v", promised)
+	}
+	fr = readFrame[*RSTStreamFrame](t, tc)
+	if fr.StreamID != 4 || fr.ErrCode != ErrCodeProtocol {
+		t.Fatalf("got %v, want RST_STREAM(PROTOCOL_ERROR) for stream 4", summarizeFrame(fr))
+	}
+
+	// The request is unaffected.
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+
+	// Promised stream IDs must increase.
+	req, _ = http.NewRequest("GET", "http://dummy.tld/", nil)
+	rt = tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	promise(2, "GET")
+	if fr := readFrame[*GoAwayFrame](t, tc); fr.ErrCode != ErrCodeProtocol {
+		t.Fatalf("got %v, want GOAWAY(PROTOCOL_ERROR)", summarizeFrame(fr))
+	}
+	if err := rt.err(); err == nil {
+		t.Fatalf("RoundTrip on closed connection succeeded")
+	}
+}
+
+func TestTransportPushHandlerRequestBody(t *testing.T) {
+	// An accepted push delivers its response while the associated
+	// request continues.
+	fillc := make(chan *http.Response, 1)
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.PushHandler = func(p *PushPromise) {
+			p.Accept(pushCacheFunc(func(req *http.Request, res *http.Response) {
+				fillc <- res
+			}))
+		}
+	})
+	tc.greet()
+
+	req, _ := http.NewRequest("GET", "http://dummy.tld/", nil)
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.fr.WritePushPromise(PushPromiseParam{
+		StreamID:   rt.streamID(),
+		PromiseID:  2,
+		EndHeaders: true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":method", "GET",
+			":scheme", "http",
+			":authority", "dummy.tld",
+			":path", "/pushed",
+		),
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      2,
+		EndHeaders:    true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	tc.writeData(2, true, []byte("pushed"))
+	tc.sync()
+	var res *http.Response
+	select {
+	case res = <-fillc:
+	default:
+		t.Fatalf("pushed response not delivered")
+	}
+	if body, err := io.ReadAll(res.Body); err != nil || string(body) != "pushed" {
+		t.Fatalf("pushed body = %q, %v; want %q", body, err, "pushed")
+	}
+	res.Body.Close()
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:      rt.streamID(),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+	})
+	rt.wantStatus(200)
+	tc.wantIdle()
+}
+
+func TestTransportClientTrace(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		io.WriteString(w, "body")
+	})
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	event := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn:      func(string) { event("GetConn") },
+		ConnectStart: func(network, addr string) { event("ConnectStart %v", network) },
+		ConnectDone: func(network, addr string, err error) {
+			event("ConnectDone %v %v", network, err)
+		},
+		TLSHandshakeStart: func() { event("TLSHandshakeStart") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			event("TLSHandshakeDone %v %v", state.NegotiatedProtocol, err)
+		},
+		GotConn:      func(ci httptrace.GotConnInfo) { event("GotConn reused=%v", ci.Reused) },
+		WroteHeaders: func() { event("WroteHeaders") },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			event("WroteRequest %v", info.Err)
+		},
+		GotFirstResponseByte: func() { event("GotFirstResponseByte") },
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			event("Got1xxResponse %v %v", code, header.Get("Link"))
+			return nil
+		},
+	}
+
+	tr := &Transport{TLSClientConfig: tlsConfigInsecure}
+	defer tr.CloseIdleConnections()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	want := []string{
+		"GetConn",
+		"ConnectStart tcp",
+		"ConnectDone tcp <nil>",
+		"TLSHandshakeStart",
+		"TLSHandshakeDone h2 <nil>",
+		"GotConn reused=false",
+		"WroteHeaders",
+		"WroteRequest <nil>",
+		"GotFirstResponseByte",
+		"Got1xxResponse 103 </style.css>; rel=preload",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("trace events:\n%v\nwant:\n%v", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestClientConnRoundTripTraceGotConn(t *testing.T) {
+	// ClientConn.RoundTrip reports the connection, when used
+	// without a Transport's pool.
+	tc := newTestClientConn(t)
+	tc.greet()
+
+	var gotConns []httptrace.GotConnInfo
+	trace := &httptrace.ClientTrace{
+		GotConn: func(ci httptrace.GotConnInfo) { gotConns = append(gotConns, ci) },
+	}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		rt := tc.roundTrip(req)
+		tc.wantFrameType(FrameHeaders)
+		tc.writeHeaders(HeadersFrameParam{
+			StreamID:      rt.streamID(),
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+		})
+		rt.wantStatus(200)
+	}
+	if len(gotConns) != 2 || gotConns[0].Reused || !gotConns[1].Reused {
+		t.Fatalf("GotConn calls: %+v; want one new and one reused conn", gotConns)
+	}
+}
+
+func TestTransportPreserveHeaderCase(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		t.Run(fmt.Sprint(preserve), func(t *testing.T) {
+			tc := newTestClientConn(t, func(tr *Transport) {
+				tr.PreserveHeaderCase = preserve
+			})
+			tc.greet()
+
+			body := tc.newRequestBody()
+			req, _ := http.NewRequest("PUT", "https://dummy.tld/", body)
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header["X-Device-ID"] = []string{"1"}
+			req.Header["lower"] = []string{"2"}
+			req.Trailer = http.Header{"X-Checksum": nil}
+			rt := tc.roundTrip(req)
+
+			fr := readFrame[*HeadersFrame](t, tc)
+			got := map[string]bool{}
+			for _, kv := range tc.decodeHeader(fr.HeaderBlockFragment()) {
+				got[kv[0]] = true
+			}
+			want := []string{"content-type", "x-device-id", "lower", "user-agent"}
+			if preserve {
+				want = []string{"Content-Type", "X-Device-ID", "lower", "user-agent"}
+			}
+			for _, name := range want {
+				if !got[name] {
+					t.Errorf("header field %q not sent; got %v", name, got)
+				}
+			}
+
+			req.Trailer.Set("X-Checksum", "abc")
+			body.closeWithError(io.EOF)
+			fr = readFrame[*HeadersFrame](t, tc)
+			trailer := tc.decodeHeader(fr.HeaderBlockFragment())
+			wantTrailer := "x-checksum"
+			if preserve {
+				wantTrailer = "X-Checksum"
+			}
+			if len(trailer) != 1 || trailer[0][0] != wantTrailer {
+				t.Errorf("trailers = %v, want %q", trailer, wantTrailer)
+			}
+			tc.writeHeaders(HeadersFrameParam{
+				StreamID:      rt.streamID(),
+				EndHeaders:    true,
+				EndStream:     true,
+				BlockFragment: tc.makeHeaderBlockFragment(":status", "200"),
+			})
+			rt.wantStatus(200)
+		})
+	}
+}
+
+func TestTransportDialPolicy(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	type dial struct {
+		release chan error // nil to connect, or an error to fail
+	}
+	dials := make(chan dial)
+	newTransport := func(policy DialPolicy) *Transport {
+		return &Transport{
+			TLSClientConfig: tlsConfigInsecure,
+			DialPolicy:      policy,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				d := dial{release: make(chan error)}
+				dials <- d
+				if err := <-d.release; err != nil {
+					return nil, err
+				}
+				return tls.Dial(network, addr, cfg)
+			},
+		}
+	}
+	// start starts a request, returning a channel receiving its error
+	// and the dial it started.
+	start := func(tr *Transport) (chan error, dial) {
+		errc := make(chan error, 1)
+		go func() {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			res, err := tr.RoundTrip(req)
+			if err == nil {
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+		return errc, <-dials
+	}
+	// join starts a request once a dial is in progress, and returns
+	// a channel receiving its error after it is waiting for the dial.
+	join := func(tr *Transport) chan error {
+		errc := make(chan error, 1)
+		waiting := make(chan struct{})
+		go func() {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				GetConn: func(string) { close(waiting) },
+			}))
+			res, err := tr.RoundTrip(req)
+			if err == nil {
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+		<-waiting
+		return errc
+	}
+	roundTrip := func(tr *Transport) error {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		tr := newTransport(DialPolicyFailFast)
+		defer tr.CloseIdleConnections()
+		errc, d := start(tr)
+		if err := roundTrip(tr); err != ErrDialInProgress {
+			t.Errorf("RoundTrip during dial = %v, want ErrDialInProgress", err)
+		}
+		d.release <- nil
+		if err := <-errc; err != nil {
+			t.Errorf("RoundTrip which started dial = %v", err)
+		}
+	})
+
+	t.Run("fail fast after error", func(t *testing.T) {
+		tr := newTransport(DialPolicyFailFastAfterError)
+		defer tr.CloseIdleConnections()
+
+		// Requests wait for the first dial to a host.
+		errc1, d := start(tr)
+		errc2 := join(tr)
+		dialErr := errors.New("host down")
+		d.release <- dialErr
+		for _, errc := range []chan error{errc1, errc2} {
+			if err := <-errc; !errors.Is(err, dialErr) {
+				t.Errorf("RoundTrip during failed dial = %v, want %v", err, dialErr)
+			}
+		}
+
+		// After a failed dial, requests fail fast during the redial.
+		errc, d := start(tr)
+		if err := roundTrip(tr); err != ErrDialInProgress {
+			t.Errorf("RoundTrip during redial = %v, want ErrDialInProgress", err)
+		}
+		d.release <- nil
+		if err := <-errc; err != nil {
+			t.Errorf("RoundTrip which started redial = %v", err)
+		}
+
+		// A successful dial restores waiting.
+		tr.CloseIdleConnections()
+		errc1, d = start(tr)
+		errc2 = join(tr)
+		d.release <- nil
+		for _, errc := range []chan error{errc1, errc2} {
+			if err := <-errc; err != nil {
+				t.Errorf("RoundTrip after successful dial = %v", err)
+			}
+		}
+	})
+}
+
+func TestTransportMaxConnsPerHost(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		t.Run(fmt.Sprintf("failFast=%v", failFast), func(t *testing.T) {
+			testTransportMaxConnsPerHost(t, failFast)
+		})
+	}
+}
+
+func testTransportMaxConnsPerHost(t *testing.T, failFast bool) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.MaxConnsPerHost = 1
+		tr.MaxConnsPerHostFailFast = failFast
+	})
+
+	req0, _ := http.NewRequest("GET", "https://dummy.tld/0", nil)
+	rt0 := tt.roundTrip(req0)
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings(Setting{SettingMaxConcurrentStreams, 1})
+	tc.wantFrameType(FrameSettings) // acknowledgement
+
+	// The connection has no free streams, but no second connection
+	// is dialed.
+	req1, _ := http.NewRequest("GET", "https://dummy.tld/1", nil)
+	rt1 := tt.roundTrip(req1)
+	tt.sync()
+	if tt.hasConn() {
+		t.Fatalf("Transport dialed a second connection, want at most one")
+	}
+	if failFast {
+		if err := rt1.err(); err != ErrMaxConnsPerHost {
+			t.Fatalf("RoundTrip(1) = %v, want ErrMaxConnsPerHost", err)
+		}
+	} else if rt1.done() {
+		t.Fatalf("RoundTrip(1) is done, but should be waiting for a connection")
+	}
+
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt0.wantStatus(200)
+	if failFast {
+		return
+	}
+
+	// Finishing the first request sends the second on the same connection.
+	tc.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   3,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt1.wantStatus(200)
+}
+
+func TestTransportStrictMaxConcurrentStreamsMaxConnsPerHost(t *testing.T) {
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.StrictMaxConcurrentStreams = true
+		tr.MaxConnsPerHost = 2
+	})
+	// start sends a request, which is the first on a new connection.
+	start := func(path string) (*testRoundTrip, *testClientConn) {
+		req, _ := http.NewRequest("GET", "https://dummy.tld/"+path, nil)
+		rt := tt.roundTrip(req)
+		tc := tt.getConn()
+		tc.wantFrameType(FrameSettings)
+		tc.wantFrameType(FrameWindowUpdate)
+		tc.wantHeaders(wantHeader{
+			streamID:  1,
+			endStream: true,
+		})
+		tc.writeSettings(Setting{SettingMaxConcurrentStreams, 1})
+		tc.wantFrameType(FrameSettings) // acknowledgement
+		return rt, tc
+	}
+
+	// The first connection's only stream is in use,
+	// so the second request dials a second connection.
+	rt0, tc0 := start("0")
+	rt1, tc1 := start("1")
+
+	// With MaxConnsPerHost connections, the third request waits
+	// for a stream on one of them.
+	req2, _ := http.NewRequest("GET", "https://dummy.tld/2", nil)
+	rt2 := tt.roundTrip(req2)
+	tt.sync()
+	if tt.hasConn() {
+		t.Fatalf("Transport dialed a third connection, want at most two")
+	}
+	if rt2.done() {
+		t.Fatalf("RoundTrip(2) is done, but should be waiting for a stream")
+	}
+	tc0.wantIdle()
+	tc1.wantIdle()
+
+	for _, r := range []struct {
+		rt *testRoundTrip
+		tc *testClientConn
+	}{{rt0, tc0}, {rt1, tc1}} {
+		r.tc.writeHeaders(HeadersFrameParam{
+			StreamID:   1,
+			EndHeaders: true,
+			EndStream:  true,
+			BlockFragment: r.tc.makeHeaderBlockFragment(
+				":status", "200",
+			),
+		})
+		r.rt.wantStatus(200)
+	}
+	tc0.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	tc0.writeHeaders(HeadersFrameParam{
+		StreamID:   3,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc0.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt2.wantStatus(200)
+}
+
+func TestTransportCircuitBreaker(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	tt := newTestTransport(t, func(tr *Transport) {
+		tr.CircuitBreaker = &CircuitBreaker{
+			Threshold: 2,
+			Cooldown:  1 * time.Second,
+			IsFailure: func(req *http.Request, res *http.Response, err error) bool {
+				return err != nil || res.StatusCode == http.StatusServiceUnavailable
+			},
+			OnStateChange: func(ev BreakerEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, fmt.Sprintf("%v -> %v (%v)", ev.From, ev.To, ev.Label))
+			},
+		}
+	})
+	breaker := tt.tr.CircuitBreaker
+	const addr = "dummy.tld:443"
+	dialErr := errors.New("connection refused")
+	dials := 0
+	tt.dial = func(string) error {
+		dials++
+		if dials <= 2 {
+			return dialErr
+		}
+		return nil
+	}
+	requests := 0
+	newRequest := func() *http.Request {
+		requests++
+		ctx := WithRequestLabel(context.Background(), requests)
+		req, _ := http.NewRequestWithContext(ctx, "GET", "https://dummy.tld/", nil)
+		return req
+	}
+	wantOpen := func() {
+		t.Helper()
+		err := tt.roundTrip(newRequest()).err()
+		var openErr *CircuitOpenError
+		if !errors.As(err, &openErr) || openErr.Addr != addr {
+			t.Fatalf("RoundTrip error = %v, want CircuitOpenError for %v", err, addr)
+		}
+	}
+
+	// Consecutive dial failures open the breaker.
+	for i := 0; i < 2; i++ {
+		if err := tt.roundTrip(newRequest()).err(); !errors.Is(err, dialErr) {
+			t.Fatalf("RoundTrip error = %v, want %v", err, dialErr)
+		}
+	}
+	if got := breaker.State(addr); got != BreakerOpen {
+		t.Fatalf("breaker state = %v, want open", got)
+	}
+	err := tt.roundTrip(newRequest()).err()
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || openErr.LastErr != dialErr || openErr.Until.IsZero() {
+		t.Fatalf("RoundTrip error = %#v, want CircuitOpenError after %v", err, dialErr)
+	}
+
+	// After the cooldown, one probe is sent. A failed probe reopens
+	// the breaker for twice as long.
+	tt.advance(1 * time.Second)
+	rt := tt.roundTrip(newRequest())
+	tc := tt.getConn()
+	tc.wantFrameType(FrameSettings)
+	tc.wantFrameType(FrameWindowUpdate)
+	tc.wantHeaders(wantHeader{
+		streamID:  1,
+		endStream: true,
+	})
+	tc.writeSettings()
+	tc.wantFrameType(FrameSettings) // settings ACK
+	wantOpen()
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "503",
+		),
+	})
+	rt.wantStatus(http.StatusServiceUnavailable)
+	tt.advance(1 * time.Second)
+	wantOpen()
+
+	// A successful probe closes the breaker.
+	tt.advance(1 * time.Second)
+	rt = tt.roundTrip(newRequest())
+	tc.wantHeaders(wantHeader{
+		streamID:  3,
+		endStream: true,
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   3,
+		EndHeaders: true,
+		EndStream:  true,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	rt.wantStatus(http.StatusOK)
+	if got := breaker.State(addr); got != BreakerClosed {
+		t.Errorf("breaker state = %v, want closed", got)
+	}
+	if dials != 3 {
+		t.Errorf("dialed %v times, want 3", dials)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"closed -> open (2)",
+		"open -> half-open (4)",
+		"half-open -> open (4)",
+		"open -> half-open (7)",
+		"half-open -> closed (7)",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("breaker events:\n%q\nwant:\n%q", events, want)
+	}
+}
+
+func TestCircuitBreakerDefaultIsFailure(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://dummy.tld/", nil)
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, test := range []struct {
+		name    string
+		req     *http.Request
+		status  int
+		err     error
+		dialErr bool
+		want    attemptResult
+	}{
+		{name: "200", status: 200, want: attemptSucceeded},
+		{name: "404", status: 404, want: attemptSucceeded},
+		{name: "503", status: 503, want: attemptFailed},
+		{name: "dial error", err: errors.New("connection refused"), dialErr: true, want: attemptFailed},
+		{name: "connection error", err: ConnectionError(ErrCodeProtocol), want: attemptFailed},
+		{name: "GOAWAY", err: GoAwayError{ErrCode: ErrCodeInternal}, want: attemptFailed},
+		{name: "network error", err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, want: attemptFailed},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: attemptFailed},
+		{name: "lost ping", err: errClientConnLost, want: attemptFailed},
+		{name: "stream error", err: StreamError{StreamID: 1, Code: ErrCodeCancel}, want: attemptAbandoned},
+		{name: "body error", err: errReqBodyTooLong, want: attemptAbandoned},
+		{name: "canceled", req: req.WithContext(canceledCtx), err: context.Canceled, want: attemptAbandoned},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := req
+			if test.req != nil {
+				req = test.req
+			}
+			var res *http.Response
+			if test.err == nil {
+				res = &http.Response{StatusCode: test.status}
+			}
+			b := &CircuitBreaker{}
+			if got := b.result(req, res, test.err, test.dialErr); got != test.want {
+				t.Errorf("result = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTransportFrameEventLabels(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	tc := newTestClientConn(t, func(tr *Transport) {
+		tr.FrameEvent = func(ev FrameEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			dir := "received"
+			if ev.Sent {
+				dir = "sent"
+			}
+			events = append(events, fmt.Sprintf("%v %v %v stream=%v", ev.Label, dir, ev.Header.Type, ev.Header.StreamID))
+		}
+	})
+	tc.greet()
+
+	ctx := WithRequestLabel(context.Background(), "req-1")
+	if got := RequestLabel(ctx); got != "req-1" {
+		t.Fatalf("RequestLabel = %v, want req-1", got)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://dummy.tld/", strings.NewReader("body"))
+	rt := tc.roundTrip(req)
+	tc.wantFrameType(FrameHeaders)
+	tc.wantData(wantData{
+		streamID:  1,
+		endStream: true,
+		size:      len("body"),
+		multiple:  true,
+	})
+	tc.writeHeaders(HeadersFrameParam{
+		StreamID:   1,
+		EndHeaders: true,
+		EndStream:  false,
+		BlockFragment: tc.makeHeaderBlockFragment(
+			":status", "200",
+		),
+	})
+	tc.writeData(1, true, []byte("response"))
+	rt.wantStatus(200)
+	rt.wantBody([]byte("response"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"req-1 sent HEADERS stream=1",
+		"req-1 sent DATA stream=1",
+		"req-1 received HEADERS stream=1",
+		"req-1 received DATA stream=1",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("frame events:\n%q\nwant:\n%q", events, want)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Connect sends a CONNECT request for target, a host:port, and returns
+// the resulting tunnel as a net.Conn. Data written to the conn is sent
+// to the target through the server, and data from the target is read
+// from it, subject to the stream's flow control.
+//
+// The context governs only establishing the tunnel. To set headers on
+// the CONNECT request, such as Proxy-Authorization, use ConnectRequest.
+func (cc *ClientConn) Connect(ctx context.Context, target string) (net.Conn, error) {
+	return cc.ConnectRequest(ctx, target, nil)
+}
+
+// ConnectRequest is like Connect, but sends the header h with the
+// CONNECT request. If the server responds with a non-2xx status,
+// the error is a *ConnectError holding the response.
+func (cc *ClientConn) ConnectRequest(ctx context.Context, target string, h http.Header) (net.Conn, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return nil, err
+	}
+	// The stream lives as long as the request's context, so it
+	// cannot be ctx, which may end once the tunnel is established.
+	reqCtx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req := (&http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Host: target},
+		Host:   target,
+		Header: h.Clone(),
+		Body:   pr,
+	}).WithContext(reqCtx)
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.ContentLength = -1
+
+	type result struct {
+		res *http.Response
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		cc.t.markNewGoroutine()
+		res, err := cc.RoundTrip(req)
+		resc <- result{res, err}
+	}()
+	var r result
+	select {
+	case r = <-resc:
+	case <-ctx.Done():
+		cancel()
+		pw.Close()
+		if r := <-resc; r.res != nil {
+			r.res.Body.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		cancel()
+		pw.Close()
+		return nil, r.err
+	}
+	if r.res.StatusCode < 200 || r.res.StatusCode > 299 {
+		r.res.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, &ConnectError{Target: target, Response: r.res}
+	}
+	c := &tunnelConn{
+		cc:      cc,
+		body:    r.res.Body,
+		pw:      pw,
+		cancel:  cancel,
+		datac:   make(chan []byte),
+		donec:   make(chan struct{}),
+		closedc: make(chan struct{}),
+	}
+	c.readDeadline.init(cc.t)
+	c.writeDeadline.init(cc.t)
+	go c.readLoop()
+	return c, nil
+}
+
+// ConnectError is returned by ClientConn.Connect when the server
+// refuses to establish a tunnel.
+type ConnectError struct {
+	Target string
+
+	// Response is the server's response. Its body is closed.
+	Response *http.Response
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("http2: CONNECT to %v: %v", e.Target, e.Response.Status)
+}
+
+// tunnelConn is the net.Conn returned by ClientConn.Connect.
+type tunnelConn struct {
+	cc     *ClientConn
+	body   io.ReadCloser // response body, read by readLoop
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+
+	// readLoop sends data read from body on datac, and waits for
+	// Read to consume it before reusing the buffer.
+	datac   chan []byte
+	donec   chan struct{} // closed when readLoop exits
+	readErr error         // set before donec is closed
+
+	readMu  sync.Mutex // serializes Reads, guards unread
+	unread  []byte     // remainder of the last data from datac
+	writeMu sync.Mutex // serializes Writes
+
+	closeOnce sync.Once
+	closedc   chan struct{}
+
+	readDeadline  connDeadline
+	writeDeadline connDeadline
+}
+
+func (c *tunnelConn) readLoop() {
+	c.cc.t.markNewGoroutine()
+	defer close(c.donec)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := c.body.Read(buf)
+		if n > 0 {
+			select {
+			case c.datac <- buf[:n]:
+			case <-c.closedc:
+				c.readErr = net.ErrClosed
+				return
+			}
+			// Wait for Read to finish with buf.
+			select {
+			case c.datac <- nil:
+			case <-c.closedc:
+				c.readErr = net.ErrClosed
+				return
+			}
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+	}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	select {
+	case <-c.closedc:
+		// Data left unread is discarded.
+		return 0, net.ErrClosed
+	default:
+	}
+	if len(c.unread) == 0 {
+		select {
+		case c.unread = <-c.datac:
+		case <-c.donec:
+			select {
+			case <-c.closedc:
+				// readLoop ended because we closed the body.
+				return 0, net.ErrClosed
+			default:
+			}
+			return 0, c.readErr
+		case <-c.closedc:
+			return 0, net.ErrClosed
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, c.unread)
+	c.unread = c.unread[n:]
+	if len(c.unread) == 0 {
+		// Release the buffer to readLoop,
+		// which stops waiting for it on Close.
+		select {
+		case <-c.datac:
+		case <-c.closedc:
+		}
+	}
+	return n, nil
+}
+
+// Write writes p to the tunnel. A Write which times out leaves the
+// tunnel in an unknown state, and closes it.
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.closedc:
+		return 0, net.ErrClosed
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	type result struct {
+		n   int
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		c.cc.t.markNewGoroutine()
+		n, err := c.pw.Write(p)
+		resc <- result{n, err}
+	}()
+	select {
+	case r := <-resc:
+		return r.n, r.err
+	case <-c.writeDeadline.wait():
+		c.Close()
+		r := <-resc
+		return r.n, os.ErrDeadlineExceeded
+	}
+}
+
+// CloseWrite ends the tunnel's outbound data, leaving it open for reads.
+func (c *tunnelConn) CloseWrite() error {
+	return c.pw.Close()
+}
+
+func (c *tunnelConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closedc)
+		c.pw.CloseWithError(net.ErrClosed)
+		c.body.Close()
+		c.cancel()
+		c.readDeadline.set(time.Time{})
+		c.writeDeadline.set(time.Time{})
+	})
+	return nil
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return c.cc.tconn.LocalAddr() }
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.cc.tconn.RemoteAddr() }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// connDeadline is a net.Conn deadline.
+type connDeadline struct {
+	t     *Transport
+	mu    sync.Mutex
+	timer timer         // or nil
+	c     chan struct{} // closed when the deadline passes
+}
+
+func (d *connDeadline) init(t *Transport) {
+	d.t = t
+	d.c = make(chan struct{})
+}
+
+// set sets the deadline. The zero time means no deadline.
+func (d *connDeadline) set(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// The deadline passed, closing d.c.
+		d.c = make(chan struct{})
+	}
+	d.timer = nil
+	if deadline.IsZero() {
+		return
+	}
+	dur := deadline.Sub(d.t.now())
+	if dur <= 0 {
+		select {
+		case <-d.c:
+		default:
+			close(d.c)
+		}
+		return
+	}
+	c := d.c
+	d.timer = d.t.afterFunc(dur, func() {
+		close(c)
+	})
+}
+
+// wait returns a channel which is closed when the deadline passes.
+func (d *connDeadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.c
+}
@@ -41,7 +41,7 @@ type writeContext interface {
 	CloseConn() error
 	// HeaderEncoder returns an HPACK encoder that writes to the
 	// returned buffer.
-	HeaderEncoder() (*hpack.Encoder, *bytes.Buffer)
+	HeaderEncoder() (*headerEncoder, *bytes.Buffer)
 }
 
 // writeEndsStream reports whether w writes a frame that will transition
@@ -139,6 +139,20 @@ func (w writePingAck) writeFrame(ctx writeContext) error {
 
 func (w writePingAck) staysWithinBuffer(max int) bool { return frameHeaderLen+len(w.pf.Data) <= max }
 
+type writeOrigin []string
+
+func (o writeOrigin) staysWithinBuffer(max int) bool {
+	n := frameHeaderLen
+	for _, s := range o {
+		n += 2 + len(s)
+	}
+	return n <= max
+}
+
+func (o writeOrigin) writeFrame(ctx writeContext) error {
+	return ctx.Framer().WriteOrigin([]string(o)...)
+}
+
 type writeSettingsAck struct{}
 
 func (writeSettingsAck) writeFrame(ctx writeContext) error {
@@ -188,11 +202,11 @@ type writeResHeaders struct {
 	contentLength string
 }
 
-func encKV(enc *hpack.Encoder, k, v string) {
+func encKV(enc *headerEncoder, k, v string) {
 	if VerboseLogs {
 		log.Printf("http2: server encoding header %q = %q", k, v)
 	}
-	enc.WriteField(hpack.HeaderField{Name: k, Value: v})
+	enc.writeField(hpack.HeaderField{Name: k, Value: v})
 }
 
 func (w *writeResHeaders) staysWithinBuffer(max int) bool {
@@ -330,7 +344,7 @@ func (wu writeWindowUpdate) writeFrame(ctx writeContext) error {
 
 // encodeHeaders encodes an http.Header. If keys is not nil, then (k, h[k])
 // is encoded only if k is in keys.
-func encodeHeaders(enc *hpack.Encoder, h http.Header, keys []string) {
+func encodeHeaders(enc *headerEncoder, h http.Header, keys []string) {
 	if keys == nil {
 		sorter := sorterPool.Get().(*sorter)
 		// Using defer here, since the returned keys from the
@@ -12,6 +12,7 @@
 // ICMP extensions for interface and next-hop identification are
 // defined in RFC 5837.
 // PROBE: A utility for probing interfaces is defined in RFC 8335.
+// Neighbor Discovery for IPv6 is defined in RFC 4861.
 package icmp // import "golang.org/x/net/icmp"
 
 import (
@@ -127,6 +128,12 @@ var parseFns = map[Type]func(int, Type, []byte) (MessageBody, error){
 	ipv6.ICMPTypeTimeExceeded:           parseTimeExceeded,
 	ipv6.ICMPTypeParameterProblem:       parseParamProb,
 
+	ipv6.ICMPTypeRouterSolicitation:    parseRouterSolicitation,
+	ipv6.ICMPTypeRouterAdvertisement:   parseRouterAdvertisement,
+	ipv6.ICMPTypeNeighborSolicitation:  parseNeighborSolicitation,
+	ipv6.ICMPTypeNeighborAdvertisement: parseNeighborAdvertisement,
+	ipv6.ICMPTypeRedirect:              parseRedirect,
+
 	ipv6.ICMPTypeEchoRequest:         parseEcho,
 	ipv6.ICMPTypeEchoReply:           parseEcho,
 	ipv6.ICMPTypeExtendedEchoRequest: parseExtendedEchoRequest,
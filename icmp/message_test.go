@@ -155,6 +155,69 @@ func TestMarshalAndParseMessage(t *testing.T) {
 						State: 5 /* Probe */, Active: true, IPv6: true,
 					},
 				},
+				{
+					Type: ipv6.ICMPTypeRouterSolicitation, Code: 0,
+					Body: &icmp.RouterSolicitation{
+						Options: []icmp.NDOption{
+							&icmp.LinkLayerAddress{Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x01}},
+						},
+					},
+				},
+				{
+					Type: ipv6.ICMPTypeRouterAdvertisement, Code: 0,
+					Body: &icmp.RouterAdvertisement{
+						CurHopLimit:    64,
+						Other:          true,
+						RouterLifetime: 1800,
+						ReachableTime:  30000,
+						RetransTimer:   1000,
+						Options: []icmp.NDOption{
+							&icmp.LinkLayerAddress{Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x01}},
+							&icmp.MTUOption{MTU: 1280},
+							&icmp.PrefixInformation{
+								PrefixLength:      64,
+								OnLink:            true,
+								Autonomous:        true,
+								ValidLifetime:     2592000,
+								PreferredLifetime: 604800,
+								Prefix:            net.ParseIP("2001:db8:1::"),
+							},
+							&icmp.RawNDOption{Type: 25, Data: []byte{0, 0, 0, 0, 0x0e, 0x10, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53}},
+						},
+					},
+				},
+				{
+					Type: ipv6.ICMPTypeNeighborSolicitation, Code: 0,
+					Body: &icmp.NeighborSolicitation{
+						Target: net.ParseIP("fe80::1"),
+						Options: []icmp.NDOption{
+							&icmp.LinkLayerAddress{Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x02}},
+						},
+					},
+				},
+				{
+					Type: ipv6.ICMPTypeNeighborAdvertisement, Code: 0,
+					Body: &icmp.NeighborAdvertisement{
+						Router:    true,
+						Solicited: true,
+						Override:  true,
+						Target:    net.ParseIP("fe80::1"),
+						Options: []icmp.NDOption{
+							&icmp.LinkLayerAddress{Target: true, Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x01}},
+						},
+					},
+				},
+				{
+					Type: ipv6.ICMPTypeRedirect, Code: 0,
+					Body: &icmp.Redirect{
+						Target:      net.ParseIP("fe80::2"),
+						Destination: net.ParseIP("2001:db8:2::1"),
+						Options: []icmp.NDOption{
+							&icmp.LinkLayerAddress{Target: true, Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x03}},
+							&icmp.RedirectedHeader{Data: []byte("ERROR-INVOKING-PACKET---")},
+						},
+					},
+				},
 			})
 	})
 }
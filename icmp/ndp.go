@@ -0,0 +1,504 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmp
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/internal/iana"
+)
+
+// An NDOption represents a Neighbor Discovery option.
+type NDOption interface {
+	// Len returns the length of the option, including its type,
+	// length, and padding.
+	Len() int
+
+	// Marshal returns the binary encoding of the option.
+	Marshal() ([]byte, error)
+}
+
+// Neighbor Discovery option types
+const (
+	ndOptSourceLinkLayerAddress = 1
+	ndOptTargetLinkLayerAddress = 2
+	ndOptPrefixInformation      = 3
+	ndOptRedirectedHeader       = 4
+	ndOptMTU                    = 5
+)
+
+// ndOptionLen returns the length of an option with n bytes following
+// the type and length fields, rounded up to a multiple of 8 bytes.
+func ndOptionLen(n int) int {
+	return (2 + n + 7) &^ 7
+}
+
+// marshalNDOption returns the encoding of an option of type typ,
+// with room for the option's data after the type and length fields.
+func marshalNDOption(typ, dataLen int) ([]byte, error) {
+	l := ndOptionLen(dataLen)
+	if l/8 > 0xff {
+		return nil, errInvalidBody
+	}
+	b := make([]byte, l)
+	b[0] = byte(typ)
+	b[1] = byte(l / 8)
+	return b, nil
+}
+
+// A LinkLayerAddress represents a source or target link-layer
+// address option.
+type LinkLayerAddress struct {
+	Target bool             // target rather than source link-layer address
+	Addr   net.HardwareAddr // link-layer address
+}
+
+// Len implements the Len method of NDOption interface.
+func (o *LinkLayerAddress) Len() int {
+	if o == nil {
+		return 0
+	}
+	return ndOptionLen(len(o.Addr))
+}
+
+// Marshal implements the Marshal method of NDOption interface.
+func (o *LinkLayerAddress) Marshal() ([]byte, error) {
+	typ := ndOptSourceLinkLayerAddress
+	if o.Target {
+		typ = ndOptTargetLinkLayerAddress
+	}
+	b, err := marshalNDOption(typ, len(o.Addr))
+	if err != nil {
+		return nil, err
+	}
+	copy(b[2:], o.Addr)
+	return b, nil
+}
+
+// A PrefixInformation represents a prefix information option.
+type PrefixInformation struct {
+	PrefixLength      int    // number of leading bits of Prefix that are valid
+	OnLink            bool   // prefix can be used for on-link determination
+	Autonomous        bool   // prefix can be used for address autoconfiguration
+	ValidLifetime     uint32 // in seconds; 0xffffffff represents infinity
+	PreferredLifetime uint32 // in seconds; 0xffffffff represents infinity
+	Prefix            net.IP // IPv6 prefix
+}
+
+// Len implements the Len method of NDOption interface.
+func (o *PrefixInformation) Len() int {
+	if o == nil {
+		return 0
+	}
+	return 32
+}
+
+// Marshal implements the Marshal method of NDOption interface.
+func (o *PrefixInformation) Marshal() ([]byte, error) {
+	prefix := o.Prefix.To16()
+	if prefix == nil || o.PrefixLength < 0 || o.PrefixLength > 128 {
+		return nil, errInvalidBody
+	}
+	b, _ := marshalNDOption(ndOptPrefixInformation, 30)
+	b[2] = byte(o.PrefixLength)
+	if o.OnLink {
+		b[3] |= 0x80
+	}
+	if o.Autonomous {
+		b[3] |= 0x40
+	}
+	binary.BigEndian.PutUint32(b[4:8], o.ValidLifetime)
+	binary.BigEndian.PutUint32(b[8:12], o.PreferredLifetime)
+	copy(b[16:32], prefix)
+	return b, nil
+}
+
+// A RedirectedHeader represents a redirected header option.
+type RedirectedHeader struct {
+	Data []byte // leading part of the redirected packet
+}
+
+// Len implements the Len method of NDOption interface.
+func (o *RedirectedHeader) Len() int {
+	if o == nil {
+		return 0
+	}
+	return ndOptionLen(6 + len(o.Data))
+}
+
+// Marshal implements the Marshal method of NDOption interface.
+func (o *RedirectedHeader) Marshal() ([]byte, error) {
+	b, err := marshalNDOption(ndOptRedirectedHeader, 6+len(o.Data))
+	if err != nil {
+		return nil, err
+	}
+	copy(b[8:], o.Data)
+	return b, nil
+}
+
+// An MTUOption represents an MTU option.
+type MTUOption struct {
+	MTU int // maximum transmission unit of the link
+}
+
+// Len implements the Len method of NDOption interface.
+func (o *MTUOption) Len() int {
+	if o == nil {
+		return 0
+	}
+	return 8
+}
+
+// Marshal implements the Marshal method of NDOption interface.
+func (o *MTUOption) Marshal() ([]byte, error) {
+	b, _ := marshalNDOption(ndOptMTU, 6)
+	binary.BigEndian.PutUint32(b[4:8], uint32(o.MTU))
+	return b, nil
+}
+
+// A RawNDOption represents an option of a type not known to this
+// package.
+type RawNDOption struct {
+	Type int    // option type
+	Data []byte // data following the type and length fields, including padding
+}
+
+// Len implements the Len method of NDOption interface.
+func (o *RawNDOption) Len() int {
+	if o == nil {
+		return 0
+	}
+	return ndOptionLen(len(o.Data))
+}
+
+// Marshal implements the Marshal method of NDOption interface.
+func (o *RawNDOption) Marshal() ([]byte, error) {
+	b, err := marshalNDOption(o.Type, len(o.Data))
+	if err != nil {
+		return nil, err
+	}
+	copy(b[2:], o.Data)
+	return b, nil
+}
+
+func ndOptionsLen(opts []NDOption) int {
+	var l int
+	for _, o := range opts {
+		l += o.Len()
+	}
+	return l
+}
+
+// marshalNDMessage returns the encoding of a message body with a
+// fixed part of fixedLen bytes followed by opts.
+func marshalNDMessage(proto, fixedLen int, opts []NDOption) ([]byte, error) {
+	if proto != iana.ProtocolIPv6ICMP {
+		return nil, errInvalidProtocol
+	}
+	b := make([]byte, fixedLen, fixedLen+ndOptionsLen(opts))
+	for _, o := range opts {
+		ob, err := o.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, ob...)
+	}
+	return b, nil
+}
+
+// parseNDOptions parses b as a list of Neighbor Discovery options.
+func parseNDOptions(b []byte) ([]NDOption, error) {
+	var opts []NDOption
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errMessageTooShort
+		}
+		l := int(b[1]) * 8
+		if l == 0 {
+			return nil, errInvalidBody
+		}
+		if l > len(b) {
+			return nil, errMessageTooShort
+		}
+		data := b[2:l]
+		switch typ := int(b[0]); typ {
+		case ndOptSourceLinkLayerAddress, ndOptTargetLinkLayerAddress:
+			opts = append(opts, &LinkLayerAddress{
+				Target: typ == ndOptTargetLinkLayerAddress,
+				Addr:   append(net.HardwareAddr(nil), data...),
+			})
+		case ndOptPrefixInformation:
+			if l != 32 {
+				return nil, errInvalidBody
+			}
+			opts = append(opts, &PrefixInformation{
+				PrefixLength:      int(data[0]),
+				OnLink:            data[1]&0x80 != 0,
+				Autonomous:        data[1]&0x40 != 0,
+				ValidLifetime:     binary.BigEndian.Uint32(data[2:6]),
+				PreferredLifetime: binary.BigEndian.Uint32(data[6:10]),
+				Prefix:            append(net.IP(nil), data[14:30]...),
+			})
+		case ndOptRedirectedHeader:
+			opts = append(opts, &RedirectedHeader{Data: append([]byte(nil), data[6:]...)})
+		case ndOptMTU:
+			if l != 8 {
+				return nil, errInvalidBody
+			}
+			opts = append(opts, &MTUOption{MTU: int(binary.BigEndian.Uint32(data[2:6]))})
+		default:
+			opts = append(opts, &RawNDOption{Type: typ, Data: append([]byte(nil), data...)})
+		}
+		b = b[l:]
+	}
+	return opts, nil
+}
+
+// A RouterSolicitation represents an ICMPv6 router solicitation
+// message body.
+type RouterSolicitation struct {
+	Options []NDOption // options
+}
+
+// Len implements the Len method of MessageBody interface.
+func (p *RouterSolicitation) Len(proto int) int {
+	if p == nil {
+		return 0
+	}
+	return 4 + ndOptionsLen(p.Options)
+}
+
+// Marshal implements the Marshal method of MessageBody interface.
+func (p *RouterSolicitation) Marshal(proto int) ([]byte, error) {
+	return marshalNDMessage(proto, 4, p.Options)
+}
+
+// parseRouterSolicitation parses b as an ICMPv6 router solicitation
+// message body.
+func parseRouterSolicitation(proto int, _ Type, b []byte) (MessageBody, error) {
+	if len(b) < 4 {
+		return nil, errMessageTooShort
+	}
+	opts, err := parseNDOptions(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &RouterSolicitation{Options: opts}, nil
+}
+
+// A RouterAdvertisement represents an ICMPv6 router advertisement
+// message body.
+type RouterAdvertisement struct {
+	CurHopLimit    int        // default hop limit for outgoing packets, or 0 if unspecified
+	Managed        bool       // addresses are available via DHCPv6
+	Other          bool       // other configuration is available via DHCPv6
+	RouterLifetime int        // lifetime of the router as a default router, in seconds
+	ReachableTime  int        // in milliseconds, or 0 if unspecified
+	RetransTimer   int        // in milliseconds, or 0 if unspecified
+	Options        []NDOption // options
+}
+
+// Len implements the Len method of MessageBody interface.
+func (p *RouterAdvertisement) Len(proto int) int {
+	if p == nil {
+		return 0
+	}
+	return 12 + ndOptionsLen(p.Options)
+}
+
+// Marshal implements the Marshal method of MessageBody interface.
+func (p *RouterAdvertisement) Marshal(proto int) ([]byte, error) {
+	b, err := marshalNDMessage(proto, 12, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	b[0] = byte(p.CurHopLimit)
+	if p.Managed {
+		b[1] |= 0x80
+	}
+	if p.Other {
+		b[1] |= 0x40
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(p.RouterLifetime))
+	binary.BigEndian.PutUint32(b[4:8], uint32(p.ReachableTime))
+	binary.BigEndian.PutUint32(b[8:12], uint32(p.RetransTimer))
+	return b, nil
+}
+
+// parseRouterAdvertisement parses b as an ICMPv6 router advertisement
+// message body.
+func parseRouterAdvertisement(proto int, _ Type, b []byte) (MessageBody, error) {
+	if len(b) < 12 {
+		return nil, errMessageTooShort
+	}
+	p := &RouterAdvertisement{
+		CurHopLimit:    int(b[0]),
+		Managed:        b[1]&0x80 != 0,
+		Other:          b[1]&0x40 != 0,
+		RouterLifetime: int(binary.BigEndian.Uint16(b[2:4])),
+		ReachableTime:  int(binary.BigEndian.Uint32(b[4:8])),
+		RetransTimer:   int(binary.BigEndian.Uint32(b[8:12])),
+	}
+	var err error
+	if p.Options, err = parseNDOptions(b[12:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// A NeighborSolicitation represents an ICMPv6 neighbor solicitation
+// message body.
+type NeighborSolicitation struct {
+	Target  net.IP     // target address
+	Options []NDOption // options
+}
+
+// Len implements the Len method of MessageBody interface.
+func (p *NeighborSolicitation) Len(proto int) int {
+	if p == nil {
+		return 0
+	}
+	return 20 + ndOptionsLen(p.Options)
+}
+
+// Marshal implements the Marshal method of MessageBody interface.
+func (p *NeighborSolicitation) Marshal(proto int) ([]byte, error) {
+	b, err := marshalNDMessage(proto, 20, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := putNDAddr(b[4:20], p.Target); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseNeighborSolicitation parses b as an ICMPv6 neighbor
+// solicitation message body.
+func parseNeighborSolicitation(proto int, _ Type, b []byte) (MessageBody, error) {
+	if len(b) < 20 {
+		return nil, errMessageTooShort
+	}
+	p := &NeighborSolicitation{Target: append(net.IP(nil), b[4:20]...)}
+	var err error
+	if p.Options, err = parseNDOptions(b[20:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// A NeighborAdvertisement represents an ICMPv6 neighbor advertisement
+// message body.
+type NeighborAdvertisement struct {
+	Router    bool       // sender is a router
+	Solicited bool       // sent in response to a neighbor solicitation
+	Override  bool       // override an existing cache entry
+	Target    net.IP     // target address
+	Options   []NDOption // options
+}
+
+// Len implements the Len method of MessageBody interface.
+func (p *NeighborAdvertisement) Len(proto int) int {
+	if p == nil {
+		return 0
+	}
+	return 20 + ndOptionsLen(p.Options)
+}
+
+// Marshal implements the Marshal method of MessageBody interface.
+func (p *NeighborAdvertisement) Marshal(proto int) ([]byte, error) {
+	b, err := marshalNDMessage(proto, 20, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	if p.Router {
+		b[0] |= 0x80
+	}
+	if p.Solicited {
+		b[0] |= 0x40
+	}
+	if p.Override {
+		b[0] |= 0x20
+	}
+	if err := putNDAddr(b[4:20], p.Target); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseNeighborAdvertisement parses b as an ICMPv6 neighbor
+// advertisement message body.
+func parseNeighborAdvertisement(proto int, _ Type, b []byte) (MessageBody, error) {
+	if len(b) < 20 {
+		return nil, errMessageTooShort
+	}
+	p := &NeighborAdvertisement{
+		Router:    b[0]&0x80 != 0,
+		Solicited: b[0]&0x40 != 0,
+		Override:  b[0]&0x20 != 0,
+		Target:    append(net.IP(nil), b[4:20]...),
+	}
+	var err error
+	if p.Options, err = parseNDOptions(b[20:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// A Redirect represents an ICMPv6 redirect message body.
+type Redirect struct {
+	Target      net.IP     // better first hop for Destination
+	Destination net.IP     // destination address being redirected
+	Options     []NDOption // options
+}
+
+// Len implements the Len method of MessageBody interface.
+func (p *Redirect) Len(proto int) int {
+	if p == nil {
+		return 0
+	}
+	return 36 + ndOptionsLen(p.Options)
+}
+
+// Marshal implements the Marshal method of MessageBody interface.
+func (p *Redirect) Marshal(proto int) ([]byte, error) {
+	b, err := marshalNDMessage(proto, 36, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := putNDAddr(b[4:20], p.Target); err != nil {
+		return nil, err
+	}
+	if err := putNDAddr(b[20:36], p.Destination); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseRedirect parses b as an ICMPv6 redirect message body.
+func parseRedirect(proto int, _ Type, b []byte) (MessageBody, error) {
+	if len(b) < 36 {
+		return nil, errMessageTooShort
+	}
+	p := &Redirect{
+		Target:      append(net.IP(nil), b[4:20]...),
+		Destination: append(net.IP(nil), b[20:36]...),
+	}
+	var err error
+	if p.Options, err = parseNDOptions(b[36:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func putNDAddr(b []byte, ip net.IP) error {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return errInvalidBody
+	}
+	copy(b, ip16)
+	return nil
+}
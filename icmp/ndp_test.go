@@ -0,0 +1,57 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmp_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/internal/iana"
+	"golang.org/x/net/ipv6"
+)
+
+func TestNeighborDiscoveryWireFormat(t *testing.T) {
+	m := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborAdvertisement, Code: 0,
+		Body: &icmp.NeighborAdvertisement{
+			Solicited: true,
+			Override:  true,
+			Target:    net.ParseIP("fe80::1"),
+			Options: []icmp.NDOption{
+				&icmp.LinkLayerAddress{Target: true, Addr: net.HardwareAddr{0x02, 0x00, 0x5e, 0x10, 0x00, 0x01}},
+			},
+		},
+	}
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x88, 0x00, 0x00, 0x00, // type, code, checksum
+		0x60, 0x00, 0x00, 0x00, // S and O flags
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		0x02, 0x01, 0x02, 0x00, 0x5e, 0x10, 0x00, 0x01,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Marshal:\ngot  %x\nwant %x", b, want)
+	}
+
+	if _, err := m.Body.Marshal(iana.ProtocolICMP); err == nil {
+		t.Error("Marshal for ICMPv4 succeeded")
+	}
+
+	for _, bad := range [][]byte{
+		want[:20], // truncated target
+		append(want[:24:24], 2, 0, 0, 0, 0, 0, 0, 0),                         // zero length option
+		append(want[:24:24], 2, 2, 0, 0, 0, 0, 0, 0),                         // option longer than message
+		append(want[:24:24], 5, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0), // long MTU option
+	} {
+		if _, err := icmp.ParseMessage(iana.ProtocolIPv6ICMP, bad); err == nil {
+			t.Errorf("ParseMessage(%x) succeeded", bad)
+		}
+	}
+}
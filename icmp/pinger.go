@@ -0,0 +1,189 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmp
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/internal/iana"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// An EchoBackend sends ICMP echo requests and receives their replies
+// on behalf of a Pinger.
+//
+// Implementations are platform specific: NewConnEchoBackend uses a
+// PacketConn, and on Windows the ICMP helper functions of the IP
+// Helper API are used, which need neither raw sockets nor
+// administrator privileges.
+type EchoBackend interface {
+	// Echo sends an echo request carrying data to dst, and waits
+	// for the matching reply until ctx is done.
+	// Echo is not called concurrently.
+	Echo(ctx context.Context, dst net.IP, data []byte) (*EchoReply, error)
+
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// An EchoReply is a reply to an echo request.
+type EchoReply struct {
+	Addr net.IP        // address the reply came from
+	Data []byte        // data carried by the reply
+	RTT  time.Duration // round-trip time of the request
+}
+
+// A Pinger sends ICMP echo requests.
+// Its methods are safe for concurrent use; requests are sent one at
+// a time.
+type Pinger struct {
+	mu      sync.Mutex
+	backend EchoBackend
+	size    int
+}
+
+// defaultPingSize is the number of data bytes in a Pinger's echo
+// requests, the same as the ping command's default.
+const defaultPingSize = 56
+
+// NewPinger returns a Pinger sending echo requests from address on
+// network, which has the same form as for ListenPacket.
+//
+// On Windows, the IPv4 networks "ip4:icmp", "ip4:1" and "udp4" use the
+// ICMP helper functions rather than a socket, and address is ignored.
+func NewPinger(network, address string) (*Pinger, error) {
+	if b := platformEchoBackend(network); b != nil {
+		return NewPingerBackend(b), nil
+	}
+	c, err := ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewPingerBackend(NewConnEchoBackend(c)), nil
+}
+
+// NewPingerBackend returns a Pinger sending echo requests with b.
+func NewPingerBackend(b EchoBackend) *Pinger {
+	return &Pinger{backend: b, size: defaultPingSize}
+}
+
+// Ping sends an echo request to dst and waits for its reply until
+// ctx is done.
+func (p *Pinger) Ping(ctx context.Context, dst net.IP) (*EchoReply, error) {
+	data := make([]byte, p.size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.backend.Echo(ctx, dst, data)
+}
+
+// Close closes the Pinger's backend.
+func (p *Pinger) Close() error {
+	return p.backend.Close()
+}
+
+// NewConnEchoBackend returns an EchoBackend exchanging messages on c,
+// which is closed by the backend's Close method.
+func NewConnEchoBackend(c *PacketConn) EchoBackend {
+	return &connEchoBackend{c: c, id: os.Getpid() & 0xffff}
+}
+
+type connEchoBackend struct {
+	c   *PacketConn
+	id  int
+	seq int
+}
+
+func (b *connEchoBackend) Echo(ctx context.Context, dst net.IP, data []byte) (*EchoReply, error) {
+	b.seq = (b.seq + 1) & 0xffff
+	proto, typ := iana.ProtocolICMP, Type(ipv4.ICMPTypeEcho)
+	if dst.To4() == nil {
+		proto, typ = iana.ProtocolIPv6ICMP, ipv6.ICMPTypeEchoRequest
+	}
+	wm := Message{
+		Type: typ,
+		Body: &Echo{ID: b.id, Seq: b.seq, Data: data},
+	}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr = &net.IPAddr{IP: dst}
+	if _, ok := b.c.LocalAddr().(*net.UDPAddr); ok {
+		addr = &net.UDPAddr{IP: dst}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		b.c.SetReadDeadline(deadline)
+	}
+	// Wait for the goroutine interrupting reads on cancelation to
+	// exit before clearing the deadline, so that it can't set one
+	// after Echo returns, which would fail the next Echo.
+	done, exited := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-exited
+		b.c.SetReadDeadline(time.Time{})
+	}()
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			b.c.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	start := time.Now()
+	if _, err := b.c.WriteTo(wb, addr); err != nil {
+		return nil, err
+	}
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := b.c.ReadFrom(rb)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		rm, err := ParseMessage(proto, rb[:n])
+		if err != nil {
+			continue
+		}
+		if rm.Type != ipv4.ICMPTypeEchoReply && rm.Type != ipv6.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := rm.Body.(*Echo)
+		if !ok || echo.Seq != b.seq {
+			continue
+		}
+		reply := &EchoReply{Data: echo.Data, RTT: time.Since(start)}
+		switch a := peer.(type) {
+		case *net.IPAddr:
+			// Raw endpoints receive every process's replies.
+			// Non-privileged datagram endpoints choose their
+			// own identifier, and only receive their own.
+			if echo.ID != b.id {
+				continue
+			}
+			reply.Addr = a.IP
+		case *net.UDPAddr:
+			reply.Addr = a.IP
+		}
+		return reply, nil
+	}
+}
+
+func (b *connEchoBackend) Close() error {
+	return b.c.Close()
+}
@@ -0,0 +1,13 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package icmp
+
+// platformEchoBackend returns the platform's socket-less EchoBackend
+// for network, or nil if there is none.
+func platformEchoBackend(network string) EchoBackend {
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmp_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+type fakeEchoBackend struct {
+	dst    net.IP
+	data   []byte
+	closed bool
+}
+
+func (b *fakeEchoBackend) Echo(ctx context.Context, dst net.IP, data []byte) (*icmp.EchoReply, error) {
+	b.dst, b.data = dst, data
+	return &icmp.EchoReply{Addr: dst, Data: data, RTT: time.Millisecond}, nil
+}
+
+func (b *fakeEchoBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestPingerBackend(t *testing.T) {
+	b := &fakeEchoBackend{}
+	p := icmp.NewPingerBackend(b)
+	dst := net.IPv4(192, 0, 2, 1)
+	reply, err := p.Ping(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.dst.Equal(dst) || len(b.data) != 56 {
+		t.Errorf("backend got echo to %v with %v data bytes; want %v with 56", b.dst, len(b.data), dst)
+	}
+	if !reply.Addr.Equal(dst) || !bytes.Equal(reply.Data, b.data) {
+		t.Errorf("Ping reply = %+v; want reply from backend", reply)
+	}
+	p.Close()
+	if !b.closed {
+		t.Errorf("Pinger.Close did not close backend")
+	}
+}
+
+func TestPingerLoopback(t *testing.T) {
+	if m, ok := supportsNonPrivilegedICMP(); !ok {
+		t.Skip(m)
+	}
+	p, err := icmp.NewPinger("udp4", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		reply, err := p.Ping(ctx, net.IPv4(127, 0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reply.Addr.Equal(net.IPv4(127, 0, 0, 1)) || len(reply.Data) != 56 {
+			t.Errorf("Ping reply = %+v; want 56 bytes from 127.0.0.1", reply)
+		}
+	}
+}
+
+func TestPingerCancel(t *testing.T) {
+	if m, ok := supportsNonPrivilegedICMP(); !ok {
+		t.Skip(m)
+	}
+	p, err := icmp.NewPinger("udp4", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// 192.0.2.0/24 is reserved for documentation, and does not reply.
+	if _, err := p.Ping(ctx, net.IPv4(192, 0, 2, 1)); err != context.Canceled {
+		t.Errorf("Ping with canceled context: %v, want %v", err, context.Canceled)
+	}
+
+	// The cancelation must not affect later requests,
+	// including ones whose context has no deadline.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(3*time.Second, cancel)
+	if _, err := p.Ping(ctx, net.IPv4(127, 0, 0, 1)); err != nil {
+		t.Errorf("Ping after canceled Ping: %v", err)
+	}
+}
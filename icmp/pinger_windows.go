@@ -0,0 +1,209 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package icmp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modiphlpapi = windows.NewLazySystemDLL("iphlpapi.dll")
+
+	procIcmpCreateFile  = modiphlpapi.NewProc("IcmpCreateFile")
+	procIcmpCloseHandle = modiphlpapi.NewProc("IcmpCloseHandle")
+	procIcmpSendEcho    = modiphlpapi.NewProc("IcmpSendEcho")
+)
+
+// icmpEchoReply is the ICMP_ECHO_REPLY structure.
+type icmpEchoReply struct {
+	Address       [4]byte
+	Status        uint32
+	RoundTripTime uint32
+	DataSize      uint16
+	Reserved      uint16
+	Data          uintptr
+	Options       ipOptionInformation
+}
+
+// ipOptionInformation is the IP_OPTION_INFORMATION structure.
+type ipOptionInformation struct {
+	TTL         uint8
+	TOS         uint8
+	Flags       uint8
+	OptionsSize uint8
+	OptionsData uintptr
+}
+
+const (
+	ipSuccess = 0 // IP_SUCCESS
+
+	// defaultHelperTimeout bounds an echo request whose context
+	// has no deadline.
+	defaultHelperTimeout = 4 * time.Second
+)
+
+func platformEchoBackend(network string) EchoBackend {
+	switch network {
+	case "ip4:icmp", "ip4:1", "udp4":
+		return &helperEchoBackend{}
+	}
+	return nil
+}
+
+// A helperEchoBackend sends IPv4 echo requests with the IcmpSendEcho
+// function of the IP Helper API.
+type helperEchoBackend struct {
+	mu     sync.Mutex
+	h      windows.Handle // opened on first use
+	err    error
+	closed bool
+
+	// calls tracks IcmpSendEcho calls using h, which may outlive
+	// the Echo that made them. Close waits for them to return
+	// before closing h.
+	calls sync.WaitGroup
+}
+
+// handle returns the backend's handle, opening it if needed, and adds
+// a call to b.calls which the caller must mark done when it is no
+// longer using the handle.
+func (b *helperEchoBackend) handle() (windows.Handle, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, net.ErrClosed
+	}
+	if b.h == 0 && b.err == nil {
+		b.h, b.err = openICMPHandle()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.calls.Add(1)
+	return b.h, nil
+}
+
+func openICMPHandle() (windows.Handle, error) {
+	if err := procIcmpCreateFile.Find(); err != nil {
+		return 0, err
+	}
+	r, _, err := procIcmpCreateFile.Call()
+	if windows.Handle(r) == windows.InvalidHandle {
+		return 0, err
+	}
+	return windows.Handle(r), nil
+}
+
+func (b *helperEchoBackend) Echo(ctx context.Context, dst net.IP, data []byte) (*EchoReply, error) {
+	ip4 := dst.To4()
+	if ip4 == nil {
+		return nil, errors.New("icmp: ICMP helper echo supports only IPv4")
+	}
+	timeout := defaultHelperTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	h, err := b.handle()
+	if err != nil {
+		return nil, err
+	}
+
+	// IcmpSendEcho blocks until the reply or its timeout, and
+	// cannot be canceled, so it runs in its own goroutine.
+	type result struct {
+		reply *EchoReply
+		err   error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		defer b.calls.Done()
+		reply, err := helperEcho(h, ip4, data, timeout)
+		resc <- result{reply, err}
+	}()
+	select {
+	case res := <-resc:
+		return res.reply, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func helperEcho(h windows.Handle, ip4 net.IP, data []byte, timeout time.Duration) (*EchoReply, error) {
+	var reply icmpEchoReply
+	// The reply buffer holds an ICMP_ECHO_REPLY, the echoed data,
+	// room for an ICMP error message, and an IO_STATUS_BLOCK.
+	var iosb windows.IO_STATUS_BLOCK
+	buf := make([]byte, int(unsafe.Sizeof(reply))+len(data)+8+int(unsafe.Sizeof(iosb)))
+	var req unsafe.Pointer
+	if len(data) > 0 {
+		req = unsafe.Pointer(&data[0])
+	}
+	addr := *(*uint32)(unsafe.Pointer(&ip4[0])) // IPAddr, in network byte order
+	ms := uint32(timeout / time.Millisecond)
+	if ms == 0 {
+		ms = 1
+	}
+	n, _, err := procIcmpSendEcho.Call(
+		uintptr(h),
+		uintptr(addr),
+		uintptr(req),
+		uintptr(len(data)),
+		0,
+		uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(len(buf)),
+		uintptr(ms),
+	)
+	if n == 0 {
+		return nil, err
+	}
+	reply = *(*icmpEchoReply)(unsafe.Pointer(&buf[0]))
+	if reply.Status != ipSuccess {
+		return nil, errors.New("icmp: echo failed with IP status " + strconv.Itoa(int(reply.Status)))
+	}
+	rdata := make([]byte, reply.DataSize)
+	if off := reply.Data - uintptr(unsafe.Pointer(&buf[0])); reply.DataSize > 0 && off < uintptr(len(buf)) {
+		copy(rdata, buf[off:])
+	}
+	return &EchoReply{
+		Addr: net.IPv4(reply.Address[0], reply.Address[1], reply.Address[2], reply.Address[3]),
+		Data: rdata,
+		RTT:  time.Duration(reply.RoundTripTime) * time.Millisecond,
+	}, nil
+}
+
+func (b *helperEchoBackend) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	h := b.h
+	b.mu.Unlock()
+	if h == 0 {
+		// The handle was never opened.
+		return nil
+	}
+	// An Echo canceled by its context leaves its IcmpSendEcho call
+	// running until the call's timeout; the handle and reply buffer
+	// must not be freed under it.
+	b.calls.Wait()
+	r, _, err := procIcmpCloseHandle.Call(uintptr(h))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package idna
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A Converter converts many domain names using a Profile, for programs
+// such as DNS servers and crawlers which convert large numbers of names.
+//
+// Names which are already in canonical ASCII form, made up of lower case
+// letters, digits, and hyphens in valid labels, are returned unchanged
+// without consulting the Profile's tables and without allocating. Other
+// names are converted by the Profile, and the results of the most recent
+// conversions may be kept in a cache.
+//
+// A Converter is safe for concurrent use by multiple goroutines.
+type Converter struct {
+	p   *Profile
+	max int
+
+	mu    sync.Mutex
+	lru   list.List // of *cacheEntry, most recently used first
+	cache map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	name    string
+	toASCII bool
+}
+
+type cacheEntry struct {
+	key cacheKey
+	s   string
+	err error
+}
+
+// NewConverter returns a Converter using the Profile p, caching the
+// results of up to cacheSize conversions. If p is nil, the Lookup
+// profile is used. If cacheSize is zero or negative, no results are
+// cached.
+func NewConverter(p *Profile, cacheSize int) *Converter {
+	if p == nil {
+		p = Lookup
+	}
+	return &Converter{p: p, max: cacheSize}
+}
+
+// ToASCII is like Profile.ToASCII.
+func (c *Converter) ToASCII(s string) (string, error) {
+	return c.convert(s, true)
+}
+
+// ToUnicode is like Profile.ToUnicode.
+func (c *Converter) ToUnicode(s string) (string, error) {
+	return c.convert(s, false)
+}
+
+// ToASCIIAll converts each of names to its ASCII form in place.
+// If any conversion fails, it returns a slice of the same length as
+// names holding each failed conversion's error, and nil for the
+// others; as with ToASCII, the failed names are replaced with their
+// partially processed results. Otherwise it returns nil.
+func (c *Converter) ToASCIIAll(names []string) []error {
+	return c.convertAll(names, true)
+}
+
+// ToUnicodeAll is like ToASCIIAll, but converts names to their
+// Unicode forms.
+func (c *Converter) ToUnicodeAll(names []string) []error {
+	return c.convertAll(names, false)
+}
+
+func (c *Converter) convertAll(names []string, toASCII bool) []error {
+	var errs []error
+	for i, name := range names {
+		s, err := c.convert(name, toASCII)
+		names[i] = s
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(names))
+			}
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (c *Converter) convert(s string, toASCII bool) (string, error) {
+	if canonicalASCII(s) {
+		return s, nil
+	}
+	if c.max <= 0 {
+		return c.process(s, toASCII)
+	}
+	key := cacheKey{s, toASCII}
+	c.mu.Lock()
+	if e, ok := c.cache[key]; ok {
+		c.lru.MoveToFront(e)
+		ent := e.Value.(*cacheEntry)
+		c.mu.Unlock()
+		return ent.s, ent.err
+	}
+	c.mu.Unlock()
+
+	// Convert without holding the lock, so that conversions of
+	// different names proceed concurrently.
+	res, err := c.process(s, toASCII)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[key]; ok {
+		// Converted concurrently by another goroutine.
+		return res, err
+	}
+	if c.cache == nil {
+		c.cache = make(map[cacheKey]*list.Element)
+	}
+	c.cache[key] = c.lru.PushFront(&cacheEntry{key: key, s: res, err: err})
+	if c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.cache, e.Value.(*cacheEntry).key)
+	}
+	return res, err
+}
+
+func (c *Converter) process(s string, toASCII bool) (string, error) {
+	if toASCII {
+		return c.p.ToASCII(s)
+	}
+	return c.p.ToUnicode(s)
+}
+
+// canonicalASCII reports whether every profile converts s to itself
+// without error, in either direction. This holds for names made up of
+// non-empty labels of at most 63 lower case letters, digits, and
+// hyphens, not starting or ending with a hyphen and without hyphens in
+// the third and fourth positions (which excludes "xn--" labels),
+// optionally followed by the root label's trailing dot.
+func canonicalASCII(s string) bool {
+	if len(s) == 0 || len(s) > 254 || len(s) == 254 && s[253] != '.' {
+		return false
+	}
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && s[i] != '.' {
+			c := s[i]
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+			continue
+		}
+		if i == len(s) && start == len(s) && start > 0 {
+			break // the trailing root label
+		}
+		label := s[start:i]
+		if n := len(label); n == 0 || n > 63 || label[0] == '-' || label[n-1] == '-' ||
+			n >= 4 && label[2] == '-' && label[3] == '-' {
+			return false
+		}
+		start = i + 1
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package idna
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalASCII(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	name253 := strings.Repeat(long+".", 3) + strings.Repeat("b", 61)
+	for _, tc := range []struct {
+		s    string
+		want bool
+	}{
+		{"golang.org", true},
+		{"golang.org.", true},
+		{"a-b.c0", true},
+		{"123.example", true},
+		{long, true},
+		{name253, true},
+		{name253 + ".", true},
+		{"", false},
+		{".", false},
+		{".golang.org", false},
+		{"golang..org", false},
+		{"golang.org..", false},
+		{"Golang.org", false},
+		{"-golang.org", false},
+		{"golang-.org", false},
+		{"xn--bcher-kva.example", false},
+		{"ab--cd.example", false},
+		{"foo_bar.example", false},
+		{long + "a", false},
+		{name253 + "b", false},
+		{"bücher.example", false},
+	} {
+		if got := canonicalASCII(tc.s); got != tc.want {
+			t.Errorf("canonicalASCII(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+		if !tc.want {
+			continue
+		}
+		// Every profile must agree that the name is unchanged.
+		for _, p := range []*Profile{Punycode, Lookup, Display, Registration} {
+			if got, err := p.ToASCII(tc.s); got != tc.s || err != nil {
+				t.Errorf("%v.ToASCII(%q) = %q, %v; want unchanged", p, tc.s, got, err)
+			}
+			if got, err := p.ToUnicode(tc.s); got != tc.s || err != nil {
+				t.Errorf("%v.ToUnicode(%q) = %q, %v; want unchanged", p, tc.s, got, err)
+			}
+		}
+	}
+}
+
+func TestConverter(t *testing.T) {
+	for _, size := range []int{0, 2, 100} {
+		c := NewConverter(Lookup, size)
+		for i := 0; i < 2; i++ {
+			for _, tc := range []string{"bücher.example", "BÜCHER.example", "golang.org", "ab--c.example", "xn--bcher-kva.example"} {
+				want, wantErr := Lookup.ToASCII(tc)
+				if got, err := c.ToASCII(tc); got != want || (err == nil) != (wantErr == nil) {
+					t.Errorf("size %v: ToASCII(%q) = %q, %v; want %q, %v", size, tc, got, err, want, wantErr)
+				}
+				want, wantErr = Lookup.ToUnicode(tc)
+				if got, err := c.ToUnicode(tc); got != want || (err == nil) != (wantErr == nil) {
+					t.Errorf("size %v: ToUnicode(%q) = %q, %v; want %q, %v", size, tc, got, err, want, wantErr)
+				}
+			}
+		}
+		if n := c.lru.Len(); n > size && size > 0 || size == 0 && n != 0 {
+			t.Errorf("size %v: cache holds %v entries", size, n)
+		}
+	}
+}
+
+func TestConverterAll(t *testing.T) {
+	c := NewConverter(nil, 10)
+	names := []string{"golang.org", "bücher.example", "-a.example", "www.müller.de"}
+	errs := c.ToASCIIAll(names)
+	want := []string{"golang.org", "xn--bcher-kva.example", "-a.example", "www.xn--mller-kva.de"}
+	for i := range names {
+		if names[i] != want[i] {
+			t.Errorf("ToASCIIAll: names[%v] = %q, want %q", i, names[i], want[i])
+		}
+	}
+	if len(errs) != len(names) || errs[0] != nil || errs[1] != nil || errs[2] == nil || errs[3] != nil {
+		t.Errorf("ToASCIIAll: errs = %v, want only errs[2] set", errs)
+	}
+
+	if errs := c.ToUnicodeAll(names); errs == nil || errs[2] == nil {
+		t.Errorf("ToUnicodeAll: errs = %v, want errs[2] set", errs)
+	}
+	if names[1] != "bücher.example" || names[3] != "www.müller.de" {
+		t.Errorf("ToUnicodeAll: names = %q", names)
+	}
+
+	ok := []string{"golang.org", "bücher.example"}
+	if errs := c.ToASCIIAll(ok); errs != nil {
+		t.Errorf("ToASCIIAll(%q) = %v, want nil", ok, errs)
+	}
+}
+
+func TestConverterASCIIAllocs(t *testing.T) {
+	c := NewConverter(Lookup, 100)
+	names := []string{"golang.org", "www.example.com.", "123.a-b.net"}
+	allocs := testing.AllocsPerRun(100, func() {
+		if errs := c.ToASCIIAll(names); errs != nil {
+			t.Fatal(errs)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("ToASCIIAll of canonical names: %v allocs, want 0", allocs)
+	}
+}
+
+func BenchmarkConverter(b *testing.B) {
+	names := []string{"golang.org", "www.example.com", "bücher.example", "xn--mller-kva.de"}
+	for _, bb := range []struct {
+		name string
+		conv func(string) (string, error)
+	}{
+		{"Profile", Lookup.ToASCII},
+		{"Converter", NewConverter(Lookup, 100).ToASCII},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bb.conv(names[i%len(names)])
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socket
+
+import "unsafe"
+
+// cmsghdr is the WSACMSGHDR structure.
+type cmsghdr struct {
+	Len   uintptr
+	Level int32
+	Type  int32
+}
+
+func (h *cmsghdr) len() int { return int(h.Len) }
+func (h *cmsghdr) lvl() int { return int(h.Level) }
+func (h *cmsghdr) typ() int { return int(h.Type) }
+
+func (h *cmsghdr) set(l, lvl, typ int) {
+	h.Len = uintptr(l)
+	h.Level = int32(lvl)
+	h.Type = int32(typ)
+}
+
+// Control message headers are aligned to the alignment of WSACMSGHDR,
+// and their data to MAX_NATURAL_ALIGNMENT, both of which are the size
+// of a pointer.
+const cmsgAlign = int(unsafe.Sizeof(uintptr(0)))
+
+func cmsgAlignOf(l int) int {
+	return (l + cmsgAlign - 1) &^ (cmsgAlign - 1)
+}
+
+func controlHeaderLen() int {
+	return cmsgAlignOf(int(unsafe.Sizeof(cmsghdr{})))
+}
+
+func controlMessageLen(dataLen int) int {
+	return controlHeaderLen() + dataLen
+}
+
+func controlMessageSpace(dataLen int) int {
+	return controlHeaderLen() + cmsgAlignOf(dataLen)
+}
@@ -15,6 +15,7 @@ import (
 type Conn struct {
 	network string
 	c       syscall.RawConn
+	conn    net.Conn // the connection c belongs to
 }
 
 // tcpConn is an interface implemented by net.TCPConn.
@@ -64,6 +65,7 @@ func NewConn(c net.Conn) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	cc.conn = c
 	return &cc, nil
 }
 
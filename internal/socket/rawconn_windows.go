@@ -0,0 +1,138 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package socket
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// On Windows, WSARecvMsg and WSASendMsg must be issued as overlapped
+// operations through the runtime's network poller to honor deadlines,
+// which syscall.RawConn does not permit. Messages are instead read and
+// written with the ReadMsg and WriteMsg methods of net.UDPConn and
+// net.IPConn, which use those calls.
+
+var errInvalidAddr = errors.New("invalid address type")
+
+type udpMsgConn interface {
+	ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error)
+}
+
+type ipMsgConn interface {
+	ReadMsgIP(b, oob []byte) (n, oobn, flags int, addr *net.IPAddr, err error)
+	WriteMsgIP(b, oob []byte, addr *net.IPAddr) (n, oobn int, err error)
+}
+
+func (c *Conn) recvMsg(m *Message, flags int) error {
+	if flags != 0 {
+		return errNotImplemented
+	}
+	m.raceWrite()
+	b := m.Buffers[0]
+	if len(m.Buffers) > 1 {
+		b = make([]byte, buffersLen(m.Buffers))
+	}
+	var (
+		n, oobn, recvflags int
+		from               net.Addr
+		err                error
+	)
+	switch cc := c.conn.(type) {
+	case udpMsgConn:
+		var addr *net.UDPAddr
+		n, oobn, recvflags, addr, err = cc.ReadMsgUDP(b, m.OOB)
+		if addr != nil {
+			from = addr
+		}
+	case ipMsgConn:
+		var addr *net.IPAddr
+		n, oobn, recvflags, addr, err = cc.ReadMsgIP(b, m.OOB)
+		if addr != nil {
+			from = addr
+		}
+	default:
+		return errNotImplemented
+	}
+	if err != nil {
+		return msgError("recvmsg", err)
+	}
+	if len(m.Buffers) > 1 {
+		rest := b[:n]
+		for _, buf := range m.Buffers {
+			rest = rest[copy(buf, rest):]
+		}
+	}
+	m.Addr = from
+	m.N = n
+	m.NN = oobn
+	m.Flags = recvflags
+	return nil
+}
+
+func (c *Conn) sendMsg(m *Message, flags int) error {
+	if flags != 0 {
+		return errNotImplemented
+	}
+	m.raceRead()
+	var b []byte
+	if len(m.Buffers) == 1 {
+		b = m.Buffers[0]
+	} else {
+		b = make([]byte, 0, buffersLen(m.Buffers))
+		for _, buf := range m.Buffers {
+			b = append(b, buf...)
+		}
+	}
+	var (
+		n   int
+		err error
+	)
+	switch cc := c.conn.(type) {
+	case udpMsgConn:
+		addr, _ := m.Addr.(*net.UDPAddr)
+		if m.Addr != nil && addr == nil {
+			return errInvalidAddr
+		}
+		n, _, err = cc.WriteMsgUDP(b, m.OOB, addr)
+	case ipMsgConn:
+		addr, _ := m.Addr.(*net.IPAddr)
+		if m.Addr != nil && addr == nil {
+			return errInvalidAddr
+		}
+		n, _, err = cc.WriteMsgIP(b, m.OOB, addr)
+	default:
+		return errNotImplemented
+	}
+	if err != nil {
+		return msgError("sendmsg", err)
+	}
+	m.N = n
+	m.NN = len(m.OOB)
+	return nil
+}
+
+func buffersLen(bs [][]byte) int {
+	var l int
+	for _, b := range bs {
+		l += len(b)
+	}
+	return l
+}
+
+// msgError returns the error of a failed ReadMsg or WriteMsg call as
+// a system call error, as returned by the recvmsg and sendmsg system
+// calls on other platforms.
+func msgError(call string, err error) error {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if _, ok := err.(*os.SyscallError); ok {
+		return err
+	}
+	return os.NewSyscallError(call, err)
+}
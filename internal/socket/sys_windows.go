@@ -5,7 +5,6 @@
 package socket
 
 import (
-	"net"
 	"syscall"
 	"unsafe"
 
@@ -38,14 +37,6 @@ func setsockopt(s uintptr, level, name int, b []byte) error {
 	return syscall.Setsockopt(syscall.Handle(s), int32(level), int32(name), (*byte)(unsafe.Pointer(&b[0])), int32(len(b)))
 }
 
-func recvmsg(s uintptr, buffers [][]byte, oob []byte, flags int, network string) (n, oobn int, recvflags int, from net.Addr, err error) {
-	return 0, 0, 0, nil, errNotImplemented
-}
-
-func sendmsg(s uintptr, buffers [][]byte, oob []byte, to net.Addr, flags int) (int, error) {
-	return 0, errNotImplemented
-}
-
 func recvmmsg(s uintptr, hs []mmsghdr, flags int) (int, error) {
 	return 0, errNotImplemented
 }
@@ -7,9 +7,13 @@ package socks
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,7 +22,7 @@ var (
 	aLongTimeAgo = time.Unix(1, 0)
 )
 
-func (d *Dialer) connect(ctx context.Context, c net.Conn, address string) (_ net.Addr, ctxErr error) {
+func (d *Dialer) connect(ctx context.Context, c net.Conn, address string, trace *DialTrace) (_ net.Addr, ctxErr error) {
 	host, port, err := splitHostPort(address)
 	if err != nil {
 		return nil, err
@@ -49,17 +53,16 @@ func (d *Dialer) connect(ctx context.Context, c net.Conn, address string) (_ net
 
 	b := make([]byte, 0, 6+len(host)) // the size here is just an estimate
 	b = append(b, Version5)
-	if len(d.AuthMethods) == 0 || d.Authenticate == nil {
-		b = append(b, 1, byte(AuthMethodNotRequired))
-	} else {
-		ams := d.AuthMethods
+	ams := []AuthMethod{AuthMethodNotRequired}
+	if len(d.AuthMethods) != 0 && d.Authenticate != nil {
+		ams = d.AuthMethods
 		if len(ams) > 255 {
 			return nil, errors.New("too many authentication methods")
 		}
-		b = append(b, byte(len(ams)))
-		for _, am := range ams {
-			b = append(b, byte(am))
-		}
+	}
+	b = append(b, byte(len(ams)))
+	for _, am := range ams {
+		b = append(b, byte(am))
 	}
 	if _, ctxErr = c.Write(b); ctxErr != nil {
 		return
@@ -73,12 +76,33 @@ func (d *Dialer) connect(ctx context.Context, c net.Conn, address string) (_ net
 	}
 	am := AuthMethod(b[1])
 	if am == AuthMethodNoAcceptableMethods {
-		return nil, errors.New("no acceptable authentication methods")
+		names := make([]string, len(ams))
+		for i, m := range ams {
+			names[i] = m.String()
+		}
+		err := fmt.Errorf("%w (offered: %s)", ErrNoAcceptableAuthMethods, strings.Join(names, ", "))
+		if trace != nil && trace.AuthDone != nil {
+			trace.AuthDone(am, err)
+		}
+		return nil, err
+	}
+	if !offered(ams, am) {
+		err := errors.New("proxy server selected authentication method " + am.String() + ", which was not offered")
+		if trace != nil && trace.AuthDone != nil {
+			trace.AuthDone(am, err)
+		}
+		return nil, err
 	}
 	if d.Authenticate != nil {
-		if ctxErr = d.Authenticate(ctx, c, am); ctxErr != nil {
+		ctxErr = d.Authenticate(ctx, c, am)
+		if trace != nil && trace.AuthDone != nil {
+			trace.AuthDone(am, ctxErr)
+		}
+		if ctxErr != nil {
 			return
 		}
+	} else if trace != nil && trace.AuthDone != nil {
+		trace.AuthDone(am, nil)
 	}
 
 	b = b[:0]
@@ -152,6 +176,45 @@ func (d *Dialer) connect(ctx context.Context, c net.Conn, address string) (_ net
 	return &a, nil
 }
 
+func offered(ams []AuthMethod, am AuthMethod) bool {
+	for _, m := range ams {
+		if m == am {
+			return true
+		}
+	}
+	return false
+}
+
+// A tracedConn counts the bytes read and written through a
+// connection, and reports them when it is closed.
+type tracedConn struct {
+	net.Conn
+	closed func(read, written int64)
+
+	nr, nw    int64 // accessed atomically
+	closeOnce sync.Once
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.nr, int64(n))
+	return n, err
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.nw, int64(n))
+	return n, err
+}
+
+func (c *tracedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.closed(atomic.LoadInt64(&c.nr), atomic.LoadInt64(&c.nw))
+	})
+	return err
+}
+
 func splitHostPort(address string) (string, int, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
@@ -34,6 +34,19 @@ func (cmd Command) String() string {
 // An AuthMethod represents a SOCKS authentication method.
 type AuthMethod int
 
+func (am AuthMethod) String() string {
+	switch am {
+	case AuthMethodNotRequired:
+		return "no authentication required"
+	case AuthMethodUsernamePassword:
+		return "username/password"
+	case AuthMethodNoAcceptableMethods:
+		return "no acceptable methods"
+	default:
+		return "method " + strconv.Itoa(int(am))
+	}
+}
+
 // A Reply represents a SOCKS command reply code.
 type Reply int
 
@@ -80,6 +93,11 @@ const (
 	StatusSucceeded Reply = 0x00
 )
 
+// ErrNoAcceptableAuthMethods is returned, wrapped with the list of
+// methods offered, when the proxy server accepts none of the
+// authentication methods the client offered.
+var ErrNoAcceptableAuthMethods = errors.New("no acceptable authentication methods")
+
 // An Addr represents a SOCKS-specific address.
 // Either Name or IP is used exclusively.
 type Addr struct {
@@ -136,6 +154,27 @@ type Dialer struct {
 	// function. It must be non-nil when AuthMethods is not empty.
 	// It must return an error when the authentication is failed.
 	Authenticate func(context.Context, io.ReadWriter, AuthMethod) error
+
+	// Trace specifies the optional function called at the start
+	// of each DialContext call. It returns the hooks to call for
+	// the dial, or nil.
+	Trace func(ctx context.Context, network, address string) *DialTrace
+}
+
+// A DialTrace is a set of hooks called during a dial.
+// Any hook may be nil.
+type DialTrace struct {
+	// AuthDone is called with the authentication method the
+	// proxy server selected and the result of authenticating.
+	AuthDone func(AuthMethod, error)
+
+	// DialDone is called with the result of the dial.
+	DialDone func(error)
+
+	// Closed is called when the connection returned by a
+	// successful dial is first closed, with the number of bytes
+	// read and written through it.
+	Closed func(read, written int64)
 }
 
 // DialContext connects to the provided address on the provided
@@ -157,6 +196,18 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 		proxy, dst, _ := d.pathAddrs(address)
 		return nil, &net.OpError{Op: d.cmd.String(), Net: network, Source: proxy, Addr: dst, Err: errors.New("nil context")}
 	}
+	var trace *DialTrace
+	if d.Trace != nil {
+		trace = d.Trace(ctx, network, address)
+	}
+	c, err := d.dialContext(ctx, network, address, trace)
+	if trace != nil && trace.DialDone != nil {
+		trace.DialDone(err)
+	}
+	return c, err
+}
+
+func (d *Dialer) dialContext(ctx context.Context, network, address string, trace *DialTrace) (net.Conn, error) {
 	var err error
 	var c net.Conn
 	if d.ProxyDial != nil {
@@ -169,12 +220,15 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 		proxy, dst, _ := d.pathAddrs(address)
 		return nil, &net.OpError{Op: d.cmd.String(), Net: network, Source: proxy, Addr: dst, Err: err}
 	}
-	a, err := d.connect(ctx, c, address)
+	a, err := d.connect(ctx, c, address, trace)
 	if err != nil {
 		c.Close()
 		proxy, dst, _ := d.pathAddrs(address)
 		return nil, &net.OpError{Op: d.cmd.String(), Net: network, Source: proxy, Addr: dst, Err: err}
 	}
+	if trace != nil && trace.Closed != nil {
+		c = &tracedConn{Conn: c, closed: trace.Closed}
+	}
 	return &Conn{Conn: c, boundAddr: a}, nil
 }
 
@@ -193,7 +247,7 @@ func (d *Dialer) DialWithConn(ctx context.Context, c net.Conn, network, address
 		proxy, dst, _ := d.pathAddrs(address)
 		return nil, &net.OpError{Op: d.cmd.String(), Net: network, Source: proxy, Addr: dst, Err: errors.New("nil context")}
 	}
-	a, err := d.connect(ctx, c, address)
+	a, err := d.connect(ctx, c, address, nil)
 	if err != nil {
 		proxy, dst, _ := d.pathAddrs(address)
 		return nil, &net.OpError{Op: d.cmd.String(), Net: network, Source: proxy, Addr: dst, Err: err}
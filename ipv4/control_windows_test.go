@@ -0,0 +1,33 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv4
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/internal/socket"
+)
+
+func TestControlMessageWindows(t *testing.T) {
+	// Windows reports the destination address of a received packet
+	// in the same IN_PKTINFO field as the source address of a sent one.
+	b := (&ControlMessage{Src: net.IPv4(192, 0, 2, 1), IfIndex: 3}).Marshal()
+	if len(b) != socket.ControlMessageSpace(sizeofInetPktinfo) {
+		t.Fatalf("Marshal: %v bytes, want %v", len(b), socket.ControlMessageSpace(sizeofInetPktinfo))
+	}
+	b = append(b, NewControlMessage(FlagTTL)...)
+	ttl := b[len(b)-socket.ControlMessageSpace(4):]
+	marshalTTL(ttl, nil)
+	socket.NativeEndian.PutUint32(socket.ControlMessage(ttl).Data(4), 64)
+
+	var cm ControlMessage
+	if err := cm.Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	if !cm.Dst.Equal(net.IPv4(192, 0, 2, 1)) || cm.IfIndex != 3 || cm.TTL != 64 {
+		t.Errorf("Parse = %v, want dst=192.0.2.1 ifindex=3 ttl=64", &cm)
+	}
+}
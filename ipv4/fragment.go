@@ -0,0 +1,411 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidVersion     = errors.New("invalid version")
+	errInvalidTotalLen    = errors.New("invalid total length")
+	errInvalidChecksum    = errors.New("invalid header checksum")
+	errInvalidOptions     = errors.New("invalid options length")
+	errPacketTooBig       = errors.New("packet too big")
+	errMTUTooSmall        = errors.New("MTU too small")
+	errDontFragment       = errors.New("packet too big and don't fragment flag set")
+	errFragmentOverlap    = errors.New("overlapping fragments")
+	errFragmentOutOfRange = errors.New("fragment out of range")
+)
+
+// maxPacketLen is the largest total length of an IPv4 packet.
+const maxPacketLen = 0xffff
+
+// MarshalWire returns the wire format encoding of h, with a correct
+// header checksum. The h.Len and h.Checksum fields are ignored.
+//
+// Unlike Marshal, the returned slice is always in wire format,
+// suitable for packet construction on a packet socket or for
+// transmission over a link.
+func (h *Header) MarshalWire() ([]byte, error) {
+	if h == nil {
+		return nil, errNilHeader
+	}
+	if len(h.Options)%4 != 0 || len(h.Options) > 40 {
+		return nil, errInvalidOptions
+	}
+	hdrlen := HeaderLen + len(h.Options)
+	if h.TotalLen < hdrlen || h.TotalLen > maxPacketLen {
+		return nil, errInvalidTotalLen
+	}
+	b := make([]byte, hdrlen)
+	b[0] = byte(Version<<4 | hdrlen>>2)
+	b[1] = byte(h.TOS)
+	binary.BigEndian.PutUint16(b[2:4], uint16(h.TotalLen))
+	binary.BigEndian.PutUint16(b[4:6], uint16(h.ID))
+	binary.BigEndian.PutUint16(b[6:8], uint16((h.FragOff&0x1fff)|int(h.Flags<<13)))
+	b[8] = byte(h.TTL)
+	b[9] = byte(h.Protocol)
+	if ip := h.Src.To4(); ip != nil {
+		copy(b[12:16], ip)
+	}
+	if ip := h.Dst.To4(); ip != nil {
+		copy(b[16:20], ip)
+	} else {
+		return nil, errMissingAddress
+	}
+	copy(b[HeaderLen:], h.Options)
+	binary.BigEndian.PutUint16(b[10:12], checksum(b))
+	return b, nil
+}
+
+// ParseWireHeader parses b, which must be in wire format, as an IPv4
+// header. It returns an error if the header is malformed: if the
+// version is not 4, the header or total length is invalid for b, or
+// the header checksum is incorrect.
+//
+// Unlike ParseHeader, ParseWireHeader does not depend on the format
+// used by raw IP sockets on the local system.
+func ParseWireHeader(b []byte) (*Header, error) {
+	if len(b) < HeaderLen {
+		return nil, errHeaderTooShort
+	}
+	if b[0]>>4 != Version {
+		return nil, errInvalidVersion
+	}
+	hdrlen := int(b[0]&0x0f) << 2
+	if hdrlen < HeaderLen {
+		return nil, errHeaderTooShort
+	}
+	if len(b) < hdrlen {
+		return nil, errExtHeaderTooShort
+	}
+	totalLen := int(binary.BigEndian.Uint16(b[2:4]))
+	if totalLen < hdrlen || totalLen > len(b) {
+		return nil, errInvalidTotalLen
+	}
+	if checksum(b[:hdrlen]) != 0 {
+		return nil, errInvalidChecksum
+	}
+	flagsAndFragOff := int(binary.BigEndian.Uint16(b[6:8]))
+	h := &Header{
+		Version:  Version,
+		Len:      hdrlen,
+		TOS:      int(b[1]),
+		TotalLen: totalLen,
+		ID:       int(binary.BigEndian.Uint16(b[4:6])),
+		Flags:    HeaderFlags(flagsAndFragOff&0xe000) >> 13,
+		FragOff:  flagsAndFragOff & 0x1fff,
+		TTL:      int(b[8]),
+		Protocol: int(b[9]),
+		Checksum: int(binary.BigEndian.Uint16(b[10:12])),
+		Src:      net.IPv4(b[12], b[13], b[14], b[15]),
+		Dst:      net.IPv4(b[16], b[17], b[18], b[19]),
+	}
+	if hdrlen > HeaderLen {
+		h.Options = make([]byte, hdrlen-HeaderLen)
+		copy(h.Options, b[HeaderLen:hdrlen])
+	}
+	return h, nil
+}
+
+// checksum returns the Internet checksum of b, as defined in RFC 1071.
+// The checksum of a header including a correct checksum is zero.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// copiedOptions returns the options in opts which must be copied into
+// every fragment of a packet, padded to a multiple of 4 bytes.
+// See RFC 791, Section 3.2.
+func copiedOptions(opts []byte) []byte {
+	var b []byte
+	for i := 0; i < len(opts); {
+		typ := opts[i]
+		if typ == 0 { // end of option list
+			break
+		}
+		if typ == 1 { // no operation
+			i++
+			continue
+		}
+		if i+1 >= len(opts) {
+			break
+		}
+		l := int(opts[i+1])
+		if l < 2 || i+l > len(opts) {
+			break
+		}
+		if typ&0x80 != 0 {
+			b = append(b, opts[i:i+l]...)
+		}
+		i += l
+	}
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Fragment splits the packet with header h and payload into wire
+// format packets of at most mtu bytes, as described in RFC 791.
+// Each fragment has a correct header checksum; h.TotalLen, h.Len
+// and h.Checksum are ignored.
+//
+// If the packet fits in mtu, Fragment returns it unfragmented.
+// Fragment returns an error if the packet needs to be fragmented
+// and its DontFragment flag is set.
+func Fragment(h *Header, payload []byte, mtu int) ([][]byte, error) {
+	if h == nil {
+		return nil, errNilHeader
+	}
+	hdrlen := HeaderLen + len(h.Options)
+	if hdrlen+len(payload) > maxPacketLen {
+		return nil, errPacketTooBig
+	}
+	fh := *h
+	if hdrlen+len(payload) <= mtu {
+		fh.TotalLen = hdrlen + len(payload)
+		b, err := fh.MarshalWire()
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{append(b, payload...)}, nil
+	}
+	if h.Flags&DontFragment != 0 {
+		return nil, errDontFragment
+	}
+	var pkts [][]byte
+	off := h.FragOff << 3 // h may itself be a fragment
+	for len(payload) > 0 {
+		n := (mtu - HeaderLen - len(fh.Options)) &^ 7
+		if n <= 0 {
+			return nil, errMTUTooSmall
+		}
+		fh.Flags = h.Flags | MoreFragments
+		if n >= len(payload) {
+			n = len(payload)
+			fh.Flags = h.Flags // the last fragment keeps the original flag
+		}
+		fh.FragOff = off >> 3
+		fh.TotalLen = HeaderLen + len(fh.Options) + n
+		b, err := fh.MarshalWire()
+		if err != nil {
+			return nil, err
+		}
+		pkts = append(pkts, append(b, payload[:n]...))
+		payload = payload[n:]
+		off += n
+		if len(pkts) == 1 {
+			fh.Options = copiedOptions(h.Options)
+		}
+	}
+	return pkts, nil
+}
+
+// A Reassembler reassembles fragmented IPv4 packets.
+// The zero value is ready to use. A Reassembler is safe for
+// concurrent use.
+type Reassembler struct {
+	// MaxPackets limits the number of incomplete packets held at
+	// once. When a fragment of another packet arrives at the limit,
+	// the fragments of the oldest incomplete packet are discarded,
+	// so that a flood of fragments which never complete uses bounded
+	// memory. If zero, DefaultMaxReassemblyPackets is used.
+	MaxPackets int
+
+	mu      sync.Mutex
+	packets map[fragmentKey]*partialPacket
+	seq     uint64 // sequence number of the last packet added to packets
+}
+
+// DefaultMaxReassemblyPackets is the default limit on the number of
+// incomplete packets held by a Reassembler.
+const DefaultMaxReassemblyPackets = 256
+
+func (r *Reassembler) maxPackets() int {
+	if r.MaxPackets > 0 {
+		return r.MaxPackets
+	}
+	return DefaultMaxReassemblyPackets
+}
+
+// evictOldestLocked discards the fragments of the incomplete packet
+// whose first fragment was added longest ago.
+// r.mu must be held.
+func (r *Reassembler) evictOldestLocked() {
+	var oldest *partialPacket
+	var oldestKey fragmentKey
+	for key, p := range r.packets {
+		if oldest == nil || p.seq < oldest.seq {
+			oldest, oldestKey = p, key
+		}
+	}
+	delete(r.packets, oldestKey)
+}
+
+// fragmentKey identifies the fragments of a packet.
+// See RFC 791, Section 2.3.
+type fragmentKey struct {
+	src, dst [4]byte
+	protocol int
+	id       int
+}
+
+type partialPacket struct {
+	created time.Time
+	seq     uint64  // order in which packets were added to the Reassembler
+	first   *Header // header of the fragment at offset 0, or nil
+	data    []byte
+	have    []fragmentRange // sorted, non-overlapping
+	length  int             // payload length, or -1 until the last fragment arrives
+}
+
+type fragmentRange struct{ start, end int }
+
+// Add adds the wire format packet b, which may be a fragment.
+//
+// When b completes a packet, Add returns the packet's header, with
+// Flags, FragOff, TotalLen and Checksum adjusted for the reassembled
+// packet, and its payload. When b is a fragment of a packet which is
+// not yet complete, Add returns nil. A packet which is not a fragment
+// is returned as is.
+//
+// If b is malformed, overlaps fragments already added, or is the last
+// fragment of a packet whose other fragments extend past its end, Add
+// returns an error and discards the fragments of its packet.
+func (r *Reassembler) Add(b []byte) (*Header, []byte, error) {
+	h, err := ParseWireHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := b[h.Len:h.TotalLen]
+	if h.Flags&MoreFragments == 0 && h.FragOff == 0 {
+		return h, payload, nil
+	}
+	key := fragmentKey{protocol: h.Protocol, id: h.ID}
+	copy(key.src[:], h.Src.To4())
+	copy(key.dst[:], h.Dst.To4())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.packets == nil {
+		r.packets = make(map[fragmentKey]*partialPacket)
+	}
+	p := r.packets[key]
+	if p == nil {
+		if len(r.packets) >= r.maxPackets() {
+			r.evictOldestLocked()
+		}
+		r.seq++
+		p = &partialPacket{created: time.Now(), seq: r.seq, length: -1}
+		r.packets[key] = p
+	}
+	if err := p.add(h, payload); err != nil {
+		delete(r.packets, key)
+		return nil, nil, err
+	}
+	if !p.complete() {
+		return nil, nil, nil
+	}
+	delete(r.packets, key)
+	rh := *p.first
+	rh.Flags &^= MoreFragments
+	rh.FragOff = 0
+	rh.TotalLen = rh.Len + p.length
+	wb, err := rh.MarshalWire()
+	if err != nil {
+		return nil, nil, err
+	}
+	rh.Checksum = int(binary.BigEndian.Uint16(wb[10:12]))
+	return &rh, p.data[:p.length], nil
+}
+
+func (p *partialPacket) add(h *Header, payload []byte) error {
+	start := h.FragOff << 3
+	end := start + len(payload)
+	if h.Flags&MoreFragments != 0 && len(payload)%8 != 0 {
+		return errInvalidTotalLen
+	}
+	if end > maxPacketLen-HeaderLen {
+		return errFragmentOutOfRange
+	}
+	if h.Flags&MoreFragments == 0 {
+		if p.length >= 0 && p.length != end {
+			return errFragmentOutOfRange
+		}
+		if n := len(p.have); n > 0 && p.have[n-1].end > end {
+			// Fragments already added extend past the end
+			// of the packet.
+			return errFragmentOutOfRange
+		}
+		p.length = end
+	}
+	if p.length >= 0 && end > p.length {
+		return errFragmentOutOfRange
+	}
+	i := 0
+	for i < len(p.have) && p.have[i].end <= start {
+		i++
+	}
+	if i < len(p.have) && p.have[i].start < end {
+		return errFragmentOverlap
+	}
+	p.have = append(p.have, fragmentRange{})
+	copy(p.have[i+1:], p.have[i:])
+	p.have[i] = fragmentRange{start, end}
+	if len(p.data) < end {
+		p.data = append(p.data, make([]byte, end-len(p.data))...)
+	}
+	copy(p.data[start:end], payload)
+	if start == 0 {
+		p.first = h
+	}
+	return nil
+}
+
+func (p *partialPacket) complete() bool {
+	if p.length < 0 || p.first == nil {
+		return false
+	}
+	next := 0
+	for _, fr := range p.have {
+		if fr.start != next {
+			return false
+		}
+		next = fr.end
+	}
+	return next == p.length
+}
+
+// Expire discards the fragments of incomplete packets whose first
+// fragment was added more than d ago, and returns the number of packets
+// discarded. RFC 791 suggests a reassembly timeout of 15 seconds.
+func (r *Reassembler) Expire(d time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for key, p := range r.packets {
+		if time.Since(p.created) > d {
+			delete(r.packets, key)
+			n++
+		}
+	}
+	return n
+}
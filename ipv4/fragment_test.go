@@ -0,0 +1,247 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func testFragmentHeader() *Header {
+	return &Header{
+		Version:  Version,
+		TOS:      1,
+		ID:       0xcafe,
+		TTL:      64,
+		Protocol: 17,
+		Src:      net.IPv4(192, 0, 2, 1),
+		Dst:      net.IPv4(192, 0, 2, 2),
+	}
+}
+
+func TestMarshalWireAndParse(t *testing.T) {
+	h := testFragmentHeader()
+	h.Flags = DontFragment
+	h.FragOff = 0
+	h.Options = []byte{0x94, 0x04, 0x00, 0x00} // router alert
+	h.TotalLen = HeaderLen + len(h.Options) + 8
+	b, err := h.MarshalWire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := checksum(b); got != 0 {
+		t.Errorf("checksum of marshaled header = %#x, want 0", got)
+	}
+	b = append(b, make([]byte, 8)...)
+	ph, err := ParseWireHeader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ph.Len != HeaderLen+4 || ph.TotalLen != h.TotalLen || ph.ID != h.ID ||
+		ph.Flags != DontFragment || ph.TTL != h.TTL || ph.Protocol != h.Protocol ||
+		!ph.Src.Equal(h.Src) || !ph.Dst.Equal(h.Dst) || !bytes.Equal(ph.Options, h.Options) {
+		t.Errorf("ParseWireHeader = %v, want %v", ph, h)
+	}
+
+	for _, tt := range []struct {
+		name string
+		mod  func(b []byte) []byte
+		err  error
+	}{
+		{"short", func(b []byte) []byte { return b[:HeaderLen-1] }, errHeaderTooShort},
+		{"version", func(b []byte) []byte { b[0] = 0x65; return b }, errInvalidVersion},
+		{"ihl", func(b []byte) []byte { b[0] = 0x44; return b }, errHeaderTooShort},
+		{"options", func(b []byte) []byte { return b[:HeaderLen+2] }, errExtHeaderTooShort},
+		{"total length", func(b []byte) []byte { return b[:len(b)-1] }, errInvalidTotalLen},
+		{"checksum", func(b []byte) []byte { b[8]--; return b }, errInvalidChecksum},
+	} {
+		bb := tt.mod(append([]byte(nil), b...))
+		if _, err := ParseWireHeader(bb); err != tt.err {
+			t.Errorf("%v: ParseWireHeader error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	h := testFragmentHeader()
+	h.Options = []byte{
+		0x94, 0x04, 0x00, 0x00, // router alert, copied
+		0x07, 0x03, 0x04, 0x00, // record route, not copied
+	}
+	payload := make([]byte, 1000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	frags, err := Fragment(h, payload, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frags) != 4 {
+		t.Fatalf("Fragment returned %v fragments, want 4", len(frags))
+	}
+	for i, f := range frags {
+		if len(f) > 300 {
+			t.Errorf("fragment %v is %v bytes, want at most 300", i, len(f))
+		}
+		fh, err := ParseWireHeader(f)
+		if err != nil {
+			t.Fatalf("fragment %v: %v", i, err)
+		}
+		wantOpts := h.Options
+		if i > 0 {
+			wantOpts = h.Options[:4]
+		}
+		if !bytes.Equal(fh.Options, wantOpts) {
+			t.Errorf("fragment %v options = %x, want %x", i, fh.Options, wantOpts)
+		}
+		if more := fh.Flags&MoreFragments != 0; more != (i < len(frags)-1) {
+			t.Errorf("fragment %v flags = %v", i, fh.Flags)
+		}
+	}
+
+	var r Reassembler
+	// Add fragments out of order.
+	for _, i := range []int{2, 0, 3} {
+		rh, _, err := r.Add(frags[i])
+		if err != nil || rh != nil {
+			t.Fatalf("Add(fragment %v) = %v, %v; want incomplete packet", i, rh, err)
+		}
+	}
+	rh, rp, err := r.Add(frags[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rh == nil {
+		t.Fatal("Add of last missing fragment did not complete packet")
+	}
+	if !bytes.Equal(rp, payload) {
+		t.Errorf("reassembled payload differs from original")
+	}
+	if rh.Flags != 0 || rh.FragOff != 0 || rh.TotalLen != HeaderLen+len(h.Options)+len(payload) {
+		t.Errorf("reassembled header = %v", rh)
+	}
+	if len(r.packets) != 0 {
+		t.Errorf("Reassembler holds %v packets after completion, want 0", len(r.packets))
+	}
+}
+
+func TestFragmentUnfragmented(t *testing.T) {
+	h := testFragmentHeader()
+	frags, err := Fragment(h, []byte("hello"), 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frags) != 1 {
+		t.Fatalf("Fragment returned %v packets, want 1", len(frags))
+	}
+	var r Reassembler
+	rh, rp, err := r.Add(frags[0])
+	if err != nil || rh == nil || string(rp) != "hello" {
+		t.Errorf("Add(unfragmented) = %v, %q, %v; want packet with payload %q", rh, rp, err, "hello")
+	}
+}
+
+func TestFragmentErrors(t *testing.T) {
+	h := testFragmentHeader()
+	h.Flags = DontFragment
+	if _, err := Fragment(h, make([]byte, 100), 68); err != errDontFragment {
+		t.Errorf("Fragment with DontFragment: %v, want %v", err, errDontFragment)
+	}
+	h.Flags = 0
+	if _, err := Fragment(h, make([]byte, 100), 27); err != errMTUTooSmall {
+		t.Errorf("Fragment with small MTU: %v, want %v", err, errMTUTooSmall)
+	}
+	if _, err := Fragment(h, make([]byte, maxPacketLen), 1500); err != errPacketTooBig {
+		t.Errorf("Fragment of huge packet: %v, want %v", err, errPacketTooBig)
+	}
+}
+
+func TestReassemblerOverlap(t *testing.T) {
+	frags, err := Fragment(testFragmentHeader(), make([]byte, 64), 44)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Reassembler
+	if _, _, err := r.Add(frags[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Add(frags[0]); err != errFragmentOverlap {
+		t.Errorf("Add of duplicate fragment: %v, want %v", err, errFragmentOverlap)
+	}
+	if len(r.packets) != 0 {
+		t.Errorf("Reassembler holds %v packets after error, want 0", len(r.packets))
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	frags, err := Fragment(testFragmentHeader(), make([]byte, 64), 44)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Reassembler
+	if _, _, err := r.Add(frags[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.Expire(time.Hour); n != 0 {
+		t.Errorf("Expire(time.Hour) = %v, want 0", n)
+	}
+	if n := r.Expire(-1); n != 1 {
+		t.Errorf("Expire(-1) = %v, want 1", n)
+	}
+}
+
+func TestReassemblerLastFragmentBeforeData(t *testing.T) {
+	// fragment returns a fragment at byte offset off with n bytes
+	// of payload.
+	fragment := func(off, n int, more bool) []byte {
+		h := testFragmentHeader()
+		h.FragOff = off >> 3
+		if more {
+			h.Flags = MoreFragments
+		}
+		h.TotalLen = HeaderLen + n
+		b, err := h.MarshalWire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(b, make([]byte, n)...)
+	}
+	var r Reassembler
+	if _, _, err := r.Add(fragment(16, 16, true)); err != nil {
+		t.Fatal(err)
+	}
+	// The last fragment ends at 16, below the data already added.
+	if _, _, err := r.Add(fragment(8, 8, false)); err != errFragmentOutOfRange {
+		t.Errorf("Add of last fragment before data: %v, want %v", err, errFragmentOutOfRange)
+	}
+	if len(r.packets) != 0 {
+		t.Errorf("Reassembler holds %v packets after error, want 0", len(r.packets))
+	}
+}
+
+func TestReassemblerMaxPackets(t *testing.T) {
+	r := Reassembler{MaxPackets: 2}
+	for id := 1; id <= 3; id++ {
+		h := testFragmentHeader()
+		h.ID = id
+		frags, err := Fragment(h, make([]byte, 64), 44)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.Add(frags[0]); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.packets) > 2 {
+			t.Fatalf("Reassembler holds %v packets, want at most 2", len(r.packets))
+		}
+	}
+	for key := range r.packets {
+		if key.id == 1 {
+			t.Errorf("oldest packet was not discarded")
+		}
+	}
+}
@@ -14,6 +14,12 @@ import (
 const (
 	sizeofIPMreq       = 0x8
 	sizeofIPMreqSource = 0xc
+	sizeofInetPktinfo  = 0x8
+
+	// IP_HOPLIMIT, also known as IP_RECVTTL, enables the reception
+	// of the TTL of received packets, in control messages of the
+	// same type. It is missing from golang.org/x/sys/windows.
+	sysIP_HOPLIMIT = 0x15
 )
 
 type ipMreq struct {
@@ -27,9 +33,17 @@ type ipMreqSource struct {
 	Interface  [4]byte
 }
 
+type inetPktinfo struct {
+	Addr    [4]byte
+	Ifindex uint32
+}
+
 // See http://msdn.microsoft.com/en-us/library/windows/desktop/ms738586(v=vs.85).aspx
 var (
-	ctlOpts = [ctlMax]ctlOpt{}
+	ctlOpts = [ctlMax]ctlOpt{
+		ctlTTL:        {sysIP_HOPLIMIT, 4, marshalTTL, parseTTL},
+		ctlPacketInfo: {windows.IP_PKTINFO, sizeofInetPktinfo, marshalPacketInfo, parsePacketInfo},
+	}
 
 	sockOpts = map[int]*sockOpt{
 		ssoTOS:                {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_TOS, Len: 4}},
@@ -37,6 +51,8 @@ var (
 		ssoMulticastTTL:       {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_MULTICAST_TTL, Len: 4}},
 		ssoMulticastInterface: {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_MULTICAST_IF, Len: 4}},
 		ssoMulticastLoopback:  {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_MULTICAST_LOOP, Len: 4}},
+		ssoReceiveTTL:         {Option: socket.Option{Level: iana.ProtocolIP, Name: sysIP_HOPLIMIT, Len: 4}},
+		ssoPacketInfo:         {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_PKTINFO, Len: 4}},
 		ssoHeaderPrepend:      {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_HDRINCL, Len: 4}},
 		ssoJoinGroup:          {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_ADD_MEMBERSHIP, Len: sizeofIPMreq}, typ: ssoTypeIPMreq},
 		ssoLeaveGroup:         {Option: socket.Option{Level: iana.ProtocolIP, Name: windows.IP_DROP_MEMBERSHIP, Len: sizeofIPMreq}, typ: ssoTypeIPMreq},
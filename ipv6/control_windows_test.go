@@ -0,0 +1,24 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipv6
+
+import (
+	"net"
+	"testing"
+)
+
+func TestControlMessageWindows(t *testing.T) {
+	// Windows reports the destination address of a received packet
+	// in the same IN6_PKTINFO field as the source address of a sent one.
+	src := net.ParseIP("2001:db8::1")
+	b := (&ControlMessage{TrafficClass: 0x20, HopLimit: 17, Src: src, IfIndex: 3}).Marshal()
+	var cm ControlMessage
+	if err := cm.Parse(b); err != nil {
+		t.Fatal(err)
+	}
+	if cm.TrafficClass != 0x20 || cm.HopLimit != 17 || !cm.Dst.Equal(src) || cm.IfIndex != 3 {
+		t.Errorf("Parse = %v, want tclass=0x20 hoplim=17 dst=%v ifindex=3", &cm, src)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// A Drainer is a Listener which keeps track of the connections it
+// accepts, so that a server can be shut down gracefully by draining
+// them: see Drain.
+type Drainer struct {
+	net.Listener
+
+	// Notify, if non-nil, is called by Drain for each active
+	// connection, and for each connection accepted after Drain is
+	// called, to tell it to finish up. A server might use it to send an
+	// HTTP/2 GOAWAY frame or a WebSocket close frame, or to close idle
+	// connections. Notify is called from its own goroutine and must not
+	// block for long.
+	Notify func(net.Conn)
+
+	mu       sync.Mutex
+	conns    map[*drainConn]struct{}
+	draining bool
+	idle     chan struct{} // closed when draining and conns is empty
+}
+
+// NewDrainer returns a Drainer which accepts connections from l.
+func NewDrainer(l net.Listener) *Drainer {
+	return &Drainer{Listener: l}
+}
+
+// Accept waits for and returns the next connection from the underlying
+// Listener. The connection is active until it is closed. A connection
+// accepted after Drain has finished waiting is closed, and Accept
+// returns net.ErrClosed.
+func (d *Drainer) Accept() (net.Conn, error) {
+	c, err := d.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	dc := &drainConn{Conn: c, d: d}
+	d.mu.Lock()
+	if d.drainedLocked() {
+		// Accepted concurrently with a Drain which has already
+		// finished waiting. Decide under d.mu, so that Drain never
+		// returns with a connection still active.
+		d.mu.Unlock()
+		c.Close()
+		return nil, net.ErrClosed
+	}
+	if d.conns == nil {
+		d.conns = make(map[*drainConn]struct{})
+	}
+	d.conns[dc] = struct{}{}
+	draining := d.draining
+	d.mu.Unlock()
+	if draining && d.Notify != nil {
+		// Accepted concurrently with Drain, which may not have seen it.
+		go d.Notify(dc)
+	}
+	return dc, nil
+}
+
+// Active returns the number of accepted connections which have not yet
+// been closed.
+func (d *Drainer) Active() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// Drain closes the underlying Listener, calls Notify for each active
+// connection, and waits for all active connections to be closed. If ctx
+// is done first, Drain closes the remaining connections itself and
+// returns ctx.Err().
+//
+// Drain may be called more than once; each call waits for the same
+// connections.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	var notify []net.Conn
+	if !d.draining {
+		d.draining = true
+		d.idle = make(chan struct{})
+		if len(d.conns) == 0 {
+			close(d.idle)
+		}
+		if d.Notify != nil {
+			for c := range d.conns {
+				notify = append(notify, c)
+			}
+		}
+		d.mu.Unlock()
+		d.Listener.Close()
+	} else {
+		d.mu.Unlock()
+	}
+	for _, c := range notify {
+		go d.Notify(c)
+	}
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+	}
+	// Check for connections and claim them for closing in one step,
+	// so that none is closed after the last one finished on its own.
+	d.mu.Lock()
+	if len(d.conns) == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	conns := make([]*drainConn, 0, len(d.conns))
+	for c := range d.conns {
+		conns = append(conns, c)
+	}
+	d.conns = nil
+	close(d.idle)
+	d.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return ctx.Err()
+}
+
+// drainedLocked reports whether Drain has finished waiting for
+// connections.
+// d.mu must be held.
+func (d *Drainer) drainedLocked() bool {
+	if !d.draining {
+		return false
+	}
+	select {
+	case <-d.idle:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Drainer) remove(c *drainConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conns, c)
+	if d.draining && len(d.conns) == 0 && !d.drainedLocked() {
+		close(d.idle)
+	}
+}
+
+type drainConn struct {
+	net.Conn
+	d         *Drainer
+	closeOnce sync.Once
+}
+
+func (c *drainConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.d.remove(c) })
+	return err
+}
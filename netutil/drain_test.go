@@ -0,0 +1,154 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptN dials n connections to d and returns the client ends, after d
+// has accepted them all.
+func acceptN(t *testing.T, d *Drainer, n int) []net.Conn {
+	t.Helper()
+	var clients []net.Conn
+	for i := 0; i < n; i++ {
+		c, err := net.Dial("tcp", d.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		clients = append(clients, c)
+		if _, err := d.Accept(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.Active(); got != n {
+		t.Fatalf("Active() = %d, want %d", got, n)
+	}
+	return clients
+}
+
+func TestDrainerNotify(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDrainer(l)
+	d.Notify = func(c net.Conn) {
+		io.WriteString(c, "bye\n")
+		c.Close()
+	}
+	clients := acceptN(t, d, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if got := d.Active(); got != 0 {
+		t.Errorf("after Drain: Active() = %d, want 0", got)
+	}
+	for _, c := range clients {
+		b, err := io.ReadAll(c)
+		if string(b) != "bye\n" || err != nil {
+			t.Errorf("client read %q, %v; want %q", b, err, "bye\n")
+		}
+	}
+	if _, err := d.Accept(); err == nil {
+		t.Error("Accept after Drain succeeded")
+	}
+	if err := d.Drain(ctx); err != nil {
+		t.Errorf("second Drain: %v", err)
+	}
+}
+
+func TestDrainerWait(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDrainer(l)
+	acceptN(t, d, 2)
+
+	d.mu.Lock()
+	var conns []net.Conn
+	for c := range d.conns {
+		conns = append(conns, c)
+	}
+	d.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- d.Drain(context.Background()) }()
+	conns[0].Close()
+	select {
+	case err := <-done:
+		t.Fatalf("Drain returned %v with a connection still active", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	conns[1].Close()
+	if err := <-done; err != nil {
+		t.Errorf("Drain: %v", err)
+	}
+}
+
+func TestDrainerTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDrainer(l)
+	clients := acceptN(t, d, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := d.Active(); got != 0 {
+		t.Errorf("after Drain: Active() = %d, want 0", got)
+	}
+	for _, c := range clients {
+		c.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("client read: got error %v, want EOF", err)
+		}
+	}
+}
+
+// chanListener is a Listener whose Accept returns the connections sent
+// on c, even after Close, like an Accept racing with Close.
+type chanListener struct {
+	c chan net.Conn
+}
+
+func (l chanListener) Accept() (net.Conn, error) { return <-l.c, nil }
+func (l chanListener) Close() error              { return nil }
+func (l chanListener) Addr() net.Addr            { return &net.TCPAddr{} }
+
+func TestDrainerAcceptAfterDrain(t *testing.T) {
+	l := chanListener{make(chan net.Conn, 1)}
+	d := NewDrainer(l)
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	l.c <- server
+	if c, err := d.Accept(); err == nil {
+		c.Close()
+		t.Fatal("Accept after Drain returned a connection")
+	}
+	if got := d.Active(); got != 0 {
+		t.Errorf("Active() = %d, want 0", got)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client read: got error %v, want EOF", err)
+	}
+}
@@ -49,7 +49,14 @@ func (p *PerHost) DialContext(ctx context.Context, network, addr string) (c net.
 	if err != nil {
 		return nil, err
 	}
-	d := p.dialerForRequest(host)
+	bypass := p.bypasses(host)
+	if trace := ContextDialTrace(ctx); trace != nil && trace.RouteChosen != nil {
+		trace.RouteChosen(network, addr, bypass)
+	}
+	d := p.def
+	if bypass {
+		d = p.bypass
+	}
 	if x, ok := d.(ContextDialer); ok {
 		return x.DialContext(ctx, network, addr)
 	}
@@ -57,36 +64,44 @@ func (p *PerHost) DialContext(ctx context.Context, network, addr string) (c net.
 }
 
 func (p *PerHost) dialerForRequest(host string) Dialer {
+	if p.bypasses(host) {
+		return p.bypass
+	}
+	return p.def
+}
+
+// bypasses reports whether connections to host use the bypass dialer.
+func (p *PerHost) bypasses(host string) bool {
 	if ip := net.ParseIP(host); ip != nil {
 		for _, net := range p.bypassNetworks {
 			if net.Contains(ip) {
-				return p.bypass
+				return true
 			}
 		}
 		for _, bypassIP := range p.bypassIPs {
 			if bypassIP.Equal(ip) {
-				return p.bypass
+				return true
 			}
 		}
-		return p.def
+		return false
 	}
 
 	for _, zone := range p.bypassZones {
 		if strings.HasSuffix(host, zone) {
-			return p.bypass
+			return true
 		}
 		if host == zone[1:] {
 			// For a zone ".example.com", we match "example.com"
 			// too.
-			return p.bypass
+			return true
 		}
 	}
 	for _, bypassHost := range p.bypassHosts {
 		if bypassHost == host {
-			return p.bypass
+			return true
 		}
 	}
-	return p.def
+	return false
 }
 
 // AddFromString parses a string that contains comma-separated values
@@ -9,6 +9,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
@@ -113,6 +114,111 @@ func TestSOCKS5(t *testing.T) {
 	c.Close()
 }
 
+// usernamePasswordRequired returns a sockstest auth function which
+// requires username/password authentication, and sends the usernames
+// it receives on users.
+func usernamePasswordRequired(users chan<- string) func(io.ReadWriter, []byte) error {
+	return func(rw io.ReadWriter, b []byte) error {
+		req, err := sockstest.ParseAuthRequest(b)
+		if err != nil {
+			return err
+		}
+		m := socks.AuthMethodNoAcceptableMethods
+		for _, am := range req.Methods {
+			if am == socks.AuthMethodUsernamePassword {
+				m = am
+			}
+		}
+		b, _ = sockstest.MarshalAuthReply(req.Version, m)
+		if _, err := rw.Write(b); err != nil {
+			return err
+		}
+		if m == socks.AuthMethodNoAcceptableMethods {
+			return errors.New("no acceptable methods")
+		}
+		// RFC 1929: VER ULEN UNAME PLEN PASSWD
+		b = make([]byte, 2)
+		if _, err := io.ReadFull(rw, b); err != nil {
+			return err
+		}
+		b = make([]byte, int(b[1])+1)
+		if _, err := io.ReadFull(rw, b); err != nil {
+			return err
+		}
+		user := string(b[:len(b)-1])
+		if _, err := io.ReadFull(rw, make([]byte, b[len(b)-1])); err != nil {
+			return err
+		}
+		users <- user
+		_, err = rw.Write([]byte{0x01, 0x00})
+		return err
+	}
+}
+
+func TestSOCKS5WithCredentials(t *testing.T) {
+	users := make(chan string, 2)
+	ss, err := sockstest.NewServer(usernamePasswordRequired(users), sockstest.NoProxyRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	n := 0
+	creds := CredentialsFunc(func(ctx context.Context, network, address string) (*Auth, error) {
+		n++
+		return &Auth{User: fmt.Sprintf("user%v-%v", n, address), Password: "password"}, nil
+	})
+	proxy, err := SOCKS5WithCredentials("tcp", ss.Addr().String(), creds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		c, err := proxy.Dial("tcp", "fqdn.doesnotexist:5963")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if got, want := <-users, fmt.Sprintf("user%v-fqdn.doesnotexist:5963", i); got != want {
+			t.Errorf("dial %v: proxy got username %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestSOCKS5NoAcceptableAuthMethods(t *testing.T) {
+	ss, err := sockstest.NewServer(usernamePasswordRequired(nil), sockstest.NoProxyRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	creds := CredentialsFunc(func(ctx context.Context, network, address string) (*Auth, error) {
+		return nil, nil
+	})
+	proxy, err := SOCKS5WithCredentials("tcp", ss.Addr().String(), creds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proxy.Dial("tcp", "fqdn.doesnotexist:5963")
+	if !errors.Is(err, ErrNoAcceptableAuthMethods) {
+		t.Fatalf("Dial error = %v, want ErrNoAcceptableAuthMethods", err)
+	}
+	if want := "offered: no authentication required"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Dial error = %q, want it to contain %q", err, want)
+	}
+}
+
+func TestSOCKS5CredentialsError(t *testing.T) {
+	errCreds := errors.New("no credentials")
+	creds := CredentialsFunc(func(ctx context.Context, network, address string) (*Auth, error) {
+		return nil, errCreds
+	})
+	proxy, err := SOCKS5WithCredentials("tcp", "127.0.0.1:1", creds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.Dial("tcp", "fqdn.doesnotexist:5963"); err != errCreds {
+		t.Errorf("Dial error = %v, want %v", err, errCreds)
+	}
+}
+
 type funcFailDialer func(context.Context) error
 
 func (f funcFailDialer) Dial(net, addr string) (net.Conn, error) {
@@ -15,6 +15,76 @@ import (
 // address with an optional username and password.
 // See RFC 1928 and RFC 1929.
 func SOCKS5(network, address string, auth *Auth, forward Dialer) (Dialer, error) {
+	d := newSOCKS5Dialer(network, address, forward)
+	setSOCKS5Auth(d, address, auth)
+	return d, nil
+}
+
+// ErrNoAcceptableAuthMethods is the error a SOCKS5 dialer's dial fails
+// with when the proxy server accepts none of the authentication methods
+// it offered. The error returned by the dialer wraps it, and describes
+// the methods offered.
+var ErrNoAcceptableAuthMethods = socks.ErrNoAcceptableAuthMethods
+
+// A CredentialsProvider supplies the credentials a SOCKS5 dialer
+// authenticates with. See SOCKS5WithCredentials.
+type CredentialsProvider interface {
+	// Credentials returns the username and password to use for a
+	// dial to address on network. It is called for each dial,
+	// so the credentials may be rotated and may depend on the
+	// destination.
+	//
+	// If Credentials returns nil credentials, the dialer offers
+	// only the no-authentication-required method. If it returns
+	// an error, the dial fails with that error.
+	Credentials(ctx context.Context, network, address string) (*Auth, error)
+}
+
+// The CredentialsFunc type is an adapter to allow the use of ordinary
+// functions as a CredentialsProvider.
+type CredentialsFunc func(ctx context.Context, network, address string) (*Auth, error)
+
+// Credentials returns f(ctx, network, address).
+func (f CredentialsFunc) Credentials(ctx context.Context, network, address string) (*Auth, error) {
+	return f(ctx, network, address)
+}
+
+// SOCKS5WithCredentials is like SOCKS5, but calls creds for the
+// username and password of each dial rather than using fixed ones.
+func SOCKS5WithCredentials(network, address string, creds CredentialsProvider, forward Dialer) (Dialer, error) {
+	return &socks5CredentialsDialer{
+		d:       newSOCKS5Dialer(network, address, forward),
+		address: address,
+		creds:   creds,
+	}, nil
+}
+
+// A socks5CredentialsDialer is a SOCKS5 dialer which looks up its
+// credentials for each dial.
+type socks5CredentialsDialer struct {
+	d       *socks.Dialer
+	address string // of the proxy server
+	creds   CredentialsProvider
+}
+
+// Dial connects to the address addr on the given network via the proxy.
+func (c *socks5CredentialsDialer) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address addr on the given network via
+// the proxy, authenticating with credentials from the provider.
+func (c *socks5CredentialsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	auth, err := c.creds.Credentials(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d := *c.d
+	setSOCKS5Auth(&d, c.address, auth)
+	return d.DialContext(ctx, network, addr)
+}
+
+func newSOCKS5Dialer(network, address string, forward Dialer) *socks.Dialer {
 	d := socks.NewDialer(network, address)
 	if forward != nil {
 		if f, ok := forward.(ContextDialer); ok {
@@ -27,7 +97,15 @@ func SOCKS5(network, address string, auth *Auth, forward Dialer) (Dialer, error)
 			}
 		}
 	}
+	return d
+}
+
+// setSOCKS5Auth configures d, which dials the proxy server at address,
+// to authenticate with auth, if non-nil.
+func setSOCKS5Auth(d *socks.Dialer, address string, auth *Auth) {
+	user := ""
 	if auth != nil {
+		user = auth.User
 		up := socks.UsernamePassword{
 			Username: auth.User,
 			Password: auth.Password,
@@ -38,5 +116,5 @@ func SOCKS5(network, address string, auth *Auth, forward Dialer) (Dialer, error)
 		}
 		d.Authenticate = up.Authenticate
 	}
-	return d, nil
+	d.Trace = socksTrace(address, user)
 }
@@ -0,0 +1,95 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"context"
+
+	"golang.org/x/net/internal/socks"
+)
+
+// A DialTrace is a set of hooks observing connections made through
+// proxies, for example to record metrics. Any particular hook may be
+// nil. Functions may be called concurrently.
+//
+// Dialers in this package call the hooks of the DialTrace attached to
+// the context passed to DialContext with WithDialTrace. Dial does not
+// trace.
+type DialTrace struct {
+	// DialStart is called when a dialer starts to connect to
+	// address through the proxy server at proxyAddress.
+	DialStart func(network, proxyAddress, address string)
+
+	// DialDone is called when a dial started with DialStart
+	// ends. The err is nil if the dial succeeded.
+	DialDone func(network, proxyAddress, address string, err error)
+
+	// RouteChosen is called when a PerHost dialer chooses how to
+	// connect to address. The bypass is true if the connection
+	// is made with the bypass dialer rather than the proxy.
+	RouteChosen func(network, address string, bypass bool)
+
+	// AuthDone is called with the result of authenticating with
+	// the proxy server at proxyAddress. The user is the username
+	// sent to the server, or empty if the server did not require
+	// authentication.
+	AuthDone func(proxyAddress, user string, err error)
+
+	// TunnelClosed is called when a connection to address made
+	// through the proxy server at proxyAddress is closed, with the
+	// number of bytes read and written through the tunnel.
+	TunnelClosed func(proxyAddress, address string, bytesRead, bytesWritten int64)
+}
+
+type dialTraceKey struct{}
+
+// WithDialTrace returns a new context based on ctx which makes dials
+// using it call the hooks of trace.
+func WithDialTrace(ctx context.Context, trace *DialTrace) context.Context {
+	return context.WithValue(ctx, dialTraceKey{}, trace)
+}
+
+// ContextDialTrace returns the DialTrace associated with ctx, or nil
+// if there is none.
+func ContextDialTrace(ctx context.Context) *DialTrace {
+	trace, _ := ctx.Value(dialTraceKey{}).(*DialTrace)
+	return trace
+}
+
+// socksTrace returns a socks.Dialer.Trace function reporting to the
+// context's DialTrace dials through the proxy server at proxyAddress.
+// The user is the username the dialer authenticates with, if any.
+func socksTrace(proxyAddress, user string) func(context.Context, string, string) *socks.DialTrace {
+	return func(ctx context.Context, network, address string) *socks.DialTrace {
+		trace := ContextDialTrace(ctx)
+		if trace == nil {
+			return nil
+		}
+		if trace.DialStart != nil {
+			trace.DialStart(network, proxyAddress, address)
+		}
+		st := &socks.DialTrace{}
+		if trace.AuthDone != nil {
+			st.AuthDone = func(am socks.AuthMethod, err error) {
+				u := ""
+				if am == socks.AuthMethodUsernamePassword {
+					u = user
+				}
+				trace.AuthDone(proxyAddress, u, err)
+			}
+		}
+		if trace.DialDone != nil {
+			st.DialDone = func(err error) {
+				trace.DialDone(network, proxyAddress, address, err)
+			}
+		}
+		if trace.TunnelClosed != nil {
+			st.Closed = func(read, written int64) {
+				trace.TunnelClosed(proxyAddress, address, read, written)
+			}
+		}
+		return st
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/internal/socks"
+	"golang.org/x/net/internal/sockstest"
+)
+
+type recordingTrace struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingTrace) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+// trace returns a DialTrace recording the events of every hook.
+func (r *recordingTrace) trace() *DialTrace {
+	return &DialTrace{
+		DialStart: func(network, proxyAddress, address string) {
+			r.record("DialStart %v %v %v", network, proxyAddress, address)
+		},
+		DialDone: func(network, proxyAddress, address string, err error) {
+			r.record("DialDone %v %v %v %v", network, proxyAddress, address, err)
+		},
+		RouteChosen: func(network, address string, bypass bool) {
+			r.record("RouteChosen %v %v %v", network, address, bypass)
+		},
+		AuthDone: func(proxyAddress, user string, err error) {
+			r.record("AuthDone %v %q %v", proxyAddress, user, err)
+		},
+		TunnelClosed: func(proxyAddress, address string, bytesRead, bytesWritten int64) {
+			r.record("TunnelClosed %v %v %v %v", proxyAddress, address, bytesRead, bytesWritten)
+		},
+	}
+}
+
+func TestSOCKS5DialTrace(t *testing.T) {
+	ss, err := sockstest.NewServer(sockstest.NoAuthRequired, func(rw io.ReadWriter, b []byte) error {
+		req, err := sockstest.ParseCmdRequest(b)
+		if err != nil {
+			return err
+		}
+		b, err = sockstest.MarshalCmdReply(socks.Version5, socks.StatusSucceeded, &req.Addr)
+		if err != nil {
+			return err
+		}
+		if _, err := rw.Write(append(b, "hello"...)); err != nil {
+			return err
+		}
+		_, err = io.ReadFull(rw, make([]byte, 3))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	proxyAddr, target := ss.Addr().String(), ss.TargetAddr().String()
+	d, err := SOCKS5("tcp", proxyAddr, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &recordingTrace{}
+	ctx := WithDialTrace(context.Background(), tr.trace())
+	c, err := d.(ContextDialer).DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c.Close()
+
+	// Hooks left nil are not called.
+	ctx = WithDialTrace(context.Background(), &DialTrace{})
+	c, err = d.(ContextDialer).DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	want := []string{
+		"DialStart tcp " + proxyAddr + " " + target,
+		"AuthDone " + proxyAddr + ` "" <nil>`,
+		"DialDone tcp " + proxyAddr + " " + target + " <nil>",
+		"TunnelClosed " + proxyAddr + " " + target + " 5 3",
+	}
+	if !reflect.DeepEqual(tr.events, want) {
+		t.Errorf("traced events:\n%q\nwant:\n%q", tr.events, want)
+	}
+}
+
+func TestPerHostDialTrace(t *testing.T) {
+	var def, bypass recordingProxy
+	perHost := NewPerHost(&def, &bypass)
+	perHost.AddHost("localhost")
+
+	tr := &recordingTrace{}
+	ctx := WithDialTrace(context.Background(), tr.trace())
+	perHost.DialContext(ctx, "tcp", "example.com:123")
+	perHost.DialContext(ctx, "tcp", "localhost:123")
+
+	want := []string{
+		"RouteChosen tcp example.com:123 false",
+		"RouteChosen tcp localhost:123 true",
+	}
+	if !reflect.DeepEqual(tr.events, want) {
+		t.Errorf("traced events:\n%q\nwant:\n%q", tr.events, want)
+	}
+}
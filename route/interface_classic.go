@@ -19,19 +19,12 @@ func (w *wireFormat) parseInterfaceMessage(_ RIBType, b []byte) (Message, error)
 	if len(b) < l {
 		return nil, errInvalidMessage
 	}
-	attrs := uint(nativeEndian.Uint32(b[4:8]))
+	m, attrs := parseInterfaceMessageHeader(b[:l])
 	if attrs&syscall.RTA_IFP == 0 {
 		return nil, nil
 	}
-	m := &InterfaceMessage{
-		Version: int(b[2]),
-		Type:    int(b[3]),
-		Addrs:   make([]Addr, syscall.RTAX_MAX),
-		Flags:   int(nativeEndian.Uint32(b[8:12])),
-		Index:   int(nativeEndian.Uint16(b[12:14])),
-		extOff:  w.extOff,
-		raw:     b[:l],
-	}
+	m.Addrs = make([]Addr, syscall.RTAX_MAX)
+	m.extOff = w.extOff
 	a, err := parseLinkAddr(b[w.bodyOff:])
 	if err != nil {
 		return nil, err
@@ -41,6 +34,23 @@ func (w *wireFormat) parseInterfaceMessage(_ RIBType, b []byte) (Message, error)
 	return m, nil
 }
 
+// interfaceMessageHeaderLen is the length of the header fields read by
+// parseInterfaceMessageHeader.
+const interfaceMessageHeaderLen = 14
+
+// parseInterfaceMessageHeader returns the message with the header
+// fields of the interface message b, and its address bitmap.
+// b must be at least interfaceMessageHeaderLen bytes long.
+func parseInterfaceMessageHeader(b []byte) (*InterfaceMessage, uint) {
+	return &InterfaceMessage{
+		Version: int(b[2]),
+		Type:    int(b[3]),
+		Flags:   int(nativeEndian.Uint32(b[8:12])),
+		Index:   int(nativeEndian.Uint16(b[12:14])),
+		raw:     b,
+	}, uint(nativeEndian.Uint32(b[4:8]))
+}
+
 func (w *wireFormat) parseInterfaceAddrMessage(_ RIBType, b []byte) (Message, error) {
 	if len(b) < w.bodyOff {
 		return nil, errMessageTooShort
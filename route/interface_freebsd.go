@@ -25,19 +25,12 @@ func (w *wireFormat) parseInterfaceMessage(typ RIBType, b []byte) (Message, erro
 	if len(b) < l {
 		return nil, errInvalidMessage
 	}
-	attrs := uint(nativeEndian.Uint32(b[4:8]))
+	m, attrs := parseInterfaceMessageHeader(b[:l])
 	if attrs&syscall.RTA_IFP == 0 {
 		return nil, nil
 	}
-	m := &InterfaceMessage{
-		Version: int(b[2]),
-		Type:    int(b[3]),
-		Flags:   int(nativeEndian.Uint32(b[8:12])),
-		Index:   int(nativeEndian.Uint16(b[12:14])),
-		Addrs:   make([]Addr, syscall.RTAX_MAX),
-		extOff:  extOff,
-		raw:     b[:l],
-	}
+	m.Addrs = make([]Addr, syscall.RTAX_MAX)
+	m.extOff = extOff
 	a, err := parseLinkAddr(b[bodyOff:])
 	if err != nil {
 		return nil, err
@@ -47,6 +40,23 @@ func (w *wireFormat) parseInterfaceMessage(typ RIBType, b []byte) (Message, erro
 	return m, nil
 }
 
+// interfaceMessageHeaderLen is the length of the header fields read by
+// parseInterfaceMessageHeader.
+const interfaceMessageHeaderLen = 14
+
+// parseInterfaceMessageHeader returns the message with the header
+// fields of the interface message b, and its address bitmap.
+// b must be at least interfaceMessageHeaderLen bytes long.
+func parseInterfaceMessageHeader(b []byte) (*InterfaceMessage, uint) {
+	return &InterfaceMessage{
+		Version: int(b[2]),
+		Type:    int(b[3]),
+		Flags:   int(nativeEndian.Uint32(b[8:12])),
+		Index:   int(nativeEndian.Uint16(b[12:14])),
+		raw:     b,
+	}, uint(nativeEndian.Uint32(b[4:8]))
+}
+
 func (w *wireFormat) parseInterfaceAddrMessage(typ RIBType, b []byte) (Message, error) {
 	var bodyOff int
 	if typ == syscall.NET_RT_IFLISTL {
@@ -14,18 +14,11 @@ func (*wireFormat) parseInterfaceMessage(_ RIBType, b []byte) (Message, error) {
 	if len(b) < l {
 		return nil, errInvalidMessage
 	}
-	attrs := uint(nativeEndian.Uint32(b[12:16]))
+	m, attrs := parseInterfaceMessageHeader(b[:l])
 	if attrs&syscall.RTA_IFP == 0 {
 		return nil, nil
 	}
-	m := &InterfaceMessage{
-		Version: int(b[2]),
-		Type:    int(b[3]),
-		Flags:   int(nativeEndian.Uint32(b[16:20])),
-		Index:   int(nativeEndian.Uint16(b[6:8])),
-		Addrs:   make([]Addr, syscall.RTAX_MAX),
-		raw:     b[:l],
-	}
+	m.Addrs = make([]Addr, syscall.RTAX_MAX)
 	ll := int(nativeEndian.Uint16(b[4:6]))
 	if len(b) < ll {
 		return nil, errInvalidMessage
@@ -39,6 +32,23 @@ func (*wireFormat) parseInterfaceMessage(_ RIBType, b []byte) (Message, error) {
 	return m, nil
 }
 
+// interfaceMessageHeaderLen is the length of the header fields read by
+// parseInterfaceMessageHeader.
+const interfaceMessageHeaderLen = 20
+
+// parseInterfaceMessageHeader returns the message with the header
+// fields of the interface message b, and its address bitmap.
+// b must be at least interfaceMessageHeaderLen bytes long.
+func parseInterfaceMessageHeader(b []byte) (*InterfaceMessage, uint) {
+	return &InterfaceMessage{
+		Version: int(b[2]),
+		Type:    int(b[3]),
+		Flags:   int(nativeEndian.Uint32(b[16:20])),
+		Index:   int(nativeEndian.Uint16(b[6:8])),
+		raw:     b,
+	}, uint(nativeEndian.Uint32(b[12:16]))
+}
+
 func (*wireFormat) parseInterfaceAddrMessage(_ RIBType, b []byte) (Message, error) {
 	if len(b) < 24 {
 		return nil, errMessageTooShort
@@ -0,0 +1,279 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package route
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// An EventType represents a type of routing event.
+type EventType int
+
+const (
+	EventRouteAdd           EventType = iota + 1 // route added
+	EventRouteDelete                             // route deleted
+	EventRouteChange                             // route changed
+	EventAddrAdd                                 // interface address added
+	EventAddrDelete                              // interface address deleted
+	EventInterfaceChange                         // interface state or flags changed
+	EventInterfaceArrival                        // interface attached
+	EventInterfaceDeparture                      // interface detached
+
+	// EventResync reports that routing messages may have been lost,
+	// because the socket's receive buffer overflowed or the socket
+	// failed and was reopened. Watchers tracking the routing state
+	// should fetch it again with FetchRIB.
+	EventResync
+)
+
+var eventTypeNames = map[EventType]string{
+	EventRouteAdd:           "route add",
+	EventRouteDelete:        "route delete",
+	EventRouteChange:        "route change",
+	EventAddrAdd:            "address add",
+	EventAddrDelete:         "address delete",
+	EventInterfaceChange:    "interface change",
+	EventInterfaceArrival:   "interface arrival",
+	EventInterfaceDeparture: "interface departure",
+	EventResync:             "resync",
+}
+
+func (typ EventType) String() string {
+	if s, ok := eventTypeNames[typ]; ok {
+		return s
+	}
+	return "unknown event"
+}
+
+// An Event represents a change of the routing state.
+type Event struct {
+	Type EventType
+
+	// Message is the routing message reporting the change: a
+	// *RouteMessage, *InterfaceAddrMessage, *InterfaceMessage or
+	// *InterfaceAnnounceMessage. It is nil for EventResync.
+	Message Message
+}
+
+// An eventSource is a source of routing messages for a Watcher.
+// A routing socket is currently the only source.
+type eventSource interface {
+	// read reads one or more routing messages into b.
+	read(b []byte) (int, error)
+	close() error
+}
+
+// A Watcher delivers events from the routing socket of the operating
+// system.
+//
+// When the socket's receive buffer overflows, or reading from it
+// fails, the Watcher opens a new socket and delivers an EventResync.
+type Watcher struct {
+	open func() (eventSource, error)
+
+	mu     sync.Mutex
+	src    eventSource
+	closed bool
+
+	// Accessed only by Next.
+	buf     []byte
+	pending []Event
+}
+
+// errWatcherClosed is returned by Watcher.Next after Close.
+var errWatcherClosed = errors.New("watcher closed")
+
+// NewWatcher returns a Watcher for routing events of the address
+// family af, such as syscall.AF_INET. Zero means all address families.
+func NewWatcher(af int) (*Watcher, error) {
+	return newWatcher(func() (eventSource, error) {
+		s, err := openRoutingSocket(af)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+func newWatcher(open func() (eventSource, error)) (*Watcher, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		open: open,
+		src:  src,
+		buf:  make([]byte, os.Getpagesize()),
+	}, nil
+}
+
+// Next blocks until the next routing event, and returns it.
+// Routing messages which do not report a change, such as replies
+// to RTM_GET requests, are skipped.
+//
+// Next must not be called concurrently. After Close, Next returns
+// an error.
+func (w *Watcher) Next() (*Event, error) {
+	for len(w.pending) == 0 {
+		w.mu.Lock()
+		src, closed := w.src, w.closed
+		w.mu.Unlock()
+		if closed {
+			return nil, errWatcherClosed
+		}
+		n, err := src.read(w.buf)
+		if err != nil {
+			if err := w.reopen(src); err != nil {
+				return nil, err
+			}
+			w.pending = append(w.pending, Event{Type: EventResync})
+			break
+		}
+		evs, err := parseEvents(w.buf[:n])
+		if err != nil {
+			// A message we cannot parse may have reported a
+			// change.
+			w.pending = append(w.pending, Event{Type: EventResync})
+			break
+		}
+		w.pending = append(w.pending, evs...)
+	}
+	ev := w.pending[0]
+	w.pending = w.pending[1:]
+	return &ev, nil
+}
+
+// reopen replaces the failed source src with a new one.
+func (w *Watcher) reopen(src eventSource) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errWatcherClosed
+	}
+	src.close()
+	nsrc, err := w.open()
+	if err != nil {
+		w.closed = true
+		return err
+	}
+	w.src = nsrc
+	return nil
+}
+
+// Close closes the Watcher, unblocking any call to Next.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.src.close()
+}
+
+// parseEvents parses the routing messages in b, as read from a
+// routing socket, and returns the events they report.
+func parseEvents(b []byte) ([]Event, error) {
+	var evs []Event
+	for len(b) > 4 {
+		l := int(nativeEndian.Uint16(b[:2]))
+		if l == 0 {
+			return nil, errInvalidMessage
+		}
+		if len(b) < l {
+			return nil, errMessageTooShort
+		}
+		mb := b[:l]
+		b = b[l:]
+		ms, err := ParseRIB(0, mb)
+		if err != nil {
+			return nil, err
+		}
+		if len(ms) == 0 && len(mb) >= interfaceMessageHeaderLen && mb[2] == rtmVersion && int(mb[3]) == syscall.RTM_IFINFO {
+			// The RTM_IFINFO messages sent on a routing socket
+			// carry no addresses, so ParseRIB skips them, but
+			// they still report a change of the interface's
+			// state or flags. Messages of another version, which
+			// ParseRIB skips too, are not parsed.
+			m, _ := parseInterfaceMessageHeader(mb)
+			evs = append(evs, Event{Type: EventInterfaceChange, Message: m})
+			continue
+		}
+		for _, m := range ms {
+			if typ := eventTypeOf(m); typ != 0 {
+				evs = append(evs, Event{Type: typ, Message: m})
+			}
+		}
+	}
+	return evs, nil
+}
+
+// IFAN_ARRIVAL and IFAN_DEPARTURE, the same on all systems sending
+// interface announcement messages.
+const (
+	ifanArrival   = 0
+	ifanDeparture = 1
+)
+
+// eventTypeOf returns the type of event reported by m, or zero if m
+// does not report a change.
+func eventTypeOf(m Message) EventType {
+	switch m := m.(type) {
+	case *RouteMessage:
+		switch m.Type {
+		case syscall.RTM_ADD:
+			return EventRouteAdd
+		case syscall.RTM_DELETE:
+			return EventRouteDelete
+		case syscall.RTM_CHANGE:
+			return EventRouteChange
+		}
+	case *InterfaceAddrMessage:
+		switch m.Type {
+		case syscall.RTM_NEWADDR:
+			return EventAddrAdd
+		case syscall.RTM_DELADDR:
+			return EventAddrDelete
+		}
+	case *InterfaceMessage:
+		return EventInterfaceChange
+	case *InterfaceAnnounceMessage:
+		switch m.What {
+		case ifanArrival:
+			return EventInterfaceArrival
+		case ifanDeparture:
+			return EventInterfaceDeparture
+		}
+	}
+	return 0
+}
+
+// A routingSocket is a routing socket, read through the runtime's
+// network poller so that Close unblocks a pending read.
+type routingSocket struct {
+	f *os.File
+}
+
+func openRoutingSocket(af int) (*routingSocket, error) {
+	s, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, af)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(s)
+	if err := syscall.SetNonblock(s, true); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	return &routingSocket{f: os.NewFile(uintptr(s), "route")}, nil
+}
+
+func (s *routingSocket) read(b []byte) (int, error) { return s.f.Read(b) }
+
+func (s *routingSocket) close() error { return s.f.Close() }
@@ -0,0 +1,232 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package route
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+type fakeRead struct {
+	b   []byte
+	err error
+}
+
+// fakeEventSource returns queued reads, and blocks once they have
+// all been read until it is closed.
+type fakeEventSource struct {
+	reads  chan fakeRead
+	closed chan struct{}
+}
+
+func newFakeEventSource(reads ...fakeRead) *fakeEventSource {
+	s := &fakeEventSource{
+		reads:  make(chan fakeRead, len(reads)),
+		closed: make(chan struct{}),
+	}
+	for _, r := range reads {
+		s.reads <- r
+	}
+	return s
+}
+
+func (s *fakeEventSource) read(b []byte) (int, error) {
+	select {
+	case r := <-s.reads:
+		return copy(b, r.b), r.err
+	case <-s.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (s *fakeEventSource) close() error {
+	close(s.closed)
+	return nil
+}
+
+func marshalRouteMessage(t *testing.T, typ int) []byte {
+	t.Helper()
+	m := &RouteMessage{
+		Type: typ,
+		Addrs: []Addr{
+			syscall.RTAX_DST: &Inet4Addr{IP: [4]byte{192, 0, 2, 0}},
+		},
+	}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// marshalInterfaceInfo returns an RTM_IFINFO message as sent on a
+// routing socket, with no addresses.
+func marshalInterfaceInfo(flags, index int) []byte {
+	var b []byte
+	if runtime.GOOS == "openbsd" {
+		b = make([]byte, 32)
+		nativeEndian.PutUint16(b[4:6], uint16(len(b)))
+		nativeEndian.PutUint16(b[6:8], uint16(index))
+		nativeEndian.PutUint32(b[16:20], uint32(flags))
+	} else {
+		b = make([]byte, wireFormats[syscall.RTM_IFINFO].bodyOff)
+		nativeEndian.PutUint32(b[8:12], uint32(flags))
+		nativeEndian.PutUint16(b[12:14], uint16(index))
+	}
+	nativeEndian.PutUint16(b[:2], uint16(len(b)))
+	b[2] = rtmVersion
+	b[3] = syscall.RTM_IFINFO
+	return b
+}
+
+func TestWatcherInterfaceInfo(t *testing.T) {
+	flags := syscall.IFF_UP | syscall.IFF_RUNNING
+	w, err := newWatcher(func() (eventSource, error) {
+		return newFakeEventSource(fakeRead{b: marshalInterfaceInfo(flags, 3)}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ev, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != EventInterfaceChange {
+		t.Fatalf("Next returned %v event, want %v", ev.Type, EventInterfaceChange)
+	}
+	m, ok := ev.Message.(*InterfaceMessage)
+	if !ok {
+		t.Fatalf("%v event has message %T, want *InterfaceMessage", ev.Type, ev.Message)
+	}
+	if m.Type != syscall.RTM_IFINFO || m.Index != 3 || m.Flags != flags {
+		t.Errorf("got message %+v, want type %v, index 3, flags %#x", m, syscall.RTM_IFINFO, flags)
+	}
+}
+
+func TestParseEventsMalformedInterfaceInfo(t *testing.T) {
+	// ParseRIB skips messages of other versions,
+	// whatever their length.
+	version := marshalInterfaceInfo(0, 1)
+	version[2] = rtmVersion + 1
+	short := append([]byte{}, version[:8]...)
+	nativeEndian.PutUint16(short[:2], uint16(len(short)))
+	tiny := []byte{3, 0, rtmVersion, 0, 0}
+	for _, b := range [][]byte{version, short, tiny} {
+		evs, err := parseEvents(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evs) != 0 {
+			t.Errorf("parseEvents(%x) = %v events, want none", b, len(evs))
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	add := marshalRouteMessage(t, syscall.RTM_ADD)
+	del := marshalRouteMessage(t, syscall.RTM_DELETE)
+	get := marshalRouteMessage(t, syscall.RTM_GET)
+	srcs := []*fakeEventSource{
+		newFakeEventSource(
+			fakeRead{b: append(append([]byte{}, add...), get...)},
+			fakeRead{err: os.NewSyscallError("read", syscall.ENOBUFS)},
+		),
+		newFakeEventSource(
+			fakeRead{b: del},
+		),
+	}
+	opens := 0
+	w, err := newWatcher(func() (eventSource, error) {
+		s := srcs[opens]
+		opens++
+		return s, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []EventType{EventRouteAdd, EventResync, EventRouteDelete} {
+		ev, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != want {
+			t.Fatalf("Next returned %v event, want %v", ev.Type, want)
+		}
+		if want != EventResync {
+			if _, ok := ev.Message.(*RouteMessage); !ok {
+				t.Errorf("%v event has message %T, want *RouteMessage", ev.Type, ev.Message)
+			}
+		}
+	}
+	if opens != 2 {
+		t.Errorf("opened %v sources, want 2", opens)
+	}
+
+	errc := make(chan error)
+	go func() {
+		_, err := w.Next()
+		errc <- err
+	}()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("Next after Close succeeded, want error")
+	}
+	if opens != 2 {
+		t.Errorf("opened %v sources after Close, want 2", opens)
+	}
+}
+
+func TestEventTypeOf(t *testing.T) {
+	for _, tt := range []struct {
+		m    Message
+		want EventType
+	}{
+		{&RouteMessage{Type: syscall.RTM_ADD}, EventRouteAdd},
+		{&RouteMessage{Type: syscall.RTM_DELETE}, EventRouteDelete},
+		{&RouteMessage{Type: syscall.RTM_CHANGE}, EventRouteChange},
+		{&RouteMessage{Type: syscall.RTM_GET}, 0},
+		{&InterfaceAddrMessage{Type: syscall.RTM_NEWADDR}, EventAddrAdd},
+		{&InterfaceAddrMessage{Type: syscall.RTM_DELADDR}, EventAddrDelete},
+		{&InterfaceMessage{Type: syscall.RTM_IFINFO}, EventInterfaceChange},
+		{&InterfaceAnnounceMessage{What: ifanArrival}, EventInterfaceArrival},
+		{&InterfaceAnnounceMessage{What: ifanDeparture}, EventInterfaceDeparture},
+		{&InterfaceMulticastAddrMessage{}, 0},
+	} {
+		if got := eventTypeOf(tt.m); got != tt.want {
+			t.Errorf("eventTypeOf(%#v) = %v, want %v", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestWatcherRoutingSocket(t *testing.T) {
+	w, err := NewWatcher(syscall.AF_UNSPEC)
+	if err != nil {
+		t.Skip(err)
+	}
+	errc := make(chan error)
+	go func() {
+		for {
+			if _, err := w.Next(); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("Next after Close succeeded, want error")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// An Authorizer determines whether a request is permitted to load the
+// pages of a Registry. Like AuthRequest, it returns two bools; the
+// first indicates whether the page may be viewed at all, and the second
+// indicates whether sensitive events will be shown.
+//
+// Authorizers may be combined with AnyOf and Insensitive.
+type Authorizer func(req *http.Request) (any, sensitive bool)
+
+// AllowLocalhost permits requests from localhost/127.0.0.1/[::1] to
+// view all events, including sensitive ones. It is the default
+// AuthRequest.
+func AllowLocalhost(req *http.Request) (any, sensitive bool) {
+	// RemoteAddr is commonly in the form "IP" or "IP:port".
+	// If it is in the form "IP:port", split off the port.
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	switch host {
+	case "localhost", "127.0.0.1", "::1":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// AllowNetworks returns an Authorizer permitting requests whose remote
+// address is in one of prefixes, such as an internal network, to view
+// the pages. Sensitive events are shown if sensitive is true.
+func AllowNetworks(sensitive bool, prefixes ...netip.Prefix) Authorizer {
+	prefixes = append([]netip.Prefix(nil), prefixes...)
+	return func(req *http.Request) (any, sens bool) {
+		ap, err := netip.ParseAddrPort(req.RemoteAddr)
+		addr := ap.Addr()
+		if err != nil {
+			if addr, err = netip.ParseAddr(req.RemoteAddr); err != nil {
+				return false, false
+			}
+		}
+		addr = addr.Unmap()
+		for _, p := range prefixes {
+			if p.Contains(addr) {
+				return true, sensitive
+			}
+		}
+		return false, false
+	}
+}
+
+// AllowTokens returns an Authorizer permitting requests bearing one of
+// the tokens in an "Authorization: Bearer" header to view the pages.
+// The tokens map each token to whether it shows sensitive events, so
+// that some tokens grant only a view of the pages without them.
+func AllowTokens(tokens map[string]bool) Authorizer {
+	type token struct {
+		b         []byte
+		sensitive bool
+	}
+	var toks []token
+	for t, sensitive := range tokens {
+		if t != "" {
+			toks = append(toks, token{[]byte(t), sensitive})
+		}
+	}
+	return func(req *http.Request) (any, sensitive bool) {
+		const prefix = "Bearer "
+		h := req.Header.Get("Authorization")
+		if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+			return false, false
+		}
+		b := []byte(h[len(prefix):])
+		// Compare with every token in constant time, so that the
+		// response time does not reveal how much of a token matched.
+		for _, t := range toks {
+			if subtle.ConstantTimeCompare(b, t.b) == 1 {
+				any = true
+				sensitive = sensitive || t.sensitive
+			}
+		}
+		return any, sensitive
+	}
+}
+
+// AnyOf returns an Authorizer permitting a request if any of auths
+// does, showing sensitive events if any of the auths permitting it
+// shows them.
+func AnyOf(auths ...Authorizer) Authorizer {
+	auths = append([]Authorizer(nil), auths...)
+	return func(req *http.Request) (any, sensitive bool) {
+		for _, auth := range auths {
+			a, s := auth(req)
+			if a {
+				any = true
+				sensitive = sensitive || s
+			}
+		}
+		return any, sensitive
+	}
+}
+
+// Insensitive returns an Authorizer permitting the requests auth
+// permits, without ever showing sensitive events.
+func Insensitive(auth Authorizer) Authorizer {
+	return func(req *http.Request) (any, sensitive bool) {
+		any, _ = auth(req)
+		return any, false
+	}
+}
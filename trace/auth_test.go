@@ -0,0 +1,57 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestAuthorizers(t *testing.T) {
+	internal := AllowNetworks(false, netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8"))
+	tokens := AllowTokens(map[string]bool{
+		"viewer": false,
+		"admin":  true,
+		"":       true, // ignored
+	})
+	for _, tc := range []struct {
+		name          string
+		auth          Authorizer
+		remoteAddr    string
+		authorization string
+		wantAny       bool
+		wantSensitive bool
+	}{
+		{"network", internal, "10.1.2.3:8080", "", true, false},
+		{"network without port", internal, "10.1.2.3", "", true, false},
+		{"network IPv6", internal, "[fd00::1]:8080", "", true, false},
+		{"network IPv4-mapped", internal, "[::ffff:10.1.2.3]:8080", "", true, false},
+		{"outside network", internal, "192.168.1.1:8080", "", false, false},
+		{"malformed address", internal, "malformed remote addr", "", false, false},
+
+		{"viewer token", tokens, "192.0.2.1:1", "Bearer viewer", true, false},
+		{"admin token", tokens, "192.0.2.1:1", "bearer admin", true, true},
+		{"wrong token", tokens, "192.0.2.1:1", "Bearer admin2", false, false},
+		{"empty token", tokens, "192.0.2.1:1", "Bearer ", false, false},
+		{"basic auth", tokens, "192.0.2.1:1", "Basic YWRtaW46", false, false},
+		{"no token", tokens, "192.0.2.1:1", "", false, false},
+
+		{"any of network", AnyOf(internal, tokens), "10.0.0.1:1", "", true, false},
+		{"any of token", AnyOf(internal, tokens), "10.0.0.1:1", "Bearer admin", true, true},
+		{"any of none", AnyOf(internal, tokens), "192.0.2.1:1", "", false, false},
+		{"insensitive", Insensitive(tokens), "192.0.2.1:1", "Bearer admin", true, false},
+		{"insensitive localhost", Insensitive(AllowLocalhost), "127.0.0.1:1", "", true, false},
+	} {
+		req := &http.Request{RemoteAddr: tc.remoteAddr, Header: http.Header{}}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		gotAny, gotSensitive := tc.auth(req)
+		if gotAny != tc.wantAny || gotSensitive != tc.wantSensitive {
+			t.Errorf("%v: got (%v, %v), want (%v, %v)", tc.name, gotAny, gotSensitive, tc.wantAny, tc.wantSensitive)
+		}
+	}
+}
@@ -43,6 +43,13 @@ var buckets = []bucket{
 //
 // Most users will use the Events handler.
 func RenderEvents(w http.ResponseWriter, req *http.Request, sensitive bool) {
+	defaultRegistry.RenderEvents(w, req, sensitive)
+}
+
+// RenderEvents renders the HTML page of the event logs of r, like the
+// package's RenderEvents function. It does not do any auth checking.
+// The request may be nil.
+func (r *Registry) RenderEvents(w http.ResponseWriter, req *http.Request, sensitive bool) {
 	now := time.Now()
 	data := &struct {
 		Families []string // family names
@@ -58,19 +65,19 @@ func RenderEvents(w http.ResponseWriter, req *http.Request, sensitive bool) {
 		Buckets: buckets,
 	}
 
-	data.Families = make([]string, 0, len(families))
-	famMu.RLock()
-	for name := range families {
+	r.famMu.RLock()
+	data.Families = make([]string, 0, len(r.families))
+	for name := range r.families {
 		data.Families = append(data.Families, name)
 	}
-	famMu.RUnlock()
+	r.famMu.RUnlock()
 	sort.Strings(data.Families)
 
 	// Count the number of eventLogs in each family for each error age.
 	data.Counts = make([][]int, len(data.Families))
 	for i, name := range data.Families {
 		// TODO(sameer): move this loop under the family lock.
-		f := getEventFamily(name)
+		f := r.getEventFamily(name)
 		data.Counts[i] = make([]int, len(data.Buckets))
 		for j, b := range data.Buckets {
 			data.Counts[i][j] = f.Count(now, b.MaxErrAge)
@@ -83,7 +90,7 @@ func RenderEvents(w http.ResponseWriter, req *http.Request, sensitive bool) {
 		if !ok {
 			// No-op
 		} else {
-			data.EventLogs = getEventFamily(data.Family).Copy(now, buckets[data.Bucket].MaxErrAge)
+			data.EventLogs = r.getEventFamily(data.Family).Copy(now, buckets[data.Bucket].MaxErrAge)
 		}
 		if data.EventLogs != nil {
 			defer data.EventLogs.Free()
@@ -94,8 +101,8 @@ func RenderEvents(w http.ResponseWriter, req *http.Request, sensitive bool) {
 		}
 	}
 
-	famMu.RLock()
-	defer famMu.RUnlock()
+	r.famMu.RLock()
+	defer r.famMu.RUnlock()
 	if err := eventsTmpl().Execute(w, data); err != nil {
 		log.Printf("net/trace: Failed executing template: %v", err)
 	}
@@ -129,37 +136,69 @@ type EventLog interface {
 
 // NewEventLog returns a new EventLog with the specified family name
 // and title.
+//
+// If the family has a Sampler, the returned EventLog may not be
+// recorded. See SetEventLogSampler.
 func NewEventLog(family, title string) EventLog {
+	return defaultRegistry.newEventLog(family, title)
+}
+
+// NewEventLog returns a new EventLog in r with the specified family
+// name and title, like the package's NewEventLog function.
+func (r *Registry) NewEventLog(family, title string) EventLog {
+	return r.newEventLog(family, title)
+}
+
+// newEventLog implements NewEventLog. It is called by the exported
+// functions so that event logs record the same call stack.
+func (r *Registry) newEventLog(family, title string) EventLog {
+	start := time.Now()
+	if fs := r.getSampler(r.eventLogSamplers, family); fs != nil && !fs.sample(start) {
+		if !fs.keeps() {
+			return noEventLog{}
+		}
+		return &unsampledEventLog{reg: r, fs: fs, family: family, title: title, start: start}
+	}
+	return r.newEventLogAt(family, title, start, 4)
+}
+
+// newEventLogAt returns a new recorded event log started at start. Its
+// stack is recorded as by runtime.Callers(skip, ...) called from
+// newEventLogAt.
+func (r *Registry) newEventLogAt(family, title string, start time.Time, skip int) *eventLog {
 	el := newEventLog()
 	el.ref()
+	el.reg = r
 	el.Family, el.Title = family, title
-	el.Start = time.Now()
+	el.Start = start
 	el.events = make([]logEntry, 0, maxEventsPerLog)
 	el.stack = make([]uintptr, 32)
-	n := runtime.Callers(2, el.stack)
+	n := runtime.Callers(skip, el.stack)
 	el.stack = el.stack[:n]
 
-	getEventFamily(family).add(el)
+	r.getEventFamily(family).add(el)
 	return el
 }
 
+// noEventLog is an EventLog which is not recorded.
+type noEventLog struct{}
+
+func (noEventLog) Printf(format string, a ...interface{}) {}
+func (noEventLog) Errorf(format string, a ...interface{}) {}
+func (noEventLog) Finish()                                {}
+
 func (el *eventLog) Finish() {
-	getEventFamily(el.Family).remove(el)
+	el.reg.getEventFamily(el.Family).remove(el)
 	el.unref() // matches ref in New
 }
 
-var (
-	famMu    sync.RWMutex
-	families = make(map[string]*eventFamily) // family name => family
-)
-
-func getEventFamily(fam string) *eventFamily {
-	famMu.Lock()
-	defer famMu.Unlock()
-	f := families[fam]
+func (r *Registry) getEventFamily(fam string) *eventFamily {
+	r.famMu.Lock()
+	defer r.famMu.Unlock()
+	f := r.families[fam]
 	if f == nil {
 		f = &eventFamily{}
-		families[fam] = f
+		r.families[fam] = f
 	}
 	return f
 }
@@ -254,6 +293,8 @@ type eventLog struct {
 	// Timing information.
 	Start time.Time
 
+	reg *Registry // the registry holding this event log
+
 	// Call stack where this event log was created.
 	stack []uintptr
 
@@ -274,6 +315,7 @@ func (el *eventLog) reset() {
 	el.Family = ""
 	el.Title = ""
 	el.Start = time.Time{}
+	el.reg = nil
 	el.stack = nil
 	el.events = nil
 	el.LastErrorTime = time.Time{}
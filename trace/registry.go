@@ -0,0 +1,104 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+	"sync"
+)
+
+// A Registry holds traces and event logs, and the samplers of their
+// families, separately from those of other Registries.
+//
+// The package's functions, such as New and NewEventLog, use a default
+// Registry, which is served at /debug/requests and /debug/events.
+// Programs serving several tenants may create a Registry for each, and
+// serve each one's pages with its own Authorizer using TracesHandler
+// and EventsHandler.
+type Registry struct {
+	// The active traces.
+	activeMu     sync.RWMutex
+	activeTraces map[string]*traceSet // family -> traces
+
+	// Families of completed traces.
+	completedMu     sync.RWMutex
+	completedTraces map[string]*family // family -> traces
+
+	// Families of event logs.
+	famMu    sync.RWMutex
+	families map[string]*eventFamily // family name => family
+
+	samplerMu        sync.RWMutex
+	traceSamplers    map[string]*familySampler // family -> sampler
+	eventLogSamplers map[string]*familySampler // family -> sampler
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		activeTraces:     make(map[string]*traceSet),
+		completedTraces:  make(map[string]*family),
+		families:         make(map[string]*eventFamily),
+		traceSamplers:    make(map[string]*familySampler),
+		eventLogSamplers: make(map[string]*familySampler),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry returns the Registry used by the package's functions.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// TracesHandler returns a handler serving the traces of r, like the
+// Traces function. It performs authorization by running auth, or
+// AuthRequest if auth is nil.
+func (r *Registry) TracesHandler(auth Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serveTraces(w, req, auth)
+	})
+}
+
+// EventsHandler returns a handler serving the event logs of r, like
+// the Events function. It performs authorization by running auth, or
+// AuthRequest if auth is nil.
+func (r *Registry) EventsHandler(auth Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serveEvents(w, req, auth)
+	})
+}
+
+// authorize runs auth, or AuthRequest if auth is nil, and responds
+// with an error if the request is not allowed.
+func authorize(w http.ResponseWriter, req *http.Request, auth Authorizer) (ok, sensitive bool) {
+	if auth == nil {
+		auth = AuthRequest
+	}
+	ok, sensitive = auth(req)
+	if !ok {
+		http.Error(w, "not allowed", http.StatusUnauthorized)
+		return false, false
+	}
+	return true, sensitive
+}
+
+func (r *Registry) serveTraces(w http.ResponseWriter, req *http.Request, auth Authorizer) {
+	ok, sensitive := authorize(w, req, auth)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	r.Render(w, req, sensitive)
+}
+
+func (r *Registry) serveEvents(w http.ResponseWriter, req *http.Request, auth Authorizer) {
+	ok, sensitive := authorize(w, req, auth)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	r.RenderEvents(w, req, sensitive)
+}
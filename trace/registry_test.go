@@ -0,0 +1,86 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryIsolation(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	// Finishing a trace adds its family to the page, which New only
+	// does asynchronously.
+	r1.New("RegistryIsolation.Trace", "finished").Finish()
+	tr := r1.New("RegistryIsolation.Trace", "title")
+	tr.LazyPrintf("secret %d", 1)
+	tr.LazyLog(stringer("sensitive value"), true)
+	el := r1.NewEventLog("RegistryIsolation.EventLog", "title")
+	el.Printf("event")
+	defer el.Finish()
+
+	get := func(h http.Handler, url string) (int, string) {
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+	allowAll := func(*http.Request) (any, sensitive bool) { return true, true }
+	for _, tc := range []struct {
+		name  string
+		h     http.Handler
+		fam   string
+		found bool
+	}{
+		{"traces", r1.TracesHandler(allowAll), "RegistryIsolation.Trace", true},
+		{"other traces", r2.TracesHandler(allowAll), "RegistryIsolation.Trace", false},
+		{"default traces", http.HandlerFunc(Traces), "RegistryIsolation.Trace", false},
+		{"events", r1.EventsHandler(allowAll), "RegistryIsolation.EventLog", true},
+		{"other events", r2.EventsHandler(allowAll), "RegistryIsolation.EventLog", false},
+	} {
+		code, body := get(tc.h, "/")
+		if code != http.StatusOK {
+			t.Errorf("%v: status %v, want %v", tc.name, code, http.StatusOK)
+		}
+		if got := strings.Contains(body, tc.fam); got != tc.found {
+			t.Errorf("%v: page contains %v = %v, want %v", tc.name, tc.fam, got, tc.found)
+		}
+	}
+
+	// Active traces are shown with their events, and the sensitive
+	// ones only to authorizers allowing them.
+	url := "/?fam=RegistryIsolation.Trace&b=-1&exp=1"
+	for _, tc := range []struct {
+		auth          Authorizer
+		wantSensitive bool
+	}{
+		{allowAll, true},
+		{Insensitive(allowAll), false},
+	} {
+		code, body := get(r1.TracesHandler(tc.auth), url)
+		if code != http.StatusOK || !strings.Contains(body, "secret 1") {
+			t.Errorf("active traces page: status %v, want %v and the trace's events:\n%s", code, http.StatusOK, body)
+		}
+		if got := strings.Contains(body, "sensitive value"); got != tc.wantSensitive {
+			t.Errorf("active traces page shows sensitive event = %v, want %v", got, tc.wantSensitive)
+		}
+	}
+	tr.Finish()
+
+	deny := func(*http.Request) (any, sensitive bool) { return false, false }
+	if code, _ := get(r1.TracesHandler(deny), "/"); code != http.StatusUnauthorized {
+		t.Errorf("denied traces request: status %v, want %v", code, http.StatusUnauthorized)
+	}
+	if code, _ := get(r1.EventsHandler(deny), "/"); code != http.StatusUnauthorized {
+		t.Errorf("denied events request: status %v, want %v", code, http.StatusUnauthorized)
+	}
+}
+
+type stringer string
+
+func (s stringer) String() string { return string(s) }
@@ -0,0 +1,192 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A Sampler controls which of the traces or event logs of a family are
+// recorded, so that busy families can be traced in production at a
+// fraction of the cost.
+//
+// A trace or event log is sampled if it is one of every Every created,
+// and no more than PerSecond have been sampled in the current second.
+// A Trace which is not sampled is not recorded unless it is kept by
+// KeepErrors or LatencyThreshold; an EventLog which is not sampled is
+// not recorded until it is kept by one of them.
+type Sampler struct {
+	// Every is the sampling interval: one in every Every traces or
+	// event logs is sampled. Zero or one samples all of them.
+	Every int
+
+	// PerSecond, if positive, limits the number sampled per second.
+	PerSecond int
+
+	// KeepErrors keeps traces which call SetError, and event logs from
+	// their first call to Errorf, even if they are not sampled.
+	KeepErrors bool
+
+	// LatencyThreshold, if positive, keeps traces which take at least
+	// this long to finish, and event logs from their first event at
+	// least this long after they were created, even if they are not
+	// sampled.
+	LatencyThreshold time.Duration
+}
+
+// familySampler is the sampling state of one family.
+type familySampler struct {
+	s Sampler
+
+	mu     sync.Mutex
+	n      int   // traces or event logs created, mod s.Every
+	second int64 // the Unix time of the current second
+	count  int   // sampled in the current second
+}
+
+// sample reports whether a trace or event log created at now is sampled.
+func (fs *familySampler) sample(now time.Time) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.s.Every > 1 {
+		n := fs.n
+		fs.n = (fs.n + 1) % fs.s.Every
+		if n != 0 {
+			return false
+		}
+	}
+	if fs.s.PerSecond > 0 {
+		if sec := now.Unix(); sec != fs.second {
+			fs.second, fs.count = sec, 0
+		}
+		if fs.count >= fs.s.PerSecond {
+			return false
+		}
+		fs.count++
+	}
+	return true
+}
+
+// keeps reports whether the sampler may keep traces or event logs which
+// are not sampled.
+func (fs *familySampler) keeps() bool {
+	return fs.s.KeepErrors || fs.s.LatencyThreshold > 0
+}
+
+// SetSampler sets the sampler for the traces of the given family, which
+// applies to traces created by later calls to New. A nil s removes the
+// family's sampler, so that all of its traces are recorded.
+func SetSampler(family string, s *Sampler) {
+	defaultRegistry.SetSampler(family, s)
+}
+
+// SetEventLogSampler sets the sampler for the event logs of the given
+// family, which applies to event logs created by later calls to
+// NewEventLog. A nil s removes the family's sampler, so that all of its
+// event logs are recorded.
+func SetEventLogSampler(family string, s *Sampler) {
+	defaultRegistry.SetEventLogSampler(family, s)
+}
+
+// SetSampler sets the sampler for the traces of the given family in r,
+// like the package's SetSampler function.
+func (r *Registry) SetSampler(family string, s *Sampler) {
+	r.setSampler(r.traceSamplers, family, s)
+}
+
+// SetEventLogSampler sets the sampler for the event logs of the given
+// family in r, like the package's SetEventLogSampler function.
+func (r *Registry) SetEventLogSampler(family string, s *Sampler) {
+	r.setSampler(r.eventLogSamplers, family, s)
+}
+
+func (r *Registry) setSampler(m map[string]*familySampler, family string, s *Sampler) {
+	r.samplerMu.Lock()
+	defer r.samplerMu.Unlock()
+	if s == nil {
+		delete(m, family)
+		return
+	}
+	m[family] = &familySampler{s: *s}
+}
+
+func (r *Registry) getSampler(m map[string]*familySampler, family string) *familySampler {
+	r.samplerMu.RLock()
+	defer r.samplerMu.RUnlock()
+	return m[family]
+}
+
+// unsampledTrace is a Trace which is not recorded. It only contributes
+// its elapsed time to its family's latency distribution.
+type unsampledTrace struct {
+	reg    *Registry
+	family string
+	start  time.Time
+}
+
+func (tr *unsampledTrace) LazyLog(x fmt.Stringer, sensitive bool)     {}
+func (tr *unsampledTrace) LazyPrintf(format string, a ...interface{}) {}
+func (tr *unsampledTrace) SetError()                                  {}
+func (tr *unsampledTrace) SetRecycler(f func(interface{}))            {}
+func (tr *unsampledTrace) SetTraceInfo(traceID, spanID uint64)        {}
+func (tr *unsampledTrace) SetMaxEvents(m int)                         {}
+
+func (tr *unsampledTrace) Finish() {
+	addLatency(tr.reg.getFamily(tr.family, true), time.Since(tr.start))
+}
+
+// unsampledEventLog is an EventLog which is not recorded until it is
+// kept by its sampler's KeepErrors or LatencyThreshold, at which point
+// it starts recording to a new event log.
+type unsampledEventLog struct {
+	reg           *Registry
+	fs            *familySampler
+	family, title string
+	start         time.Time
+
+	mu sync.Mutex
+	el *eventLog // nil until kept
+}
+
+func (l *unsampledEventLog) Printf(format string, a ...interface{}) {
+	if el := l.kept(false); el != nil {
+		el.Printf(format, a...)
+	}
+}
+
+func (l *unsampledEventLog) Errorf(format string, a ...interface{}) {
+	if el := l.kept(true); el != nil {
+		el.Errorf(format, a...)
+	}
+}
+
+func (l *unsampledEventLog) Finish() {
+	l.mu.Lock()
+	el := l.el
+	l.mu.Unlock()
+	if el != nil {
+		el.Finish()
+	}
+}
+
+// kept returns the event log to record an event to, or nil if the
+// event is not kept.
+func (l *unsampledEventLog) kept(isErr bool) *eventLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.el == nil {
+		keep := isErr && l.fs.s.KeepErrors ||
+			l.fs.s.LatencyThreshold > 0 && time.Since(l.start) >= l.fs.s.LatencyThreshold
+		if !keep {
+			return nil
+		}
+		// Record the stack of the Printf or Errorf call which
+		// caused the event log to be kept.
+		l.el = l.reg.newEventLogAt(l.family, l.title, l.start, 4)
+	}
+	return l.el
+}
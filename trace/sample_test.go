@@ -0,0 +1,138 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSamplerSample(t *testing.T) {
+	now := time.Unix(1000, 0)
+	fs := &familySampler{s: Sampler{Every: 3}}
+	var got []bool
+	for i := 0; i < 7; i++ {
+		got = append(got, fs.sample(now))
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Every: 3: sampled %v, want %v", got, want)
+		}
+	}
+
+	fs = &familySampler{s: Sampler{PerSecond: 2}}
+	for i, tc := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{now, true},
+		{now.Add(100 * time.Millisecond), true},
+		{now.Add(200 * time.Millisecond), false},
+		{now.Add(time.Second), true},
+		{now.Add(time.Second), true},
+		{now.Add(time.Second), false},
+	} {
+		if got := fs.sample(tc.now); got != tc.want {
+			t.Errorf("PerSecond: 2: call %d: sampled %v, want %v", i, got, tc.want)
+		}
+	}
+}
+
+// completed returns the number of completed traces of fam.
+func completed(fam string) int {
+	trl := defaultRegistry.getFamily(fam, true).Buckets[0].Copy(false)
+	defer trl.Free()
+	return len(trl)
+}
+
+func TestTraceSampler(t *testing.T) {
+	fam := fmt.Sprintf("TestTraceSampler-%d", time.Now().UnixNano())
+	SetSampler(fam, &Sampler{Every: 2})
+	defer SetSampler(fam, nil)
+	for i := 0; i < 4; i++ {
+		tr := New(fam, "title")
+		tr.LazyPrintf("event %d", i)
+		tr.SetError()
+		tr.Finish()
+	}
+	if got := completed(fam); got != 2 {
+		t.Errorf("Every: 2: %d traces recorded, want 2", got)
+	}
+	f := defaultRegistry.getFamily(fam, false)
+	f.LatencyMu.RLock()
+	n := f.Latency.Recent(time.Minute).(*histogram).total()
+	f.LatencyMu.RUnlock()
+	if n != 4 {
+		t.Errorf("latency distribution holds %d traces, want 4", n)
+	}
+
+	SetSampler(fam, &Sampler{Every: 1000, KeepErrors: true})
+	New(fam, "sampled").Finish()
+	New(fam, "dropped").Finish()
+	tr := New(fam, "error")
+	tr.SetError()
+	tr.Finish()
+	if got := completed(fam); got != 4 {
+		t.Errorf("KeepErrors: %d traces recorded, want 4", got)
+	}
+
+	SetSampler(fam, &Sampler{Every: 1000, LatencyThreshold: time.Nanosecond})
+	New(fam, "sampled").Finish()
+	tr = New(fam, "slow")
+	time.Sleep(time.Millisecond)
+	tr.Finish()
+	if got := completed(fam); got != 6 {
+		t.Errorf("LatencyThreshold: %d traces recorded, want 6", got)
+	}
+
+	SetSampler(fam, nil)
+	New(fam, "title").Finish()
+	if got := completed(fam); got != 7 {
+		t.Errorf("no sampler: %d traces recorded, want 7", got)
+	}
+}
+
+func TestEventLogSampler(t *testing.T) {
+	const fam = "TestEventLogSampler"
+	active := func() int {
+		return defaultRegistry.getEventFamily(fam).Count(time.Now(), 0)
+	}
+	SetEventLogSampler(fam, &Sampler{Every: 1000, KeepErrors: true})
+	defer SetEventLogSampler(fam, nil)
+
+	sampled := NewEventLog(fam, "sampled")
+	defer sampled.Finish()
+	el := NewEventLog(fam, "unsampled")
+	el.Printf("not recorded")
+	if got := active(); got != 1 {
+		t.Fatalf("%d active event logs, want 1", got)
+	}
+	el.Errorf("recorded")
+	el.Printf("recorded too")
+	if got := active(); got != 2 {
+		t.Fatalf("after Errorf: %d active event logs, want 2", got)
+	}
+	els := defaultRegistry.getEventFamily(fam).Copy(time.Now(), 0)
+	for _, l := range els {
+		if l.Title == "unsampled" && len(l.Events()) != 2 {
+			t.Errorf("kept event log has %d events, want 2", len(l.Events()))
+		}
+	}
+	els.Free()
+	el.Finish()
+	if got := active(); got != 1 {
+		t.Errorf("after Finish: %d active event logs, want 1", got)
+	}
+
+	SetEventLogSampler(fam, &Sampler{Every: 1000})
+	el = NewEventLog(fam, "unsampled")
+	el.Errorf("not recorded")
+	el.Finish()
+	if got := active(); got != 1 {
+		t.Errorf("without KeepErrors: %d active event logs, want 1", got)
+	}
+}
@@ -59,6 +59,16 @@ connections.
 The /debug/events HTTP endpoint organizes the event logs by family and
 by time since the last error.  The expanded view displays recent log
 entries and the log's call stack.
+
+Access to both endpoints is controlled by AuthRequest. Programs may
+instead keep traces and event logs in separate Registries, such as one
+for each tenant, and serve each Registry's pages with its own
+Authorizer:
+
+	reg := trace.NewRegistry()
+	auth := trace.AllowTokens(map[string]bool{"viewer-token": false})
+	mux.Handle("/tenant/requests", reg.TracesHandler(auth))
+	mux.Handle("/tenant/events", reg.EventsHandler(auth))
 */
 package trace // import "golang.org/x/net/trace"
 
@@ -69,7 +79,6 @@ import (
 	"html/template"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"runtime"
@@ -99,23 +108,13 @@ const (
 // and the second indicates whether sensitive events will be shown.
 //
 // AuthRequest may be replaced by a program to customize its authorization requirements.
+// It applies to every handler without an Authorizer of its own; to authorize
+// requests for each handler separately, use the handlers returned by
+// Registry.TracesHandler and Registry.EventsHandler.
 //
-// The default AuthRequest function returns (true, true) if and only if the request
-// comes from localhost/127.0.0.1/[::1].
-var AuthRequest = func(req *http.Request) (any, sensitive bool) {
-	// RemoteAddr is commonly in the form "IP" or "IP:port".
-	// If it is in the form "IP:port", split off the port.
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		host = req.RemoteAddr
-	}
-	switch host {
-	case "localhost", "127.0.0.1", "::1":
-		return true, true
-	default:
-		return false, false
-	}
-}
+// The default AuthRequest function is AllowLocalhost, which returns (true, true)
+// if and only if the request comes from localhost/127.0.0.1/[::1].
+var AuthRequest func(req *http.Request) (any, sensitive bool) = AllowLocalhost
 
 func init() {
 	_, pat := http.DefaultServeMux.Handler(&http.Request{URL: &url.URL{Path: debugRequestsPath}})
@@ -149,13 +148,7 @@ func FromContext(ctx context.Context) (tr Trace, ok bool) {
 //
 // It performs authorization by running AuthRequest.
 func Traces(w http.ResponseWriter, req *http.Request) {
-	any, sensitive := AuthRequest(req)
-	if !any {
-		http.Error(w, "not allowed", http.StatusUnauthorized)
-		return
-	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	Render(w, req, sensitive)
+	defaultRegistry.serveTraces(w, req, AuthRequest)
 }
 
 // Events responds with a page of events collected by EventLogs.
@@ -164,13 +157,7 @@ func Traces(w http.ResponseWriter, req *http.Request) {
 //
 // It performs authorization by running AuthRequest.
 func Events(w http.ResponseWriter, req *http.Request) {
-	any, sensitive := AuthRequest(req)
-	if !any {
-		http.Error(w, "not allowed", http.StatusUnauthorized)
-		return
-	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	RenderEvents(w, req, sensitive)
+	defaultRegistry.serveEvents(w, req, AuthRequest)
 }
 
 // Render renders the HTML page typically served at /debug/requests.
@@ -178,6 +165,13 @@ func Events(w http.ResponseWriter, req *http.Request) {
 //
 // Most users will use the Traces handler.
 func Render(w io.Writer, req *http.Request, sensitive bool) {
+	defaultRegistry.Render(w, req, sensitive)
+}
+
+// Render renders the HTML page of the traces of r, like the package's
+// Render function. It does not do any auth checking. The request may
+// be nil.
+func (r *Registry) Render(w io.Writer, req *http.Request, sensitive bool) {
 	data := &struct {
 		Families         []string
 		ActiveTraceCount map[string]int
@@ -199,7 +193,7 @@ func Render(w io.Writer, req *http.Request, sensitive bool) {
 		// and this is the total number.
 		Total int
 	}{
-		CompletedTraces: completedTraces,
+		CompletedTraces: r.completedTraces,
 	}
 
 	data.ShowSensitive = sensitive
@@ -218,22 +212,22 @@ func Render(w io.Writer, req *http.Request, sensitive bool) {
 		}
 	}
 
-	completedMu.RLock()
-	data.Families = make([]string, 0, len(completedTraces))
-	for fam := range completedTraces {
+	r.completedMu.RLock()
+	data.Families = make([]string, 0, len(r.completedTraces))
+	for fam := range r.completedTraces {
 		data.Families = append(data.Families, fam)
 	}
-	completedMu.RUnlock()
+	r.completedMu.RUnlock()
 	sort.Strings(data.Families)
 
 	// We are careful here to minimize the time spent locking activeMu,
 	// since that lock is required every time an RPC starts and finishes.
 	data.ActiveTraceCount = make(map[string]int, len(data.Families))
-	activeMu.RLock()
-	for fam, s := range activeTraces {
+	r.activeMu.RLock()
+	for fam, s := range r.activeTraces {
 		data.ActiveTraceCount[fam] = s.Len()
 	}
-	activeMu.RUnlock()
+	r.activeMu.RUnlock()
 
 	var ok bool
 	data.Family, data.Bucket, ok = parseArgs(req)
@@ -243,16 +237,16 @@ func Render(w io.Writer, req *http.Request, sensitive bool) {
 	case data.Bucket == -1:
 		data.Active = true
 		n := data.ActiveTraceCount[data.Family]
-		data.Traces = getActiveTraces(data.Family)
+		data.Traces = r.getActiveTraces(data.Family)
 		if len(data.Traces) < n {
 			data.Total = n
 		}
 	case data.Bucket < bucketsPerFamily:
-		if b := lookupBucket(data.Family, data.Bucket); b != nil {
+		if b := r.lookupBucket(data.Family, data.Bucket); b != nil {
 			data.Traces = b.Copy(data.Traced)
 		}
 	default:
-		if f := getFamily(data.Family, false); f != nil {
+		if f := r.getFamily(data.Family, false); f != nil {
 			var obs timeseries.Observable
 			f.LatencyMu.RLock()
 			switch o := data.Bucket - bucketsPerFamily; o {
@@ -278,8 +272,8 @@ func Render(w io.Writer, req *http.Request, sensitive bool) {
 		sort.Sort(data.Traces)
 	}
 
-	completedMu.RLock()
-	defer completedMu.RUnlock()
+	r.completedMu.RLock()
+	defer r.completedMu.RUnlock()
 	if err := pageTmpl().ExecuteTemplate(w, "Page", data); err != nil {
 		log.Printf("net/trace: Failed executing template: %v", err)
 	}
@@ -301,8 +295,8 @@ func parseArgs(req *http.Request) (fam string, b int, ok bool) {
 	return fam, b, true
 }
 
-func lookupBucket(fam string, b int) *traceBucket {
-	f := getFamily(fam, false)
+func (r *Registry) lookupBucket(fam string, b int) *traceBucket {
+	f := r.getFamily(fam, false)
 	if f == nil || b < 0 || b >= len(f.Buckets) {
 		return nil
 	}
@@ -358,25 +352,45 @@ func (l *lazySprintf) String() string {
 }
 
 // New returns a new Trace with the specified family and title.
+//
+// If the family has a Sampler, the returned Trace may not be recorded.
+// See SetSampler.
 func New(family, title string) Trace {
+	return defaultRegistry.New(family, title)
+}
+
+// New returns a new Trace in r with the specified family and title,
+// like the package's New function.
+func (r *Registry) New(family, title string) Trace {
+	start := time.Now()
+	fs := r.getSampler(r.traceSamplers, family)
+	sampled := fs == nil || fs.sample(start)
+	if !sampled && !fs.keeps() {
+		return &unsampledTrace{reg: r, family: family, start: start}
+	}
+
 	tr := newTrace()
 	tr.ref()
+	tr.reg = r
 	tr.Family, tr.Title = family, title
-	tr.Start = time.Now()
+	tr.Start = start
+	if !sampled {
+		tr.sampler = fs
+	}
 	tr.maxEvents = maxEventsPerTrace
 	tr.events = tr.eventsBuf[:0]
 
-	activeMu.RLock()
-	s := activeTraces[tr.Family]
-	activeMu.RUnlock()
+	r.activeMu.RLock()
+	s := r.activeTraces[tr.Family]
+	r.activeMu.RUnlock()
 	if s == nil {
-		activeMu.Lock()
-		s = activeTraces[tr.Family] // check again
+		r.activeMu.Lock()
+		s = r.activeTraces[tr.Family] // check again
 		if s == nil {
 			s = new(traceSet)
-			activeTraces[tr.Family] = s
+			r.activeTraces[tr.Family] = s
 		}
-		activeMu.Unlock()
+		r.activeMu.Unlock()
 	}
 	s.Add(tr)
 
@@ -385,11 +399,11 @@ func New(family, title string) Trace {
 	// the first trace of this family. We don't care about the return value,
 	// nor is there any need for this to run inline, so we execute it in its
 	// own goroutine, but only if the family isn't allocated yet.
-	completedMu.RLock()
-	if _, ok := completedTraces[tr.Family]; !ok {
-		go allocFamily(tr.Family)
+	r.completedMu.RLock()
+	if _, ok := r.completedTraces[tr.Family]; !ok {
+		go r.allocFamily(tr.Family)
 	}
-	completedMu.RUnlock()
+	r.completedMu.RUnlock()
 
 	return tr
 }
@@ -406,28 +420,46 @@ func (tr *trace) Finish() {
 		tr.finishStack = buf[:n]
 	}
 
-	activeMu.RLock()
-	m := activeTraces[tr.Family]
-	activeMu.RUnlock()
+	r := tr.reg
+	r.activeMu.RLock()
+	m := r.activeTraces[tr.Family]
+	r.activeMu.RUnlock()
 	m.Remove(tr)
 
-	f := getFamily(tr.Family, true)
+	f := r.getFamily(tr.Family, true)
 	tr.mu.RLock() // protects tr fields in Cond.match calls
-	for _, b := range f.Buckets {
-		if b.Cond.match(tr) {
-			b.Add(tr)
+	if tr.kept() {
+		for _, b := range f.Buckets {
+			if b.Cond.match(tr) {
+				b.Add(tr)
+			}
 		}
 	}
 	tr.mu.RUnlock()
 
-	// Add a sample of elapsed time as microseconds to the family's timeseries
+	addLatency(f, elapsed)
+
+	tr.unref() // matches ref in New
+}
+
+// kept reports whether a finished trace is kept: either it was sampled,
+// or its sampler keeps it anyway.
+// L >= tr.mu
+func (tr *trace) kept() bool {
+	s := tr.sampler
+	return s == nil ||
+		s.s.KeepErrors && tr.IsError ||
+		s.s.LatencyThreshold > 0 && tr.Elapsed >= s.s.LatencyThreshold
+}
+
+// addLatency adds a sample of elapsed time as microseconds to the
+// family's timeseries.
+func addLatency(f *family, elapsed time.Duration) {
 	h := new(histogram)
 	h.addMeasurement(elapsed.Nanoseconds() / 1e3)
 	f.LatencyMu.Lock()
 	f.Latency.Add(h)
 	f.LatencyMu.Unlock()
-
-	tr.unref() // matches ref in New
 }
 
 const (
@@ -438,16 +470,6 @@ const (
 	numHistogramBuckets = 38
 )
 
-var (
-	// The active traces.
-	activeMu     sync.RWMutex
-	activeTraces = make(map[string]*traceSet) // family -> traces
-
-	// Families of completed traces.
-	completedMu     sync.RWMutex
-	completedTraces = make(map[string]*family) // family -> traces
-)
-
 type traceSet struct {
 	mu sync.RWMutex
 	m  map[*trace]bool
@@ -529,33 +551,33 @@ func (ts *traceSet) FirstN(n int) traceList {
 	return trl
 }
 
-func getActiveTraces(fam string) traceList {
-	activeMu.RLock()
-	s := activeTraces[fam]
-	activeMu.RUnlock()
+func (r *Registry) getActiveTraces(fam string) traceList {
+	r.activeMu.RLock()
+	s := r.activeTraces[fam]
+	r.activeMu.RUnlock()
 	if s == nil {
 		return nil
 	}
 	return s.FirstN(maxActiveTraces)
 }
 
-func getFamily(fam string, allocNew bool) *family {
-	completedMu.RLock()
-	f := completedTraces[fam]
-	completedMu.RUnlock()
+func (r *Registry) getFamily(fam string, allocNew bool) *family {
+	r.completedMu.RLock()
+	f := r.completedTraces[fam]
+	r.completedMu.RUnlock()
 	if f == nil && allocNew {
-		f = allocFamily(fam)
+		f = r.allocFamily(fam)
 	}
 	return f
 }
 
-func allocFamily(fam string) *family {
-	completedMu.Lock()
-	defer completedMu.Unlock()
-	f := completedTraces[fam]
+func (r *Registry) allocFamily(fam string) *family {
+	r.completedMu.Lock()
+	defer r.completedMu.Unlock()
+	f := r.completedTraces[fam]
 	if f == nil {
 		f = newFamily()
-		completedTraces[fam] = f
+		r.completedTraces[fam] = f
 	}
 	return f
 }
@@ -721,6 +743,8 @@ type trace struct {
 	// Start time of the this trace.
 	Start time.Time
 
+	reg *Registry // the registry holding this trace
+
 	mu        sync.RWMutex
 	events    []event // Append-only sequence of events (modulo discards).
 	maxEvents int
@@ -730,6 +754,10 @@ type trace struct {
 	traceID   uint64        // Trace information if non-zero.
 	spanID    uint64
 
+	// sampler is the sampler which did not sample this trace, if any,
+	// and decides whether to keep it when it finishes.
+	sampler *familySampler
+
 	refs int32     // how many buckets this is in
 	disc discarded // scratch space to avoid allocation
 
@@ -743,6 +771,7 @@ func (tr *trace) reset() {
 	tr.Family = ""
 	tr.Title = ""
 	tr.Start = time.Time{}
+	tr.reg = nil
 
 	tr.mu.Lock()
 	tr.Elapsed = 0
@@ -754,6 +783,7 @@ func (tr *trace) reset() {
 	tr.recycler = nil
 	tr.mu.Unlock()
 
+	tr.sampler = nil
 	tr.refs = 0
 	tr.disc = 0
 	tr.finishStack = nil
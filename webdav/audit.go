@@ -0,0 +1,166 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// An AuditEvent is a structured record of a WebDAV operation, passed to
+// a Handler's Audit function once the operation's response is written.
+type AuditEvent struct {
+	// Time is when the Handler started serving the request.
+	Time time.Time
+	// Duration is how long the Handler took to serve the request.
+	Duration time.Duration
+
+	// Principal identifies who made the request. See Handler.Principal.
+	Principal string
+	// Method is the request method, such as "PROPFIND" or "MOVE".
+	Method string
+	// Path is the resource path, with the Handler's Prefix stripped. If
+	// the request's URL path does not have the Prefix, Path is the URL
+	// path unchanged.
+	Path string
+	// Destination is the destination resource path of a COPY or MOVE
+	// request, with the Handler's Prefix stripped. It is empty for other
+	// methods and when the Destination header is missing or invalid.
+	Destination string
+	// Depth is the value of the request's Depth header, such as "0",
+	// "1" or "infinity", or empty if the header is missing.
+	Depth string
+
+	// Status is the HTTP status code of the response.
+	Status int
+	// RequestBytes is the number of bytes read from the request body.
+	RequestBytes int64
+	// ResponseBytes is the number of bytes written to the response body.
+	ResponseBytes int64
+
+	// LockTokens holds the lock tokens involved in the request: those
+	// submitted in the If and Lock-Token headers, and the token of a
+	// lock created by a LOCK request.
+	LockTokens []string
+
+	// Err is the error, if any, that the operation failed with. It is the
+	// same error passed to the Handler's Logger.
+	Err error
+}
+
+// newAuditEvent returns the AuditEvent for r, to be completed by finish
+// once r has been served.
+func (h *Handler) newAuditEvent(r *http.Request, start time.Time) *AuditEvent {
+	e := &AuditEvent{
+		Time:   start,
+		Method: r.Method,
+		Depth:  r.Header.Get("Depth"),
+	}
+	if h.Principal != nil {
+		e.Principal = h.Principal(r)
+	} else if user, _, ok := r.BasicAuth(); ok {
+		e.Principal = user
+	}
+	var err error
+	if e.Path, _, err = h.stripPrefix(r.URL.Path); err != nil {
+		e.Path = r.URL.Path
+	}
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil && u.Path != "" {
+			if dst, _, err := h.stripPrefix(u.Path); err == nil {
+				e.Destination = dst
+			}
+		}
+	}
+	if ih, ok := parseIfHeader(r.Header.Get("If")); ok {
+		for _, l := range ih.lists {
+			for _, c := range l.conditions {
+				e.addLockToken(c.Token)
+			}
+		}
+	}
+	e.addLockToken(codedURL(r.Header.Get("Lock-Token")))
+	return e
+}
+
+// finish completes e with the outcome of serving its request.
+func (e *AuditEvent) finish(aw *auditResponseWriter, body *auditBody, err error) {
+	e.Duration = time.Since(e.Time)
+	e.Status = aw.status
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	e.ResponseBytes = aw.n
+	if body != nil {
+		e.RequestBytes = body.n
+	}
+	if e.Method == "LOCK" {
+		e.addLockToken(codedURL(aw.Header().Get("Lock-Token")))
+	}
+	e.Err = err
+}
+
+func (e *AuditEvent) addLockToken(token string) {
+	if token == "" {
+		return
+	}
+	for _, t := range e.LockTokens {
+		if t == token {
+			return
+		}
+	}
+	e.LockTokens = append(e.LockTokens, token)
+}
+
+// codedURL returns s with its angle brackets removed, or "" if s is not
+// a Coded-URL.
+func codedURL(s string) string {
+	if len(s) < 2 || s[0] != '<' || s[len(s)-1] != '>' {
+		return ""
+	}
+	return s[1 : len(s)-1]
+}
+
+// auditResponseWriter records the status and size of a response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// auditBody counts the bytes read from a request body.
+type auditBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
@@ -45,11 +45,25 @@ type FileSystem interface {
 	Stat(ctx context.Context, name string) (os.FileInfo, error)
 }
 
+// A FastCopier is a FileSystem that can copy a file without the Handler
+// reading and writing its contents, such as an object store which
+// supports server-side copies.
+//
+// FastCopy copies the file src, which is not a directory, to dst, which
+// does not exist, with the given permissions. It should also copy any
+// dead properties. It may return ErrNotImplemented for files it cannot
+// copy, in which case the Handler copies them itself.
+type FastCopier interface {
+	FileSystem
+	FastCopy(ctx context.Context, src, dst string, perm os.FileMode) error
+}
+
 // A File is returned by a FileSystem's OpenFile method and can be served by a
 // Handler.
 //
 // A File may optionally implement the DeadPropsHolder interface, if it can
-// load and save dead properties.
+// load and save dead properties. WithPropStore gives the Files of any
+// FileSystem dead properties held by a PropStore.
 type File interface {
 	http.File
 	io.Writer
@@ -608,7 +622,10 @@ func (f *memFile) Write(p []byte) (int, error) {
 // moveFiles moves files and/or directories from src to dst.
 //
 // See section 9.9.4 for when various HTTP status codes apply.
-func moveFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bool) (status int, err error) {
+func moveFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bool, p *copyProgress) (status int, err error) {
+	if err := ctx.Err(); err != nil {
+		return http.StatusInternalServerError, err
+	}
 	created := false
 	if _, err := fs.Stat(ctx, dst); err != nil {
 		if !os.IsNotExist(err) {
@@ -629,6 +646,7 @@ func moveFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	if err := fs.Rename(ctx, src, dst); err != nil {
 		return http.StatusForbidden, err
 	}
+	p.report(src, dst, 0)
 	if created {
 		return http.StatusCreated, nil
 	}
@@ -658,12 +676,18 @@ func copyProps(dst, src File) error {
 
 // copyFiles copies files and/or directories from src to dst.
 //
+// The copy stops early if ctx is canceled, leaving the resources already
+// copied in place. Each copied resource is reported to p, which may be nil.
+//
 // See section 9.8.5 for when various HTTP status codes apply.
-func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bool, depth int, recursion int) (status int, err error) {
+func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bool, depth int, recursion int, p *copyProgress) (status int, err error) {
 	if recursion == 1000 {
 		return http.StatusInternalServerError, errRecursionTooDeep
 	}
 	recursion++
+	if err := ctx.Err(); err != nil {
+		return http.StatusInternalServerError, err
+	}
 
 	// TODO: section 9.8.3 says that "Note that an infinite-depth COPY of /A/
 	// into /A/B/ could lead to infinite recursion if not handled correctly."
@@ -705,6 +729,7 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		if err := fs.Mkdir(ctx, dst, srcPerm); err != nil {
 			return http.StatusForbidden, err
 		}
+		p.report(src, dst, 0)
 		if depth == infiniteDepth {
 			children, err := srcFile.Readdir(-1)
 			if err != nil {
@@ -714,7 +739,7 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 				name := c.Name()
 				s := path.Join(src, name)
 				d := path.Join(dst, name)
-				cStatus, cErr := copyFiles(ctx, fs, s, d, overwrite, depth, recursion)
+				cStatus, cErr := copyFiles(ctx, fs, s, d, overwrite, depth, recursion, p)
 				if cErr != nil {
 					// TODO: MultiStatus.
 					return cStatus, cErr
@@ -722,6 +747,12 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 			}
 		}
 
+	} else if status, err := fastCopy(ctx, fs, src, dst, srcPerm); err != ErrNotImplemented {
+		if err != nil {
+			return status, err
+		}
+		p.report(src, dst, srcStat.Size())
+
 	} else {
 		dstFile, err := fs.OpenFile(ctx, dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, srcPerm)
 		if err != nil {
@@ -731,7 +762,7 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 			return http.StatusForbidden, err
 
 		}
-		_, copyErr := io.Copy(dstFile, srcFile)
+		n, copyErr := io.Copy(dstFile, ctxReader{ctx, srcFile})
 		propsErr := copyProps(dstFile, srcFile)
 		closeErr := dstFile.Close()
 		if copyErr != nil {
@@ -743,6 +774,7 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 		if closeErr != nil {
 			return http.StatusInternalServerError, closeErr
 		}
+		p.report(src, dst, n)
 	}
 
 	if created {
@@ -751,6 +783,72 @@ func copyFiles(ctx context.Context, fs FileSystem, src, dst string, overwrite bo
 	return http.StatusNoContent, nil
 }
 
+// fastCopy copies the file src to dst using fs's FastCopy method, if it
+// has one. It returns ErrNotImplemented if the file must be copied by
+// reading and writing its contents instead.
+func fastCopy(ctx context.Context, fs FileSystem, src, dst string, perm os.FileMode) (status int, err error) {
+	fc, ok := fs.(FastCopier)
+	if !ok {
+		return 0, ErrNotImplemented
+	}
+	switch err := fc.FastCopy(ctx, src, dst, perm); {
+	case err == nil:
+		return 0, nil
+	case err == ErrNotImplemented:
+		return 0, err
+	case os.IsNotExist(err):
+		return http.StatusConflict, err
+	default:
+		return http.StatusForbidden, err
+	}
+}
+
+// ctxReader is an io.Reader which stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// CopyProgress reports the progress of a COPY or MOVE request to a
+// Handler's Progress function.
+type CopyProgress struct {
+	// Src and Dst are the paths of the resource most recently copied or
+	// moved, with the Handler's Prefix stripped.
+	Src, Dst string
+	// Resources is the number of files and directories copied or moved
+	// so far, including Src. Moving a collection counts as a single
+	// resource.
+	Resources int
+	// Bytes is the number of bytes of file content copied so far.
+	Bytes int64
+}
+
+// copyProgress accumulates the progress of a COPY or MOVE request. A nil
+// *copyProgress discards progress reports.
+type copyProgress struct {
+	fn func(CopyProgress)
+	p  CopyProgress
+}
+
+func (p *copyProgress) report(src, dst string, n int64) {
+	if p == nil {
+		return
+	}
+	p.p.Src, p.p.Dst = src, dst
+	p.p.Resources++
+	p.p.Bytes += n
+	if p.fn != nil {
+		p.fn(p.p)
+	}
+}
+
 // walkFS traverses filesystem fs starting at name up to depth levels.
 //
 // Allowed values for depth are 0, 1 or infiniteDepth. For each visited node,
@@ -468,11 +468,11 @@ func testFS(t *testing.T, fs FileSystem) {
 				if parts[1] == "d=∞" {
 					depth = infiniteDepth
 				}
-				_, opErr = copyFiles(ctx, fs, parts[2], parts[3], parts[0] == "o=T", depth, 0)
+				_, opErr = copyFiles(ctx, fs, parts[2], parts[3], parts[0] == "o=T", depth, 0, nil)
 			case "mk-dir":
 				opErr = fs.Mkdir(ctx, parts[0], 0777)
 			case "move__":
-				_, opErr = moveFiles(ctx, fs, parts[1], parts[2], parts[0] == "o=T")
+				_, opErr = moveFiles(ctx, fs, parts[1], parts[2], parts[0] == "o=T", nil)
 			case "rm-all":
 				opErr = fs.RemoveAll(ctx, parts[0])
 			case "stat":
@@ -912,10 +912,10 @@ func TestCopyMoveProps(t *testing.T) {
 	if err := patch("/src", Proppatch{Props: []Property{p0, p1}}); err != nil {
 		t.Fatalf("patch /src +p0 +p1: %v", err)
 	}
-	if _, err := copyFiles(ctx, fs, "/src", "/tmp", true, infiniteDepth, 0); err != nil {
+	if _, err := copyFiles(ctx, fs, "/src", "/tmp", true, infiniteDepth, 0, nil); err != nil {
 		t.Fatalf("copyFiles /src /tmp: %v", err)
 	}
-	if _, err := moveFiles(ctx, fs, "/tmp", "/dst", true); err != nil {
+	if _, err := moveFiles(ctx, fs, "/tmp", "/dst", true, nil); err != nil {
 		t.Fatalf("moveFiles /tmp /dst: %v", err)
 	}
 	if err := patch("/src", Proppatch{Props: []Property{p0}, Remove: true}); err != nil {
@@ -956,6 +956,112 @@ func TestCopyMoveProps(t *testing.T) {
 	}
 }
 
+// fastCopyFS is a FileSystem with a FastCopy method, which copies files
+// whose names start with "fast" and reports ErrNotImplemented for others.
+type fastCopyFS struct {
+	FileSystem
+	copied []string
+}
+
+func (fs *fastCopyFS) FastCopy(ctx context.Context, src, dst string, perm os.FileMode) error {
+	if !strings.HasPrefix(path.Base(src), "fast") {
+		return ErrNotImplemented
+	}
+	fs.copied = append(fs.copied, src)
+	f, err := fs.OpenFile(ctx, dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("fast"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func TestCopyProgress(t *testing.T) {
+	ctx := context.Background()
+	fs := &fastCopyFS{FileSystem: NewMemFS()}
+	for _, dir := range []string{"/src", "/src/sub"} {
+		if err := fs.Mkdir(ctx, dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{
+		"/src/a":        "hello",
+		"/src/fastb":    "ignored",
+		"/src/sub/c":    "world!",
+		"/src/sub/fast": "ignored",
+	} {
+		f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(data))
+		f.Close()
+	}
+
+	var reports []CopyProgress
+	p := &copyProgress{fn: func(p CopyProgress) { reports = append(reports, p) }}
+	if _, err := copyFiles(ctx, fs, "/src", "/dst", false, infiniteDepth, 0, p); err != nil {
+		t.Fatalf("copyFiles: %v", err)
+	}
+	if got, want := len(reports), 6; got != want {
+		t.Fatalf("got %d progress reports, want %d: %+v", got, want, reports)
+	}
+	last := reports[len(reports)-1]
+	if last.Resources != 6 || last.Bytes != int64(len("hello")+len("world!"))+2*int64(len("ignored")) {
+		t.Errorf("last progress report = %+v", last)
+	}
+	sort.Strings(fs.copied)
+	if want := []string{"/src/fastb", "/src/sub/fast"}; !reflect.DeepEqual(fs.copied, want) {
+		t.Errorf("FastCopy copied %q, want %q", fs.copied, want)
+	}
+	for name, want := range map[string]string{
+		"/dst/a":        "hello",
+		"/dst/fastb":    "fast",
+		"/dst/sub/c":    "world!",
+		"/dst/sub/fast": "fast",
+	} {
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			t.Errorf("open %s: %v", name, err)
+			continue
+		}
+		b, _ := io.ReadAll(f)
+		f.Close()
+		if string(b) != want {
+			t.Errorf("%s holds %q, want %q", name, b, want)
+		}
+	}
+
+	reports = nil
+	if _, err := moveFiles(ctx, fs, "/dst", "/moved", false, p); err != nil {
+		t.Fatalf("moveFiles: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Src != "/dst" || reports[0].Dst != "/moved" {
+		t.Errorf("moveFiles progress reports = %+v", reports)
+	}
+
+	// Cancel the copy once the first resource has been copied.
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	reports = nil
+	p = &copyProgress{fn: func(p CopyProgress) {
+		reports = append(reports, p)
+		cancel()
+	}}
+	if _, err := copyFiles(cctx, fs, "/src", "/canceled", false, infiniteDepth, 0, p); err != context.Canceled {
+		t.Errorf("canceled copyFiles: got error %v, want %v", err, context.Canceled)
+	}
+	if len(reports) != 1 {
+		t.Errorf("canceled copyFiles: got %d progress reports, want 1", len(reports))
+	}
+	if _, err := moveFiles(cctx, fs, "/src", "/canceled2", false, nil); err != context.Canceled {
+		t.Errorf("canceled moveFiles: got error %v, want %v", err, context.Canceled)
+	}
+}
+
 func TestWalkFS(t *testing.T) {
 	testCases := []struct {
 		desc    string
@@ -0,0 +1,352 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A PropStore stores the dead properties of the resources of a
+// FileSystem, for FileSystems whose Files do not implement
+// DeadPropsHolder. See WithPropStore.
+//
+// Resources are identified by their slash-separated, cleaned names,
+// such as "/a/b". Each method must be safe for concurrent use.
+type PropStore interface {
+	// DeadProps returns a copy of the dead properties of resource name.
+	DeadProps(ctx context.Context, name string) (map[xml.Name]Property, error)
+
+	// Patch patches the dead properties of resource name. Its return
+	// values are constrained in the same manner as DeadPropsHolder.Patch.
+	Patch(ctx context.Context, name string, patches []Proppatch) ([]Propstat, error)
+
+	// RemoveAll removes the dead properties of resource name and of all
+	// the resources it contains.
+	RemoveAll(ctx context.Context, name string) error
+
+	// Rename moves the dead properties of resource oldName and of all
+	// the resources it contains to newName, replacing any properties
+	// held for newName and the resources it contains.
+	Rename(ctx context.Context, oldName, newName string) error
+}
+
+// WithPropStore returns a FileSystem which stores the dead properties
+// of fs's resources in ps. The Files it returns implement
+// DeadPropsHolder, so that PROPPATCH requests succeed, and the
+// properties are copied, moved and removed along with the resources.
+//
+// Properties are only kept in sync with changes made through the
+// returned FileSystem. Properties of resources removed from fs by other
+// means remain in ps, and are removed when a resource of the same name
+// is created through the returned FileSystem.
+func WithPropStore(fs FileSystem, ps PropStore) FileSystem {
+	return &propStoreFS{fs: fs, ps: ps}
+}
+
+type propStoreFS struct {
+	fs FileSystem
+	ps PropStore
+}
+
+func (fs *propStoreFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.fs.Mkdir(ctx, name, perm); err != nil {
+		return err
+	}
+	return fs.ps.RemoveAll(ctx, slashClean(name))
+}
+
+func (fs *propStoreFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (File, error) {
+	created := false
+	if flag&os.O_CREATE != 0 {
+		if _, err := fs.fs.Stat(ctx, name); os.IsNotExist(err) {
+			created = true
+		}
+	}
+	f, err := fs.fs.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	name = slashClean(name)
+	if created {
+		if err := fs.ps.RemoveAll(ctx, name); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &propStoreFile{File: f, ctx: ctx, name: name, ps: fs.ps}, nil
+}
+
+func (fs *propStoreFS) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.fs.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+	return fs.ps.RemoveAll(ctx, slashClean(name))
+}
+
+func (fs *propStoreFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.fs.Rename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	return fs.ps.Rename(ctx, slashClean(oldName), slashClean(newName))
+}
+
+func (fs *propStoreFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fs.fs.Stat(ctx, name)
+}
+
+// FastCopy implements FastCopier if the underlying FileSystem does, and
+// copies the dead properties of src to dst.
+func (fs *propStoreFS) FastCopy(ctx context.Context, src, dst string, perm os.FileMode) error {
+	fc, ok := fs.fs.(FastCopier)
+	if !ok {
+		return ErrNotImplemented
+	}
+	if err := fc.FastCopy(ctx, src, dst, perm); err != nil {
+		return err
+	}
+	dst = slashClean(dst)
+	if err := fs.ps.RemoveAll(ctx, dst); err != nil {
+		return err
+	}
+	m, err := fs.ps.DeadProps(ctx, slashClean(src))
+	if err != nil || len(m) == 0 {
+		return err
+	}
+	props := make([]Property, 0, len(m))
+	for _, p := range m {
+		props = append(props, p)
+	}
+	_, err = fs.ps.Patch(ctx, dst, []Proppatch{{Props: props}})
+	return err
+}
+
+// A propStoreFile is a File whose dead properties are held by a
+// PropStore.
+type propStoreFile struct {
+	File
+	ctx  context.Context
+	name string
+	ps   PropStore
+}
+
+// A *propStoreFile implements the optional DeadPropsHolder interface.
+var _ DeadPropsHolder = (*propStoreFile)(nil)
+
+func (f *propStoreFile) DeadProps() (map[xml.Name]Property, error) {
+	return f.ps.DeadProps(f.ctx, f.name)
+}
+
+func (f *propStoreFile) Patch(patches []Proppatch) ([]Propstat, error) {
+	return f.ps.Patch(f.ctx, f.name, patches)
+}
+
+// inPropTree reports whether resource name is root or is contained
+// in root.
+func inPropTree(name, root string) bool {
+	return name == root || root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+// applyProppatch applies patches to the dead properties m, and returns
+// the patched properties and the Propstats reporting their success.
+func applyProppatch(m map[xml.Name]Property, patches []Proppatch) (map[xml.Name]Property, []Propstat) {
+	pstat := Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, Property{XMLName: p.XMLName})
+			if patch.Remove {
+				delete(m, p.XMLName)
+				continue
+			}
+			if m == nil {
+				m = map[xml.Name]Property{}
+			}
+			m[p.XMLName] = p
+		}
+	}
+	return m, []Propstat{pstat}
+}
+
+// filePropStore is a PropStore holding the dead properties in memory,
+// and saving them to a file after each change.
+type filePropStore struct {
+	filename string
+
+	mu    sync.Mutex
+	props map[string]map[xml.Name]Property
+}
+
+// filePropRecord is the JSON encoding of the dead properties of a
+// resource in a filePropStore's file.
+type filePropRecord struct {
+	Name  string          `json:"name"`
+	Props []filePropValue `json:"props"`
+}
+
+type filePropValue struct {
+	Space    string `json:"space,omitempty"`
+	Local    string `json:"local"`
+	Lang     string `json:"lang,omitempty"`
+	InnerXML string `json:"innerxml"`
+}
+
+// NewFilePropStore returns a PropStore which saves dead properties as
+// JSON in the named file, loading those already saved if the file
+// exists.
+//
+// Each change rewrites the file, by writing a temporary file in the
+// same directory and renaming it, so the file is never left partially
+// written. The file must not be used by more than one PropStore at a
+// time.
+func NewFilePropStore(filename string) (PropStore, error) {
+	s := &filePropStore{
+		filename: filename,
+		props:    map[string]map[xml.Name]Property{},
+	}
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []filePropRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		m := make(map[xml.Name]Property, len(r.Props))
+		for _, p := range r.Props {
+			n := xml.Name{Space: p.Space, Local: p.Local}
+			m[n] = Property{XMLName: n, Lang: p.Lang, InnerXML: []byte(p.InnerXML)}
+		}
+		s.props[r.Name] = m
+	}
+	return s, nil
+}
+
+func (s *filePropStore) DeadProps(ctx context.Context, name string) (map[xml.Name]Property, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.props[name]
+	if len(m) == 0 {
+		return nil, nil
+	}
+	ret := make(map[xml.Name]Property, len(m))
+	for k, v := range m {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func (s *filePropStore) Patch(ctx context.Context, name string, patches []Proppatch) ([]Propstat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[xml.Name]Property, len(s.props[name]))
+	for k, v := range s.props[name] {
+		m[k] = v
+	}
+	m, pstats := applyProppatch(m, patches)
+	next := s.clone(func(n string) bool { return n != name })
+	if len(m) != 0 {
+		next[name] = m
+	}
+	if err := s.save(next); err != nil {
+		return nil, err
+	}
+	return pstats, nil
+}
+
+func (s *filePropStore) RemoveAll(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.clone(func(n string) bool { return !inPropTree(n, name) })
+	if len(next) == len(s.props) {
+		return nil
+	}
+	return s.save(next)
+}
+
+func (s *filePropStore) Rename(ctx context.Context, oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.clone(func(n string) bool { return !inPropTree(n, newName) && !inPropTree(n, oldName) })
+	for n, m := range s.props {
+		if inPropTree(n, oldName) {
+			next[newName+n[len(oldName):]] = m
+		}
+	}
+	return s.save(next)
+}
+
+// clone returns a copy of s.props holding the resources for which keep
+// returns true. The properties maps are shared, and are never modified.
+func (s *filePropStore) clone(keep func(name string) bool) map[string]map[xml.Name]Property {
+	next := make(map[string]map[xml.Name]Property, len(s.props))
+	for n, m := range s.props {
+		if keep(n) {
+			next[n] = m
+		}
+	}
+	return next
+}
+
+// save writes props to s.filename and, if that succeeds, replaces
+// s.props.
+func (s *filePropStore) save(props map[string]map[xml.Name]Property) error {
+	records := make([]filePropRecord, 0, len(props))
+	for n, m := range props {
+		r := filePropRecord{Name: n}
+		for _, p := range m {
+			r.Props = append(r.Props, filePropValue{
+				Space:    p.XMLName.Space,
+				Local:    p.XMLName.Local,
+				Lang:     p.Lang,
+				InnerXML: string(p.InnerXML),
+			})
+		}
+		sort.Slice(r.Props, func(i, j int) bool {
+			a, b := r.Props[i], r.Props[j]
+			if a.Space != b.Space {
+				return a.Space < b.Space
+			}
+			return a.Local < b.Local
+		})
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	b, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.filename), filepath.Base(s.filename)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.props = props
+	return nil
+}
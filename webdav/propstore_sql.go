@@ -0,0 +1,238 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// An SQLPropStore is a PropStore which stores dead properties in a
+// table of an SQL database, one row per property. The table must have
+// the following columns, or be created by a statement such as:
+//
+//	CREATE TABLE webdav_props (
+//		path       VARCHAR(1024) NOT NULL,
+//		ns         VARCHAR(1024) NOT NULL,
+//		local_name VARCHAR(255) NOT NULL,
+//		lang       VARCHAR(64) NOT NULL,
+//		inner_xml  BLOB NOT NULL,
+//		PRIMARY KEY (path, ns, local_name)
+//	)
+//
+// The path column must compare strings byte by byte, as with a binary
+// collation, and inner_xml may be of any type holding a []byte, such as
+// BYTEA for PostgreSQL.
+//
+// Each change is made in a transaction, so that a Patch, RemoveAll or
+// Rename is applied entirely or not at all.
+type SQLPropStore struct {
+	// DB is the database holding the table.
+	DB *sql.DB
+
+	// Table is the name of the table. If empty, "webdav_props" is used.
+	Table string
+
+	// Placeholder optionally returns the placeholder for the n'th
+	// argument of a statement, counting from 1. If nil, "?" is used,
+	// as by MySQL and SQLite. PostgreSQL drivers need "$1", "$2" and
+	// so on, returned by:
+	//
+	//	func(n int) string { return "$" + strconv.Itoa(n) }
+	Placeholder func(n int) string
+}
+
+var errSQLPropStoreNoDB = errors.New("webdav: SQLPropStore has no DB")
+
+func (s *SQLPropStore) table() string {
+	if s.Table == "" {
+		return "webdav_props"
+	}
+	return s.Table
+}
+
+// query returns q with the table name substituted for "%t" and the
+// placeholders substituted for each "?".
+func (s *SQLPropStore) query(q string) string {
+	q = strings.ReplaceAll(q, "%t", s.table())
+	if s.Placeholder == nil {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString(s.Placeholder(n))
+	}
+	return b.String()
+}
+
+func (s *SQLPropStore) DeadProps(ctx context.Context, name string) (map[xml.Name]Property, error) {
+	if s.DB == nil {
+		return nil, errSQLPropStoreNoDB
+	}
+	rows, err := s.DB.QueryContext(ctx, s.query(
+		"SELECT ns, local_name, lang, inner_xml FROM %t WHERE path = ?"), name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var m map[xml.Name]Property
+	for rows.Next() {
+		var p Property
+		if err := rows.Scan(&p.XMLName.Space, &p.XMLName.Local, &p.Lang, &p.InnerXML); err != nil {
+			return nil, err
+		}
+		if m == nil {
+			m = map[xml.Name]Property{}
+		}
+		m[p.XMLName] = p
+	}
+	return m, rows.Err()
+}
+
+func (s *SQLPropStore) Patch(ctx context.Context, name string, patches []Proppatch) ([]Propstat, error) {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, patch := range patches {
+			for _, p := range patch.Props {
+				if err := s.deleteProp(ctx, tx, name, p.XMLName); err != nil {
+					return err
+				}
+				if patch.Remove {
+					continue
+				}
+				if err := s.insertProp(ctx, tx, name, p); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, pstats := applyProppatch(nil, patches)
+	return pstats, nil
+}
+
+func (s *SQLPropStore) RemoveAll(ctx context.Context, name string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.removeTree(ctx, tx, name)
+	})
+}
+
+func (s *SQLPropStore) Rename(ctx context.Context, oldName, newName string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		props, err := s.selectTree(ctx, tx, oldName)
+		if err != nil {
+			return err
+		}
+		if err := s.removeTree(ctx, tx, newName); err != nil {
+			return err
+		}
+		if err := s.removeTree(ctx, tx, oldName); err != nil {
+			return err
+		}
+		for _, p := range props {
+			if err := s.insertProp(ctx, tx, newName+p.path[len(oldName):], p.Property); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inTx calls f in a transaction, which is committed if f succeeds and
+// rolled back otherwise.
+func (s *SQLPropStore) inTx(ctx context.Context, f func(*sql.Tx) error) error {
+	if s.DB == nil {
+		return errSQLPropStoreNoDB
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type sqlProp struct {
+	path string
+	Property
+}
+
+// selectTree returns the properties of resource name and of the
+// resources it contains.
+func (s *SQLPropStore) selectTree(ctx context.Context, tx *sql.Tx, name string) ([]sqlProp, error) {
+	// The range holds every path with the prefix name, and the paths
+	// which are not in the tree are skipped below. Paths in the tree
+	// sort before name followed by the byte after '/'.
+	lo, hi := name, name+"0"
+	if name == "/" {
+		hi = "0"
+	}
+	rows, err := tx.QueryContext(ctx, s.query(
+		"SELECT path, ns, local_name, lang, inner_xml FROM %t WHERE path >= ? AND path < ?"), lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var props []sqlProp
+	for rows.Next() {
+		var p sqlProp
+		if err := rows.Scan(&p.path, &p.XMLName.Space, &p.XMLName.Local, &p.Lang, &p.InnerXML); err != nil {
+			return nil, err
+		}
+		if inPropTree(p.path, name) {
+			props = append(props, p)
+		}
+	}
+	return props, rows.Err()
+}
+
+func (s *SQLPropStore) removeTree(ctx context.Context, tx *sql.Tx, name string) error {
+	props, err := s.selectTree(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	for _, p := range props {
+		if removed[p.path] {
+			continue
+		}
+		removed[p.path] = true
+		if _, err := tx.ExecContext(ctx, s.query("DELETE FROM %t WHERE path = ?"), p.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLPropStore) deleteProp(ctx context.Context, tx *sql.Tx, name string, pn xml.Name) error {
+	_, err := tx.ExecContext(ctx, s.query(
+		"DELETE FROM %t WHERE path = ? AND ns = ? AND local_name = ?"), name, pn.Space, pn.Local)
+	return err
+}
+
+func (s *SQLPropStore) insertProp(ctx context.Context, tx *sql.Tx, name string, p Property) error {
+	innerXML := p.InnerXML
+	if innerXML == nil {
+		innerXML = []byte{}
+	}
+	_, err := tx.ExecContext(ctx, s.query(
+		"INSERT INTO %t (path, ns, local_name, lang, inner_xml) VALUES (?, ?, ?, ?, ?)"),
+		name, p.XMLName.Space, p.XMLName.Local, p.Lang, innerXML)
+	return err
+}
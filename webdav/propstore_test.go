@@ -0,0 +1,411 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webdav
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	propStoreP0 = Property{XMLName: xml.Name{Space: "ns0", Local: "p0"}, InnerXML: []byte("v0")}
+	propStoreP1 = Property{XMLName: xml.Name{Space: "ns1", Local: "p1"}, Lang: "en", InnerXML: []byte("<x:y xmlns:x=\"x\">v1</x:y>")}
+	propStoreP2 = Property{XMLName: xml.Name{Space: "ns0", Local: "p2"}, InnerXML: []byte{}}
+)
+
+// testPropStore runs operations common to all PropStores against ps.
+func testPropStore(t *testing.T, ps PropStore) {
+	ctx := context.Background()
+	want := func(name string, props ...Property) {
+		t.Helper()
+		got, err := ps.DeadProps(ctx, name)
+		if err != nil {
+			t.Fatalf("DeadProps(%q): %v", name, err)
+		}
+		var wantMap map[xml.Name]Property
+		for _, p := range props {
+			if wantMap == nil {
+				wantMap = map[xml.Name]Property{}
+			}
+			wantMap[p.XMLName] = p
+		}
+		// Normalize nil and empty InnerXML, which SQL drivers may not
+		// preserve.
+		for k, p := range got {
+			if len(p.InnerXML) == 0 {
+				p.InnerXML = []byte{}
+				got[k] = p
+			}
+		}
+		if !reflect.DeepEqual(got, wantMap) {
+			t.Errorf("DeadProps(%q):\ngot  %v\nwant %v", name, got, wantMap)
+		}
+	}
+	patch := func(name string, patches ...Proppatch) {
+		t.Helper()
+		pstats, err := ps.Patch(ctx, name, patches)
+		if err != nil {
+			t.Fatalf("Patch(%q): %v", name, err)
+		}
+		if len(pstats) != 1 || pstats[0].Status != http.StatusOK {
+			t.Fatalf("Patch(%q) = %v, want one 200 OK Propstat", name, pstats)
+		}
+	}
+
+	want("/a")
+	patch("/a", Proppatch{Props: []Property{propStoreP0, propStoreP1}})
+	patch("/a/b", Proppatch{Props: []Property{propStoreP2}})
+	patch("/ab", Proppatch{Props: []Property{propStoreP0}})
+	patch("/c", Proppatch{Props: []Property{propStoreP1}})
+	want("/a", propStoreP0, propStoreP1)
+	want("/a/b", propStoreP2)
+
+	patch("/a",
+		Proppatch{Remove: true, Props: []Property{{XMLName: propStoreP0.XMLName}}},
+		Proppatch{Props: []Property{propStoreP2}},
+	)
+	want("/a", propStoreP1, propStoreP2)
+
+	if err := ps.Rename(ctx, "/a", "/c"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	want("/a")
+	want("/a/b")
+	want("/c", propStoreP1, propStoreP2)
+	want("/c/b", propStoreP2)
+	want("/ab", propStoreP0)
+
+	if err := ps.RemoveAll(ctx, "/c"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	want("/c")
+	want("/c/b")
+	want("/ab", propStoreP0)
+
+	if err := ps.RemoveAll(ctx, "/"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	want("/ab")
+}
+
+func TestFilePropStore(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "props.json")
+	ps, err := NewFilePropStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPropStore(t, ps)
+
+	if _, err := ps.Patch(ctx, "/a", []Proppatch{{Props: []Property{propStoreP0, propStoreP1}}}); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = NewFilePropStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ps.DeadProps(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[xml.Name]Property{
+		propStoreP0.XMLName: propStoreP0,
+		propStoreP1.XMLName: propStoreP1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DeadProps after reloading:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestSQLPropStore(t *testing.T) {
+	ps := &SQLPropStore{DB: sql.OpenDB(&fakeSQLConnector{db: &fakeSQLDB{}})}
+	defer ps.DB.Close()
+	testPropStore(t, ps)
+}
+
+func TestSQLPropStoreRollback(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeSQLDB{}
+	ps := &SQLPropStore{DB: sql.OpenDB(&fakeSQLConnector{db: db})}
+	defer ps.DB.Close()
+
+	if _, err := ps.Patch(ctx, "/a", []Proppatch{{Props: []Property{propStoreP0}}}); err != nil {
+		t.Fatal(err)
+	}
+	db.failInsert = propStoreP2.XMLName.Local
+	_, err := ps.Patch(ctx, "/a", []Proppatch{
+		{Remove: true, Props: []Property{{XMLName: propStoreP0.XMLName}}},
+		{Props: []Property{propStoreP1, propStoreP2}},
+	})
+	if err == nil {
+		t.Fatal("Patch succeeded, want error")
+	}
+	got, err := ps.DeadProps(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[xml.Name]Property{propStoreP0.XMLName: propStoreP0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("DeadProps after failed Patch:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestSQLPropStoreQuery(t *testing.T) {
+	ps := &SQLPropStore{
+		Table:       "props",
+		Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+	got := ps.query("DELETE FROM %t WHERE path = ? AND ns = ?")
+	if want := "DELETE FROM props WHERE path = $1 AND ns = $2"; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+}
+
+func TestWithPropStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "props.json")
+	ps, err := NewFilePropStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{
+		FileSystem: WithPropStore(Dir(t.TempDir()), ps),
+		LockSystem: NewMemLS(),
+	}
+	do := func(method, path, body string, wantStatus int, hdr ...string) string {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != wantStatus {
+			t.Fatalf("%s %s: got status %d, want %d", method, path, rec.Code, wantStatus)
+		}
+		return rec.Body.String()
+	}
+
+	do("PUT", "/a.txt", "hello", http.StatusCreated)
+	do("MKCOL", "/dir", "", http.StatusCreated)
+	resp := do("PROPPATCH", "/a.txt", `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:z">
+<D:set><D:prop><Z:author>gopher</Z:author></D:prop></D:set>
+</D:propertyupdate>`, http.StatusMultiStatus)
+	if !strings.Contains(resp, "200 OK") {
+		t.Fatalf("PROPPATCH response does not report success:\n%s", resp)
+	}
+	do("COPY", "/a.txt", "", http.StatusCreated, "Destination", "/dir/b.txt")
+	do("MOVE", "/dir", "", http.StatusCreated, "Destination", "/moved")
+	do("DELETE", "/a.txt", "", http.StatusNoContent)
+	resp = do("PROPFIND", "/moved/b.txt", `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:Z="urn:z"><D:prop><Z:author/></D:prop></D:propfind>`,
+		http.StatusMultiStatus, "Depth", "0")
+	if !strings.Contains(resp, "gopher") {
+		t.Errorf("PROPFIND response does not hold the copied and moved property:\n%s", resp)
+	}
+
+	ps, err = NewFilePropStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		want int
+	}{
+		{"/a.txt", 0},
+		{"/dir/b.txt", 0},
+		{"/moved/b.txt", 1},
+	} {
+		m, err := ps.DeadProps(ctx, tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m) != tc.want {
+			t.Errorf("%s: got %d saved properties, want %d", tc.name, len(m), tc.want)
+		}
+	}
+}
+
+// fakeSQLDB is an in-memory database for the queries made by
+// SQLPropStore, with the default table name and placeholders.
+type fakeSQLDB struct {
+	mu   sync.Mutex
+	rows []fakeSQLRow
+
+	// failInsert makes inserting a property with this local name fail.
+	failInsert string
+}
+
+type fakeSQLRow struct {
+	path, ns, local, lang string
+	innerXML              []byte
+}
+
+type fakeSQLConnector struct {
+	db *fakeSQLDB
+}
+
+func (c *fakeSQLConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeSQLConn{db: c.db}, nil
+}
+
+func (c *fakeSQLConnector) Driver() driver.Driver { return fakeSQLDriver{} }
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeSQLDriver: use fakeSQLConnector")
+}
+
+// fakeSQLConn holds the database's lock for the duration of a
+// transaction, and restores its rows on rollback.
+type fakeSQLConn struct {
+	db    *fakeSQLDB
+	saved []fakeSQLRow
+	inTx  bool
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{c: c, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.inTx = true
+	c.saved = append([]fakeSQLRow(nil), c.db.rows...)
+	return c, nil
+}
+
+func (c *fakeSQLConn) Commit() error {
+	c.inTx = false
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *fakeSQLConn) Rollback() error {
+	c.db.rows = c.saved
+	c.inTx = false
+	c.db.mu.Unlock()
+	return nil
+}
+
+type fakeSQLStmt struct {
+	c     *fakeSQLConn
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.c.db
+	if !s.c.inTx {
+		return nil, errors.New("fakeSQLStmt: Exec outside transaction")
+	}
+	str := func(i int) string { return args[i].(string) }
+	var n int64
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO webdav_props "):
+		r := fakeSQLRow{str(0), str(1), str(2), str(3), append([]byte{}, args[4].([]byte)...)}
+		if r.local == db.failInsert {
+			return nil, errors.New("fakeSQLStmt: insert failed")
+		}
+		for _, o := range db.rows {
+			if o.path == r.path && o.ns == r.ns && o.local == r.local {
+				return nil, errors.New("fakeSQLStmt: duplicate primary key")
+			}
+		}
+		db.rows = append(db.rows, r)
+		n = 1
+	case s.query == "DELETE FROM webdav_props WHERE path = ? AND ns = ? AND local_name = ?":
+		n = db.delete(func(r fakeSQLRow) bool { return r.path == str(0) && r.ns == str(1) && r.local == str(2) })
+	case s.query == "DELETE FROM webdav_props WHERE path = ?":
+		n = db.delete(func(r fakeSQLRow) bool { return r.path == str(0) })
+	default:
+		return nil, fmt.Errorf("fakeSQLStmt: unknown statement %q", s.query)
+	}
+	return driver.RowsAffected(n), nil
+}
+
+func (db *fakeSQLDB) delete(match func(fakeSQLRow) bool) int64 {
+	var n int64
+	rows := db.rows[:0]
+	for _, r := range db.rows {
+		if match(r) {
+			n++
+			continue
+		}
+		rows = append(rows, r)
+	}
+	db.rows = rows
+	return n
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.c.db
+	if !s.c.inTx {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+	str := func(i int) string { return args[i].(string) }
+	res := &fakeSQLRows{}
+	switch s.query {
+	case "SELECT ns, local_name, lang, inner_xml FROM webdav_props WHERE path = ?":
+		res.cols = []string{"ns", "local_name", "lang", "inner_xml"}
+		for _, r := range db.rows {
+			if r.path == str(0) {
+				res.vals = append(res.vals, []driver.Value{r.ns, r.local, r.lang, r.innerXML})
+			}
+		}
+	case "SELECT path, ns, local_name, lang, inner_xml FROM webdav_props WHERE path >= ? AND path < ?":
+		res.cols = []string{"path", "ns", "local_name", "lang", "inner_xml"}
+		for _, r := range db.rows {
+			if r.path >= str(0) && r.path < str(1) {
+				res.vals = append(res.vals, []driver.Value{r.path, r.ns, r.local, r.lang, r.innerXML})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("fakeSQLStmt: unknown query %q", s.query)
+	}
+	// Return rows in a stable order, as a database may return them in
+	// any order.
+	sort.Slice(res.vals, func(i, j int) bool {
+		return fmt.Sprint(res.vals[i]) < fmt.Sprint(res.vals[j])
+	})
+	return res, nil
+}
+
+type fakeSQLRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.cols }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
@@ -28,6 +28,18 @@ type Handler struct {
 	// Logger is an optional error logger. If non-nil, it will be called
 	// for all HTTP requests.
 	Logger func(*http.Request, error)
+	// Audit is an optional access and audit log hook. If non-nil, it will
+	// be called with a record of each HTTP request once its response has
+	// been written.
+	Audit func(*AuditEvent)
+	// Principal optionally identifies the user making a request, for
+	// AuditEvent.Principal. If nil, the user name from the request's
+	// HTTP basic authentication credentials, if any, is used.
+	Principal func(*http.Request) string
+	// Progress is an optional callback for COPY and MOVE requests. If
+	// non-nil, it will be called after each file or directory is copied
+	// or moved. Copies stop early if the request's context is canceled.
+	Progress func(*http.Request, CopyProgress)
 }
 
 func (h *Handler) stripPrefix(p string) (string, int, error) {
@@ -41,6 +53,24 @@ func (h *Handler) stripPrefix(p string) (string, int, error) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		audit *AuditEvent
+		aw    *auditResponseWriter
+		body  *auditBody
+	)
+	if h.Audit != nil {
+		audit = h.newAuditEvent(r, time.Now())
+		aw = &auditResponseWriter{ResponseWriter: w}
+		w = aw
+		if r.Body != nil {
+			body = &auditBody{ReadCloser: r.Body}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Body = body
+			r = r2
+		}
+	}
+
 	status, err := http.StatusBadRequest, errUnsupportedMethod
 	if h.FileSystem == nil {
 		status, err = http.StatusInternalServerError, errNoFileSystem
@@ -80,6 +110,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.Logger != nil {
 		h.Logger(r, err)
 	}
+	if audit != nil {
+		audit.finish(aw, body, err)
+		h.Audit(audit)
+	}
 }
 
 func (h *Handler) lock(now time.Time, root string) (token string, status int, err error) {
@@ -349,6 +383,10 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 	}
 
 	ctx := r.Context()
+	var progress *copyProgress
+	if h.Progress != nil {
+		progress = &copyProgress{fn: func(p CopyProgress) { h.Progress(r, p) }}
+	}
 
 	if r.Method == "COPY" {
 		// Section 7.5.1 says that a COPY only needs to lock the destination,
@@ -373,7 +411,7 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 				return http.StatusBadRequest, errInvalidDepth
 			}
 		}
-		return copyFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F", depth, 0)
+		return copyFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") != "F", depth, 0, progress)
 	}
 
 	release, status, err := h.confirmLocks(r, src, dst)
@@ -390,7 +428,7 @@ func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status
 			return http.StatusBadRequest, errInvalidDepth
 		}
 	}
-	return moveFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") == "T")
+	return moveFiles(ctx, h.FileSystem, src, dst, r.Header.Get("Overwrite") == "T", progress)
 }
 
 func (h *Handler) handleLock(w http.ResponseWriter, r *http.Request) (retStatus int, retErr error) {
@@ -18,6 +18,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TODO: add tests to check XML responses with the expected prefix path
@@ -406,3 +407,72 @@ func TestPutRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestAudit(t *testing.T) {
+	var events []*AuditEvent
+	h := &Handler{
+		Prefix:     "/dav",
+		FileSystem: NewMemFS(),
+		LockSystem: NewMemLS(),
+		Audit:      func(e *AuditEvent) { events = append(events, e) },
+	}
+	do := func(method, path, body string, hdr ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth("gopher", "secret")
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do("PUT", "/dav/a.txt", "hello")
+	lockInfo := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	rec := do("LOCK", "/dav/a.txt", lockInfo, "Depth", "0")
+	token := codedURL(rec.Header().Get("Lock-Token"))
+	if token == "" {
+		t.Fatalf("LOCK: no Lock-Token in response header %v", rec.Header())
+	}
+	do("PUT", "/dav/a.txt", "hello, world", "If", "(<"+token+">)")
+	do("COPY", "/dav/a.txt", "", "Destination", "http://example.com/dav/b.txt")
+	do("GET", "/dav/b.txt", "")
+	do("UNLOCK", "/dav/a.txt", "", "Lock-Token", "<"+token+">")
+	do("GET", "/other", "")
+
+	want := []AuditEvent{
+		{Principal: "gopher", Method: "PUT", Path: "/a.txt", Status: http.StatusCreated, RequestBytes: 5, ResponseBytes: 7},
+		{Principal: "gopher", Method: "LOCK", Path: "/a.txt", Depth: "0", Status: http.StatusOK, RequestBytes: int64(len(lockInfo)), LockTokens: []string{token}},
+		{Principal: "gopher", Method: "PUT", Path: "/a.txt", Status: http.StatusCreated, RequestBytes: 12, ResponseBytes: 7, LockTokens: []string{token}},
+		{Principal: "gopher", Method: "COPY", Path: "/a.txt", Destination: "/b.txt", Status: http.StatusCreated, ResponseBytes: 7},
+		{Principal: "gopher", Method: "GET", Path: "/b.txt", Status: http.StatusOK, ResponseBytes: 12},
+		{Principal: "gopher", Method: "UNLOCK", Path: "/a.txt", Status: http.StatusNoContent, LockTokens: []string{token}},
+		{Principal: "gopher", Method: "GET", Path: "/other", Status: http.StatusNotFound, ResponseBytes: 9, Err: errPrefixMismatch},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Time.IsZero() || e.Duration < 0 {
+			t.Errorf("event %d: Time = %v, Duration = %v", i, e.Time, e.Duration)
+		}
+		got := *e
+		got.Time, got.Duration = time.Time{}, 0
+		if got.Method == "LOCK" {
+			// The response holds the lock discovery XML.
+			got.ResponseBytes = 0
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("event %d:\ngot  %+v\nwant %+v", i, got, want[i])
+		}
+	}
+
+	h.Principal = func(r *http.Request) string { return r.Header.Get("X-User") }
+	events = nil
+	do("OPTIONS", "/dav/", "", "X-User", "alice")
+	if len(events) != 1 || events[0].Principal != "alice" {
+		t.Errorf("with Principal func: events = %+v, want Principal alice", events)
+	}
+}
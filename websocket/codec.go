@@ -0,0 +1,219 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+)
+
+// A Marshaler converts values to and from WebSocket messages,
+// for use with a StreamCodec.
+//
+// Marshalers for formats such as protobuf or msgpack can be
+// registered with RegisterMarshaler.
+type Marshaler interface {
+	// Marshal returns the encoding of v, and the frame type to
+	// send it in: TextFrame or BinaryFrame.
+	Marshal(v interface{}) (data []byte, payloadType byte, err error)
+
+	// Decode decodes a message into v.
+	// r returns the message's payload, reassembled from all of its
+	// fragments, and fails with ErrFrameTooLarge if the message
+	// exceeds the StreamCodec's size limit.
+	// payloadType is the type of the message's first frame.
+	Decode(r io.Reader, payloadType byte, v interface{}) error
+}
+
+var (
+	marshalersMu sync.RWMutex
+	marshalers   = map[string]Marshaler{
+		"json":    jsonMarshaler{},
+		"message": messageMarshaler{},
+	}
+)
+
+// RegisterMarshaler makes a Marshaler available by name,
+// such as the name of the WebSocket subprotocol using it.
+// The "json" and "message" Marshalers, equivalent to the JSON and
+// Message codecs, are registered by default.
+// It panics if m is nil or if name is already registered.
+func RegisterMarshaler(name string, m Marshaler) {
+	marshalersMu.Lock()
+	defer marshalersMu.Unlock()
+	if m == nil {
+		panic("websocket: RegisterMarshaler of nil Marshaler")
+	}
+	if _, dup := marshalers[name]; dup {
+		panic("websocket: RegisterMarshaler called twice for " + name)
+	}
+	marshalers[name] = m
+}
+
+// LookupMarshaler returns the Marshaler registered by name,
+// or nil if there is none.
+func LookupMarshaler(name string) Marshaler {
+	marshalersMu.RLock()
+	defer marshalersMu.RUnlock()
+	return marshalers[name]
+}
+
+// Marshalers returns the sorted names of the registered Marshalers.
+func Marshalers() []string {
+	marshalersMu.RLock()
+	defer marshalersMu.RUnlock()
+	names := make([]string, 0, len(marshalers))
+	for name := range marshalers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StreamCodec sends and receives values using a Marshaler.
+//
+// Unlike Codec, which reads a single frame into memory before
+// unmarshaling it, StreamCodec decodes a message as it is read,
+// and reassembles messages sent as several fragments.
+type StreamCodec struct {
+	Marshaler Marshaler
+
+	// MaxMessageBytes limits the size of a received message,
+	// across all of its fragments. If zero, the Conn's
+	// MaxPayloadBytes is used.
+	MaxMessageBytes int
+}
+
+// Send sends v marshaled by cd.Marshaler as a single frame to ws.
+func (cd StreamCodec) Send(ws *Conn, v interface{}) error {
+	return Codec{Marshal: cd.Marshaler.Marshal}.Send(ws, v)
+}
+
+// Receive receives a message from ws, decodes it with cd.Marshaler,
+// and stores it in v. Any part of the message not consumed by the
+// Marshaler is discarded. If the message exceeds the size limit,
+// Receive returns ErrFrameTooLarge.
+func (cd StreamCodec) Receive(ws *Conn, v interface{}) error {
+	ws.rio.Lock()
+	defer ws.rio.Unlock()
+	if ws.frameReader != nil {
+		if _, err := io.Copy(io.Discard, ws.frameReader); err != nil {
+			return err
+		}
+		ws.frameReader = nil
+	}
+	frame, err := nextDataFrame(ws)
+	if err != nil {
+		return err
+	}
+	max := cd.MaxMessageBytes
+	if max == 0 {
+		max = ws.MaxPayloadBytes
+	}
+	if max == 0 {
+		max = DefaultMaxPayloadBytes
+	}
+	r := &messageReader{ws: ws, frame: frame, remain: int64(max)}
+	err = cd.Marshaler.Decode(r, frame.PayloadType(), v)
+	if r.err == ErrFrameTooLarge {
+		err = ErrFrameTooLarge
+	}
+	// Discard the rest of the message, so the next Receive starts
+	// on a message boundary.
+	r.remain = -1
+	if r.err == ErrFrameTooLarge {
+		r.err = nil
+	}
+	if _, derr := io.Copy(io.Discard, r); derr != nil && err == nil {
+		err = derr
+	}
+	return err
+}
+
+// nextDataFrame reads frames from ws until one which is not a
+// control frame handled by ws's frame handler.
+func nextDataFrame(ws *Conn) (frameReader, error) {
+	for {
+		frame, err := ws.frameReaderFactory.NewFrameReader()
+		if err != nil {
+			return nil, err
+		}
+		frame, err = ws.frameHandler.HandleFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			return frame, nil
+		}
+	}
+}
+
+// A messageReader reads a message which may span several frames.
+type messageReader struct {
+	ws     *Conn
+	frame  frameReader
+	remain int64 // bytes permitted before ErrFrameTooLarge, or -1 for no limit
+	err    error
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.remain >= 0 && int64(len(p)) > r.remain+1 {
+			p = p[:r.remain+1]
+		}
+		n, err := r.frame.Read(p)
+		if r.remain >= 0 {
+			r.remain -= int64(n)
+			if r.remain < 0 {
+				r.err = ErrFrameTooLarge
+				return 0, r.err
+			}
+		}
+		switch {
+		case err == io.EOF && finalFrame(r.frame):
+			r.err = io.EOF
+		case err == io.EOF:
+			r.frame, r.err = nextDataFrame(r.ws)
+		case err != nil:
+			r.err = err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// finalFrame reports whether frame is the last frame of its message.
+func finalFrame(frame frameReader) bool {
+	if hf, ok := frame.(*hybiFrameReader); ok {
+		return hf.header.Fin
+	}
+	return true
+}
+
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v interface{}) ([]byte, byte, error) { return jsonMarshal(v) }
+
+func (jsonMarshaler) Decode(r io.Reader, payloadType byte, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+type messageMarshaler struct{}
+
+func (messageMarshaler) Marshal(v interface{}) ([]byte, byte, error) { return marshal(v) }
+
+func (messageMarshaler) Decode(r io.Reader, payloadType byte, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return unmarshal(data, payloadType, v)
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// fragmentedFrames returns the wire encoding of unmasked frames,
+// one per element of parts, forming a single text message.
+func fragmentedFrames(parts ...string) []byte {
+	var b []byte
+	for i, part := range parts {
+		var op byte = TextFrame
+		if i > 0 {
+			op = ContinuationFrame
+		}
+		if i == len(parts)-1 {
+			op |= 0x80 // FIN
+		}
+		b = append(b, op, byte(len(part)))
+		b = append(b, part...)
+	}
+	return b
+}
+
+func newTestReadConn(t *testing.T, wire []byte) *Conn {
+	br := bufio.NewReader(bytes.NewReader(wire))
+	bw := bufio.NewWriter(io.Discard)
+	return newHybiConn(newConfig(t, "/"), bufio.NewReadWriter(br, bw), nil, nil)
+}
+
+func TestStreamCodecFragmented(t *testing.T) {
+	var wire []byte
+	wire = append(wire, fragmentedFrames(`{"Msg":"hel`, `lo","Co`, `unt":3}`)...)
+	wire = append(wire, 0x89, 0x00) // PING between messages
+	wire = append(wire, fragmentedFrames(`{"Msg":"second"}   `, `  `)...)
+	ws := newTestReadConn(t, wire)
+
+	codec := StreamCodec{Marshaler: LookupMarshaler("json")}
+	type T struct {
+		Msg   string
+		Count int
+	}
+	var got T
+	if err := codec.Receive(ws, &got); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	if want := (T{"hello", 3}); got != want {
+		t.Errorf("Receive = %+v, want %+v", got, want)
+	}
+	got = T{}
+	if err := codec.Receive(ws, &got); err != nil {
+		t.Fatalf("second Receive: %v", err)
+	}
+	if want := (T{Msg: "second"}); got != want {
+		t.Errorf("second Receive = %+v, want %+v", got, want)
+	}
+}
+
+func TestStreamCodecLimit(t *testing.T) {
+	var wire []byte
+	wire = append(wire, fragmentedFrames("aaaa", "bbbb", "cccc")...)
+	wire = append(wire, fragmentedFrames("ok")...)
+	ws := newTestReadConn(t, wire)
+
+	codec := StreamCodec{Marshaler: LookupMarshaler("message"), MaxMessageBytes: 10}
+	var s string
+	if err := codec.Receive(ws, &s); err != ErrFrameTooLarge {
+		t.Fatalf("Receive of oversized message: %v, want ErrFrameTooLarge", err)
+	}
+	if err := codec.Receive(ws, &s); err != nil || s != "ok" {
+		t.Fatalf("Receive after oversized message = %q, %v; want %q", s, err, "ok")
+	}
+}
+
+func TestRegisterMarshaler(t *testing.T) {
+	if LookupMarshaler("json") == nil || LookupMarshaler("message") == nil {
+		t.Fatalf("default Marshalers not registered: %q", Marshalers())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterMarshaler of duplicate name did not panic")
+		}
+	}()
+	RegisterMarshaler("json", jsonMarshaler{})
+}
@@ -261,9 +261,13 @@ func (buf hybiFrameWriterFactory) NewFrameWriter(payloadType byte) (frame frameW
 type hybiFrameHandler struct {
 	conn        *Conn
 	payloadType byte
+	limiter     readLimiter
 }
 
 func (handler *hybiFrameHandler) HandleFrame(frame frameReader) (frameReader, error) {
+	if err := handler.limiter.err; err != nil {
+		return nil, err
+	}
 	if handler.conn.IsServerConn() {
 		// The client MUST mask all frames sent to the server.
 		if frame.(*hybiFrameReader).header.MaskingKey == nil {
@@ -281,6 +285,12 @@ func (handler *hybiFrameHandler) HandleFrame(frame frameReader) (frameReader, er
 		io.Copy(io.Discard, header)
 	}
 	switch frame.PayloadType() {
+	case ContinuationFrame, TextFrame, BinaryFrame:
+		if err := handler.limiter.check(handler, frame.(*hybiFrameReader)); err != nil {
+			return nil, err
+		}
+	}
+	switch frame.PayloadType() {
 	case ContinuationFrame:
 		frame.(*hybiFrameReader).header.OpCode = handler.payloadType
 	case TextFrame, BinaryFrame:
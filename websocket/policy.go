@@ -0,0 +1,148 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrOriginNotAllowed   = &ProtocolError{"origin not allowed"}
+	ErrTooManyConnections = &ProtocolError{"too many connections"}
+)
+
+// A Policy limits the WebSocket connections accepted by a Server.
+// It is checked before the handshake is accepted.
+//
+// A Policy may be shared by several Servers, and must not be
+// copied after first use.
+type Policy struct {
+	// AllowedOrigins lists the Origin header values accepted.
+	// If empty, any origin is accepted.
+	//
+	// Each entry matches an origin's host, and optionally its scheme:
+	//	"example.com"         the host example.com, with any scheme
+	//	"https://example.com" the host example.com, with the https scheme
+	//	"*.example.com"       any subdomain of example.com
+	//	"10.0.0.0/8"          any IP address in 10.0.0.0/8
+	// An entry with a port, such as "example.com:8080", matches only
+	// that port; otherwise any port matches.
+	// Requests without an Origin header are rejected when
+	// AllowedOrigins is set.
+	AllowedOrigins []string
+
+	// MaxConnsPerIP limits the number of concurrent connections from
+	// a single remote IP address. Zero means no limit.
+	MaxConnsPerIP int
+
+	// MaxConnsPerOrigin limits the number of concurrent connections
+	// with the same Origin header. Zero means no limit.
+	MaxConnsPerOrigin int
+
+	// Reject, if non-nil, is called when the policy rejects a request,
+	// with ErrOriginNotAllowed or ErrTooManyConnections.
+	Reject func(req *http.Request, err error)
+
+	mu       sync.Mutex
+	byIP     map[string]int
+	byOrigin map[string]int
+}
+
+// admit checks req against the policy. If the request is accepted, it
+// returns a func which must be called when the connection is closed.
+func (p *Policy) admit(req *http.Request) (release func(), code int, err error) {
+	origin := req.Header.Get("Origin")
+	if len(p.AllowedOrigins) > 0 && !p.originAllowed(origin) {
+		return nil, http.StatusForbidden, p.reject(req, ErrOriginNotAllowed)
+	}
+	ip := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.MaxConnsPerIP > 0 && p.byIP[ip] >= p.MaxConnsPerIP ||
+		p.MaxConnsPerOrigin > 0 && origin != "" && p.byOrigin[origin] >= p.MaxConnsPerOrigin {
+		return nil, http.StatusServiceUnavailable, p.reject(req, ErrTooManyConnections)
+	}
+	if p.byIP == nil {
+		p.byIP = make(map[string]int)
+		p.byOrigin = make(map[string]int)
+	}
+	p.byIP[ip]++
+	if origin != "" {
+		p.byOrigin[origin]++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			decrement(p.byIP, ip)
+			if origin != "" {
+				decrement(p.byOrigin, origin)
+			}
+		})
+	}, 0, nil
+}
+
+func decrement(m map[string]int, key string) {
+	if m[key] <= 1 {
+		delete(m, key)
+	} else {
+		m[key]--
+	}
+}
+
+func (p *Policy) reject(req *http.Request, err error) error {
+	if p.Reject != nil {
+		p.Reject(req, err)
+	}
+	return err
+}
+
+func (p *Policy) originAllowed(origin string) bool {
+	u, err := url.ParseRequestURI(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, pattern := range p.AllowedOrigins {
+		if matchOrigin(pattern, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether the origin u matches a Policy.AllowedOrigins entry.
+func matchOrigin(pattern string, u *url.URL) bool {
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if !strings.EqualFold(scheme, u.Scheme) {
+			return false
+		}
+		pattern = rest
+	}
+	host := u.Hostname()
+	if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ipnet.Contains(ip)
+	}
+	if h, port, err := net.SplitHostPort(pattern); err == nil {
+		if port != u.Port() {
+			return false
+		}
+		pattern = h
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[len("*."):]
+		return len(host) > len(suffix)+1 &&
+			strings.EqualFold(host[len(host)-len(suffix)-1:], "."+suffix)
+	}
+	return strings.EqualFold(strings.Trim(pattern, "[]"), host)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	for _, test := range []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"example.com", "http://example.com", true},
+		{"example.com", "https://EXAMPLE.com:8443", true},
+		{"example.com", "http://www.example.com", false},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "http://example.com", false},
+		{"example.com:8080", "http://example.com:8080", true},
+		{"example.com:8080", "http://example.com", false},
+		{"*.example.com", "http://www.example.com", true},
+		{"*.example.com", "http://a.b.example.com", true},
+		{"*.example.com", "http://example.com", false},
+		{"*.example.com", "http://badexample.com", false},
+		{"10.0.0.0/8", "http://10.1.2.3:8000", true},
+		{"10.0.0.0/8", "http://11.1.2.3", false},
+		{"10.0.0.0/8", "http://example.com", false},
+		{"::1", "http://[::1]:80", true},
+		{"2001:db8::/32", "http://[2001:db8::1]", true},
+	} {
+		u, err := url.ParseRequestURI(test.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchOrigin(test.pattern, u); got != test.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", test.pattern, test.origin, got, test.want)
+		}
+	}
+}
+
+func TestPolicyAdmit(t *testing.T) {
+	var rejected []error
+	p := &Policy{
+		AllowedOrigins:    []string{"*.example.com"},
+		MaxConnsPerIP:     2,
+		MaxConnsPerOrigin: 1,
+		Reject: func(req *http.Request, err error) {
+			rejected = append(rejected, err)
+		},
+	}
+	newReq := func(remote, origin string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req
+	}
+
+	if _, _, err := p.admit(newReq("10.0.0.1:1000", "")); err != ErrOriginNotAllowed {
+		t.Errorf("admit without Origin: %v, want ErrOriginNotAllowed", err)
+	}
+	if _, _, err := p.admit(newReq("10.0.0.1:1000", "http://evil.com")); err != ErrOriginNotAllowed {
+		t.Errorf("admit disallowed origin: %v, want ErrOriginNotAllowed", err)
+	}
+	release1, _, err := p.admit(newReq("10.0.0.1:1000", "http://a.example.com"))
+	if err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if _, code, err := p.admit(newReq("10.0.0.2:1000", "http://a.example.com")); err != ErrTooManyConnections || code != http.StatusServiceUnavailable {
+		t.Errorf("admit over origin limit: %v, %v; want ErrTooManyConnections", code, err)
+	}
+	release2, _, err := p.admit(newReq("10.0.0.1:1001", "http://b.example.com"))
+	if err != nil {
+		t.Fatalf("admit: %v", err)
+	}
+	if _, _, err := p.admit(newReq("10.0.0.1:1002", "http://c.example.com")); err != ErrTooManyConnections {
+		t.Errorf("admit over IP limit: %v, want ErrTooManyConnections", err)
+	}
+	release1()
+	release1() // no-op
+	release3, _, err := p.admit(newReq("10.0.0.1:1002", "http://a.example.com"))
+	if err != nil {
+		t.Fatalf("admit after release: %v", err)
+	}
+	release2()
+	release3()
+	if len(p.byIP) != 0 || len(p.byOrigin) != 0 {
+		t.Errorf("after releasing all connections: byIP=%v, byOrigin=%v; want empty", p.byIP, p.byOrigin)
+	}
+	if len(rejected) != 4 {
+		t.Errorf("Reject called %v times, want 4", len(rejected))
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	s := Server{
+		Policy:  &Policy{AllowedOrigins: []string{"localhost"}},
+		Handler: echoServer,
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	url := "ws://" + server.Listener.Addr().String() + "/"
+
+	ws, err := Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Dial with allowed origin: %v", err)
+	}
+	ws.Close()
+	if _, err := Dial(url, "", "http://example.com/"); err == nil {
+		t.Fatalf("Dial with disallowed origin succeeded, want error")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"time"
+)
+
+var (
+	ErrRateLimited     = &ProtocolError{"message rate limit exceeded"}
+	ErrMessageTooLarge = &ProtocolError{"message size limit exceeded"}
+)
+
+// A RateLimitAction is what a Conn does when messages arrive faster
+// than its ReadLimit allows.
+type RateLimitAction int
+
+const (
+	// RateLimitClose closes the connection with status 1008
+	// (policy violation).
+	RateLimitClose RateLimitAction = iota
+
+	// RateLimitWait stops reading from the connection until the
+	// message is allowed, so that a sender which is too fast is
+	// slowed down by TCP flow control.
+	RateLimitWait
+)
+
+// A ReadLimit limits the rate and size of the messages a Conn reads.
+// The limits are enforced as frames are read, by Read and by the
+// Receive methods of the codecs, before a frame's payload is read.
+// Control frames such as pings are not counted.
+//
+// A ReadLimit may be shared by several Conns; each Conn keeps its own
+// count of the messages it has read.
+type ReadLimit struct {
+	// MessagesPerSecond limits the average rate at which messages
+	// are read. Zero means no limit.
+	MessagesPerSecond float64
+
+	// Burst is the number of messages which may be read at once
+	// beyond the average rate. If zero, 1 is used.
+	Burst int
+
+	// Action is what the Conn does when a message arrives too soon.
+	Action RateLimitAction
+
+	// MaxMessageBytes limits the size of a message, across all of its
+	// fragments. A Conn reading a larger message closes the connection
+	// with status 1009 (message too big). Zero means no limit.
+	MaxMessageBytes int64
+}
+
+func (l *ReadLimit) burst() float64 {
+	if l.Burst <= 0 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// readLimiter is the state of a Conn's ReadLimit.
+type readLimiter struct {
+	tokens   float64   // messages which may be read now
+	last     time.Time // when tokens was last updated, or zero
+	msgBytes int64     // bytes in the current message's frames so far
+	err      error     // the limit the Conn exceeded, or nil
+}
+
+// check enforces the Conn's ReadLimit on frame, a data frame just
+// read by handler. It reports an error after closing the connection
+// for a frame which exceeds the limit.
+func (rl *readLimiter) check(handler *hybiFrameHandler, frame *hybiFrameReader) error {
+	l := handler.conn.ReadLimit
+	if l == nil {
+		return nil
+	}
+	if frame.header.OpCode != ContinuationFrame {
+		rl.msgBytes = 0
+		if l.MessagesPerSecond > 0 {
+			if err := rl.wait(handler, l); err != nil {
+				return err
+			}
+		}
+	}
+	rl.msgBytes += frame.header.Length
+	if l.MaxMessageBytes > 0 && rl.msgBytes > l.MaxMessageBytes {
+		return rl.fail(handler, closeStatusTooBigData, ErrMessageTooLarge)
+	}
+	return nil
+}
+
+// wait takes a message from the token bucket, waiting for one or
+// failing if there is none.
+func (rl *readLimiter) wait(handler *hybiFrameHandler, l *ReadLimit) error {
+	now := time.Now()
+	if rl.last.IsZero() {
+		rl.tokens = l.burst()
+	} else {
+		rl.tokens += now.Sub(rl.last).Seconds() * l.MessagesPerSecond
+		if b := l.burst(); rl.tokens > b {
+			rl.tokens = b
+		}
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		if l.Action != RateLimitWait {
+			return rl.fail(handler, closeStatusPolicyViolation, ErrRateLimited)
+		}
+		d := time.Duration((1 - rl.tokens) / l.MessagesPerSecond * float64(time.Second))
+		time.Sleep(d)
+		rl.tokens = 1
+		rl.last = now.Add(d)
+	}
+	rl.tokens--
+	return nil
+}
+
+// fail sends a close frame with status, and makes the Conn's reads
+// fail with err from now on.
+func (rl *readLimiter) fail(handler *hybiFrameHandler, status int, err error) error {
+	rl.err = err
+	handler.WriteClose(status)
+	return err
+}
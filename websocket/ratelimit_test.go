@@ -0,0 +1,112 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// maskedFrame returns a frame as a client sends it, masked with
+// an all-zero key.
+func maskedFrame(opcode byte, fin bool, payload string) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	b := []byte{b0, 0x80 | byte(len(payload)), 0, 0, 0, 0}
+	return append(b, payload...)
+}
+
+// newLimitedServerConn returns a server Conn reading wireData,
+// and the buffer its writes go to.
+func newLimitedServerConn(t *testing.T, l *ReadLimit, wireData []byte) (*Conn, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	br := bufio.NewReader(bytes.NewReader(wireData))
+	bw := bufio.NewWriter(out)
+	ws := newHybiConn(newConfig(t, "/"), bufio.NewReadWriter(br, bw), nil, new(http.Request))
+	ws.ReadLimit = l
+	return ws, out
+}
+
+func TestReadLimitRateClose(t *testing.T) {
+	var wire []byte
+	for i := 0; i < 3; i++ {
+		wire = append(wire, maskedFrame(TextFrame, true, "hello")...)
+	}
+	ws, out := newLimitedServerConn(t, &ReadLimit{
+		MessagesPerSecond: 0.001,
+		Burst:             2,
+	}, wire)
+
+	msg := make([]byte, 512)
+	for i := 0; i < 2; i++ {
+		if _, err := ws.Read(msg); err != nil {
+			t.Fatalf("Read %v within burst: %v", i, err)
+		}
+	}
+	if _, err := ws.Read(msg); err != ErrRateLimited {
+		t.Fatalf("Read beyond burst: %v, want ErrRateLimited", err)
+	}
+	if want := []byte{0x88, 0x02, 0x03, 0xf0}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("wrote %x, want close frame %x", out.Bytes(), want)
+	}
+	if _, err := ws.Read(msg); err != ErrRateLimited {
+		t.Errorf("Read after closing: %v, want ErrRateLimited", err)
+	}
+}
+
+func TestReadLimitRateWait(t *testing.T) {
+	var wire []byte
+	for i := 0; i < 3; i++ {
+		wire = append(wire, maskedFrame(TextFrame, true, "hello")...)
+	}
+	const rate = 50
+	ws, out := newLimitedServerConn(t, &ReadLimit{
+		MessagesPerSecond: rate,
+		Action:            RateLimitWait,
+	}, wire)
+
+	start := time.Now()
+	msg := make([]byte, 512)
+	for i := 0; i < 3; i++ {
+		if _, err := ws.Read(msg); err != nil {
+			t.Fatalf("Read %v: %v", i, err)
+		}
+	}
+	if d, want := time.Since(start), 2*time.Second/rate; d < want {
+		t.Errorf("read 3 messages in %v, want at least %v", d, want)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %x, want nothing", out.Bytes())
+	}
+}
+
+func TestReadLimitMessageSize(t *testing.T) {
+	var wire []byte
+	wire = append(wire, maskedFrame(TextFrame, true, "12345678")...)
+	wire = append(wire, maskedFrame(TextFrame, false, "12345")...)
+	wire = append(wire, maskedFrame(PingFrame, true, "")...)
+	wire = append(wire, maskedFrame(ContinuationFrame, true, "6789")...)
+	ws, out := newLimitedServerConn(t, &ReadLimit{MaxMessageBytes: 8}, wire)
+
+	msg := make([]byte, 512)
+	if n, err := ws.Read(msg); err != nil || n != 8 {
+		t.Fatalf("Read of message at limit = %v, %v; want 8, nil", n, err)
+	}
+	if n, err := ws.Read(msg); err != nil || n != 5 {
+		t.Fatalf("Read of first fragment = %v, %v; want 5, nil", n, err)
+	}
+	if _, err := ws.Read(msg); err != ErrMessageTooLarge {
+		t.Fatalf("Read of fragment beyond limit: %v, want ErrMessageTooLarge", err)
+	}
+	// The ping is answered with a pong before the connection is closed.
+	if want := []byte{0x8a, 0x00, 0x88, 0x02, 0x03, 0xf1}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("wrote %x, want pong and close frame %x", out.Bytes(), want)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReconnectingConnClosed is returned by the methods of a
+// ReconnectingConn after it is closed.
+var ErrReconnectingConnClosed = errors.New("websocket: reconnecting connection closed")
+
+// A ReconnectingConn is a client connection to a WebSocket server which
+// dials a new connection, after a backoff, when the current one fails.
+//
+// Read reconnects and continues reading when the connection fails, so
+// a loop reading messages sees a single stream of messages; messages
+// the server sent while disconnected are lost. Write does not retry
+// a failed write, but the next call to a method uses a new connection.
+// OnConnect can restore the state a server keeps for each connection,
+// such as subscriptions, whenever a new connection is made.
+//
+// A ReconnectingConn must not be copied after first use.
+type ReconnectingConn struct {
+	// Config is the configuration connections are dialed with.
+	Config *Config
+
+	// Backoff returns how long to wait before the nth retry of a
+	// failed dial, counting from 1. The first dial after a connection
+	// fails is not delayed. If nil, the wait starts at 100ms and
+	// doubles after each failure, up to 30s.
+	Backoff func(n int) time.Duration
+
+	// MaxAttempts limits the number of consecutive failed dials.
+	// When it is reached, the method waiting for a connection
+	// returns the last dial error, and the next call starts dialing
+	// again. Zero means no limit.
+	MaxAttempts int
+
+	// OnConnect, if non-nil, is called with each new connection before
+	// it is used, such as to resubscribe to the server's events. If it
+	// returns an error, the connection is closed and the dial counts
+	// as failed.
+	OnConnect func(ws *Conn) error
+
+	// OnStateChange, if non-nil, is called when the connection
+	// changes state. It must not call the methods of the
+	// ReconnectingConn.
+	OnStateChange func(ReconnectEvent)
+
+	mu      sync.Mutex
+	ws      *Conn         // current connection, or nil
+	dialing chan struct{} // closed when the current dial loop ends, or nil
+	dialErr error         // the error which ended the last dial loop
+	closed  bool
+	ctx     context.Context // canceled by Close
+	cancel  context.CancelFunc
+}
+
+// A ReconnectState is the state of a ReconnectingConn.
+type ReconnectState int
+
+const (
+	// ReconnectConnecting is a dial in progress.
+	ReconnectConnecting ReconnectState = iota
+
+	// ReconnectConnected is a new connection, after OnConnect
+	// returned successfully.
+	ReconnectConnected
+
+	// ReconnectDisconnected is a failed dial, or a connection which
+	// failed while in use.
+	ReconnectDisconnected
+
+	// ReconnectClosed is a ReconnectingConn closed with Close.
+	ReconnectClosed
+)
+
+func (s ReconnectState) String() string {
+	switch s {
+	case ReconnectConnecting:
+		return "connecting"
+	case ReconnectConnected:
+		return "connected"
+	case ReconnectDisconnected:
+		return "disconnected"
+	case ReconnectClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// A ReconnectEvent is a change in the state of a ReconnectingConn.
+// See ReconnectingConn.OnStateChange.
+type ReconnectEvent struct {
+	State ReconnectState
+
+	// Attempt is the number of the dial, counting from 1, for
+	// ReconnectConnecting events and failed dials.
+	Attempt int
+
+	// Err is why the connection or dial failed, for
+	// ReconnectDisconnected events.
+	Err error
+}
+
+func (rc *ReconnectingConn) initLocked() {
+	if rc.ctx == nil {
+		rc.ctx, rc.cancel = context.WithCancel(context.Background())
+	}
+}
+
+func (rc *ReconnectingConn) notify(ev ReconnectEvent) {
+	if rc.OnStateChange != nil {
+		rc.OnStateChange(ev)
+	}
+}
+
+func (rc *ReconnectingConn) backoff(n int) time.Duration {
+	if rc.Backoff != nil {
+		return rc.Backoff(n)
+	}
+	d := 100 * time.Millisecond
+	for i := 1; i < n && d < 30*time.Second; i++ {
+		d *= 2
+	}
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+// Conn returns the current connection, dialing a new one if there is
+// none. It returns an error if ctx is done first, if the dial fails
+// MaxAttempts times, or if rc is closed. Dials continue after ctx is
+// done for other callers waiting for a connection.
+//
+// A caller which finds the connection has failed, such as by an error
+// from a Codec, should report it with Fail.
+func (rc *ReconnectingConn) Conn(ctx context.Context) (*Conn, error) {
+	rc.mu.Lock()
+	for {
+		if rc.closed {
+			rc.mu.Unlock()
+			return nil, ErrReconnectingConnClosed
+		}
+		if rc.ws != nil {
+			ws := rc.ws
+			rc.mu.Unlock()
+			return ws, nil
+		}
+		if rc.dialing == nil {
+			rc.initLocked()
+			rc.dialing = make(chan struct{})
+			rc.dialErr = nil
+			go rc.dialLoop(rc.dialing)
+		}
+		dialing := rc.dialing
+		rc.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		rc.mu.Lock()
+		if rc.ws == nil && rc.dialErr != nil && !rc.closed {
+			err := rc.dialErr
+			rc.mu.Unlock()
+			return nil, err
+		}
+	}
+}
+
+// dialLoop dials until it makes a connection, rc is closed, or
+// MaxAttempts dials fail. It closes done when it finishes.
+func (rc *ReconnectingConn) dialLoop(done chan struct{}) {
+	rc.mu.Lock()
+	ctx := rc.ctx
+	rc.mu.Unlock()
+	var err error
+	for n := 1; ; n++ {
+		if n > 1 {
+			t := time.NewTimer(rc.backoff(n - 1))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
+		}
+		if ctx.Err() != nil {
+			err = ErrReconnectingConnClosed
+			break
+		}
+		rc.notify(ReconnectEvent{State: ReconnectConnecting, Attempt: n})
+		var ws *Conn
+		ws, err = rc.Config.DialContext(ctx)
+		if err == nil && rc.OnConnect != nil {
+			if err = rc.OnConnect(ws); err != nil {
+				ws.Close()
+			}
+		}
+		if err == nil {
+			rc.mu.Lock()
+			if rc.closed {
+				rc.mu.Unlock()
+				ws.Close()
+				err = ErrReconnectingConnClosed
+				break
+			}
+			rc.ws = ws
+			rc.dialing = nil
+			rc.mu.Unlock()
+			close(done)
+			rc.notify(ReconnectEvent{State: ReconnectConnected, Attempt: n})
+			return
+		}
+		if ctx.Err() != nil {
+			err = ErrReconnectingConnClosed
+			break
+		}
+		rc.notify(ReconnectEvent{State: ReconnectDisconnected, Attempt: n, Err: err})
+		if rc.MaxAttempts > 0 && n >= rc.MaxAttempts {
+			break
+		}
+	}
+	rc.mu.Lock()
+	rc.dialErr = err
+	rc.dialing = nil
+	rc.mu.Unlock()
+	close(done)
+}
+
+// Fail reports that ws, a connection returned by Conn, failed with
+// err. It closes ws, and the next call to a method of rc dials a new
+// connection. Fail does nothing if ws is no longer rc's connection.
+func (rc *ReconnectingConn) Fail(ws *Conn, err error) {
+	rc.mu.Lock()
+	if rc.ws != ws || rc.closed {
+		rc.mu.Unlock()
+		return
+	}
+	rc.ws = nil
+	rc.mu.Unlock()
+	ws.Close()
+	rc.notify(ReconnectEvent{State: ReconnectDisconnected, Err: err})
+}
+
+// Read reads data from the current connection, reconnecting when it
+// fails, until data is read or a new connection cannot be made.
+func (rc *ReconnectingConn) Read(msg []byte) (n int, err error) {
+	for {
+		var ws *Conn
+		if ws, err = rc.Conn(context.Background()); err != nil {
+			return 0, err
+		}
+		n, err = ws.Read(msg)
+		if err != nil {
+			// The connection failed, even if it returned data
+			// first; the next call reads from a new one.
+			rc.Fail(ws, err)
+		}
+		if err == nil || n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Write writes msg as a single frame to the current connection,
+// dialing one if there is none. If the write fails, Write returns
+// the error, and the next call dials a new connection.
+func (rc *ReconnectingConn) Write(msg []byte) (n int, err error) {
+	ws, err := rc.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	n, err = ws.Write(msg)
+	if err != nil {
+		rc.Fail(ws, err)
+	}
+	return n, err
+}
+
+// Close closes the current connection and stops reconnecting. Methods
+// called after Close, and those waiting for a connection, return
+// ErrReconnectingConnClosed.
+func (rc *ReconnectingConn) Close() error {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.closed = true
+	rc.initLocked()
+	rc.cancel()
+	ws := rc.ws
+	rc.ws = nil
+	rc.mu.Unlock()
+	var err error
+	if ws != nil {
+		err = ws.Close()
+	}
+	rc.notify(ReconnectEvent{State: ReconnectClosed})
+	return err
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectingConn(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	// The server answers a subscription with a greeting, and closes
+	// the first connection after it.
+	server := httptest.NewServer(Handler(func(ws *Conn) {
+		mu.Lock()
+		conns++
+		n := conns
+		mu.Unlock()
+		var sub string
+		if err := Message.Receive(ws, &sub); err != nil {
+			return
+		}
+		Message.Send(ws, fmt.Sprintf("%v %v", sub, n))
+		if n > 1 {
+			io.Copy(io.Discard, ws)
+		}
+	}))
+	defer server.Close()
+
+	config, _ := NewConfig("ws://"+server.Listener.Addr().String()+"/", "http://localhost")
+	var events []string
+	rc := &ReconnectingConn{
+		Config:  config,
+		Backoff: func(int) time.Duration { return time.Millisecond },
+		OnConnect: func(ws *Conn) error {
+			return Message.Send(ws, "subscribed")
+		},
+		OnStateChange: func(ev ReconnectEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev.State.String())
+		},
+	}
+
+	msg := make([]byte, 64)
+	for _, want := range []string{"subscribed 1", "subscribed 2"} {
+		n, err := rc.Read(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(msg[:n]); got != want {
+			t.Errorf("Read = %q, want %q", got, want)
+		}
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := rc.Read(msg); err != ErrReconnectingConnClosed {
+		t.Errorf("Read after Close = %v, want ErrReconnectingConnClosed", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"connecting", "connected", "disconnected", "connecting", "connected", "closed"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("state changes = %q, want %q", events, want)
+	}
+}
+
+func TestReconnectingConnMaxAttempts(t *testing.T) {
+	// A listener which is closed refuses connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	config, _ := NewConfig("ws://"+addr+"/", "http://localhost")
+	var backoffs []int
+	attempts := 0
+	rc := &ReconnectingConn{
+		Config:      config,
+		MaxAttempts: 3,
+		Backoff: func(n int) time.Duration {
+			backoffs = append(backoffs, n)
+			return time.Millisecond
+		},
+		OnStateChange: func(ev ReconnectEvent) {
+			if ev.State == ReconnectConnecting {
+				attempts = ev.Attempt
+			}
+		},
+	}
+	defer rc.Close()
+	_, err = rc.Write([]byte("hello"))
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("Write = %v, want DialError", err)
+	}
+	if attempts != 3 {
+		t.Errorf("dialed %v times, want 3", attempts)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(backoffs, want) {
+		t.Errorf("Backoff called with %v, want %v", backoffs, want)
+	}
+}
+
+// failingFrameReader returns its data together with err.
+type failingFrameReader struct {
+	data string
+	err  error
+}
+
+func (r *failingFrameReader) Read(p []byte) (int, error) { return copy(p, r.data), r.err }
+func (r *failingFrameReader) PayloadType() byte          { return TextFrame }
+func (r *failingFrameReader) HeaderReader() io.Reader    { return nil }
+func (r *failingFrameReader) TrailerReader() io.Reader   { return nil }
+func (r *failingFrameReader) Len() int                   { return len(r.data) }
+
+func TestReconnectingConnReadDataAndError(t *testing.T) {
+	server := httptest.NewServer(Handler(func(ws *Conn) {
+		Message.Send(ws, "hello")
+		io.Copy(io.Discard, ws)
+	}))
+	defer server.Close()
+
+	config, _ := NewConfig("ws://"+server.Listener.Addr().String()+"/", "http://localhost")
+	readErr := errors.New("connection broken")
+	var (
+		mu     sync.Mutex
+		conns  int
+		events []ReconnectEvent
+	)
+	rc := &ReconnectingConn{
+		Config:  config,
+		Backoff: func(int) time.Duration { return time.Millisecond },
+		OnConnect: func(ws *Conn) error {
+			mu.Lock()
+			defer mu.Unlock()
+			conns++
+			if conns == 1 {
+				// The first connection fails after returning data.
+				ws.frameReader = &failingFrameReader{data: "partial", err: readErr}
+			}
+			return nil
+		},
+		OnStateChange: func(ev ReconnectEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, ev)
+		},
+	}
+	defer rc.Close()
+
+	msg := make([]byte, 64)
+	for _, want := range []string{"partial", "hello"} {
+		n, err := rc.Read(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(msg[:n]); got != want {
+			t.Errorf("Read = %q, want %q", got, want)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 2 {
+		t.Errorf("connected %v times, want 2", conns)
+	}
+	var failed bool
+	for _, ev := range events {
+		if ev.State == ReconnectDisconnected && ev.Err == readErr {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Errorf("no disconnected event with the read error; events: %v", events)
+	}
+}
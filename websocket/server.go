@@ -61,6 +61,13 @@ type Server struct {
 	// Another example, you can select config.Protocol.
 	Handshake func(*Config, *http.Request) error
 
+	// Policy, if non-nil, limits the connections accepted.
+	// It is checked before Handshake.
+	Policy *Policy
+
+	// ReadLimit, if non-nil, is the ReadLimit of each accepted Conn.
+	ReadLimit *ReadLimit
+
 	// Handler handles a WebSocket connection.
 	Handler
 }
@@ -79,6 +86,17 @@ func (s Server) serveWebSocket(w http.ResponseWriter, req *http.Request) {
 	// the client did not send a handshake that matches with protocol
 	// specification.
 	defer rwc.Close()
+	if s.Policy != nil {
+		release, code, err := s.Policy.admit(req)
+		if err != nil {
+			fmt.Fprintf(buf, "HTTP/1.1 %03d %s\r\n", code, http.StatusText(code))
+			buf.WriteString("\r\n")
+			buf.WriteString(err.Error())
+			buf.Flush()
+			return
+		}
+		defer release()
+	}
 	conn, err := newServerConn(rwc, buf, req, &s.Config, s.Handshake)
 	if err != nil {
 		return
@@ -86,6 +104,7 @@ func (s Server) serveWebSocket(w http.ResponseWriter, req *http.Request) {
 	if conn == nil {
 		panic("unexpected nil conn")
 	}
+	conn.ReadLimit = s.ReadLimit
 	s.Handler(conn)
 }
 
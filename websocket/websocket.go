@@ -180,6 +180,10 @@ type Conn struct {
 	// MaxPayloadBytes limits the size of frame payload received over Conn
 	// by Codec's Receive method. If zero, DefaultMaxPayloadBytes is used.
 	MaxPayloadBytes int
+
+	// ReadLimit, if non-nil, limits the rate and size of the messages
+	// read from Conn. It must be set before the first read.
+	ReadLimit *ReadLimit
 }
 
 // Read implements the io.Reader interface:
@@ -297,6 +301,8 @@ func (ws *Conn) Config() *Config { return ws.config }
 func (ws *Conn) Request() *http.Request { return ws.request }
 
 // Codec represents a symmetric pair of functions that implement a codec.
+// See StreamCodec for a codec which decodes fragmented messages
+// and can use registered Marshalers.
 type Codec struct {
 	Marshal   func(v interface{}) (data []byte, payloadType byte, err error)
 	Unmarshal func(data []byte, payloadType byte, v interface{}) (err error)